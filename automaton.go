@@ -35,6 +35,20 @@ type Automaton interface {
 	Accept(int, byte) int
 }
 
+// DepthHintAutomaton is an optional interface for automata which can give
+// up early on states which can't reach a match within a number of bytes,
+// such as a Levenshtein automaton a few edits away from the end of its
+// term.  When searching an FST built with depth hints (see
+// BuilderOpts.DepthHints), the iterator skips transitions to states with
+// no key long enough for the automaton to match.
+type DepthHintAutomaton interface {
+	Automaton
+
+	// CanMatchWithin returns true if and only if it is possible to reach a
+	// match in at most depth steps
+	CanMatchWithin(state int, depth int) bool
+}
+
 // AutomatonContains implements an generic Contains() method which works
 // on any implementation of Automaton
 func AutomatonContains(a Automaton, k []byte) bool {
@@ -53,6 +67,19 @@ func AutomatonContains(a Automaton, k []byte) bool {
 	return a.IsMatch(curr)
 }
 
+// AutomatonAdvance returns the state reached by the automaton from the
+// state s after the bytes, and whether it can still match, see
+// FST.SearchFrom.
+func AutomatonAdvance(a Automaton, s int, b []byte) (int, bool) {
+	for _, c := range b {
+		if !a.CanMatch(s) {
+			return s, false
+		}
+		s = a.Accept(s, c)
+	}
+	return s, a.CanMatch(s)
+}
+
 // AlwaysMatch is an Automaton implementation which always matches
 type AlwaysMatch struct{}
 
@@ -0,0 +1,208 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package automaton provides combinators building a vellum.Automaton from
+// others, such as one matching the keys which match a regexp and are
+// within an edit distance of a term:
+//
+//	re, err := regexp.New("ba.*")
+//	...
+//	fuzzy, err := levenshtein.New("bar", 1)
+//	...
+//	itr, err := fst.Search(automaton.Intersection(re, fuzzy), nil, nil)
+//
+// FuzzyRegexp is a faster equivalent of this common intersection.
+//
+// The combined automata are safe for concurrent use, if the automata they
+// wrap are.  They number the combinations of states they reach, which are
+// kept while they are in use.
+package automaton
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/couchbase/vellum"
+)
+
+// Intersection returns an automaton matching the keys matched by all of
+// the automata, or every key if there are none.
+func Intersection(auts ...vellum.Automaton) vellum.Automaton {
+	return newProduct(auts, true)
+}
+
+// Union returns an automaton matching the keys matched by any of the
+// automata, or no key if there are none.
+func Union(auts ...vellum.Automaton) vellum.Automaton {
+	return newProduct(auts, false)
+}
+
+// Complement returns an automaton matching the keys not matched by the
+// automaton.  As the complement of a selective automaton matches most keys,
+// searching with it visits most of the FST.
+func Complement(aut vellum.Automaton) vellum.Automaton {
+	return &complement{aut: aut}
+}
+
+// Difference returns an automaton matching the keys matched by a but not
+// by b.
+func Difference(a, b vellum.Automaton) vellum.Automaton {
+	return Intersection(a, Complement(b))
+}
+
+// complement matches exactly the keys not matched by the wrapped
+// automaton, it shares its states.
+type complement struct {
+	aut vellum.Automaton
+}
+
+func (n *complement) Start() int {
+	return n.aut.Start()
+}
+
+func (n *complement) IsMatch(s int) bool {
+	return !n.aut.IsMatch(s)
+}
+
+func (n *complement) CanMatch(s int) bool {
+	return !n.aut.WillAlwaysMatch(s)
+}
+
+func (n *complement) WillAlwaysMatch(s int) bool {
+	return !n.aut.CanMatch(s)
+}
+
+func (n *complement) Accept(s int, b byte) int {
+	return n.aut.Accept(s, b)
+}
+
+// product runs several automata in parallel, matching when all (and) or
+// any (or) of them match.  Each of its states represents a tuple of states
+// of the automata, they are numbered as they are first reached.  State 0 is
+// dead, it is used for any tuple which can no longer match.
+type product struct {
+	auts []vellum.Automaton
+	and  bool
+
+	start  int
+	m      sync.RWMutex
+	tuples [][]int
+	ids    map[string]int
+	keyBuf []byte
+}
+
+func newProduct(auts []vellum.Automaton, and bool) *product {
+	rv := &product{
+		auts:   auts,
+		and:    and,
+		tuples: [][]int{nil},
+		ids:    make(map[string]int),
+	}
+	start := make([]int, len(auts))
+	for i, aut := range auts {
+		start[i] = aut.Start()
+	}
+	rv.start = rv.id(start)
+	return rv
+}
+
+// id returns the state for the tuple, allocating it if necessary
+func (p *product) id(tuple []int) int {
+	if p.dead(tuple) {
+		return 0
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.keyBuf = p.keyBuf[:0]
+	var tmp [binary.MaxVarintLen64]byte
+	for _, s := range tuple {
+		n := binary.PutVarint(tmp[:], int64(s))
+		p.keyBuf = append(p.keyBuf, tmp[:n]...)
+	}
+	if rv, ok := p.ids[string(p.keyBuf)]; ok {
+		return rv
+	}
+	rv := len(p.tuples)
+	p.tuples = append(p.tuples, tuple)
+	p.ids[string(p.keyBuf)] = rv
+	return rv
+}
+
+func (p *product) tuple(s int) []int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if s < 0 || s >= len(p.tuples) {
+		return nil
+	}
+	return p.tuples[s]
+}
+
+func (p *product) dead(tuple []int) bool {
+	for i, aut := range p.auts {
+		canMatch := aut.CanMatch(tuple[i])
+		if p.and && !canMatch {
+			return true
+		}
+		if !p.and && canMatch {
+			return false
+		}
+	}
+	return !p.and
+}
+
+// all reports whether f is true for all (and) or any (or) of the tuple
+func (p *product) all(s int, f func(vellum.Automaton, int) bool) bool {
+	tuple := p.tuple(s)
+	if tuple == nil {
+		return false
+	}
+	for i, aut := range p.auts {
+		rv := f(aut, tuple[i])
+		if p.and && !rv {
+			return false
+		}
+		if !p.and && rv {
+			return true
+		}
+	}
+	return p.and
+}
+
+func (p *product) Start() int {
+	return p.start
+}
+
+func (p *product) IsMatch(s int) bool {
+	return p.all(s, vellum.Automaton.IsMatch)
+}
+
+func (p *product) CanMatch(s int) bool {
+	return p.tuple(s) != nil
+}
+
+func (p *product) WillAlwaysMatch(s int) bool {
+	return p.all(s, vellum.Automaton.WillAlwaysMatch)
+}
+
+func (p *product) Accept(s int, b byte) int {
+	tuple := p.tuple(s)
+	if tuple == nil {
+		return 0
+	}
+	next := make([]int, len(tuple))
+	for i, aut := range p.auts {
+		next[i] = aut.Accept(tuple[i], b)
+	}
+	return p.id(next)
+}
@@ -0,0 +1,146 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automaton
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/automatontest"
+	"github.com/couchbase/vellum/levenshtein"
+	"github.com/couchbase/vellum/regexp"
+)
+
+var testKeys = []string{"", "ba", "bar", "bars", "bat", "baz", "car", "cat",
+	"foo", "fool", "fox", "star"}
+
+func TestCombinators(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := vellum.New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	for i, key := range testKeys {
+		err = b.Insert([]byte(key), uint64(i))
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := vellum.Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	ba, err := regexp.New("ba.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := regexp.New("bar.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fuzzy, err := levenshtein.New("bar", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contains := func(aut vellum.Automaton) func([]byte) bool {
+		return func(key []byte) bool {
+			return vellum.AutomatonContains(aut, key)
+		}
+	}
+	inBa, inBar, inFuzzy := contains(ba), contains(bar), contains(fuzzy)
+
+	tests := []struct {
+		desc  string
+		aut   vellum.Automaton
+		match func([]byte) bool
+	}{
+		{
+			desc: "intersection",
+			aut:  Intersection(ba, fuzzy),
+			match: func(key []byte) bool {
+				return inBa(key) && inFuzzy(key)
+			},
+		},
+		{
+			desc: "union",
+			aut:  Union(bar, fuzzy),
+			match: func(key []byte) bool {
+				return inBar(key) || inFuzzy(key)
+			},
+		},
+		{
+			desc: "complement",
+			aut:  Complement(fuzzy),
+			match: func(key []byte) bool {
+				return !inFuzzy(key)
+			},
+		},
+		{
+			desc: "difference",
+			aut:  Difference(ba, bar),
+			match: func(key []byte) bool {
+				return inBa(key) && !inBar(key)
+			},
+		},
+		{
+			desc:  "empty intersection",
+			aut:   Intersection(),
+			match: func([]byte) bool { return true },
+		},
+		{
+			desc:  "empty union",
+			aut:   Union(),
+			match: func([]byte) bool { return false },
+		},
+	}
+	for _, test := range tests {
+		var want, got []string
+		for _, key := range testKeys {
+			if test.match([]byte(key)) {
+				want = append(want, key)
+			}
+		}
+		itr, err := fst.Search(test.aut, nil, nil)
+		for err == nil {
+			key, _ := itr.Current()
+			got = append(got, string(key))
+			err = itr.Next()
+		}
+		if !errors.Is(err, vellum.ErrIteratorDone) {
+			t.Fatalf("%s: error searching: %v", test.desc, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", test.desc, want, got)
+		}
+
+		err = automatontest.Check(test.aut, &automatontest.Opts{
+			Alphabet: []byte("abrstz"),
+			MaxLen:   6,
+			Seeds:    [][]byte{[]byte("bar"), []byte("bat")},
+			Match:    test.match,
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.desc, err)
+		}
+	}
+}
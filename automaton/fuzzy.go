@@ -0,0 +1,116 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automaton
+
+import (
+	"math/bits"
+	"sync"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/levenshtein"
+	"github.com/couchbase/vellum/regexp"
+)
+
+// fuzzyShift is the number of bits of a fuzzyRegexp state holding the
+// state of the Levenshtein automaton, those above hold the state of the
+// regexp
+const fuzzyShift = bits.UintSize / 2
+
+const fuzzyMask = 1<<fuzzyShift - 1
+
+// FuzzyRegexp returns an automaton matching the keys matched by the regexp
+// which are also within the edit distance of the Levenshtein automaton, as
+// Intersection(re, fuzzy) does, for typo-tolerant pattern searches.
+//
+// Rather than numbering the pairs of states it reaches in a shared table,
+// it packs both states in its own, so stepping it involves no locking,
+// hashing or allocation, and the Levenshtein automaton, which rejects most
+// bytes, is stepped first, the regexp only when it survives.
+func FuzzyRegexp(re *regexp.Regexp, fuzzy *levenshtein.Levenshtein) vellum.Automaton {
+	return &fuzzyRegexp{
+		re:    re,
+		fuzzy: fuzzy,
+	}
+}
+
+// fuzzyRegexp states are the state of the regexp shifted by fuzzyShift,
+// combined with the state of the Levenshtein automaton, neither of which
+// is ever negative.  State 0 is dead.  Should either state not fit, the
+// pair is numbered by a product instead, and represented by the negated
+// product state, which is then stepped for the rest of the key.
+type fuzzyRegexp struct {
+	re    *regexp.Regexp
+	fuzzy *levenshtein.Levenshtein
+
+	once     sync.Once
+	overflow *product
+}
+
+func (f *fuzzyRegexp) pack(re, fuzzy int) int {
+	if !f.re.CanMatch(re) || !f.fuzzy.CanMatch(fuzzy) {
+		return 0
+	}
+	if re > fuzzyMask || fuzzy > fuzzyMask {
+		return -f.product().id([]int{re, fuzzy})
+	}
+	return re<<fuzzyShift | fuzzy
+}
+
+// product returns the product numbering the pairs which don't fit
+func (f *fuzzyRegexp) product() *product {
+	f.once.Do(func() {
+		f.overflow = newProduct([]vellum.Automaton{f.re, f.fuzzy}, true)
+	})
+	return f.overflow
+}
+
+func (f *fuzzyRegexp) Start() int {
+	return f.pack(f.re.Start(), f.fuzzy.Start())
+}
+
+func (f *fuzzyRegexp) IsMatch(s int) bool {
+	if s < 0 {
+		return f.product().IsMatch(-s)
+	}
+	return s != 0 && f.fuzzy.IsMatch(s&fuzzyMask) &&
+		f.re.IsMatch(s>>fuzzyShift)
+}
+
+func (f *fuzzyRegexp) CanMatch(s int) bool {
+	// pack only returns states which can match
+	return s != 0
+}
+
+func (f *fuzzyRegexp) WillAlwaysMatch(s int) bool {
+	if s < 0 {
+		return f.product().WillAlwaysMatch(-s)
+	}
+	return s != 0 && f.fuzzy.WillAlwaysMatch(s&fuzzyMask) &&
+		f.re.WillAlwaysMatch(s>>fuzzyShift)
+}
+
+func (f *fuzzyRegexp) Accept(s int, b byte) int {
+	if s < 0 {
+		return -f.product().Accept(-s, b)
+	}
+	if s == 0 {
+		return 0
+	}
+	fuzzy := f.fuzzy.Accept(s&fuzzyMask, b)
+	if !f.fuzzy.CanMatch(fuzzy) {
+		return 0
+	}
+	return f.pack(f.re.Accept(s>>fuzzyShift, b), fuzzy)
+}
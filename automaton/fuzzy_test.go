@@ -0,0 +1,165 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automaton
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/automatontest"
+	"github.com/couchbase/vellum/levenshtein"
+	"github.com/couchbase/vellum/regexp"
+)
+
+// randomWordsFST builds an FST of n random lowercase words
+func randomWordsFST(tb testing.TB, n int) *vellum.FST {
+	rng := rand.New(rand.NewSource(1))
+	words := make(map[string]struct{}, n)
+	for len(words) < n {
+		word := make([]byte, 3+rng.Intn(8))
+		for i := range word {
+			word[i] = byte('a' + rng.Intn(26))
+		}
+		words[string(word)] = struct{}{}
+	}
+	sorted := make([]string, 0, n)
+	for word := range words {
+		sorted = append(sorted, word)
+	}
+	sort.Strings(sorted)
+
+	var buf bytes.Buffer
+	b, err := vellum.New(&buf, nil)
+	if err != nil {
+		tb.Fatalf("error creating builder: %v", err)
+	}
+	for i, word := range sorted {
+		err = b.Insert([]byte(word), uint64(i))
+		if err != nil {
+			tb.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		tb.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := vellum.Load(buf.Bytes())
+	if err != nil {
+		tb.Fatalf("error loading: %v", err)
+	}
+	return fst
+}
+
+func searchKeys(tb testing.TB, fst *vellum.FST, aut vellum.Automaton) []string {
+	var rv []string
+	itr, err := fst.Search(aut, nil, nil)
+	for err == nil {
+		key, _ := itr.Current()
+		rv = append(rv, string(key))
+		err = itr.Next()
+	}
+	if !errors.Is(err, vellum.ErrIteratorDone) {
+		tb.Fatalf("error searching: %v", err)
+	}
+	return rv
+}
+
+// accepts runs the automaton over the key, unlike vellum.AutomatonContains
+// it doesn't treat state 1 as dead, which the regexp start state can be
+func accepts(aut vellum.Automaton, key []byte) bool {
+	s := aut.Start()
+	for _, b := range key {
+		if !aut.CanMatch(s) {
+			return false
+		}
+		s = aut.Accept(s, b)
+	}
+	return aut.IsMatch(s)
+}
+
+func TestFuzzyRegexp(t *testing.T) {
+	fst := randomWordsFST(t, 20000)
+	tests := []struct {
+		re    string
+		query string
+		dist  int
+	}{
+		{"ba.*", "bar", 1},
+		{"[a-m].*s", "things", 2},
+		{".*", "marty", 1},
+		{"x+", "abc", 1},
+	}
+	for _, test := range tests {
+		re, err := regexp.New(test.re)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fuzzy, err := levenshtein.New(test.query, test.dist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := searchKeys(t, fst, Intersection(re, fuzzy))
+		got := searchKeys(t, fst, FuzzyRegexp(re, fuzzy))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s %s~%d: expected %v, got %v", test.re, test.query,
+				test.dist, want, got)
+		}
+
+		err = automatontest.Check(FuzzyRegexp(re, fuzzy), &automatontest.Opts{
+			Alphabet: []byte("abrstx"),
+			MaxLen:   6,
+			Seeds:    [][]byte{[]byte(test.query)},
+			Match: func(key []byte) bool {
+				return accepts(re, key) && accepts(fuzzy, key)
+			},
+		})
+		if err != nil {
+			t.Errorf("%s %s~%d: %v", test.re, test.query, test.dist, err)
+		}
+	}
+}
+
+func benchmarkFuzzyRegexp(b *testing.B, combine func(*regexp.Regexp,
+	*levenshtein.Levenshtein) vellum.Automaton) {
+	fst := randomWordsFST(b, 100000)
+	re, err := regexp.New("[a-m].*e.*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	fuzzy, err := levenshtein.New("example", 2)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searchKeys(b, fst, combine(re, fuzzy))
+	}
+}
+
+func BenchmarkFuzzyRegexpFused(b *testing.B) {
+	benchmarkFuzzyRegexp(b, FuzzyRegexp)
+}
+
+func BenchmarkFuzzyRegexpIntersection(b *testing.B) {
+	benchmarkFuzzyRegexp(b, func(re *regexp.Regexp,
+		fuzzy *levenshtein.Levenshtein) vellum.Automaton {
+		return Intersection(re, fuzzy)
+	})
+}
@@ -0,0 +1,89 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automaton
+
+import (
+	"errors"
+
+	"github.com/couchbase/vellum"
+)
+
+// ErrTooManyStrings is returned by Language when the automaton accepts
+// more strings than the limit.
+var ErrTooManyStrings = errors.New("automaton accepts too many strings")
+
+// errStop stops VisitLanguage from Language, once the limit is reached
+var errStop = errors.New("stop")
+
+// VisitLanguage invokes fn with each byte string of at most maxLen bytes
+// accepted by the automaton, in lexicographic order, such as to expand a
+// small pattern into the keys to probe.  The string is only valid for the
+// duration of the call.  If fn returns an error, the enumeration stops,
+// and VisitLanguage returns it.
+//
+// Every prefix the automaton reports it can match is followed, with each
+// of the 256 bytes, so automata which can match many strings longer than
+// maxLen, without accepting them, take time exponential in maxLen.
+func VisitLanguage(aut vellum.Automaton, maxLen int,
+	fn func([]byte) error) error {
+	s := aut.Start()
+	if !aut.CanMatch(s) {
+		return nil
+	}
+	return visitLanguage(aut, s, make([]byte, 0, maxLen), maxLen, fn)
+}
+
+func visitLanguage(aut vellum.Automaton, s int, prefix []byte, maxLen int,
+	fn func([]byte) error) error {
+	if aut.IsMatch(s) {
+		err := fn(prefix)
+		if err != nil {
+			return err
+		}
+	}
+	if len(prefix) >= maxLen {
+		return nil
+	}
+	for b := 0; b < 256; b++ {
+		next := aut.Accept(s, byte(b))
+		if !aut.CanMatch(next) {
+			continue
+		}
+		err := visitLanguage(aut, next, append(prefix, byte(b)), maxLen, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Language returns the byte strings of at most maxLen bytes accepted by
+// the automaton, in lexicographic order, as VisitLanguage enumerates them.
+// If there are more than limit of them, the first limit are returned, with
+// ErrTooManyStrings.
+func Language(aut vellum.Automaton, maxLen, limit int) ([][]byte, error) {
+	var rv [][]byte
+	err := VisitLanguage(aut, maxLen, func(s []byte) error {
+		if len(rv) == limit {
+			return errStop
+		}
+		rv = append(rv, append([]byte(nil), s...))
+		return nil
+	})
+	if err == errStop {
+		return rv, ErrTooManyStrings
+	}
+	return rv, err
+}
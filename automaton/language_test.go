@@ -0,0 +1,91 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automaton
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/levenshtein"
+	"github.com/couchbase/vellum/regexp"
+)
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		expr   string
+		maxLen int
+		limit  int
+		want   string
+		err    error
+	}{
+		{`a[bc]d?`, 10, 10, `["ab" "abd" "ac" "acd"]`, nil},
+		{`a[bc]d?`, 2, 10, `["ab" "ac"]`, nil},
+		{`a[bc]d?`, 10, 3, `["ab" "abd" "ac"]`, ErrTooManyStrings},
+		{`a[bc]d?`, 10, 4, `["ab" "abd" "ac" "acd"]`, nil},
+		{`x*`, 3, 10, `["" "x" "xx" "xxx"]`, nil},
+		{`(ab)*`, 5, 10, `["" "ab" "abab"]`, nil},
+		{`a{10}`, 5, 10, `[]`, nil},
+		{`[a-c]{2}`, 2, 100, `["aa" "ab" "ac" "ba" "bb" "bc" "ca" "cb" "cc"]`, nil},
+	}
+	for _, test := range tests {
+		r, err := regexp.New(test.expr)
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", test.expr, err)
+		}
+		got, err := Language(r, test.maxLen, test.limit)
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.expr, test.err, err)
+		}
+		if fmt.Sprintf("%q", got) != test.want {
+			t.Errorf("%s: expected %s, got %q", test.expr, test.want, got)
+		}
+	}
+}
+
+func TestLanguageVisitor(t *testing.T) {
+	lev, err := levenshtein.New("ab", 1)
+	if err != nil {
+		t.Fatalf("error creating levenshtein: %v", err)
+	}
+	errFound := errors.New("found")
+	err = VisitLanguage(lev, 3, func(s []byte) error {
+		if string(s) == "abc" {
+			return errFound
+		}
+		return nil
+	})
+	if err != errFound {
+		t.Errorf("expected the error of the visitor, got %v", err)
+	}
+	// every accepted string is within the distance of the term, and
+	// the automaton is checked to agree on them
+	got, err := Language(lev, 3, 1<<20)
+	if err != nil {
+		t.Fatalf("error enumerating: %v", err)
+	}
+	for _, s := range got {
+		if len(s) < 1 || len(s) > 3 || !vellum.AutomatonContains(lev, s) {
+			t.Errorf("unexpected %q", s)
+		}
+	}
+
+	// the language of an automaton matching nothing is empty
+	got, err = Language(Complement(&vellum.AlwaysMatch{}), 3, 10)
+	if err != nil || len(got) != 0 {
+		t.Errorf("expected nothing, got %q %v", got, err)
+	}
+}
@@ -0,0 +1,246 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package automatontest implements support for testing implementations of
+// the vellum.Automaton interface.
+//
+// Subtle automaton bugs usually manifest only as mysteriously missing
+// search results, because the FST search trusts the automaton to report
+// when no match is possible, and prunes the rest of the search.  Check
+// validates the contract against randomized inputs, and can be used from
+// the tests of any Automaton implementation:
+//
+//	func TestMyAutomaton(t *testing.T) {
+//		err := automatontest.Check(newMyAutomaton("foo*"), &automatontest.Opts{
+//			Seeds: [][]byte{[]byte("fo"), []byte("fooo")},
+//		})
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//	}
+package automatontest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/couchbase/vellum"
+)
+
+// Opts customizes the inputs used by Check.
+type Opts struct {
+	// Seed for the random number generator, so failures are
+	// reproducible.
+	Seed int64
+
+	// Inputs is the number of random inputs generated, if zero 1000.
+	Inputs int
+
+	// MaxLen is the maximum length of random inputs, if zero 16.
+	MaxLen int
+
+	// Alphabet is the set of bytes random inputs are built from, if
+	// empty all bytes are used.  Restricting it to the bytes which are
+	// significant for the automaton explores more of its states.
+	Alphabet []byte
+
+	// Seeds are inputs which are interesting for the automaton, such as
+	// ones which match.  They are checked, and random variations of them
+	// (prefixes, extensions and edits) are added to the inputs.
+	Seeds [][]byte
+
+	// Match, if set, is the reference implementation of the language of
+	// the automaton, reporting whether an input should match.
+	Match func(input []byte) bool
+}
+
+// maxViolations is the number of violations reported by Check.
+const maxViolations = 10
+
+// Violation describes a breach of the Automaton contract.
+type Violation struct {
+	// Input is the input which led to the offending state.
+	Input []byte
+	// Rule is the part of the contract breached.
+	Rule string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("input %q: %s", v.Input, v.Rule)
+}
+
+// Error is returned by Check, listing the violations detected (at most
+// 10 of them).
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		lines[i] = v.String()
+	}
+	return "automaton contract violated:\n" + strings.Join(lines, "\n")
+}
+
+// Check runs the automaton over randomized inputs, verifying that:
+//   - Start and Accept are deterministic, and IsMatch, CanMatch and
+//     WillAlwaysMatch are stable for a given state
+//   - a state which IsMatch or WillAlwaysMatch also CanMatch
+//   - a state which WillAlwaysMatch also IsMatch, and all states reachable
+//     from it WillAlwaysMatch
+//   - no state reachable from a state which can't match IsMatch or
+//     CanMatch
+//   - IsMatch agrees with Opts.Match, if provided
+//
+// It returns nil if no violations were found, otherwise an *Error.
+func Check(aut vellum.Automaton, opts *Opts) error {
+	if opts == nil {
+		opts = &Opts{}
+	}
+	c := &checker{
+		aut:  aut,
+		opts: opts,
+		r:    rand.New(rand.NewSource(opts.Seed)),
+	}
+	c.alphabet = opts.Alphabet
+	if len(c.alphabet) == 0 {
+		c.alphabet = make([]byte, 256)
+		for i := range c.alphabet {
+			c.alphabet[i] = byte(i)
+		}
+	}
+	maxLen := opts.MaxLen
+	if maxLen <= 0 {
+		maxLen = 16
+	}
+	n := opts.Inputs
+	if n <= 0 {
+		n = 1000
+	}
+
+	if aut.Start() != aut.Start() {
+		c.violation(nil, "Start is not deterministic")
+	}
+	c.check(nil)
+	for _, seed := range opts.Seeds {
+		c.check(seed)
+	}
+	for i := 0; i < n && len(c.violations) < maxViolations; i++ {
+		c.check(c.input(maxLen))
+	}
+
+	if len(c.violations) > 0 {
+		return &Error{Violations: c.violations}
+	}
+	return nil
+}
+
+type checker struct {
+	aut      vellum.Automaton
+	opts     *Opts
+	r        *rand.Rand
+	alphabet []byte
+
+	violations []Violation
+}
+
+func (c *checker) violation(input []byte, format string, args ...interface{}) {
+	if len(c.violations) < maxViolations {
+		c.violations = append(c.violations, Violation{
+			Input: append([]byte(nil), input...),
+			Rule:  fmt.Sprintf(format, args...),
+		})
+	}
+}
+
+// input returns a random input, either a variation of one of the seeds,
+// or random bytes from the alphabet.
+func (c *checker) input(maxLen int) []byte {
+	var rv []byte
+	if len(c.opts.Seeds) > 0 && c.r.Intn(2) == 0 {
+		seed := c.opts.Seeds[c.r.Intn(len(c.opts.Seeds))]
+		rv = append(rv, seed...)
+		switch c.r.Intn(3) {
+		case 0: // prefix
+			return rv[:c.r.Intn(len(rv)+1)]
+		case 1: // edit
+			if len(rv) > 0 {
+				rv[c.r.Intn(len(rv))] = c.alphabet[c.r.Intn(len(c.alphabet))]
+			}
+			return rv
+		}
+		// otherwise extend
+	}
+	for n := c.r.Intn(maxLen + 1); len(rv) < n; {
+		rv = append(rv, c.alphabet[c.r.Intn(len(c.alphabet))])
+	}
+	return rv
+}
+
+// check runs the input, checking each state visited.
+func (c *checker) check(input []byte) {
+	s := c.aut.Start()
+	deadAt := -1
+	alwaysAt := -1
+	for i := 0; ; i++ {
+		isMatch := c.aut.IsMatch(s)
+		canMatch := c.aut.CanMatch(s)
+		willAlwaysMatch := c.aut.WillAlwaysMatch(s)
+		if isMatch != c.aut.IsMatch(s) ||
+			canMatch != c.aut.CanMatch(s) ||
+			willAlwaysMatch != c.aut.WillAlwaysMatch(s) {
+			c.violation(input[:i], "IsMatch/CanMatch/WillAlwaysMatch not stable for state %d", s)
+		}
+		if isMatch && !canMatch {
+			c.violation(input[:i], "state %d IsMatch but not CanMatch", s)
+		}
+		if willAlwaysMatch && !isMatch {
+			c.violation(input[:i], "state %d WillAlwaysMatch but not IsMatch", s)
+		}
+		if willAlwaysMatch && !canMatch {
+			c.violation(input[:i], "state %d WillAlwaysMatch but not CanMatch", s)
+		}
+		if alwaysAt >= 0 && !willAlwaysMatch {
+			c.violation(input[:i], "state %d reached after WillAlwaysMatch at %q no longer WillAlwaysMatch",
+				s, input[:alwaysAt])
+		}
+		if deadAt >= 0 && (isMatch || canMatch) {
+			c.violation(input[:i], "state %d reached after not CanMatch at %q can match",
+				s, input[:deadAt])
+		}
+		if c.opts.Match != nil && deadAt < 0 && isMatch != c.opts.Match(input[:i]) {
+			c.violation(input[:i], "IsMatch %t for state %d, expected %t", isMatch, s, !isMatch)
+		}
+		if c.opts.Match != nil && deadAt >= 0 && c.opts.Match(input[:i]) {
+			c.violation(input[:i], "expected a match, but search was pruned after not CanMatch at %q",
+				input[:deadAt])
+		}
+		if willAlwaysMatch && alwaysAt < 0 {
+			alwaysAt = i
+		}
+		if !canMatch && deadAt < 0 {
+			deadAt = i
+		}
+		if i == len(input) {
+			return
+		}
+		next := c.aut.Accept(s, input[i])
+		if next != c.aut.Accept(s, input[i]) {
+			c.violation(input[:i+1], "Accept from state %d is not deterministic", s)
+		}
+		s = next
+	}
+}
@@ -0,0 +1,128 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automatontest
+
+import (
+	"errors"
+	stdregexp "regexp"
+	"testing"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/levenshtein2"
+	"github.com/couchbase/vellum/query"
+	"github.com/couchbase/vellum/regexp"
+)
+
+func TestConforming(t *testing.T) {
+	re, err := regexp.New("fo+(ba[rz])?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdre := stdregexp.MustCompile("^(?:fo+(ba[rz])?)$")
+	lb, err := levenshtein2.NewLevenshteinAutomatonBuilder(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lev, err := lb.BuildDfa("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := query.Prefix("fo").Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc string
+		aut  vellum.Automaton
+		opts *Opts
+	}{
+		{
+			desc: "always match",
+			aut:  &vellum.AlwaysMatch{},
+		},
+		{
+			desc: "regexp",
+			aut:  re,
+			opts: &Opts{
+				Alphabet: []byte("fobarz"),
+				Seeds:    [][]byte{[]byte("foo"), []byte("foobaz")},
+				Match:    stdre.Match,
+			},
+		},
+		{
+			desc: "levenshtein",
+			aut:  lev,
+			opts: &Opts{
+				Alphabet: []byte("fox"),
+				Seeds:    [][]byte{[]byte("foo")},
+			},
+		},
+		{
+			desc: "prefix",
+			aut:  prefix,
+			opts: &Opts{
+				Alphabet: []byte("fox"),
+				Seeds:    [][]byte{[]byte("fo")},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := Check(test.aut, test.opts)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// prunesEarly is a broken automaton for the language "ab", it claims
+// nothing can match after "a".
+type prunesEarly struct{}
+
+func (p prunesEarly) Start() int                 { return 1 }
+func (p prunesEarly) IsMatch(s int) bool         { return s == 3 }
+func (p prunesEarly) CanMatch(s int) bool        { return s == 1 || s == 3 }
+func (p prunesEarly) WillAlwaysMatch(s int) bool { return false }
+func (p prunesEarly) Accept(s int, b byte) int {
+	if s == 1 && b == 'a' {
+		return 2
+	}
+	if s == 2 && b == 'b' {
+		return 3
+	}
+	return 0
+}
+
+func TestViolations(t *testing.T) {
+	err := Check(prunesEarly{}, &Opts{
+		Alphabet: []byte("ab"),
+		Seeds:    [][]byte{[]byte("ab")},
+	})
+	var cerr *Error
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	found := false
+	for _, v := range cerr.Violations {
+		if string(v.Input) == "ab" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected violation for input ab, got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// BestFirstIterator visits the keys matching an automaton in descending
+// order of their output values, rather than in lexicographic order.  Keys
+// with equal values are visited in lexicographic order.
+//
+// It maintains a max-heap of partially explored paths, ordered by an upper
+// bound of the output of any key reachable along them: the output
+// accumulated so far plus the largest output reachable from the state.
+// If the FST was built with value bounds (see BuilderOpts.ValueBounds),
+// these are read from them, so only the states along the paths expanded
+// are visited, and consumers interested in only the top few keys avoid
+// visiting the rest of the FST.  Otherwise they are computed from the
+// states reachable from the root when the iterator is created, which
+// visits every state of the FST once.
+type BestFirstIterator struct {
+	f   *FST
+	aut Automaton
+
+	startKeyInclusive []byte
+	endKeyExclusive   []byte
+
+	queue  bestFirstQueue
+	maxOut map[int]uint64
+
+	currKey []byte
+	currVal uint64
+}
+
+// BestFirst returns a BestFirstIterator over the keys matching the
+// automaton, with startKeyInclusive <= key < endKeyExclusive.  A nil
+// automaton matches all keys, and nil bounds are unbounded.  As with
+// NewMergeIterator, ErrIteratorDone is returned if there are no such keys.
+// ErrInternedValues is returned if the FST was built with interned values.
+func (f *FST) BestFirst(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) (*BestFirstIterator, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	if f.values != nil {
+		return nil, ErrInternedValues
+	}
+	if aut == nil {
+		aut = alwaysMatchAutomaton
+	}
+	rv := &BestFirstIterator{
+		f:                 f,
+		aut:               aut,
+		startKeyInclusive: startKeyInclusive,
+		endKeyExclusive:   endKeyExclusive,
+	}
+	if f.bounds == nil {
+		rv.maxOut = make(map[int]uint64)
+	}
+	err := rv.push(nil, f.decoder.getRoot(), aut.Start(), 0)
+	if err != nil {
+		return nil, err
+	}
+	err = rv.Next()
+	if err != nil {
+		return rv, err
+	}
+	return rv, nil
+}
+
+// Current returns the key and value currently pointed to by the iterator.
+func (i *BestFirstIterator) Current() ([]byte, uint64) {
+	return i.currKey, i.currVal
+}
+
+// Next advances the iterator to the key with the next largest value,
+// ErrIteratorDone is returned when there are no more keys.
+func (i *BestFirstIterator) Next() error {
+	if err := i.f.enter(); err != nil {
+		return err
+	}
+	defer i.f.exit()
+	for len(i.queue) > 0 {
+		item := heap.Pop(&i.queue).(*bestFirstItem)
+		if item.final {
+			i.currKey, i.currVal = item.key, item.bound
+			return nil
+		}
+		err := i.expand(item)
+		if err != nil {
+			return err
+		}
+	}
+	i.currKey, i.currVal = nil, 0
+	return ErrIteratorDone
+}
+
+// Close will free any resources held by this iterator.
+func (i *BestFirstIterator) Close() error {
+	i.queue = nil
+	i.maxOut = nil
+	return nil
+}
+
+func (i *BestFirstIterator) expand(item *bestFirstItem) error {
+	curr, err := i.f.decoder.stateAt(item.addr, nil)
+	if err != nil {
+		return err
+	}
+	if curr.Final() && i.aut.IsMatch(item.autState) &&
+		bytes.Compare(item.key, i.startKeyInclusive) >= 0 &&
+		(i.endKeyExclusive == nil ||
+			bytes.Compare(item.key, i.endKeyExclusive) < 0) {
+		heap.Push(&i.queue, &bestFirstItem{
+			key:   item.key,
+			bound: item.out + curr.FinalOutput(),
+			final: true,
+		})
+	}
+	for j := 0; j < curr.NumTransitions(); j++ {
+		t := curr.TransitionAt(j)
+		autNext := i.aut.Accept(item.autState, t)
+		if !i.aut.CanMatch(autNext) {
+			continue
+		}
+		key := make([]byte, len(item.key)+1)
+		copy(key, item.key)
+		key[len(item.key)] = t
+		if !i.inRange(key) {
+			continue
+		}
+		_, nextAddr, nextOut := curr.TransitionFor(t)
+		err = i.push(key, nextAddr, autNext, item.out+nextOut)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inRange reports whether some key starting with the prefix may be within
+// the bounds
+func (i *BestFirstIterator) inRange(prefix []byte) bool {
+	if i.endKeyExclusive != nil &&
+		bytes.Compare(prefix, i.endKeyExclusive) >= 0 {
+		return false
+	}
+	if bytes.Compare(prefix, i.startKeyInclusive) < 0 &&
+		!bytes.HasPrefix(i.startKeyInclusive, prefix) {
+		return false
+	}
+	return true
+}
+
+func (i *BestFirstIterator) push(key []byte, addr, autState int,
+	out uint64) error {
+	max, err := i.maxOutput(addr)
+	if err != nil {
+		return err
+	}
+	heap.Push(&i.queue, &bestFirstItem{
+		key:      key,
+		addr:     addr,
+		autState: autState,
+		out:      out,
+		bound:    out + max,
+	})
+	return nil
+}
+
+// maxOutput returns the largest output of any key reachable from the state
+// at addr, relative to that state, from the value bounds if there are, or
+// computed from the states reachable from it otherwise
+func (i *BestFirstIterator) maxOutput(addr int) (uint64, error) {
+	if i.f.bounds != nil {
+		if addr == noneAddr {
+			return 0, nil
+		}
+		_, max, ok := i.f.bounds.get(addr)
+		if !ok {
+			return 0, corruptf(addr, "missing value bounds")
+		}
+		return max, nil
+	}
+	if rv, ok := i.maxOut[addr]; ok {
+		return rv, nil
+	}
+	curr, err := i.f.decoder.stateAt(addr, nil)
+	if err != nil {
+		return 0, err
+	}
+	var rv uint64
+	if curr.Final() {
+		rv = curr.FinalOutput()
+	}
+	for j := 0; j < curr.NumTransitions(); j++ {
+		_, nextAddr, nextOut := curr.TransitionFor(curr.TransitionAt(j))
+		max, err := i.maxOutput(nextAddr)
+		if err != nil {
+			return 0, err
+		}
+		if nextOut+max > rv {
+			rv = nextOut + max
+		}
+	}
+	i.maxOut[addr] = rv
+	return rv, nil
+}
+
+type bestFirstItem struct {
+	key      []byte
+	addr     int
+	autState int
+	out      uint64
+	bound    uint64
+	// final items are keys ready to be returned, with bound their value
+	final bool
+}
+
+type bestFirstQueue []*bestFirstItem
+
+func (q bestFirstQueue) Len() int { return len(q) }
+
+func (q bestFirstQueue) Less(i, j int) bool {
+	if q[i].bound != q[j].bound {
+		return q[i].bound > q[j].bound
+	}
+	// keys before the paths which might extend them
+	if c := bytes.Compare(q[i].key, q[j].key); c != 0 {
+		return c < 0
+	}
+	return q[i].final && !q[j].final
+}
+
+func (q bestFirstQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *bestFirstQueue) Push(x interface{}) {
+	*q = append(*q, x.(*bestFirstItem))
+}
+
+func (q *bestFirstQueue) Pop() interface{} {
+	old := *q
+	rv := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return rv
+}
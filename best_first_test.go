@@ -0,0 +1,123 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/couchbase/vellum/regexp"
+)
+
+func TestBestFirst(t *testing.T) {
+	vals := randomValues(thousandTestWords)
+	for _, opts := range [][]BuilderOption{nil, {WithValueBounds()}} {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, thousandTestWords, vals)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		fst, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		testBestFirst(t, fst)
+	}
+}
+
+func testBestFirst(t *testing.T, fst *FST) {
+	r, err := regexp.New(`[a-m].*s`)
+	if err != nil {
+		t.Fatalf("error compiling regexp: %v", err)
+	}
+
+	type kv struct {
+		key string
+		val uint64
+	}
+	tests := []struct {
+		name       string
+		aut        Automaton
+		start, end []byte
+	}{
+		{name: "all"},
+		{name: "range", start: []byte("b"), end: []byte("k")},
+		{name: "regexp", aut: r},
+		{name: "regexp range", aut: r, start: []byte("c"), end: []byte("h")},
+	}
+	for _, test := range tests {
+		var want []kv
+		itr, err := fst.Search(test.aut, test.start, test.end)
+		for err == nil {
+			key, val := itr.Current()
+			want = append(want, kv{string(key), val})
+			err = itr.Next()
+		}
+		if err != ErrIteratorDone {
+			t.Fatalf("%s: error iterating: %v", test.name, err)
+		}
+		sort.SliceStable(want, func(i, j int) bool {
+			return want[i].val > want[j].val
+		})
+
+		var got []kv
+		bitr, err := fst.BestFirst(test.aut, test.start, test.end)
+		if fst.bounds != nil && bitr != nil && bitr.maxOut != nil {
+			t.Errorf("%s: expected the value bounds to be used", test.name)
+		}
+		for err == nil {
+			key, val := bitr.Current()
+			got = append(got, kv{string(key), val})
+			err = bitr.Next()
+		}
+		if err != ErrIteratorDone {
+			t.Fatalf("%s: error iterating best first: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %d keys by output, got %d: %v",
+				test.name, len(want), len(got), got)
+		}
+	}
+}
+
+func TestBestFirstEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	_, err = fst.BestFirst(nil, nil, nil)
+	if err != ErrIteratorDone {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+}
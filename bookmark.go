@@ -0,0 +1,164 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+)
+
+// ErrInvalidBookmark is returned by Resume for a bookmark which can't be
+// decoded.
+var ErrInvalidBookmark = errors.New("invalid iterator bookmark")
+
+// ErrBookmarkMismatch is returned by Resume for a bookmark taken on an FST
+// with different content.
+var ErrBookmarkMismatch = errors.New("iterator bookmark is for a different fst")
+
+const bookmarkVersion = 1
+
+// ContentHash returns a hash of the data of the FST, identifying its
+// content across processes.  It is computed the first time it is needed,
+// which reads all of the data, and fails if any of it can't be read, such
+// as the missing ranges of an FST opened WithPartialData.
+func (f *FST) ContentHash() (uint64, error) {
+	if err := f.enter(); err != nil {
+		return 0, err
+	}
+	defer f.exit()
+	f.hashMu.Lock()
+	defer f.hashMu.Unlock()
+	if !f.hashed {
+		data, err := f.readAll()
+		if err != nil {
+			return 0, err
+		}
+		f.hash = crc64.Checksum(data, crc64.MakeTable(crc64.ECMA))
+		f.hashed = true
+	}
+	return f.hash, nil
+}
+
+// Bookmark returns a compact token recording the position of the iterator,
+// which must be at a key, so that iteration can be resumed after that key
+// with Resume, even in another process.  The token records the key, the end
+// bound and the hash of the FST, but not the automaton, which is described
+// by filter instead, in whatever form Resume's compile function accepts,
+// such as the source of a regular expression.  filter should be empty when
+// iterating without an automaton.
+func (i *FSTIterator) Bookmark(filter string) ([]byte, error) {
+	if err := i.f.enter(); err != nil {
+		return nil, err
+	}
+	defer i.f.exit()
+	key, _, ok := i.current()
+	if !ok {
+		return nil, ErrIteratorDone
+	}
+	contentHash, err := i.f.ContentHash()
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]byte, 0, 2+8+3*binary.MaxVarintLen64+len(key)+
+		len(i.endKeyExclusive)+len(filter))
+	rv = append(rv, bookmarkVersion)
+	var hash [8]byte
+	binary.LittleEndian.PutUint64(hash[:], contentHash)
+	rv = append(rv, hash[:]...)
+	rv = appendBookmarkBytes(rv, key)
+	if i.endKeyExclusive != nil {
+		rv = append(rv, 1)
+		rv = appendBookmarkBytes(rv, i.endKeyExclusive)
+	} else {
+		rv = append(rv, 0)
+	}
+	rv = appendBookmarkBytes(rv, []byte(filter))
+	return rv, nil
+}
+
+// Resume returns an iterator positioned at the first key after the one
+// recorded by the bookmark, with the same end bound, which must have been
+// taken on an FST with the same content, or ErrBookmarkMismatch is
+// returned.  If the bookmark has a filter, compile is called to build the
+// automaton for it.  As for Search, ErrIteratorDone is returned if there
+// are no more keys.
+func (f *FST) Resume(bookmark []byte,
+	compile func(filter string) (Automaton, error)) (*FSTIterator, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	if len(bookmark) < 9 || bookmark[0] != bookmarkVersion {
+		return nil, ErrInvalidBookmark
+	}
+	contentHash, err := f.ContentHash()
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(bookmark[1:]) != contentHash {
+		return nil, ErrBookmarkMismatch
+	}
+	buf := bookmark[9:]
+	key, buf, ok := readBookmarkBytes(buf)
+	if !ok || len(buf) < 1 {
+		return nil, ErrInvalidBookmark
+	}
+	var endKeyExclusive []byte
+	hasEnd := buf[0]
+	buf = buf[1:]
+	if hasEnd == 1 {
+		endKeyExclusive, buf, ok = readBookmarkBytes(buf)
+		if !ok {
+			return nil, ErrInvalidBookmark
+		}
+	} else if hasEnd != 0 {
+		return nil, ErrInvalidBookmark
+	}
+	filter, buf, ok := readBookmarkBytes(buf)
+	if !ok || len(buf) != 0 {
+		return nil, ErrInvalidBookmark
+	}
+
+	var aut Automaton
+	if len(filter) > 0 {
+		if compile == nil {
+			return nil, errors.New("bookmark has a filter, but no compile function")
+		}
+		aut, err = compile(string(filter))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the smallest key after the bookmarked one
+	startKeyInclusive := KeySuccessor(key)
+	return f.Search(aut, startKeyInclusive, endKeyExclusive)
+}
+
+func appendBookmarkBytes(dst, b []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	dst = append(dst, lenBuf[:n]...)
+	return append(dst, b...)
+}
+
+func readBookmarkBytes(buf []byte) ([]byte, []byte, bool) {
+	l, n := binary.Uvarint(buf)
+	if n <= 0 || l > uint64(len(buf)-n) {
+		return nil, nil, false
+	}
+	return buf[n : n+int(l)], buf[n+int(l):], true
+}
@@ -0,0 +1,116 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum/regexp"
+)
+
+func TestBookmark(t *testing.T) {
+	data := buildWordsSample(t)
+	fst, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compile := func(filter string) (Automaton, error) {
+		return regexp.New(filter)
+	}
+	aut, err := compile("s.*e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := []byte("t")
+
+	itr, err := fst.Search(aut, nil, end)
+	want, _ := drainIterator(t, itr, err)
+	if len(want) < 10 {
+		t.Fatalf("expected more matches, got %d", len(want))
+	}
+
+	// stop part way through, and resume against a separately loaded copy
+	itr, err = fst.Search(aut, nil, end)
+	var got []string
+	for j := 0; j < 5; j++ {
+		if err != nil {
+			t.Fatalf("error iterating: %v", err)
+		}
+		key, _ := itr.Current()
+		got = append(got, string(key))
+		if j < 4 {
+			err = itr.Next()
+		}
+	}
+	token, err := itr.Bookmark("s.*e")
+	if err != nil {
+		t.Fatalf("error bookmarking: %v", err)
+	}
+	other, err := Load(append([]byte(nil), data...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr, err = other.Resume(token, compile)
+	rest, _ := drainIterator(t, itr, err)
+	got = append(got, rest...)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// a bookmark only applies to the same content
+	fst2, err := Load(buildSmallSample(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fst2.Resume(token, compile)
+	if err != ErrBookmarkMismatch {
+		t.Errorf("expected ErrBookmarkMismatch, got %v", err)
+	}
+	for _, bad := range [][]byte{nil, token[:len(token)-1], append(token, 0)} {
+		_, err = fst.Resume(bad, compile)
+		if err != ErrInvalidBookmark {
+			t.Errorf("expected ErrInvalidBookmark for %x, got %v", bad, err)
+		}
+	}
+	_, err = fst.Resume(token, nil)
+	if err == nil {
+		t.Errorf("expected error resuming filter without compile function")
+	}
+
+	// an FST which can't be read in full can't be hashed, so its bookmarks
+	// can't be checked
+	partial, err := Load(data, WithPartialData(NewRangeSet(
+		ByteRange{0, headerSize}, ByteRange{headerSize + 1, len(data)})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = partial.ContentHash()
+	if !errors.Is(err, ErrRangeUnavailable) {
+		t.Errorf("expected range unavailable hashing, got %v", err)
+	}
+	_, err = partial.Resume(token, compile)
+	if !errors.Is(err, ErrRangeUnavailable) {
+		t.Errorf("expected range unavailable resuming, got %v", err)
+	}
+	itr, err = partial.Search(aut, nil, end)
+	if err == nil {
+		_, err = itr.Bookmark("s.*e")
+	}
+	if !errors.Is(err, ErrRangeUnavailable) {
+		t.Errorf("expected range unavailable bookmarking, got %v", err)
+	}
+}
@@ -16,7 +16,9 @@ package vellum
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"reflect"
 )
 
 var defaultBuilderOpts = &BuilderOpts{
@@ -34,11 +36,28 @@ type Builder struct {
 	len        int
 
 	lastAddr int
+	// states and transitions count those written, see BuildStats
+	states      int
+	transitions int
 
 	encoder encoder
 	opts    *BuilderOpts
+	// out is the Writer the encoder writes to
+	out *flowWriter
 
 	builderNodePool *builderNodePool
+
+	check outputCheck
+
+	annotators []stateAnnotator
+	values     *valueInterner
+	digests    *keyDigester
+	payloads   *payloadInterner
+	valueLists *payloadInterner
+	suffixes   *suffixReporter
+
+	// copied remembers the nodes compiled by CopyFrom
+	copied *copier
 }
 
 const noneAddr = 1
@@ -50,21 +69,65 @@ func newBuilder(w io.Writer, opts *BuilderOpts) (*Builder, error) {
 	if opts == nil {
 		opts = defaultBuilderOpts
 	}
+	if opts.KeyDigests < 0 || opts.KeyDigests > 8 {
+		return nil, fmt.Errorf("invalid key digest size %d", opts.KeyDigests)
+	}
 	builderNodePool := &builderNodePool{}
 	rv := &Builder{
 		unfinished:      newUnfinishedNodes(builderNodePool),
-		registry:        newRegistry(builderNodePool, opts.RegistryTableSize, opts.RegistryMRUSize),
+		registry:        newRegistry(builderNodePool, opts.registryTableSize(), opts.RegistryMRUSize),
 		builderNodePool: builderNodePool,
 		opts:            opts,
 		lastAddr:        noneAddr,
+		out:             &flowWriter{w: w},
+	}
+	rv.annotators = opts.annotators()
+	if opts.InternValues {
+		rv.values = newValueInterner()
+	}
+	if opts.KeyDigests > 0 {
+		rv.digests = newKeyDigester(opts.KeyDigests)
+	}
+	if opts.payloads {
+		rv.payloads = newPayloadInterner()
+	}
+	if opts.valueLists {
+		rv.valueLists = newPayloadInterner()
 	}
+	if opts.SuffixReport > 0 {
+		rv.suffixes = newSuffixReporter(opts.SuffixReport)
+	}
+	rv.registry.spillThreshold = opts.RegistrySpillThreshold
+	rv.registry.spillDir = opts.RegistrySpillDir
+	if opts.MaxMemory > 0 {
+		rv.registry.maxHeapBytes = opts.MaxMemory -
+			len(rv.registry.table)*registryCellSize
+		if rv.registry.maxHeapBytes < 1 {
+			rv.registry.maxHeapBytes = 1
+		}
+	}
+	rv.registry.hashFunc = opts.RegistryHash
 
 	var err error
-	rv.encoder, err = loadEncoder(opts.Encoder, w)
+	rv.encoder, err = loadEncoder(opts.Encoder, rv.out)
 	if err != nil {
 		return nil, err
 	}
-	err = rv.encoder.start()
+	if _, ok := rv.encoder.(*encoderV1); opts.Checksums && !ok {
+		return nil, fmt.Errorf("checksums not supported by encoder for "+
+			"version %d", opts.Encoder)
+	}
+	if opts.Checksums && opts.Compression != "" {
+		return nil, fmt.Errorf("checksums can't be combined with " +
+			"compression, the compressed blocks aren't covered")
+	}
+	if opts.Compression != "" {
+		rv.encoder, err = newCompressingEncoder(rv.encoder, rv.out, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rv.encoder.start(opts.headerType())
 	if err != nil {
 		return nil, err
 	}
@@ -73,13 +136,40 @@ func newBuilder(w io.Writer, opts *BuilderOpts) (*Builder, error) {
 
 func (b *Builder) Reset(w io.Writer) error {
 	b.unfinished.Reset()
+	err := b.registry.closeSpill()
+	if err != nil {
+		return err
+	}
 	b.registry.Reset()
 	b.lastAddr = noneAddr
-	b.encoder.reset(w)
+	b.out.reset(w)
+	b.encoder.reset(b.out)
 	b.last = nil
 	b.len = 0
+	b.states = 0
+	b.transitions = 0
+	b.check.active = false
+	for _, a := range b.annotators {
+		a.reset()
+	}
+	if b.values != nil {
+		b.values.reset()
+	}
+	if b.digests != nil {
+		b.digests.reset()
+	}
+	if b.payloads != nil {
+		b.payloads.reset()
+	}
+	if b.valueLists != nil {
+		b.valueLists.reset()
+	}
+	if b.suffixes != nil {
+		b.suffixes.reset()
+	}
+	b.copied = nil
 
-	err := b.encoder.start()
+	err = b.encoder.start(b.opts.headerType())
 	if err != nil {
 		return err
 	}
@@ -89,10 +179,19 @@ func (b *Builder) Reset(w io.Writer) error {
 // Insert the provided value to the set being built.
 // NOTE: values must be inserted in lexicographical order.
 func (b *Builder) Insert(key []byte, val uint64) error {
+	if b.out.err != nil {
+		return b.out.err
+	}
 	// ensure items are added in lexicographic order
 	if bytes.Compare(key, b.last) < 0 {
 		return ErrOutOfOrder
 	}
+	if b.digests != nil {
+		b.digests.add(key, val)
+	}
+	if b.values != nil {
+		val = b.values.intern(val)
+	}
 	if len(key) == 0 {
 		b.len = 1
 		b.unfinished.setRootOutput(val)
@@ -101,6 +200,15 @@ func (b *Builder) Insert(key []byte, val uint64) error {
 
 	prefixLen, out := b.unfinished.findCommonPrefixAndSetOutput(key, val)
 	b.len++
+	if b.check.active && !bytes.Equal(key, b.check.key) {
+		// outputs for the previous key have just been redistributed,
+		// verify before its suffix is compiled
+		err := b.unfinished.verifyOutput(b.check.key, b.check.val)
+		if err != nil {
+			return err
+		}
+	}
+	b.check.active = false
 	err := b.compileFrom(prefixLen)
 	if err != nil {
 		return err
@@ -108,6 +216,10 @@ func (b *Builder) Insert(key []byte, val uint64) error {
 	b.copyLastKey(key)
 	b.unfinished.addSuffix(key[prefixLen:], out)
 
+	if b.opts.CheckOutputs > 0 && b.len%b.opts.CheckOutputs == 0 {
+		return b.checkOutput(key, val)
+	}
+
 	return nil
 }
 
@@ -122,15 +234,58 @@ func (b *Builder) copyLastKey(key []byte) {
 
 // Close MUST be called after inserting all values.
 func (b *Builder) Close() error {
+	err := b.close()
+	cerr := b.registry.closeSpill()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (b *Builder) close() error {
+	if b.out.err != nil {
+		return b.out.err
+	}
 	err := b.compileFrom(0)
 	if err != nil {
 		return err
 	}
 	root := b.unfinished.popRoot()
-	rootAddr, err := b.compile(root)
+	rootAddr, err := b.compile(root, b.last)
 	if err != nil {
 		return err
 	}
+	for _, a := range b.annotators {
+		err = b.encoder.encodeSection(a.section(), a.encode())
+		if err != nil {
+			return err
+		}
+	}
+	if b.values != nil {
+		err = b.encoder.encodeSection(sectionValues, b.values.encode())
+		if err != nil {
+			return err
+		}
+	}
+	if b.digests != nil {
+		err = b.encoder.encodeSection(sectionKeyDigests, b.digests.encode())
+		if err != nil {
+			return err
+		}
+	}
+	if b.payloads != nil {
+		err = b.encoder.encodeSection(sectionPayloads, b.payloads.encode())
+		if err != nil {
+			return err
+		}
+	}
+	if b.valueLists != nil {
+		err = b.encoder.encodeSection(sectionValueLists,
+			b.valueLists.encode())
+		if err != nil {
+			return err
+		}
+	}
 	return b.encoder.finish(b.len, rootAddr)
 }
 
@@ -144,7 +299,7 @@ func (b *Builder) compileFrom(iState int) error {
 			node = b.unfinished.popFreeze(addr)
 		}
 		var err error
-		addr, err = b.compile(node)
+		addr, err = b.compile(node, b.last[len(b.unfinished.stack):])
 		if err != nil {
 			return nil
 		}
@@ -153,22 +308,45 @@ func (b *Builder) compileFrom(iState int) error {
 	return nil
 }
 
-func (b *Builder) compile(node *builderNode) (int, error) {
+// compile writes the node, unless an equivalent one was, suffix is that of
+// the last key inserted from the node, or nil if not known
+func (b *Builder) compile(node *builderNode, suffix []byte) (int, error) {
 	if node.final && len(node.trans) == 0 &&
 		node.finalOutput == 0 {
 		return 0, nil
 	}
+	err := b.registry.maybeSpill()
+	if err != nil {
+		return 0, err
+	}
+	b.registry.maybeShed()
 	found, addr, entry := b.registry.entry(node)
 	if found {
+		if b.suffixes != nil {
+			b.suffixes.reused(addr, suffix)
+		}
 		return addr, nil
 	}
-	addr, err := b.encoder.encodeState(node, b.lastAddr)
+	addr, err = b.encoder.encodeState(node, b.lastAddr)
 	if err != nil {
 		return 0, err
 	}
+	if b.suffixes != nil {
+		prev := b.lastAddr
+		if prev == noneAddr {
+			prev = headerSize - 1
+		}
+		b.suffixes.written(addr, addr-prev)
+	}
+
+	for _, a := range b.annotators {
+		a.add(addr, node)
+	}
 
+	b.states++
+	b.transitions += len(node.trans)
 	b.lastAddr = addr
-	entry.addr = addr
+	b.registry.set(entry, addr)
 	return addr, nil
 }
 
@@ -368,6 +546,15 @@ func (n *builderNode) reset() {
 	n.next = nil
 }
 
+// sizes used by heapSize, obtained without unsafe, see the nommap build tag
+var builderNodeSize = int(reflect.TypeOf(builderNode{}).Size())
+var transitionSize = int(reflect.TypeOf(transition{}).Size())
+
+// heapSize estimates the memory used by the node
+func (n *builderNode) heapSize() int {
+	return builderNodeSize + cap(n.trans)*transitionSize
+}
+
 func (n *builderNode) equiv(o *builderNode) bool {
 	if n.final != o.final {
 		return false
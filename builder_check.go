@@ -0,0 +1,66 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOutputInvariant is returned by a Builder with BuilderOpts.CheckOutputs
+// set, when the outputs distributed along the shared prefix of a key no
+// longer combine to the value inserted for that key.
+var ErrOutputInvariant = errors.New("output invariant violated")
+
+// outputCheck tracks the sampled key currently being verified.
+type outputCheck struct {
+	key    []byte
+	val    uint64
+	active bool
+}
+
+// checkOutput verifies the sampled key which has just been inserted, and
+// records it so that it is verified again after the next Insert
+// redistributes outputs along its shared prefix.
+func (b *Builder) checkOutput(key []byte, val uint64) error {
+	err := b.unfinished.verifyOutput(key, val)
+	if err != nil {
+		return err
+	}
+	b.check.key = append(b.check.key[:0], key...)
+	b.check.val = val
+	b.check.active = true
+	return nil
+}
+
+// verifyOutput checks that the outputs along the path for key, which must
+// still be entirely unfinished, combine to val.
+func (u *unfinishedNodes) verifyOutput(key []byte, val uint64) error {
+	var out uint64
+	for i := range key {
+		if i >= len(u.stack) || !u.stack[i].hasLastT || u.stack[i].lastIn != key[i] {
+			return fmt.Errorf("%w: key %q not found at depth %d", ErrOutputInvariant, key, i)
+		}
+		out = outputCat(out, u.stack[i].lastOut)
+	}
+	if len(key) >= len(u.stack) || !u.stack[len(key)].node.final {
+		return fmt.Errorf("%w: key %q not final", ErrOutputInvariant, key)
+	}
+	out = outputCat(out, u.stack[len(key)].node.finalOutput)
+	if out != val {
+		return fmt.Errorf("%w: key %q has output %d, expected %d", ErrOutputInvariant, key, out, val)
+	}
+	return nil
+}
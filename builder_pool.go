@@ -0,0 +1,70 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"io"
+	"sync"
+)
+
+// BuilderPool recycles Builders across many builds within a process, for
+// example when building per-field dictionaries of the same documents.
+// The registry table, the pool of builder nodes and the write buffer of
+// each Builder are retained between builds, avoiding the allocation and
+// initialization cost of each new build.
+//
+// NOTE: registered states refer to addresses in the FST being built, so
+// the contents of the registry are always cleared between builds, only the
+// memory is reused.  Sharing a registry pre-seeded with the states of other
+// builds isn't supported: each FST can only refer to the states written to
+// it, so reusing a seeded state would mean writing it to every FST, used or
+// not.  Builds of similar key sets only share the cost of allocations.  A
+// BuilderPool is safe for concurrent use.
+type BuilderPool struct {
+	opts *BuilderOpts
+	pool sync.Pool
+}
+
+// NewBuilderPool returns a new BuilderPool, where all Builders share the
+// provided options.
+func NewBuilderPool(opts ...BuilderOption) *BuilderPool {
+	return &BuilderPool{
+		opts: applyBuilderOptions(opts),
+	}
+}
+
+// Get returns a Builder streaming out to the provided Writer, reusing a
+// previously released Builder if one is available.
+func (p *BuilderPool) Get(w io.Writer) (*Builder, error) {
+	if b, ok := p.pool.Get().(*Builder); ok {
+		err := b.Reset(w)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	return newBuilder(w, p.opts)
+}
+
+// Put releases the Builder back to the pool.  The Builder MUST NOT be used
+// after it is released, it is typically released after calling Close().
+func (p *BuilderPool) Put(b *Builder) {
+	if b == nil {
+		return
+	}
+	// release the reference to the previous writer
+	b.encoder.reset(nil)
+	p.pool.Put(b)
+}
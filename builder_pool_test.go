@@ -0,0 +1,120 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestBuilderPool(t *testing.T) {
+	pool := NewBuilderPool(nil)
+
+	build := func(m map[string]uint64) []byte {
+		var buf bytes.Buffer
+		b, err := pool.Get(&buf)
+		if err != nil {
+			t.Fatalf("error getting builder: %v", err)
+		}
+		err = insertStringMap(b, m)
+		if err != nil {
+			t.Fatalf("error building: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing: %v", err)
+		}
+		pool.Put(b)
+		return buf.Bytes()
+	}
+
+	samples := []map[string]uint64{
+		smallSample,
+		{"mon": 7, "tues": 1, "wed": 3},
+		{},
+		smallSample,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, sample := range samples {
+				data := build(sample)
+				fst, err := Load(data)
+				if err != nil {
+					t.Errorf("error loading: %v", err)
+					return
+				}
+				got := map[string]uint64{}
+				itr, err := fst.Iterator(nil, nil)
+				for err == nil {
+					key, val := itr.Current()
+					got[string(key)] = val
+					err = itr.Next()
+				}
+				if err != ErrIteratorDone {
+					t.Errorf("iterator error: %v", err)
+				}
+				if !reflect.DeepEqual(sample, got) {
+					t.Errorf("expected %v, got: %v", sample, got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// output is identical to a fresh builder
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStringMap(b, smallSample)
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), build(smallSample)) {
+		t.Errorf("expected pooled builder output to match new builder")
+	}
+}
+
+func BenchmarkBuilderPool(b *testing.B) {
+	pool := NewBuilderPool(nil)
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		builder, err := pool.Get(&buf)
+		if err != nil {
+			b.Fatalf("error getting builder: %v", err)
+		}
+		err = insertStrings(builder, thousandTestWords[:100], make([]uint64, 100))
+		if err != nil {
+			b.Fatalf("error building: %v", err)
+		}
+		err = builder.Close()
+		if err != nil {
+			b.Fatalf("error closing: %v", err)
+		}
+		pool.Put(builder)
+	}
+}
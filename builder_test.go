@@ -16,9 +16,12 @@ package vellum
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"reflect"
 	"sort"
 	"testing"
 )
@@ -254,3 +257,108 @@ func BenchmarkBuilder(b *testing.B) {
 		}
 	}
 }
+
+func TestBuilderCheckOutputs(t *testing.T) {
+	for _, every := range []int{1, 7} {
+		var buf bytes.Buffer
+		b, err := New(&buf, &BuilderOpts{
+			Encoder:           1,
+			RegistryTableSize: 10000,
+			RegistryMRUSize:   2,
+			CheckOutputs:      every,
+		})
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		vals := randomValues(thousandTestWords)
+		err = insertStrings(b, thousandTestWords, vals)
+		if err != nil {
+			t.Fatalf("error inserting with CheckOutputs %d: %v", every, err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+	}
+}
+
+func TestBuilderCheckOutputsDetects(t *testing.T) {
+	b, err := New(ioutil.Discard, &BuilderOpts{
+		Encoder:           1,
+		RegistryTableSize: 10000,
+		RegistryMRUSize:   2,
+		CheckOutputs:      1,
+	})
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = b.Insert([]byte("ab"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// simulate a bug in the output algebra
+	b.unfinished.stack[1].lastOut++
+	err = b.Insert([]byte("ac"), 7)
+	if !errors.Is(err, ErrOutputInvariant) {
+		t.Fatalf("expected ErrOutputInvariant, got %v", err)
+	}
+}
+
+func TestBuilderOptions(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts []BuilderOption
+		want BuilderOpts
+	}{
+		{
+			desc: "none",
+			want: *defaultBuilderOpts,
+		},
+		{
+			desc: "nil",
+			opts: []BuilderOption{nil},
+			want: *defaultBuilderOpts,
+		},
+		{
+			desc: "struct",
+			opts: []BuilderOption{&BuilderOpts{Encoder: 1, RegistryTableSize: 5}},
+			want: BuilderOpts{Encoder: 1, RegistryTableSize: 5},
+		},
+		{
+			desc: "functional",
+			opts: []BuilderOption{WithRegistrySize(20, 3), WithOutputCheck(10)},
+			want: BuilderOpts{Encoder: 1, RegistryTableSize: 20, RegistryMRUSize: 3, CheckOutputs: 10},
+		},
+		{
+			desc: "struct then functional",
+			opts: []BuilderOption{&BuilderOpts{Encoder: 1, CheckOutputs: 2}, WithRegistrySize(20, 3)},
+			want: BuilderOpts{Encoder: 1, RegistryTableSize: 20, RegistryMRUSize: 3, CheckOutputs: 2},
+		},
+		{
+			desc: "functional then struct",
+			opts: []BuilderOption{WithRegistrySize(20, 3), &BuilderOpts{Encoder: 1}},
+			want: BuilderOpts{Encoder: 1},
+		},
+		{
+			desc: "functional then nil struct",
+			opts: []BuilderOption{WithRegistrySize(20, 3), (*BuilderOpts)(nil)},
+			want: *defaultBuilderOpts,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			b, err := New(ioutil.Discard, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*b.opts, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, *b.opts)
+			}
+		})
+	}
+
+	_, err := New(ioutil.Discard, WithVersion(629))
+	if err == nil {
+		t.Errorf("expected error for unknown version, got nil")
+	}
+}
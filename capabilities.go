@@ -0,0 +1,91 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Features describes the optional features available in this build of
+// vellum, on this platform.
+type Features struct {
+	// Mmap is true if Open memory maps files, false with the nommap build
+	// tag, where they are read into memory instead
+	Mmap bool
+
+	// RegistrySpill is true if builders can spill their registry to a
+	// file, see BuilderOpts.RegistrySpillThreshold, which requires mmap
+	RegistrySpill bool
+
+	// ReadOnlyProtect is true if WithMutationCheck protects the FST data
+	// against writes, rather than only detecting them with a checksum
+	ReadOnlyProtect bool
+
+	// FormatVersions are the versions of the file format which can be read
+	// and written, in increasing order
+	FormatVersions []int
+
+	// Compression lists the names of the registered Compressors
+	Compression []string
+
+	// SWAR is true if transition labels are searched several at a time,
+	// with portable word-wide operations rather than assembly
+	SWAR bool
+
+	// GOOS and GOARCH are the platform vellum was compiled for
+	GOOS   string
+	GOARCH string
+}
+
+// Capabilities reports the optional features available in this build of
+// vellum, so that applications can adapt to them, and include them in
+// their diagnostics.
+func Capabilities() *Features {
+	rv := &Features{
+		Mmap:            mmapAvailable,
+		RegistrySpill:   mmapAvailable,
+		ReadOnlyProtect: readOnlyProtectAvailable,
+		Compression:     compressorNames(),
+		SWAR:            true,
+		GOOS:            runtime.GOOS,
+		GOARCH:          runtime.GOARCH,
+	}
+	for ver := range encoders {
+		if _, ok := decoders[ver]; ok {
+			rv.FormatVersions = append(rv.FormatVersions, ver)
+		}
+	}
+	sort.Ints(rv.FormatVersions)
+	return rv
+}
+
+// String returns a one line summary of the features, for diagnostics.
+func (f *Features) String() string {
+	versions := make([]string, len(f.FormatVersions))
+	for i, ver := range f.FormatVersions {
+		versions[i] = fmt.Sprint(ver)
+	}
+	compression := "none"
+	if len(f.Compression) > 0 {
+		compression = strings.Join(f.Compression, ",")
+	}
+	return fmt.Sprintf("vellum %s/%s mmap=%t registry-spill=%t "+
+		"read-only-protect=%t swar=%t versions=%s compression=%s",
+		f.GOOS, f.GOARCH, f.Mmap, f.RegistrySpill, f.ReadOnlyProtect, f.SWAR,
+		strings.Join(versions, ","), compression)
+}
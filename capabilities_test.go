@@ -0,0 +1,50 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	c := Capabilities()
+	if c.GOOS != runtime.GOOS || c.GOARCH != runtime.GOARCH {
+		t.Errorf("expected %s/%s, got %s/%s", runtime.GOOS, runtime.GOARCH,
+			c.GOOS, c.GOARCH)
+	}
+	if c.Mmap != mmapAvailable || c.ReadOnlyProtect != readOnlyProtectAvailable {
+		t.Errorf("unexpected platform features %s", c)
+	}
+	if c.ReadOnlyProtect && !c.Mmap {
+		t.Errorf("expected read-only protection to require mmap")
+	}
+	var found bool
+	for i, ver := range c.FormatVersions {
+		if i > 0 && ver <= c.FormatVersions[i-1] {
+			t.Errorf("expected increasing versions, got %v", c.FormatVersions)
+		}
+		if ver == versionV1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected version %d in %v", versionV1, c.FormatVersions)
+	}
+	if !strings.Contains(c.String(), "versions=") {
+		t.Errorf("unexpected summary %q", c.String())
+	}
+}
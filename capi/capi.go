@@ -0,0 +1,210 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command capi exports a C ABI for querying vellum FSTs from other
+// languages.  Build it as a shared library, which also generates the
+// header to include:
+//
+//	go build -buildmode=c-shared -o libvellum.so github.com/couchbase/vellum/capi
+//
+// FSTs and iterators are referred to by opaque handles.  Functions return
+// VELLUM_OK on success, and VELLUM_ERROR on failure, in which case if err
+// is not NULL it is set to a message which the caller must release with
+// vellum_free.  Keys are passed as a pointer and length, and may contain
+// any bytes.
+//
+// An iterator MUST be closed before the FST it iterates.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+#define VELLUM_OK 0
+#define VELLUM_ERROR -1
+#define VELLUM_NOT_FOUND 1
+#define VELLUM_DONE 2
+
+typedef uint64_t vellum_handle;
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/query"
+)
+
+func setErr(err error, cerr **C.char) C.int {
+	if cerr != nil {
+		*cerr = C.CString(err.Error())
+	}
+	return C.VELLUM_ERROR
+}
+
+func goBytes(p *C.char, n C.size_t) []byte {
+	if p == nil {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(p), C.int(n))
+}
+
+//export vellum_free
+func vellum_free(p unsafe.Pointer) {
+	C.free(p)
+}
+
+//export vellum_open
+func vellum_open(path *C.char, fst *C.vellum_handle, cerr **C.char) C.int {
+	id, err := openFST(C.GoString(path))
+	if err != nil {
+		return setErr(err, cerr)
+	}
+	*fst = C.vellum_handle(id)
+	return C.VELLUM_OK
+}
+
+//export vellum_close
+func vellum_close(fst C.vellum_handle, cerr **C.char) C.int {
+	err := closeFST(uint64(fst))
+	if err != nil {
+		return setErr(err, cerr)
+	}
+	return C.VELLUM_OK
+}
+
+// vellum_get returns VELLUM_NOT_FOUND if the key doesn't exist.
+//
+//export vellum_get
+func vellum_get(fst C.vellum_handle, key *C.char, keyLen C.size_t,
+	val *C.uint64_t, cerr **C.char) C.int {
+	v, exists, err := getFST(uint64(fst), goBytes(key, keyLen))
+	if err != nil {
+		return setErr(err, cerr)
+	}
+	if !exists {
+		return C.VELLUM_NOT_FOUND
+	}
+	*val = C.uint64_t(v)
+	return C.VELLUM_OK
+}
+
+func search(fst C.vellum_handle, q *query.Query,
+	start *C.char, startLen C.size_t, end *C.char, endLen C.size_t,
+	itr *C.vellum_handle, cerr **C.char) C.int {
+	id, err := searchFST(uint64(fst), q, goBytes(start, startLen),
+		goBytes(end, endLen))
+	if err != nil {
+		return setErr(err, cerr)
+	}
+	*itr = C.vellum_handle(id)
+	return C.VELLUM_OK
+}
+
+// vellum_iterate iterates all keys between start (inclusive) and end
+// (exclusive), a NULL start or end is unbounded.
+//
+//export vellum_iterate
+func vellum_iterate(fst C.vellum_handle,
+	start *C.char, startLen C.size_t, end *C.char, endLen C.size_t,
+	itr *C.vellum_handle, cerr **C.char) C.int {
+	return search(fst, nil, start, startLen, end, endLen, itr, cerr)
+}
+
+//export vellum_search_prefix
+func vellum_search_prefix(fst C.vellum_handle, prefix *C.char,
+	prefixLen C.size_t, itr *C.vellum_handle, cerr **C.char) C.int {
+	q := query.Prefix(string(goBytes(prefix, prefixLen)))
+	return search(fst, q, nil, 0, nil, 0, itr, cerr)
+}
+
+//export vellum_search_regexp
+func vellum_search_regexp(fst C.vellum_handle, expr *C.char,
+	itr *C.vellum_handle, cerr **C.char) C.int {
+	q := query.Regexp(C.GoString(expr))
+	return search(fst, q, nil, 0, nil, 0, itr, cerr)
+}
+
+//export vellum_search_fuzzy
+func vellum_search_fuzzy(fst C.vellum_handle, term *C.char, distance C.int,
+	itr *C.vellum_handle, cerr **C.char) C.int {
+	q := query.Fuzzy(C.GoString(term), int(distance))
+	return search(fst, q, nil, 0, nil, 0, itr, cerr)
+}
+
+// vellum_iterator_current returns VELLUM_DONE once the iterator is
+// exhausted.  Otherwise the key returned is valid until the next call using
+// the iterator.
+//
+//export vellum_iterator_current
+func vellum_iterator_current(itr C.vellum_handle, key **C.char,
+	keyLen *C.size_t, val *C.uint64_t, cerr **C.char) C.int {
+	i, err := table.iterator(uint64(itr))
+	if err != nil {
+		return setErr(err, cerr)
+	}
+	if errors.Is(i.err, vellum.ErrIteratorDone) {
+		return C.VELLUM_DONE
+	}
+	if i.err != nil {
+		return setErr(i.err, cerr)
+	}
+	k, v := i.itr.Current()
+	C.free(i.ckey)
+	i.ckey = C.CBytes(k)
+	*key = (*C.char)(i.ckey)
+	*keyLen = C.size_t(len(k))
+	*val = C.uint64_t(v)
+	return C.VELLUM_OK
+}
+
+// vellum_iterator_next returns VELLUM_DONE once the iterator is exhausted.
+//
+//export vellum_iterator_next
+func vellum_iterator_next(itr C.vellum_handle, cerr **C.char) C.int {
+	i, err := table.iterator(uint64(itr))
+	if err != nil {
+		return setErr(err, cerr)
+	}
+	if i.err == nil {
+		i.err = i.itr.Next()
+	}
+	if errors.Is(i.err, vellum.ErrIteratorDone) {
+		return C.VELLUM_DONE
+	}
+	if i.err != nil {
+		return setErr(i.err, cerr)
+	}
+	return C.VELLUM_OK
+}
+
+//export vellum_iterator_close
+func vellum_iterator_close(itr C.vellum_handle, cerr **C.char) C.int {
+	i, err := table.iterator(uint64(itr))
+	if err != nil {
+		return setErr(err, cerr)
+	}
+	table.remove(uint64(itr))
+	C.free(i.ckey)
+	i.ckey = nil
+	if i.itr != nil {
+		err = i.itr.Close()
+		if err != nil {
+			return setErr(err, cerr)
+		}
+	}
+	return C.VELLUM_OK
+}
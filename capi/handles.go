@@ -0,0 +1,128 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/query"
+)
+
+// main is required by -buildmode=c-shared, and is here rather than in
+// capi.go so that the package also builds without cgo.
+func main() {}
+
+// Go pointers can't be retained by C code, so FSTs and iterators are
+// referred to by opaque handles, indexing this table.
+type handles struct {
+	m    sync.Mutex
+	last uint64
+	objs map[uint64]interface{}
+}
+
+var table = &handles{
+	objs: make(map[uint64]interface{}),
+}
+
+func (h *handles) add(obj interface{}) uint64 {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.last++
+	h.objs[h.last] = obj
+	return h.last
+}
+
+func (h *handles) fst(id uint64) (*vellum.FST, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if fst, ok := h.objs[id].(*vellum.FST); ok {
+		return fst, nil
+	}
+	return nil, fmt.Errorf("invalid fst handle %d", id)
+}
+
+func (h *handles) iterator(id uint64) (*iterator, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if itr, ok := h.objs[id].(*iterator); ok {
+		return itr, nil
+	}
+	return nil, fmt.Errorf("invalid iterator handle %d", id)
+}
+
+func (h *handles) remove(id uint64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	delete(h.objs, id)
+}
+
+// iterator tracks the position of a vellum iterator, and the C copy of
+// the current key handed out, which is valid until the next call.
+type iterator struct {
+	itr  *vellum.FSTIterator
+	err  error
+	ckey unsafe.Pointer
+}
+
+func openFST(path string) (uint64, error) {
+	fst, err := vellum.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	return table.add(fst), nil
+}
+
+func closeFST(id uint64) error {
+	fst, err := table.fst(id)
+	if err != nil {
+		return err
+	}
+	table.remove(id)
+	return fst.Close()
+}
+
+func getFST(id uint64, key []byte) (uint64, bool, error) {
+	fst, err := table.fst(id)
+	if err != nil {
+		return 0, false, err
+	}
+	return fst.Get(key)
+}
+
+// searchFST starts iterating the keys between start (inclusive) and end
+// (exclusive) matching the query, a nil query matches all keys.
+func searchFST(id uint64, q *query.Query, start, end []byte) (uint64, error) {
+	fst, err := table.fst(id)
+	if err != nil {
+		return 0, err
+	}
+	var aut vellum.Automaton
+	if q != nil {
+		aut, err = q.Compile()
+		if err != nil {
+			return 0, err
+		}
+	}
+	rv := &iterator{}
+	rv.itr, rv.err = fst.Search(aut, start, end)
+	if rv.err != nil && !errors.Is(rv.err, vellum.ErrIteratorDone) {
+		return 0, rv.err
+	}
+	return table.add(rv), nil
+}
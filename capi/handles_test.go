@@ -0,0 +1,96 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/query"
+)
+
+func TestHandles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "test.fst")
+	b := vellum.NewMapBuilder()
+	b.Set("mon", 1)
+	b.Set("tue", 2)
+	b.Set("thu", 4)
+	err = b.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fst, err := openFST(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, exists, err := getFST(fst, []byte("tue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists || val != 2 {
+		t.Errorf("expected tue 2, got %d (exists: %t)", val, exists)
+	}
+
+	id, err := searchFST(fst, query.Prefix("t"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr, err := table.iterator(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for itr.err == nil {
+		k, _ := itr.itr.Current()
+		got = append(got, string(k))
+		itr.err = itr.itr.Next()
+	}
+	want := []string{"thu", "tue"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	table.remove(id)
+
+	_, err = searchFST(fst, query.Regexp("a("), nil, nil)
+	if err == nil {
+		t.Errorf("expected error for invalid regexp")
+	}
+
+	_, err = table.fst(id)
+	if err == nil {
+		t.Errorf("expected error using iterator handle as fst")
+	}
+
+	err = closeFST(fst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = getFST(fst, []byte("tue"))
+	if err == nil {
+		t.Errorf("expected error using closed handle")
+	}
+}
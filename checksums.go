@@ -0,0 +1,220 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Checksums protect the data of an FST built with BuilderOpts.Checksums
+// against truncation and corruption.  The data is split in blocks of
+// checksumBlockSize bytes, from the header up to the checksum section,
+// which is the last section, and the CRC-32C of each block is recorded in
+// that section, laid out as:
+//
+//	4 bytes block size
+//	4 bytes checksum of the block size, section table and footer
+//	for each block: 4 bytes checksum
+//
+// all uint32 little-endian.  The section table and footer which follow it
+// are known when it is written, so their checksum is recorded as well, and
+// every byte of the file is covered.  The typeChecksums bit is set in the
+// header type, and readers unaware of it ignore the section.
+//
+// Checksums are an optional section, rather than a format version of their
+// own with a magic number and a checksum in the footer: the version selects
+// the encoding of the states (see decoder_v2.go), which the checksums
+// cover either way, and the header and footer of the version are kept, so
+// that the files remain readable by readers predating them.  They aren't
+// supported with compression, as the compressed blocks wrapping the data
+// (see compression.go) aren't covered.
+
+const checksumBlockSize = 64 << 10
+
+const checksumHeaderSize = 8
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// putChecksumHeader writes the block size, and the checksum of the tail of
+// the data, which also covers the block size
+func putChecksumHeader(buf []byte, blockSize int, table, footer []byte) {
+	binary.LittleEndian.PutUint32(buf, uint32(blockSize))
+	binary.LittleEndian.PutUint32(buf[4:], tailChecksum(buf[:4], table,
+		footer))
+}
+
+func tailChecksum(blockSize, table, footer []byte) uint32 {
+	crc := crc32.Checksum(blockSize, castagnoliTable)
+	crc = crc32.Update(crc, castagnoliTable, table)
+	return crc32.Update(crc, castagnoliTable, footer)
+}
+
+// blockChecksummer computes the checksums of the blocks of the data
+// written, buffering small writes
+type blockChecksummer struct {
+	blockSize int
+	n         int // bytes of the current block checksummed
+	crc       uint32
+	sums      []uint32
+	pending   []byte
+}
+
+func newBlockChecksummer(blockSize int) *blockChecksummer {
+	return &blockChecksummer{
+		blockSize: blockSize,
+		pending:   make([]byte, 0, 256),
+	}
+}
+
+func (c *blockChecksummer) writeByte(b byte) {
+	if len(c.pending) == cap(c.pending) {
+		c.flush()
+	}
+	c.pending = append(c.pending, b)
+}
+
+func (c *blockChecksummer) write(p []byte) {
+	if len(c.pending)+len(p) <= cap(c.pending) {
+		c.pending = append(c.pending, p...)
+		return
+	}
+	c.flush()
+	c.update(p)
+}
+
+func (c *blockChecksummer) flush() {
+	c.update(c.pending)
+	c.pending = c.pending[:0]
+}
+
+func (c *blockChecksummer) update(p []byte) {
+	for len(p) > 0 {
+		k := c.blockSize - c.n
+		if k > len(p) {
+			k = len(p)
+		}
+		c.crc = crc32.Update(c.crc, castagnoliTable, p[:k])
+		c.n += k
+		p = p[k:]
+		if c.n == c.blockSize {
+			c.sums = append(c.sums, c.crc)
+			c.crc = 0
+			c.n = 0
+		}
+	}
+}
+
+// finish returns the checksums of all the blocks, including the last,
+// partial one
+func (c *blockChecksummer) finish() []uint32 {
+	c.flush()
+	if c.n > 0 {
+		c.sums = append(c.sums, c.crc)
+		c.crc = 0
+		c.n = 0
+	}
+	return c.sums
+}
+
+// verifyChecksums verifies the data against its checksum section.  It
+// only relies on the footer and section table layout, which it checks, as
+// the data hasn't been validated yet.
+func verifyChecksums(data []byte) error {
+	if len(data) < headerSize+8+footerSizeV1 {
+		return corruptf(len(data), "data too short for checksums")
+	}
+	footerStart := len(data) - footerSizeV1
+	n := binary.LittleEndian.Uint64(data[footerStart-8:])
+	if n > uint64(footerStart-8-headerSize)/sectionEntrySize {
+		return corruptf(footerStart-8, "invalid number of sections %d", n)
+	}
+	tableStart := footerStart - 8 - int(n)*sectionEntrySize
+	var entry *sectionEntry
+	for i := 0; i < int(n); i++ {
+		s := getSectionEntry(data[tableStart+i*sectionEntrySize:])
+		if s.id == sectionChecksums {
+			entry = &s
+			break
+		}
+	}
+	if entry == nil {
+		return corruptf(tableStart, "missing checksum section")
+	}
+	if entry.length < checksumHeaderSize || entry.offset < headerSize ||
+		entry.offset > uint64(tableStart) ||
+		entry.length != uint64(tableStart)-entry.offset {
+		return corruptf(tableStart, "invalid checksum section at %d length %d",
+			entry.offset, entry.length)
+	}
+	end := int(entry.offset)
+	section := data[end:tableStart]
+	blockSize := int(binary.LittleEndian.Uint32(section))
+	tailSum := binary.LittleEndian.Uint32(section[4:])
+	if tailChecksum(section[:4], data[tableStart:], nil) != tailSum {
+		return corruptf(tableStart, "checksum mismatch in section table "+
+			"and footer")
+	}
+	if blockSize <= 0 {
+		return corruptf(end, "invalid checksum block size %d", blockSize)
+	}
+	sums := section[checksumHeaderSize:]
+	blocks := (end + blockSize - 1) / blockSize
+	if len(sums) != 4*blocks {
+		return corruptf(end, "%d checksums for %d blocks", len(sums)/4, blocks)
+	}
+	for i := 0; i < blocks; i++ {
+		start := i * blockSize
+		stop := start + blockSize
+		if stop > end {
+			stop = end
+		}
+		if crc32.Checksum(data[start:stop], castagnoliTable) !=
+			binary.LittleEndian.Uint32(sums[4*i:]) {
+			return corruptf(start, "checksum mismatch in block %d", i)
+		}
+	}
+	return nil
+}
+
+// HasChecksums returns true if the FST was built with checksums, see
+// BuilderOpts.Checksums.
+func (f *FST) HasChecksums() bool {
+	return f.typ&typeChecksums != 0
+}
+
+// VerifyChecksums verifies the data of the FST against its checksums,
+// returning an error matching ErrCorrupt if it has been corrupted, or nil
+// if the FST was built without checksums.
+func (f *FST) VerifyChecksums() error {
+	if err := f.enter(); err != nil {
+		return err
+	}
+	defer f.exit()
+	if f.typ&typeChecksums == 0 {
+		return nil
+	}
+	return f.verifyChecksums()
+}
+
+// verifyChecksums verifies the data, read in full first if the FST was
+// opened with OpenReaderAt
+func (f *FST) verifyChecksums() error {
+	data, err := f.readAll()
+	if err != nil {
+		return err
+	}
+	return verifyChecksums(data)
+}
@@ -0,0 +1,121 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	build := func(n int, opts ...BuilderOption) []byte {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			err = b.Insert([]byte(fmt.Sprintf("%08x", i*7919)), uint64(i))
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, ver := range []int{versionV1, versionV2} {
+		// enough keys for several blocks
+		data := build(20000, WithVersion(ver), WithChecksums(),
+			WithSubtreeCounts())
+		if len(data) < 2*checksumBlockSize {
+			t.Fatalf("expected several blocks, got %d bytes", len(data))
+		}
+		fst, err := Load(data)
+		if err != nil {
+			t.Fatalf("version %d: error loading: %v", ver, err)
+		}
+		if !fst.HasChecksums() || fst.VerifyChecksums() != nil {
+			t.Errorf("version %d: expected valid checksums", ver)
+		}
+		val, exists, err := fst.Get([]byte(fmt.Sprintf("%08x", 1234*7919)))
+		if err != nil || !exists || val != 1234 {
+			t.Errorf("version %d: unexpected lookup %d %t %v", ver, val,
+				exists, err)
+		}
+	}
+
+	data := build(1000, WithChecksums())
+	// any change past the header is detected, the header version and type
+	// are checked as usual
+	for i := headerSize; i < len(data); i++ {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0x10
+		_, err := Load(corrupted)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("expected byte %d change detected, got %v", i, err)
+		}
+	}
+	for _, n := range []int{1, footerSizeV1, len(data) / 2, len(data) - headerSize} {
+		_, err := Load(data[:len(data)-n])
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected truncation by %d detected, got %v", n, err)
+		}
+	}
+
+	// clearing the type bit doesn't hide the checksums
+	corrupted := append([]byte(nil), data...)
+	corrupted[8] &^= typeChecksums
+	if _, err := Load(corrupted); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected cleared checksum type detected, got %v", err)
+	}
+
+	corrupted = append([]byte(nil), data...)
+	corrupted[headerSize] ^= 0x10
+	fst, err := Load(corrupted, WithoutChecksumVerification())
+	if err != nil {
+		t.Fatalf("error loading without verification: %v", err)
+	}
+	if !errors.Is(fst.VerifyChecksums(), ErrCorrupt) {
+		t.Errorf("expected the change detected later")
+	}
+
+	plain, err := Load(build(10))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if plain.HasChecksums() || plain.VerifyChecksums() != nil {
+		t.Errorf("expected no checksums")
+	}
+
+	var buf bytes.Buffer
+	_, err = New(&buf, WithVersion(countingEncoderVersion), WithChecksums())
+	if err == nil {
+		t.Errorf("expected an error with an external encoder")
+	}
+}
+
+func TestChecksumsWithCompression(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := New(&buf, WithChecksums(), WithCompression("flate"))
+	if err == nil {
+		t.Errorf("expected checksums with compression to be rejected")
+	}
+}
@@ -15,12 +15,16 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/couchbase/vellum"
 	"github.com/spf13/cobra"
 )
 
+var infoJSON bool
+
 var infoCmd = &cobra.Command{
 	Use:   "info",
 	Short: "Prints info about this vellum FST file",
@@ -36,6 +40,13 @@ var infoCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if infoJSON {
+			stats, err := fst.Stats()
+			if err != nil {
+				return err
+			}
+			return json.NewEncoder(os.Stdout).Encode(stats)
+		}
 		fmt.Printf("version: %d\n", fst.Version())
 		fmt.Printf("length: %d\n", fst.Len())
 		return nil
@@ -44,4 +55,5 @@ var infoCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().BoolVar(&infoJSON, "json", false, "print stats as JSON")
 }
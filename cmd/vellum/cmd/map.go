@@ -16,13 +16,13 @@ package cmd
 
 import (
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strconv"
 
-	"github.com/couchbase/vellum"
 	"github.com/spf13/cobra"
 )
 
@@ -34,7 +34,7 @@ var mapCmd = &cobra.Command{
 		if len(args) < 1 {
 			return fmt.Errorf("source and target paths are required")
 		}
-		if len(args) < 2 {
+		if len(args) < 2 && !dryRun {
 			return fmt.Errorf("target path is required")
 		}
 		return nil
@@ -51,12 +51,7 @@ var mapCmd = &cobra.Command{
 		}
 		defer file.Close()
 
-		f, err := os.Create(args[1])
-		if err != nil {
-			return err
-		}
-
-		b, err := vellum.New(f, nil)
+		b, err := newBuilder(args)
 		if err != nil {
 			return err
 		}
@@ -88,6 +83,9 @@ var mapCmd = &cobra.Command{
 			return err
 		}
 
+		if dryRun {
+			return json.NewEncoder(os.Stdout).Encode(b.BuildStats())
+		}
 		return nil
 	},
 }
@@ -95,4 +93,5 @@ var mapCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(mapCmd)
 	mapCmd.Flags().BoolVar(&sorted, "sorted", false, "input already sorted")
+	mapCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print JSON build stats instead of writing the FST")
 }
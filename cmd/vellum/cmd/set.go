@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -25,6 +26,8 @@ import (
 )
 
 var sorted bool
+var suffixReport int
+var dryRun bool
 
 var setCmd = &cobra.Command{
 	Use:   "set",
@@ -34,7 +37,7 @@ var setCmd = &cobra.Command{
 		if len(args) < 1 {
 			return fmt.Errorf("source and target paths are required")
 		}
-		if len(args) < 2 {
+		if len(args) < 2 && !dryRun {
 			return fmt.Errorf("target path is required")
 		}
 		return nil
@@ -51,12 +54,7 @@ var setCmd = &cobra.Command{
 		}
 		defer file.Close()
 
-		f, err := os.Create(args[1])
-		if err != nil {
-			return err
-		}
-
-		b, err := vellum.New(f, nil)
+		b, err := newBuilder(args, vellum.WithSuffixReport(suffixReport))
 		if err != nil {
 			return err
 		}
@@ -79,6 +77,15 @@ var setCmd = &cobra.Command{
 			return err
 		}
 
+		if dryRun {
+			err = json.NewEncoder(os.Stdout).Encode(b.BuildStats())
+			if err != nil {
+				return err
+			}
+		}
+		if suffixReport > 0 {
+			return json.NewEncoder(os.Stdout).Encode(b.SuffixReport())
+		}
 		return nil
 	},
 }
@@ -86,4 +93,19 @@ var setCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(setCmd)
 	setCmd.Flags().BoolVar(&sorted, "sorted", false, "input already sorted")
+	setCmd.Flags().IntVar(&suffixReport, "suffix-report", 0, "print a JSON report of the n most shared suffixes")
+	setCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print JSON build stats instead of writing the FST")
+}
+
+// newBuilder returns a Builder writing to the target path, the second of
+// the arguments, or with --dry-run a Builder discarding its output
+func newBuilder(args []string, opts ...vellum.BuilderOption) (*vellum.Builder, error) {
+	if dryRun {
+		return vellum.NewDryRun(opts...)
+	}
+	f, err := os.Create(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return vellum.New(f, opts...)
 }
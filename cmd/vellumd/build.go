@@ -0,0 +1,146 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/couchbase/vellum"
+)
+
+// errStoreDisabled is returned when storing a dictionary built by the
+// service without a data directory.
+var errStoreDisabled = errors.New("storing dictionaries is disabled, no data directory")
+
+// errInvalidName is returned for a dictionary name which can't be stored.
+var errInvalidName = errors.New("invalid dictionary name")
+
+// errDictExists is returned for a dictionary stored under the name of one
+// being served, or built.
+type errDictExists string
+
+func (e errDictExists) Error() string {
+	return fmt.Sprintf("dictionary %q already exists", string(e))
+}
+
+// Build builds an FST of the entries returned by next, which must be in
+// key order, until it returns io.EOF, and writes it to w.  It returns the
+// number of entries.
+func (s *service) Build(ctx context.Context, w io.Writer,
+	next func() (entry, error)) (int, error) {
+	b, err := vellum.New(w, nil)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for {
+		err = ctx.Err()
+		if err != nil {
+			return n, err
+		}
+		e, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		err = b.Insert([]byte(e.Key), e.Value)
+		if err != nil {
+			return n, fmt.Errorf("entry %d, key %q: %w", n, e.Key, err)
+		}
+		n++
+	}
+	return n, b.Close()
+}
+
+// Store builds an FST of the entries returned by next, as Build does, into
+// name.fst in the data directory, and serves it as the named dictionary.
+func (s *service) Store(ctx context.Context, name string,
+	next func() (entry, error)) (int, error) {
+	if s.dataDir == "" {
+		return 0, errStoreDisabled
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || name[0] == '.' {
+		return 0, fmt.Errorf("%w %q", errInvalidName, name)
+	}
+	err := s.reserve(name)
+	if err != nil {
+		return 0, err
+	}
+	defer s.unreserve(name)
+
+	f, err := ioutil.TempFile(s.dataDir, "."+name+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	w := bufio.NewWriter(f)
+	n, err := s.Build(ctx, w, next)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return n, err
+	}
+	path := filepath.Join(s.dataDir, name+".fst")
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return n, err
+	}
+	fst, err := vellum.Open(path)
+	if err != nil {
+		return n, err
+	}
+	s.m.Lock()
+	s.dicts[name] = fst
+	s.m.Unlock()
+	return n, nil
+}
+
+// reserve claims the name of a dictionary being built, so that it isn't
+// built twice concurrently
+func (s *service) reserve(name string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, exists := s.dicts[name]; exists {
+		return errDictExists(name)
+	}
+	if _, building := s.building[name]; building {
+		return errDictExists(name)
+	}
+	s.building[name] = struct{}{}
+	return nil
+}
+
+func (s *service) unreserve(name string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.building, name)
+}
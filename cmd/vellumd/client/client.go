@@ -0,0 +1,305 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client implements the bulk load endpoints of vellumd, streaming
+// the entries of an FST to the server, which builds it.
+//
+//	c := client.New("http://localhost:8080")
+//	data, err := c.Build(ctx, next)
+//
+// The entries are encoded as JSON strings, so keys must be valid UTF-8,
+// and others are rejected, rather than being sent altered.  With GRPC set,
+// the entries are streamed to the BuildFST method of the gRPC service
+// instead, in which keys are bytes.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/couchbase/vellum/cmd/vellumd/internal/wire"
+)
+
+// entriesPerMessage bounds the number of entries sent in each message of
+// a BuildFST call, and maxEntriesSize their size
+const (
+	entriesPerMessage = 1024
+	maxEntriesSize    = 1 << 20
+)
+
+// enableH2C is set where net/http can send HTTP/2 in plain text, for gRPC
+// calls to http URLs
+var enableH2C func(t *http.Transport)
+
+// NextFunc returns the entries to build an FST of, in key order, and
+// io.EOF after the last one.
+type NextFunc func() (key []byte, val uint64, err error)
+
+// Client is a client of a vellumd server.
+type Client struct {
+	// URL is the base URL of the server, such as http://localhost:8080
+	URL string
+	// HTTPClient is the client used for requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+
+	// GRPC selects the BuildFST method of the gRPC service for Build and
+	// Store.  It requires HTTP/2, over TLS for https URLs, or in plain text
+	// for http URLs, when built with Go 1.24 or later.  If HTTPClient is
+	// set, it must support HTTP/2.
+	GRPC bool
+
+	once       sync.Once
+	grpcClient *http.Client
+}
+
+// New returns a Client of the vellumd server at the base URL.
+func New(baseURL string) *Client {
+	return &Client{URL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Build streams the entries returned by next to the server, and returns
+// the FST it built.
+func (c *Client) Build(ctx context.Context, next NextFunc) ([]byte, error) {
+	if c.GRPC {
+		rv, _, err := c.buildFST(ctx, "", next)
+		return rv, err
+	}
+	resp, err := c.post(ctx, "/v1/build", next)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Store streams the entries returned by next to the server, which builds
+// an FST, stores it, and serves it as the named dictionary.  It returns
+// the number of entries.
+func (c *Client) Store(ctx context.Context, dict string,
+	next NextFunc) (int, error) {
+	if c.GRPC {
+		_, n, err := c.buildFST(ctx, dict, next)
+		return n, err
+	}
+	resp, err := c.post(ctx, "/v1/dicts/"+url.PathEscape(dict)+"/build", next)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var rv struct {
+		Keys int `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&rv)
+	if err != nil {
+		return 0, err
+	}
+	return rv.Keys, nil
+}
+
+// post streams the entries in the body of a request, as they are returned
+// by next, and returns the response if it succeeded
+func (c *Client) post(ctx context.Context, path string,
+	next NextFunc) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encode(pw, next))
+	}()
+	req, err := http.NewRequest(http.MethodPost, c.URL+path, pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	// stops the encoding if the server didn't read the whole body
+	_ = pr.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() { _ = resp.Body.Close() }()
+		var rv struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&rv)
+		return nil, fmt.Errorf("vellumd: %s: %s", resp.Status, rv.Error)
+	}
+	return resp, nil
+}
+
+// encode writes the entries returned by next as newline delimited JSON
+func encode(w io.Writer, next NextFunc) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for {
+		key, val, err := next()
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		if !utf8.Valid(key) {
+			return fmt.Errorf("key %q isn't valid UTF-8", key)
+		}
+		err = enc.Encode(struct {
+			Key   string `json:"key"`
+			Value uint64 `json:"value"`
+		}{string(key), val})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// buildFST streams the entries returned by next to the BuildFST method,
+// storing the FST as dict if it isn't empty, and returns the FST if it
+// isn't stored, and the number of entries.
+func (c *Client) buildFST(ctx context.Context, dict string,
+	next NextFunc) ([]byte, int, error) {
+	httpClient, err := c.grpcHTTPClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encodeFrames(pw, dict, next))
+	}()
+	req, err := http.NewRequest(http.MethodPost,
+		c.URL+"/vellumd.v1.Vellumd/BuildFST", pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := httpClient.Do(req)
+	// stops the encoding if the server didn't read all the requests
+	_ = pr.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("vellumd: %s", resp.Status)
+	}
+	msg, err := wire.ReadFrame(resp.Body, int(^uint(0)>>1))
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	// the trailers are read at the end of the body, and the status is in
+	// the headers of a response without messages
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	trailer := resp.Trailer
+	if msg == nil {
+		trailer = resp.Header
+	}
+	if status := trailer.Get("Grpc-Status"); status != "0" {
+		desc, _ := url.PathUnescape(trailer.Get("Grpc-Message"))
+		return nil, 0, fmt.Errorf("vellumd: grpc status %s: %s", status, desc)
+	}
+	var rv []byte
+	var n uint64
+	err = wire.ParseMessage(msg, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 2 && typ == wire.Varint:
+			n = v
+		case num == 3 && typ == wire.Bytes:
+			rv = b
+		}
+		return nil
+	})
+	return rv, int(n), err
+}
+
+// grpcHTTPClient returns the client used for gRPC calls, which supports
+// HTTP/2 if HTTPClient isn't set.
+func (c *Client) grpcHTTPClient() (*http.Client, error) {
+	if c.HTTPClient != nil {
+		return c.HTTPClient, nil
+	}
+	if strings.HasPrefix(c.URL, "http:") && enableH2C == nil {
+		return nil, errors.New("gRPC without TLS requires Go 1.24 or later")
+	}
+	c.once.Do(func() {
+		t := &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			ForceAttemptHTTP2: true,
+		}
+		if enableH2C != nil {
+			enableH2C(t)
+		}
+		c.grpcClient = &http.Client{Transport: t}
+	})
+	return c.grpcClient, nil
+}
+
+// encodeFrames writes the entries returned by next as BuildFST requests,
+// the first of which names the dictionary.
+func encodeFrames(w io.Writer, dict string, next NextFunc) error {
+	bw := bufio.NewWriter(w)
+	msg := wire.AppendBytesField(nil, 1, []byte(dict), false)
+	var e, frame []byte
+	var n int
+	flush := func() error {
+		frame = wire.AppendFrame(frame[:0], msg)
+		msg, n = msg[:0], 0
+		_, err := bw.Write(frame)
+		return err
+	}
+	for {
+		key, val, err := next()
+		if err == io.EOF {
+			if len(msg) > 0 {
+				err = flush()
+				if err != nil {
+					return err
+				}
+			}
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		e = wire.AppendBytesField(e[:0], 1, key, false)
+		e = wire.AppendVarintField(e, 2, val)
+		msg = wire.AppendBytesField(msg, 2, e, true)
+		n++
+		if n == entriesPerMessage || len(msg) >= maxEntriesSize {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -12,33 +12,18 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package regexp
+//go:build go1.24
+// +build go1.24
 
-import "testing"
+package client
 
-func TestSparse(t *testing.T) {
+import "net/http"
 
-	s := newSparseSet(10)
-	if s.Contains(0) {
-		t.Errorf("expected not to contain 0")
-	}
-
-	s.Add(3)
-	if !s.Contains(3) {
-		t.Errorf("expected to contains 3, did not")
-	}
-
-	if s.Len() != 1 {
-		t.Errorf("expected len 1, got %d", s.Len())
-	}
-
-	if s.Get(0) != 3 {
-		t.Errorf("expected 10, got %d", s.Get(0))
-	}
-
-	s.Clear()
-
-	if s.Len() != 0 {
-		t.Errorf("expected len 0, got %d", s.Len())
+func init() {
+	// without HTTP/1, http URLs are requested over unencrypted HTTP/2
+	enableH2C = func(t *http.Transport) {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
 	}
 }
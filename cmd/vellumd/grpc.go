@@ -0,0 +1,485 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/cmd/vellumd/internal/wire"
+	"github.com/couchbase/vellum/query"
+)
+
+// The gRPC transport serves the Vellumd service of vellumd.proto over the
+// HTTP/2 support of net/http, implementing the gRPC wire protocol: each
+// message is prefixed by a compression flag and its length, and the status
+// of the call is returned in the grpc-status and grpc-message trailers.
+
+const (
+	grpcContentType = "application/grpc"
+	grpcService     = "/vellumd.v1.Vellumd/"
+
+	// maxMessageSize bounds the size of the messages received, as the
+	// default of gRPC servers does
+	maxMessageSize = 4 << 20
+)
+
+// the gRPC status codes returned
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeUnknown           = 2
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codeNotFound          = 5
+	codeAlreadyExists     = 6
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+)
+
+// grpcError is an error returned with a specific gRPC status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e grpcError) Error() string {
+	return e.msg
+}
+
+// isGRPC returns whether the request is a gRPC call, rather than an HTTP
+// request for the JSON endpoints.
+func isGRPC(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	return r.ProtoMajor == 2 && r.Method == http.MethodPost &&
+		(ct == grpcContentType || strings.HasPrefix(ct, grpcContentType+"+") ||
+			strings.HasPrefix(ct, grpcContentType+";"))
+}
+
+// grpcStream reads the request messages and writes the response messages
+// of a gRPC call.
+type grpcStream struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	flusher http.Flusher
+	started bool
+	n       int
+	buf     []byte
+}
+
+// recv returns the next request message, or io.EOF after the last.
+func (s *grpcStream) recv() ([]byte, error) {
+	rv, err := wire.ReadFrame(s.r.Body, maxMessageSize)
+	switch {
+	case err == nil || err == io.EOF:
+		return rv, err
+	case errors.Is(err, wire.ErrCompressed):
+		return nil, grpcError{codeUnimplemented, err.Error()}
+	case errors.Is(err, wire.ErrMessageTooLarge):
+		return nil, grpcError{codeResourceExhausted, err.Error()}
+	}
+	// the error of its context if the call was canceled
+	if ctxErr := s.r.Context().Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err == io.ErrUnexpectedEOF {
+		return nil, grpcError{codeInternal, "truncated request message"}
+	}
+	return nil, err
+}
+
+// recvRequest returns the single request message of a unary or server
+// streaming call.
+func (s *grpcStream) recvRequest() ([]byte, error) {
+	rv, err := s.recv()
+	if err == io.EOF {
+		return nil, grpcError{codeInternal, "missing request message"}
+	}
+	return rv, err
+}
+
+// send writes a response message, flushing every flushEvery messages.
+func (s *grpcStream) send(msg []byte) error {
+	if !s.started {
+		s.w.Header().Set("Content-Type", grpcContentType)
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	s.buf = wire.AppendFrame(s.buf[:0], msg)
+	_, err := s.w.Write(s.buf)
+	s.n++
+	if err == nil && s.flusher != nil && s.n%flushEvery == 0 {
+		s.flusher.Flush()
+	}
+	return err
+}
+
+// finish ends the call with the status of err, in the trailers, or in the
+// headers if no message was sent.
+func (s *grpcStream) finish(err error) {
+	code, msg := codeOK, ""
+	if err != nil {
+		code, msg = codeFor(err), err.Error()
+	}
+	prefix := http.TrailerPrefix
+	if !s.started {
+		s.w.Header().Set("Content-Type", grpcContentType)
+		prefix = ""
+	}
+	s.w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		s.w.Header().Set(prefix+"Grpc-Message", encodeGRPCMessage(msg))
+	}
+	if !s.started {
+		s.w.WriteHeader(http.StatusOK)
+	}
+}
+
+// serveGRPC serves a call of a method of the Vellumd service.
+func (h *handler) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	s := &grpcStream{w: w, r: r}
+	s.flusher, _ = w.(http.Flusher)
+	ctx := r.Context()
+	if t := r.Header.Get("Grpc-Timeout"); t != "" {
+		timeout, ok := parseGRPCTimeout(t)
+		if !ok {
+			s.finish(grpcError{codeInternal, "invalid grpc-timeout " + t})
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, grpcService) {
+	case "List":
+		err = h.grpcList(s)
+	case "Get":
+		err = h.grpcGet(s)
+	case "Range":
+		err = h.grpcSearch(ctx, s, decodeRange)
+	case "Regexp":
+		err = h.grpcSearch(ctx, s, decodeRegexp)
+	case "Fuzzy":
+		err = h.grpcSearch(ctx, s, decodeFuzzy)
+	case "BuildFST":
+		err = h.grpcBuild(ctx, s)
+	default:
+		err = grpcError{codeUnimplemented, "unknown method " + r.URL.Path}
+	}
+	s.finish(err)
+}
+
+func (h *handler) grpcList(s *grpcStream) error {
+	_, err := s.recvRequest()
+	if err != nil {
+		return err
+	}
+	var rv []byte
+	for _, name := range h.svc.names() {
+		rv = wire.AppendBytesField(rv, 1, []byte(name), true)
+	}
+	return s.send(rv)
+}
+
+func (h *handler) grpcGet(s *grpcStream) error {
+	msg, err := s.recvRequest()
+	if err != nil {
+		return err
+	}
+	var name string
+	var key []byte
+	err = wire.ParseMessage(msg, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wire.Bytes:
+			name = string(b)
+		case num == 2 && typ == wire.Bytes:
+			key = b
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	val, exists, err := h.svc.Get(name, key)
+	if err != nil {
+		return err
+	}
+	var rv []byte
+	rv = wire.AppendVarintField(rv, 1, val)
+	if exists {
+		rv = wire.AppendVarintField(rv, 2, 1)
+	}
+	return s.send(rv)
+}
+
+// grpcBuild builds an FST of the entries of the BuildFST requests, as they
+// are received.  If the first request names a dictionary, it is stored and
+// served as the dictionary, otherwise it is returned.
+func (h *handler) grpcBuild(ctx context.Context, s *grpcStream) error {
+	var name string
+	var pending []entry
+	first := true
+	next := func() (entry, error) {
+		for len(pending) == 0 {
+			msg, err := s.recv()
+			if err != nil {
+				return entry{}, err
+			}
+			err = wire.ParseMessage(msg, func(num, typ int, v uint64,
+				b []byte) error {
+				switch {
+				case num == 1 && typ == wire.Bytes && first:
+					name = string(b)
+				case num == 2 && typ == wire.Bytes:
+					e, err := decodeEntry(b)
+					if err != nil {
+						return err
+					}
+					pending = append(pending, e)
+				}
+				return nil
+			})
+			if err != nil {
+				return entry{}, err
+			}
+			first = false
+		}
+		e := pending[0]
+		pending = pending[1:]
+		return e, nil
+	}
+
+	// the first request is received before building, for the name
+	e, err := next()
+	if err == nil {
+		pending = append([]entry{e}, pending...)
+	} else if err != io.EOF {
+		return err
+	}
+	var rv []byte
+	if name != "" {
+		n, err := h.svc.Store(ctx, name, next)
+		if err != nil {
+			return err
+		}
+		rv = wire.AppendBytesField(rv, 1, []byte(name), false)
+		rv = wire.AppendVarintField(rv, 2, uint64(n))
+		return s.send(rv)
+	}
+	var buf bytes.Buffer
+	n, err := h.svc.Build(ctx, &buf, next)
+	if err != nil {
+		return err
+	}
+	rv = wire.AppendVarintField(rv, 2, uint64(n))
+	rv = wire.AppendBytesField(rv, 3, buf.Bytes(), false)
+	return s.send(rv)
+}
+
+func decodeEntry(msg []byte) (entry, error) {
+	var rv entry
+	err := wire.ParseMessage(msg, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wire.Bytes:
+			rv.Key = string(b)
+		case num == 2 && typ == wire.Varint:
+			rv.Value = v
+		}
+		return nil
+	})
+	return rv, err
+}
+
+// searchRequest holds the fields of the requests of the search methods.
+type searchRequest struct {
+	name       string
+	q          *query.Query
+	start, end []byte
+	limit      int
+}
+
+// grpcSearch streams the entries found by a search method, of the request
+// decoded by decode.
+func (h *handler) grpcSearch(ctx context.Context, s *grpcStream,
+	decode func([]byte) (*searchRequest, error)) error {
+	msg, err := s.recvRequest()
+	if err != nil {
+		return err
+	}
+	req, err := decode(msg)
+	if err != nil {
+		return err
+	}
+	var rv []byte
+	return h.svc.Search(ctx, req.name, req.q, req.start, req.end, req.limit,
+		func(e entry) error {
+			rv = wire.AppendBytesField(rv[:0], 1, []byte(e.Key), false)
+			rv = wire.AppendVarintField(rv, 2, e.Value)
+			return s.send(rv)
+		})
+}
+
+// decodeRange decodes a RangeRequest, in which empty bounds are unbounded.
+func decodeRange(msg []byte) (*searchRequest, error) {
+	rv := &searchRequest{}
+	return rv, wire.ParseMessage(msg, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wire.Bytes:
+			rv.name = string(b)
+		case num == 2 && typ == wire.Bytes && len(b) > 0:
+			rv.start = b
+		case num == 3 && typ == wire.Bytes && len(b) > 0:
+			rv.end = b
+		case num == 4 && typ == wire.Varint:
+			rv.limit = limitFor(v)
+		}
+		return nil
+	})
+}
+
+func decodeRegexp(msg []byte) (*searchRequest, error) {
+	rv := &searchRequest{}
+	var expr string
+	err := wire.ParseMessage(msg, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wire.Bytes:
+			rv.name = string(b)
+		case num == 2 && typ == wire.Bytes:
+			expr = string(b)
+		case num == 3 && typ == wire.Varint:
+			rv.limit = limitFor(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rv.q = query.Regexp(expr)
+	return rv, nil
+}
+
+func decodeFuzzy(msg []byte) (*searchRequest, error) {
+	rv := &searchRequest{}
+	var term string
+	var distance uint64
+	err := wire.ParseMessage(msg, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wire.Bytes:
+			rv.name = string(b)
+		case num == 2 && typ == wire.Bytes:
+			term = string(b)
+		case num == 3 && typ == wire.Varint:
+			distance = v
+		case num == 4 && typ == wire.Varint:
+			rv.limit = limitFor(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if distance > 255 {
+		return nil, grpcError{codeInvalidArgument, "invalid distance"}
+	}
+	rv.q = query.Fuzzy(term, int(distance))
+	return rv, nil
+}
+
+// limitFor returns the limit of a search, in which 0 is unlimited, as it
+// is if the limit doesn't fit an int
+func limitFor(v uint64) int {
+	if v > uint64(^uint(0)>>1) {
+		return 0
+	}
+	return int(v)
+}
+
+func codeFor(err error) int {
+	var gerr grpcError
+	if errors.As(err, &gerr) {
+		return gerr.code
+	}
+	if errors.Is(err, context.Canceled) {
+		return codeCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codeDeadlineExceeded
+	}
+	var unknown errUnknownDict
+	if errors.As(err, &unknown) {
+		return codeNotFound
+	}
+	var exists errDictExists
+	if errors.As(err, &exists) {
+		return codeAlreadyExists
+	}
+	if errors.Is(err, wire.ErrInvalidMessage) ||
+		errors.Is(err, query.ErrInvalidQuery) ||
+		errors.Is(err, vellum.ErrOutOfOrder) || errors.Is(err, errInvalidName) {
+		return codeInvalidArgument
+	}
+	if errors.Is(err, errStoreDisabled) {
+		return codePermissionDenied
+	}
+	return codeUnknown
+}
+
+// parseGRPCTimeout parses the value of a grpc-timeout header, of up to 8
+// digits followed by a unit.
+func parseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[s[len(s)-1]]
+	return time.Duration(n) * unit, ok
+}
+
+// encodeGRPCMessage percent-encodes the status message, as required in the
+// grpc-message trailer.
+func encodeGRPCMessage(msg string) string {
+	var rv strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&rv, "%%%02X", c)
+		} else {
+			rv.WriteByte(c)
+		}
+	}
+	return rv.String()
+}
@@ -0,0 +1,376 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/cmd/vellumd/client"
+	"github.com/couchbase/vellum/cmd/vellumd/internal/wire"
+)
+
+// newGRPCTestServer returns a server of the service over HTTP/2, which
+// gRPC requires, and a client of it
+func newGRPCTestServer(t *testing.T, svc *service) (*httptest.Server,
+	*http.Client) {
+	srv := httptest.NewUnstartedServer(newHandler(svc))
+	srv.TLS = &tls.Config{NextProtos: []string{"h2"}}
+	srv.StartTLS()
+	c := srv.Client()
+	c.Transport.(*http.Transport).ForceAttemptHTTP2 = true
+	return srv, c
+}
+
+// grpcCall calls the method with the request messages, and returns the
+// response messages and the status of the call
+func grpcCall(t *testing.T, c *http.Client, srvURL, method string,
+	reqs ...[]byte) ([][]byte, int, string) {
+	var body []byte
+	for _, req := range reqs {
+		body = wire.AppendFrame(body, req)
+	}
+	hreq, err := http.NewRequest(http.MethodPost, srvURL+grpcService+method,
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hreq.Header.Set("Content-Type", grpcContentType)
+	hreq.Header.Set("TE", "trailers")
+	resp, err := c.Do(hreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 || resp.Header.Get("Content-Type") != grpcContentType {
+		t.Fatalf("%s: expected a gRPC response, got %s %s", method, resp.Proto,
+			resp.Header.Get("Content-Type"))
+	}
+	var msgs [][]byte
+	r := bytes.NewReader(data)
+	for {
+		msg, err := wire.ReadFrame(r, len(data))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: error reading response: %v", method, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	// the status is in the headers of a response without messages
+	trailer := resp.Trailer
+	if len(msgs) == 0 {
+		trailer = resp.Header
+	}
+	code, err := strconv.Atoi(trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("%s: invalid status: %v", method, err)
+	}
+	msg, err := url.PathUnescape(trailer.Get("Grpc-Message"))
+	if err != nil {
+		t.Fatalf("%s: invalid status message: %v", method, err)
+	}
+	return msgs, code, msg
+}
+
+// entryStrings decodes the Entry messages, as key=value
+func entryStrings(t *testing.T, msgs [][]byte) []string {
+	var rv []string
+	for _, msg := range msgs {
+		var key []byte
+		var val uint64
+		err := wire.ParseMessage(msg, func(num, typ int, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				key = b
+			case 2:
+				val = v
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rv = append(rv, fmt.Sprintf("%s=%d", key, val))
+	}
+	return rv
+}
+
+func TestGRPC(t *testing.T) {
+	svc := newTestService(t)
+	var buf bytes.Buffer
+	b, err := vellum.New(&buf, nil)
+	if err == nil {
+		err = b.Insert([]byte("a"), 1)
+	}
+	if err == nil {
+		err = b.Insert([]byte("b\xff"), 2)
+	}
+	if err == nil {
+		err = b.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	fst, err := vellum.Load(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.add("binary", fst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, c := newGRPCTestServer(t, svc)
+	defer srv.Close()
+
+	str := func(num int, s string) []byte {
+		return wire.AppendBytesField(nil, num, []byte(s), false)
+	}
+	num := func(num int, v uint64) []byte {
+		return wire.AppendVarintField(nil, num, v)
+	}
+	req := func(fields ...[]byte) []byte {
+		return bytes.Join(fields, nil)
+	}
+
+	msgs, code, msg := grpcCall(t, c, srv.URL, "List", nil)
+	if code != codeOK || len(msgs) != 1 ||
+		!bytes.Equal(msgs[0], req(str(1, "binary"), str(1, "words"))) {
+		t.Errorf("expected binary and words listed, got %q %d %s", msgs, code,
+			msg)
+	}
+	msgs, code, msg = grpcCall(t, c, srv.URL, "Get",
+		req(str(1, "words"), str(2, "car")))
+	if code != codeOK || len(msgs) != 1 ||
+		!bytes.Equal(msgs[0], req(num(1, 3), num(2, 1))) {
+		t.Errorf("expected car 3 found, got %x %d %s", msgs, code, msg)
+	}
+	msgs, code, msg = grpcCall(t, c, srv.URL, "Get",
+		req(str(1, "words"), str(2, "cat")))
+	if code != codeOK || len(msgs) != 1 || len(msgs[0]) != 0 {
+		t.Errorf("expected cat not found, got %x %d %s", msgs, code, msg)
+	}
+
+	tests := []struct {
+		method string
+		req    []byte
+		code   int
+		want   []string
+	}{
+		{
+			method: "Range",
+			req:    req(str(1, "words"), str(2, "baz"), str(3, "foo")),
+			want:   []string{"baz=2", "car=3"},
+		},
+		{
+			method: "Range",
+			req:    req(str(1, "words"), num(4, 1)),
+			want:   []string{"bar=1"},
+		},
+		{
+			method: "Range",
+			req:    req(str(1, "binary")),
+			want:   []string{"a=1", "b\xff=2"},
+		},
+		{
+			method: "Regexp",
+			req:    req(str(1, "words"), str(2, "fo+.*")),
+			want:   []string{"foo=4", "foobar=5"},
+		},
+		{
+			method: "Fuzzy",
+			req:    req(str(1, "words"), str(2, "bat"), num(3, 1)),
+			want:   []string{"bar=1", "baz=2"},
+		},
+		{
+			method: "Regexp",
+			req:    req(str(1, "words"), str(2, "a(")),
+			code:   codeInvalidArgument,
+		},
+		{
+			method: "Fuzzy",
+			req:    req(str(1, "words"), str(2, "bat"), num(3, 3)),
+			code:   codeInvalidArgument,
+		},
+		{
+			method: "Get",
+			req:    req(str(1, "nope"), str(2, "a")),
+			code:   codeNotFound,
+		},
+		{
+			method: "Get",
+			req:    []byte{0x0a, 0x10, 'a'},
+			code:   codeInvalidArgument,
+		},
+		{
+			method: "Nope",
+			code:   codeUnimplemented,
+		},
+	}
+	for _, test := range tests {
+		msgs, code, msg := grpcCall(t, c, srv.URL, test.method, test.req)
+		if code != test.code {
+			t.Errorf("%s %x: expected status %d, got %d (%s)", test.method,
+				test.req, test.code, code, msg)
+			continue
+		}
+		if got := entryStrings(t, msgs); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s %x: expected %q, got %q", test.method, test.req,
+				test.want, got)
+		}
+	}
+
+	// compressed messages aren't supported
+	hreq, err := http.NewRequest(http.MethodPost, srv.URL+grpcService+"List",
+		bytes.NewReader([]byte{1, 0, 0, 0, 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hreq.Header.Set("Content-Type", grpcContentType)
+	resp, err := c.Do(hreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.Header.Get("Grpc-Status") != strconv.Itoa(codeUnimplemented) {
+		t.Errorf("expected compressed message unimplemented, got %q",
+			resp.Header.Get("Grpc-Status"))
+	}
+
+	// the JSON endpoints are still served over HTTP/2
+	resp, err = c.Get(srv.URL + "/v1/dicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || resp.ProtoMajor != 2 ||
+		string(body) != `{"dicts":["binary","words"]}`+"\n" {
+		t.Errorf("expected dictionaries over HTTP/2, got %s %s %v", resp.Proto,
+			body, err)
+	}
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"1H":        time.Hour,
+		"90S":       90 * time.Second,
+		"250m":      250 * time.Millisecond,
+		"12345678n": 12345678,
+	} {
+		got, ok := parseGRPCTimeout(s)
+		if !ok || got != want {
+			t.Errorf("%s: expected %v, got %v %t", s, want, got, ok)
+		}
+	}
+	for _, s := range []string{"", "1", "S", "123456789S", "-1S", "1X"} {
+		if _, ok := parseGRPCTimeout(s); ok {
+			t.Errorf("%s: expected invalid timeout", s)
+		}
+	}
+	if got := encodeGRPCMessage("100% é\n"); got != "100%25 %C3%A9%0A" {
+		t.Errorf("expected percent-encoded message, got %s", got)
+	}
+}
+
+func TestGRPCBuild(t *testing.T) {
+	svc := newService()
+	defer svc.Close()
+	srv, hc := newGRPCTestServer(t, svc)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	c.HTTPClient = hc
+	c.GRPC = true
+	ctx := context.Background()
+
+	// more entries than fit a single request, and keys which aren't UTF-8
+	keys := make([]string, 3000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%04d\xff", i)
+	}
+	data, err := c.Build(ctx, entries(keys...))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	fst, err := vellum.Load(data)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if fst.Len() != len(keys) {
+		t.Errorf("expected %d keys, got %d", len(keys), fst.Len())
+	}
+	val, exists, err := fst.Get([]byte(keys[2500]))
+	if err != nil || !exists || val != 2500 {
+		t.Errorf("expected %q 2500, got %d %t %v", keys[2500], val, exists, err)
+	}
+	data, err = c.Build(ctx, entries())
+	if err != nil {
+		t.Fatalf("error building without entries: %v", err)
+	}
+	fst, err = vellum.Load(data)
+	if err != nil || fst.Len() != 0 {
+		t.Errorf("expected an empty fst, got %v", err)
+	}
+
+	_, err = c.Build(ctx, entries("foo", "bar"))
+	if err == nil || !strings.Contains(err.Error(),
+		"status "+strconv.Itoa(codeInvalidArgument)) {
+		t.Errorf("expected invalid argument for keys out of order, got %v", err)
+	}
+	_, err = c.Store(ctx, "words", entries("bar"))
+	if err == nil || !strings.Contains(err.Error(),
+		"status "+strconv.Itoa(codePermissionDenied)) {
+		t.Errorf("expected permission denied without data directory, got %v",
+			err)
+	}
+
+	svc.dataDir, err = ioutil.TempDir("", "vellumd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(svc.dataDir) }()
+	n, err := c.Store(ctx, "words", entries("bar", "car\xff"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 keys stored, got %d %v", n, err)
+	}
+	val, exists, err = svc.Get("words", []byte("car\xff"))
+	if err != nil || !exists || val != 1 {
+		t.Errorf("expected car 1 served, got %d %t %v", val, exists, err)
+	}
+	_, err = c.Store(ctx, "words", entries("bar"))
+	if err == nil || !strings.Contains(err.Error(),
+		"status "+strconv.Itoa(codeAlreadyExists)) {
+		t.Errorf("expected already exists storing words again, got %v", err)
+	}
+}
@@ -12,43 +12,18 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package regexp
+//go:build go1.24
+// +build go1.24
 
-type sparseSet struct {
-	dense  []uint
-	sparse []uint
-	size   uint
-}
-
-func newSparseSet(size uint) *sparseSet {
-	return &sparseSet{
-		dense:  make([]uint, size),
-		sparse: make([]uint, size),
-		size:   0,
-	}
-}
-
-func (s *sparseSet) Len() int {
-	return int(s.size)
-}
+package main
 
-func (s *sparseSet) Add(ip uint) uint {
-	i := s.size
-	s.dense[i] = ip
-	s.sparse[ip] = i
-	s.size++
-	return i
-}
-
-func (s *sparseSet) Get(i uint) uint {
-	return s.dense[i]
-}
+import "net/http"
 
-func (s *sparseSet) Contains(ip uint) bool {
-	i := s.sparse[ip]
-	return i < s.size && s.dense[i] == ip
-}
-
-func (s *sparseSet) Clear() {
-	s.size = 0
+func init() {
+	enableH2C = func(srv *http.Server) {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
 }
@@ -0,0 +1,272 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/query"
+)
+
+// flushEvery is the number of streamed entries between flushes.
+const flushEvery = 64
+
+type handler struct {
+	svc *service
+}
+
+func newHandler(svc *service) http.Handler {
+	return &handler{svc: svc}
+}
+
+// errBadEntry is returned for an entry of a build request which isn't
+// valid JSON.
+type errBadEntry struct {
+	err error
+}
+
+func (e errBadEntry) Error() string {
+	return fmt.Sprintf("invalid entry: %v", e.err)
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isGRPC(r) {
+		h.serveGRPC(w, r)
+		return
+	}
+	if r.Method == http.MethodPost {
+		h.build(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("only GET and POST are supported"))
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/dicts")
+	if path == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	if path == "" || path == "/" {
+		writeJSON(w, map[string][]string{"dicts": h.svc.names()})
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	name, op := parts[0], parts[1]
+	params := r.URL.Query()
+	switch op {
+	case "get":
+		key := params.Get("key")
+		val, exists, err := h.svc.Get(name, []byte(key))
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, struct {
+			Key   string `json:"key"`
+			Value uint64 `json:"value"`
+			Found bool   `json:"found"`
+		}{key, val, exists})
+	case "range":
+		var start, end []byte
+		if params.Get("start") != "" {
+			start = []byte(params.Get("start"))
+		}
+		if params.Get("end") != "" {
+			end = []byte(params.Get("end"))
+		}
+		h.stream(w, r, name, nil, start, end)
+	case "regexp":
+		h.stream(w, r, name, query.Regexp(params.Get("expr")), nil, nil)
+	case "fuzzy":
+		distance, err := strconv.Atoi(params.Get("distance"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid distance"))
+			return
+		}
+		h.stream(w, r, name, query.Fuzzy(params.Get("term"), distance), nil, nil)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// stream writes the search results as newline delimited JSON.  Errors
+// detected before anything is written are reported with an error status,
+// later ones as a final error object.
+func (h *handler) stream(w http.ResponseWriter, r *http.Request, name string,
+	q *query.Query, start, end []byte) {
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+	}
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	var n int
+	err := h.svc.Search(r.Context(), name, q, start, end, limit, func(e entry) error {
+		if !utf8.ValidString(e.Key) {
+			return fmt.Errorf("key %q isn't valid UTF-8", e.Key)
+		}
+		if n == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		n++
+		err := enc.Encode(e)
+		if err == nil && flusher != nil && n%flushEvery == 0 {
+			flusher.Flush()
+		}
+		return err
+	})
+	if err != nil {
+		if n == 0 {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		_ = enc.Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if n == 0 {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+}
+
+// build builds an FST of the newline delimited JSON entries of the request
+// body, in key order.  POSTed to /v1/build, the FST is returned, to
+// /v1/dicts/{dict}/build, it is stored and served as the dictionary.
+func (h *handler) build(w http.ResponseWriter, r *http.Request) {
+	var name string
+	if r.URL.Path != "/v1/build" {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/dicts/"), "/")
+		if !strings.HasPrefix(r.URL.Path, "/v1/dicts/") || len(parts) != 2 ||
+			parts[1] != "build" {
+			http.NotFound(w, r)
+			return
+		}
+		name = parts[0]
+	}
+	dec := json.NewDecoder(r.Body)
+	next := func() (entry, error) {
+		// the key is checked before it is decoded, as invalid UTF-8 is
+		// decoded as U+FFFD
+		var e struct {
+			Key   json.RawMessage `json:"key"`
+			Value uint64          `json:"value"`
+		}
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return entry{}, err
+		}
+		if err == nil && !utf8.Valid(e.Key) {
+			err = fmt.Errorf("key %q isn't valid UTF-8", []byte(e.Key))
+		}
+		var key string
+		if err == nil && e.Key != nil {
+			err = json.Unmarshal(e.Key, &key)
+		}
+		if err != nil {
+			return entry{}, errBadEntry{err: err}
+		}
+		return entry{Key: key, Value: e.Value}, nil
+	}
+	if name != "" {
+		n, err := h.svc.Store(r.Context(), name, next)
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, struct {
+			Dict string `json:"dict"`
+			Keys int    `json:"keys"`
+		}{name, n})
+		return
+	}
+
+	// the FST is spooled, so that errors in the entries are reported with
+	// an error status, rather than after part of the FST
+	f, err := ioutil.TempFile("", "vellumd-build-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	_, err = h.svc.Build(r.Context(), f, next)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, _ = io.Copy(w, f)
+}
+
+func statusFor(err error) int {
+	var unknown errUnknownDict
+	if errors.As(err, &unknown) {
+		return http.StatusNotFound
+	}
+	var exists errDictExists
+	if errors.As(err, &exists) {
+		return http.StatusConflict
+	}
+	var bad errBadEntry
+	if errors.As(err, &bad) || errors.Is(err, query.ErrInvalidQuery) ||
+		errors.Is(err, vellum.ErrOutOfOrder) || errors.Is(err, errInvalidName) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, errStoreDisabled) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,275 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/cmd/vellumd/client"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(newHandler(newTestService(t)))
+}
+
+// newTestService returns a service serving the words dictionary
+func newTestService(t *testing.T) *service {
+	var buf bytes.Buffer
+	b, err := vellum.New(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range []string{"bar", "baz", "car", "foo", "foobar"} {
+		err = b.Insert([]byte(k), uint64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fst, err := vellum.Load(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := newService()
+	err = svc.add("words", fst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestHTTP(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{
+			path:   "/v1/dicts",
+			status: http.StatusOK,
+			want:   `{"dicts":["words"]}` + "\n",
+		},
+		{
+			path:   "/v1/dicts/words/get?key=car",
+			status: http.StatusOK,
+			want:   `{"key":"car","value":3,"found":true}` + "\n",
+		},
+		{
+			path:   "/v1/dicts/words/get?key=cat",
+			status: http.StatusOK,
+			want:   `{"key":"cat","value":0,"found":false}` + "\n",
+		},
+		{
+			path:   "/v1/dicts/words/range?start=baz&end=foo",
+			status: http.StatusOK,
+			want:   `{"key":"baz","value":2}` + "\n" + `{"key":"car","value":3}` + "\n",
+		},
+		{
+			path:   "/v1/dicts/words/range?limit=1",
+			status: http.StatusOK,
+			want:   `{"key":"bar","value":1}` + "\n",
+		},
+		{
+			path:   "/v1/dicts/words/regexp?expr=fo%2B.*",
+			status: http.StatusOK,
+			want:   `{"key":"foo","value":4}` + "\n" + `{"key":"foobar","value":5}` + "\n",
+		},
+		{
+			path:   "/v1/dicts/words/fuzzy?term=bat&distance=1",
+			status: http.StatusOK,
+			want:   `{"key":"bar","value":1}` + "\n" + `{"key":"baz","value":2}` + "\n",
+		},
+		{
+			path:   "/v1/dicts/words/regexp?expr=a(",
+			status: http.StatusBadRequest,
+		},
+		{
+			path:   "/v1/dicts/words/fuzzy?term=bat&distance=x",
+			status: http.StatusBadRequest,
+		},
+		{
+			path:   "/v1/dicts/nope/get?key=a",
+			status: http.StatusNotFound,
+		},
+		{
+			path:   "/v1/dicts/words/nope",
+			status: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(srv.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d (%s)", test.path,
+				test.status, resp.StatusCode, body)
+			continue
+		}
+		if test.want != "" && string(body) != test.want {
+			t.Errorf("%s: expected %q, got %q", test.path, test.want, body)
+		}
+	}
+}
+
+// entries returns a client.NextFunc returning the keys, with their index
+// as value
+func entries(keys ...string) client.NextFunc {
+	var i int
+	return func() ([]byte, uint64, error) {
+		if i == len(keys) {
+			return nil, 0, io.EOF
+		}
+		i++
+		return []byte(keys[i-1]), uint64(i - 1), nil
+	}
+}
+
+func TestHTTPBuild(t *testing.T) {
+	svc := newService()
+	defer svc.Close()
+	srv := httptest.NewServer(newHandler(svc))
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	data, err := c.Build(ctx, entries("bar", "baz", "foo"))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	fst, err := vellum.Load(data)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	val, exists, err := fst.Get([]byte("baz"))
+	if err != nil || !exists || val != 1 {
+		t.Errorf("expected baz 1, got %d %t %v", val, exists, err)
+	}
+	if fst.Len() != 3 {
+		t.Errorf("expected 3 keys, got %d", fst.Len())
+	}
+
+	_, err = c.Build(ctx, entries("foo", "bar"))
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected bad request for keys out of order, got %v", err)
+	}
+	_, err = c.Store(ctx, "words", entries("bar"))
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected forbidden without data directory, got %v", err)
+	}
+
+	svc.dataDir, err = ioutil.TempDir("", "vellumd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(svc.dataDir) }()
+	n, err := c.Store(ctx, "words", entries("bar", "car"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 keys stored, got %d %v", n, err)
+	}
+	val, exists, err = svc.Get("words", []byte("car"))
+	if err != nil || !exists || val != 1 {
+		t.Errorf("expected car 1 served, got %d %t %v", val, exists, err)
+	}
+	_, err = os.Stat(filepath.Join(svc.dataDir, "words.fst"))
+	if err != nil {
+		t.Errorf("expected words.fst stored: %v", err)
+	}
+	_, err = c.Store(ctx, "words", entries("bar"))
+	if err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("expected conflict storing words again, got %v", err)
+	}
+	_, err = c.Store(ctx, "broken", entries("foo", "bar"))
+	if err == nil {
+		t.Errorf("expected error storing keys out of order")
+	}
+	_, err = svc.dict("broken")
+	if err == nil {
+		t.Errorf("expected broken dictionary not to be served")
+	}
+	tmps, _ := filepath.Glob(filepath.Join(svc.dataDir, ".*"))
+	if len(tmps) != 0 {
+		t.Errorf("expected temporary files removed, got %v", tmps)
+	}
+
+	resp, err := http.Post(srv.URL+"/v1/build", "application/x-ndjson",
+		strings.NewReader("{\"key\":\"a\",\"value\":1}\nnot json\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for invalid entry, got %d",
+			resp.StatusCode)
+	}
+
+	// keys which aren't valid UTF-8 can't be sent as JSON strings
+	_, err = c.Build(ctx, entries("a", "b\xff"))
+	if err == nil || !strings.Contains(err.Error(), "UTF-8") {
+		t.Errorf("expected invalid key rejected by the client, got %v", err)
+	}
+	resp, err = http.Post(srv.URL+"/v1/build", "application/x-ndjson",
+		strings.NewReader("{\"key\":\"b\xff\",\"value\":1}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for invalid key, got %d",
+			resp.StatusCode)
+	}
+	next := entries("a", "b\xff")
+	_, err = svc.Store(ctx, "binary", func() (entry, error) {
+		key, val, err := next()
+		return entry{Key: string(key), Value: val}, err
+	})
+	if err != nil {
+		t.Fatalf("error storing: %v", err)
+	}
+	resp, err = http.Get(srv.URL + "/v1/dicts/binary/range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(body), `{"key":"a","value":0}`+"\n") ||
+		!strings.Contains(string(body), "UTF-8") {
+		t.Errorf("expected the invalid key to end the results, got %s", body)
+	}
+}
@@ -0,0 +1,158 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wire encodes the messages of the gRPC service of vellumd in the
+// protocol buffers wire format, and frames them as gRPC messages.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The messages of vellumd.proto are few and flat, so they are encoded
+// directly, rather than with generated code, which would make vellum depend
+// on the protobuf runtime.
+
+// The wire types of the fields of the messages.
+const (
+	Varint  = 0
+	Fixed64 = 1
+	Bytes   = 2
+	Fixed32 = 5
+)
+
+// ErrInvalidMessage is returned for a message which can't be parsed.
+var ErrInvalidMessage = errors.New("invalid protobuf message")
+
+// ErrCompressed is returned by ReadFrame for a compressed message, as
+// compression isn't supported.
+var ErrCompressed = errors.New("compressed messages are not supported")
+
+// ErrMessageTooLarge is matched (using errors.Is) by the error returned by
+// ReadFrame for a message larger than the limit.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// AppendFrame appends msg to dst, prefixed by its length, as a gRPC
+// message.
+func AppendFrame(dst, msg []byte) []byte {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	return append(append(dst, prefix[:]...), msg...)
+}
+
+// ReadFrame reads a gRPC message of up to max bytes, returning io.EOF if r
+// ends before it, and io.ErrUnexpectedEOF if it ends in it.
+func ReadFrame(r io.Reader, max int) ([]byte, error) {
+	var prefix [5]byte
+	_, err := io.ReadFull(r, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, ErrCompressed
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if uint64(n) > uint64(max) {
+		return nil, fmt.Errorf("%w: %d bytes, larger than %d",
+			ErrMessageTooLarge, n, max)
+	}
+	rv := make([]byte, n)
+	_, err = io.ReadFull(r, rv)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// AppendVarintField appends field num with the varint v, omitted if zero,
+// as proto3 does for the default value
+func AppendVarintField(dst []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return dst
+	}
+	dst = appendUvarint(dst, uint64(num)<<3|Varint)
+	return appendUvarint(dst, v)
+}
+
+// AppendBytesField appends field num with the bytes b, omitted if empty
+// unless the field is repeated
+func AppendBytesField(dst []byte, num int, b []byte, repeated bool) []byte {
+	if len(b) == 0 && !repeated {
+		return dst
+	}
+	dst = appendUvarint(dst, uint64(num)<<3|Bytes)
+	dst = appendUvarint(dst, uint64(len(b)))
+	return append(dst, b...)
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+// ParseMessage calls fn with each field of the message, with its varint
+// value or its bytes depending on its wire type.  Fields of other wire
+// types are skipped.
+func ParseMessage(msg []byte, fn func(num, typ int, v uint64,
+	b []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 || tag>>3 == 0 {
+			return ErrInvalidMessage
+		}
+		msg = msg[n:]
+		num, typ := int(tag>>3), int(tag&7)
+		var v uint64
+		var b []byte
+		switch typ {
+		case Varint:
+			v, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return ErrInvalidMessage
+			}
+			msg = msg[n:]
+		case Bytes:
+			v, n = binary.Uvarint(msg)
+			if n <= 0 || v > uint64(len(msg)-n) {
+				return ErrInvalidMessage
+			}
+			b = msg[n : n+int(v)]
+			msg = msg[n+int(v):]
+		case Fixed64, Fixed32:
+			size := 8
+			if typ == Fixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return ErrInvalidMessage
+			}
+			msg = msg[size:]
+			continue
+		default:
+			return ErrInvalidMessage
+		}
+		err := fn(num, typ, v, b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
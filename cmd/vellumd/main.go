@@ -0,0 +1,141 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command vellumd serves read-only vellum FST dictionaries over HTTP/JSON
+// and gRPC, so that teams can share dictionaries without linking the Go
+// library into every consumer.
+//
+//	vellumd --addr :8080 --fst words=/data/words.fst --fst names=/data/names.fst
+//
+// The endpoints, all GET, are:
+//
+//	/v1/dicts                                         list the dictionaries
+//	/v1/dicts/{dict}/get?key=k                        look up a single key
+//	/v1/dicts/{dict}/range?start=a&end=b&limit=n      keys in [start, end)
+//	/v1/dicts/{dict}/regexp?expr=e&limit=n            keys matching a regexp
+//	/v1/dicts/{dict}/fuzzy?term=t&distance=d&limit=n  keys within an edit distance
+//
+// The range, regexp and fuzzy endpoints stream their results as newline
+// delimited JSON objects ({"key":"k","value":1}), in key order, followed by
+// a final {"error":"..."} object if the request fails part way.  As JSON
+// strings can't hold arbitrary bytes, keys which aren't valid UTF-8 are
+// rejected, both in the results and in the entries to build.
+//
+// Data pipelines in other languages can build vellum files by streaming
+// their entries, in the same format and in key order, in the body of a
+// POST to the bulk load endpoints:
+//
+//	/v1/build                                         returns the built FST
+//	/v1/dicts/{dict}/build                            stores and serves it
+//
+// Built dictionaries are stored as {dict}.fst in the --data-dir directory,
+// and served again when vellumd restarts.  The entries are inserted as
+// they arrive, so the input is never held in memory.  The client package
+// implements the bulk load for Go programs.
+//
+// The dictionaries are also served over gRPC, on the same address, as the
+// Vellumd service of vellumd.proto, from which clients can be generated in
+// any language.  The range, regexp and fuzzy methods stream their results,
+// and the client streaming BuildFST method bulk loads, returning or storing
+// the FST.  Keys are bytes, so they needn't be valid UTF-8.  gRPC requires
+// HTTP/2, which is served over TLS with --tls-cert and --tls-key, and in
+// plain text when vellumd is built with Go 1.24 or later.
+//
+// The service implementation is independent of the transports, which
+// only decode the requests and encode the results.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/couchbase/vellum"
+	"github.com/spf13/cobra"
+)
+
+var addr string
+var fstPaths []string
+var dataDir string
+var tlsCert, tlsKey string
+
+// enableH2C is set where net/http can serve HTTP/2 in plain text, for gRPC
+// clients not using TLS
+var enableH2C func(srv *http.Server)
+
+var rootCmd = &cobra.Command{
+	Use:   "vellumd",
+	Short: "Serve vellum FST dictionaries over HTTP/JSON and gRPC",
+	Long:  `Serve vellum FST dictionaries over HTTP/JSON and gRPC.  Each dictionary is specified as name=path.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		svc := newService()
+		defer svc.Close()
+		if dataDir != "" {
+			stored, err := filepath.Glob(filepath.Join(dataDir, "*.fst"))
+			if err != nil {
+				return err
+			}
+			for _, path := range stored {
+				fstPaths = append(fstPaths, strings.TrimSuffix(
+					filepath.Base(path), ".fst")+"="+path)
+			}
+			svc.dataDir = dataDir
+		}
+		for _, spec := range fstPaths {
+			eq := strings.IndexByte(spec, '=')
+			if eq <= 0 {
+				return fmt.Errorf("invalid dictionary %q, expected name=path", spec)
+			}
+			fst, err := vellum.Open(spec[eq+1:])
+			if err != nil {
+				return err
+			}
+			err = svc.add(spec[:eq], fst)
+			if err != nil {
+				_ = fst.Close()
+				return err
+			}
+		}
+		if (tlsCert == "") != (tlsKey == "") {
+			return fmt.Errorf("both --tls-cert and --tls-key are required")
+		}
+		srv := &http.Server{Addr: addr, Handler: newHandler(svc)}
+		if enableH2C != nil {
+			enableH2C(srv)
+		}
+		log.Printf("serving %d dictionaries on %s", len(fstPaths), addr)
+		if tlsCert != "" {
+			return srv.ListenAndServeTLS(tlsCert, tlsKey)
+		}
+		return srv.ListenAndServe()
+	},
+}
+
+func init() {
+	rootCmd.Flags().StringVar(&addr, "addr", ":8080", "bind address")
+	rootCmd.Flags().StringArrayVar(&fstPaths, "fst", nil, "dictionary to serve, as name=path (repeatable)")
+	rootCmd.Flags().StringVar(&dataDir, "data-dir", "", "directory of the dictionaries built by the service, served on start")
+	rootCmd.Flags().StringVar(&tlsCert, "tls-cert", "", "certificate file, to serve over TLS")
+	rootCmd.Flags().StringVar(&tlsKey, "tls-key", "", "private key file of the certificate")
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
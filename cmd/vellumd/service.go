@@ -0,0 +1,150 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/query"
+)
+
+// errUnknownDict is returned for requests naming a dictionary which isn't
+// being served.
+type errUnknownDict string
+
+func (e errUnknownDict) Error() string {
+	return fmt.Sprintf("unknown dictionary %q", string(e))
+}
+
+// entry is a single key/value pair returned by the service.
+type entry struct {
+	Key   string `json:"key"`
+	Value uint64 `json:"value"`
+}
+
+// service implements the dictionary operations, independently of the
+// transport used to expose them.  It is safe for concurrent use.
+type service struct {
+	m     sync.RWMutex
+	dicts map[string]*vellum.FST
+	// building holds the names of the dictionaries being stored
+	building map[string]struct{}
+
+	// dataDir is where the dictionaries built by the service are stored,
+	// storing them is disabled if empty
+	dataDir string
+}
+
+func newService() *service {
+	return &service{
+		dicts:    make(map[string]*vellum.FST),
+		building: make(map[string]struct{}),
+	}
+}
+
+func (s *service) add(name string, fst *vellum.FST) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, exists := s.dicts[name]; exists {
+		return fmt.Errorf("duplicate dictionary %q", name)
+	}
+	s.dicts[name] = fst
+	return nil
+}
+
+func (s *service) dict(name string) (*vellum.FST, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	fst, ok := s.dicts[name]
+	if !ok {
+		return nil, errUnknownDict(name)
+	}
+	return fst, nil
+}
+
+// names returns the names of the dictionaries, in order.
+func (s *service) names() []string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	rv := make([]string, 0, len(s.dicts))
+	for name := range s.dicts {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Get looks up a single key.
+func (s *service) Get(name string, key []byte) (uint64, bool, error) {
+	fst, err := s.dict(name)
+	if err != nil {
+		return 0, false, err
+	}
+	return fst.Get(key)
+}
+
+// Search streams the keys between start (inclusive) and end (exclusive)
+// matching the query (a nil query matches all keys) to the callback, up to
+// limit keys if limit is greater than zero.  It stops early, returning the
+// error, if the callback fails or the context is done.
+func (s *service) Search(ctx context.Context, name string, q *query.Query,
+	start, end []byte, limit int, cb func(entry) error) error {
+	fst, err := s.dict(name)
+	if err != nil {
+		return err
+	}
+	var aut vellum.Automaton
+	if q != nil {
+		aut, err = q.Compile()
+		if err != nil {
+			return err
+		}
+	}
+	itr, err := fst.Search(aut, start, end)
+	if itr != nil {
+		defer func() { _ = itr.Close() }()
+	}
+	for n := 0; err == nil && (limit <= 0 || n < limit); n++ {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		k, v := itr.Current()
+		err = cb(entry{Key: string(k), Value: v})
+		if err != nil {
+			return err
+		}
+		err = itr.Next()
+	}
+	if err != nil && !errors.Is(err, vellum.ErrIteratorDone) {
+		return err
+	}
+	return nil
+}
+
+// Close closes all the dictionaries.
+func (s *service) Close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for name, fst := range s.dicts {
+		_ = fst.Close()
+		delete(s.dicts, name)
+	}
+}
@@ -0,0 +1,138 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The golden FSTs of testdata/upstream were built by the upstream
+// couchbase/vellum, see gen.go there.  This library must read them
+// identically, and build them byte for byte with the default options, so
+// that upstream reads its files, and users can switch between the two
+// without rebuilding their FSTs.
+
+func loadDataset(t *testing.T, path string) []KV {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("error opening dataset: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	var rv []KV
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		sp := strings.LastIndexByte(line, ' ')
+		key, err := strconv.Unquote(line[:sp])
+		if err != nil {
+			t.Fatalf("error parsing key %s: %v", line, err)
+		}
+		val, err := strconv.ParseUint(line[sp+1:], 10, 64)
+		if err != nil {
+			t.Fatalf("error parsing value %s: %v", line, err)
+		}
+		rv = append(rv, KV{Key: key, Val: val})
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatalf("error reading dataset: %v", err)
+	}
+	return rv
+}
+
+func TestUpstreamCompatibility(t *testing.T) {
+	datasets, err := filepath.Glob(filepath.Join("testdata", "upstream", "*.txt"))
+	if err != nil || len(datasets) == 0 {
+		t.Fatalf("no datasets found: %v", err)
+	}
+	for _, dataset := range datasets {
+		kvs := loadDataset(t, dataset)
+		path := strings.TrimSuffix(dataset, ".txt") + ".fst"
+		golden, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("error reading golden fst: %v", err)
+		}
+
+		// reading upstream files, from memory and mmap'd
+		loaded, err := Load(golden)
+		if err != nil {
+			t.Fatalf("%s: error loading: %v", path, err)
+		}
+		opened, err := Open(path)
+		if err != nil {
+			t.Fatalf("%s: error opening: %v", path, err)
+		}
+		for _, fst := range []*FST{loaded, opened} {
+			checkDataset(t, path, fst, kvs)
+		}
+		err = opened.Close()
+		if err != nil {
+			t.Fatalf("%s: error closing: %v", path, err)
+		}
+
+		// writing files upstream reads, as it wrote them
+		var buf bytes.Buffer
+		b, err := New(&buf, nil)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		for _, kv := range kvs {
+			err = b.Insert([]byte(kv.Key), kv.Val)
+			if err != nil {
+				t.Fatalf("%s: error inserting %q: %v", path, kv.Key, err)
+			}
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("%s: error closing builder: %v", path, err)
+		}
+		if !bytes.Equal(buf.Bytes(), golden) {
+			t.Errorf("%s: built %d bytes differing from the %d golden ones",
+				path, buf.Len(), len(golden))
+		}
+	}
+}
+
+func checkDataset(t *testing.T, path string, fst *FST, kvs []KV) {
+	if fst.Len() != len(kvs) {
+		t.Errorf("%s: expected %d keys, got %d", path, len(kvs), fst.Len())
+	}
+	for _, kv := range kvs {
+		val, exists, err := fst.Get([]byte(kv.Key))
+		if err != nil || !exists || val != kv.Val {
+			t.Errorf("%s: expected %q %d, got %d %t %v", path, kv.Key,
+				kv.Val, val, exists, err)
+		}
+	}
+	var i int
+	itr, err := fst.Iterator(nil, nil)
+	for err == nil {
+		key, val := itr.Current()
+		if i >= len(kvs) || string(key) != kvs[i].Key || val != kvs[i].Val {
+			t.Fatalf("%s: unexpected entry %d %q %d", path, i, key, val)
+		}
+		i++
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone || i != len(kvs) {
+		t.Errorf("%s: expected %d entries, got %d %v", path, len(kvs), i, err)
+	}
+}
@@ -0,0 +1,702 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Compressed FSTs store their states in blocks, each compressed on its
+// own, so that reading a state only requires decompressing its block,
+// which is then cached.  Blocks end between states, once they reach the
+// block size, so no state is split across blocks.  The sections, section
+// table and footer follow the blocks uncompressed.  The file is laid out
+// as:
+//
+//	8 bytes magic "vellumz1"
+//	16 bytes header of the uncompressed FST
+//	compressed blocks...
+//	section data, section table and footer of the uncompressed FST
+//	for each block: 8 bytes end address, 8 bytes end offset of the
+//	  compressed block
+//	compressor name
+//	8 bytes length of the compressor name
+//	8 bytes number of blocks
+//	8 bytes offset of the uncompressed section data
+//	8 bytes offset of the block ends
+//
+// all uint64 little-endian.  The first block starts at address 0, with
+// the header, the last ends where the states do.
+
+const compressedMagic = "vellumz1"
+
+const compressedTrailerSize = 4 * 8
+
+const compressedBlockEntrySize = 16
+
+// DefaultCompressionBlockSize is the size of the blocks of states
+// compressed when BuilderOpts.CompressionBlockSize is zero.
+const DefaultCompressionBlockSize = 64 << 10
+
+// MinCompressionBlockSize is the smallest BuilderOpts.CompressionBlockSize.
+const MinCompressionBlockSize = 4 << 10
+
+// DefaultBlockCacheBudget is the memory the decompressed blocks of a
+// compressed FST are cached in, unless WithBlockCache is used.
+const DefaultBlockCacheBudget = 16 << 20
+
+// ErrUnknownCompressor is returned when building or reading an FST
+// compressed with a Compressor which isn't registered.
+var ErrUnknownCompressor = errors.New("unknown compressor")
+
+// Compressor compresses the blocks of states of FSTs built with
+// BuilderOpts.Compression.  Compressors are registered with
+// RegisterCompressor, and recorded by name in the FSTs.  They must be safe
+// for concurrent use.  The flate compressor, DEFLATE from compress/flate,
+// is always registered, others such as LZ4, Snappy or zstd can be
+// registered by applications, adapting their implementations.
+type Compressor interface {
+	// Name identifies the compressor, it must not change
+	Name() string
+
+	// Compress appends the compressed src to dst
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed src to dst
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var compressorsLock sync.RWMutex
+var compressors = map[string]Compressor{}
+
+func init() {
+	_ = RegisterCompressor(flateCompressor{})
+}
+
+// RegisterCompressor registers the Compressor under its name, which must
+// not already have one.
+func RegisterCompressor(c Compressor) error {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	if _, ok := compressors[c.Name()]; ok {
+		return fmt.Errorf("compressor %q already registered", c.Name())
+	}
+	compressors[c.Name()] = c
+	return nil
+}
+
+func loadCompressor(name string) (Compressor, error) {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	if c, ok := compressors[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownCompressor, name)
+}
+
+// compressorNames returns the names of the registered compressors, sorted
+func compressorNames() []string {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	rv := make([]string, 0, len(compressors))
+	for name := range compressors {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string {
+	return "flate"
+}
+
+func (flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return dst, err
+	}
+	_, err = w.Write(src)
+	if err == nil {
+		err = w.Close()
+	}
+	return buf.Bytes(), err
+}
+
+func (flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	r := flate.NewReader(bytes.NewReader(src))
+	_, err := io.Copy(buf, r)
+	if err == nil {
+		err = r.Close()
+	}
+	return buf.Bytes(), err
+}
+
+// blockCompressor is the Writer the encoder of a compressed FST writes to,
+// it compresses the states block by block, as the encoder cuts them, and
+// writes the rest as is
+type blockCompressor struct {
+	w       io.Writer
+	c       Compressor
+	counter int
+
+	// states are the states of the current block, starting at blockStart
+	states     []byte
+	blockStart int
+	inTail     bool
+	tail       []byte
+
+	// index holds the end address and offset of each block
+	index []byte
+	buf   []byte
+}
+
+func newBlockCompressor(w io.Writer, c Compressor) *blockCompressor {
+	return &blockCompressor{
+		w: w,
+		c: c,
+	}
+}
+
+func (b *blockCompressor) reset(w io.Writer) {
+	b.w = w
+	b.counter = 0
+	b.states = b.states[:0]
+	b.blockStart = 0
+	b.inTail = false
+	b.tail = b.tail[:0]
+	b.index = b.index[:0]
+}
+
+func (b *blockCompressor) Write(p []byte) (int, error) {
+	if b.inTail {
+		b.tail = append(b.tail, p...)
+	} else {
+		b.states = append(b.states, p...)
+	}
+	return len(p), nil
+}
+
+func (b *blockCompressor) write(p []byte) error {
+	n, err := b.w.Write(p)
+	b.counter += n
+	return err
+}
+
+// cut compresses the current block, which must end between states
+func (b *blockCompressor) cut() error {
+	if len(b.states) == 0 {
+		return nil
+	}
+	if b.counter == 0 {
+		err := b.write([]byte(compressedMagic))
+		if err == nil {
+			err = b.write(b.states[:headerSize])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	b.buf, err = b.c.Compress(b.buf[:0], b.states)
+	if err != nil {
+		return err
+	}
+	err = b.write(b.buf)
+	if err != nil {
+		return err
+	}
+	b.blockStart += len(b.states)
+	b.states = b.states[:0]
+	b.index = appendUint64(b.index, uint64(b.blockStart))
+	b.index = appendUint64(b.index, uint64(b.counter))
+	return nil
+}
+
+// endStates compresses the last block, what follows isn't compressed
+func (b *blockCompressor) endStates() error {
+	if b.inTail {
+		return nil
+	}
+	b.inTail = true
+	return b.cut()
+}
+
+// buffered returns the number of bytes not written yet
+func (b *blockCompressor) buffered() int {
+	return len(b.states) + len(b.tail)
+}
+
+// close writes the uncompressed tail, the block ends and the trailer
+func (b *blockCompressor) close() error {
+	tailOffset := b.counter
+	err := b.write(b.tail)
+	if err != nil {
+		return err
+	}
+	indexOffset := b.counter
+	trailer := append(b.index, b.c.Name()...)
+	for _, v := range []int{len(b.c.Name()),
+		len(b.index) / compressedBlockEntrySize, tailOffset, indexOffset} {
+		trailer = appendUint64(trailer, uint64(v))
+	}
+	b.index = trailer[:0]
+	return b.write(trailer)
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(dst, buf[:]...)
+}
+
+// compressingEncoder wraps the encoder of the uncompressed FST, which
+// writes to the blockCompressor
+type compressingEncoder struct {
+	*encoderV1
+	c         *blockCompressor
+	blockSize int
+}
+
+func newCompressingEncoder(inner encoder, w io.Writer,
+	opts *BuilderOpts) (*compressingEncoder, error) {
+	e, ok := inner.(*encoderV1)
+	if !ok {
+		return nil, fmt.Errorf("compression not supported by encoder for "+
+			"version %d", opts.Encoder)
+	}
+	c, err := loadCompressor(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := opts.CompressionBlockSize
+	if blockSize == 0 {
+		blockSize = DefaultCompressionBlockSize
+	}
+	if blockSize < MinCompressionBlockSize || int64(blockSize) > 1<<32 {
+		return nil, fmt.Errorf("invalid compression block size %d", blockSize)
+	}
+	rv := &compressingEncoder{
+		encoderV1: e,
+		c:         newBlockCompressor(w, c),
+		blockSize: blockSize,
+	}
+	rv.encoderV1.reset(rv.c)
+	return rv, nil
+}
+
+// encodeState cuts a block once the states written since the last one
+// reach the block size
+func (e *compressingEncoder) encodeState(s *builderNode, lastAddr int) (int, error) {
+	addr, err := e.encoderV1.encodeState(s, lastAddr)
+	if err != nil {
+		return addr, err
+	}
+	if e.encoderV1.bw.counter-e.c.blockStart >= e.blockSize {
+		err = e.encoderV1.flush()
+		if err == nil {
+			err = e.c.cut()
+		}
+	}
+	return addr, err
+}
+
+func (e *compressingEncoder) endStates() error {
+	err := e.encoderV1.flush()
+	if err != nil {
+		return err
+	}
+	return e.c.endStates()
+}
+
+func (e *compressingEncoder) encodeSection(id int, data []byte) error {
+	err := e.endStates()
+	if err != nil {
+		return err
+	}
+	return e.encoderV1.encodeSection(id, data)
+}
+
+func (e *compressingEncoder) finish(count, rootAddr int) error {
+	err := e.endStates()
+	if err != nil {
+		return err
+	}
+	err = e.encoderV1.finish(count, rootAddr)
+	if err != nil {
+		return err
+	}
+	return e.c.close()
+}
+
+func (e *compressingEncoder) reset(w io.Writer) {
+	e.c.reset(w)
+	e.encoderV1.reset(e.c)
+}
+
+func (e *compressingEncoder) buffered() int {
+	return e.encoderV1.buffered() + e.c.buffered()
+}
+
+// isCompressed returns true if the data is a compressed FST
+func isCompressed(data []byte) bool {
+	return len(data) >= len(compressedMagic) &&
+		string(data[:len(compressedMagic)]) == compressedMagic
+}
+
+// compressedDecoder decodes the states of a compressed FST from its
+// blocks, and the rest from the uncompressed tail
+type compressedDecoder struct {
+	data      []byte
+	typ       int
+	dense     bool
+	c         Compressor
+	statesEnd int
+	// blocksSize is the size of the compressed blocks of states
+	blocksSize int
+	numBlocks  int
+	index      []byte
+	tail       []byte
+	sections   map[int][]byte
+	cache      *blockCache
+}
+
+func loadCompressedDecoder(data []byte, ver, typ int,
+	cacheBudget int) (*compressedDecoder, error) {
+	if ver != versionV1 && ver != versionV2 {
+		return nil, fmt.Errorf("compression not supported by version %d", ver)
+	}
+	if typ&typeChecksums != 0 {
+		return nil, fmt.Errorf("checksums not supported with compression")
+	}
+	start := len(compressedMagic) + headerSize
+	if len(data) < start+compressedTrailerSize {
+		return nil, corruptf(len(data), "data too short for compressed fst")
+	}
+	trailerStart := len(data) - compressedTrailerSize
+	nameLen := binary.LittleEndian.Uint64(data[trailerStart:])
+	numBlocks := binary.LittleEndian.Uint64(data[trailerStart+8:])
+	tailOffset := binary.LittleEndian.Uint64(data[trailerStart+16:])
+	indexOffset := binary.LittleEndian.Uint64(data[trailerStart+24:])
+	if nameLen > uint64(trailerStart-start) {
+		return nil, corruptf(trailerStart, "invalid compressor name length %d",
+			nameLen)
+	}
+	nameStart := trailerStart - int(nameLen)
+	if numBlocks == 0 || indexOffset > uint64(nameStart) ||
+		(uint64(nameStart)-indexOffset)/compressedBlockEntrySize != numBlocks ||
+		(uint64(nameStart)-indexOffset)%compressedBlockEntrySize != 0 ||
+		tailOffset < uint64(start) || tailOffset > indexOffset {
+		return nil, corruptf(trailerStart, "invalid compressed fst trailer")
+	}
+	c, err := loadCompressor(string(data[nameStart:trailerStart]))
+	if err != nil {
+		return nil, err
+	}
+	rv := &compressedDecoder{
+		data:      data,
+		typ:       typ,
+		dense:     ver == versionV2,
+		c:         c,
+		numBlocks: int(numBlocks),
+		index:     data[indexOffset:nameStart],
+		tail:      data[tailOffset:indexOffset],
+		cache:     newBlockCache(cacheBudget),
+	}
+	var prevAddr, prevOffset uint64 = 0, uint64(start)
+	for i := 0; i < rv.numBlocks; i++ {
+		addr, offset := rv.blockEnd(i)
+		if addr <= prevAddr || addr > 1<<62 || offset < prevOffset ||
+			offset > tailOffset {
+			return nil, corruptf(int(indexOffset)+i*compressedBlockEntrySize,
+				"invalid block %d end %d/%d", i, addr, offset)
+		}
+		prevAddr, prevOffset = addr, offset
+	}
+	if prevAddr < headerSize || prevOffset != tailOffset {
+		return nil, corruptf(int(tailOffset), "blocks end at %d/%d", prevAddr,
+			prevOffset)
+	}
+	rv.statesEnd = int(prevAddr)
+	rv.blocksSize = int(tailOffset) - start
+	return rv, nil
+}
+
+// blockEnd returns the end address and offset of block i
+func (d *compressedDecoder) blockEnd(i int) (uint64, uint64) {
+	entry := d.index[i*compressedBlockEntrySize:]
+	return binary.LittleEndian.Uint64(entry),
+		binary.LittleEndian.Uint64(entry[8:])
+}
+
+func (d *compressedDecoder) getRoot() int {
+	if len(d.tail) < footerSizeV1 {
+		return noneAddr
+	}
+	footer := d.tail[len(d.tail)-footerSizeV1:]
+	return int(binary.LittleEndian.Uint64(footer[8:]))
+}
+
+func (d *compressedDecoder) getLen() int {
+	if len(d.tail) < footerSizeV1 {
+		return 0
+	}
+	footer := d.tail[len(d.tail)-footerSizeV1:]
+	return int(binary.LittleEndian.Uint64(footer))
+}
+
+// validate checks the footer and the section table, which are in the tail,
+// at offsets relative to the end of the states
+func (d *compressedDecoder) validate() error {
+	if len(d.tail) < footerSizeV1 {
+		return corruptf(d.statesEnd, "data too short for footer")
+	}
+	footerStart := len(d.tail) - footerSizeV1
+	if n := binary.LittleEndian.Uint64(d.tail[footerStart:]); n > maxLen {
+		return corruptf(d.statesEnd+footerStart, "invalid length %d", n)
+	}
+	if d.typ&typeSections != 0 {
+		err := d.parseSections(footerStart)
+		if err != nil {
+			return err
+		}
+	}
+	root := d.getRoot()
+	if root != emptyAddr && root != noneAddr &&
+		(root < headerSize || root >= d.statesEnd) {
+		return corruptf(d.statesEnd+footerStart+8, "invalid root address %d",
+			root)
+	}
+	_, err := d.stateAt(root, nil)
+	return err
+}
+
+func (d *compressedDecoder) parseSections(end int) error {
+	if end < 8 {
+		return corruptf(d.statesEnd+end, "data too short for section table")
+	}
+	n := binary.LittleEndian.Uint64(d.tail[end-8:])
+	if n > uint64(end-8)/sectionEntrySize {
+		return corruptf(d.statesEnd+end-8, "invalid number of sections %d", n)
+	}
+	tableStart := end - 8 - int(n)*sectionEntrySize
+	d.sections = make(map[int][]byte, n)
+	for i := 0; i < int(n); i++ {
+		entryStart := tableStart + i*sectionEntrySize
+		s := getSectionEntry(d.tail[entryStart:])
+		offset := s.offset - uint64(d.statesEnd)
+		if s.offset < uint64(d.statesEnd) || offset > uint64(tableStart) ||
+			s.length > uint64(tableStart)-offset {
+			return corruptf(d.statesEnd+entryStart,
+				"invalid section %d at %d length %d", s.id, s.offset, s.length)
+		}
+		d.sections[int(s.id)] = d.tail[offset : offset+s.length]
+	}
+	return nil
+}
+
+func (d *compressedDecoder) sizes() Sizes {
+	rv := Sizes{
+		Total:  len(d.data),
+		Header: len(compressedMagic) + headerSize,
+		States: d.blocksSize,
+	}
+	var sections int
+	rv.Sections, sections = sectionSizes(d.sections)
+	rv.Other = rv.Total - rv.Header - rv.States - sections
+	return rv
+}
+
+func (d *compressedDecoder) section(id int) []byte {
+	return d.sections[id]
+}
+
+func (d *compressedDecoder) stateAt(addr int, prealloc fstState) (fstState, error) {
+	state, ok := prealloc.(*fstStateV1)
+	if ok && state != nil {
+		*state = fstStateV1{allowDense: d.dense} // clear the struct
+	} else {
+		state = &fstStateV1{allowDense: d.dense}
+	}
+	if addr == emptyAddr || addr == noneAddr {
+		return state, state.at(nil, addr)
+	}
+	if addr < headerSize || addr >= d.statesEnd {
+		return nil, corruptf(addr, "invalid address %d/%d", addr, d.statesEnd)
+	}
+	i := sort.Search(d.numBlocks, func(i int) bool {
+		end, _ := d.blockEnd(i)
+		return uint64(addr) < end
+	})
+	block, start, err := d.block(i)
+	if err != nil {
+		return nil, err
+	}
+	err = state.atWindow(block, start, addr)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// block returns the decompressed block i, from the cache if possible, and
+// its start address
+func (d *compressedDecoder) block(i int) ([]byte, int, error) {
+	var start, offset uint64 = 0, uint64(len(compressedMagic) + headerSize)
+	if i > 0 {
+		start, offset = d.blockEnd(i - 1)
+	}
+	if rv := d.cache.lookup(i); rv != nil {
+		return rv, int(start), nil
+	}
+	end, endOffset := d.blockEnd(i)
+	size := int(end - start)
+	rv, err := d.c.Decompress(make([]byte, 0, size), d.data[offset:endOffset])
+	if err != nil {
+		return nil, 0, corruptf(int(offset), "error decompressing block %d: %v",
+			i, err)
+	}
+	if len(rv) != size {
+		return nil, 0, corruptf(int(offset), "block %d decompressed to %d "+
+			"bytes, expected %d", i, len(rv), size)
+	}
+	d.cache.add(i, rv)
+	return rv, int(start), nil
+}
+
+// WithBlockCache sets the memory (in bytes) the decompressed blocks of a
+// compressed FST are cached in, DefaultBlockCacheBudget by default.  Any
+// block not cached is decompressed again each time one of its states is
+// read.  It also sets the memory the blocks read by an FST opened with
+// OpenReaderAt are cached in.  It has no effect on other uncompressed
+// FSTs.
+func WithBlockCache(budget int) OpenOption {
+	return func(o *openOpts) {
+		o.blockCacheBudget = budget
+	}
+}
+
+// BlockCacheStats reports on the effectiveness of the cache of the
+// decompressed blocks of a compressed FST.
+type BlockCacheStats struct {
+	// Hits is the number of states read from cached blocks.
+	Hits uint64
+	// Misses is the number of blocks decompressed.
+	Misses uint64
+	// Evictions is the number of blocks evicted to stay within the budget.
+	Evictions uint64
+	// Entries is the number of blocks currently cached.
+	Entries int
+	// Size is the memory used by the cached blocks.
+	Size int
+}
+
+// IsCompressed returns true if the FST was built with compression, see
+// BuilderOpts.Compression.
+func (f *FST) IsCompressed() bool {
+	_, ok := f.decoder.(*compressedDecoder)
+	return ok
+}
+
+// BlockCacheStats returns the statistics of the cache of decompressed
+// blocks, or of the blocks read by an FST opened with OpenReaderAt, all
+// zero if the FST is neither.
+func (f *FST) BlockCacheStats() BlockCacheStats {
+	switch d := f.decoder.(type) {
+	case *compressedDecoder:
+		return d.cache.stats()
+	case *readerAtDecoder:
+		return d.cache.stats()
+	}
+	return BlockCacheStats{}
+}
+
+// blockCache is an LRU cache of decompressed blocks
+type blockCache struct {
+	m       sync.Mutex
+	budget  int
+	entries map[int]*list.Element
+	lru     list.List // front is the most recently used
+
+	s BlockCacheStats
+}
+
+type blockCacheEntry struct {
+	i     int
+	block []byte
+}
+
+func newBlockCache(budget int) *blockCache {
+	return &blockCache{
+		budget:  budget,
+		entries: make(map[int]*list.Element),
+	}
+}
+
+// lookup returns the cached block i, or nil
+func (c *blockCache) lookup(i int) []byte {
+	c.m.Lock()
+	defer c.m.Unlock()
+	elem, ok := c.entries[i]
+	if !ok {
+		c.s.Misses++
+		return nil
+	}
+	c.s.Hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*blockCacheEntry).block
+}
+
+// add caches the block, evicting the least recently used blocks as needed
+// to stay within the budget
+func (c *blockCache) add(i int, block []byte) {
+	if len(block) > c.budget {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, ok := c.entries[i]; ok {
+		// added concurrently
+		return
+	}
+	for c.s.Size+len(block) > c.budget {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*blockCacheEntry)
+		delete(c.entries, entry.i)
+		c.s.Size -= len(entry.block)
+		c.s.Entries--
+		c.s.Evictions++
+	}
+	c.entries[i] = c.lru.PushFront(&blockCacheEntry{i: i, block: block})
+	c.s.Size += len(block)
+	c.s.Entries++
+}
+
+func (c *blockCache) stats() BlockCacheStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.s
+}
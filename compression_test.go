@@ -0,0 +1,139 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	var keys []string
+	for i := 0; i < 30000; i++ {
+		keys = append(keys, fmt.Sprintf("%08x/%d", i*7919, i%13))
+	}
+	build := func(opts ...BuilderOption) []byte {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		for i, key := range keys {
+			err = b.Insert([]byte(key), uint64(i))
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, ver := range []int{versionV1, versionV2} {
+		plain := build(WithVersion(ver), WithSubtreeCounts())
+		data := build(WithVersion(ver), WithSubtreeCounts(),
+			builderOptionFunc(func(o *BuilderOpts) {
+				o.Compression = "flate"
+				o.CompressionBlockSize = MinCompressionBlockSize
+			}))
+		if len(data) >= len(plain) {
+			t.Errorf("version %d: expected compression, got %d bytes for %d",
+				ver, len(data), len(plain))
+		}
+
+		// a small cache, so blocks are evicted
+		fst, err := Load(data, WithBlockCache(4*MinCompressionBlockSize))
+		if err != nil {
+			t.Fatalf("version %d: error loading: %v", ver, err)
+		}
+		if !fst.IsCompressed() || fst.Len() != len(keys) {
+			t.Fatalf("version %d: expected %d compressed keys", ver, len(keys))
+		}
+		for i := 0; i < len(keys); i += 7 {
+			val, exists, err := fst.Get([]byte(keys[i]))
+			if err != nil || !exists || val != uint64(i) {
+				t.Fatalf("version %d: expected %q %d, got %d %t %v", ver,
+					keys[i], i, val, exists, err)
+			}
+		}
+		if _, exists, _ := fst.Get([]byte("missing")); exists {
+			t.Errorf("version %d: unexpected key", ver)
+		}
+		itr, err := fst.Iterator(nil, nil)
+		i := 0
+		for ; err == nil; i++ {
+			key, val := itr.Current()
+			if string(key) != keys[i] || val != uint64(i) {
+				t.Fatalf("version %d: expected %q %d, got %q %d", ver, keys[i],
+					i, key, val)
+			}
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) || i != len(keys) {
+			t.Fatalf("version %d: expected %d keys, got %d %v", ver,
+				len(keys), i, err)
+		}
+		if pos, err := fst.keysBefore([]byte(keys[1000])); err != nil ||
+			pos != 1000 {
+			t.Errorf("version %d: expected subtree counts, got %d %v", ver,
+				pos, err)
+		}
+		stats := fst.BlockCacheStats()
+		if stats.Misses == 0 || stats.Hits == 0 || stats.Evictions == 0 ||
+			stats.Size > 4*MinCompressionBlockSize {
+			t.Errorf("version %d: unexpected cache stats %+v", ver, stats)
+		}
+	}
+
+	data := build(WithCompression("flate"))
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(compressedMagic)+headerSize+100] ^= 0xff
+	fst, err := Load(corrupted)
+	if err == nil {
+		var itr *FSTIterator
+		itr, err = fst.Iterator(nil, nil)
+		for err == nil {
+			err = itr.Next()
+		}
+	}
+	if err == nil || errors.Is(err, ErrIteratorDone) {
+		t.Errorf("expected the corrupted block detected, got %v", err)
+	}
+	for _, n := range []int{1, 8, len(data) / 2} {
+		if _, err = Load(data[:len(data)-n]); err == nil {
+			t.Errorf("expected truncation by %d detected", n)
+		}
+	}
+
+	var buf bytes.Buffer
+	_, err = New(&buf, WithCompression("no such compressor"))
+	if !errors.Is(err, ErrUnknownCompressor) {
+		t.Errorf("expected ErrUnknownCompressor, got %v", err)
+	}
+	_, err = New(&buf, WithCompression("flate"), WithChecksums())
+	if err == nil {
+		t.Errorf("expected an error with checksums")
+	}
+	if err = RegisterCompressor(flateCompressor{}); err == nil {
+		t.Errorf("expected an error registering flate again")
+	}
+	if c := Capabilities().Compression; len(c) == 0 || c[0] != "flate" {
+		t.Errorf("expected the flate compressor, got %v", c)
+	}
+}
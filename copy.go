@@ -0,0 +1,311 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+)
+
+// CopyFrom inserts the keys of the FST between startKeyInclusive and
+// endKeyExclusive, with their values, as inserting them one at a time
+// would, but compiles each node of the source only once.  Keys sharing a
+// suffix in the source share it in the FST being built without being
+// minimized again, so copying an FST, or large ranges of it, costs in
+// proportion to its nodes rather than its keys.  A nil endKeyExclusive
+// copies to the last key.  The keys must follow those already inserted.
+//
+// If the Builder interns values, records key digests or checks outputs,
+// or the source FST has interned values, the keys are inserted one at a
+// time.
+func (b *Builder) CopyFrom(f *FST, startKeyInclusive, endKeyExclusive []byte) error {
+	_, err := b.copyFrom(f, startKeyInclusive, endKeyExclusive)
+	return err
+}
+
+// copiedNode is a node of the source of a copy, compiled into the FST
+// being built
+type copiedNode struct {
+	addr int
+	// len is the number of keys below the node
+	len int
+}
+
+// copier remembers the nodes of the source FST already compiled, so that
+// nodes shared in the source are compiled once.  It uses memory in
+// proportion to the nodes copied.
+type copier struct {
+	src   *FST
+	nodes map[int]copiedNode
+}
+
+// copyFrom performs a CopyFrom, returning the number of keys copied
+func (b *Builder) copyFrom(f *FST, start, end []byte) (int, error) {
+	if err := f.enter(); err != nil {
+		return 0, err
+	}
+	defer f.exit()
+	if b.out.err != nil {
+		return 0, b.out.err
+	}
+	err := emptySearch(f, start, end, nil)
+	if err != nil {
+		if errors.Is(err, ErrIteratorDone) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if b.values != nil || b.digests != nil || b.opts.CheckOutputs > 0 ||
+		f.values != nil {
+		return b.insertFrom(f, start, end)
+	}
+	if b.copied == nil || b.copied.src != f {
+		b.copied = &copier{
+			src:   f,
+			nodes: make(map[int]copiedNode),
+		}
+	}
+	root, err := f.decoder.stateAt(f.decoder.getRoot(), nil)
+	if err != nil {
+		return 0, err
+	}
+	c := &rangeCopy{
+		b:     b,
+		start: start,
+		end:   end,
+	}
+	err = c.visit(root, 0)
+	return c.n, err
+}
+
+// insertFrom inserts the keys of the FST in the range one at a time
+func (b *Builder) insertFrom(f *FST, start, end []byte) (int, error) {
+	var n int
+	itr, err := f.Iterator(start, end)
+	for err == nil {
+		k, v := itr.Current()
+		err = b.Insert(k, v)
+		if err != nil {
+			return n, err
+		}
+		n++
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		return n, err
+	}
+	return n, nil
+}
+
+// rangeCopy walks the source FST for a copy.  Only the states on the paths
+// to the bounds of the range are visited, the subtrees entirely within it
+// are grafted whole.
+type rangeCopy struct {
+	b          *Builder
+	start, end []byte
+	key        []byte
+	n          int
+}
+
+func (c *rangeCopy) visit(state fstState, out uint64) error {
+	if len(c.key) > 0 && c.contains(c.key) {
+		n, err := c.b.graft(c.key, state, out)
+		c.n += n
+		return err
+	}
+	if state.Final() && bytes.Compare(c.key, c.start) >= 0 &&
+		(c.end == nil || bytes.Compare(c.key, c.end) < 0) {
+		err := c.b.Insert(c.key, out+state.FinalOutput())
+		if err != nil {
+			return err
+		}
+		c.n++
+	}
+	for i := 0; i < state.NumTransitions(); i++ {
+		t := state.TransitionAt(i)
+		c.key = append(c.key, t)
+		if c.end != nil && bytes.Compare(c.key, c.end) >= 0 {
+			c.key = c.key[:len(c.key)-1]
+			break
+		}
+		if succ := PrefixSuccessor(c.key); succ == nil ||
+			bytes.Compare(succ, c.start) > 0 {
+			_, addr, tout := state.TransitionFor(t)
+			next, err := c.b.copied.src.decoder.stateAt(addr, nil)
+			if err != nil {
+				return err
+			}
+			err = c.visit(next, out+tout)
+			if err != nil {
+				return err
+			}
+		}
+		c.key = c.key[:len(c.key)-1]
+	}
+	return nil
+}
+
+// contains reports whether every key starting with the prefix is in the
+// range
+func (c *rangeCopy) contains(prefix []byte) bool {
+	if bytes.Compare(prefix, c.start) < 0 {
+		return false
+	}
+	if c.end == nil {
+		return true
+	}
+	succ := PrefixSuccessor(prefix)
+	return succ != nil && bytes.Compare(succ, c.end) <= 0
+}
+
+// graft inserts the keys below the source state, reached with the prefix
+// and output, leaving the unfinished nodes as inserting them one at a time
+// would: the path to the last of the keys is unfinished, and every node off
+// it is compiled from the source.
+func (b *Builder) graft(prefix []byte, state fstState, out uint64) (int, error) {
+	if bytes.Compare(prefix, b.last) <= 0 {
+		return 0, ErrOutOfOrder
+	}
+	prefixLen, out := b.unfinished.findCommonPrefixAndSetOutput(prefix, out)
+	err := b.compileFrom(prefixLen)
+	if err != nil {
+		return 0, err
+	}
+	b.copyLastKey(prefix)
+	b.unfinished.addSuffix(prefix[prefixLen:], out)
+
+	var n int
+	for {
+		top := b.unfinished.stack[len(b.unfinished.stack)-1]
+		top.node.final = state.Final()
+		if top.node.final {
+			top.node.finalOutput = state.FinalOutput()
+			n++
+		}
+		numTrans := state.NumTransitions()
+		if numTrans == 0 {
+			break
+		}
+		for i := 0; i < numTrans-1; i++ {
+			t := state.TransitionAt(i)
+			_, addr, tout := state.TransitionFor(t)
+			copied, err := b.copyNode(addr)
+			if err != nil {
+				return n, err
+			}
+			top.node.trans = append(top.node.trans, transition{
+				in:   t,
+				out:  tout,
+				addr: copied.addr,
+			})
+			n += copied.len
+		}
+		t := state.TransitionAt(numTrans - 1)
+		_, addr, tout := state.TransitionFor(t)
+		b.last = append(b.last, t)
+		b.unfinished.addSuffix(b.last[len(b.last)-1:], tout)
+		state, err = b.copied.src.decoder.stateAt(addr, nil)
+		if err != nil {
+			return n, err
+		}
+	}
+	b.len += n
+	return n, nil
+}
+
+// copyNode compiles the node of the source at the address, after the
+// nodes below it, unless already compiled
+func (b *Builder) copyNode(addr int) (copiedNode, error) {
+	if rv, ok := b.copied.nodes[addr]; ok {
+		return rv, nil
+	}
+	state, err := b.copied.src.decoder.stateAt(addr, nil)
+	if err != nil {
+		return copiedNode{}, err
+	}
+	var rv copiedNode
+	node := b.builderNodePool.Get()
+	node.final = state.Final()
+	if node.final {
+		node.finalOutput = state.FinalOutput()
+		rv.len++
+	}
+	for i := 0; i < state.NumTransitions(); i++ {
+		t := state.TransitionAt(i)
+		_, next, out := state.TransitionFor(t)
+		copied, err := b.copyNode(next)
+		if err != nil {
+			return copiedNode{}, err
+		}
+		node.trans = append(node.trans, transition{
+			in:   t,
+			out:  out,
+			addr: copied.addr,
+		})
+		rv.len += copied.len
+	}
+	rv.addr, err = b.compile(node, nil)
+	if err != nil {
+		return copiedNode{}, err
+	}
+	b.copied.nodes[addr] = rv
+	return rv, nil
+}
+
+// mergeCopySources returns the FSTIterators merged, if they all iterate
+// over every key in their range, so that runs of keys from one of them
+// can be copied, or nil otherwise
+func mergeCopySources(itrs []Iterator) []*FSTIterator {
+	rv := make([]*FSTIterator, len(itrs))
+	for i, itr := range itrs {
+		fi, ok := itr.(*FSTIterator)
+		if !ok || fi.aut != Automaton(alwaysMatchAutomaton) {
+			return nil
+		}
+		rv[i] = fi
+	}
+	return rv
+}
+
+// copyRun copies the keys of the only input the current key comes from,
+// up to the next key of any other input, and moves past them
+func (m *MergeIterator) copyRun(b *Builder, srcs []*FSTIterator) (int, error) {
+	i := m.lowIdxs[0]
+	src := srcs[i]
+	end := src.endKeyExclusive
+	for j, k := range m.currKs {
+		if j != i && k != nil && (end == nil || bytes.Compare(k, end) < 0) {
+			end = k
+		}
+	}
+	n, err := b.copyFrom(src.f, m.lowK, end)
+	if err != nil {
+		return n, err
+	}
+	m.currKs[i], m.currVs[i] = nil, 0
+	if end != nil {
+		err = src.Seek(append([]byte(nil), end...))
+		if err == nil {
+			m.currKs[i], m.currVs[i] = src.Current()
+		} else if !errors.Is(err, ErrIteratorDone) {
+			return n, err
+		}
+	}
+	m.updateMatches()
+	if m.lowK == nil {
+		return n, ErrIteratorDone
+	}
+	return n, nil
+}
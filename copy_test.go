@@ -0,0 +1,213 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCopyFrom(t *testing.T) {
+	words := append([]string{""}, thousandTestWords...)
+	vals := randomValues(words)
+	var srcBuf bytes.Buffer
+	b, err := New(&srcBuf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, words, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	src, err := Load(srcBuf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	last := words[len(words)-1]
+
+	for _, opts := range [][]BuilderOption{nil, {WithVersion(2)}, {WithKeyDigests(2)}} {
+		for _, bounds := range [][2]string{
+			{"", ""},
+			{"c", "ma"},
+			{"ab", ""},
+			{words[10], words[11]},
+			{"p", "p"},
+		} {
+			start := []byte(bounds[0])
+			var end []byte
+			if bounds[1] != "" {
+				end = []byte(bounds[1])
+			}
+			itr, err := src.Iterator(start, end)
+			wantKeys, wantVals := drainIterator(t, itr, err)
+			if len(wantKeys) == 0 {
+				wantKeys, wantVals = nil, nil
+			}
+			// a key after the copy may share a prefix with the copied keys
+			after := last + "z"
+			if end != nil {
+				after = string(end) + "z"
+			}
+			wantKeys = append(wantKeys, after)
+			wantVals = append(wantVals, 42)
+
+			var buf bytes.Buffer
+			b, err := New(&buf, opts...)
+			if err != nil {
+				t.Fatalf("error creating builder: %v", err)
+			}
+			err = b.CopyFrom(src, start, end)
+			if err != nil {
+				t.Fatalf("error copying: %v", err)
+			}
+			err = b.Insert([]byte(after), 42)
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+			err = b.Close()
+			if err != nil {
+				t.Fatalf("error closing builder: %v", err)
+			}
+			fst, err := Load(buf.Bytes())
+			if err != nil {
+				t.Fatalf("error loading: %v", err)
+			}
+			itr, err = fst.Iterator(nil, nil)
+			gotKeys, gotVals := drainIterator(t, itr, err)
+			if !reflect.DeepEqual(gotKeys, wantKeys) || !reflect.DeepEqual(gotVals, wantVals) {
+				t.Errorf("expected copy of %q to %q to match the source", start, end)
+			}
+			if fst.Len() != len(wantKeys) {
+				t.Errorf("expected %d keys, got %d", len(wantKeys), fst.Len())
+			}
+		}
+	}
+
+	// keys must follow those already inserted
+	b, err = New(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = b.Insert([]byte("zzz"), 1)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.CopyFrom(src, nil, nil)
+	if err != ErrOutOfOrder {
+		t.Errorf("expected ErrOutOfOrder, got %v", err)
+	}
+}
+
+func TestCopyFromSharesSuffixes(t *testing.T) {
+	// every key shares its suffix with 3 others, so the source has few
+	// nodes for its keys, each compiled once
+	var kvs []KV
+	for c := 'a'; c <= 'd'; c++ {
+		for _, word := range thousandTestWords[:200] {
+			kvs = append(kvs, KV{string(c) + word, 1})
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	src := buildKVs(t, kvs...)
+
+	var buf bytes.Buffer
+	b, err := New(&buf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = b.CopyFrom(src, nil, nil)
+	if err != nil {
+		t.Fatalf("error copying: %v", err)
+	}
+	if len(b.copied.nodes) >= len(kvs)/2 {
+		t.Errorf("expected shared suffixes to be copied once, copied %d nodes",
+			len(b.copied.nodes))
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	if buf.Len() > len(src.data) {
+		t.Errorf("expected copy no larger than the source, got %d > %d",
+			buf.Len(), len(src.data))
+	}
+}
+
+func TestMergeCopiesRuns(t *testing.T) {
+	words := thousandTestWords
+	var a, b []KV
+	for i, word := range words {
+		switch {
+		case i < 400:
+			a = append(a, KV{word, uint64(i)})
+		case i < 600:
+			a = append(a, KV{word, uint64(i)})
+			if i%3 == 0 {
+				b = append(b, KV{word, 1})
+			}
+		default:
+			b = append(b, KV{word, uint64(i)})
+		}
+	}
+	fstA, fstB := buildKVs(t, a...), buildKVs(t, b...)
+
+	iterators := func() []Iterator {
+		itrA, err := fstA.Iterator(nil, nil)
+		if err != nil {
+			t.Fatalf("error creating iterator: %v", err)
+		}
+		itrB, err := fstB.Iterator([]byte(words[100]), nil)
+		if err != nil {
+			t.Fatalf("error creating iterator: %v", err)
+		}
+		return []Iterator{itrA, itrB}
+	}
+	var wantKeys []string
+	var wantVals []uint64
+	m, err := NewMergeIterator(iterators(), MergeSum)
+	for err == nil {
+		k, v := m.Current()
+		wantKeys = append(wantKeys, string(k))
+		wantVals = append(wantVals, v)
+		err = m.Next()
+	}
+	if err != ErrIteratorDone {
+		t.Fatalf("error merging: %v", err)
+	}
+
+	var buf bytes.Buffer
+	stats, err := MergeWithStats(&buf, nil, iterators(), MergeSum)
+	if err != nil {
+		t.Fatalf("error merging: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	itr, err := fst.Iterator(nil, nil)
+	gotKeys, gotVals := drainIterator(t, itr, err)
+	if !reflect.DeepEqual(gotKeys, wantKeys) || !reflect.DeepEqual(gotVals, wantVals) {
+		t.Errorf("expected merged keys and values to match")
+	}
+	if stats.Keys != len(wantKeys) || stats.MergedKeys != 66 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
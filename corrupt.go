@@ -0,0 +1,47 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorrupt is matched (using errors.Is) by all errors returned when
+// malformed FST data is detected.
+var ErrCorrupt = errors.New("corrupt fst data")
+
+// CorruptError describes malformed FST data, and the byte offset within
+// the data where the problem was detected.
+type CorruptError struct {
+	Offset int
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupt fst data at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Is allows errors.Is(err, ErrCorrupt) to match any CorruptError.
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+func corruptf(offset int, format string, args ...interface{}) error {
+	return &CorruptError{
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
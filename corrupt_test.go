@@ -0,0 +1,290 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func buildWordsSample(t testing.TB) []byte {
+	return buildWordsValues(t, randomValues(thousandTestWords))
+}
+
+func buildWordsValues(t testing.TB, vals []uint64) []byte {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// exerciseFST walks (up to limit keys of) an FST and looks each of those
+// keys up again, returning the first error encountered, to check that
+// malformed data is reported without panicking.
+func exerciseFST(f *FST, limit int) error {
+	_, err := f.Stats()
+	if err != nil {
+		return err
+	}
+	_, _, err = f.MinKey()
+	if err != nil {
+		return err
+	}
+	_, _, err = f.MaxKey()
+	if err != nil {
+		return err
+	}
+	itr, err := f.Iterator(nil, nil)
+	for n := 0; err == nil && n < limit; n++ {
+		key, _ := itr.Current()
+		_, _, gerr := f.Get(key)
+		if gerr != nil {
+			return gerr
+		}
+		err = itr.Next()
+	}
+	if errors.Is(err, ErrIteratorDone) {
+		return nil
+	}
+	return err
+}
+
+func checkCorruptErr(t *testing.T, err error) {
+	if err != nil && err != ErrIteratorDone && !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected nil or ErrCorrupt, got %v", err)
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	data := buildSmallSample(t)
+	for i := 0; i < len(data); i++ {
+		fst, err := Load(data[:i])
+		if err == nil {
+			t.Fatalf("expected error loading %d/%d bytes", i, len(data))
+		}
+		if fst != nil {
+			t.Fatalf("expected nil fst loading %d/%d bytes", i, len(data))
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("expected ErrCorrupt loading %d/%d bytes, got %v", i, len(data), err)
+		}
+	}
+}
+
+func TestLoadCorruptFooter(t *testing.T) {
+	data := buildSmallSample(t)
+	corrupt := append([]byte(nil), data...)
+	// root address pointing into the footer
+	corrupt[len(corrupt)-8] = byte(len(corrupt) - 4)
+	_, err := Load(corrupt)
+	var cerr *CorruptError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected CorruptError, got %v", err)
+	}
+	if cerr.Offset != len(corrupt)-8 {
+		t.Errorf("expected offset %d, got %d", len(corrupt)-8, cerr.Offset)
+	}
+}
+
+// TestLoadManyKeys checks that FSTs with more keys than bytes, whose keys
+// share most of their states, aren't reported as corrupt
+func TestLoadManyKeys(t *testing.T) {
+	for _, n := range []int{100, 1000, 10000} {
+		var buf bytes.Buffer
+		b, err := New(&buf, nil)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			err = b.Insert([]byte(fmt.Sprintf("key%05d", i)), 0)
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		data := buf.Bytes()
+		if len(data) >= n {
+			t.Fatalf("expected fewer bytes than keys, got %d for %d", len(data),
+				n)
+		}
+		fst, err := Load(data)
+		if err != nil {
+			t.Fatalf("%d keys in %d bytes: error loading: %v", n, len(data), err)
+		}
+		if fst.Len() != n {
+			t.Errorf("expected %d keys, got %d", n, fst.Len())
+		}
+		_, err = OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Errorf("%d keys in %d bytes: error opening reader: %v", n,
+				len(data), err)
+		}
+		_, exists, err := fst.Get([]byte(fmt.Sprintf("key%05d", n-1)))
+		if err != nil || !exists {
+			t.Errorf("expected the last key, got %t %v", exists, err)
+		}
+
+		buf.Reset()
+		b, err = New(&buf, WithCompression("flate"))
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			err = b.Insert([]byte(fmt.Sprintf("key%05d", i)), 0)
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		_, err = Load(buf.Bytes())
+		if err != nil {
+			t.Errorf("%d keys compressed: error loading: %v", n, err)
+		}
+	}
+}
+
+func TestLoadMutated(t *testing.T) {
+	// the values are drawn from r too, so that the data is the same each run
+	r := rand.New(rand.NewSource(7))
+	vals := make([]uint64, len(thousandTestWords))
+	for i := range vals {
+		vals[i] = r.Uint64()
+	}
+	data := buildWordsValues(t, vals)
+	for i := 0; i < 2000; i++ {
+		corrupt := append([]byte(nil), data...)
+		for j := 0; j < 1+r.Intn(8); j++ {
+			corrupt[headerSize+r.Intn(len(corrupt)-headerSize)] = byte(r.Intn(256))
+		}
+		fst, err := Load(corrupt)
+		checkCorruptErr(t, err)
+		if err != nil {
+			continue
+		}
+		checkCorruptErr(t, exerciseFST(fst, 2000))
+		for _, word := range thousandTestWords[:50] {
+			_, _, err = fst.Get([]byte(word))
+			checkCorruptErr(t, err)
+		}
+	}
+}
+
+func recoverAll(t *testing.T, fst *FST) (map[string]uint64, []SkippedRange) {
+	rv := make(map[string]uint64)
+	itr, err := fst.RecoverIterator()
+	var prev []byte
+	for err == nil {
+		key, val := itr.Current()
+		if prev != nil && bytes.Compare(key, prev) <= 0 {
+			t.Fatalf("recovered key %q after %q", key, prev)
+		}
+		prev = append(prev[:0], key...)
+		rv[string(key)] = val
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone {
+		t.Fatalf("expected ErrIteratorDone, got %v", err)
+	}
+	return rv, itr.Skipped()
+}
+
+func TestRecoverIterator(t *testing.T) {
+	vals := randomValues(thousandTestWords)
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	data := buf.Bytes()
+
+	fst, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, skipped := recoverAll(t, fst)
+	if len(got) != len(thousandTestWords) || len(skipped) != 0 {
+		t.Errorf("expected all keys recovered, got %d skipped %v", len(got),
+			skipped)
+	}
+
+	// damage the state reached with "b", giving it invalid pack sizes
+	root, err := fst.decoder.stateAt(fst.decoder.getRoot(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr, _ := root.TransitionFor('b')
+	data[addr] = 2
+	data[addr-1] = 0xff
+	got, skipped = recoverAll(t, fst)
+	if len(skipped) != 1 || string(skipped[0].Start) != "b" ||
+		string(skipped[0].End) != "c" || !errors.Is(skipped[0].Err, ErrCorrupt) {
+		t.Fatalf("expected keys starting with b skipped, got %v", skipped)
+	}
+	for i, word := range thousandTestWords {
+		val, ok := got[word]
+		if word[0] == 'b' {
+			if ok {
+				t.Errorf("expected %s to be skipped", word)
+			}
+		} else if !ok || val != vals[i] {
+			t.Errorf("expected %s %d recovered, got %d %t", word, vals[i], val, ok)
+		}
+	}
+
+	// random damage never panics, loops, or yields keys out of order
+	data = buildWordsSample(t)
+	r := rand.New(rand.NewSource(11))
+	for i := 0; i < 500; i++ {
+		corrupt := append([]byte(nil), data...)
+		for j := 0; j < 1+r.Intn(8); j++ {
+			corrupt[headerSize+r.Intn(len(corrupt)-headerSize)] = byte(r.Intn(256))
+		}
+		fst, err := Load(corrupt)
+		if err != nil {
+			continue
+		}
+		_, skipped = recoverAll(t, fst)
+		for _, s := range skipped {
+			checkCorruptErr(t, s.Err)
+		}
+	}
+}
@@ -28,7 +28,12 @@ func init() {
 }
 
 type decoderV1 struct {
-	data []byte
+	data     []byte
+	sections map[int][]byte
+
+	// dense is set if states with many transitions are encoded with a
+	// bitmap of their labels, see decoder_v2.go
+	dense bool
 }
 
 func newDecoderV1(data []byte) *decoderV1 {
@@ -46,6 +51,88 @@ func (d *decoderV1) getRoot() int {
 	return int(root)
 }
 
+// maxLen bounds the number of keys in the footer to those an int can count.
+// It isn't bounded by the size of the data, as the keys of an FST sharing
+// most of their states can outnumber its bytes.
+const maxLen = uint64(^uint(0) >> 1)
+
+// validate checks that the footer is present, and that it refers to a
+// root state which can be decoded.
+func (d *decoderV1) validate() error {
+	if len(d.data) < headerSize+footerSizeV1 {
+		return corruptf(len(d.data), "data too short for header and footer")
+	}
+	footerStart := len(d.data) - footerSizeV1
+	if n := binary.LittleEndian.Uint64(d.data[footerStart:]); n > maxLen {
+		return corruptf(footerStart, "invalid length %d", n)
+	}
+	nodesEnd := footerStart
+	typ := binary.LittleEndian.Uint64(d.data[8:headerSize])
+	if typ&typeSections != 0 {
+		var err error
+		nodesEnd, err = d.parseSections(footerStart)
+		if err != nil {
+			return err
+		}
+	}
+	root := binary.LittleEndian.Uint64(d.data[footerStart+8:])
+	if root != emptyAddr && root != noneAddr &&
+		(root < headerSize || root >= uint64(nodesEnd)) {
+		return corruptf(footerStart+8, "invalid root address %d", root)
+	}
+	state := fstStateV1{allowDense: d.dense}
+	return state.at(d.data, int(root))
+}
+
+// parseSections parses the table of sections ending at the provided
+// offset, and returns the offset where the states end.
+func (d *decoderV1) parseSections(end int) (int, error) {
+	if end < headerSize+8 {
+		return 0, corruptf(end, "data too short for section table")
+	}
+	n := binary.LittleEndian.Uint64(d.data[end-8:])
+	if n > uint64(end-8-headerSize)/sectionEntrySize {
+		return 0, corruptf(end-8, "invalid number of sections %d", n)
+	}
+	tableStart := end - 8 - int(n)*sectionEntrySize
+	nodesEnd := tableStart
+	d.sections = make(map[int][]byte, n)
+	for i := 0; i < int(n); i++ {
+		entryStart := tableStart + i*sectionEntrySize
+		s := getSectionEntry(d.data[entryStart:])
+		if s.offset < headerSize || s.offset > uint64(tableStart) ||
+			s.length > uint64(tableStart)-s.offset {
+			return 0, corruptf(entryStart, "invalid section %d at %d length %d",
+				s.id, s.offset, s.length)
+		}
+		d.sections[int(s.id)] = d.data[s.offset : s.offset+s.length]
+		if int(s.offset) < nodesEnd {
+			nodesEnd = int(s.offset)
+		}
+	}
+	return nodesEnd, nil
+}
+
+// section returns the data of the section, or nil if it isn't present.
+func (d *decoderV1) section(id int) []byte {
+	return d.sections[id]
+}
+
+func (d *decoderV1) sizes() Sizes {
+	rv := Sizes{
+		Total:  len(d.data),
+		Header: headerSize,
+		Other:  footerSizeV1,
+	}
+	if d.sections != nil {
+		rv.Other += 8 + len(d.sections)*sectionEntrySize
+	}
+	var sections int
+	rv.Sections, sections = sectionSizes(d.sections)
+	rv.States = rv.Total - rv.Header - rv.Other - sections
+	return rv
+}
+
 func (d *decoderV1) getLen() int {
 	if len(d.data) < footerSizeV1 {
 		return 0
@@ -58,9 +145,9 @@ func (d *decoderV1) getLen() int {
 func (d *decoderV1) stateAt(addr int, prealloc fstState) (fstState, error) {
 	state, ok := prealloc.(*fstStateV1)
 	if ok && state != nil {
-		*state = fstStateV1{} // clear the struct
+		*state = fstStateV1{allowDense: d.dense} // clear the struct
 	} else {
-		state = &fstStateV1{}
+		state = &fstStateV1{allowDense: d.dense}
 	}
 	err := state.at(d.data, addr)
 	if err != nil {
@@ -94,6 +181,17 @@ type fstStateV1 struct {
 	outTop      int
 	outBottom   int
 	outFinal    int
+
+	// allowDense is set by the decoder if dense states may be present, and
+	// dense if this is one, in which case transTop and transBottom delimit
+	// the bitmap of labels
+	allowDense bool
+	dense      bool
+
+	// window is set when decoding a window of the states of a compressed
+	// FST (see compression.go), starting at address base
+	window bool
+	base   int
 }
 
 func (f *fstStateV1) isEncodedSingle() bool {
@@ -110,11 +208,27 @@ func (f *fstStateV1) at(data []byte, addr int) error {
 	} else if addr == noneAddr {
 		return f.atNone()
 	}
-	if addr > len(data) || addr < 16 {
-		return fmt.Errorf("invalid address %d/%d", addr, len(data))
+	if addr >= len(data) || addr < headerSize {
+		return corruptf(addr, "invalid address %d/%d", addr, len(data))
+	}
+	return f.atIndex(data, addr)
+}
+
+// atWindow decodes the state at addr from a window of the states starting
+// at address base
+func (f *fstStateV1) atWindow(data []byte, base, addr int) error {
+	f.data = data
+	f.window = true
+	f.base = base
+	if addr-base >= len(data) || addr < base || addr < headerSize {
+		return corruptf(addr, "invalid address %d/%d", addr, base+len(data))
 	}
-	f.top = addr
-	f.bottom = addr
+	return f.atIndex(data, addr)
+}
+
+func (f *fstStateV1) atIndex(data []byte, addr int) error {
+	f.top = addr - f.base
+	f.bottom = f.top
 	if f.isEncodedSingle() {
 		return f.atSingle(data, addr)
 	}
@@ -146,17 +260,29 @@ func (f *fstStateV1) atSingle(data []byte, addr int) error {
 	f.singleTransChar = data[f.top] & maxCommon
 	if f.singleTransChar == 0 {
 		f.bottom-- // extra byte for uncommon
+		if f.bottom < f.low() {
+			return f.truncated(addr)
+		}
 		f.singleTransChar = data[f.bottom]
 	} else {
 		f.singleTransChar = decodeCommon(f.singleTransChar)
 	}
 	if f.singleTransNext {
 		// now we know the bottom, can compute next addr
-		f.singleTransAddr = uint64(f.bottom - 1)
+		f.singleTransAddr = uint64(f.base + f.bottom - 1)
 		f.singleTransOut = 0
 	} else {
 		f.bottom-- // extra byte with pack sizes
+		if f.bottom < f.low() {
+			return f.truncated(addr)
+		}
 		f.transSize, f.outSize = decodePackSize(data[f.bottom])
+		if err := f.checkPackSizes(); err != nil {
+			return err
+		}
+		if f.bottom-f.transSize-f.outSize < f.low() {
+			return f.truncated(addr)
+		}
 		f.bottom -= f.transSize // exactly one trans
 		f.singleTransAddr = readPackedUint(data[f.bottom : f.bottom+f.transSize])
 		if f.outSize > 0 {
@@ -167,7 +293,7 @@ func (f *fstStateV1) atSingle(data []byte, addr int) error {
 		}
 		// need to wait till we know bottom
 		if f.singleTransAddr != 0 {
-			f.singleTransAddr = uint64(f.bottom) - f.singleTransAddr
+			f.singleTransAddr = uint64(f.base+f.bottom) - f.singleTransAddr
 		}
 	}
 	return nil
@@ -179,14 +305,33 @@ func (f *fstStateV1) atMulti(data []byte, addr int) error {
 	f.numTrans = int(data[f.top] & maxNumTrans)
 	if f.numTrans == 0 {
 		f.bottom-- // extra byte for number of trans
+		if f.bottom < f.low() {
+			return f.truncated(addr)
+		}
 		f.numTrans = int(data[f.bottom])
 		if f.numTrans == 1 {
 			// can't really be 1 here, this is special case that means 256
 			f.numTrans = 256
 		}
 	}
+	if f.allowDense && f.numTrans > denseMinTrans {
+		return f.atDense(data, addr)
+	}
 	f.bottom-- // extra byte with pack sizes
+	if f.bottom < f.low() {
+		return f.truncated(addr)
+	}
 	f.transSize, f.outSize = decodePackSize(data[f.bottom])
+	if err := f.checkPackSizes(); err != nil {
+		return err
+	}
+	size := f.numTrans * (1 + f.transSize + f.outSize)
+	if f.final {
+		size += f.outSize
+	}
+	if f.bottom-size < f.low() {
+		return f.truncated(addr)
+	}
 
 	f.transTop = f.bottom
 	f.bottom -= f.numTrans // one byte for each transition
@@ -208,8 +353,27 @@ func (f *fstStateV1) atMulti(data []byte, addr int) error {
 	return nil
 }
 
+// low returns the lowest index of the data a state may extend to
+func (f *fstStateV1) low() int {
+	if f.window {
+		return 0
+	}
+	return headerSize
+}
+
+func (f *fstStateV1) checkPackSizes() error {
+	if f.transSize > 8 || f.outSize > 8 {
+		return corruptf(f.bottom, "invalid pack sizes %d/%d", f.transSize, f.outSize)
+	}
+	return nil
+}
+
+func (f *fstStateV1) truncated(addr int) error {
+	return corruptf(addr, "state extends before start of data")
+}
+
 func (f *fstStateV1) Address() int {
-	return f.top
+	return f.base + f.top
 }
 
 func (f *fstStateV1) Final() bool {
@@ -231,10 +395,38 @@ func (f *fstStateV1) TransitionAt(i int) byte {
 	if f.isEncodedSingle() {
 		return f.singleTransChar
 	}
+	if f.dense {
+		return f.denseLabel(i)
+	}
 	transitionKeys := f.data[f.transBottom:f.transTop]
 	return transitionKeys[f.numTrans-i-1]
 }
 
+// TransitionBefore returns the position (as used by TransitionAt) of the
+// transition with the greatest label less than b, or -1 if there is none.
+func (f *fstStateV1) TransitionBefore(b byte) int {
+	if f.numTrans == 0 {
+		return -1
+	}
+	if f.isEncodedSingle() {
+		if f.singleTransChar < b {
+			return 0
+		}
+		return -1
+	}
+	if f.dense {
+		return f.denseRank(b) - 1
+	}
+	// keys are stored in descending order, so the first key less than b
+	// is the greatest one
+	transitionKeys := f.data[f.transBottom:f.transTop]
+	pos := indexLessThan(transitionKeys, b)
+	if pos < 0 {
+		return -1
+	}
+	return f.numTrans - pos - 1
+}
+
 func (f *fstStateV1) TransitionFor(b byte) (int, int, uint64) {
 	if f.isEncodedSingle() {
 		if f.singleTransChar == b {
@@ -242,6 +434,9 @@ func (f *fstStateV1) TransitionFor(b byte) (int, int, uint64) {
 		}
 		return -1, noneAddr, 0
 	}
+	if f.dense {
+		return f.denseTransitionFor(b)
+	}
 	transitionKeys := f.data[f.transBottom:f.transTop]
 	pos := bytes.IndexByte(transitionKeys, b)
 	if pos < 0 {
@@ -251,7 +446,7 @@ func (f *fstStateV1) TransitionFor(b byte) (int, int, uint64) {
 	dest := int(readPackedUint(transDests[pos*f.transSize : pos*f.transSize+f.transSize]))
 	if dest > 0 {
 		// convert delta
-		dest = f.bottom - dest
+		dest = f.base + f.bottom - dest
 	}
 	transVals := f.data[f.outBottom:f.outTop]
 	var out uint64
@@ -263,7 +458,7 @@ func (f *fstStateV1) TransitionFor(b byte) (int, int, uint64) {
 
 func (f *fstStateV1) String() string {
 	rv := ""
-	rv += fmt.Sprintf("State: %d (%#x)", f.top, f.top)
+	rv += fmt.Sprintf("State: %d (%#x)", f.Address(), f.Address())
 	if f.final {
 		rv += " final"
 		fout := f.FinalOutput()
@@ -293,7 +488,7 @@ func (f *fstStateV1) DotString(num int) string {
 	if f.final {
 		final = ",peripheries=2"
 	}
-	rv += fmt.Sprintf("    %d [label=\"%s\"%s];\n", f.top, label, final)
+	rv += fmt.Sprintf("    %d [label=\"%s\"%s];\n", f.Address(), label, final)
 
 	for i := 0; i < f.numTrans; i++ {
 		transChar := f.TransitionAt(i)
@@ -302,7 +497,7 @@ func (f *fstStateV1) DotString(num int) string {
 		if transOut != 0 {
 			out = fmt.Sprintf("/%d", transOut)
 		}
-		rv += fmt.Sprintf("    %d -> %d [label=\"%s%s\"];\n", f.top, transDest, escapeInput(transChar), out)
+		rv += fmt.Sprintf("    %d -> %d [label=\"%s%s\"];\n", f.Address(), transDest, escapeInput(transChar), out)
 	}
 
 	return rv
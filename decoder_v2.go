@@ -0,0 +1,136 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "math/bits"
+
+func init() {
+	registerDecoder(versionV2, func(data []byte) decoder {
+		rv := newDecoderV1(data)
+		rv.dense = true
+		return rv
+	})
+}
+
+func (f *fstStateV1) atDense(data []byte, addr int) error {
+	f.bottom-- // extra byte with pack sizes
+	if f.bottom < f.low() {
+		return f.truncated(addr)
+	}
+	f.transSize, f.outSize = decodePackSize(data[f.bottom])
+	if err := f.checkPackSizes(); err != nil {
+		return err
+	}
+	size := denseBitmapSize + f.numTrans*(f.transSize+f.outSize)
+	if f.final {
+		size += f.outSize
+	}
+	if f.bottom-size < f.low() {
+		return f.truncated(addr)
+	}
+	f.dense = true
+
+	f.transTop = f.bottom
+	f.bottom -= denseBitmapSize
+	f.transBottom = f.bottom
+	var count int
+	for i := 0; i < denseBitmapSize/8; i++ {
+		count += bits.OnesCount64(denseWord(data[f.transBottom:], i))
+	}
+	if count != f.numTrans {
+		return corruptf(addr, "dense state has %d labels for %d transitions",
+			count, f.numTrans)
+	}
+
+	f.destTop = f.bottom
+	f.bottom -= f.numTrans * f.transSize
+	f.destBottom = f.bottom
+
+	if f.outSize > 0 {
+		f.outTop = f.bottom
+		f.bottom -= f.numTrans * f.outSize
+		f.outBottom = f.bottom
+		if f.final {
+			f.bottom -= f.outSize
+			f.outFinal = f.bottom
+		}
+	}
+	return nil
+}
+
+// denseRank returns the number of labels less than b
+func (f *fstStateV1) denseRank(b byte) int {
+	bitmap := f.data[f.transBottom:f.transTop]
+	w := int(b >> 6)
+	var rv int
+	for i := 0; i < w; i++ {
+		rv += bits.OnesCount64(denseWord(bitmap, i))
+	}
+	return rv + bits.OnesCount64(denseWord(bitmap, w)&(1<<(b&63)-1))
+}
+
+// denseLabel returns the i-th smallest label
+func (f *fstStateV1) denseLabel(i int) byte {
+	bitmap := f.data[f.transBottom:f.transTop]
+	for w := 0; w < denseBitmapSize/8; w++ {
+		word := denseWord(bitmap, w)
+		n := bits.OnesCount64(word)
+		if i < n {
+			return byte(w<<6 + selectBit(word, i))
+		}
+		i -= n
+	}
+	return 0
+}
+
+func (f *fstStateV1) denseTransitionFor(b byte) (int, int, uint64) {
+	bitmap := f.data[f.transBottom:f.transTop]
+	w := int(b >> 6)
+	word := denseWord(bitmap, w)
+	if word&(1<<(b&63)) == 0 {
+		return -1, noneAddr, 0
+	}
+	pos := bits.OnesCount64(word & (1<<(b&63) - 1))
+	for i := 0; i < w; i++ {
+		pos += bits.OnesCount64(denseWord(bitmap, i))
+	}
+	start := f.destBottom + pos*f.transSize
+	dest := int(readPackedUint(f.data[start : start+f.transSize]))
+	if dest > 0 {
+		// convert delta
+		dest = f.base + f.bottom - dest
+	}
+	var out uint64
+	if f.outSize > 0 {
+		start = f.outBottom + pos*f.outSize
+		out = readPackedUint(f.data[start : start+f.outSize])
+	}
+	return pos, dest, out
+}
+
+// selectBit returns the position of the i-th lowest bit set in the word,
+// halving the range searched with each population count
+func selectBit(word uint64, i int) int {
+	var pos uint
+	for width := uint(32); width > 0; width >>= 1 {
+		n := bits.OnesCount64(word & (1<<width - 1))
+		if i >= n {
+			i -= n
+			word >>= width
+			pos += width
+		}
+	}
+	return int(pos)
+}
@@ -0,0 +1,176 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// denseKeys returns keys whose states near the root have many transitions,
+// the root all 256, and some of those below it more than denseMinTrans
+func denseKeys() []string {
+	var rv []string
+	for a := 0; a < 256; a++ {
+		rv = append(rv, string([]byte{byte(a)}))
+		for b := 0; b < 256; b += 1 + a%3 {
+			rv = append(rv, string([]byte{byte(a), byte(b)}))
+		}
+	}
+	return rv
+}
+
+func buildVersion(t testing.TB, ver int, keys []string, vals []uint64) []byte {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithVersion(ver))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, keys, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDenseStates(t *testing.T) {
+	keys := denseKeys()
+	vals := randomValues(keys)
+	v1 := buildVersion(t, versionV1, keys, vals)
+	v2 := buildVersion(t, versionV2, keys, vals)
+	if len(v2) >= len(v1) {
+		t.Errorf("expected dense states to be smaller, got %d >= %d", len(v2),
+			len(v1))
+	}
+
+	fst1, err := Load(v1)
+	if err != nil {
+		t.Fatalf("error loading v1: %v", err)
+	}
+	fst2, err := Load(v2)
+	if err != nil {
+		t.Fatalf("error loading v2: %v", err)
+	}
+	if fst2.Version() != versionV2 {
+		t.Errorf("expected version 2, got %d", fst2.Version())
+	}
+	root, err := fst2.decoder.stateAt(fst2.decoder.getRoot(), nil)
+	if err != nil {
+		t.Fatalf("error decoding root: %v", err)
+	}
+	if !root.(*fstStateV1).dense || root.NumTransitions() != 256 {
+		t.Errorf("expected dense root with 256 transitions")
+	}
+
+	for i, key := range keys {
+		val, exists, err := fst2.Get([]byte(key))
+		if err != nil || !exists || val != vals[i] {
+			t.Fatalf("expected %x %d, got %d %t %v", key, vals[i], val, exists,
+				err)
+		}
+	}
+	_, exists, err := fst2.Get([]byte{1, 1})
+	if err != nil || exists {
+		t.Errorf("expected missing key, got %t %v", exists, err)
+	}
+
+	// iteration and seeking rely on the positions of the transitions
+	for _, bounds := range [][2][]byte{{nil, nil}, {{7, 200}, {250}},
+		{{2, 1}, {2, 2, 0}}} {
+		itr1, err1 := fst1.Iterator(bounds[0], bounds[1])
+		want, _ := drainIterator(t, itr1, err1)
+		itr2, err2 := fst2.Iterator(bounds[0], bounds[1])
+		got, _ := drainIterator(t, itr2, err2)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("iterating %x: expected %d keys, got %d", bounds, len(want),
+				len(got))
+		}
+	}
+	itr, err := fst2.Iterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = itr.Seek([]byte{4, 3})
+	if key, _ := itr.Current(); err != nil || !bytes.Equal(key, []byte{4, 4}) {
+		t.Errorf("expected seek to 0404, got %x %v", key, err)
+	}
+
+	err = fst2.Debug(func(int, interface{}) error { return nil })
+	if err != nil {
+		t.Errorf("error visiting states: %v", err)
+	}
+}
+
+func BenchmarkDenseGet(b *testing.B) {
+	keys := denseKeys()
+	vals := randomValues(keys)
+	for _, ver := range []int{versionV1, versionV2} {
+		fst, err := Load(buildVersion(b, ver, keys, vals))
+		if err != nil {
+			b.Fatalf("error loading: %v", err)
+		}
+		r, err := fst.Reader()
+		if err != nil {
+			b.Fatalf("error getting reader: %v", err)
+		}
+		b.Run(fmt.Sprintf("v%d", ver), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, _ = r.Get([]byte(keys[i%len(keys)]))
+			}
+		})
+	}
+}
+
+func BenchmarkDenseIterator(b *testing.B) {
+	keys := denseKeys()
+	vals := randomValues(keys)
+	for _, ver := range []int{versionV1, versionV2} {
+		fst, err := Load(buildVersion(b, ver, keys, vals))
+		if err != nil {
+			b.Fatalf("error loading: %v", err)
+		}
+		b.Run(fmt.Sprintf("v%d", ver), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				itr, err := fst.Iterator(nil, nil)
+				for err == nil {
+					err = itr.Next()
+				}
+			}
+		})
+	}
+}
+
+func TestSelectBit(t *testing.T) {
+	for _, word := range []uint64{1, 1 << 63, 0xf0f0f0f0f0f0f0f0, ^uint64(0),
+		0x8000000100010001} {
+		var want []int
+		for pos := 0; pos < 64; pos++ {
+			if word&(1<<uint(pos)) != 0 {
+				want = append(want, pos)
+			}
+		}
+		for i, pos := range want {
+			if got := selectBit(word, i); got != pos {
+				t.Errorf("word %x bit %d: expected %d, got %d", word, i, pos, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// Depth hints record, for each state, the length of the longest key suffix
+// reachable from it, in a state table.  They allow automata implementing
+// DepthHintAutomaton to give up on states which can't reach a match within
+// that many bytes.
+
+type depthHinter struct {
+	table stateTableBuilder
+}
+
+func (d *depthHinter) section() int {
+	return sectionDepthHints
+}
+
+func (d *depthHinter) reset() {
+	d.table.reset()
+}
+
+func (d *depthHinter) lookup(addr int) uint64 {
+	if addr == emptyAddr {
+		return 0
+	}
+	return d.table.lookup(addr)
+}
+
+// add records the depth for a newly encoded state
+func (d *depthHinter) add(addr int, node *builderNode) {
+	var depth uint64
+	for _, t := range node.trans {
+		if n := d.lookup(t.addr) + 1; n > depth {
+			depth = n
+		}
+	}
+	d.table.add(addr, depth)
+}
+
+func (d *depthHinter) encode() []byte {
+	return d.table.encode()
+}
+
+// depthHints reads the depths from the section data
+type depthHints struct {
+	table *stateTable
+}
+
+func loadDepthHints(data []byte) (*depthHints, error) {
+	table, err := loadStateTable(data, "depth hints")
+	if err != nil {
+		return nil, err
+	}
+	return &depthHints{table: table}, nil
+}
+
+// get returns the length of the longest key suffix reachable from the state
+// at addr
+func (d *depthHints) get(addr int) (int, bool) {
+	if addr == emptyAddr {
+		return 0, true
+	}
+	rv, ok := d.table.get(addr)
+	return int(rv), ok
+}
@@ -0,0 +1,107 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum/levenshtein2"
+)
+
+// countingAutomaton counts the transitions taken
+type countingAutomaton struct {
+	DepthHintAutomaton
+	accepts int
+}
+
+func (c *countingAutomaton) Accept(s int, b byte) int {
+	c.accepts++
+	return c.DepthHintAutomaton.Accept(s, b)
+}
+
+func TestDepthHints(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithDepthHints(), WithSubtreeCounts())
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, randomValues(thousandTestWords))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	withHints, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if withHints.depths == nil || withHints.counts == nil {
+		t.Fatalf("expected depth hints and subtree counts")
+	}
+	longest := 0
+	for _, word := range thousandTestWords {
+		if len(word) > longest {
+			longest = len(word)
+		}
+	}
+	depth, ok := withHints.depths.get(withHints.decoder.getRoot())
+	if !ok || depth != longest {
+		t.Errorf("expected root depth %d, got %d %t", longest, depth, ok)
+	}
+
+	withoutHints, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	lb, err := levenshtein2.NewLevenshteinAutomatonBuilder(2, false)
+	if err != nil {
+		t.Fatalf("error creating levenshtein builder: %v", err)
+	}
+	var pruned bool
+	for _, term := range []string{"the", "internationalization", "wtaer",
+		"prophecy", "zzzzzzzzzzzzzzzzzzzzzzzzz"} {
+		dfa, err := lb.BuildDfa(term, 2)
+		if err != nil {
+			t.Fatalf("error building dfa: %v", err)
+		}
+		var results [2][]string
+		var accepts [2]int
+		for j, fst := range []*FST{withoutHints, withHints} {
+			aut := &countingAutomaton{DepthHintAutomaton: dfa}
+			itr, err := fst.Search(aut, nil, nil)
+			results[j] = iterateKeys(t, itr, err)
+			accepts[j] = aut.accepts
+		}
+		if !reflect.DeepEqual(results[0], results[1]) {
+			t.Errorf("%s: expected %v with depth hints, got %v", term,
+				results[0], results[1])
+		}
+		if accepts[1] > accepts[0] {
+			t.Errorf("%s: expected no more transitions with depth hints, got %d > %d",
+				term, accepts[1], accepts[0])
+		}
+		if accepts[1] < accepts[0] {
+			pruned = true
+		}
+	}
+	if !pruned {
+		t.Errorf("expected depth hints to prune some transitions")
+	}
+}
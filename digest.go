@@ -0,0 +1,171 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+// ErrNoKeyDigests is returned by GetVerified for an FST built without key
+// digests, see BuilderOpts.KeyDigests.
+var ErrNoKeyDigests = errors.New("FST built without key digests")
+
+// Key digests are a short hash of each key and its value, stored in key
+// order in an optional section:
+//
+//	1 byte digest size
+//	for each key: digest
+//
+// The position of a key is found with the subtree counts, which are always
+// recorded with the digests.
+
+// keyDigest returns the digest of the key and value, truncated to size
+// bytes
+func keyDigest(key []byte, val uint64, size int) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], val)
+	_, _ = h.Write(buf[:])
+	rv := h.Sum64()
+	if size < 8 {
+		rv &= 1<<(uint(size)*8) - 1
+	}
+	return rv
+}
+
+// keyDigester records the digests of the keys while building
+type keyDigester struct {
+	size int
+	data []byte
+}
+
+func newKeyDigester(size int) *keyDigester {
+	return &keyDigester{
+		size: size,
+		data: []byte{byte(size)},
+	}
+}
+
+func (d *keyDigester) reset() {
+	d.data = d.data[:1]
+}
+
+func (d *keyDigester) add(key []byte, val uint64) {
+	n := len(d.data)
+	for i := 0; i < d.size; i++ {
+		d.data = append(d.data, 0)
+	}
+	putPackedUint(d.data[n:], keyDigest(key, val, d.size))
+}
+
+func (d *keyDigester) encode() []byte {
+	return d.data
+}
+
+// keyDigests reads the digests from the section data
+type keyDigests struct {
+	data []byte
+	size int
+}
+
+func loadKeyDigests(data []byte, n int) (*keyDigests, error) {
+	if len(data) < 1 || data[0] < 1 || data[0] > 8 {
+		return nil, corruptf(0, "invalid key digests section")
+	}
+	rv := &keyDigests{
+		data: data[1:],
+		size: int(data[0]),
+	}
+	if len(rv.data) != n*rv.size {
+		return nil, corruptf(0, "invalid key digests section length %d for %d keys",
+			len(data), n)
+	}
+	return rv, nil
+}
+
+// get returns the digest of the key at the position
+func (d *keyDigests) get(pos uint64) (uint64, bool) {
+	if pos >= uint64(len(d.data)/d.size) {
+		return 0, false
+	}
+	start := int(pos) * d.size
+	return readPackedUint(d.data[start : start+d.size]), true
+}
+
+// GetVerified returns the value associated with the key, as Get does,
+// but also checks the digest recorded for the key when the FST was built,
+// returning an error matching ErrCorrupt if the traversal didn't land on
+// the key it was given, or found the wrong value for it.  It guards
+// against corruption the decoder can't detect, at the cost of counting the
+// keys before this one along the way.  ErrNoKeyDigests is returned if the
+// FST was built without key digests.
+func (f *FST) GetVerified(key []byte) (uint64, bool, error) {
+	if err := f.enter(); err != nil {
+		return 0, false, err
+	}
+	defer f.exit()
+	if f.digests == nil {
+		return 0, false, ErrNoKeyDigests
+	}
+	curr := f.decoder.getRoot()
+	state, err := f.decoder.stateAt(curr, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	// pos counts the keys before this one
+	var pos, total uint64
+	for _, c := range key {
+		if state.Final() {
+			pos++
+		}
+		for j := 0; j < state.NumTransitions(); j++ {
+			t := state.TransitionAt(j)
+			if t >= c {
+				break
+			}
+			_, addr, _ := state.TransitionFor(t)
+			n, ok := f.counts.get(addr)
+			if !ok {
+				return 0, false, corruptf(addr, "missing subtree count")
+			}
+			pos += n
+		}
+		_, next, output := state.TransitionFor(c)
+		if next == noneAddr {
+			return 0, false, nil
+		}
+		state, err = f.decoder.stateAt(next, state)
+		if err != nil {
+			return 0, false, err
+		}
+		curr = next
+		total += output
+	}
+	if !state.Final() {
+		return 0, false, nil
+	}
+	val, err := f.value(total + state.FinalOutput())
+	if err != nil {
+		return 0, false, err
+	}
+	digest, ok := f.digests.get(pos)
+	if !ok || digest != keyDigest(key, val, f.digests.size) {
+		return 0, false, corruptf(curr, "key digest mismatch at position %d", pos)
+	}
+	return val, true, nil
+}
@@ -0,0 +1,89 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestGetVerified(t *testing.T) {
+	words := append([]string{""}, thousandTestWords...)
+	vals := randomValues(words)
+	build := func(opts ...BuilderOption) *FST {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, words, vals)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		fst, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		return fst
+	}
+
+	for _, opts := range [][]BuilderOption{
+		{WithKeyDigests(4)},
+		{WithKeyDigests(1), WithInternedValues()},
+		{WithKeyDigests(8), WithVersion(2)},
+	} {
+		fst := build(opts...)
+		for i, word := range words {
+			val, exists, err := fst.GetVerified([]byte(word))
+			if err != nil || !exists || val != vals[i] {
+				t.Fatalf("expected %q %d, got %d %t %v", word, vals[i], val,
+					exists, err)
+			}
+		}
+		for _, missing := range []string{"zzz", "a\x00", words[5] + "\xff"} {
+			_, exists, err := fst.GetVerified([]byte(missing))
+			if err != nil || exists {
+				t.Errorf("expected %q missing, got %t %v", missing, exists, err)
+			}
+		}
+	}
+
+	// a digest not matching the key and value found is reported
+	fst := build(WithKeyDigests(2))
+	fst.digests.data = append([]byte(nil), fst.digests.data...)
+	fst.digests.data[2*7] ^= 0xff
+	_, _, err := fst.GetVerified([]byte(words[7]))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+	_, exists, err := fst.GetVerified([]byte(words[8]))
+	if err != nil || !exists {
+		t.Errorf("expected other keys to verify, got %t %v", exists, err)
+	}
+
+	_, _, err = build().GetVerified([]byte(words[1]))
+	if err != ErrNoKeyDigests {
+		t.Errorf("expected ErrNoKeyDigests, got %v", err)
+	}
+	_, err = New(&bytes.Buffer{}, WithKeyDigests(9))
+	if err == nil {
+		t.Errorf("expected invalid digest size to be rejected")
+	}
+}
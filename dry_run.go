@@ -0,0 +1,58 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "io/ioutil"
+
+// BuildStats describes the FST built by a Builder.  The JSON encoding is
+// stable, see StatsSchemaVersion.
+type BuildStats struct {
+	// SchemaVersion is the StatsSchemaVersion which produced this value
+	SchemaVersion int `json:"schema_version"`
+	// Keys is the number of keys inserted
+	Keys int `json:"keys"`
+	// States is the number of states written, those equivalent to states
+	// already written being shared instead, and the final state without
+	// transitions being implied
+	States int `json:"states"`
+	// Transitions is the number of transitions of the states written
+	Transitions int `json:"transitions"`
+	// Bytes is the size of the FST encoded so far, once the Builder is
+	// closed, the size of the FST
+	Bytes int64 `json:"bytes"`
+	// Registry describes the sharing of states, see RegistryStats
+	Registry RegistryStats `json:"registry"`
+}
+
+// BuildStats returns statistics about the FST built since the Builder was
+// created or last Reset.
+func (b *Builder) BuildStats() BuildStats {
+	return BuildStats{
+		SchemaVersion: StatsSchemaVersion,
+		Keys:          b.len,
+		States:        b.states,
+		Transitions:   b.transitions,
+		Bytes:         b.out.stats.Written + int64(b.encoder.buffered()),
+		Registry:      b.registry.getStats(),
+	}
+}
+
+// NewDryRun returns a new Builder which builds the FST as New does, with
+// the options, but discards it, so that its BuildStats give the size and
+// the shape of the FST which would be built, for capacity planning or
+// comparing options, without writing it.
+func NewDryRun(opts ...BuilderOption) (*Builder, error) {
+	return New(ioutil.Discard, opts...)
+}
@@ -0,0 +1,88 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	vals := randomValues(thousandTestWords)
+	build := func(b *Builder, err error) BuildStats {
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, thousandTestWords, vals)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		return b.BuildStats()
+	}
+
+	var buf bytes.Buffer
+	stats := build(New(&buf, WithSubtreeCounts()))
+	dry := build(NewDryRun(WithSubtreeCounts()))
+	if !reflect.DeepEqual(stats, dry) {
+		t.Errorf("expected the stats of the dry run %+v to be %+v", dry, stats)
+	}
+	if dry.Bytes != int64(buf.Len()) || dry.Keys != len(thousandTestWords) {
+		t.Errorf("expected %d bytes and %d keys, got %+v", buf.Len(),
+			len(thousandTestWords), dry)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	fstStats, err := fst.Stats()
+	if err != nil {
+		t.Fatalf("error getting stats: %v", err)
+	}
+	// the final state without transitions isn't written
+	if dry.States != fstStats.States-1 ||
+		dry.Transitions != fstStats.Transitions {
+		t.Errorf("expected %d states and %d transitions, got %+v",
+			fstStats.States-1, fstStats.Transitions, dry)
+	}
+
+	// comparing options
+	plain := build(NewDryRun())
+	small := build(NewDryRun(WithRegistrySize(1, 1)))
+	if small.Bytes <= plain.Bytes || small.States <= plain.States ||
+		small.Registry.Hits >= plain.Registry.Hits {
+		t.Errorf("expected a smaller registry to share fewer states, got %+v, "+
+			"and %+v", small, plain)
+	}
+
+	// before closing, the size so far
+	b, err := NewDryRun()
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords[:500], vals[:500])
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	partial := b.BuildStats()
+	if partial.Keys != 500 || partial.Bytes <= headerSize ||
+		partial.Bytes >= dry.Bytes {
+		t.Errorf("expected the stats of half the keys, got %+v", partial)
+	}
+}
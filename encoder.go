@@ -0,0 +1,194 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"fmt"
+	"io"
+)
+
+// Encoder writes the states of an FST as a Builder compiles them, allowing
+// alternative layouts to be experimented with, without changing the
+// Builder.  An Encoder is registered for an encoding version with
+// RegisterEncoder, and selected with WithVersion.
+//
+// The Builder calls Start once, EncodeState once for each distinct state,
+// always after the states it transitions to, EncodeSection for each
+// optional section (see docs/format.md), and finally Finish.  Reset
+// prepares the Encoder to write another FST.
+//
+// An Encoder buffering its output can also implement Flush() error, to
+// write the buffered data, and Buffered() int, returning its size, which
+// are used by Builder.Flush and Builder.FlowStats.
+//
+// Reading an FST requires a decoder for the version recorded in its header.
+// Encoders producing a layout the existing decoder understands, such as
+// one adding padding between states, can record version 1, and are then
+// read as usual.
+type Encoder interface {
+	// Start writes the header, typ is the header type, a set of flags
+	Start(typ int) error
+
+	// EncodeState writes the state, and returns the address transitions to
+	// it will use.  lastAddr is the address returned by the previous call,
+	// allowing a transition to the state just written to be encoded more
+	// compactly.  Addresses 0 and 1 are reserved, 0 is the final state
+	// without transitions or output, which the Builder never passes to
+	// EncodeState, and 1 denotes no state.
+	EncodeState(s *EncoderState, lastAddr int) (int, error)
+
+	// EncodeSection writes the data of an optional section
+	EncodeSection(id int, data []byte) error
+
+	// Finish writes the footer, with the number of keys and the address of
+	// the root state, and flushes any buffered data
+	Finish(count, rootAddr int) error
+
+	// Reset discards any state, to write another FST to w
+	Reset(w io.Writer)
+}
+
+// EncoderState is a state passed to an Encoder.  It is only valid for the
+// duration of the EncodeState call.
+type EncoderState struct {
+	Final       bool
+	FinalOutput uint64
+	// Transitions are sorted by Label
+	Transitions []EncoderTransition
+}
+
+// EncoderTransition is a transition of an EncoderState.
+type EncoderTransition struct {
+	Label  byte
+	Output uint64
+	// Addr is the address of the target state, returned when it was
+	// encoded
+	Addr int
+}
+
+// RegisterEncoder registers the Encoder constructor for an encoding
+// version, which must not already have one.  It is intended to be called
+// from an init function, and must not be called while FSTs are built.
+func RegisterEncoder(ver int, cons func(w io.Writer) Encoder) error {
+	if _, ok := encoders[ver]; ok {
+		return fmt.Errorf("encoder for version %d already registered", ver)
+	}
+	registerEncoder(ver, func(w io.Writer) encoder {
+		return &externalEncoder{e: cons(w)}
+	})
+	return nil
+}
+
+// NewDefaultEncoder returns the Encoder used for version 1, which other
+// Encoders can delegate to.
+func NewDefaultEncoder(w io.Writer) Encoder {
+	return &defaultEncoder{e: newEncoderV1(w)}
+}
+
+// externalEncoder adapts an Encoder to the encoder used by the Builder
+type externalEncoder struct {
+	e     Encoder
+	state EncoderState
+}
+
+func (x *externalEncoder) start(typ int) error {
+	return x.e.Start(typ)
+}
+
+func (x *externalEncoder) encodeState(s *builderNode, lastAddr int) (int, error) {
+	x.state.Final = s.final
+	x.state.FinalOutput = s.finalOutput
+	x.state.Transitions = x.state.Transitions[:0]
+	for _, t := range s.trans {
+		x.state.Transitions = append(x.state.Transitions, EncoderTransition{
+			Label:  t.in,
+			Output: t.out,
+			Addr:   t.addr,
+		})
+	}
+	return x.e.EncodeState(&x.state, lastAddr)
+}
+
+func (x *externalEncoder) encodeSection(id int, data []byte) error {
+	return x.e.EncodeSection(id, data)
+}
+
+func (x *externalEncoder) finish(count, rootAddr int) error {
+	return x.e.Finish(count, rootAddr)
+}
+
+func (x *externalEncoder) reset(w io.Writer) {
+	x.e.Reset(w)
+}
+
+func (x *externalEncoder) flush() error {
+	if f, ok := x.e.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (x *externalEncoder) buffered() int {
+	if b, ok := x.e.(interface{ Buffered() int }); ok {
+		return b.Buffered()
+	}
+	return 0
+}
+
+// defaultEncoder adapts the version 1 encoder to the Encoder interface
+type defaultEncoder struct {
+	e    *encoderV1
+	node builderNode
+}
+
+func (d *defaultEncoder) Start(typ int) error {
+	return d.e.start(typ)
+}
+
+func (d *defaultEncoder) EncodeState(s *EncoderState, lastAddr int) (int, error) {
+	d.node.final = s.Final
+	d.node.finalOutput = s.FinalOutput
+	d.node.trans = d.node.trans[:0]
+	for _, t := range s.Transitions {
+		d.node.trans = append(d.node.trans, transition{
+			in:   t.Label,
+			out:  t.Output,
+			addr: t.Addr,
+		})
+	}
+	return d.e.encodeState(&d.node, lastAddr)
+}
+
+func (d *defaultEncoder) EncodeSection(id int, data []byte) error {
+	return d.e.encodeSection(id, data)
+}
+
+func (d *defaultEncoder) Finish(count, rootAddr int) error {
+	return d.e.finish(count, rootAddr)
+}
+
+func (d *defaultEncoder) Reset(w io.Writer) {
+	d.e.reset(w)
+}
+
+// Flush writes the buffered data
+func (d *defaultEncoder) Flush() error {
+	return d.e.flush()
+}
+
+// Buffered returns the number of bytes buffered
+func (d *defaultEncoder) Buffered() int {
+	return d.e.buffered()
+}
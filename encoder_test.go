@@ -0,0 +1,107 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+const countingEncoderVersion = 1000
+
+// countingEncoder delegates to the default encoder, counting the states
+// and transitions encoded
+type countingEncoder struct {
+	Encoder
+	states      int
+	transitions int
+	sorted      bool
+}
+
+var lastCountingEncoder *countingEncoder
+
+func init() {
+	err := RegisterEncoder(countingEncoderVersion, func(w io.Writer) Encoder {
+		lastCountingEncoder = &countingEncoder{
+			Encoder: NewDefaultEncoder(w),
+			sorted:  true,
+		}
+		return lastCountingEncoder
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (c *countingEncoder) EncodeState(s *EncoderState, lastAddr int) (int, error) {
+	c.states++
+	c.transitions += len(s.Transitions)
+	for i := 1; i < len(s.Transitions); i++ {
+		if s.Transitions[i-1].Label >= s.Transitions[i].Label {
+			c.sorted = false
+		}
+	}
+	return c.Encoder.EncodeState(s, lastAddr)
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	err := RegisterEncoder(versionV1, NewDefaultEncoder)
+	if err == nil {
+		t.Errorf("expected error registering version 1 again")
+	}
+
+	vals := randomValues(thousandTestWords)
+	build := func(opts ...BuilderOption) []byte {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, thousandTestWords, vals)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing: %v", err)
+		}
+		return buf.Bytes()
+	}
+	want := build(WithSubtreeCounts())
+	got := build(WithSubtreeCounts(), WithVersion(countingEncoderVersion))
+	if !bytes.Equal(want, got) {
+		t.Errorf("expected the default encoder to produce the same FST")
+	}
+	if !lastCountingEncoder.sorted {
+		t.Errorf("expected transitions sorted by label")
+	}
+
+	fst, err := Load(got)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	stats, err := fst.Stats()
+	if err != nil {
+		t.Fatalf("error getting stats: %v", err)
+	}
+	// the final state without transitions is never encoded
+	if lastCountingEncoder.states != stats.States-1 ||
+		lastCountingEncoder.transitions != stats.Transitions {
+		t.Errorf("expected %d states and %d transitions encoded, got %d %d",
+			stats.States-1, stats.Transitions, lastCountingEncoder.states,
+			lastCountingEncoder.transitions)
+	}
+}
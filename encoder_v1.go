@@ -33,23 +33,46 @@ func init() {
 }
 
 type encoderV1 struct {
-	bw *writer
+	bw       *writer
+	typ      int
+	sections []sectionEntry
+
+	// ver is written in the header, later versions reuse this encoder
+	ver int
+	// dense is set if states with many transitions are encoded with a
+	// bitmap of their labels, see encoder_v2.go
+	dense bool
 }
 
 func newEncoderV1(w io.Writer) *encoderV1 {
 	return &encoderV1{
-		bw: newWriter(w),
+		bw:  newWriter(w),
+		ver: versionV1,
 	}
 }
 
 func (e *encoderV1) reset(w io.Writer) {
 	e.bw.Reset(w)
+	e.sections = e.sections[:0]
+}
+
+func (e *encoderV1) flush() error {
+	return e.bw.Flush()
 }
 
-func (e *encoderV1) start() error {
+func (e *encoderV1) buffered() int {
+	return e.bw.Buffered()
+}
+
+func (e *encoderV1) start(typ int) error {
+	e.typ = typ
+	e.bw.sum = nil
+	if typ&typeChecksums != 0 {
+		e.bw.sum = newBlockChecksummer(checksumBlockSize)
+	}
 	header := make([]byte, headerSize)
-	binary.LittleEndian.PutUint64(header, versionV1)
-	binary.LittleEndian.PutUint64(header[8:], uint64(0)) // type
+	binary.LittleEndian.PutUint64(header, uint64(e.ver))
+	binary.LittleEndian.PutUint64(header[8:], uint64(typ)) // type
 	n, err := e.bw.Write(header)
 	if err != nil {
 		return err
@@ -63,6 +86,8 @@ func (e *encoderV1) start() error {
 func (e *encoderV1) encodeState(s *builderNode, lastAddr int) (int, error) {
 	if len(s.trans) == 0 && s.final && s.finalOutput == 0 {
 		return 0, nil
+	} else if e.dense && len(s.trans) > denseMinTrans {
+		return e.encodeStateDense(s)
 	} else if len(s.trans) != 1 || s.final {
 		return e.encodeStateMany(s)
 	} else if !s.final && s.trans[0].out == 0 && s.trans[0].addr == lastAddr {
@@ -208,10 +233,37 @@ func (e *encoderV1) encodeStateMany(s *builderNode) (int, error) {
 	return e.bw.counter - 1, nil
 }
 
+// encodeSection writes out the data of an optional section, it must be
+// called after the last state has been encoded.
+func (e *encoderV1) encodeSection(id int, data []byte) error {
+	if e.typ&typeSections == 0 {
+		return fmt.Errorf("sections not enabled in type %d", e.typ)
+	}
+	e.sections = append(e.sections, sectionEntry{
+		id:     uint64(id),
+		offset: uint64(e.bw.counter),
+		length: uint64(len(data)),
+	})
+	_, err := e.bw.Write(data)
+	return err
+}
+
 func (e *encoderV1) finish(count, rootAddr int) error {
 	footer := make([]byte, footerSizeV1)
 	binary.LittleEndian.PutUint64(footer, uint64(count))        // root addr
 	binary.LittleEndian.PutUint64(footer[8:], uint64(rootAddr)) // root addr
+	if e.typ&typeChecksums != 0 {
+		err := e.encodeChecksums(footer)
+		if err != nil {
+			return err
+		}
+	}
+	if e.typ&typeSections != 0 {
+		_, err := e.bw.Write(e.sectionTable())
+		if err != nil {
+			return err
+		}
+	}
 	n, err := e.bw.Write(footer)
 	if err != nil {
 		return err
@@ -223,5 +275,36 @@ func (e *encoderV1) finish(count, rootAddr int) error {
 	if err != nil {
 		return err
 	}
+	e.sections = e.sections[:0]
 	return nil
 }
+
+func (e *encoderV1) sectionTable() []byte {
+	buf := make([]byte, len(e.sections)*sectionEntrySize+8)
+	for i, s := range e.sections {
+		s.put(buf[i*sectionEntrySize:])
+	}
+	binary.LittleEndian.PutUint64(buf[len(e.sections)*sectionEntrySize:],
+		uint64(len(e.sections)))
+	return buf
+}
+
+// encodeChecksums writes the checksum section, the last one, with the
+// checksums of the blocks written so far, and of the section table and
+// footer which will follow it.
+func (e *encoderV1) encodeChecksums(footer []byte) error {
+	sums := e.bw.sum.finish()
+	e.bw.sum = nil
+	data := make([]byte, checksumHeaderSize+4*len(sums))
+	e.sections = append(e.sections, sectionEntry{
+		id:     sectionChecksums,
+		offset: uint64(e.bw.counter),
+		length: uint64(len(data)),
+	})
+	putChecksumHeader(data, checksumBlockSize, e.sectionTable(), footer)
+	for i, sum := range sums {
+		binary.LittleEndian.PutUint32(data[checksumHeaderSize+4*i:], sum)
+	}
+	_, err := e.bw.Write(data)
+	return err
+}
@@ -0,0 +1,128 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Version 2 is version 1, except that states with more than denseMinTrans
+// transitions are dense states, which record their labels in a 256 bit
+// bitmap, rather than one byte each.  The position of a transition is
+// then the number of bits set before its label, computed with a few
+// population counts, instead of searching the labels.
+const versionV2 = 2
+
+const denseMinTrans = 100
+
+// size of the bitmap of labels of a dense state
+const denseBitmapSize = 32
+
+func init() {
+	registerEncoder(versionV2, func(w io.Writer) encoder {
+		return newEncoderV2(w)
+	})
+}
+
+func newEncoderV2(w io.Writer) *encoderV1 {
+	rv := newEncoderV1(w)
+	rv.ver = versionV2
+	rv.dense = true
+	return rv
+}
+
+// encodeStateDense writes a dense state, from its lowest address up: the
+// final output, the outputs and the delta addresses of the transitions in
+// label order, the bitmap of labels, as 4 little-endian uint64, the pack
+// sizes, the number of transitions, and the state top byte, as for a state
+// with multiple transitions.
+func (e *encoderV1) encodeStateDense(s *builderNode) (int, error) {
+	start := uint64(e.bw.counter)
+	transPackSize := 0
+	outPackSize := packedSize(s.finalOutput)
+	anyOutputs := s.finalOutput != 0
+	var bitmap [denseBitmapSize]byte
+	for i := range s.trans {
+		delta := deltaAddr(start, uint64(s.trans[i].addr))
+		tsize := packedSize(delta)
+		if tsize > transPackSize {
+			transPackSize = tsize
+		}
+		osize := packedSize(s.trans[i].out)
+		if osize > outPackSize {
+			outPackSize = osize
+		}
+		anyOutputs = anyOutputs || s.trans[i].out != 0
+		bitmap[s.trans[i].in>>3] |= 1 << (s.trans[i].in & 7)
+	}
+	if !anyOutputs {
+		outPackSize = 0
+	}
+
+	if anyOutputs {
+		if s.final {
+			err := e.bw.WritePackedUintIn(s.finalOutput, outPackSize)
+			if err != nil {
+				return 0, err
+			}
+		}
+		for j := range s.trans {
+			err := e.bw.WritePackedUintIn(s.trans[j].out, outPackSize)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	for j := range s.trans {
+		delta := deltaAddr(start, uint64(s.trans[j].addr))
+		err := e.bw.WritePackedUintIn(delta, transPackSize)
+		if err != nil {
+			return 0, err
+		}
+	}
+	// bytes in this order are the little-endian words
+	_, err := e.bw.Write(bitmap[:])
+	if err != nil {
+		return 0, err
+	}
+
+	err = e.bw.WriteByte(encodePackSize(transPackSize, outPackSize))
+	if err != nil {
+		return 0, err
+	}
+	numTrans := len(s.trans)
+	if numTrans == 256 {
+		numTrans = 1
+	}
+	err = e.bw.WriteByte(byte(numTrans))
+	if err != nil {
+		return 0, err
+	}
+	var top byte
+	if s.final {
+		top |= stateFinal
+	}
+	err = e.bw.WriteByte(top)
+	if err != nil {
+		return 0, err
+	}
+	return e.bw.counter - 1, nil
+}
+
+// denseWord returns the word of the bitmap holding bits 64*i to 64*i+63
+func denseWord(bitmap []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(bitmap[i*8:])
+}
@@ -22,6 +22,26 @@ import (
 
 const headerSize = 16
 
+// typeSections is set in the header type when a table of optional sections
+// (see sections.go) precedes the footer.
+const typeSections = 1 << 0
+
+// typeInternedValues is set in the header type when the outputs are indexes
+// into a table of values (see values.go).
+const typeInternedValues = 1 << 1
+
+// typePayloads is set in the header type when the outputs are indexes into
+// a table of byte slice payloads (see payloads.go).
+const typePayloads = 1 << 2
+
+// typeValueLists is set in the header type when the outputs are indexes into
+// a table of lists of values (see multi_values.go).
+const typeValueLists = 1 << 3
+
+// typeChecksums is set in the header type when a section of checksums of
+// the data (see checksums.go) precedes the section table.
+const typeChecksums = 1 << 4
+
 type encoderConstructor func(w io.Writer) encoder
 type decoderConstructor func([]byte) decoder
 
@@ -29,10 +49,15 @@ var encoders = map[int]encoderConstructor{}
 var decoders = map[int]decoderConstructor{}
 
 type encoder interface {
-	start() error
+	start(typ int) error
 	encodeState(s *builderNode, addr int) (int, error)
+	encodeSection(id int, data []byte) error
 	finish(count, rootAddr int) error
 	reset(w io.Writer)
+	// flush writes any buffered data
+	flush() error
+	// buffered returns the number of bytes buffered
+	buffered() int
 }
 
 func loadEncoder(ver int, w io.Writer) (encoder, error) {
@@ -47,9 +72,12 @@ func registerEncoder(ver int, cons encoderConstructor) {
 }
 
 type decoder interface {
+	validate() error
+	section(id int) []byte
 	getRoot() int
 	getLen() int
 	stateAt(addr int, prealloc fstState) (fstState, error)
+	sizes() Sizes
 }
 
 func loadDecoder(ver int, data []byte) (decoder, error) {
@@ -65,7 +93,7 @@ func registerDecoder(ver int, cons decoderConstructor) {
 
 func decodeHeader(header []byte) (ver int, typ int, err error) {
 	if len(header) < headerSize {
-		err = fmt.Errorf("invalid header < 16 bytes")
+		err = corruptf(len(header), "invalid header < 16 bytes")
 		return
 	}
 	ver = int(binary.LittleEndian.Uint64(header[0:8]))
@@ -84,4 +112,5 @@ type fstState interface {
 	NumTransitions() int
 	TransitionFor(b byte) (int, int, uint64)
 	TransitionAt(i int) byte
+	TransitionBefore(b byte) int
 }
@@ -0,0 +1,157 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package export writes the keys and values of an FST as columnar record
+// batches, for loading into dataframes through Apache Arrow or Parquet.
+//
+// The package doesn't depend on any Arrow or Parquet library.  Batches are
+// handed to a Writer supplied by the caller, and laid out as an Arrow
+// record batch of two columns, so that an implementation can wrap them
+// without conversion:
+//
+//	key   binary, the key bytes, Data[Offsets[i]:Offsets[i+1]]
+//	value uint64, Values[i]
+//
+// A Parquet Writer similarly writes each Batch as the rows of a row group,
+// using Key(i) as the byte array of the key column.
+package export
+
+import (
+	"errors"
+	"math"
+
+	"github.com/couchbase/vellum"
+)
+
+// Names of the columns of a Batch, to use in the schema of the exported
+// records
+const (
+	KeyColumn   = "key"
+	ValueColumn = "value"
+)
+
+// DefaultBatchSize is the number of rows of a Batch, if Opts.BatchSize is
+// zero
+const DefaultBatchSize = 64 * 1024
+
+// Batch is a batch of rows, each a key and its value, in key order.
+type Batch struct {
+	// Offsets has Len()+1 entries, the key of row i being
+	// Data[Offsets[i]:Offsets[i+1]], as in an Arrow binary array
+	Offsets []int32
+	// Data is the concatenated key bytes
+	Data []byte
+	// Values are the values of the rows
+	Values []uint64
+}
+
+// Len returns the number of rows of the batch.
+func (b *Batch) Len() int {
+	return len(b.Values)
+}
+
+// Key returns the key of row i.
+func (b *Batch) Key(i int) []byte {
+	return b.Data[b.Offsets[i]:b.Offsets[i+1]]
+}
+
+func (b *Batch) reset() {
+	b.Offsets = append(b.Offsets[:0], 0)
+	b.Data = b.Data[:0]
+	b.Values = b.Values[:0]
+}
+
+func (b *Batch) add(key []byte, val uint64) {
+	b.Data = append(b.Data, key...)
+	b.Offsets = append(b.Offsets, int32(len(b.Data)))
+	b.Values = append(b.Values, val)
+}
+
+// Writer receives the batches of an export, typically adapting them to an
+// Arrow record batch writer or a Parquet file writer.
+type Writer interface {
+	// WriteBatch writes the batch.  The batch is reused once WriteBatch
+	// returns, so a Writer which keeps it must copy it.
+	WriteBatch(b *Batch) error
+}
+
+// Opts customizes an export.
+type Opts struct {
+	// BatchSize is the maximum number of rows of a Batch, if zero
+	// DefaultBatchSize
+	BatchSize int
+}
+
+// ErrKeyTooLarge is returned when a key alone exceeds the binary offsets
+// of a Batch.
+var ErrKeyTooLarge = errors.New("key too large for a batch")
+
+// FST exports all the keys and values of the FST, returning the number of
+// rows written.
+func FST(f *vellum.FST, w Writer, opts *Opts) (int, error) {
+	itr, err := f.Iterator(nil, nil)
+	if errors.Is(err, vellum.ErrIteratorDone) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return Iterator(itr, w, opts)
+}
+
+// Iterator exports the keys and values from the current position of the
+// Iterator to its end, returning the number of rows written.  A batch is
+// written when it reaches the BatchSize, or before its key bytes would
+// exceed the 2GiB addressable by its offsets.
+func Iterator(itr vellum.Iterator, w Writer, opts *Opts) (int, error) {
+	size := DefaultBatchSize
+	if opts != nil && opts.BatchSize > 0 {
+		size = opts.BatchSize
+	}
+	var rows int
+	b := &Batch{}
+	b.reset()
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+		err := w.WriteBatch(b)
+		if err != nil {
+			return err
+		}
+		rows += b.Len()
+		b.reset()
+		return nil
+	}
+
+	var err error
+	for err == nil {
+		key, val := itr.Current()
+		if len(key) > math.MaxInt32 {
+			return rows, ErrKeyTooLarge
+		}
+		if b.Len() >= size || len(b.Data)+len(key) > math.MaxInt32 {
+			err = flush()
+			if err != nil {
+				return rows, err
+			}
+		}
+		b.add(key, val)
+		err = itr.Next()
+	}
+	if !errors.Is(err, vellum.ErrIteratorDone) {
+		return rows, err
+	}
+	return rows, flush()
+}
@@ -0,0 +1,109 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum"
+)
+
+// recordingWriter copies the rows of the batches it is given
+type recordingWriter struct {
+	sizes []int
+	keys  []string
+	vals  []uint64
+	err   error
+}
+
+func (w *recordingWriter) WriteBatch(b *Batch) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(b.Offsets) != b.Len()+1 || int(b.Offsets[b.Len()]) != len(b.Data) {
+		return fmt.Errorf("inconsistent batch %+v", b)
+	}
+	w.sizes = append(w.sizes, b.Len())
+	for i := 0; i < b.Len(); i++ {
+		w.keys = append(w.keys, string(b.Key(i)))
+		w.vals = append(w.vals, b.Values[i])
+	}
+	return nil
+}
+
+func buildFST(t *testing.T, keys []string) *vellum.FST {
+	var buf bytes.Buffer
+	b, err := vellum.New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	for i, key := range keys {
+		err = b.Insert([]byte(key), uint64(i))
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := vellum.Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	return fst
+}
+
+func TestExport(t *testing.T) {
+	keys := []string{"", "a", "bar", "bat", "car", "cat", "foo"}
+	fst := buildFST(t, keys)
+
+	w := &recordingWriter{}
+	rows, err := FST(fst, w, &Opts{BatchSize: 3})
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	if rows != len(keys) {
+		t.Errorf("expected %d rows, got %d", len(keys), rows)
+	}
+	if !reflect.DeepEqual(w.sizes, []int{3, 3, 1}) {
+		t.Errorf("expected batches of 3, 3 and 1 rows, got %v", w.sizes)
+	}
+	if !reflect.DeepEqual(w.keys, keys) {
+		t.Errorf("expected keys %q, got %q", keys, w.keys)
+	}
+	for i, val := range w.vals {
+		if val != uint64(i) {
+			t.Errorf("expected value %d for %q, got %d", i, w.keys[i], val)
+		}
+	}
+
+	w = &recordingWriter{}
+	rows, err = FST(buildFST(t, nil), w, nil)
+	if err != nil || rows != 0 || len(w.sizes) != 0 {
+		t.Errorf("expected empty export, got %d rows, %v", rows, err)
+	}
+
+	errWrite := errors.New("write failed")
+	w = &recordingWriter{err: errWrite}
+	_, err = FST(fst, w, nil)
+	if !errors.Is(err, errWrite) {
+		t.Errorf("expected the write error, got %v", err)
+	}
+}
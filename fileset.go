@@ -0,0 +1,332 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownFile is returned by FileSet.Open for a file not in the FileSet.
+var ErrUnknownFile = errors.New("unknown file")
+
+// ErrTxDone is returned when using a FileSetTx after Commit or Abort.
+var ErrTxDone = errors.New("file set transaction already committed or aborted")
+
+// ErrTxConflict is returned by FileSetTx.Write and Commit if another
+// transaction was committed since this one began.
+var ErrTxConflict = errors.New("file set changed since transaction began")
+
+const fileSetManifestName = "FILESET"
+const fileSetManifestVersion = 1
+
+// A FileSet is a group of files in a directory which are published together,
+// such as an FST, an FST of the reversed keys and a bloom filter sidecar,
+// making up one logical dictionary.
+//
+// Each file has a logical name, and is stored under that name suffixed with
+// the generation of the transaction which wrote it.  A manifest file
+// records the files of the current generation, and is replaced atomically
+// when a FileSetTx commits, so readers never see some files from one
+// generation and others from another.  The files of the previous generation
+// are kept until the next commit, so that readers which read the manifest
+// just before a commit can still open them.
+//
+// The directory must be dedicated to the FileSet.
+type FileSet struct {
+	dir      string
+	manifest *fileSetManifest
+}
+
+type fileSetManifest struct {
+	Version    int    `json:"version"`
+	Generation uint64 `json:"generation"`
+	// Files maps logical names to file names
+	Files map[string]string `json:"files"`
+	// Previous are the files of the previous generation
+	Previous map[string]string `json:"previous,omitempty"`
+}
+
+// OpenFileSet reads the current generation of the FileSet in the provided
+// directory.  If nothing has been committed yet, it is empty.
+func OpenFileSet(dir string) (*FileSet, error) {
+	manifest, err := readFileSetManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSet{dir: dir, manifest: manifest}, nil
+}
+
+// Generation returns the generation of the FileSet, incremented by every
+// commit, zero if nothing has been committed.
+func (s *FileSet) Generation() uint64 {
+	return s.manifest.Generation
+}
+
+// Names returns the logical names of the files, sorted.
+func (s *FileSet) Names() []string {
+	rv := make([]string, 0, len(s.manifest.Files))
+	for name := range s.manifest.Files {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Path returns the path of the file with the logical name, and whether it
+// is in the FileSet.
+func (s *FileSet) Path(name string) (string, bool) {
+	file, ok := s.manifest.Files[name]
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(s.dir, file), true
+}
+
+// Open opens the FST with the logical name.
+func (s *FileSet) Open(name string, opts ...OpenOption) (*FST, error) {
+	path, ok := s.Path(name)
+	if !ok {
+		return nil, ErrUnknownFile
+	}
+	return Open(path, opts...)
+}
+
+// FileSetTx writes new versions of some files of a FileSet, which are
+// published together by Commit.  Files not written by the transaction are
+// carried over unchanged.  Only one transaction may be in progress for a
+// directory at a time.
+type FileSetTx struct {
+	dir   string
+	prev  *fileSetManifest
+	files map[string]string
+	done  bool
+}
+
+// BeginFileSet starts a transaction on the FileSet in the provided
+// directory, creating it if necessary.  Files left over by transactions
+// which were never committed are removed.
+func BeginFileSet(dir string) (*FileSetTx, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := readFileSetManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = removeFileSetFiles(dir, prev.Files, prev.Previous)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSetTx{
+		dir:   dir,
+		prev:  prev,
+		files: make(map[string]string),
+	}, nil
+}
+
+// Build builds the FST with the logical name, with the provided build
+// function, which must insert keys in lexicographic order, as usual.
+func (tx *FileSetTx) Build(name string, build func(*Builder) error,
+	opts ...BuilderOption) error {
+	return tx.Write(name, func(w io.Writer) error {
+		b, err := New(w, opts...)
+		if err != nil {
+			return err
+		}
+		err = build(b)
+		if err != nil {
+			return err
+		}
+		return b.Close()
+	})
+}
+
+// Write writes the file with the logical name, such as a sidecar which
+// isn't an FST, with the provided write function.  The file isn't visible
+// to readers until the transaction is committed.
+func (tx *FileSetTx) Write(name string, write func(io.Writer) error) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !isFileSetName(name) {
+		return fmt.Errorf("invalid file set name %q", name)
+	}
+	file := fmt.Sprintf("%s.%08d", name, tx.prev.Generation+1)
+	if _, ok := tx.files[name]; !ok {
+		// another transaction already wrote this generation
+		if _, err := os.Stat(filepath.Join(tx.dir, file)); err == nil {
+			return ErrTxConflict
+		}
+	}
+	err := writeFileAtomic(filepath.Join(tx.dir, file), func(f *os.File) error {
+		return write(f)
+	})
+	if err != nil {
+		return err
+	}
+	tx.files[name] = file
+	return nil
+}
+
+// Commit publishes the files written by the transaction, together, and
+// removes the files which are no longer part of the current or previous
+// generation.
+func (tx *FileSetTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	curr, err := readFileSetManifest(tx.dir)
+	if err == nil && curr.Generation != tx.prev.Generation {
+		err = ErrTxConflict
+	}
+	if err != nil {
+		_ = tx.removeFiles()
+		return err
+	}
+	manifest := &fileSetManifest{
+		Version:    fileSetManifestVersion,
+		Generation: tx.prev.Generation + 1,
+		Files:      make(map[string]string, len(tx.prev.Files)+len(tx.files)),
+		Previous:   tx.prev.Files,
+	}
+	for name, file := range tx.prev.Files {
+		manifest.Files[name] = file
+	}
+	for name, file := range tx.files {
+		manifest.Files[name] = file
+	}
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		_ = tx.removeFiles()
+		return err
+	}
+	err = writeFileAtomic(filepath.Join(tx.dir, fileSetManifestName),
+		func(f *os.File) error {
+			_, err := f.Write(buf)
+			return err
+		})
+	if err != nil {
+		_ = tx.removeFiles()
+		return err
+	}
+	return removeFileSetFiles(tx.dir, manifest.Files, manifest.Previous)
+}
+
+// Abort discards the files written by the transaction.
+func (tx *FileSetTx) Abort() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	return tx.removeFiles()
+}
+
+func (tx *FileSetTx) removeFiles() error {
+	var rv error
+	for _, file := range tx.files {
+		err := os.Remove(filepath.Join(tx.dir, file))
+		if err != nil && rv == nil {
+			rv = err
+		}
+	}
+	return rv
+}
+
+// isFileSetName reports whether name is a valid logical name
+func isFileSetName(name string) bool {
+	return name != "" && name != fileSetManifestName &&
+		!strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".tmp") &&
+		!strings.ContainsAny(name, `/\`)
+}
+
+// isFileSetFileName reports whether the file name is that of a file of
+// some generation, or of one being written
+func isFileSetFileName(name string) bool {
+	name = strings.TrimSuffix(name, ".tmp")
+	i := strings.LastIndexByte(name, '.')
+	if i < 1 || len(name)-i-1 != 8 {
+		return false
+	}
+	for _, c := range name[i+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func readFileSetManifest(dir string) (*fileSetManifest, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, fileSetManifestName))
+	if os.IsNotExist(err) {
+		return &fileSetManifest{Version: fileSetManifestVersion}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rv fileSetManifest
+	err = json.Unmarshal(buf, &rv)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file set manifest: %w", err)
+	}
+	if rv.Version != fileSetManifestVersion {
+		return nil, fmt.Errorf("unsupported file set manifest version %d",
+			rv.Version)
+	}
+	for _, files := range []map[string]string{rv.Files, rv.Previous} {
+		for _, file := range files {
+			if !isFileSetFileName(file) || strings.HasSuffix(file, ".tmp") {
+				return nil, fmt.Errorf("invalid file name %q in file set manifest",
+					file)
+			}
+		}
+	}
+	return &rv, nil
+}
+
+// removeFileSetFiles removes the files of any generation which aren't
+// among the live ones
+func removeFileSetFiles(dir string, live ...map[string]string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, files := range live {
+		for _, file := range files {
+			keep[file] = true
+		}
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if (isFileSetFileName(name) && !keep[name]) ||
+			name == fileSetManifestName+".tmp" {
+			err = os.Remove(filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func insertKVs(kvs ...KV) func(*Builder) error {
+	return func(b *Builder) error {
+		for _, kv := range kvs {
+			err := b.Insert([]byte(kv.Key), kv.Val)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func fileSetGet(t *testing.T, s *FileSet, name, key string) uint64 {
+	fst, err := s.Open(name)
+	if err != nil {
+		t.Fatalf("error opening %s: %v", name, err)
+	}
+	defer func() {
+		_ = fst.Close()
+	}()
+	val, exists, err := fst.Get([]byte(key))
+	if err != nil || !exists {
+		t.Fatalf("expected %s in %s, got %t %v", key, name, exists, err)
+	}
+	return val
+}
+
+func TestFileSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	s, err := OpenFileSet(dir)
+	if err != nil {
+		t.Fatalf("error opening empty file set: %v", err)
+	}
+	if s.Generation() != 0 || len(s.Names()) != 0 {
+		t.Errorf("expected empty file set, got %d %v", s.Generation(), s.Names())
+	}
+
+	tx, err := BeginFileSet(dir)
+	if err != nil {
+		t.Fatalf("error beginning: %v", err)
+	}
+	err = tx.Build("main", insertKVs(KV{"bar", 1}, KV{"foo", 2}))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	err = tx.Build("reversed", insertKVs(KV{"oof", 2}, KV{"rab", 1}))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	err = tx.Write("bloom", func(w io.Writer) error {
+		_, err := w.Write([]byte("sidecar"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	// nothing is visible before committing
+	s, err = OpenFileSet(dir)
+	if err != nil || s.Generation() != 0 {
+		t.Fatalf("expected nothing committed, got %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+	err = tx.Commit()
+	if err != ErrTxDone {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+
+	s, err = OpenFileSet(dir)
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	if s.Generation() != 1 ||
+		!reflect.DeepEqual(s.Names(), []string{"bloom", "main", "reversed"}) {
+		t.Errorf("expected generation 1 with 3 files, got %d %v", s.Generation(),
+			s.Names())
+	}
+	if fileSetGet(t, s, "main", "foo") != 2 || fileSetGet(t, s, "reversed", "rab") != 1 {
+		t.Errorf("unexpected values in generation 1")
+	}
+	_, err = s.Open("missing")
+	if err != ErrUnknownFile {
+		t.Errorf("expected ErrUnknownFile, got %v", err)
+	}
+
+	// replace only main, twice, the generation 1 readers keep their files
+	// until the second commit
+	old := s
+	for gen := uint64(2); gen <= 3; gen++ {
+		tx, err = BeginFileSet(dir)
+		if err != nil {
+			t.Fatalf("error beginning: %v", err)
+		}
+		err = tx.Build("main", insertKVs(KV{"foo", gen}))
+		if err != nil {
+			t.Fatalf("error building: %v", err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("error committing: %v", err)
+		}
+		s, err = OpenFileSet(dir)
+		if err != nil {
+			t.Fatalf("error opening: %v", err)
+		}
+		if fileSetGet(t, s, "main", "foo") != gen ||
+			fileSetGet(t, s, "reversed", "oof") != 2 {
+			t.Errorf("unexpected values in generation %d", gen)
+		}
+		path, _ := old.Path("main")
+		_, err = os.Stat(path)
+		if gen == 2 && err != nil {
+			t.Errorf("expected previous generation to be kept: %v", err)
+		} else if gen == 3 && !os.IsNotExist(err) {
+			t.Errorf("expected older generation to be removed, got %v", err)
+		}
+	}
+
+	// files of transactions never committed are discarded
+	tx, err = BeginFileSet(dir)
+	if err != nil {
+		t.Fatalf("error beginning: %v", err)
+	}
+	err = tx.Build("main", insertKVs(KV{"baz", 9}))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	err = tx.Abort()
+	if err != nil {
+		t.Fatalf("error aborting: %v", err)
+	}
+	tx, err = BeginFileSet(dir)
+	if err != nil {
+		t.Fatalf("error beginning: %v", err)
+	}
+	err = tx.Build("main", insertKVs(KV{"baz", 9}))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	_, err = BeginFileSet(dir)
+	if err != nil {
+		t.Fatalf("error beginning: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "main.00000004"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected uncommitted file to be removed, got %v", err)
+	}
+
+	// a transaction committing after another fails
+	tx1, err := BeginFileSet(dir)
+	if err != nil {
+		t.Fatalf("error beginning: %v", err)
+	}
+	tx2, err := BeginFileSet(dir)
+	if err != nil {
+		t.Fatalf("error beginning: %v", err)
+	}
+	err = tx1.Build("main", insertKVs(KV{"foo", 4}))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	err = tx1.Commit()
+	if err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+	err = tx2.Build("main", insertKVs(KV{"foo", 5}))
+	if err != ErrTxConflict {
+		t.Errorf("expected ErrTxConflict, got %v", err)
+	}
+	err = tx2.Write("bloom", func(w io.Writer) error { return nil })
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	err = tx2.Commit()
+	if err != ErrTxConflict {
+		t.Errorf("expected ErrTxConflict, got %v", err)
+	}
+
+	err = tx.Build("../escape", insertKVs())
+	if err == nil {
+		t.Errorf("expected invalid name to be rejected")
+	}
+}
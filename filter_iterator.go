@@ -0,0 +1,118 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// FilterIterator returns the keys of another Iterator matching an
+// automaton.  When the automaton can't match any key starting with a
+// prefix of the current key, the Iterator seeks past all of them, so whole
+// ranges of keys are skipped without being returned.  Iterators of FSTs are
+// better filtered by their Search, which doesn't visit the keys at all,
+// FilterIterator filters any Iterator, such as a MergeIterator.
+//
+// The wrapped Iterator must not be used directly while the FilterIterator
+// is in use, Close closes it.
+type FilterIterator struct {
+	itr Iterator
+	aut Automaton
+}
+
+// NewFilterIterator returns an Iterator over the keys of itr matching the
+// automaton, starting from its current key.  As with NewMergeIterator,
+// ErrIteratorDone is returned if there are no such keys.
+func NewFilterIterator(itr Iterator, aut Automaton) (*FilterIterator, error) {
+	rv := &FilterIterator{
+		itr: itr,
+		aut: aut,
+	}
+	return rv, rv.settle(nil)
+}
+
+// settle moves the wrapped Iterator to the first key matching the
+// automaton, from its current key, unless err is set
+func (i *FilterIterator) settle(err error) error {
+	for err == nil {
+		key, _ := i.itr.Current()
+		if key == nil {
+			return ErrIteratorDone
+		}
+		dead := i.deadPrefix(key)
+		if dead < 0 {
+			return nil
+		}
+		if dead > len(key) {
+			err = i.itr.Next()
+			continue
+		}
+		next := PrefixSuccessor(key[:dead])
+		if next == nil {
+			return ErrIteratorDone
+		}
+		err = i.itr.Seek(next)
+	}
+	return err
+}
+
+// deadPrefix returns -1 if the automaton matches the key, the length of its
+// shortest prefix the automaton can't match any continuation of, or more
+// than the length of the key if there is none
+func (i *FilterIterator) deadPrefix(key []byte) int {
+	s := i.aut.Start()
+	if !i.aut.CanMatch(s) {
+		return 0
+	}
+	for j, b := range key {
+		if i.aut.WillAlwaysMatch(s) {
+			return -1
+		}
+		s = i.aut.Accept(s, b)
+		if !i.aut.CanMatch(s) {
+			return j + 1
+		}
+	}
+	if i.aut.IsMatch(s) {
+		return -1
+	}
+	return len(key) + 1
+}
+
+// Current returns the key and value currently pointed to by the iterator.
+func (i *FilterIterator) Current() ([]byte, uint64) {
+	return i.itr.Current()
+}
+
+// Next advances the iterator to the next matching key.  If there is none,
+// ErrIteratorDone is returned.
+func (i *FilterIterator) Next() error {
+	return i.settle(i.itr.Next())
+}
+
+// Seek advances the iterator to the first matching key greater than or
+// equal to the provided key.  If there is none, ErrIteratorDone is
+// returned.
+func (i *FilterIterator) Seek(key []byte) error {
+	return i.settle(i.itr.Seek(key))
+}
+
+// Reset resets the wrapped Iterator, and advances it to its first key
+// matching the automaton of the FilterIterator.
+func (i *FilterIterator) Reset(f *FST, startKeyInclusive,
+	endKeyExclusive []byte, aut Automaton) error {
+	return i.settle(i.itr.Reset(f, startKeyInclusive, endKeyExclusive, aut))
+}
+
+// Close closes the wrapped Iterator.
+func (i *FilterIterator) Close() error {
+	return i.itr.Close()
+}
@@ -0,0 +1,113 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum/regexp"
+)
+
+// countingIterator counts the keys a FilterIterator steps over
+type countingIterator struct {
+	*testIterator
+	nexts int
+}
+
+func (c *countingIterator) Next() error {
+	c.nexts++
+	return c.testIterator.Next()
+}
+
+func TestFilterIterator(t *testing.T) {
+	a, _ := newTestIterator(map[string]uint64{
+		"aa": 1, "ab": 2, "ac": 3, "bat": 4, "bee": 5, "cat": 6,
+	})
+	b, _ := newTestIterator(map[string]uint64{
+		"ad": 7, "bee": 8, "bet": 9, "bit": 10, "dot": 11,
+	})
+	counted := &countingIterator{testIterator: a}
+	merged, err := NewMergeIterator([]Iterator{counted, b}, MergeSum)
+	if err != nil {
+		t.Fatalf("error merging: %v", err)
+	}
+	r, err := regexp.New(`b.t`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr, err := NewFilterIterator(merged, r)
+	var got []KV
+	for err == nil {
+		k, v := itr.Current()
+		got = append(got, KV{string(k), v})
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Fatalf("error iterating: %v", err)
+	}
+	want := []KV{{"bat", 4}, {"bet", 9}, {"bit", 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	// the keys starting with a and c, and bee, were skipped by seeking
+	if counted.nexts != 1 {
+		t.Errorf("expected 1 key stepped over, got %d", counted.nexts)
+	}
+
+	err = itr.Seek([]byte("bet"))
+	if err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	if k, v := itr.Current(); string(k) != "bet" || v != 9 {
+		t.Errorf("expected bet 9, got %q %d", k, v)
+	}
+	err = itr.Reset(nil, nil, nil, nil)
+	if err == nil {
+		t.Errorf("expected merge iterator reset to fail")
+	}
+
+	c, _ := newTestIterator(map[string]uint64{"aa": 1, "cc": 2})
+	_, err = NewFilterIterator(c, r)
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+}
+
+func TestMergeSearch(t *testing.T) {
+	a := buildKVs(t, KV{"bat", 1}, KV{"bee", 2}, KV{"cat", 3})
+	b := buildKVs(t, KV{"bat", 4}, KV{"bit", 5})
+	empty := buildKVs(t)
+	r, err := regexp.New(`b.t`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr, err := NewMergeSearch([]*FST{a, empty, b}, r, nil, []byte("bit"),
+		MergeMax)
+	var got []KV
+	for err == nil {
+		k, v := itr.Current()
+		got = append(got, KV{string(k), v})
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Fatalf("error iterating: %v", err)
+	}
+	want := []KV{{"bat", 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,173 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FlowStats describes the data written by a Builder to its Writer, allowing
+// callers to notice a slow Writer and throttle the build.
+type FlowStats struct {
+	// Buffered is the number of bytes encoded, but not yet written to the
+	// Writer.
+	Buffered int `json:"buffered"`
+	// Written is the number of bytes written to the Writer.
+	Written int64 `json:"written"`
+	// Writes is the number of calls to the Writer's Write method.
+	Writes int `json:"writes"`
+	// WriteTime is the total time spent in the Writer's Write method.
+	WriteTime time.Duration `json:"write_time"`
+	// LastWriteTime is the time spent in the most recent Write.
+	LastWriteTime time.Duration `json:"last_write_time"`
+}
+
+// FlowStats returns statistics about the data written to the Writer since
+// the Builder was created or last Reset.
+func (b *Builder) FlowStats() FlowStats {
+	rv := b.out.stats
+	rv.Buffered = b.encoder.buffered()
+	return rv
+}
+
+// Flush writes the encoded data buffered by the Builder to the Writer,
+// allowing a build to be paced by a slow Writer.  If the context is done
+// before the data is written, Flush returns its error.  A Write in
+// progress is only interrupted if the Writer has a SetWriteDeadline
+// method, as network connections and pipes do, otherwise the context is
+// checked before each Write.
+//
+// Once a Write fails, or is interrupted, the Builder is aborted: the
+// output is incomplete, and Insert, Flush and Close return the same error
+// until the Builder is Reset.
+func (b *Builder) Flush(ctx context.Context) error {
+	err := b.out.begin(ctx)
+	if err != nil {
+		return err
+	}
+	return b.out.end(b.encoder.flush())
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// flowWriter is the Writer the encoder of a Builder writes to, counting
+// the data written, and allowing Flush to interrupt the writes
+type flowWriter struct {
+	w     io.Writer
+	stats FlowStats
+	// err is set once a write failed, aborting the build
+	err error
+
+	// ctx is set for the duration of a Flush
+	ctx context.Context
+	// done stops the goroutine interrupting writes when ctx is done
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (w *flowWriter) reset(newWriter io.Writer) {
+	*w = flowWriter{w: newWriter}
+}
+
+func (w *flowWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.ctx != nil {
+		if err := w.ctx.Err(); err != nil {
+			w.err = err
+			return 0, err
+		}
+	}
+	start := time.Now()
+	n, err := w.w.Write(p)
+	w.stats.LastWriteTime = time.Since(start)
+	w.stats.WriteTime += w.stats.LastWriteTime
+	w.stats.Writes++
+	w.stats.Written += int64(n)
+	if err != nil {
+		if w.ctx != nil {
+			ctxErr := w.ctx.Err()
+			var timeout interface{ Timeout() bool }
+			if ctxErr == nil && w.done != nil && errors.As(err, &timeout) &&
+				timeout.Timeout() {
+				// the Writer noticed the deadline before the context
+				ctxErr = context.DeadlineExceeded
+			}
+			if ctxErr != nil {
+				err = fmt.Errorf("%w: %v", ctxErr, err)
+			}
+		}
+		w.err = err
+	}
+	return n, err
+}
+
+// begin prepares the writes of a Flush with the context
+func (w *flowWriter) begin(ctx context.Context) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.ctx = ctx
+	dw, ok := w.w.(writeDeadliner)
+	if !ok || ctx.Done() == nil {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := dw.SetWriteDeadline(deadline); err != nil &&
+			!errors.Is(err, os.ErrNoDeadline) {
+			w.ctx = nil
+			return err
+		}
+	}
+	w.done = make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		select {
+		case <-ctx.Done():
+			// a deadline in the past interrupts the Write in progress
+			_ = dw.SetWriteDeadline(time.Unix(1, 0))
+		case <-w.done:
+		}
+	}()
+	return nil
+}
+
+// end finishes the writes of a Flush, returning the error of the flush
+func (w *flowWriter) end(err error) error {
+	if w.done != nil {
+		close(w.done)
+		w.wg.Wait()
+		w.done = nil
+		_ = w.w.(writeDeadliner).SetWriteDeadline(time.Time{})
+	}
+	w.ctx = nil
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
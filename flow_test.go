@@ -0,0 +1,143 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBuilderFlush(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	words := thousandTestWords[:100]
+	err = insertStrings(b, words, randomValues(words))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	stats := b.FlowStats()
+	if stats.Buffered == 0 || stats.Written != int64(buf.Len()) {
+		t.Errorf("expected buffered data, got %+v with %d written", stats,
+			buf.Len())
+	}
+	err = b.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	flushed := b.FlowStats()
+	if flushed.Buffered != 0 || flushed.Written != int64(buf.Len()) ||
+		flushed.Written != stats.Written+int64(stats.Buffered) ||
+		flushed.Writes != stats.Writes+1 {
+		t.Errorf("expected everything written, got %+v after %+v", flushed, stats)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = b.Flush(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	// nothing was written, so the build goes on
+	words = thousandTestWords[100:200]
+	err = insertStrings(b, words, randomValues(words))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil || fst.Len() != 200 {
+		t.Errorf("expected 200 keys, got %v", err)
+	}
+}
+
+func TestBuilderFlushInterrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(),
+					20*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "cancel",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// nothing reads the pipe, so writes block
+			w, r := net.Pipe()
+			defer func() {
+				_ = w.Close()
+				_ = r.Close()
+			}()
+			b, err := New(w)
+			if err != nil {
+				t.Fatalf("error creating builder: %v", err)
+			}
+			err = b.Insert([]byte("a"), 1)
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+			ctx, cancel := test.ctx()
+			defer cancel()
+			err = b.Flush(ctx)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+			// the build is aborted
+			if b.Insert([]byte("b"), 2) != err || b.Close() != err {
+				t.Errorf("expected the build to be aborted with %v", err)
+			}
+
+			var buf bytes.Buffer
+			err = b.Reset(&buf)
+			if err != nil {
+				t.Fatalf("error resetting: %v", err)
+			}
+			err = b.Insert([]byte("b"), 2)
+			if err != nil {
+				t.Fatalf("error inserting after reset: %v", err)
+			}
+			err = b.Close()
+			if err != nil {
+				t.Fatalf("error closing after reset: %v", err)
+			}
+			if b.FlowStats().Written != int64(buf.Len()) {
+				t.Errorf("expected stats to be reset, got %+v", b.FlowStats())
+			}
+		})
+	}
+}
@@ -15,43 +15,249 @@
 package vellum
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 
 	"github.com/willf/bitset"
 )
 
+// ErrClosed is returned by the operations of an FST started after it was
+// closed.
+var ErrClosed = errors.New("fst closed")
+
+// closedFlag is set in FST.inflight once Close has started, the lower bits
+// count the operations in progress
+const closedFlag = 1 << 62
+
 // FST is an in-memory representation of a finite state transducer,
 // capable of returning the uint64 value associated with
 // each []byte key stored, as well as enumerating all of the keys
 // in order.
+//
+// An FST is immutable once loaded, and its methods are safe for concurrent
+// use by multiple goroutines, including with a single mmap'd file, without
+// any locking of the caller.  The values holding the state of a query, the
+// Iterators and Readers, are not: each goroutine must use its own.
 type FST struct {
-	f       io.Closer
-	ver     int
-	len     int
-	typ     int
-	data    []byte
-	decoder decoder
+	// inflight is accessed atomically, so it comes first, to be 64-bit
+	// aligned on 32-bit platforms, see closedFlag
+	inflight int64
+
+	f          io.Closer
+	ver        int
+	len        int
+	typ        int
+	data       []byte
+	decoder    decoder
+	cache      *nodeCache
+	counts     *subtreeCounts
+	depths     *depthHints
+	bounds     *valueBounds
+	sums       *stateTable
+	values     *valueTable
+	digests    *keyDigests
+	payloads   *payloadTable
+	valueLists *payloadTable
+
+	getCache *getCache
+	pool     *workerPool
+	prefetch int
+
+	mutationCheck bool
+	checksum      uint32
+	endBoundError bool
+
+	hashMu sync.Mutex
+	hash   uint64
+	hashed bool
+
+	closeMu sync.Mutex
+	// drained is signaled when the last operation in progress ends after
+	// Close has started
+	drained chan struct{}
 }
 
-func new(data []byte, f io.Closer) (rv *FST, err error) {
+func new(data []byte, f io.Closer, opts *openOpts) (rv *FST, err error) {
 	rv = &FST{
-		data: data,
-		f:    f,
+		data:    data,
+		f:       f,
+		drained: make(chan struct{}, 1),
+	}
+
+	if opts.partial != nil {
+		err = opts.partial.check(0, headerSize)
+		if err != nil {
+			return nil, err
+		}
+		if isCompressed(data) {
+			return nil, fmt.Errorf("partial data not supported with compression")
+		}
+		if opts.mutationCheck {
+			return nil, fmt.Errorf("partial data can't be checked for mutations")
+		}
 	}
 
-	rv.ver, rv.typ, err = decodeHeader(data)
+	if isCompressed(data) {
+		rv.ver, rv.typ, err = decodeHeader(data[len(compressedMagic):])
+		if err != nil {
+			return nil, err
+		}
+		rv.decoder, err = loadCompressedDecoder(data, rv.ver, rv.typ,
+			opts.blockCacheBudget)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rv.ver, rv.typ, err = decodeHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		rv.decoder, err = loadDecoder(rv.ver, rv.data)
+		if err != nil {
+			return nil, err
+		}
+		if opts.partial != nil {
+			rv.decoder = &partialDecoder{
+				decoder:   rv.decoder,
+				data:      rv.data,
+				available: opts.partial,
+			}
+		}
+	}
+
+	err = rv.load(opts)
 	if err != nil {
 		return nil, err
 	}
+	return rv, nil
+}
 
-	rv.decoder, err = loadDecoder(rv.ver, rv.data)
+// load validates the FST, once its decoder is set, and loads its sections
+func (f *FST) load(opts *openOpts) error {
+	var err error
+	if f.typ&typeChecksums != 0 && !opts.skipChecksums && opts.partial == nil {
+		// before decoding anything, so corruption is reported as such
+		err = f.verifyChecksums()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = f.decoder.validate()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	rv.len = rv.decoder.getLen()
+	if f.typ&typeChecksums == 0 &&
+		f.decoder.section(sectionChecksums) != nil {
+		return corruptf(8, "checksum section, but no checksums in type %d",
+			f.typ)
+	}
 
-	return rv, nil
+	f.len = f.decoder.getLen()
+
+	if section := f.decoder.section(sectionSubtreeCounts); section != nil {
+		f.counts, err = loadSubtreeCounts(section)
+		if err != nil {
+			return err
+		}
+	}
+	if section := f.decoder.section(sectionDepthHints); section != nil {
+		f.depths, err = loadDepthHints(section)
+		if err != nil {
+			return err
+		}
+	}
+	mins := f.decoder.section(sectionValueMins)
+	maxes := f.decoder.section(sectionValueMaxes)
+	if mins != nil || maxes != nil {
+		f.bounds, err = loadValueBounds(mins, maxes)
+		if err != nil {
+			return err
+		}
+	}
+	if section := f.decoder.section(sectionValueSums); section != nil {
+		if f.counts == nil {
+			return corruptf(0, "value sums without subtree counts")
+		}
+		f.sums, err = loadStateTable(section, "value sums")
+		if err != nil {
+			return err
+		}
+	}
+	if f.typ&typeInternedValues != 0 {
+		section := f.decoder.section(sectionValues)
+		if section == nil {
+			return corruptf(0, "missing value table section")
+		}
+		f.values, err = loadValueTable(section)
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.typ&typePayloads != 0 {
+		section := f.decoder.section(sectionPayloads)
+		if section == nil {
+			return corruptf(0, "missing payload section")
+		}
+		f.payloads, err = loadPayloadTable(section)
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.typ&typeValueLists != 0 {
+		section := f.decoder.section(sectionValueLists)
+		if section == nil {
+			return corruptf(0, "missing value list section")
+		}
+		f.valueLists, err = loadPayloadTable(section)
+		if err != nil {
+			return err
+		}
+	}
+
+	if section := f.decoder.section(sectionKeyDigests); section != nil {
+		if f.counts == nil {
+			return corruptf(0, "key digests without subtree counts")
+		}
+		f.digests, err = loadKeyDigests(section, f.len)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.workers > 0 {
+		f.pool = newWorkerPool(opts.workers)
+	}
+
+	if opts.mutationCheck {
+		f.mutationCheck = true
+		f.checksum = dataChecksum(f.data)
+	}
+
+	if opts.prefetch > 1 {
+		f.prefetch = opts.prefetch
+	}
+
+	f.endBoundError = opts.endBoundError
+
+	if opts.getCacheBudget > 0 {
+		f.getCache = newGetCache(opts.getCacheBudget)
+	}
+
+	if opts.nodeCacheBudget > 0 {
+		f.cache, err = newNodeCache(f.decoder, opts.nodeCacheBudget)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Contains returns true if this FST contains the specified key.
@@ -68,8 +274,41 @@ func (f *FST) Get(input []byte) (uint64, bool, error) {
 }
 
 func (f *FST) get(input []byte, prealloc fstState) (uint64, bool, error) {
+	if err := f.enter(); err != nil {
+		return 0, false, err
+	}
+	defer f.exit()
+	if f.getCache != nil {
+		if val, exists, ok := f.getCache.lookup(input); ok {
+			return val, exists, nil
+		}
+		val, exists, err := f.traverse(input, prealloc)
+		if err == nil {
+			f.getCache.add(input, val, exists)
+		}
+		return val, exists, err
+	}
+	return f.traverse(input, prealloc)
+}
+
+// traverse looks up the input by following the transitions from the root
+func (f *FST) traverse(input []byte, prealloc fstState) (uint64, bool, error) {
 	var total uint64
 	curr := f.decoder.getRoot()
+	if f.cache != nil {
+		var done, exists bool
+		var val uint64
+		var consumed int
+		done, val, exists, consumed, curr, total = f.cache.get(input)
+		if done {
+			if exists {
+				val, err := f.value(val)
+				return val, err == nil, err
+			}
+			return val, exists, nil
+		}
+		input = input[consumed:]
+	}
 	state, err := f.decoder.stateAt(curr, prealloc)
 	if err != nil {
 		return 0, false, err
@@ -89,7 +328,10 @@ func (f *FST) get(input []byte, prealloc fstState) (uint64, bool, error) {
 	}
 
 	if state.Final() {
-		total += state.FinalOutput()
+		total, err = f.value(total + state.FinalOutput())
+		if err != nil {
+			return 0, false, err
+		}
 		return total, true, nil
 	}
 	return 0, false, nil
@@ -112,8 +354,31 @@ func (f *FST) Type() int {
 
 // Close will unmap any mmap'd data (if managed by vellum) and it will close
 // the backing file (if managed by vellum).  You MUST call Close() for any
-// FST instance that is created.
+// FST instance that is created.  If the FST was opened with
+// WithMutationCheck and the data has been modified, Close panics.
+//
+// Close may be called concurrently with lookups and iterations, it waits
+// for those in progress, and those started later, including the next
+// steps of existing Iterators, return ErrClosed.  Once it is closed, the
+// Automaton methods of the FST match nothing, and the methods without an
+// error return zero values.  Closing a closed or nil FST does nothing.
 func (f *FST) Close() error {
+	if f == nil {
+		return nil
+	}
+	f.closeMu.Lock()
+	defer f.closeMu.Unlock()
+	if atomic.LoadInt64(&f.inflight)&closedFlag != 0 {
+		return nil
+	}
+	if err := f.CheckUnmodified(); err != nil {
+		panic(err)
+	}
+	atomic.AddInt64(&f.inflight, closedFlag)
+	for atomic.LoadInt64(&f.inflight) != closedFlag {
+		<-f.drained
+	}
+	f.pool.close()
 	if f.f != nil {
 		err := f.f.Close()
 		if err != nil {
@@ -122,11 +387,50 @@ func (f *FST) Close() error {
 	}
 	f.data = nil
 	f.decoder = nil
+	f.cache = nil
+	f.mutationCheck = false
+	return nil
+}
+
+// errEndBound returns the error of the iterators moving past their bounds,
+// see WithEndBoundError
+func (f *FST) errEndBound() error {
+	if f.endBoundError {
+		return ErrIteratorEndBound
+	}
+	return ErrIteratorDone
+}
+
+// enter records an operation in progress, which must call exit once done,
+// unless ErrClosed is returned
+func (f *FST) enter() error {
+	if f == nil {
+		return ErrClosed
+	}
+	if atomic.AddInt64(&f.inflight, 1)&closedFlag != 0 {
+		f.exit()
+		return ErrClosed
+	}
 	return nil
 }
 
+// exit records the end of an operation, waking Close if it was the last
+func (f *FST) exit() {
+	if atomic.AddInt64(&f.inflight, -1) == closedFlag {
+		select {
+		case f.drained <- struct{}{}:
+		default:
+			// Close is already woken, and checks again
+		}
+	}
+}
+
 // Start returns the start state of this Automaton
 func (f *FST) Start() int {
+	if f.enter() != nil {
+		return noneAddr
+	}
+	defer f.exit()
 	return f.decoder.getRoot()
 }
 
@@ -160,6 +464,10 @@ func (f *FST) Accept(addr int, b byte) int {
 // IsMatchWithVal returns if this state is a matching state in this Automaton
 // and also returns the final output value for this state
 func (f *FST) IsMatchWithVal(addr int) (bool, uint64) {
+	if f.enter() != nil {
+		return false, 0
+	}
+	defer f.exit()
 	s, err := f.decoder.stateAt(addr, nil)
 	if err != nil {
 		return false, 0
@@ -170,6 +478,10 @@ func (f *FST) IsMatchWithVal(addr int) (bool, uint64) {
 // AcceptWithVal returns the next state for this Automaton on input of byte b
 // and also returns the output value for the transition
 func (f *FST) AcceptWithVal(addr int, b byte) (int, uint64) {
+	if f.enter() != nil {
+		return noneAddr, 0
+	}
+	defer f.exit()
 	s, err := f.decoder.stateAt(addr, nil)
 	if err != nil {
 		return noneAddr, 0
@@ -178,6 +490,42 @@ func (f *FST) AcceptWithVal(addr int, b byte) (int, uint64) {
 	return next, output
 }
 
+// Arc describes an outgoing transition of a state in this FST.
+type Arc struct {
+	// Label is the input byte consumed by this transition
+	Label byte
+	// Dest is the address of the state this transition leads to
+	Dest int
+	// Output is the value accumulated by following this transition
+	Output uint64
+}
+
+// Arcs appends the outgoing transitions of the state at the provided
+// address to rv, in ascending Label order, and returns the extended slice.
+// Addresses are obtained from Start() or the Dest of another Arc, whether
+// the state is final (and its final output) can be checked with
+// IsMatchWithVal().
+func (f *FST) Arcs(addr int, rv []Arc) ([]Arc, error) {
+	if err := f.enter(); err != nil {
+		return rv, err
+	}
+	defer f.exit()
+	s, err := f.decoder.stateAt(addr, nil)
+	if err != nil {
+		return rv, err
+	}
+	for i := 0; i < s.NumTransitions(); i++ {
+		label := s.TransitionAt(i)
+		_, dest, output := s.TransitionFor(label)
+		rv = append(rv, Arc{
+			Label:  label,
+			Dest:   dest,
+			Output: output,
+		})
+	}
+	return rv, nil
+}
+
 // Iterator returns a new Iterator capable of enumerating the key/value pairs
 // between the provided startKeyInclusive and endKeyExclusive.
 func (f *FST) Iterator(startKeyInclusive, endKeyExclusive []byte) (*FSTIterator, error) {
@@ -194,6 +542,10 @@ func (f *FST) Search(aut Automaton, startKeyInclusive, endKeyExclusive []byte) (
 // Debug is only intended for debug purposes, it simply asks the underlying
 // decoder visit each state, and pass it to the provided callback.
 func (f *FST) Debug(callback func(int, interface{}) error) error {
+	if err := f.enter(); err != nil {
+		return err
+	}
+	defer f.exit()
 
 	addr := f.decoder.getRoot()
 	set := bitset.New(uint(addr))
@@ -237,58 +589,82 @@ func (a addrStack) Pop() (addrStack, int) {
 	return a[:l-1], a[l-1]
 }
 
-// Reader() returns a Reader instance that a single thread may use to
-// retrieve data from the FST
-func (f *FST) Reader() (*Reader, error) {
-	return &Reader{f: f}, nil
-}
-
-func (f *FST) getMinMaxKey(comparator func(byte, byte) bool) ([]byte, error) {
+// minMaxKey follows the smallest transitions of each state, to the first
+// final state, or the largest ones, to the state without any.
+func (f *FST) minMaxKey(max bool) ([]byte, bool, error) {
+	if err := f.enter(); err != nil {
+		return nil, false, err
+	}
+	defer f.exit()
+	if f.len == 0 {
+		return nil, false, nil
+	}
 	var rv []byte
 
 	curr := f.decoder.getRoot()
 	state, err := f.decoder.stateAt(curr, nil)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	for !state.Final() {
-		lastTransition := state.TransitionAt(0)
+	for max || !state.Final() {
 		numTransitions := state.NumTransitions()
+		if numTransitions == 0 {
+			if state.Final() {
+				break
+			}
+			return nil, false, corruptf(curr, "non-final state without transitions")
+		}
+		transition := state.TransitionAt(0)
 		for i := 1; i < numTransitions; i++ {
-			transition := state.TransitionAt(i)
-			if comparator(transition, lastTransition) {
-				lastTransition = transition
+			t := state.TransitionAt(i)
+			if (t < transition) != max {
+				transition = t
 			}
 		}
 
-		_, curr, _ = state.TransitionFor(lastTransition)
+		_, curr, _ = state.TransitionFor(transition)
 		state, err = f.decoder.stateAt(curr, state)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
-		rv = append(rv, lastTransition)
+		rv = append(rv, transition)
+		if len(rv) > f.addrSpace() {
+			// each state is at least a byte, so this is a cycle
+			return nil, false, corruptf(curr, "cycle following transitions")
+		}
 	}
 
-	return rv, nil
+	return rv, true, nil
 }
 
-func (f *FST) GetMinKey() ([]byte, error) {
-	return f.getMinMaxKey(func (x byte, y byte) bool {return x < y})
+// MinKey returns the smallest key of the FST, without iterating.  If the
+// FST is empty, it returns false.
+func (f *FST) MinKey() ([]byte, bool, error) {
+	return f.minMaxKey(false)
 }
 
-
-func (f *FST) GetMaxKey() ([]byte, error) {
-	return f.getMinMaxKey(func (x byte, y byte) bool {return x > y})
+// MaxKey returns the largest key of the FST, without iterating.  If the
+// FST is empty, it returns false.
+func (f *FST) MaxKey() ([]byte, bool, error) {
+	return f.minMaxKey(true)
 }
 
-// A Reader is meant for a single threaded use
-type Reader struct {
-	f        *FST
-	prealloc fstStateV1
+// GetMinKey returns the smallest key of the FST, or nil if it is empty.
+//
+// Deprecated: use MinKey, which distinguishes an empty FST from the empty
+// key.
+func (f *FST) GetMinKey() ([]byte, error) {
+	rv, _, err := f.minMaxKey(false)
+	return rv, err
 }
 
-func (r *Reader) Get(input []byte) (uint64, bool, error) {
-	return r.f.get(input, &r.prealloc)
+// GetMaxKey returns the largest key of the FST, or nil if it is empty.
+//
+// Deprecated: use MaxKey, which distinguishes an empty FST from the empty
+// key.
+func (f *FST) GetMaxKey() ([]byte, error) {
+	rv, _, err := f.minMaxKey(true)
+	return rv, err
 }
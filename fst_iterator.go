@@ -50,6 +50,9 @@ type Iterator interface {
 type FSTIterator struct {
 	f   *FST
 	aut Automaton
+	// depthAut is set when both the automaton and the FST support depth
+	// hints
+	depthAut DepthHintAutomaton
 
 	startKeyInclusive []byte
 	endKeyExclusive   []byte
@@ -61,13 +64,31 @@ type FSTIterator struct {
 	autStatesStack []int
 
 	nextStart []byte
+
+	// valRange, if set, restricts the values of the keys, see
+	// FST.SearchValues
+	valRange *valueRange
+
+	// ring, if set, holds the entries decoded ahead, the first being the
+	// current one, see WithIteratorPrefetch
+	ring *iteratorRing
 }
 
 func newIterator(f *FST, startKeyInclusive, endKeyExclusive []byte,
 	aut Automaton) (*FSTIterator, error) {
 
+	startKeyInclusive, endKeyExclusive = automatonBounds(aut,
+		startKeyInclusive, endKeyExclusive)
+
+	// no iterator is set up for searches which can't match, as with any
+	// other search without matches, only the error is returned
+	err := emptySearch(f, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		return nil, err
+	}
+
 	rv := &FSTIterator{}
-	err := rv.Reset(f, startKeyInclusive, endKeyExclusive, aut)
+	err = rv.Reset(f, startKeyInclusive, endKeyExclusive, aut)
 	if err != nil {
 		return nil, err
 	}
@@ -78,20 +99,73 @@ func newIterator(f *FST, startKeyInclusive, endKeyExclusive []byte,
 // reuse (e.g. pooling).
 func (i *FSTIterator) Reset(f *FST,
 	startKeyInclusive, endKeyExclusive []byte, aut Automaton) error {
+	return i.reset(f, startKeyInclusive, endKeyExclusive, aut, nil)
+}
+
+func (i *FSTIterator) reset(f *FST, startKeyInclusive,
+	endKeyExclusive []byte, aut Automaton, valRange *valueRange) error {
+	if err := f.enter(); err != nil {
+		return err
+	}
+	defer f.exit()
 	if aut == nil {
 		aut = alwaysMatchAutomaton
 	}
+	startKeyInclusive, endKeyExclusive = automatonBounds(aut,
+		startKeyInclusive, endKeyExclusive)
 
 	i.f = f
 	i.startKeyInclusive = startKeyInclusive
 	i.endKeyExclusive = endKeyExclusive
 	i.aut = aut
+	i.depthAut = nil
+	if f.depths != nil {
+		i.depthAut, _ = aut.(DepthHintAutomaton)
+	}
+	i.valRange = valRange
+	if f.prefetch <= 0 {
+		i.ring = nil
+	} else if i.ring == nil || len(i.ring.keys) != f.prefetch {
+		i.ring = newIteratorRing(f.prefetch)
+	}
+	i.clear()
 
 	return i.pointTo(startKeyInclusive)
 }
 
-// pointTo attempts to point us to the specified location
+// emptySearch returns the error a search ends with if it trivially matches
+// no keys, because the FST is empty, the start key isn't before the end
+// key, or the automaton can't match anything, or nil otherwise.  These
+// cases are common enough, with many small FSTs or generated bounds, to be
+// worth detecting without walking the FST.
+func emptySearch(f *FST, startKeyInclusive, endKeyExclusive []byte,
+	aut Automaton) error {
+	if endKeyExclusive != nil &&
+		bytes.Compare(startKeyInclusive, endKeyExclusive) >= 0 {
+		return f.errEndBound()
+	}
+	if f.len == 0 || (aut != nil && !aut.CanMatch(aut.Start())) {
+		return ErrIteratorDone
+	}
+	return nil
+}
+
+// pointTo attempts to point us to the specified location, and decodes the
+// entries after it when prefetching
 func (i *FSTIterator) pointTo(key []byte) error {
+	err := i.seek(key)
+	if i.ring == nil {
+		return err
+	}
+	i.ring.reset()
+	if err != nil {
+		return err
+	}
+	return i.fill()
+}
+
+// seek positions the path at the specified location
+func (i *FSTIterator) seek(key []byte) error {
 	// tried to seek before start
 	if bytes.Compare(key, i.startKeyInclusive) < 0 {
 		key = i.startKeyInclusive
@@ -103,25 +177,37 @@ func (i *FSTIterator) pointTo(key []byte) error {
 		key = i.endKeyExclusive
 	}
 
-	// reset any state, pointTo always starts over
-	i.statesStack = i.statesStack[:0]
-	i.keysStack = i.keysStack[:0]
-	i.keysPosStack = i.keysPosStack[:0]
-	i.valsStack = i.valsStack[:0]
-	i.autStatesStack = i.autStatesStack[:0]
+	// the path to the current key is kept as far as it is shared with the
+	// key, so that seeking a nearby key only walks the rest of its path
+	shared := 0
+	if len(i.statesStack) > 0 {
+		for shared < len(key) && shared < len(i.keysStack) &&
+			key[shared] == i.keysStack[shared] {
+			shared++
+		}
+	}
+	i.truncate(shared)
 
-	root, err := i.f.decoder.stateAt(i.f.decoder.getRoot(), nil)
+	// the stacks are left empty, Current and Next handle that
+	err := emptySearch(i.f, key, i.endKeyExclusive, i.aut)
 	if err != nil {
+		i.clear()
 		return err
 	}
 
-	autStart := i.aut.Start()
+	if len(i.statesStack) == 0 {
+		root, err := i.f.decoder.stateAt(i.f.decoder.getRoot(),
+			i.preallocState())
+		if err != nil {
+			return err
+		}
+		// root is always part of the path
+		i.statesStack = append(i.statesStack, root)
+		i.autStatesStack = append(i.autStatesStack, i.aut.Start())
+	}
 
 	maxQ := -1
-	// root is always part of the path
-	i.statesStack = append(i.statesStack, root)
-	i.autStatesStack = append(i.autStatesStack, autStart)
-	for j := 0; j < len(key); j++ {
+	for j := shared; j < len(key); j++ {
 		keyJ := key[j]
 		curr := i.statesStack[len(i.statesStack)-1]
 		autCurr := i.autStatesStack[len(i.autStatesStack)-1]
@@ -130,17 +216,12 @@ func (i *FSTIterator) pointTo(key []byte) error {
 		if nextAddr == noneAddr {
 			// needed transition doesn't exist
 			// find last trans before the one we needed
-			for q := curr.NumTransitions() - 1; q >= 0; q-- {
-				if curr.TransitionAt(q) < keyJ {
-					maxQ = q
-					break
-				}
-			}
+			maxQ = curr.TransitionBefore(keyJ)
 			break
 		}
 		autNext := i.aut.Accept(autCurr, keyJ)
 
-		next, err := i.f.decoder.stateAt(nextAddr, nil)
+		next, err := i.f.decoder.stateAt(nextAddr, i.preallocState())
 		if err != nil {
 			return err
 		}
@@ -155,6 +236,7 @@ func (i *FSTIterator) pointTo(key []byte) error {
 
 	if !i.statesStack[len(i.statesStack)-1].Final() ||
 		!i.aut.IsMatch(i.autStatesStack[len(i.autStatesStack)-1]) ||
+		!i.valueMatch() ||
 		bytes.Compare(i.keysStack, key) < 0 {
 		return i.next(maxQ)
 	}
@@ -162,30 +244,142 @@ func (i *FSTIterator) pointTo(key []byte) error {
 	return nil
 }
 
+// preallocState returns the fstState instance in the next slot of the
+// statesStack, left by a longer path, which can be reused, if any
+func (i *FSTIterator) preallocState() fstState {
+	if len(i.statesStack) < cap(i.statesStack) {
+		return i.statesStack[0:cap(i.statesStack)][len(i.statesStack)]
+	}
+	return nil
+}
+
+// truncate truncates the path to the first n keys, and the states they
+// lead to
+func (i *FSTIterator) truncate(n int) {
+	if len(i.statesStack) == 0 {
+		return
+	}
+	i.statesStack = i.statesStack[:n+1]
+	i.autStatesStack = i.autStatesStack[:n+1]
+	i.keysStack = i.keysStack[:n]
+	i.keysPosStack = i.keysPosStack[:n]
+	i.valsStack = i.valsStack[:n]
+}
+
+// clear empties the path
+func (i *FSTIterator) clear() {
+	i.statesStack = i.statesStack[:0]
+	i.keysStack = i.keysStack[:0]
+	i.keysPosStack = i.keysPosStack[:0]
+	i.valsStack = i.valsStack[:0]
+	i.autStatesStack = i.autStatesStack[:0]
+}
+
 // Current returns the key and value currently pointed to by the iterator.
 // If the iterator is not pointing at a valid value (because Iterator/Next/Seek)
 // returned an error previously, it may return nil,0.
 func (i *FSTIterator) Current() ([]byte, uint64) {
+	if i.f.enter() != nil {
+		return nil, 0
+	}
+	defer i.f.exit()
+	key, val, _ := i.current()
+	return key, val
+}
+
+// CurrentInto returns the key and value currently pointed to by the
+// iterator, as Current does, but with the key copied into buf, which is
+// grown if needed, so that it remains valid after the iterator moves.
+// Passing the key returned back in as buf copies the keys without
+// allocating, once the buffer is large enough: after the first pass, a
+// scan of an FST with Reset, Next, Seek and CurrentInto doesn't allocate.
+func (i *FSTIterator) CurrentInto(buf []byte) ([]byte, uint64) {
+	if i.f.enter() != nil {
+		return buf[:0], 0
+	}
+	defer i.f.exit()
+	key, val, ok := i.current()
+	if !ok {
+		return buf[:0], val
+	}
+	return append(buf[:0], key...), val
+}
+
+// current returns the current key and value, and whether there is one
+func (i *FSTIterator) current() ([]byte, uint64, bool) {
+	if i.ring != nil && i.ring.n > 0 {
+		key, val := i.ring.head()
+		return key, val, true
+	}
+	if len(i.statesStack) == 0 {
+		return nil, 0, false
+	}
 	curr := i.statesStack[len(i.statesStack)-1]
 	if curr.Final() {
-		var total uint64
-		for _, v := range i.valsStack {
-			total += v
-		}
-		total += curr.FinalOutput()
-		return i.keysStack, total
+		return i.keysStack, i.value(curr), true
 	}
-	return nil, 0
+	return nil, 0, false
+}
+
+// value returns the value of the final state at the top of the stack
+func (i *FSTIterator) value(curr fstState) uint64 {
+	total := i.output() + curr.FinalOutput()
+	if i.f.values != nil {
+		// an index out of range has no value
+		total, _ = i.f.values.get(total)
+	}
+	return total
+}
+
+// output returns the outputs accumulated along the stack
+func (i *FSTIterator) output() uint64 {
+	var total uint64
+	for _, v := range i.valsStack {
+		total += v
+	}
+	return total
+}
+
+// valueMatch returns true if the value of the final state at the top of the
+// stack is in the value range, if any
+func (i *FSTIterator) valueMatch() bool {
+	if i.valRange == nil {
+		return true
+	}
+	return i.valRange.contains(i.value(i.statesStack[len(i.statesStack)-1]))
+}
+
+// valuesOutside returns true if the values of all the keys reachable from
+// the state at addr, with the outputs accumulated up to it, are outside the
+// value range.  Interned values aren't ordered as their indexes are, so
+// can't be bounded.
+func (i *FSTIterator) valuesOutside(addr int, out uint64) bool {
+	if i.valRange == nil || i.f.bounds == nil || i.f.values != nil {
+		return false
+	}
+	min, max, ok := i.f.bounds.get(addr)
+	return ok && (out+max < i.valRange.min || out+min > i.valRange.max)
 }
 
 // Next advances this iterator to the next key/value pair.  If there is none
 // or the advancement goes beyond the configured endKeyExclusive, then
-// ErrIteratorDone is returned.
+// ErrIteratorDone is returned (see WithEndBoundError).
 func (i *FSTIterator) Next() error {
+	if err := i.f.enter(); err != nil {
+		return err
+	}
+	defer i.f.exit()
+	if i.ring != nil {
+		return i.nextPrefetched()
+	}
 	return i.next(-1)
 }
 
 func (i *FSTIterator) next(lastOffset int) error {
+	if len(i.statesStack) == 0 {
+		return ErrIteratorDone
+	}
+
 	// remember where we started
 	i.nextStart = append(i.nextStart[:0], i.keysStack...)
 
@@ -196,7 +390,7 @@ OUTER:
 		curr := i.statesStack[len(i.statesStack)-1]
 		autCurr := i.autStatesStack[len(i.autStatesStack)-1]
 
-		if curr.Final() && i.aut.IsMatch(autCurr) &&
+		if curr.Final() && i.aut.IsMatch(autCurr) && i.valueMatch() &&
 			bytes.Compare(i.keysStack, i.nextStart) > 0 {
 			// in final state greater than start key
 			return nil
@@ -215,15 +409,21 @@ OUTER:
 
 			pos, nextAddr, v := curr.TransitionFor(t)
 
-			// the next slot in the statesStack might have an
-			// fstState instance that we can reuse
-			var nextPrealloc fstState
-			if len(i.statesStack) < cap(i.statesStack) {
-				nextPrealloc = i.statesStack[0:cap(i.statesStack)][len(i.statesStack)]
+			if i.depthAut != nil {
+				depth, ok := i.f.depths.get(nextAddr)
+				if ok && !i.depthAut.CanMatchWithin(autNext, depth) {
+					nextOffset += 1
+					continue INNER
+				}
+			}
+
+			if i.valuesOutside(nextAddr, i.output()+v) {
+				nextOffset += 1
+				continue INNER
 			}
 
 			// push onto stack
-			next, err := i.f.decoder.stateAt(nextAddr, nextPrealloc)
+			next, err := i.f.decoder.stateAt(nextAddr, i.preallocState())
 			if err != nil {
 				return err
 			}
@@ -237,7 +437,7 @@ OUTER:
 			// check to see if new keystack might have gone too far
 			if i.endKeyExclusive != nil &&
 				bytes.Compare(i.keysStack, i.endKeyExclusive) >= 0 {
-				return ErrIteratorDone
+				return i.f.errEndBound()
 			}
 
 			nextOffset = 0
@@ -266,8 +466,18 @@ OUTER:
 // Seek advances this iterator to the specified key/value pair.  If this key
 // is not in the FST, Current() will return the next largest key.  If this
 // seek operation would go past the last key, or outside the configured
-// startKeyInclusive/endKeyExclusive then ErrIteratorDone is returned.
+// startKeyInclusive/endKeyExclusive then ErrIteratorDone is returned (see
+// WithEndBoundError).
+//
+// The key may be before or after the current one.  The states on the path
+// to the current key which are shared with the path to the key are kept,
+// so seeks to nearby keys, as in merge joins intersecting the keys of
+// several iterators, only decode the states after the shared prefix.
 func (i *FSTIterator) Seek(key []byte) error {
+	if err := i.f.enter(); err != nil {
+		return err
+	}
+	defer i.f.exit()
 	return i.pointTo(key)
 }
 
@@ -16,6 +16,8 @@ package vellum
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -662,3 +664,249 @@ func TestRegexpSearch(t *testing.T) {
 		t.Errorf("with start key t, end key u, expected %v, got: %v", want, got)
 	}
 }
+
+func TestIteratorErrorTaxonomy(t *testing.T) {
+	fst, err := Load(buildSmallSample(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// exhausting the fst is done, but not past the end bound
+	itr, err := fst.Iterator([]byte("tues"), nil)
+	for err == nil {
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+
+	// passing the end bound is done, unless opted into telling it apart
+	itr, err = fst.Iterator(nil, []byte("tues"))
+	for err == nil {
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+	fst, err = Load(buildSmallSample(t), WithEndBoundError())
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr, err = fst.Iterator(nil, []byte("tues"))
+	for err == nil {
+		err = itr.Next()
+	}
+	if err != ErrIteratorEndBound {
+		t.Errorf("expected ErrIteratorEndBound, got %v", err)
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Errorf("expected ErrIteratorEndBound to match ErrIteratorDone")
+	}
+
+	// decode failures are neither
+	data := buildSmallSample(t)
+	root := int(binary.LittleEndian.Uint64(data[len(data)-8:]))
+	data[root] = 0 // multiple transitions, but number of them truncated
+	fst, err = Load(data[:])
+	if err == nil {
+		_, err = fst.Iterator(nil, nil)
+	}
+	if !errors.Is(err, ErrCorrupt) || errors.Is(err, ErrIteratorDone) {
+		t.Errorf("expected only ErrCorrupt, got %v", err)
+	}
+}
+
+// neverMatch is an automaton which can't match anything
+type neverMatch struct{}
+
+func (neverMatch) Start() int               { return 0 }
+func (neverMatch) IsMatch(int) bool         { return false }
+func (neverMatch) CanMatch(int) bool        { return false }
+func (neverMatch) WillAlwaysMatch(int) bool { return false }
+func (neverMatch) Accept(int, byte) int     { return 0 }
+
+func TestIteratorEmptySearch(t *testing.T) {
+	fst, err := Load(buildSmallSample(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc       string
+		fst        *FST
+		aut        Automaton
+		start, end string
+		want       error
+	}{
+		{"empty fst", empty, nil, "", "", ErrIteratorDone},
+		{"start after end", fst, nil, "tues", "mon", ErrIteratorDone},
+		// tues is a key, but excluded by the end bound
+		{"start at end", fst, nil, "tues", "tues", ErrIteratorDone},
+		{"never matches", fst, neverMatch{}, "", "", ErrIteratorDone},
+	}
+	for _, test := range tests {
+		var start, end []byte
+		if test.start != "" {
+			start = []byte(test.start)
+		}
+		if test.end != "" {
+			end = []byte(test.end)
+		}
+		itr, err := test.fst.Search(test.aut, start, end)
+		if err != test.want || itr != nil {
+			t.Errorf("%s: expected %v, got %v", test.desc, test.want, err)
+		}
+		allocs := testing.AllocsPerRun(10, func() {
+			_, _ = test.fst.Search(test.aut, start, end)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: expected no allocations, got %f", test.desc, allocs)
+		}
+	}
+
+	// reusing an iterator for an empty search leaves it done
+	itr, err := fst.Iterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = itr.Reset(fst, []byte("tues"), []byte("mon"), nil)
+	if err != ErrIteratorDone {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+	if key, val := itr.Current(); key != nil || val != 0 {
+		t.Errorf("expected no current key, got %s %d", key, val)
+	}
+	err = itr.Next()
+	if err != ErrIteratorDone {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+}
+
+func TestIteratorSeekKeepsPath(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, randomValues(thousandTestWords))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	r, err := regexp.New(`[a-m].*e.*`)
+	if err != nil {
+		t.Fatalf("error compiling regexp: %v", err)
+	}
+
+	// seeks forwards and backwards, to keys sharing more or less of the
+	// current path, land where a fresh iterator does
+	seeks := []string{"abc", "abd", "ab", "absolute", "b", "aa", "zzz",
+		"mechanic", "mechanical", "me", "", "program", "progress", "m"}
+	for _, aut := range []Automaton{nil, r} {
+		itr, err := fst.Search(aut, []byte("a"), []byte("p"))
+		if err != nil {
+			t.Fatalf("error searching: %v", err)
+		}
+		for _, seek := range seeks {
+			err = itr.Seek([]byte(seek))
+			key, val := itr.Current()
+
+			fresh, ferr := fst.Search(aut, []byte("a"), []byte("p"))
+			if ferr == nil {
+				ferr = fresh.Seek([]byte(seek))
+			}
+			var wantKey []byte
+			var wantVal uint64
+			if ferr == nil {
+				wantKey, wantVal = fresh.Current()
+			}
+			if err != ferr || !bytes.Equal(key, wantKey) || val != wantVal {
+				t.Errorf("seek %q: expected %q %d %v, got %q %d %v", seek,
+					wantKey, wantVal, ferr, key, val, err)
+			}
+			if err == nil {
+				// the iteration carries on from the key
+				err = itr.Next()
+				if ferr = fresh.Next(); err != ferr {
+					t.Errorf("seek %q: expected next %v, got %v", seek, ferr,
+						err)
+				}
+			}
+		}
+	}
+}
+
+func TestIteratorAllocs(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithVersion(2))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords,
+		make([]uint64, len(thousandTestWords)))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	r, err := regexp.New(`[a-m].*s`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]OpenOption{nil, {WithIteratorPrefetch(16)}} {
+		fst, err := Load(buf.Bytes(), opts...)
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		for _, aut := range []Automaton{nil, r} {
+			itr, err := fst.Search(aut, nil, nil)
+			if err != nil {
+				t.Fatalf("error searching: %v", err)
+			}
+			var key []byte
+			var keys int
+			scan := func() {
+				keys = 0
+				err := itr.Reset(fst, nil, nil, aut)
+				for err == nil {
+					key, _ = itr.CurrentInto(key)
+					keys++
+					err = itr.Next()
+				}
+				for _, word := range thousandTestWords[:100] {
+					_ = itr.Seek([]byte(word))
+					key, _ = itr.CurrentInto(key)
+				}
+			}
+			scan()
+			if n := testing.AllocsPerRun(5, scan); n != 0 {
+				t.Errorf("expected no allocations, got %f", n)
+			}
+			if keys == 0 || (aut == nil && keys != len(thousandTestWords)) {
+				t.Errorf("unexpected number of keys %d", keys)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build gofuzz
+
+package vellum
+
+// Fuzz is the go-fuzz entry point for the decoder.  It loads arbitrary
+// data as an FST and exercises it, any panic is a bug.
+//
+//	go-fuzz-build github.com/couchbase/vellum
+//	go-fuzz -bin=vellum-fuzz.zip -workdir=fuzz
+func Fuzz(data []byte) int {
+	fst, err := Load(data)
+	if err != nil {
+		return 0
+	}
+	_, err = fst.Stats()
+	if err == nil {
+		_, _, err = fst.MinKey()
+	}
+	if err == nil {
+		_, _, err = fst.MaxKey()
+	}
+	if err != nil {
+		return 0
+	}
+	itr, err := fst.Iterator(nil, nil)
+	for n := 0; err == nil && n < 10000; n++ {
+		key, _ := itr.Current()
+		_, _, err = fst.Get(key)
+		if err == nil {
+			err = itr.Next()
+		}
+	}
+	if err != ErrIteratorDone {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,116 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "fmt"
+
+// DistanceAutomaton is an Automaton which knows the edit distance of the
+// keys reaching its matching states, such as the automata of the
+// levenshtein and levenshtein2 packages.
+type DistanceAutomaton interface {
+	Automaton
+
+	// MatchDistance returns the edit distance of the keys reaching the
+	// matching state s.
+	MatchDistance(s int) uint8
+}
+
+// FuzzyIterator iterates over the keys of an FST matching a
+// DistanceAutomaton, along with their edit distance, to rank them.
+type FuzzyIterator struct {
+	itr  *FSTIterator
+	aut  DistanceAutomaton
+	dist uint8
+}
+
+// FuzzySearch returns a new FuzzyIterator over the keys between the
+// provided startKeyInclusive and endKeyExclusive matching the automaton.
+func (f *FST) FuzzySearch(aut DistanceAutomaton, startKeyInclusive,
+	endKeyExclusive []byte) (*FuzzyIterator, error) {
+	itr, err := f.Search(aut, startKeyInclusive, endKeyExclusive)
+	if err != nil {
+		return nil, err
+	}
+	rv := &FuzzyIterator{itr: itr, aut: aut}
+	rv.measure()
+	return rv, nil
+}
+
+// measure computes the distance of the current key, by running the
+// automaton over it again, which is cheap compared to finding it
+func (i *FuzzyIterator) measure() {
+	key, _ := i.itr.Current()
+	s := i.aut.Start()
+	for _, b := range key {
+		s = i.aut.Accept(s, b)
+	}
+	i.dist = i.aut.MatchDistance(s)
+}
+
+// Current returns the key and value currently pointed to by the iterator.
+func (i *FuzzyIterator) Current() ([]byte, uint64) {
+	return i.itr.Current()
+}
+
+// Distance returns the edit distance of the current key.
+func (i *FuzzyIterator) Distance() uint8 {
+	return i.dist
+}
+
+// Next advances the iterator to the next matching key.  If there is none,
+// ErrIteratorDone is returned.
+func (i *FuzzyIterator) Next() error {
+	err := i.itr.Next()
+	if err != nil {
+		return err
+	}
+	i.measure()
+	return nil
+}
+
+// Seek advances the iterator to the first matching key greater than or
+// equal to the provided key.  If there is none, ErrIteratorDone is
+// returned.
+func (i *FuzzyIterator) Seek(key []byte) error {
+	err := i.itr.Seek(key)
+	if err != nil {
+		return err
+	}
+	i.measure()
+	return nil
+}
+
+// Reset resets the iterator to search the FST with the automaton, which
+// must be a DistanceAutomaton.
+func (i *FuzzyIterator) Reset(f *FST, startKeyInclusive,
+	endKeyExclusive []byte, aut Automaton) error {
+	daut, ok := aut.(DistanceAutomaton)
+	if !ok {
+		return fmt.Errorf("fuzzy iterator reset with %T, not a DistanceAutomaton",
+			aut)
+	}
+	err := i.itr.Reset(f, startKeyInclusive, endKeyExclusive, daut)
+	if err != nil {
+		return err
+	}
+	i.aut = daut
+	i.measure()
+	return nil
+}
+
+// Close closes the iterator.
+func (i *FuzzyIterator) Close() error {
+	return i.itr.Close()
+}
@@ -0,0 +1,122 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"testing"
+
+	"github.com/couchbase/vellum/levenshtein"
+	"github.com/couchbase/vellum/levenshtein2"
+)
+
+// editDistance is the Levenshtein distance between the runes of a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			next := prev + cost
+			if row[j]+1 < next {
+				next = row[j] + 1
+			}
+			if row[j-1]+1 < next {
+				next = row[j-1] + 1
+			}
+			prev, row[j] = row[j], next
+		}
+	}
+	return row[len(rb)]
+}
+
+func TestFuzzyIterator(t *testing.T) {
+	fst, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	lb, err := levenshtein2.NewLevenshteinAutomatonBuilder(2, false)
+	if err != nil {
+		t.Fatalf("error creating levenshtein builder: %v", err)
+	}
+	for _, query := range []string{"there", "wather", "bück", "goverment"} {
+		lev, err := levenshtein.New(query, 2)
+		if err != nil {
+			t.Fatalf("%s: error building levenshtein automaton: %v", query, err)
+		}
+		dfa, err := lb.BuildDfa(query, 2)
+		if err != nil {
+			t.Fatalf("%s: error building dfa: %v", query, err)
+		}
+		want := map[string]uint8{}
+		for _, word := range thousandTestWords {
+			if d := editDistance(query, word); d <= 2 {
+				want[word] = uint8(d)
+			}
+		}
+		for _, aut := range []DistanceAutomaton{lev, dfa} {
+			got := map[string]uint8{}
+			itr, err := fst.FuzzySearch(aut, nil, nil)
+			for err == nil {
+				key, _ := itr.Current()
+				got[string(key)] = itr.Distance()
+				err = itr.Next()
+			}
+			if err != ErrIteratorDone {
+				t.Fatalf("%s: error iterating: %v", query, err)
+			}
+			if len(got) != len(want) {
+				t.Errorf("%s %T: expected %v, got %v", query, aut, want, got)
+			}
+			for word, d := range want {
+				if got[word] != d {
+					t.Errorf("%s %T: expected %s at distance %d, got %d", query,
+						aut, word, d, got[word])
+				}
+			}
+		}
+	}
+
+	lev, err := levenshtein.New("there", 1)
+	if err != nil {
+		t.Fatalf("error building levenshtein automaton: %v", err)
+	}
+	if d := lev.MatchDistance(0); d != 2 {
+		t.Errorf("expected the dead state beyond the distance, got %d", d)
+	}
+	itr, err := fst.FuzzySearch(lev, nil, nil)
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	err = itr.Seek([]byte("there"))
+	if err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	if key, _ := itr.Current(); string(key) != "there" || itr.Distance() != 0 {
+		t.Errorf("expected there at distance 0, got %s at %d", key,
+			itr.Distance())
+	}
+	err = itr.Reset(fst, nil, nil, alwaysMatchAutomaton)
+	if err == nil {
+		t.Errorf("expected error resetting without distances")
+	}
+}
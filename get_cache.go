@@ -0,0 +1,127 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"container/list"
+	"sync"
+)
+
+// approximate memory used by a cached result, besides its key
+const getCacheEntrySize = 128
+
+// GetCacheStats reports on the effectiveness of the cache enabled with
+// WithGetCache.
+type GetCacheStats struct {
+	// Hits is the number of lookups answered by the cache for keys which
+	// exist.
+	Hits uint64
+	// NegativeHits is the number of lookups answered by the cache for keys
+	// which don't exist.
+	NegativeHits uint64
+	// Misses is the number of lookups which traversed the FST.
+	Misses uint64
+	// Evictions is the number of results evicted to stay within the
+	// budget.
+	Evictions uint64
+	// Entries is the number of results currently cached.
+	Entries int
+	// Size is the approximate memory used by the cached results.
+	Size int
+}
+
+// GetCacheStats returns the statistics of the cache enabled with
+// WithGetCache, all zero if it isn't.
+func (f *FST) GetCacheStats() GetCacheStats {
+	if f.getCache == nil {
+		return GetCacheStats{}
+	}
+	return f.getCache.stats()
+}
+
+// getCache is an LRU cache of the results of Get
+type getCache struct {
+	m       sync.Mutex
+	budget  int
+	entries map[string]*list.Element
+	lru     list.List // front is the most recently used
+
+	s GetCacheStats
+}
+
+type getCacheEntry struct {
+	key    string
+	val    uint64
+	exists bool
+}
+
+func newGetCache(budget int) *getCache {
+	return &getCache{
+		budget:  budget,
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// lookup returns the cached result for the key, if there is one
+func (c *getCache) lookup(key []byte) (val uint64, exists bool, ok bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	elem, ok := c.entries[string(key)]
+	if !ok {
+		c.s.Misses++
+		return 0, false, false
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*getCacheEntry)
+	if entry.exists {
+		c.s.Hits++
+	} else {
+		c.s.NegativeHits++
+	}
+	return entry.val, entry.exists, true
+}
+
+// add caches the result for the key, evicting the least recently used
+// results as needed to stay within the budget
+func (c *getCache) add(key []byte, val uint64, exists bool) {
+	size := getCacheEntrySize + len(key)
+	if size > c.budget {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, ok := c.entries[string(key)]; ok {
+		// added concurrently
+		return
+	}
+	for c.s.Size+size > c.budget {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*getCacheEntry)
+		delete(c.entries, entry.key)
+		c.s.Size -= getCacheEntrySize + len(entry.key)
+		c.s.Entries--
+		c.s.Evictions++
+	}
+	entry := &getCacheEntry{key: string(key), val: val, exists: exists}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.s.Size += size
+	c.s.Entries++
+}
+
+func (c *getCache) stats() GetCacheStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.s
+}
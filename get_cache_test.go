@@ -0,0 +1,82 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGetCache(t *testing.T) {
+	data := buildWordsSample(t)
+	// room for a few entries only
+	fst, err := Load(data, WithGetCache(4*(getCacheEntrySize+8)))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	plain, err := Load(data)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	keys := append([]string{"missing", "zzz", ""}, thousandTestWords[:20]...)
+	for round := 0; round < 3; round++ {
+		for _, key := range keys {
+			// ask for the first keys repeatedly
+			for _, k := range []string{keys[0], keys[3], key} {
+				want, wantExists, _ := plain.Get([]byte(k))
+				got, exists, err := fst.Get([]byte(k))
+				if err != nil || got != want || exists != wantExists {
+					t.Fatalf("expected %s %d %t, got %d %t %v", k, want,
+						wantExists, got, exists, err)
+				}
+			}
+		}
+	}
+	stats := fst.GetCacheStats()
+	if stats.Hits == 0 || stats.NegativeHits == 0 || stats.Misses == 0 ||
+		stats.Evictions == 0 {
+		t.Errorf("expected hits, negative hits, misses and evictions, got %+v",
+			stats)
+	}
+	if stats.Entries > 4 || stats.Size > 4*(getCacheEntrySize+8) {
+		t.Errorf("expected cache within budget, got %+v", stats)
+	}
+	if total := stats.Hits + stats.NegativeHits + stats.Misses; total !=
+		uint64(3*3*len(keys)) {
+		t.Errorf("expected %d lookups, got %d", 3*3*len(keys), total)
+	}
+	if plain.GetCacheStats() != (GetCacheStats{}) {
+		t.Errorf("expected no stats without cache, got %+v",
+			plain.GetCacheStats())
+	}
+
+	// concurrent lookups share the cache
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, word := range thousandTestWords[:100] {
+				_, exists, err := fst.Get([]byte(word))
+				if err != nil || !exists {
+					t.Errorf("expected %s, got %t %v", word, exists, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,175 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// KeyHistogram counts the keys of an FST by their prefixes, to help plan
+// capacity and choose partition points.  The JSON encoding is stable, see
+// StatsSchemaVersion.
+type KeyHistogram struct {
+	// SchemaVersion is the StatsSchemaVersion which produced this value
+	SchemaVersion int `json:"schema_version"`
+	// Depth is the length of the prefixes counted
+	Depth int `json:"depth"`
+	// Keys is the number of keys in the FST
+	Keys int `json:"keys"`
+	// FirstByte counts the keys by their first byte, the empty key is not
+	// counted
+	FirstByte [256]int `json:"first_byte"`
+	// Prefixes counts the keys by their first Depth bytes, in order of the
+	// prefixes, keys shorter than Depth are counted as their own prefix
+	Prefixes []PrefixCount `json:"prefixes"`
+}
+
+// PrefixCount is the number of keys starting with a prefix.
+type PrefixCount struct {
+	Prefix []byte `json:"prefix"`
+	Count  int    `json:"count"`
+}
+
+// KeyHistogram counts the keys of the FST by their first byte and by their
+// first depth bytes.  The number of keys reachable from each state is only
+// computed once, or read from the subtree counts if the FST has them (see
+// BuilderOpts.SubtreeCounts), so this doesn't visit every key.
+func (f *FST) KeyHistogram(depth int) (*KeyHistogram, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	rv := &KeyHistogram{
+		SchemaVersion: StatsSchemaVersion,
+		Depth:         depth,
+	}
+	h := &keyHistogrammer{
+		f:      f,
+		depth:  depth,
+		counts: make(map[int]int),
+		rv:     rv,
+	}
+	root, err := f.decoder.stateAt(f.decoder.getRoot(), nil)
+	if err != nil {
+		return nil, err
+	}
+	rv.Keys, err = h.count(root)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < root.NumTransitions(); i++ {
+		t := root.TransitionAt(i)
+		_, dest, _ := root.TransitionFor(t)
+		next, err := f.decoder.stateAt(dest, nil)
+		if err != nil {
+			return nil, err
+		}
+		rv.FirstByte[t], err = h.count(next)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = h.visit(root, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Partitions returns up to n-1 prefixes splitting the keys into n ranges
+// of about the same number of keys, each prefix being the start of a
+// range.  The ranges are only as balanced as the histogram's depth allows.
+func (h *KeyHistogram) Partitions(n int) [][]byte {
+	var rv [][]byte
+	var sum int
+	for i := 0; i+1 < len(h.Prefixes) && len(rv) < n-1; i++ {
+		sum += h.Prefixes[i].Count
+		if sum*n >= (len(rv)+1)*h.Keys {
+			rv = append(rv, h.Prefixes[i+1].Prefix)
+		}
+	}
+	return rv
+}
+
+type keyHistogrammer struct {
+	f      *FST
+	depth  int
+	counts map[int]int
+	rv     *KeyHistogram
+}
+
+// count returns the number of keys reachable from the state
+func (h *keyHistogrammer) count(state fstState) (int, error) {
+	addr := state.Address()
+	if h.f.counts != nil {
+		if c, ok := h.f.counts.get(addr); ok {
+			return int(c), nil
+		}
+	}
+	if c, ok := h.counts[addr]; ok {
+		return c, nil
+	}
+	var rv int
+	if state.Final() {
+		rv++
+	}
+	for i := 0; i < state.NumTransitions(); i++ {
+		_, dest, _ := state.TransitionFor(state.TransitionAt(i))
+		next, err := h.f.decoder.stateAt(dest, nil)
+		if err != nil {
+			return 0, err
+		}
+		c, err := h.count(next)
+		if err != nil {
+			return 0, err
+		}
+		rv += c
+	}
+	h.counts[addr] = rv
+	return rv, nil
+}
+
+// visit records the prefixes of the keys reachable from the state
+func (h *keyHistogrammer) visit(state fstState, prefix []byte) error {
+	if len(prefix) >= h.depth {
+		c, err := h.count(state)
+		if err != nil {
+			return err
+		}
+		h.add(prefix, c)
+		return nil
+	}
+	if state.Final() {
+		h.add(prefix, 1)
+	}
+	for i := 0; i < state.NumTransitions(); i++ {
+		t := state.TransitionAt(i)
+		_, dest, _ := state.TransitionFor(t)
+		next, err := h.f.decoder.stateAt(dest, nil)
+		if err != nil {
+			return err
+		}
+		err = h.visit(next, append(prefix, t))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *keyHistogrammer) add(prefix []byte, count int) {
+	if count > 0 {
+		h.rv.Prefixes = append(h.rv.Prefixes, PrefixCount{
+			Prefix: append([]byte{}, prefix...),
+			Count:  count,
+		})
+	}
+}
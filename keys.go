@@ -0,0 +1,82 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// The functions in this file compute the bounds of the iterators for the
+// common ranges of keys.  None of them modify their argument, the keys
+// returned are always new slices.
+
+// PrefixSuccessor returns the smallest key greater than all the keys
+// starting with the prefix, the exclusive end bound of an iteration over
+// them.  Trailing 0xff bytes are dropped before incrementing the last
+// byte, as there is no greater byte.  It returns nil, which means no end
+// bound, if the prefix is empty or only made of 0xff bytes.
+func PrefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			rv := append([]byte(nil), prefix[:i+1]...)
+			rv[i]++
+			return rv
+		}
+	}
+	return nil
+}
+
+// KeySuccessor returns the smallest key greater than the key, which is the
+// key followed by a 0 byte.  It turns an inclusive end bound into the
+// exclusive one the iterators take, or an exclusive start bound, such as
+// the last key returned, into an inclusive one.
+func KeySuccessor(key []byte) []byte {
+	rv := make([]byte, len(key)+1)
+	copy(rv, key)
+	return rv
+}
+
+// KeyPredecessor returns the greatest key less than the key, and whether
+// there is one.  There is only one if the key ends with a 0 byte, otherwise
+// infinitely many keys come just before it, as "aa" < "aa\xff" <
+// "aa\xff\xff" < ... < "ab".  With a bound on the length of the keys,
+// PrefixPredecessor always finds one.
+func KeyPredecessor(key []byte) ([]byte, bool) {
+	if len(key) == 0 || key[len(key)-1] != 0 {
+		return nil, false
+	}
+	return append([]byte(nil), key[:len(key)-1]...), true
+}
+
+// PrefixPredecessor returns the greatest key of at most maxLen bytes less
+// than the key, and whether there is one, there is none for the empty key.
+// When the FST keys are no longer than maxLen, it turns an exclusive end
+// bound into an inclusive one.
+func PrefixPredecessor(key []byte, maxLen int) ([]byte, bool) {
+	if len(key) == 0 {
+		return nil, false
+	}
+	if len(key) > maxLen {
+		// the longest allowed prefix of the key
+		return append([]byte(nil), key[:maxLen]...), true
+	}
+	if key[len(key)-1] == 0 {
+		return append([]byte(nil), key[:len(key)-1]...), true
+	}
+	// decrement the last byte, and extend with 0xff up to maxLen bytes
+	rv := make([]byte, maxLen)
+	copy(rv, key)
+	rv[len(key)-1]--
+	for i := len(key); i < maxLen; i++ {
+		rv[i] = 0xff
+	}
+	return rv, true
+}
@@ -0,0 +1,124 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+func TestPrefixSuccessor(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []byte
+	}{
+		{"", nil},
+		{"a", []byte("b")},
+		{"ab", []byte("ac")},
+		{"a\xff", []byte("b")},
+		{"a\xff\xff", []byte("b")},
+		{"\xff", nil},
+		{"\xff\xff", nil},
+		{"\xfe\xff", []byte("\xff")},
+		{"a\x00", []byte("a\x01")},
+	}
+	for _, test := range tests {
+		prefix := []byte(test.prefix)
+		got := PrefixSuccessor(prefix)
+		if !bytes.Equal(got, test.want) || (got == nil) != (test.want == nil) {
+			t.Errorf("%q: expected %q, got %q", test.prefix, test.want, got)
+		}
+		if string(prefix) != test.prefix {
+			t.Errorf("%q: prefix modified", test.prefix)
+		}
+	}
+}
+
+// allKeys returns all the keys of up to maxLen bytes over the alphabet,
+// sorted
+func allKeys(alphabet []byte, maxLen int) [][]byte {
+	rv := [][]byte{{}}
+	last := rv
+	for n := 1; n <= maxLen; n++ {
+		var next [][]byte
+		for _, key := range last {
+			for _, b := range alphabet {
+				next = append(next, append(append([]byte(nil), key...), b))
+			}
+		}
+		rv = append(rv, next...)
+		last = next
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return bytes.Compare(rv[i], rv[j]) < 0
+	})
+	return rv
+}
+
+func TestKeySuccessorPredecessor(t *testing.T) {
+	// over all the keys of up to 4 bytes, the neighbours of the keys of up
+	// to 3 bytes are known
+	const maxLen = 3
+	keys := allKeys([]byte{0, 1, 0x7f, 0xff}, maxLen+1)
+	for i, key := range keys {
+		if len(key) > maxLen {
+			continue
+		}
+		if succ := KeySuccessor(key); i+1 >= len(keys) ||
+			!bytes.Equal(succ, keys[i+1]) {
+			t.Errorf("%q: unexpected successor %q", key, succ)
+		}
+
+		pred, ok := KeyPredecessor(key)
+		if i == 0 {
+			if ok {
+				t.Errorf("%q: unexpected predecessor %q", key, pred)
+			}
+		} else if ok != (len(keys[i-1]) < len(key)) ||
+			(ok && !bytes.Equal(pred, keys[i-1])) {
+			// only a proper prefix can be the predecessor of all keys,
+			// the others could be extended past maxLen
+			t.Errorf("%q: unexpected predecessor %q %t, before %q", key,
+				pred, ok, keys[i-1])
+		}
+
+		if succ := PrefixSuccessor(key); succ != nil {
+			j := sort.Search(len(keys), func(j int) bool {
+				return bytes.Compare(keys[j], succ) >= 0
+			})
+			if !bytes.HasPrefix(keys[j-1], key) || bytes.HasPrefix(succ, key) {
+				t.Errorf("%q: unexpected prefix successor %q", key, succ)
+			}
+		}
+	}
+
+	// among all the keys of up to 2 bytes, the predecessor is the previous
+	alphabet := make([]byte, 256)
+	for i := range alphabet {
+		alphabet[i] = byte(i)
+	}
+	keys = allKeys(alphabet, 2)
+	for i, key := range keys {
+		pred, ok := PrefixPredecessor(key, 2)
+		if ok != (i > 0) || (ok && !bytes.Equal(pred, keys[i-1])) {
+			t.Errorf("%q: unexpected prefix predecessor %q %t", key, pred, ok)
+		}
+	}
+	pred, ok := PrefixPredecessor([]byte("abcd"), 2)
+	if !ok || string(pred) != "ab" {
+		t.Errorf("expected the truncated key, got %q %t", pred, ok)
+	}
+}
@@ -37,6 +37,8 @@ type dfa struct {
 type state struct {
 	next  []int
 	match bool
+	// distance is the edit distance of the keys reaching a matching state
+	distance uint8
 }
 
 func (s *state) String() string {
@@ -72,6 +74,9 @@ type dfaBuilder struct {
 	startBytes []byte
 	endBytes   []byte
 	nexts      []int
+
+	stateLimit  int
+	memoryLimit int
 }
 
 func newDfaBuilder(lev *dynamicLevenshtein) *dfaBuilder {
@@ -83,6 +88,7 @@ func newDfaBuilder(lev *dynamicLevenshtein) *dfaBuilder {
 		cache:      make(map[string]int, 1024),
 		startBytes: make([]byte, unicode_utf8.UTFMax),
 		endBytes:   make([]byte, unicode_utf8.UTFMax),
+		stateLimit: StateLimit,
 	}
 	_, dfab.nexts = dfab.newState(false, nil) // create state 0, invalid
 	return dfab
@@ -110,7 +116,9 @@ func (b *dfaBuilder) build() (*dfa, error) {
 
 		i := 0
 		for _, r := range b.lev.query {
-			if uint(levState[i]) > b.lev.distance {
+			// a rune whose position is already too far can still
+			// complete a transposition
+			if uint(levState[i]) > b.lev.distance && !b.lev.transpositions {
 				i++
 				continue
 			}
@@ -129,8 +137,15 @@ func (b *dfaBuilder) build() (*dfa, error) {
 			i++
 		}
 
-		if len(b.dfa.states) > StateLimit {
-			return nil, ErrTooManyStates
+		numStates := len(b.dfa.states)
+		if numStates > b.stateLimit ||
+			(b.memoryLimit > 0 && numStates*stateSize > b.memoryLimit) {
+			return nil, &TooManyStatesError{
+				States:      numStates,
+				Memory:      numStates * stateSize,
+				StateLimit:  b.stateLimit,
+				MemoryLimit: b.memoryLimit,
+			}
 		}
 
 		stack, levState = stack.Pop()
@@ -167,8 +182,9 @@ func (b *dfaBuilder) cached(levState []int) (int, bool) {
 	}
 	match := b.lev.isMatch(levState)
 	b.dfa.states = append(b.dfa.states, state{
-		next:  make([]int, 256),
-		match: match,
+		next:     make([]int, 256),
+		match:    match,
+		distance: b.lev.matchDistance(levState),
 	})
 	newV := len(b.dfa.states) - 1
 	b.cache[string(b.keyBuf)] = newV
@@ -16,15 +16,48 @@ package levenshtein
 
 import (
 	"fmt"
+	"reflect"
 )
 
-// StateLimit is the maximum number of states allowed
+// StateLimit is the default maximum number of states allowed, see
+// NewWithLimits
 const StateLimit = 10000
 
 // ErrTooManyStates is returned if you attempt to build a Levenshtein
-// automaton which requires too many states.
+// automaton which requires too many states.  The error returned is a
+// *TooManyStatesError, which matches it with errors.Is.
 var ErrTooManyStates = fmt.Errorf("dfa contains more than %d states", StateLimit)
 
+// TooManyStatesError is returned when the DFA exceeds the state or memory
+// limit while it is being built, reporting how far it got, to help tune
+// the limits.
+type TooManyStatesError struct {
+	// States is the number of states built when construction stopped
+	States int
+	// Memory is the approximate size of those states, in bytes
+	Memory int
+	// StateLimit and MemoryLimit are the limits in effect, MemoryLimit
+	// is zero if the memory isn't limited
+	StateLimit  int
+	MemoryLimit int
+}
+
+func (e *TooManyStatesError) Error() string {
+	if e.States > e.StateLimit {
+		return fmt.Sprintf("dfa contains more than %d states", e.StateLimit)
+	}
+	return fmt.Sprintf("dfa with %d states uses more than %d bytes", e.States,
+		e.MemoryLimit)
+}
+
+func (e *TooManyStatesError) Unwrap() error {
+	return ErrTooManyStates
+}
+
+// stateSize is the approximate size of a state of the dfa
+var stateSize = int(reflect.TypeOf(state{}).Size()) +
+	256*int(reflect.TypeOf(0).Size())
+
 // Levenshtein implements the vellum.Automaton interface for matching
 // terms within the specified Levenshtein edit-distance of the queried
 // term.  This automaton recognizes utf-8 encoded bytes and computes
@@ -37,11 +70,41 @@ type Levenshtein struct {
 // New creates a new Levenshtein automaton for the specified
 // query string and edit distance.
 func New(query string, distance int) (*Levenshtein, error) {
-	lev := &dynamicLevenshtein{
+	return NewWithLimits(query, distance, 0, 0)
+}
+
+// NewWithLimits creates a new Levenshtein automaton for the specified
+// query string and edit distance, whose DFA may have at most maxStates
+// states (StateLimit if zero), using about maxMemory bytes (unlimited if
+// zero).  If either limit is exceeded, a *TooManyStatesError is returned,
+// reporting the number of states built.
+func NewWithLimits(query string, distance, maxStates, maxMemory int) (*Levenshtein, error) {
+	return newLevenshtein(&dynamicLevenshtein{
 		query:    query,
 		distance: uint(distance),
+	}, maxStates, maxMemory)
+}
+
+// NewDamerau creates a new Levenshtein automaton for the specified query
+// string and edit distance, which also counts the transposition of two
+// adjacent runes, such as "teh" for "the", as a single edit.  This is the
+// optimal string alignment distance, so a substring is edited at most
+// once: "ca" is at distance 3 of "abc", not 2.
+func NewDamerau(query string, distance int) (*Levenshtein, error) {
+	return newLevenshtein(&dynamicLevenshtein{
+		query:          query,
+		distance:       uint(distance),
+		transpositions: true,
+	}, 0, 0)
+}
+
+func newLevenshtein(lev *dynamicLevenshtein, maxStates, maxMemory int) (*Levenshtein, error) {
+	if maxStates <= 0 {
+		maxStates = StateLimit
 	}
 	dfabuilder := newDfaBuilder(lev)
+	dfabuilder.stateLimit = maxStates
+	dfabuilder.memoryLimit = maxMemory
 	dfa, err := dfabuilder.build()
 	if err != nil {
 		return nil, err
@@ -65,6 +128,16 @@ func (l *Levenshtein) IsMatch(s int) bool {
 	return false
 }
 
+// MatchDistance returns the edit distance between the query and the keys
+// reaching the specified matching state, or the maximum distance plus one
+// if the state isn't a matching state.
+func (l *Levenshtein) MatchDistance(s int) uint8 {
+	if s > 0 && s < len(l.dfa.states) && l.dfa.states[s].match {
+		return l.dfa.states[s].distance
+	}
+	return uint8(l.prog.distance + 1)
+}
+
 // CanMatch returns if the specified state can ever transition to a matching
 // state.
 func (l *Levenshtein) CanMatch(s int) bool {
@@ -15,6 +15,7 @@
 package levenshtein
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -226,3 +227,105 @@ func BenchmarkNewMarty2(b *testing.B) {
 		New("marty", 2)
 	}
 }
+
+func TestLevenshteinLimits(t *testing.T) {
+	l, err := NewWithLimits("kitten", 2, 0, 0)
+	if err != nil {
+		t.Fatalf("error building with default limits: %v", err)
+	}
+	numStates := len(l.dfa.states)
+
+	tests := []struct {
+		states, memory int
+	}{
+		{states: numStates - 1},
+		{memory: (numStates - 1) * stateSize},
+	}
+	for _, test := range tests {
+		_, err = NewWithLimits("kitten", 2, test.states, test.memory)
+		var serr *TooManyStatesError
+		if !errors.As(err, &serr) || !errors.Is(err, ErrTooManyStates) {
+			t.Fatalf("expected TooManyStatesError, got %v", err)
+		}
+		if serr.States < numStates-1 || serr.Memory != serr.States*stateSize ||
+			(test.states > 0 && serr.StateLimit != test.states) ||
+			serr.MemoryLimit != test.memory {
+			t.Errorf("unexpected error %+v", *serr)
+		}
+	}
+
+	_, err = NewWithLimits("kitten", 2, numStates, numStates*stateSize)
+	if err != nil {
+		t.Errorf("expected exact limits to be allowed, got %v", err)
+	}
+}
+
+// osaDistance is the optimal string alignment distance between the runes
+// of a and b
+func osaDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(min(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func TestDamerau(t *testing.T) {
+	l, err := New("the", 1)
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	if runLevenshtein(l, "teh") {
+		t.Errorf("expected a transposition to count twice by default")
+	}
+
+	// every key of up to 5 runes from the alphabet
+	alphabet := []string{"t", "h", "e", "a", "é"}
+	keys := []string{""}
+	for i := 0; i < len(keys) && len(keys) < 4000; i++ {
+		if len([]rune(keys[i])) < 5 {
+			for _, r := range alphabet {
+				keys = append(keys, keys[i]+r)
+			}
+		}
+	}
+	for _, query := range []string{"the", "thée", "aa", ""} {
+		for distance := 0; distance <= 2; distance++ {
+			l, err := NewDamerau(query, distance)
+			if err != nil {
+				t.Fatalf("error building %q/%d: %v", query, distance, err)
+			}
+			for _, key := range keys {
+				want := osaDistance(key, query) <= distance
+				if got := runLevenshtein(l, key); got != want {
+					t.Errorf("%q/%d: expected %q match %t", query, distance, key, want)
+				}
+			}
+		}
+	}
+}
+
+func runLevenshtein(l *Levenshtein, key string) bool {
+	s := l.Start()
+	for i := 0; i < len(key); i++ {
+		s = l.Accept(s, key[i])
+	}
+	return l.IsMatch(s)
+}
@@ -14,55 +14,118 @@
 
 package levenshtein
 
-import "unicode/utf8"
+import (
+	"strings"
+	"unicode/utf8"
+)
 
 // dynamicLevenshtein is the rune-based automaton, which is used
-// during the building of the ut8-aware byte-based automaton
+// during the building of the ut8-aware byte-based automaton.  Its states
+// are the row of the edit distance table for the runes accepted so far.
+// With transpositions, the states also hold the previous row, and the
+// previous rune if it is in the query or -1, which an adjacent
+// transposition depends on.
 type dynamicLevenshtein struct {
-	query    string
-	distance uint
+	query          string
+	distance       uint
+	transpositions bool
 }
 
 func (d *dynamicLevenshtein) start() []int {
 	runeCount := utf8.RuneCountInString(d.query)
-	rv := make([]int, runeCount+1)
+	size := runeCount + 1
+	if d.transpositions {
+		size = 2*(runeCount+1) + 1
+	}
+	rv := make([]int, size)
 	for i := 0; i < runeCount+1; i++ {
 		rv[i] = i
 	}
+	if d.transpositions {
+		for i := runeCount + 1; i < size-1; i++ {
+			rv[i] = int(d.distance) + 1
+		}
+		rv[size-1] = -1
+	}
 	return rv
 }
 
+// row returns the current row of the edit distance table in the state
+func (d *dynamicLevenshtein) row(state []int) []int {
+	if d.transpositions {
+		return state[:(len(state)-1)/2]
+	}
+	return state
+}
+
 func (d *dynamicLevenshtein) isMatch(state []int) bool {
-	last := state[len(state)-1]
+	row := d.row(state)
+	last := row[len(row)-1]
 	if uint(last) <= d.distance {
 		return true
 	}
 	return false
 }
 
+// matchDistance returns the edit distance of the query to the input, or the
+// maximum distance plus one if it is further
+func (d *dynamicLevenshtein) matchDistance(state []int) uint8 {
+	row := d.row(state)
+	last := row[len(row)-1]
+	if last < 0 || uint(last) > d.distance {
+		return uint8(d.distance + 1)
+	}
+	return uint8(last)
+}
+
 func (d *dynamicLevenshtein) canMatch(state []int) bool {
 	distance := int(d.distance)
-	for _, v := range state {
+	for _, v := range d.row(state) {
 		if v <= distance {
 			return true
 		}
 	}
+	if d.transpositions {
+		// the next rune may complete a transposition
+		prev := state[len(state)/2 : len(state)-1]
+		for _, v := range prev {
+			if v+1 <= distance {
+				return true
+			}
+		}
+	}
 	return false
 }
 
 func (d *dynamicLevenshtein) accept(state []int, r *rune) []int {
-	next := make([]int, 0, len(d.query)+1)
-	next = append(next, state[0]+1)
+	row := d.row(state)
+	next := make([]int, 0, len(state))
+	next = append(next, row[0]+1)
 	i := 0
+	var prevC rune
 	for _, c := range d.query {
 		var cost int
 		if r == nil || c != *r {
 			cost = 1
 		}
-		v := min(min(next[i]+1, state[i+1]+1), state[i]+cost)
+		v := min(min(next[i]+1, row[i+1]+1), row[i]+cost)
+		if d.transpositions && i > 0 && r != nil && *r == prevC &&
+			state[len(state)-1] == int(c) && c != prevC {
+			// the previous and this rune swap the query runes before
+			v = min(v, state[len(row)+i-1]+1)
+		}
 		next = append(next, min(v, int(d.distance)+1))
+		prevC = c
 		i++
 	}
+	if d.transpositions {
+		next = append(next, row...)
+		if r != nil && strings.ContainsRune(d.query, *r) {
+			next = append(next, int(*r))
+		} else {
+			next = append(next, -1)
+		}
+	}
 	return next
 }
 
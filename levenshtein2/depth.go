@@ -0,0 +1,58 @@
+//  Copyright (c) 2018 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package levenshtein2
+
+import "math"
+
+// CanMatchWithin returns true if a match can be reached from the state by
+// consuming at most depth more bytes, that is if the term can still be
+// completed within the edit distance, given that no key being searched has
+// more than depth bytes left.  It implements vellum.DepthHintAutomaton.
+func (d *DFA) CanMatchWithin(state int, depth int) bool {
+	d.minStepsOnce.Do(d.computeMinSteps)
+	if state <= 0 || state >= len(d.minSteps) {
+		return false
+	}
+	return d.minSteps[state] <= depth
+}
+
+// computeMinSteps computes the minimum number of bytes needed to reach a
+// match from each state, repeatedly relaxing the transitions until no
+// state improves.
+func (d *DFA) computeMinSteps() {
+	minSteps := make([]int, d.numStates())
+	for s := range minSteps {
+		if d.IsMatch(s) {
+			minSteps[s] = 0
+		} else {
+			minSteps[s] = math.MaxInt32
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for s := 1; s < len(minSteps); s++ {
+			for _, next := range d.transitions[s] {
+				if next == SinkState || minSteps[next] == math.MaxInt32 {
+					continue
+				}
+				if minSteps[next]+1 < minSteps[s] {
+					minSteps[s] = minSteps[next] + 1
+					changed = true
+				}
+			}
+		}
+	}
+	d.minSteps = minSteps
+}
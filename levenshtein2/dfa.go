@@ -17,6 +17,7 @@ package levenshtein2
 import (
 	"fmt"
 	"math"
+	"sync"
 )
 
 const SinkState = uint32(0)
@@ -26,6 +27,10 @@ type DFA struct {
 	distances   []Distance
 	initState   int
 	ed          uint8
+
+	// computed lazily, see CanMatchWithin
+	minStepsOnce sync.Once
+	minSteps     []int
 }
 
 /// Returns the initial state
@@ -70,6 +75,19 @@ func (d *DFA) IsMatch(state int) bool {
 	return false
 }
 
+// MatchDistance returns the edit distance between the query and the keys
+// reaching the specified matching state, or the maximum distance plus one
+// if the state isn't a matching state.
+func (d *DFA) MatchDistance(state int) uint8 {
+	if state < 0 || state >= d.numStates() {
+		return d.ed + 1
+	}
+	if e, ok := d.distance(state).(Exact); ok {
+		return e.d
+	}
+	return d.ed + 1
+}
+
 func (d *DFA) CanMatch(state int) bool {
 	return state > 0 && state < d.numStates()
 }
@@ -25,3 +25,39 @@ func TestLevenshtein2(t *testing.T) {
 	dfaBuilder.setInitialState(1)
 	_ = dfaBuilder.build(1)
 }
+
+func TestCanMatchWithin(t *testing.T) {
+	lb, err := NewLevenshteinAutomatonBuilder(1, false)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	dfa, err := lb.BuildDfa("abcd", 1)
+	if err != nil {
+		t.Fatalf("error building dfa: %v", err)
+	}
+	tests := []struct {
+		prefix string
+		depth  int
+		want   bool
+	}{
+		{prefix: "", depth: 3, want: true},
+		{prefix: "", depth: 2, want: false},
+		{prefix: "ab", depth: 1, want: true},
+		{prefix: "ab", depth: 0, want: false},
+		{prefix: "abcd", depth: 0, want: true},
+		{prefix: "abx", depth: 1, want: true},
+		{prefix: "abx", depth: 0, want: false},
+		{prefix: "xy", depth: 10, want: false},
+	}
+	for _, test := range tests {
+		s := dfa.Start()
+		for _, b := range []byte(test.prefix) {
+			s = dfa.Accept(s, b)
+		}
+		got := dfa.CanMatchWithin(s, test.depth)
+		if got != test.want {
+			t.Errorf("%q within %d: expected %t, got %t", test.prefix,
+				test.depth, test.want, got)
+		}
+	}
+}
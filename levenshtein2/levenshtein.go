@@ -28,6 +28,8 @@ var ErrTooManyStates = fmt.Errorf("dfa contains more than %d states",
 // datastructure that allows to produce small (but not minimal) DFA.
 type LevenshteinAutomatonBuilder struct {
 	pDfa *ParametricDFA
+	// minDists holds the least distance of each shape, see BuildParametric
+	minDists []uint8
 }
 
 // NewLevenshteinAutomatonBuilder creates a
@@ -47,7 +49,10 @@ func NewLevenshteinAutomatonBuilder(maxDistance uint8,
 		return nil, err
 	}
 
-	return &LevenshteinAutomatonBuilder{pDfa: pdfa}, nil
+	return &LevenshteinAutomatonBuilder{
+		pDfa:     pdfa,
+		minDists: pdfa.minDists(),
+	}, nil
 }
 
 // BuildDfa builds the levenshtein automaton for serving
@@ -0,0 +1,214 @@
+//  Copyright (c) 2018 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package levenshtein2
+
+import (
+	"fmt"
+	"math/bits"
+	"unicode/utf8"
+)
+
+// The states of a ParametricAutomaton pack, from the most significant
+// bits, the index of the parametric state, shapeID*(len(query)+1)+offset,
+// and the UTF-8 sequence being decoded: its length, the number of bytes
+// still expected, and the bits of the rune so far.
+const (
+	partialBits  = 21
+	partialMask  = 1<<partialBits - 1
+	pendingShift = partialBits
+	sizeShift    = pendingShift + 2
+	mainShift    = sizeShift + 2
+)
+
+// noRune stands for the invalid UTF-8 sequences, which match no rune of
+// the query.  As with utf8.DecodeRune, each byte of an invalid sequence
+// is one noRune, but for a byte starting a new sequence.
+const noRune = -1
+
+// ParametricAutomaton is a Levenshtein automaton which computes its
+// transitions from the parametric DFA of its LevenshteinAutomatonBuilder
+// as they are taken, rather than building the DFA of the query.  Building
+// it takes time and memory proportional to the length of the query, so it
+// never fails with ErrTooManyStates, however long the query, and it is
+// immutable, so it is safe for concurrent use.  Each transition decodes
+// UTF-8 and compares a rune with a few runes of the query, so searches
+// are slower than with a DFA.
+type ParametricAutomaton struct {
+	pdfa      *ParametricDFA
+	minDists  []uint8
+	query     []rune
+	numOffs   uint32
+	fuzziness uint8
+}
+
+// BuildParametric returns a ParametricAutomaton matching the keys within
+// the fuzziness of the query, which must be at most the MaxDistance of the
+// builder.
+func (lab *LevenshteinAutomatonBuilder) BuildParametric(query string,
+	fuzziness uint8) (*ParametricAutomaton, error) {
+	if fuzziness > lab.pDfa.maxDistance {
+		return nil, fmt.Errorf("fuzziness %d exceeds the maximum distance %d",
+			fuzziness, lab.pDfa.maxDistance)
+	}
+	rv := &ParametricAutomaton{
+		pdfa:      lab.pDfa,
+		minDists:  lab.minDists,
+		query:     []rune(query),
+		fuzziness: fuzziness,
+	}
+	rv.numOffs = uint32(len(rv.query)) + 1
+	maxMain := uint64(lab.pDfa.numStates()) * uint64(rv.numOffs)
+	if bits.Len64(maxMain)+mainShift >= bits.UintSize {
+		return nil, fmt.Errorf("query of %d runes too long for the states",
+			len(rv.query))
+	}
+	return rv, nil
+}
+
+// minDists returns the least distance of each shape, less than or equal
+// to the distance of any key reaching a state of that shape
+func (pdfa *ParametricDFA) minDists() []uint8 {
+	rv := make([]uint8, pdfa.numStates())
+	for shape := range rv {
+		dists := pdfa.distance[pdfa.diameter*uint32(shape):][:pdfa.diameter]
+		least := dists[0]
+		for _, d := range dists {
+			if d < least {
+				least = d
+			}
+		}
+		rv[shape] = least
+	}
+	return rv
+}
+
+func (a *ParametricAutomaton) state(s int) ParametricState {
+	main := s >> mainShift
+	return ParametricState{
+		shapeID: uint32(main / int(a.numOffs)),
+		offset:  uint32(main % int(a.numOffs)),
+	}
+}
+
+// encode returns the state of the parametric state, between runes
+func (a *ParametricAutomaton) encode(ps ParametricState) int {
+	return (int(ps.shapeID)*int(a.numOffs) + int(ps.offset)) << mainShift
+}
+
+// Start returns the start state of this automaton.
+func (a *ParametricAutomaton) Start() int {
+	return a.encode(a.pdfa.initialState())
+}
+
+// IsMatch returns if the specified state is a matching state.
+func (a *ParametricAutomaton) IsMatch(s int) bool {
+	return a.MatchDistance(s) <= a.fuzziness
+}
+
+// MatchDistance returns the edit distance between the query and the keys
+// reaching the specified matching state, or the fuzziness plus one if the
+// state isn't a matching state.
+func (a *ParametricAutomaton) MatchDistance(s int) uint8 {
+	if s <= 0 || s&(3<<pendingShift) != 0 {
+		return a.fuzziness + 1
+	}
+	e, ok := a.pdfa.getDistance(a.state(s), uint32(len(a.query))).(Exact)
+	if !ok || e.d > a.fuzziness {
+		return a.fuzziness + 1
+	}
+	return e.d
+}
+
+// CanMatch returns if the specified state can ever transition to a matching
+// state.
+func (a *ParametricAutomaton) CanMatch(s int) bool {
+	return s > 0 && a.minDists[a.state(s).shapeID] <= a.fuzziness
+}
+
+// WillAlwaysMatch returns if the specified state will always end in a
+// matching state.
+func (a *ParametricAutomaton) WillAlwaysMatch(int) bool {
+	return false
+}
+
+// Accept returns the new state, resulting from the transition byte b
+// when currently in the state s.
+func (a *ParametricAutomaton) Accept(s int, b byte) int {
+	if s <= 0 {
+		return 0
+	}
+	main := s >> mainShift
+	pending := s >> pendingShift & 3
+	if pending == 0 {
+		switch {
+		case b < utf8.RuneSelf:
+			return a.advance(s, rune(b))
+		case b < 0xc0:
+			// a continuation byte without its start
+			return a.advance(s, noRune)
+		case b < 0xe0:
+			return main<<mainShift | 2<<sizeShift | 1<<pendingShift |
+				int(b&0x1f)
+		case b < 0xf0:
+			return main<<mainShift | 3<<sizeShift | 2<<pendingShift |
+				int(b&0x0f)
+		case b < 0xf8:
+			// the size 4 is stored as 0
+			return main<<mainShift | 3<<pendingShift | int(b&0x07)
+		default:
+			return a.advance(s, noRune)
+		}
+	}
+	if b&0xc0 != 0x80 {
+		// the sequence is cut short, b is decoded on its own
+		s = a.advance(s, noRune)
+		if s == 0 {
+			return 0
+		}
+		return a.Accept(s, b)
+	}
+	partial := s&partialMask<<6 | int(b&0x3f)
+	pending--
+	if pending > 0 {
+		return main<<mainShift | s&(3<<sizeShift) | pending<<pendingShift |
+			partial&partialMask
+	}
+	r := rune(partial)
+	size := s >> sizeShift & 3
+	if size == 0 {
+		size = 4
+	}
+	if r > utf8.MaxRune || utf8.RuneLen(r) != size {
+		r = noRune
+	}
+	return a.advance(s, r)
+}
+
+// advance returns the state after the rune, from the state s
+func (a *ParametricAutomaton) advance(s int, r rune) int {
+	ps := a.state(s)
+	start := ps.offset
+	if start > uint32(len(a.query)) {
+		start = uint32(len(a.query))
+	}
+	stop := min(start+a.pdfa.diameter, uint32(len(a.query)))
+	chi := characteristicVector(a.query[start:stop], r)
+	transition := a.pdfa.transition(ps, uint32(chi))
+	next := transition.apply(ps)
+	if next.isDeadEnd() {
+		return 0
+	}
+	return a.encode(next)
+}
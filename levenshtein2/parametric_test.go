@@ -0,0 +1,160 @@
+//  Copyright (c) 2018 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package levenshtein2
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/couchbase/vellum/automatontest"
+)
+
+var parametricKeys = []string{"", "a", "ab", "abc", "abcd", "abdc", "bacd",
+	"acd", "abxd", "xabcd", "abcdx", "abcde", "dcba", "cat", "act", "cats",
+	"coat", "日本", "日本語", "本日", "日x本", "éa", "aé", "héllo", "hello",
+	"helo", "hlelo", "yellow"}
+
+// walk returns the state reached from the start of the automaton after key
+func walk(a interface {
+	Start() int
+	Accept(int, byte) int
+}, key string) int {
+	s := a.Start()
+	for i := 0; i < len(key); i++ {
+		s = a.Accept(s, key[i])
+	}
+	return s
+}
+
+func TestParametricMatchesDfa(t *testing.T) {
+	for _, transposition := range []bool{false, true} {
+		for distance := uint8(0); distance <= 2; distance++ {
+			lb, err := NewLevenshteinAutomatonBuilder(distance, transposition)
+			if err != nil {
+				t.Fatalf("error creating builder: %v", err)
+			}
+			for _, query := range parametricKeys {
+				dfa, err := lb.BuildDfa(query, distance)
+				if err != nil {
+					t.Fatalf("%q: error building dfa: %v", query, err)
+				}
+				pa, err := lb.BuildParametric(query, distance)
+				if err != nil {
+					t.Fatalf("%q: error building parametric: %v", query, err)
+				}
+				var seeds [][]byte
+				for _, key := range parametricKeys {
+					ds, ps := walk(dfa, key), walk(pa, key)
+					if dfa.IsMatch(ds) != pa.IsMatch(ps) {
+						t.Errorf("%q ~%d (transposition %t) %q: expected match %t",
+							query, distance, transposition, key, dfa.IsMatch(ds))
+					}
+					if dfa.IsMatch(ds) &&
+						dfa.MatchDistance(ds) != pa.MatchDistance(ps) {
+						t.Errorf("%q ~%d (transposition %t) %q: expected distance %d, got %d",
+							query, distance, transposition, key,
+							dfa.MatchDistance(ds), pa.MatchDistance(ps))
+					}
+					seeds = append(seeds, []byte(key))
+				}
+				err = automatontest.Check(pa, &automatontest.Opts{
+					Inputs:   200,
+					Alphabet: []byte("abcdx\xc3\xa9"),
+					Seeds:    seeds,
+				})
+				if err != nil {
+					t.Errorf("%q ~%d: %v", query, distance, err)
+				}
+			}
+		}
+	}
+}
+
+func TestParametricInvalidUTF8(t *testing.T) {
+	lb, err := NewLevenshteinAutomatonBuilder(1, false)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	pa, err := lb.BuildParametric("abc", 1)
+	if err != nil {
+		t.Fatalf("error building parametric: %v", err)
+	}
+	tests := []struct {
+		key  string
+		want uint8
+	}{
+		// an invalid sequence is a rune matching none of the query
+		{"a\xffc", 1},
+		{"a\xc3c", 1},
+		{"a\xc3\xa9c", 1},
+		{"a\x80bc", 1},
+		// an overlong encoding of b isn't b
+		{"a\xc1\xa2c", 1},
+		{"a\xff\xffc", 2},
+	}
+	for _, test := range tests {
+		got := pa.MatchDistance(walk(pa, test.key))
+		if got != test.want {
+			t.Errorf("%q: expected distance %d, got %d", test.key, test.want,
+				got)
+		}
+	}
+}
+
+func TestParametricLongQuery(t *testing.T) {
+	lb, err := NewLevenshteinAutomatonBuilder(2, true)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	query := strings.Repeat("abcdefghij", 500)
+	_, err = lb.BuildDfa(query, 2)
+	if err != ErrTooManyStates {
+		t.Fatalf("expected too many states for the dfa, got %v", err)
+	}
+	pa, err := lb.BuildParametric(query, 2)
+	if err != nil {
+		t.Fatalf("error building parametric: %v", err)
+	}
+	tests := []struct {
+		key  string
+		want uint8
+	}{
+		{query, 0},
+		{query[:2500] + "x" + query[2501:], 1},
+		{query[:2500] + query[2501:], 1},
+		{"x" + query + "x", 2},
+		{query[:1000] + "ba" + query[1002:], 1},
+	}
+	for _, test := range tests {
+		got := pa.MatchDistance(walk(pa, test.key))
+		if got != test.want {
+			t.Errorf("expected distance %d, got %d", test.want, got)
+		}
+	}
+	if s := walk(pa, "xyz"); pa.CanMatch(s) {
+		t.Errorf("expected no match possible after xyz")
+	}
+}
+
+func TestParametricFuzziness(t *testing.T) {
+	lb, err := NewLevenshteinAutomatonBuilder(1, false)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	_, err = lb.BuildParametric("abc", 2)
+	if err == nil {
+		t.Errorf("expected error for fuzziness beyond the maximum distance")
+	}
+}
@@ -0,0 +1,67 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// Result is the outcome of a Lookup.  Unlike the multiple values returned
+// by Get, it can't be mistaken for a key with a zero value when the key
+// doesn't exist, and may gain more information without breaking callers.
+type Result struct {
+	key    []byte
+	val    uint64
+	exists bool
+	err    error
+}
+
+// Exists returns whether the key was found.  It is false if the lookup
+// failed, see Err.
+func (r Result) Exists() bool {
+	return r.exists
+}
+
+// Value returns the value of the key, only meaningful if it Exists.
+func (r Result) Value() uint64 {
+	return r.val
+}
+
+// Key returns the key which was looked up, the slice passed to Lookup, so
+// it is only valid for as long as that slice is.
+func (r Result) Key() []byte {
+	return r.key
+}
+
+// Err returns the error which prevented the lookup, such as ErrCorrupt.
+func (r Result) Err() error {
+	return r.err
+}
+
+// Lookup looks up the key, as Get does, but returns a Result.
+func (f *FST) Lookup(key []byte) Result {
+	return f.lookup(key, nil)
+}
+
+// Lookup looks up the key, as Get does, but returns a Result.
+func (r *Reader) Lookup(key []byte) Result {
+	r.acquire()
+	defer r.release()
+	return r.f.lookup(key, &r.prealloc)
+}
+
+func (f *FST) lookup(key []byte, prealloc fstState) Result {
+	val, exists, err := f.get(key, prealloc)
+	if err != nil {
+		return Result{key: key, err: err}
+	}
+	return Result{key: key, val: val, exists: exists}
+}
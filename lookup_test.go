@@ -0,0 +1,81 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, []string{"one", "zero"}, []uint64{1, 0})
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	reader, err := fst.Reader()
+	if err != nil {
+		t.Fatalf("error creating reader: %v", err)
+	}
+
+	tests := []struct {
+		key    string
+		exists bool
+		val    uint64
+	}{
+		{"one", true, 1},
+		{"zero", true, 0},
+		{"two", false, 0},
+		{"", false, 0},
+	}
+	for _, test := range tests {
+		for _, res := range []Result{fst.Lookup([]byte(test.key)),
+			reader.Lookup([]byte(test.key))} {
+			if res.Err() != nil || res.Exists() != test.exists ||
+				res.Value() != test.val || string(res.Key()) != test.key {
+				t.Errorf("expected %s %t %d, got %s %t %d %v", test.key,
+					test.exists, test.val, res.Key(), res.Exists(), res.Value(),
+					res.Err())
+			}
+		}
+	}
+
+	// errors are reported, and never mistaken for an absent key
+	data := append([]byte(nil), buf.Bytes()...)
+	fst, err = Load(data)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	root := int(binary.LittleEndian.Uint64(data[len(data)-8:]))
+	data[root] = 0 // multiple transitions, but number of them truncated
+	res := fst.Lookup([]byte("one"))
+	if res.Exists() || !errors.Is(res.Err(), ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %t %v", res.Exists(), res.Err())
+	}
+}
@@ -0,0 +1,235 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+var errMapClosed = errors.New("map closed")
+
+// Map is an immutable map from string keys to uint64 values, backed by an
+// FST.  It is a convenience facade for users who don't need the full
+// flexibility of the FST, Builder and Iterator APIs.  A Map is safe for
+// concurrent use.
+type Map struct {
+	path string
+	opts []OpenOption
+
+	once sync.Once
+	fst  *FST
+	err  error
+}
+
+// OpenMap returns a Map backed by the FST file at the provided path.  The
+// file is opened lazily, when the Map is first used, so any error opening
+// it is returned by that first use (and all subsequent ones).
+func OpenMap(path string, opts ...OpenOption) *Map {
+	return &Map{
+		path: path,
+		opts: opts,
+	}
+}
+
+// NewMap returns a Map backed by an already opened FST.  Closing the Map
+// closes the FST.
+func NewMap(fst *FST) *Map {
+	rv := &Map{
+		fst: fst,
+	}
+	rv.once.Do(func() {})
+	return rv
+}
+
+func (m *Map) open() (*FST, error) {
+	m.once.Do(func() {
+		m.fst, m.err = Open(m.path, m.opts...)
+	})
+	return m.fst, m.err
+}
+
+// Get returns the value associated with the key, and whether the key
+// exists.
+func (m *Map) Get(key string) (uint64, bool, error) {
+	fst, err := m.open()
+	if err != nil {
+		return 0, false, err
+	}
+	return fst.Get([]byte(key))
+}
+
+// Has returns true if the Map contains the key.
+func (m *Map) Has(key string) (bool, error) {
+	fst, err := m.open()
+	if err != nil {
+		return false, err
+	}
+	return fst.Contains([]byte(key))
+}
+
+// Len returns the number of keys in the Map.
+func (m *Map) Len() (int, error) {
+	fst, err := m.open()
+	if err != nil {
+		return 0, err
+	}
+	return fst.Len(), nil
+}
+
+// Range invokes the callback for each key/value pair, in lexicographic
+// order, with start <= key < end.  An empty end means there is no upper
+// bound.  Iteration stops early if the callback returns false.
+func (m *Map) Range(start, end string, cb func(key string, val uint64) bool) error {
+	fst, err := m.open()
+	if err != nil {
+		return err
+	}
+	var endKey []byte
+	if end != "" {
+		endKey = []byte(end)
+	}
+	itr, err := fst.Iterator([]byte(start), endKey)
+	for err == nil {
+		key, val := itr.Current()
+		if !cb(string(key), val) {
+			return nil
+		}
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		return err
+	}
+	return nil
+}
+
+// Close releases the resources backing the Map, if it was ever opened.
+func (m *Map) Close() error {
+	// prevent a lazy open after close
+	m.once.Do(func() {
+		m.err = errMapClosed
+	})
+	if m.fst == nil {
+		return nil
+	}
+	return m.fst.Close()
+}
+
+// MapBuilder collects key/value pairs in memory, in any order, and then
+// writes them out as an FST, which can be used with Map.  It is intended for
+// modest inputs which fit comfortably in memory, larger inputs should be
+// sorted externally and inserted into a Builder directly.
+type MapBuilder struct {
+	opts    []BuilderOption
+	entries map[string]uint64
+}
+
+// NewMapBuilder returns a new MapBuilder, which will build using the
+// provided options.
+func NewMapBuilder(opts ...BuilderOption) *MapBuilder {
+	return &MapBuilder{
+		opts:    opts,
+		entries: make(map[string]uint64),
+	}
+}
+
+// Set associates the value with the key, replacing any previous value.
+func (b *MapBuilder) Set(key string, val uint64) {
+	b.entries[key] = val
+}
+
+// Len returns the number of keys set.
+func (b *MapBuilder) Len() int {
+	return len(b.entries)
+}
+
+// Write sorts the keys and writes out the FST to the provided Writer.
+func (b *MapBuilder) Write(w io.Writer) error {
+	return FromMap(b.entries, w, b.opts...)
+}
+
+// Save writes out the FST to the file at the provided path, which can then
+// be opened with OpenMap.
+func (b *MapBuilder) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = b.Write(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// KV is a key/value pair, see FromSlice.
+type KV struct {
+	Key string
+	Val uint64
+}
+
+// FromMap sorts the keys of the map and writes out the FST containing all
+// of its key/value pairs to the provided Writer.
+func FromMap(m map[string]uint64, w io.Writer, opts ...BuilderOption) error {
+	kvs := make([]KV, 0, len(m))
+	for k, v := range m {
+		kvs = append(kvs, KV{Key: k, Val: v})
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	return buildSorted(kvs, w, opts)
+}
+
+// FromSlice sorts the key/value pairs by key and writes out the FST
+// containing them to the provided Writer.  The sort is stable, so when a
+// key occurs more than once, the value occurring last in the slice is kept,
+// as if the pairs were assigned to a map in order.  The slice is not
+// modified.
+func FromSlice(kvs []KV, w io.Writer, opts ...BuilderOption) error {
+	sorted := make([]KV, len(kvs))
+	copy(sorted, kvs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	// keep only the last of each run of duplicate keys
+	n := 0
+	for i := range sorted {
+		if i+1 < len(sorted) && sorted[i+1].Key == sorted[i].Key {
+			continue
+		}
+		sorted[n] = sorted[i]
+		n++
+	}
+	return buildSorted(sorted[:n], w, opts)
+}
+
+func buildSorted(kvs []KV, w io.Writer, opts []BuilderOption) error {
+	builder, err := New(w, opts...)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		err = builder.Insert([]byte(kv.Key), kv.Val)
+		if err != nil {
+			return err
+		}
+	}
+	return builder.Close()
+}
@@ -0,0 +1,187 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "map.fst")
+
+	b := NewMapBuilder()
+	// deliberately out of order, with a replaced value
+	b.Set("tuesday", 2)
+	b.Set("monday", 1)
+	b.Set("wednesday", 9)
+	b.Set("thursday", 4)
+	b.Set("wednesday", 3)
+	if b.Len() != 4 {
+		t.Errorf("expected 4 keys, got %d", b.Len())
+	}
+	err = b.Save(path)
+	if err != nil {
+		t.Fatalf("error saving map: %v", err)
+	}
+
+	m := OpenMap(path)
+	defer func() {
+		err = m.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	val, exists, err := m.Get("wednesday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists || val != 3 {
+		t.Errorf("expected wednesday 3, got %d (exists: %t)", val, exists)
+	}
+	has, err := m.Has("friday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Errorf("expected no friday")
+	}
+	n, err := m.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 keys, got %d", n)
+	}
+
+	var got []string
+	err = m.Range("n", "", func(key string, val uint64) bool {
+		got = append(got, key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"thursday", "tuesday", "wednesday"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	got = got[:0]
+	err = m.Range("", "u", func(key string, val uint64) bool {
+		got = append(got, key)
+		return len(got) < 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"monday"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMapLazyOpenError(t *testing.T) {
+	m := OpenMap("/does/not/exist.fst")
+	_, _, err := m.Get("a")
+	if err == nil {
+		t.Fatalf("expected error opening missing file")
+	}
+	_, err = m.Has("a")
+	if err == nil {
+		t.Fatalf("expected error opening missing file again")
+	}
+	err = m.Close()
+	if err != nil {
+		t.Errorf("expected nil closing unopened map, got %v", err)
+	}
+}
+
+func TestMapCloseUnopened(t *testing.T) {
+	m := OpenMap("/does/not/exist.fst")
+	err := m.Close()
+	if err != nil {
+		t.Errorf("expected nil closing unopened map, got %v", err)
+	}
+	_, _, err = m.Get("a")
+	if err != errMapClosed {
+		t.Errorf("expected errMapClosed, got %v", err)
+	}
+}
+
+func fstPairs(t *testing.T, data []byte) []KV {
+	fst, err := Load(data)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	var rv []KV
+	itr, err := fst.Iterator(nil, nil)
+	for err == nil {
+		k, v := itr.Current()
+		rv = append(rv, KV{Key: string(k), Val: v})
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone {
+		t.Fatalf("error iterating: %v", err)
+	}
+	return rv
+}
+
+func TestFromMap(t *testing.T) {
+	var buf bytes.Buffer
+	err := FromMap(map[string]uint64{
+		"tues": 3,
+		"mon":  1,
+		"":     7,
+		"thur": 4,
+	}, &buf)
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	want := []KV{{"", 7}, {"mon", 1}, {"thur", 4}, {"tues", 3}}
+	got := fstPairs(t, buf.Bytes())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestFromSlice(t *testing.T) {
+	kvs := []KV{{"tues", 3}, {"mon", 1}, {"tues", 5}, {"thur", 4}, {"mon", 2}}
+	var buf bytes.Buffer
+	err := FromSlice(kvs, &buf, WithSubtreeCounts())
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	want := []KV{{"mon", 2}, {"thur", 4}, {"tues", 5}}
+	got := fstPairs(t, buf.Bytes())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if kvs[0].Key != "tues" || kvs[4].Key != "mon" {
+		t.Errorf("expected input slice to be unmodified, got %v", kvs)
+	}
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// MatchKind describes how the automaton of a search matched a key.
+type MatchKind int
+
+const (
+	// MatchNone means the iterator isn't positioned on a key.
+	MatchNone MatchKind = iota
+	// MatchExact means the automaton matched the key itself, without
+	// matching every extension of it.
+	MatchExact
+	// MatchPrefix means the automaton matches every extension of a prefix
+	// of the key, possibly the whole key, as a prefix query does.
+	MatchPrefix
+)
+
+func (k MatchKind) String() string {
+	switch k {
+	case MatchExact:
+		return "exact"
+	case MatchPrefix:
+		return "prefix"
+	}
+	return "none"
+}
+
+// Match describes how the automaton of a search matched the current key,
+// allowing exact matches to be ranked above prefix matches.
+type Match struct {
+	Kind MatchKind
+	// PrefixLen is, for MatchPrefix, the length of the shortest prefix of
+	// the key after which the automaton matches every extension.  It is
+	// the length of the key when the key is that prefix.
+	PrefixLen int
+}
+
+// Match returns how the automaton matched the current key.  It is computed
+// on demand, walking the automaton states along the key, so searches not
+// calling it pay nothing.  Searches without an automaton match every key
+// as a prefix of length 0.
+func (i *FSTIterator) Match() Match {
+	if i.f.enter() != nil {
+		return Match{}
+	}
+	defer i.f.exit()
+	if i.ring != nil && i.ring.n > 0 {
+		// the path is ahead of the current key, so the automaton is run
+		// over the key
+		key, _ := i.ring.head()
+		s := i.aut.Start()
+		for depth := 0; ; depth++ {
+			if i.aut.WillAlwaysMatch(s) {
+				return Match{Kind: MatchPrefix, PrefixLen: depth}
+			}
+			if depth == len(key) {
+				return Match{Kind: MatchExact}
+			}
+			s = i.aut.Accept(s, key[depth])
+		}
+	}
+	if len(i.statesStack) == 0 || !i.statesStack[len(i.statesStack)-1].Final() {
+		return Match{}
+	}
+	for depth, s := range i.autStatesStack {
+		if i.aut.WillAlwaysMatch(s) {
+			return Match{Kind: MatchPrefix, PrefixLen: depth}
+		}
+	}
+	return Match{Kind: MatchExact}
+}
+
+// Match returns how the automaton matched the current key, see
+// FSTIterator.Match.  PrefixLen is relative to the prefix of the view.
+func (i *SubIterator) Match() Match {
+	rv := i.itr.Match()
+	if rv.Kind == MatchPrefix {
+		rv.PrefixLen -= len(i.prefix)
+		if rv.PrefixLen < 0 {
+			rv.PrefixLen = 0
+		}
+	}
+	return rv
+}
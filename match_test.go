@@ -0,0 +1,110 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// literalMatch matches the literal, or if prefix is set, any key starting
+// with it.  State i+1 means i bytes of the literal have been matched.
+type literalMatch struct {
+	lit    string
+	prefix bool
+}
+
+func (l literalMatch) Start() int          { return 1 }
+func (l literalMatch) IsMatch(s int) bool  { return s == len(l.lit)+1 }
+func (l literalMatch) CanMatch(s int) bool { return s > 0 }
+func (l literalMatch) WillAlwaysMatch(s int) bool {
+	return l.prefix && s == len(l.lit)+1
+}
+func (l literalMatch) Accept(s int, b byte) int {
+	if s > 0 && s <= len(l.lit) && l.lit[s-1] == b {
+		return s + 1
+	}
+	if l.prefix && s == len(l.lit)+1 {
+		return s
+	}
+	return 0
+}
+
+func TestIteratorMatch(t *testing.T) {
+	fst := buildKVs(t, KV{"ba", 1}, KV{"bar", 2}, KV{"bars", 3}, KV{"baz", 4},
+		KV{"foo", 5})
+
+	matches := func(itr interface {
+		Current() ([]byte, uint64)
+		Next() error
+		Match() Match
+	}, err error) []string {
+		var rv []string
+		for err == nil {
+			key, _ := itr.Current()
+			m := itr.Match()
+			rv = append(rv, fmt.Sprintf("%s:%v:%d", key, m.Kind, m.PrefixLen))
+			err = itr.Next()
+		}
+		if err != ErrIteratorDone {
+			t.Fatalf("error iterating: %v", err)
+		}
+		return rv
+	}
+
+	tests := []struct {
+		name string
+		aut  Automaton
+		want []string
+	}{
+		{
+			name: "exact",
+			aut:  literalMatch{lit: "bar"},
+			want: []string{"bar:exact:0"},
+		},
+		{
+			name: "prefix",
+			aut:  literalMatch{lit: "ba", prefix: true},
+			want: []string{"ba:prefix:2", "bar:prefix:2", "bars:prefix:2",
+				"baz:prefix:2"},
+		},
+		{
+			name: "none",
+			want: []string{"ba:prefix:0", "bar:prefix:0", "bars:prefix:0",
+				"baz:prefix:0", "foo:prefix:0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			itr, err := fst.Search(test.aut, nil, nil)
+			got := matches(itr, err)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+			if itr.Match() != (Match{}) {
+				t.Errorf("expected no match once done, got %v", itr.Match())
+			}
+		})
+	}
+
+	itr, err := fst.Sub([]byte("ba")).Search(literalMatch{lit: "r", prefix: true},
+		nil, nil)
+	got := matches(itr, err)
+	want := []string{"r:prefix:1", "rs:prefix:1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v in view, got %v", want, got)
+	}
+}
@@ -16,6 +16,8 @@ package vellum
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 )
 
 // MergeFunc is used to choose the new value for a key when merging a slice
@@ -25,6 +27,10 @@ import (
 // implementations to prioritize one iterator over another.
 type MergeFunc func([]uint64) uint64
 
+// ResolveFunc is a MergeFunc which is also given the key whose values are
+// merged, see MergeFSTs.  The key must not be modified or retained.
+type ResolveFunc func(key []byte, vals []uint64) uint64
+
 // MergeIterator implements the Iterator interface by traversing a slice
 // of iterators and merging the contents of them.  If the same key exists
 // in mulitipe underlying iterators, a user-provided MergeFunc will be
@@ -40,14 +46,48 @@ type MergeIterator struct {
 	lowIdxs []int
 
 	mergeV []uint64
+	// fold, if set, combines the values of duplicate keys pairwise,
+	// instead of f
+	fold func(a, b uint64) uint64
+	// resolve, if set, chooses the values of duplicate keys, instead of f
+	resolve ResolveFunc
 }
 
 // NewMergeIterator creates a new MergeIterator over the provided slice of
 // Iterators and with the specified MergeFunc to resolve duplicate keys.
 func NewMergeIterator(itrs []Iterator, f MergeFunc) (*MergeIterator, error) {
+	return newMergeIterator(itrs, f, nil, nil)
+}
+
+// NewMergeSearch creates a new MergeIterator over the keys of the FSTs
+// matching the automaton, with startKeyInclusive <= key < endKeyExclusive,
+// using the specified MergeFunc to resolve duplicate keys, the values being
+// in the order of the FSTs.  Each FST is searched with the automaton, see
+// FST.Search, so its states must be valid in any of them.  Iterators which
+// aren't over FSTs can be filtered with NewFilterIterator.
+func NewMergeSearch(fsts []*FST, aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte, f MergeFunc) (*MergeIterator, error) {
+	var itrs []Iterator
+	for _, fst := range fsts {
+		itr, err := fst.Search(aut, startKeyInclusive, endKeyExclusive)
+		if errors.Is(err, ErrIteratorDone) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		itrs = append(itrs, itr)
+	}
+	return NewMergeIterator(itrs, f)
+}
+
+func newMergeIterator(itrs []Iterator, f MergeFunc,
+	fold func(a, b uint64) uint64, resolve ResolveFunc) (*MergeIterator, error) {
 	rv := &MergeIterator{
 		itrs:    itrs,
 		f:       f,
+		fold:    fold,
+		resolve: resolve,
 		currKs:  make([][]byte, len(itrs)),
 		currVs:  make([]uint64, len(itrs)),
 		lowIdxs: make([]int, 0, len(itrs)),
@@ -88,13 +128,22 @@ func (m *MergeIterator) updateMatches() {
 			m.lowIdxs = append(m.lowIdxs, i)
 		}
 	}
-	if len(m.lowIdxs) > 1 {
+	if len(m.lowIdxs) > 1 && m.fold != nil {
+		m.lowV = m.currVs[m.lowIdxs[0]]
+		for _, vi := range m.lowIdxs[1:] {
+			m.lowV = m.fold(m.lowV, m.currVs[vi])
+		}
+	} else if len(m.lowIdxs) > 1 {
 		// merge multiple values
 		m.mergeV = m.mergeV[:0]
 		for _, vi := range m.lowIdxs {
 			m.mergeV = append(m.mergeV, m.currVs[vi])
 		}
-		m.lowV = m.f(m.mergeV)
+		if m.resolve != nil {
+			m.lowV = m.resolve(m.lowK, m.mergeV)
+		} else {
+			m.lowV = m.f(m.mergeV)
+		}
 	} else if len(m.lowIdxs) == 1 {
 		m.lowV = m.currVs[m.lowIdxs[0]]
 	}
@@ -113,10 +162,10 @@ func (m *MergeIterator) Next() error {
 	// move all the current low iterators to next
 	for _, vi := range m.lowIdxs {
 		err := m.itrs[vi].Next()
-		if err != nil && err != ErrIteratorDone {
+		if err != nil && !errors.Is(err, ErrIteratorDone) {
 			return err
 		}
-		m.currKs[vi], m.currVs[vi] = m.itrs[vi].Current()
+		m.current(vi, err)
 	}
 	m.updateMatches()
 	if m.lowK == nil {
@@ -125,15 +174,27 @@ func (m *MergeIterator) Next() error {
 	return nil
 }
 
+// current records the current key of iterator i, none once it returned
+// ErrIteratorDone, as iterators stopping at their end bound may still
+// have a current key
+func (m *MergeIterator) current(i int, err error) {
+	if err != nil {
+		m.currKs[i], m.currVs[i] = nil, 0
+		return
+	}
+	m.currKs[i], m.currVs[i] = m.itrs[i].Current()
+}
+
 // Seek advances this iterator to the specified key/value pair.  If this key
 // is not in the FST, Current() will return the next largest key.  If this
 // seek operation would go past the last key, then ErrIteratorDone is returned.
 func (m *MergeIterator) Seek(key []byte) error {
 	for i := range m.itrs {
 		err := m.itrs[i].Seek(key)
-		if err != nil && err != ErrIteratorDone {
+		if err != nil && !errors.Is(err, ErrIteratorDone) {
 			return err
 		}
+		m.current(i, err)
 	}
 	m.updateMatches()
 	if m.lowK == nil {
@@ -142,6 +203,13 @@ func (m *MergeIterator) Seek(key []byte) error {
 	return nil
 }
 
+// Reset returns an error, as the Iterators being merged can't all be reset
+// to the same FST, it is only implemented so that a MergeIterator is an
+// Iterator, which can itself be merged or filtered.
+func (m *MergeIterator) Reset(*FST, []byte, []byte, Automaton) error {
+	return fmt.Errorf("merge iterator can't be reset")
+}
+
 // Close will attempt to close all the underlying Iterators.  If any errors
 // are encountered, the first will be returned.
 func (m *MergeIterator) Close() error {
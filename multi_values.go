@@ -0,0 +1,231 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrNoValueLists is returned when reading the values of a key from an FST
+// not built with a MultiBuilder.
+var ErrNoValueLists = errors.New("fst has no value lists")
+
+// Value lists associate several values with each key of an FST built with
+// a MultiBuilder.  Each distinct list is stored once, as the uvarint
+// encoding of its values, in an optional section laid out as the payloads
+// are (see payloads.go), and the outputs of the FST are indexes into it.
+// The typeValueLists bit is set in the header type.
+
+// MultiBuilder builds an FST associating a list of values with each key,
+// such as the identifiers of the documents containing a term.  Keys must
+// be inserted in lexicographical order, as with a Builder.
+//
+// Read the values with FST.GetMulti, or iterate over them with a
+// MultiIterator.  The values returned by the other Iterators are the
+// indexes of the lists, which FST.Values decodes.
+type MultiBuilder struct {
+	b   *Builder
+	buf []byte
+}
+
+// NewMultiBuilder returns a new MultiBuilder which will stream out the
+// underlying representation to the provided Writer as the set is built.
+// BuilderOpts.InternValues is not supported, the lists are always
+// interned.
+func NewMultiBuilder(w io.Writer, opts ...BuilderOption) (*MultiBuilder, error) {
+	o := *applyBuilderOptions(opts)
+	if o.InternValues {
+		return nil, ErrInternedValues
+	}
+	o.valueLists = true
+	b, err := newBuilder(w, &o)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiBuilder{b: b}, nil
+}
+
+// Insert adds the key with its values, which are kept in the order
+// provided, duplicates included.
+func (b *MultiBuilder) Insert(key []byte, vals []uint64) error {
+	if b.b.out.err != nil {
+		return b.b.out.err
+	}
+	if bytes.Compare(key, b.b.last) < 0 {
+		return ErrOutOfOrder
+	}
+	b.buf = b.buf[:0]
+	var tmp [binary.MaxVarintLen64]byte
+	for _, val := range vals {
+		n := binary.PutUvarint(tmp[:], val)
+		b.buf = append(b.buf, tmp[:n]...)
+	}
+	return b.b.Insert(key, b.b.valueLists.intern(b.buf))
+}
+
+// Reset resets the MultiBuilder to build another FST to the provided
+// Writer.
+func (b *MultiBuilder) Reset(w io.Writer) error {
+	return b.b.Reset(w)
+}
+
+// Close MUST be called after inserting all keys.
+func (b *MultiBuilder) Close() error {
+	return b.b.Close()
+}
+
+// HasValueLists returns true if the FST was built with a MultiBuilder.
+func (f *FST) HasValueLists() bool {
+	return f.valueLists != nil
+}
+
+// GetMulti returns the values associated with the key, and whether the key
+// exists.
+func (f *FST) GetMulti(key []byte) ([]uint64, bool, error) {
+	if err := f.enter(); err != nil {
+		return nil, false, err
+	}
+	defer f.exit()
+	if f.valueLists == nil {
+		return nil, false, ErrNoValueLists
+	}
+	val, exists, err := f.Get(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	rv, err := f.Values(val)
+	if err != nil {
+		return nil, false, err
+	}
+	return rv, true, nil
+}
+
+// Values returns the list of values a value returned by an Iterator over
+// the FST refers to.
+func (f *FST) Values(val uint64) ([]uint64, error) {
+	return f.appendValues(nil, val)
+}
+
+// appendValues appends the list of values at index val to dst
+func (f *FST) appendValues(dst []uint64, val uint64) ([]uint64, error) {
+	if err := f.enter(); err != nil {
+		return dst, err
+	}
+	defer f.exit()
+	if f.valueLists == nil {
+		return dst, ErrNoValueLists
+	}
+	list, ok := f.valueLists.get(val)
+	if !ok {
+		return dst, corruptf(0, "value list index %d out of range", val)
+	}
+	for len(list) > 0 {
+		v, n := binary.Uvarint(list)
+		if n <= 0 {
+			return dst, corruptf(0, "invalid value list %d", val)
+		}
+		dst = append(dst, v)
+		list = list[n:]
+	}
+	return dst, nil
+}
+
+// MultiIterator iterates over the keys of an FST built with a
+// MultiBuilder, along with their lists of values.
+type MultiIterator struct {
+	f    *FST
+	itr  *FSTIterator
+	vals []uint64
+	err  error
+}
+
+// MultiIterator returns a new MultiIterator over the keys between the
+// provided startKeyInclusive and endKeyExclusive.
+func (f *FST) MultiIterator(startKeyInclusive,
+	endKeyExclusive []byte) (*MultiIterator, error) {
+	return f.MultiSearch(nil, startKeyInclusive, endKeyExclusive)
+}
+
+// MultiSearch returns a new MultiIterator over the keys between the
+// provided startKeyInclusive and endKeyExclusive that satisfy the provided
+// automaton.
+func (f *FST) MultiSearch(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) (*MultiIterator, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	if f.valueLists == nil {
+		return nil, ErrNoValueLists
+	}
+	itr, err := f.Search(aut, startKeyInclusive, endKeyExclusive)
+	if err != nil {
+		return nil, err
+	}
+	rv := &MultiIterator{f: f, itr: itr}
+	err = rv.decode()
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// decode decodes the values of the current key
+func (m *MultiIterator) decode() error {
+	_, val := m.itr.Current()
+	m.vals, m.err = m.f.appendValues(m.vals[:0], val)
+	return m.err
+}
+
+// Current returns the key and the values currently pointed to by the
+// iterator.  The slices are only valid until the next call to Next or
+// Seek.
+func (m *MultiIterator) Current() ([]byte, []uint64) {
+	key, _ := m.itr.Current()
+	return key, m.vals
+}
+
+// Next advances the iterator to the next key, returning ErrIteratorDone
+// at the end.
+func (m *MultiIterator) Next() error {
+	if m.err != nil {
+		return m.err
+	}
+	err := m.itr.Next()
+	if err != nil {
+		return err
+	}
+	return m.decode()
+}
+
+// Seek advances the iterator to the specified key, see FSTIterator.Seek.
+func (m *MultiIterator) Seek(key []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	err := m.itr.Seek(key)
+	if err != nil {
+		return err
+	}
+	return m.decode()
+}
+
+// Close will free any resources held by this iterator.
+func (m *MultiIterator) Close() error {
+	return m.itr.Close()
+}
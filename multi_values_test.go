@@ -0,0 +1,117 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMultiBuilder(t *testing.T) {
+	values := func(i int) []uint64 {
+		// some lists are empty or shared, and some have duplicates
+		switch {
+		case i%10 == 0:
+			return nil
+		case i%7 == 0:
+			return []uint64{7, 1 << 60}
+		}
+		return []uint64{uint64(i), uint64(i), uint64(i) * 1000, 3}
+	}
+
+	var buf bytes.Buffer
+	b, err := NewMultiBuilder(&buf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	for i, word := range thousandTestWords {
+		err = b.Insert([]byte(word), values(i))
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Insert([]byte("a"), nil)
+	if err != ErrOutOfOrder {
+		t.Errorf("expected ErrOutOfOrder, got %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if !fst.HasValueLists() || fst.HasPayloads() {
+		t.Fatalf("expected value lists only")
+	}
+
+	for i, word := range thousandTestWords {
+		got, exists, err := fst.GetMulti([]byte(word))
+		if err != nil || !exists || len(got) != len(values(i)) ||
+			(len(got) > 0 && !reflect.DeepEqual(got, values(i))) {
+			t.Errorf("expected %v for %q, got %v %t %v", values(i), word,
+				got, exists, err)
+		}
+	}
+	_, exists, err := fst.GetMulti([]byte("not a word"))
+	if err != nil || exists {
+		t.Errorf("expected a missing key, got %t %v", exists, err)
+	}
+
+	itr, err := fst.MultiIterator(nil, nil)
+	i := 0
+	for ; err == nil; i++ {
+		key, got := itr.Current()
+		if string(key) != thousandTestWords[i] ||
+			len(got) != len(values(i)) ||
+			(len(got) > 0 && !reflect.DeepEqual(got, values(i))) {
+			t.Errorf("expected %q %v, got %q %v", thousandTestWords[i],
+				values(i), key, got)
+		}
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) || i != len(thousandTestWords) {
+		t.Fatalf("expected %d keys, got %d %v", len(thousandTestWords), i, err)
+	}
+
+	itr, err = fst.MultiIterator(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	err = itr.Seek([]byte(thousandTestWords[501]))
+	if err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	if key, got := itr.Current(); string(key) != thousandTestWords[501] ||
+		!reflect.DeepEqual(got, values(501)) {
+		t.Errorf("expected %q after seeking, got %q %v",
+			thousandTestWords[501], key, got)
+	}
+
+	plain := buildKVs(t, KV{"a", 1})
+	if _, _, err = plain.GetMulti([]byte("a")); err != ErrNoValueLists {
+		t.Errorf("expected ErrNoValueLists, got %v", err)
+	}
+	if _, err = plain.MultiIterator(nil, nil); err != ErrNoValueLists {
+		t.Errorf("expected ErrNoValueLists, got %v", err)
+	}
+	_, err = NewMultiBuilder(&buf, WithInternedValues())
+	if err != ErrInternedValues {
+		t.Errorf("expected ErrInternedValues, got %v", err)
+	}
+}
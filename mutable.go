@@ -0,0 +1,377 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MutableFST layers an in-memory overlay of inserted and deleted keys over
+// an immutable FST, the base, presenting them as a single dictionary: the
+// overlay shadows the keys of the base.  Flush merges the overlay into a
+// new FST file, which becomes the base, as the memtable of an LSM tree
+// does.
+//
+// A MutableFST is safe for concurrent use.  Its Iterators see the overlay
+// as it was when they were created, and must not be used after a Flush,
+// which closes the base they read.
+type MutableFST struct {
+	m    sync.RWMutex
+	base *FST
+	opts []OpenOption
+
+	overlay map[string]mutation
+	// keys holds the keys of the overlay, in order unless unsorted is set
+	keys     []string
+	unsorted bool
+}
+
+type mutation struct {
+	val     uint64
+	deleted bool
+}
+
+// NewMutableFST returns a MutableFST with an empty overlay over the base,
+// which may be nil for none.  The MutableFST owns the base, and closes it
+// when it is replaced by Flush, or the MutableFST is closed.  The FSTs
+// written by Flush are opened with the options.
+func NewMutableFST(base *FST, opts ...OpenOption) *MutableFST {
+	return &MutableFST{
+		base:    base,
+		opts:    opts,
+		overlay: make(map[string]mutation),
+	}
+}
+
+// Insert sets the value of the key, replacing any previous one.
+func (m *MutableFST) Insert(key []byte, val uint64) {
+	m.set(key, mutation{val: val})
+}
+
+// Delete removes the key, if it exists.
+func (m *MutableFST) Delete(key []byte) {
+	m.set(key, mutation{deleted: true})
+}
+
+func (m *MutableFST) set(key []byte, mut mutation) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	k := string(key)
+	if _, exists := m.overlay[k]; !exists {
+		if n := len(m.keys); n > 0 && m.keys[n-1] > k {
+			m.unsorted = true
+		}
+		m.keys = append(m.keys, k)
+	}
+	m.overlay[k] = mut
+}
+
+// sortKeys sorts the keys of the overlay, once they are needed in order,
+// rather than keeping them in order as they are inserted
+func (m *MutableFST) sortKeys() {
+	if m.unsorted {
+		sort.Strings(m.keys)
+		m.unsorted = false
+	}
+}
+
+// Pending returns the number of keys inserted or deleted since the last
+// Flush, to decide when to flush.
+func (m *MutableFST) Pending() int {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	return len(m.keys)
+}
+
+// Get returns the value associated with the key, from the overlay if it
+// was inserted or deleted there, or else from the base.
+func (m *MutableFST) Get(key []byte) (uint64, bool, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	if mut, ok := m.overlay[string(key)]; ok {
+		if mut.deleted {
+			return 0, false, nil
+		}
+		return mut.val, true, nil
+	}
+	if m.base == nil {
+		return 0, false, nil
+	}
+	return m.base.Get(key)
+}
+
+// Iterator returns an Iterator over the keys of the MutableFST, with
+// startKeyInclusive <= key < endKeyExclusive, see Search.
+func (m *MutableFST) Iterator(startKeyInclusive,
+	endKeyExclusive []byte) (*MutableIterator, error) {
+	return m.Search(nil, startKeyInclusive, endKeyExclusive)
+}
+
+// Search returns an Iterator over the keys of the MutableFST matching the
+// automaton, with startKeyInclusive <= key < endKeyExclusive, in order.
+// As with FST.Search, ErrIteratorDone is returned if there are no such
+// keys.
+func (m *MutableFST) Search(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) (*MutableIterator, error) {
+	if endKeyExclusive != nil &&
+		bytes.Compare(startKeyInclusive, endKeyExclusive) >= 0 {
+		return nil, ErrIteratorDone
+	}
+	m.m.Lock()
+	base := m.base
+	overlay := m.snapshot(aut, startKeyInclusive, endKeyExclusive)
+	m.m.Unlock()
+
+	rv := &MutableIterator{overlay: overlay}
+	if base != nil {
+		itr, err := base.Search(aut, startKeyInclusive, endKeyExclusive)
+		if err != nil && !errors.Is(err, ErrIteratorDone) {
+			return nil, err
+		}
+		rv.base, rv.baseDone = itr, err != nil
+	} else {
+		rv.baseDone = true
+	}
+	err := rv.settle()
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// snapshot returns the entries of the overlay between the keys matching the
+// automaton, in order
+func (m *MutableFST) snapshot(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) []overlayEntry {
+	m.sortKeys()
+	lo := sort.SearchStrings(m.keys, string(startKeyInclusive))
+	hi := len(m.keys)
+	if endKeyExclusive != nil {
+		hi = sort.SearchStrings(m.keys, string(endKeyExclusive))
+	}
+	var rv []overlayEntry
+	for _, k := range m.keys[lo:hi] {
+		if aut != nil && !automatonMatches(aut, k) {
+			continue
+		}
+		rv = append(rv, overlayEntry{
+			key:      []byte(k),
+			mutation: m.overlay[k],
+		})
+	}
+	return rv
+}
+
+// automatonMatches returns if the automaton matches the key
+func automatonMatches(aut Automaton, key string) bool {
+	s := aut.Start()
+	for i := 0; i < len(key) && aut.CanMatch(s); i++ {
+		if aut.WillAlwaysMatch(s) {
+			return true
+		}
+		s = aut.Accept(s, key[i])
+	}
+	return aut.IsMatch(s)
+}
+
+// Flush writes the keys of the MutableFST to a new FST file at path,
+// atomically, built with the options.  The new FST is opened, and replaces
+// the base, which is closed, and the overlay is cleared.  Other operations
+// wait for Flush to complete.
+func (m *MutableFST) Flush(path string, opts ...BuilderOption) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.sortKeys()
+	err := writeFileAtomic(path, func(f *os.File) error {
+		b, err := New(f, opts...)
+		if err != nil {
+			return err
+		}
+		itr := &MutableIterator{
+			overlay:  m.snapshot(nil, nil, nil),
+			baseDone: true,
+		}
+		if m.base != nil {
+			itr.base, err = m.base.Iterator(nil, nil)
+			if err != nil && !errors.Is(err, ErrIteratorDone) {
+				return err
+			}
+			itr.baseDone = err != nil
+		}
+		for err = itr.settle(); err == nil; err = itr.Next() {
+			key, val := itr.Current()
+			err = b.Insert(key, val)
+			if err != nil {
+				return err
+			}
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			return err
+		}
+		return b.Close()
+	})
+	if err != nil {
+		return err
+	}
+	fst, err := Open(path, m.opts...)
+	if err != nil {
+		return err
+	}
+	old := m.base
+	m.base = fst
+	m.overlay = make(map[string]mutation)
+	m.keys = nil
+	m.unsorted = false
+	return old.Close()
+}
+
+// Close closes the base, the overlay is discarded.
+func (m *MutableFST) Close() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	err := m.base.Close()
+	m.base = nil
+	return err
+}
+
+type overlayEntry struct {
+	key []byte
+	mutation
+}
+
+// MutableIterator iterates over the keys of a MutableFST, merging those of
+// its base with those of its overlay.
+type MutableIterator struct {
+	base     *FSTIterator
+	baseDone bool
+	overlay  []overlayEntry
+	pos      int
+
+	key  []byte
+	val  uint64
+	done bool
+	// the sources of the current key, to advance by Next
+	fromBase, fromOverlay bool
+}
+
+// settle points the iterator to the first key present in either the base
+// or the overlay, and not deleted in the overlay, from their current keys
+func (i *MutableIterator) settle() error {
+	i.done = false
+	for {
+		var baseKey []byte
+		var baseVal uint64
+		if !i.baseDone {
+			baseKey, baseVal = i.base.Current()
+		}
+		i.fromBase, i.fromOverlay = false, false
+		if i.pos < len(i.overlay) {
+			e := i.overlay[i.pos]
+			cmp := -1
+			if !i.baseDone {
+				cmp = bytes.Compare(e.key, baseKey)
+			}
+			if cmp <= 0 {
+				i.fromOverlay, i.fromBase = true, cmp == 0
+				if e.deleted {
+					err := i.advance()
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				i.key, i.val = e.key, e.val
+				return nil
+			}
+		}
+		if i.baseDone {
+			i.key, i.val, i.done = nil, 0, true
+			return ErrIteratorDone
+		}
+		i.fromBase = true
+		i.key, i.val = baseKey, baseVal
+		return nil
+	}
+}
+
+// advance moves past the current key in its sources
+func (i *MutableIterator) advance() error {
+	if i.fromOverlay {
+		i.pos++
+	}
+	if i.fromBase {
+		err := i.base.Next()
+		if err != nil {
+			if !errors.Is(err, ErrIteratorDone) {
+				return err
+			}
+			i.baseDone = true
+		}
+	}
+	return nil
+}
+
+// Current returns the key and value currently pointed to by the iterator.
+func (i *MutableIterator) Current() ([]byte, uint64) {
+	return i.key, i.val
+}
+
+// Next advances the iterator to the next key.  If there is none,
+// ErrIteratorDone is returned.
+func (i *MutableIterator) Next() error {
+	if i.done {
+		return ErrIteratorDone
+	}
+	err := i.advance()
+	if err != nil {
+		return err
+	}
+	return i.settle()
+}
+
+// Seek advances the iterator to the first key greater than or equal to the
+// provided key.  If there is none, ErrIteratorDone is returned.
+func (i *MutableIterator) Seek(key []byte) error {
+	i.pos = sort.Search(len(i.overlay), func(j int) bool {
+		return bytes.Compare(i.overlay[j].key, key) >= 0
+	})
+	if i.base != nil {
+		err := i.base.Seek(key)
+		if err != nil && !errors.Is(err, ErrIteratorDone) {
+			return err
+		}
+		i.baseDone = err != nil
+	}
+	return i.settle()
+}
+
+// Reset returns an error, as the overlay of a MutableIterator isn't an
+// FST, it is only implemented so that a MutableIterator is an Iterator.
+func (i *MutableIterator) Reset(*FST, []byte, []byte, Automaton) error {
+	return fmt.Errorf("mutable iterator can't be reset")
+}
+
+// Close closes the iterator.
+func (i *MutableIterator) Close() error {
+	if i.base != nil {
+		return i.base.Close()
+	}
+	return nil
+}
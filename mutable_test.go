@@ -0,0 +1,179 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// checkMutable compares the keys of the MutableFST with the expected ones,
+// with Get, and searches with and without an automaton and bounds
+func checkMutable(t *testing.T, m *MutableFST, want map[string]uint64) {
+	for k, v := range want {
+		val, exists, err := m.Get([]byte(k))
+		if err != nil || !exists || val != v {
+			t.Fatalf("expected %q %d, got %d %t %v", k, v, val, exists, err)
+		}
+	}
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tests := []struct {
+		aut        Automaton
+		start, end []byte
+	}{
+		{nil, nil, nil},
+		{nil, []byte("c"), []byte("m")},
+		{PrefixAutomaton([]byte("b")), nil, nil},
+		{PrefixAutomaton([]byte("d")), []byte("da"), nil},
+	}
+	for _, test := range tests {
+		var expected []string
+		for _, k := range keys {
+			if k >= string(test.start) &&
+				(test.end == nil || k < string(test.end)) &&
+				(test.aut == nil || automatonMatches(test.aut, k)) {
+				expected = append(expected, k)
+			}
+		}
+		var got []string
+		itr, err := m.Search(test.aut, test.start, test.end)
+		for err == nil {
+			key, val := itr.Current()
+			if val != want[string(key)] {
+				t.Errorf("expected %q %d, got %d", key, want[string(key)], val)
+			}
+			got = append(got, string(key))
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("error iterating: %v", err)
+		}
+		if strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Fatalf("search %q-%q: expected %q, got %q", test.start,
+				test.end, expected, got)
+		}
+	}
+}
+
+func TestMutableFST(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum-mutable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	rng := rand.New(rand.NewSource(42))
+	randomKey := func() string {
+		var sb strings.Builder
+		for n := 1 + rng.Intn(3); n > 0; n-- {
+			sb.WriteByte(byte('a' + rng.Intn(6)))
+		}
+		return sb.String()
+	}
+	want := map[string]uint64{}
+	var kvs []KV
+	for len(want) < 50 {
+		k := randomKey()
+		if _, ok := want[k]; !ok {
+			want[k] = uint64(len(want))
+		}
+	}
+	for k, v := range want {
+		kvs = append(kvs, KV{Key: k, Val: v})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	m := NewMutableFST(buildKVs(t, kvs...))
+	defer func() { _ = m.Close() }()
+	checkMutable(t, m, want)
+
+	path := filepath.Join(dir, "mutable.fst")
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			k := randomKey()
+			if rng.Intn(3) == 0 {
+				m.Delete([]byte(k))
+				delete(want, k)
+				if _, exists, _ := m.Get([]byte(k)); exists {
+					t.Fatalf("expected %q deleted", k)
+				}
+			} else {
+				v := rng.Uint64()
+				m.Insert([]byte(k), v)
+				want[k] = v
+			}
+		}
+		checkMutable(t, m, want)
+		err = m.Flush(path)
+		if err != nil {
+			t.Fatalf("error flushing: %v", err)
+		}
+		if m.Pending() != 0 {
+			t.Errorf("expected nothing pending after flush, got %d", m.Pending())
+		}
+		checkMutable(t, m, want)
+	}
+
+	fst, err := Open(path)
+	if err != nil {
+		t.Fatalf("error opening flushed fst: %v", err)
+	}
+	defer func() { _ = fst.Close() }()
+	if fst.Len() != len(want) {
+		t.Errorf("expected %d keys flushed, got %d", len(want), fst.Len())
+	}
+}
+
+func TestMutableFSTWithoutBase(t *testing.T) {
+	m := NewMutableFST(nil)
+	_, err := m.Iterator(nil, nil)
+	if err != ErrIteratorDone {
+		t.Errorf("expected no keys, got %v", err)
+	}
+	m.Insert([]byte("b"), 2)
+	m.Insert([]byte(""), 0)
+	m.Insert([]byte("a"), 1)
+	m.Delete([]byte("b"))
+	if m.Pending() != 3 {
+		t.Errorf("expected 3 pending, got %d", m.Pending())
+	}
+	checkMutable(t, m, map[string]uint64{"": 0, "a": 1})
+
+	itr, err := m.Iterator(nil, nil)
+	if err != nil {
+		t.Fatalf("error iterating: %v", err)
+	}
+	err = itr.Seek([]byte("a"))
+	key, _ := itr.Current()
+	if err != nil || string(key) != "a" {
+		t.Errorf("expected seek to a, got %q %v", key, err)
+	}
+	err = itr.Seek([]byte("b"))
+	if err != ErrIteratorDone {
+		t.Errorf("expected seek past the keys to be done, got %v", err)
+	}
+	if m.Close() != nil {
+		t.Errorf("expected closing without base to succeed")
+	}
+}
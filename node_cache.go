@@ -0,0 +1,127 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// approximate memory used by a cachedNode and each of its transitions
+const cachedNodeSize = 560
+const cachedTransitionSize = 24
+
+// nodeCache holds fully decoded copies of the states closest to the root
+// of the FST.  Following a transition between two cached states is simple
+// array indexing, instead of decoding the state from the underlying data.
+type nodeCache struct {
+	nodes []cachedNode // nodes[0] is the root
+	size  int
+}
+
+type cachedNode struct {
+	addr        int
+	final       bool
+	finalOutput uint64
+	slot        [256]uint16 // 1 + index into trans, 0 if no transition
+	trans       []cachedTransition
+}
+
+type cachedTransition struct {
+	dest int
+	out  uint64
+	node int32 // index into nodes, -1 if dest is not cached
+}
+
+// newNodeCache decodes the states of the FST level by level (breadth
+// first) starting at the root, stopping before the approximate memory
+// used would exceed the provided budget.
+func newNodeCache(d decoder, budget int) (*nodeCache, error) {
+	rv := &nodeCache{}
+	indexes := map[int]int32{}
+	queue := []int{d.getRoot()}
+	var state fstState
+	var err error
+	for len(queue) > 0 {
+		addr := queue[0]
+		queue = queue[1:]
+		state, err = d.stateAt(addr, state)
+		if err != nil {
+			return nil, err
+		}
+		numTrans := state.NumTransitions()
+		nodeSize := cachedNodeSize + numTrans*cachedTransitionSize
+		if rv.size+nodeSize > budget {
+			break
+		}
+		rv.size += nodeSize
+		indexes[addr] = int32(len(rv.nodes))
+		rv.nodes = append(rv.nodes, cachedNode{
+			addr:        addr,
+			final:       state.Final(),
+			finalOutput: state.FinalOutput(),
+			trans:       make([]cachedTransition, numTrans),
+		})
+		node := &rv.nodes[len(rv.nodes)-1]
+		for i := 0; i < numTrans; i++ {
+			label := state.TransitionAt(i)
+			_, dest, out := state.TransitionFor(label)
+			node.slot[label] = uint16(i + 1)
+			node.trans[i] = cachedTransition{
+				dest: dest,
+				out:  out,
+				node: -1,
+			}
+			if _, seen := indexes[dest]; !seen {
+				// placeholder, replaced if dest fits in the budget
+				indexes[dest] = -1
+				queue = append(queue, dest)
+			}
+		}
+	}
+	if len(rv.nodes) == 0 {
+		return nil, nil
+	}
+	// now that we know which states made it, link the transitions
+	for i := range rv.nodes {
+		for j := range rv.nodes[i].trans {
+			if idx, ok := indexes[rv.nodes[i].trans[j].dest]; ok {
+				rv.nodes[i].trans[j].node = idx
+			}
+		}
+	}
+	return rv, nil
+}
+
+// get follows the input through the cached states.  If the input is fully
+// consumed, done is true and the lookup result is returned.  Otherwise, the
+// number of bytes consumed, the address of the first uncached state reached
+// and the output accumulated so far are returned.
+func (c *nodeCache) get(input []byte) (done bool, val uint64, exists bool,
+	consumed int, addr int, total uint64) {
+	n := &c.nodes[0]
+	for consumed < len(input) {
+		slot := n.slot[input[consumed]]
+		if slot == 0 {
+			return true, 0, false, consumed, noneAddr, 0
+		}
+		t := &n.trans[slot-1]
+		total += t.out
+		consumed++
+		if t.node < 0 {
+			return false, 0, false, consumed, t.dest, total
+		}
+		n = &c.nodes[t.node]
+	}
+	if n.final {
+		return true, total + n.finalOutput, true, consumed, n.addr, total
+	}
+	return true, 0, false, consumed, n.addr, total
+}
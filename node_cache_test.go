@@ -0,0 +1,152 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestNodeCache(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+
+	vals := randomValues(thousandTestWords)
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting thousand words: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+
+	probes := append([]string{"", "a", "zzz", "thex"}, thousandTestWords...)
+	for _, budget := range []int{0, 100, 1000, 10000, 100000, 1 << 30} {
+		fst, err := Load(buf.Bytes(), WithNodeCache(budget))
+		if err != nil {
+			t.Fatalf("error loading set: %v", err)
+		}
+		if budget < cachedNodeSize && fst.cache != nil {
+			t.Errorf("expected no cache with budget %d", budget)
+		}
+		if budget >= 10000 && fst.cache == nil {
+			t.Errorf("expected cache with budget %d", budget)
+		}
+		if fst.cache != nil && fst.cache.size > budget {
+			t.Errorf("expected cache size %d within budget %d",
+				fst.cache.size, budget)
+		}
+
+		for i, word := range thousandTestWords {
+			val, ok, err := fst.Get([]byte(word))
+			if err != nil {
+				t.Fatalf("error getting %s: %v", word, err)
+			}
+			if !ok || val != vals[i] {
+				t.Errorf("budget %d: expected %s to have value %d, got %d (%t)",
+					budget, word, vals[i], val, ok)
+			}
+		}
+
+		// compare against the uncached results, including prefixes and
+		// extensions of real keys
+		uncached, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading set: %v", err)
+		}
+		for _, probe := range probes {
+			for _, key := range []string{probe, probe + "s", probe[:len(probe)/2]} {
+				wantV, wantOk, _ := uncached.Get([]byte(key))
+				gotV, gotOk, err := fst.Get([]byte(key))
+				if err != nil {
+					t.Fatalf("error getting %s: %v", key, err)
+				}
+				if gotV != wantV || gotOk != wantOk {
+					t.Errorf("budget %d: key %q expected %d (%t), got %d (%t)",
+						budget, key, wantV, wantOk, gotV, gotOk)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkGetNodeCache(b *testing.B) {
+	var buf bytes.Buffer
+	builder, err := New(&buf, nil)
+	if err != nil {
+		b.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(builder, thousandTestWords, randomValues(thousandTestWords))
+	if err != nil {
+		b.Fatalf("error inserting thousand words: %v", err)
+	}
+	err = builder.Close()
+	if err != nil {
+		b.Fatalf("error closing builder: %v", err)
+	}
+
+	for _, budget := range []int{0, 64 << 10} {
+		fst, err := Load(buf.Bytes(), WithNodeCache(budget))
+		if err != nil {
+			b.Fatalf("error loading set: %v", err)
+		}
+		r, err := fst.Reader()
+		if err != nil {
+			b.Fatalf("error getting reader: %v", err)
+		}
+		b.Run(fmt.Sprintf("budget-%d", budget), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, _ = r.Get([]byte(thousandTestWords[i%len(thousandTestWords)]))
+			}
+		})
+	}
+}
+
+func TestNodeCacheAllLabels(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	for i := 0; i < 256; i++ {
+		err = b.Insert([]byte{byte(i)}, uint64(i+1))
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes(), WithNodeCache(1<<20))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if fst.cache == nil {
+		t.Fatalf("expected a cache")
+	}
+	for i := 0; i < 256; i++ {
+		val, exists, err := fst.Get([]byte{byte(i)})
+		if err != nil || !exists || val != uint64(i+1) {
+			t.Errorf("expected %d for %#x, got %d %t %v", i+1, i, val, exists,
+				err)
+		}
+	}
+}
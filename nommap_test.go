@@ -0,0 +1,44 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestNommapImports verifies that building with the nommap tag, vellum and
+// the packages it depends on don't use unsafe, syscall, or mmap.
+func TestNommapImports(t *testing.T) {
+	ctx := build.Default
+	ctx.BuildTags = append(ctx.BuildTags, "nommap")
+	dirs := []string{".", "levenshtein", "levenshtein2", "regexp", "sparse",
+		"utf8", filepath.Join("vendor", "github.com", "willf", "bitset")}
+	for _, dir := range dirs {
+		pkg, err := ctx.ImportDir(dir, 0)
+		if err != nil {
+			t.Fatalf("error importing %s: %v", dir, err)
+		}
+		for _, imp := range pkg.Imports {
+			if imp == "unsafe" || imp == "C" || strings.HasPrefix(imp, "syscall") ||
+				strings.HasPrefix(imp, "golang.org/x/sys") ||
+				strings.Contains(imp, "mmap") {
+				t.Errorf("%s imports %s with the nommap tag", dir, imp)
+			}
+		}
+	}
+}
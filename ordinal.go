@@ -0,0 +1,258 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SeekOrdinal positions the iterator at the n-th (counting from 0) key it
+// would return, that is the n-th key matching the automaton, starting at
+// startKeyInclusive.  ErrIteratorDone is returned if there are no more than
+// n such keys, or if the n-th key is beyond the end key (see
+// WithEndBoundError).
+//
+// If the FST was built with subtree counts (see BuilderOpts.SubtreeCounts)
+// entire subtrees known to match are skipped, otherwise all keys before the
+// n-th are visited, which is no faster than calling Next n times.
+func (i *FSTIterator) SeekOrdinal(n int) error {
+	if err := i.f.enter(); err != nil {
+		return err
+	}
+	defer i.f.exit()
+	if n < 0 {
+		return fmt.Errorf("invalid ordinal %d", n)
+	}
+	s := ordinalSeeker{
+		f:         i.f,
+		aut:       i.aut,
+		start:     i.startKeyInclusive,
+		remaining: uint64(n),
+	}
+	root, err := i.f.decoder.stateAt(i.f.decoder.getRoot(), nil)
+	if err != nil {
+		return err
+	}
+	found, err := s.seek(root, i.aut.Start(), len(s.start) > 0)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrIteratorDone
+	}
+	if i.endKeyExclusive != nil &&
+		bytes.Compare(s.key, i.endKeyExclusive) >= 0 {
+		return i.f.errEndBound()
+	}
+	return i.pointTo(s.key)
+}
+
+type ordinalSeeker struct {
+	f         *FST
+	aut       Automaton
+	start     []byte
+	remaining uint64
+	key       []byte
+}
+
+// seek visits the keys of the subtree in order, until remaining keys have
+// been skipped, leaving the key found in s.key.  While tight, s.key is a
+// prefix of the start key, and keys before it are not counted.
+func (s *ordinalSeeker) seek(curr fstState, autCurr int, tight bool) (bool, error) {
+	depth := len(s.key)
+	if tight && depth == len(s.start) {
+		tight = false
+	}
+	if !tight && curr.Final() && s.aut.IsMatch(autCurr) {
+		if s.remaining == 0 {
+			return true, nil
+		}
+		s.remaining--
+	}
+
+	numTrans := curr.NumTransitions()
+	for j := 0; j < numTrans; j++ {
+		t := curr.TransitionAt(j)
+		if tight && t < s.start[depth] {
+			continue
+		}
+		childTight := tight && t == s.start[depth]
+		autNext := s.aut.Accept(autCurr, t)
+		if !s.aut.CanMatch(autNext) {
+			continue
+		}
+		_, nextAddr, _ := curr.TransitionFor(t)
+		if !childTight && s.f.counts != nil && s.aut.WillAlwaysMatch(autNext) {
+			if c, ok := s.f.counts.get(nextAddr); ok && c <= s.remaining {
+				s.remaining -= c
+				continue
+			}
+		}
+		next, err := s.f.decoder.stateAt(nextAddr, nil)
+		if err != nil {
+			return false, err
+		}
+		s.key = append(s.key, t)
+		found, err := s.seek(next, autNext, childTight)
+		if found || err != nil {
+			return found, err
+		}
+		s.key = s.key[:depth]
+	}
+	return false, nil
+}
+
+// GetByOrdinal returns the n-th (counting from 0) key of the FST, in order,
+// and its value.  If the FST has no more than n keys, it returns false.
+//
+// If the FST was built with subtree counts (see BuilderOpts.SubtreeCounts)
+// only the states along the key are visited, otherwise all the keys
+// before it are.
+func (f *FST) GetByOrdinal(n uint64) ([]byte, uint64, bool, error) {
+	if err := f.enter(); err != nil {
+		return nil, 0, false, err
+	}
+	defer f.exit()
+	if n >= uint64(f.len) {
+		return nil, 0, false, nil
+	}
+	var key []byte
+	var total uint64
+	curr, err := f.decoder.stateAt(f.decoder.getRoot(), nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	for {
+		if curr.Final() {
+			if n == 0 {
+				val, err := f.value(total + curr.FinalOutput())
+				if err != nil {
+					return nil, 0, false, err
+				}
+				return key, val, true, nil
+			}
+			n--
+		}
+		next := noneAddr
+		for j := 0; j < curr.NumTransitions(); j++ {
+			t := curr.TransitionAt(j)
+			_, addr, out := curr.TransitionFor(t)
+			c, err := f.subtreeKeys(addr)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			if n < c {
+				key = append(key, t)
+				total += out
+				next = addr
+				break
+			}
+			n -= c
+		}
+		if next == noneAddr {
+			return nil, 0, false, corruptf(curr.Address(),
+				"fewer keys than the length of the fst")
+		}
+		curr, err = f.decoder.stateAt(next, curr)
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+}
+
+// Ordinal returns the number of keys of the FST before the key, in order,
+// which is its ordinal, counting from 0, and whether the key exists.  If
+// it doesn't, the ordinal is that it would have if it were inserted.  As
+// with GetByOrdinal, it is faster with subtree counts.
+func (f *FST) Ordinal(key []byte) (uint64, bool, error) {
+	if err := f.enter(); err != nil {
+		return 0, false, err
+	}
+	defer f.exit()
+	rv, err := f.keysBefore(key)
+	if err != nil {
+		return 0, false, err
+	}
+	_, exists, err := f.traverse(key, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	return rv, exists, nil
+}
+
+// keysBefore returns the number of keys of the FST before the key, which
+// is the ordinal of the key if it is in the FST.  The subtrees wholly
+// before the key are counted with their subtree counts when the FST has
+// them, otherwise by visiting them.
+func (f *FST) keysBefore(key []byte) (uint64, error) {
+	var rv uint64
+	curr, err := f.decoder.stateAt(f.decoder.getRoot(), nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range key {
+		if curr.Final() {
+			rv++
+		}
+		for j := 0; j < curr.NumTransitions(); j++ {
+			t := curr.TransitionAt(j)
+			if t >= b {
+				break
+			}
+			_, addr, _ := curr.TransitionFor(t)
+			n, err := f.subtreeKeys(addr)
+			if err != nil {
+				return 0, err
+			}
+			rv += n
+		}
+		_, addr, _ := curr.TransitionFor(b)
+		if addr == noneAddr {
+			break
+		}
+		curr, err = f.decoder.stateAt(addr, nil)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return rv, nil
+}
+
+// subtreeKeys returns the number of keys reachable from the state at addr
+func (f *FST) subtreeKeys(addr int) (uint64, error) {
+	if f.counts != nil {
+		if n, ok := f.counts.get(addr); ok {
+			return n, nil
+		}
+	}
+	curr, err := f.decoder.stateAt(addr, nil)
+	if err != nil {
+		return 0, err
+	}
+	var rv uint64
+	if curr.Final() {
+		rv++
+	}
+	for j := 0; j < curr.NumTransitions(); j++ {
+		_, next, _ := curr.TransitionFor(curr.TransitionAt(j))
+		n, err := f.subtreeKeys(next)
+		if err != nil {
+			return 0, err
+		}
+		rv += n
+	}
+	return rv, nil
+}
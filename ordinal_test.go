@@ -0,0 +1,280 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/couchbase/vellum/regexp"
+)
+
+func buildWordsWithCounts(t *testing.T) []byte {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithSubtreeCounts())
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, randomValues(thousandTestWords))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSubtreeCounts(t *testing.T) {
+	fst, err := Load(buildWordsWithCounts(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if fst.counts == nil {
+		t.Fatalf("expected subtree counts")
+	}
+	count, ok := fst.counts.get(fst.decoder.getRoot())
+	if !ok || count != uint64(len(thousandTestWords)) {
+		t.Errorf("expected root count %d, got %d %t",
+			len(thousandTestWords), count, ok)
+	}
+
+	plain, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if plain.counts != nil {
+		t.Errorf("expected no subtree counts")
+	}
+	if plain.Len() != fst.Len() {
+		t.Errorf("expected same length, got %d and %d", plain.Len(), fst.Len())
+	}
+}
+
+func iterateKeys(t *testing.T, itr *FSTIterator, err error) []string {
+	var rv []string
+	for err == nil {
+		key, _ := itr.Current()
+		rv = append(rv, string(key))
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Fatalf("error iterating: %v", err)
+	}
+	return rv
+}
+
+func TestSeekOrdinal(t *testing.T) {
+	withCounts, err := Load(buildWordsWithCounts(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	withoutCounts, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	r, err := regexp.New(`[a-m].*s`)
+	if err != nil {
+		t.Fatalf("error compiling regexp: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		aut        Automaton
+		start, end []byte
+	}{
+		{name: "all"},
+		{name: "start", start: []byte("m")},
+		{name: "start key", start: []byte(thousandTestWords[100])},
+		{name: "range", start: []byte("b"), end: []byte("k")},
+		{name: "regexp", aut: r},
+		{name: "regexp range", aut: r, start: []byte("c"), end: []byte("h")},
+	}
+	for _, fst := range []*FST{withCounts, withoutCounts} {
+		for _, test := range tests {
+			itr, err := fst.Search(test.aut, test.start, test.end)
+			want := iterateKeys(t, itr, err)
+			if len(want) == 0 {
+				t.Fatalf("%s: expected some keys", test.name)
+			}
+
+			itr, err = fst.Search(test.aut, test.start, test.end)
+			if err != nil {
+				t.Fatalf("%s: error searching: %v", test.name, err)
+			}
+			for n, wantKey := range want {
+				err = itr.SeekOrdinal(n)
+				if err != nil {
+					t.Fatalf("%s: error seeking %d: %v", test.name, n, err)
+				}
+				key, _ := itr.Current()
+				if string(key) != wantKey {
+					t.Fatalf("%s: expected key %d %q, got %q",
+						test.name, n, wantKey, key)
+				}
+			}
+
+			// continuing with Next after a seek
+			err = itr.SeekOrdinal(len(want) / 2)
+			if err != nil {
+				t.Fatalf("%s: error seeking: %v", test.name, err)
+			}
+			got := iterateKeys(t, itr, err)
+			if len(got) != len(want)-len(want)/2 {
+				t.Errorf("%s: expected %d keys after seek, got %d",
+					test.name, len(want)-len(want)/2, len(got))
+			}
+
+			err = itr.SeekOrdinal(len(want))
+			if !errors.Is(err, ErrIteratorDone) {
+				t.Errorf("%s: expected ErrIteratorDone seeking past end, got %v",
+					test.name, err)
+			}
+			if test.end != nil && err != ErrIteratorDone {
+				t.Errorf("%s: expected ErrIteratorDone, got %v",
+					test.name, err)
+			}
+		}
+	}
+}
+
+func TestSeekOrdinalInvalid(t *testing.T) {
+	fst, err := Load(buildWordsWithCounts(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	itr, err := fst.Iterator(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	err = itr.SeekOrdinal(-1)
+	if err == nil {
+		t.Errorf("expected error seeking negative ordinal")
+	}
+}
+
+func TestGetByOrdinal(t *testing.T) {
+	withCounts, err := Load(buildWordsWithCounts(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	withoutCounts, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	for _, fst := range []*FST{withCounts, withoutCounts} {
+		itr, err := fst.Iterator(nil, nil)
+		for n := uint64(0); err == nil; n++ {
+			wantKey, wantVal := itr.Current()
+			key, val, ok, gerr := fst.GetByOrdinal(n)
+			if gerr != nil || !ok || string(key) != string(wantKey) ||
+				val != wantVal {
+				t.Fatalf("expected key %d %q %d, got %q %d %t %v", n, wantKey,
+					wantVal, key, val, ok, gerr)
+			}
+			ord, exists, oerr := fst.Ordinal(wantKey)
+			if oerr != nil || !exists || ord != n {
+				t.Fatalf("expected ordinal %d of %q, got %d %t %v", n, wantKey,
+					ord, exists, oerr)
+			}
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("error iterating: %v", err)
+		}
+
+		_, _, ok, err := fst.GetByOrdinal(uint64(fst.Len()))
+		if ok || err != nil {
+			t.Errorf("expected no key past the end, got %t %v", ok, err)
+		}
+	}
+
+	fst := buildKVs(t, KV{"", 1}, KV{"b", 2}, KV{"bb", 3}, KV{"d", 4})
+	tests := []struct {
+		key    string
+		ord    uint64
+		exists bool
+	}{
+		{"", 0, true},
+		{"a", 1, false},
+		{"b", 1, true},
+		{"ba", 2, false},
+		{"bb", 2, true},
+		{"bbb", 3, false},
+		{"c", 3, false},
+		{"d", 3, true},
+		{"e", 4, false},
+	}
+	for _, test := range tests {
+		ord, exists, err := fst.Ordinal([]byte(test.key))
+		if err != nil || ord != test.ord || exists != test.exists {
+			t.Errorf("%q: expected %d %t, got %d %t %v", test.key, test.ord,
+				test.exists, ord, exists, err)
+		}
+	}
+
+	empty := buildKVs(t)
+	if _, _, ok, err := empty.GetByOrdinal(0); ok || err != nil {
+		t.Errorf("expected no key in empty fst, got %t %v", ok, err)
+	}
+	if ord, exists, err := empty.Ordinal(nil); ord != 0 || exists || err != nil {
+		t.Errorf("expected no ordinal in empty fst, got %d %t %v", ord, exists,
+			err)
+	}
+}
+
+func TestLoadCorruptSections(t *testing.T) {
+	data := buildWordsWithCounts(t)
+	tableCount := len(data) - footerSizeV1 - 8
+	tableStart := tableCount - sectionEntrySize
+
+	corruptions := map[string]func([]byte){
+		"count": func(d []byte) {
+			binary.LittleEndian.PutUint64(d[tableCount:], 1000)
+		},
+		"offset": func(d []byte) {
+			binary.LittleEndian.PutUint64(d[tableStart+8:], uint64(len(d)))
+		},
+		"length": func(d []byte) {
+			binary.LittleEndian.PutUint64(d[tableStart+16:], uint64(len(d)))
+		},
+		"data": func(d []byte) {
+			entry := getSectionEntry(d[tableStart:])
+			d[entry.offset] = 0
+		},
+	}
+	for name, corrupt := range corruptions {
+		mutated := append([]byte(nil), data...)
+		corrupt(mutated)
+		_, err := Load(mutated)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected ErrCorrupt, got %v", name, err)
+		}
+	}
+
+	// unknown sections are ignored
+	mutated := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(mutated[tableStart:], 99)
+	fst, err := Load(mutated)
+	if err != nil {
+		t.Fatalf("expected unknown section to be ignored, got %v", err)
+	}
+	if fst.counts != nil {
+		t.Errorf("expected no subtree counts")
+	}
+}
@@ -0,0 +1,244 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrRangeUnavailable is matched (using errors.Is) by the
+// RangeUnavailableErrors returned by FSTs opened WithPartialData.
+var ErrRangeUnavailable = errors.New("fst data range unavailable")
+
+// RangeUnavailableError is returned by the operations of an FST opened
+// WithPartialData which need data that isn't available yet.  Once the
+// ranges are fetched and added to the RangeSet, the operation can be
+// retried.
+type RangeUnavailableError struct {
+	Ranges []ByteRange
+}
+
+func (e *RangeUnavailableError) Error() string {
+	ranges := make([]string, len(e.Ranges))
+	for i, r := range e.Ranges {
+		ranges[i] = r.String()
+	}
+	return fmt.Sprintf("fst data range unavailable: %s",
+		strings.Join(ranges, ", "))
+}
+
+// Is allows errors.Is(err, ErrRangeUnavailable) to match any
+// RangeUnavailableError.
+func (e *RangeUnavailableError) Is(target error) bool {
+	return target == ErrRangeUnavailable
+}
+
+// ByteRange is the range of bytes from Start (inclusive) to End
+// (exclusive) of the data of an FST.
+type ByteRange struct {
+	Start, End int
+}
+
+func (r ByteRange) String() string {
+	return fmt.Sprintf("[%d,%d)", r.Start, r.End)
+}
+
+// RangeSet is the set of the ranges of the data of an FST which are
+// available.  It is safe for concurrent use, so that ranges can be added
+// as they are fetched, while the FST is in use.
+type RangeSet struct {
+	m sync.RWMutex
+	// ranges are disjoint, not adjacent, and in order
+	ranges []ByteRange
+}
+
+// NewRangeSet returns a RangeSet with the ranges available.
+func NewRangeSet(ranges ...ByteRange) *RangeSet {
+	rv := &RangeSet{}
+	for _, r := range ranges {
+		rv.Add(r)
+	}
+	return rv
+}
+
+// Add marks the range as available.  Its data must be in place before it
+// is added.
+func (s *RangeSet) Add(r ByteRange) {
+	if r.Start >= r.End {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	// the ranges overlapping or adjacent to r are merged into it
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].End >= r.Start
+	})
+	j := i
+	for j < len(s.ranges) && s.ranges[j].Start <= r.End {
+		if s.ranges[j].Start < r.Start {
+			r.Start = s.ranges[j].Start
+		}
+		if s.ranges[j].End > r.End {
+			r.End = s.ranges[j].End
+		}
+		j++
+	}
+	s.ranges = append(s.ranges[:i], append([]ByteRange{r},
+		s.ranges[j:]...)...)
+}
+
+// Ranges returns the ranges available, in order.
+func (s *RangeSet) Ranges() []ByteRange {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return append([]ByteRange(nil), s.ranges...)
+}
+
+// missing appends the parts of the range which aren't available to rv
+func (s *RangeSet) missing(rv []ByteRange, start, end int) []ByteRange {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].End > start
+	})
+	for ; start < end && i < len(s.ranges); i++ {
+		if s.ranges[i].Start >= end {
+			break
+		}
+		if s.ranges[i].Start > start {
+			rv = append(rv, ByteRange{start, s.ranges[i].Start})
+		}
+		start = s.ranges[i].End
+	}
+	if start < end {
+		rv = append(rv, ByteRange{start, end})
+	}
+	return rv
+}
+
+// check returns a RangeUnavailableError if part of the range isn't
+// available
+func (s *RangeSet) check(start, end int) error {
+	if missing := s.missing(nil, start, end); len(missing) > 0 {
+		return &RangeUnavailableError{Ranges: missing}
+	}
+	return nil
+}
+
+// WithPartialData opens an FST of which only the ranges of the data in
+// available are present, such as a file being fetched piecemeal from a
+// remote store.  The data must have its full length, with the missing
+// ranges to be filled in.  The header, the footer and the optional
+// sections must be available to open the FST, and the operations needing
+// states which aren't return a RangeUnavailableError listing the ranges
+// to fetch.
+//
+// Ranges fetched later must be written into the data before they are
+// added to the RangeSet, into the byte slice passed to Load, or into the
+// file passed to Open where it is memory mapped.  Checksums aren't
+// verified, compressed FSTs aren't supported, nor is WithMutationCheck.
+func WithPartialData(available *RangeSet) OpenOption {
+	return func(o *openOpts) {
+		o.partial = available
+	}
+}
+
+// maxStateSize bounds the size of the encoding of a state: a multiple
+// transition state has up to 3 bytes of header, and for each of the 256
+// transitions, a label, and an address and output of up to 8 bytes, and a
+// final output
+const maxStateSize = 3 + 256*(1+8+8) + 8
+
+// partialDecoder checks that the data of the states decoded is available
+type partialDecoder struct {
+	decoder
+	data      []byte
+	available *RangeSet
+}
+
+// validate checks that the footer and the sections are available, before
+// the decoder reads them
+func (d *partialDecoder) validate() error {
+	n := len(d.data)
+	if n < headerSize+footerSizeV1 {
+		return d.decoder.validate()
+	}
+	end := n - footerSizeV1
+	missing := d.available.missing(nil, end, n)
+	typ := binary.LittleEndian.Uint64(d.data[8:headerSize])
+	if typ&typeSections != 0 && len(missing) == 0 {
+		missing = d.available.missing(missing, end-8, end)
+		count := binary.LittleEndian.Uint64(d.data[end-8:])
+		if len(missing) == 0 &&
+			count <= uint64(end-8-headerSize)/sectionEntrySize {
+			tableStart := end - 8 - int(count)*sectionEntrySize
+			missing = d.available.missing(missing, tableStart, end-8)
+			for i := 0; len(missing) == 0 && i < int(count); i++ {
+				s := getSectionEntry(d.data[tableStart+i*sectionEntrySize:])
+				if s.offset <= uint64(tableStart) &&
+					s.length <= uint64(tableStart)-s.offset {
+					missing = d.available.missing(missing, int(s.offset),
+						int(s.offset+s.length))
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return &RangeUnavailableError{Ranges: missing}
+	}
+	// the decoder reads the root state directly
+	root := d.getRoot()
+	if root != emptyAddr && root != noneAddr && root >= headerSize &&
+		root < end {
+		_, err := d.stateAt(root, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return d.decoder.validate()
+}
+
+func (d *partialDecoder) stateAt(addr int, prealloc fstState) (fstState,
+	error) {
+	state, err := d.decoder.stateAt(addr, prealloc)
+	if addr == emptyAddr || addr == noneAddr {
+		return state, err
+	}
+	if err != nil {
+		// the state may have been decoded from missing data, which is
+		// reported instead, if any of the bytes it can span is missing
+		start := addr + 1 - maxStateSize
+		if start < headerSize {
+			start = headerSize
+		}
+		if addr < len(d.data) {
+			if uerr := d.available.check(start, addr+1); uerr != nil {
+				return nil, uerr
+			}
+		}
+		return nil, err
+	}
+	s := state.(*fstStateV1)
+	err = d.available.check(s.bottom, s.top+1)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
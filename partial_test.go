@@ -0,0 +1,146 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRangeSet(t *testing.T) {
+	s := NewRangeSet(ByteRange{10, 20}, ByteRange{30, 40}, ByteRange{5, 5})
+	tests := []struct {
+		start, end int
+		want       string
+	}{
+		{10, 20, "[]"},
+		{12, 15, "[]"},
+		{0, 10, "[[0,10)]"},
+		{15, 35, "[[20,30)]"},
+		{0, 50, "[[0,10) [20,30) [40,50)]"},
+		{40, 41, "[[40,41)]"},
+	}
+	for _, test := range tests {
+		got := fmt.Sprint(s.missing(nil, test.start, test.end))
+		if got != test.want {
+			t.Errorf("missing %d-%d: expected %s, got %s", test.start,
+				test.end, test.want, got)
+		}
+	}
+
+	s.Add(ByteRange{20, 25})
+	s.Add(ByteRange{0, 3})
+	s.Add(ByteRange{24, 30})
+	want := []ByteRange{{0, 3}, {10, 40}}
+	if got := s.Ranges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ranges %v, got %v", want, got)
+	}
+	s.Add(ByteRange{2, 50})
+	want = []ByteRange{{0, 50}}
+	if got := s.Ranges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ranges %v, got %v", want, got)
+	}
+}
+
+func TestPartialData(t *testing.T) {
+	full := buildWordsWithCounts(t)
+	fst, err := Load(full)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	statesEnd := headerSize + fst.Sizes().States
+	values := map[string]uint64{}
+	itr, err := fst.Iterator(nil, nil)
+	for err == nil {
+		key, val := itr.Current()
+		values[string(key)] = val
+		err = itr.Next()
+	}
+
+	// without the footer, it can't be opened
+	data := make([]byte, len(full))
+	available := NewRangeSet()
+	fetch := func(ranges []ByteRange) {
+		for _, r := range ranges {
+			copy(data[r.Start:r.End], full[r.Start:r.End])
+			available.Add(r)
+		}
+	}
+	fetch([]ByteRange{{0, headerSize}})
+	_, err = Load(data, WithPartialData(available))
+	var rerr *RangeUnavailableError
+	if !errors.As(err, &rerr) || !errors.Is(err, ErrRangeUnavailable) {
+		t.Fatalf("expected range unavailable, got %v", err)
+	}
+	want := []ByteRange{{len(full) - footerSizeV1, len(full)}}
+	if !reflect.DeepEqual(rerr.Ranges, want) {
+		t.Errorf("expected the footer %v to be needed, got %v", want,
+			rerr.Ranges)
+	}
+
+	// with the sections, it can be opened once the root is fetched
+	fetch([]ByteRange{{statesEnd, len(full)}})
+	partial, err := Load(data, WithPartialData(available))
+	for errors.As(err, &rerr) {
+		fetch(rerr.Ranges)
+		partial, err = Load(data, WithPartialData(available))
+	}
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	// each lookup fetches what it needs
+	key := thousandTestWords[500]
+	val, exists, err := partial.Get([]byte(key))
+	for errors.As(err, &rerr) {
+		fetch(rerr.Ranges)
+		val, exists, err = partial.Get([]byte(key))
+	}
+	if err != nil || !exists || val != values[key] {
+		t.Fatalf("expected %q %d, got %d %t %v", key, values[key], val,
+			exists, err)
+	}
+	var fetched int
+	for _, r := range available.Ranges() {
+		fetched += r.End - r.Start
+	}
+	if fetched >= len(full) {
+		t.Errorf("expected a lookup to fetch part of the data, got %d/%d",
+			fetched, len(full))
+	}
+
+	for key, want := range values {
+		val, exists, err := partial.Get([]byte(key))
+		for errors.As(err, &rerr) {
+			fetch(rerr.Ranges)
+			val, exists, err = partial.Get([]byte(key))
+		}
+		if err != nil || !exists || val != want {
+			t.Fatalf("expected %q %d, got %d %t %v", key, want, val, exists,
+				err)
+		}
+	}
+	itr, err = partial.Iterator(nil, nil)
+	n := 0
+	for err == nil {
+		n++
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) || n != len(values) {
+		t.Errorf("expected to iterate %d keys, got %d %v", len(values), n, err)
+	}
+}
@@ -0,0 +1,229 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrNoPayloads is returned when reading the payload of a key from an FST
+// not built with a BytesBuilder.
+var ErrNoPayloads = errors.New("fst has no payloads")
+
+// Payloads are byte slices associated with the keys of an FST built with a
+// BytesBuilder.  Each distinct payload is stored once, in the order they
+// were first inserted, in an optional section, and the outputs of the FST
+// are indexes into it, accumulated along the paths of the keys as any
+// other outputs are.  The section is laid out as:
+//
+//	8 bytes number of payloads
+//	1 byte offset size
+//	for each payload: end offset, packed in the offset size
+//	payload bytes
+//
+// The typePayloads bit is set in the header type, as the outputs are
+// indexes rather than values.
+
+// payloadInterner assigns indexes to the payloads while building
+type payloadInterner struct {
+	indexes map[string]uint64
+	arena   []byte
+	ends    []uint64
+}
+
+func newPayloadInterner() *payloadInterner {
+	return &payloadInterner{
+		indexes: make(map[string]uint64),
+	}
+}
+
+func (p *payloadInterner) reset() {
+	p.indexes = make(map[string]uint64)
+	p.arena = p.arena[:0]
+	p.ends = p.ends[:0]
+}
+
+// intern returns the index of the payload
+func (p *payloadInterner) intern(payload []byte) uint64 {
+	if i, ok := p.indexes[string(payload)]; ok {
+		return i
+	}
+	i := uint64(len(p.ends))
+	p.indexes[string(payload)] = i
+	p.arena = append(p.arena, payload...)
+	p.ends = append(p.ends, uint64(len(p.arena)))
+	return i
+}
+
+func (p *payloadInterner) encode() []byte {
+	offsetSize := packedSize(uint64(len(p.arena)))
+	rv := make([]byte, 9+len(p.ends)*offsetSize, 9+len(p.ends)*offsetSize+
+		len(p.arena))
+	binary.LittleEndian.PutUint64(rv, uint64(len(p.ends)))
+	rv[8] = byte(offsetSize)
+	for i, end := range p.ends {
+		putPackedUint(rv[9+i*offsetSize:9+(i+1)*offsetSize], end)
+	}
+	return append(rv, p.arena...)
+}
+
+// payloadTable reads the payloads from the section data
+type payloadTable struct {
+	ends       []byte
+	offsetSize int
+	n          int
+	arena      []byte
+}
+
+func loadPayloadTable(data []byte) (*payloadTable, error) {
+	if len(data) < 9 || data[8] < 1 || data[8] > 8 {
+		return nil, corruptf(0, "invalid payload section")
+	}
+	n := binary.LittleEndian.Uint64(data)
+	offsetSize := int(data[8])
+	if n > uint64(len(data)-9)/uint64(offsetSize) {
+		return nil, corruptf(0, "invalid payload count %d", n)
+	}
+	endsSize := int(n) * offsetSize
+	rv := &payloadTable{
+		ends:       data[9 : 9+endsSize],
+		offsetSize: offsetSize,
+		n:          int(n),
+		arena:      data[9+endsSize:],
+	}
+	if n > 0 && rv.end(rv.n-1) != uint64(len(rv.arena)) {
+		return nil, corruptf(0, "invalid payload section length %d",
+			len(data))
+	}
+	return rv, nil
+}
+
+func (t *payloadTable) end(i int) uint64 {
+	return readPackedUint(t.ends[i*t.offsetSize : (i+1)*t.offsetSize])
+}
+
+// get returns the payload at index i, if there is one
+func (t *payloadTable) get(i uint64) ([]byte, bool) {
+	if i >= uint64(t.n) {
+		return nil, false
+	}
+	var start uint64
+	if i > 0 {
+		start = t.end(int(i) - 1)
+	}
+	end := t.end(int(i))
+	if start > end || end > uint64(len(t.arena)) {
+		return nil, false
+	}
+	return t.arena[start:end], true
+}
+
+// BytesBuilder builds an FST associating a byte slice payload with each
+// key, rather than a uint64 value.  Keys must be inserted in
+// lexicographical order, as with a Builder.
+//
+// The payloads are stored in the FST file, each distinct payload once, so
+// keys sharing a payload share its bytes.  Read them with FST.GetBytes, or
+// FST.Payload for the values returned by Iterators, which are the indexes
+// of the payloads.  Merging such FSTs with a MergeFunc operating on those
+// indexes is meaningless.
+type BytesBuilder struct {
+	b *Builder
+}
+
+// NewBytesBuilder returns a new BytesBuilder which will stream out the
+// underlying representation to the provided Writer as the set is built.
+// BuilderOpts.InternValues is not supported, the payloads are always
+// interned.
+func NewBytesBuilder(w io.Writer, opts ...BuilderOption) (*BytesBuilder, error) {
+	o := *applyBuilderOptions(opts)
+	if o.InternValues {
+		return nil, ErrInternedValues
+	}
+	o.payloads = true
+	b, err := newBuilder(w, &o)
+	if err != nil {
+		return nil, err
+	}
+	return &BytesBuilder{b: b}, nil
+}
+
+// Insert adds the key with its payload.
+func (b *BytesBuilder) Insert(key, payload []byte) error {
+	if b.b.out.err != nil {
+		return b.b.out.err
+	}
+	if bytes.Compare(key, b.b.last) < 0 {
+		return ErrOutOfOrder
+	}
+	return b.b.Insert(key, b.b.payloads.intern(payload))
+}
+
+// Reset resets the BytesBuilder to build another FST to the provided Writer.
+func (b *BytesBuilder) Reset(w io.Writer) error {
+	return b.b.Reset(w)
+}
+
+// Close MUST be called after inserting all keys.
+func (b *BytesBuilder) Close() error {
+	return b.b.Close()
+}
+
+// HasPayloads returns true if the FST was built with a BytesBuilder.
+func (f *FST) HasPayloads() bool {
+	return f.payloads != nil
+}
+
+// GetBytes returns the payload associated with the key, and whether the
+// key exists.
+func (f *FST) GetBytes(key []byte) ([]byte, bool, error) {
+	if err := f.enter(); err != nil {
+		return nil, false, err
+	}
+	defer f.exit()
+	if f.payloads == nil {
+		return nil, false, ErrNoPayloads
+	}
+	val, exists, err := f.Get(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	rv, err := f.Payload(val)
+	if err != nil {
+		return nil, false, err
+	}
+	return rv, true, nil
+}
+
+// Payload returns the payload a value returned by an Iterator over the FST
+// refers to.  The payload is a slice of the FST data, and must not be
+// modified.
+func (f *FST) Payload(val uint64) ([]byte, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	if f.payloads == nil {
+		return nil, ErrNoPayloads
+	}
+	rv, ok := f.payloads.get(val)
+	if !ok {
+		return nil, corruptf(0, "payload index %d out of range", val)
+	}
+	return rv, nil
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBytesBuilder(t *testing.T) {
+	payload := func(i int) []byte {
+		// a few payloads are shared, including the empty one
+		if i%10 == 0 {
+			return nil
+		}
+		if i%3 == 0 {
+			return []byte("shared payload")
+		}
+		return []byte(fmt.Sprintf("{\"word\":%d}", i))
+	}
+
+	var buf bytes.Buffer
+	b, err := NewBytesBuilder(&buf, WithSubtreeCounts())
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	for i, word := range thousandTestWords {
+		err = b.Insert([]byte(word), payload(i))
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Insert([]byte("a"), nil)
+	if err != ErrOutOfOrder {
+		t.Errorf("expected ErrOutOfOrder, got %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if !fst.HasPayloads() {
+		t.Fatalf("expected payloads")
+	}
+
+	for i, word := range thousandTestWords {
+		got, exists, err := fst.GetBytes([]byte(word))
+		if err != nil || !exists || !bytes.Equal(got, payload(i)) {
+			t.Errorf("expected %q for %q, got %q %t %v", payload(i), word,
+				got, exists, err)
+		}
+	}
+	_, exists, err := fst.GetBytes([]byte("not a word"))
+	if err != nil || exists {
+		t.Errorf("expected a missing key, got %t %v", exists, err)
+	}
+
+	itr, err := fst.Iterator(nil, nil)
+	for i := 0; err == nil; i++ {
+		_, val := itr.Current()
+		got, perr := fst.Payload(val)
+		if perr != nil || !bytes.Equal(got, payload(i)) {
+			t.Errorf("expected %q for key %d, got %q %v", payload(i), i, got,
+				perr)
+		}
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Fatalf("error iterating: %v", err)
+	}
+
+	plain := buildKVs(t, KV{"a", 1})
+	if _, _, err = plain.GetBytes([]byte("a")); err != ErrNoPayloads {
+		t.Errorf("expected ErrNoPayloads, got %v", err)
+	}
+	_, err = NewBytesBuilder(&buf, WithInternedValues())
+	if err != ErrInternedValues {
+		t.Errorf("expected ErrInternedValues, got %v", err)
+	}
+}
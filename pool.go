@@ -0,0 +1,233 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// lookupBatchSize is the number of keys of a LookupBatch looked up by
+// each task
+const lookupBatchSize = 256
+
+// WithWorkers gives the FST a pool of n goroutines, shared by all its
+// parallel operations, LookupBatch and SearchParallel, so that load spikes
+// don't start a goroutine per call.  The goroutines are started on first
+// use, and stopped by Close.  Without a pool, the parallel operations run
+// on the calling goroutine.
+func WithWorkers(n int) OpenOption {
+	return func(o *openOpts) {
+		o.workers = n
+	}
+}
+
+// workerPool runs the tasks of the parallel operations of an FST on a
+// fixed number of goroutines
+type workerPool struct {
+	n     int
+	tasks chan func()
+	once  sync.Once
+
+	// m is held for reading by each run, so that close waits for them
+	m      sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(n int) *workerPool {
+	return &workerPool{
+		n:     n,
+		tasks: make(chan func()),
+	}
+}
+
+// run runs the tasks on the workers, waiting for all of them, or on the
+// calling goroutine once the pool is closed.  Tasks are also run on the
+// calling goroutine while all the workers are busy, so that tasks running
+// tasks of their own, such as a callback of SearchParallel calling
+// LookupBatch, don't wait for themselves.
+func (p *workerPool) run(tasks []func()) {
+	if p == nil {
+		runTasks(tasks)
+		return
+	}
+	p.m.RLock()
+	if p.closed {
+		p.m.RUnlock()
+		runTasks(tasks)
+		return
+	}
+	defer p.m.RUnlock()
+	p.once.Do(func() {
+		p.wg.Add(p.n)
+		for i := 0; i < p.n; i++ {
+			go p.work()
+		}
+	})
+	var wg sync.WaitGroup
+	wg.Add(len(tasks))
+	for _, task := range tasks {
+		task := task
+		t := func() {
+			defer wg.Done()
+			task()
+		}
+		select {
+		case p.tasks <- t:
+		default:
+			t()
+		}
+	}
+	wg.Wait()
+}
+
+func runTasks(tasks []func()) {
+	for _, task := range tasks {
+		task()
+	}
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for task := range p.tasks {
+		task()
+	}
+}
+
+// close stops the workers, once the running operations are done
+func (p *workerPool) close() {
+	if p == nil {
+		return
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.tasks)
+	p.wg.Wait()
+}
+
+// LookupBatch looks up each of the keys, as Lookup does, splitting them
+// among the workers of the FST, see WithWorkers.  The Results are in the
+// order of the keys.
+func (f *FST) LookupBatch(keys [][]byte) []Result {
+	if err := f.enter(); err != nil {
+		rv := make([]Result, len(keys))
+		for i, key := range keys {
+			rv[i] = Result{key: key, err: err}
+		}
+		return rv
+	}
+	defer f.exit()
+	rv := make([]Result, len(keys))
+	var tasks []func()
+	for start := 0; start < len(keys); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch, results := keys[start:end], rv[start:end]
+		tasks = append(tasks, func() {
+			r := Reader{f: f}
+			for i, key := range batch {
+				results[i] = r.Lookup(key)
+			}
+		})
+	}
+	f.pool.run(tasks)
+	return rv
+}
+
+// SearchParallel invokes fn for each key matching the automaton, between
+// startKeyInclusive and endKeyExclusive, as iterating over Search would,
+// except that the keys are partitioned by their first byte, and the
+// partitions are searched by the workers of the FST, see WithWorkers.  So
+// fn may be invoked concurrently, and the keys are in order only within a
+// partition.  The key is only valid for the duration of the call.  If fn
+// returns an error, the search stops, and SearchParallel returns it, after
+// any calls already in progress return.
+func (f *FST) SearchParallel(aut Automaton, startKeyInclusive, endKeyExclusive []byte,
+	fn func(key []byte, val uint64) error) error {
+	if err := f.enter(); err != nil {
+		return err
+	}
+	defer f.exit()
+	err := emptySearch(f, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		if err == ErrIteratorDone || err == ErrIteratorEndBound {
+			return nil
+		}
+		return err
+	}
+	root, err := f.decoder.stateAt(f.decoder.getRoot(), nil)
+	if err != nil {
+		return err
+	}
+
+	var stopped int32
+	var m sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		m.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		m.Unlock()
+		atomic.StoreInt32(&stopped, 1)
+	}
+	search := func(start, end []byte) {
+		itr, err := f.Search(aut, start, end)
+		for err == nil && atomic.LoadInt32(&stopped) == 0 {
+			key, val := itr.Current()
+			err = fn(key, val)
+			if err == nil {
+				err = itr.Next()
+			}
+		}
+		if err != nil && err != ErrIteratorDone && err != ErrIteratorEndBound {
+			fail(err)
+		}
+	}
+
+	// a partition for the empty key, if it is in range, then one for the
+	// keys starting with each byte the root has a transition for
+	var tasks []func()
+	partition := func(start, end []byte) {
+		if bytes.Compare(start, startKeyInclusive) < 0 {
+			start = startKeyInclusive
+		}
+		if endKeyExclusive != nil &&
+			(end == nil || bytes.Compare(end, endKeyExclusive) > 0) {
+			end = endKeyExclusive
+		}
+		if end != nil && bytes.Compare(start, end) >= 0 {
+			return
+		}
+		tasks = append(tasks, func() { search(start, end) })
+	}
+	if root.Final() {
+		partition(nil, []byte{0})
+	}
+	for i := 0; i < root.NumTransitions(); i++ {
+		t := root.TransitionAt(i)
+		partition([]byte{t}, PrefixSuccessor([]byte{t}))
+	}
+	f.pool.run(tasks)
+	return firstErr
+}
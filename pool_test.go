@@ -0,0 +1,171 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestWorkerPool(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	words := append([]string{""}, thousandTestWords...)
+	vals := randomValues(words)
+	err = insertStrings(b, words, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+
+	keys := make([][]byte, 0, 2*len(words))
+	for _, word := range words {
+		keys = append(keys, []byte(word), []byte(word+"\xff"))
+	}
+	searchAll := func(fst *FST, aut Automaton, start, end []byte) []string {
+		var m sync.Mutex
+		var rv []string
+		err := fst.SearchParallel(aut, start, end, func(key []byte, val uint64) error {
+			m.Lock()
+			rv = append(rv, string(key))
+			m.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("error searching: %v", err)
+		}
+		sort.Strings(rv)
+		return rv
+	}
+	searchSeq := func(fst *FST, aut Automaton, start, end []byte) []string {
+		var rv []string
+		itr, err := fst.Search(aut, start, end)
+		for err == nil {
+			key, _ := itr.Current()
+			rv = append(rv, string(key))
+			err = itr.Next()
+		}
+		if err != ErrIteratorDone {
+			t.Fatalf("error iterating: %v", err)
+		}
+		return rv
+	}
+
+	goroutines := runtime.NumGoroutine()
+	for _, opts := range [][]OpenOption{nil, {WithWorkers(4)}} {
+		fst, err := Load(buf.Bytes(), opts...)
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+
+		results := fst.LookupBatch(keys)
+		for i, result := range results {
+			exists := i%2 == 0
+			if result.Err() != nil || result.Exists() != exists ||
+				!bytes.Equal(result.Key(), keys[i]) ||
+				(exists && result.Value() != vals[i/2]) {
+				t.Fatalf("unexpected result for %q: %v", keys[i], result)
+			}
+		}
+
+		for _, bounds := range [][2][]byte{
+			{nil, nil},
+			{[]byte("c"), []byte("ma")},
+			{[]byte("ab"), nil},
+			{[]byte("p"), []byte("p")},
+		} {
+			for _, aut := range []Automaton{nil, literalMatch{lit: "m", prefix: true}} {
+				got := searchAll(fst, aut, bounds[0], bounds[1])
+				want := searchSeq(fst, aut, bounds[0], bounds[1])
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("expected %d keys between %q and %q, got %d", len(want),
+						bounds[0], bounds[1], len(got))
+				}
+			}
+		}
+
+		// the first error stops the search
+		stop := errors.New("stop")
+		var m sync.Mutex
+		var calls, maxGoroutines int
+		err = fst.SearchParallel(nil, nil, nil, func(key []byte, val uint64) error {
+			m.Lock()
+			defer m.Unlock()
+			calls++
+			if n := runtime.NumGoroutine(); n > maxGoroutines {
+				maxGoroutines = n
+			}
+			if calls == 10 {
+				return stop
+			}
+			return nil
+		})
+		if err != stop || calls >= len(words) {
+			t.Errorf("expected search to stop, got %v after %d calls", err, calls)
+		}
+		if maxGoroutines > goroutines+4 {
+			t.Errorf("expected at most 4 workers, got %d goroutines",
+				maxGoroutines-goroutines)
+		}
+
+		err = fst.Close()
+		if err != nil {
+			t.Fatalf("error closing: %v", err)
+		}
+	}
+}
+
+func TestWorkerPoolNested(t *testing.T) {
+	data := buildWordsWithCounts(t)
+	fst, err := Load(data, WithWorkers(1))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	defer func() { _ = fst.Close() }()
+
+	// each callback runs a batch of its own, while the only worker may be
+	// busy with another
+	var m sync.Mutex
+	var n int
+	err = fst.SearchParallel(nil, nil, nil, func(key []byte, val uint64) error {
+		results := fst.LookupBatch([][]byte{key})
+		if results[0].Err() != nil || !results[0].Exists() ||
+			results[0].Value() != val {
+			return fmt.Errorf("unexpected result for %q: %v", key, results[0])
+		}
+		m.Lock()
+		n++
+		m.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if n != fst.Len() {
+		t.Errorf("expected %d keys, got %d", fst.Len(), n)
+	}
+}
@@ -0,0 +1,300 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// DefaultPrefetchBatch is the number of keys read ahead at a time by a
+// PrefetchIterator, if no other batch size is configured.
+const DefaultPrefetchBatch = 256
+
+// PrefetchIterator reads ahead from another Iterator on its own goroutine,
+// so that the consumer, such as a Builder writing the result of a Merge,
+// is not stalled while the source decodes keys or waits on I/O.  Keys are
+// copied into batches of batchSize, and at most batches of them are
+// buffered ahead of the consumer.
+//
+// The wrapped Iterator must not be used directly while the PrefetchIterator
+// is in use, Close closes it.
+type PrefetchIterator struct {
+	itr       Iterator
+	batches   int
+	batchSize int
+
+	ch   chan *prefetchBatch
+	free chan *prefetchBatch
+	quit chan struct{}
+	done chan struct{}
+
+	batch *prefetchBatch
+	pos   int
+}
+
+// prefetchBatch holds consecutive keys and values, and the error returned
+// by the wrapped Iterator after the last of them, if any.
+type prefetchBatch struct {
+	keys []byte
+	ends []int
+	vals []uint64
+	err  error
+}
+
+func (b *prefetchBatch) reset() {
+	b.keys = b.keys[:0]
+	b.ends = b.ends[:0]
+	b.vals = b.vals[:0]
+	b.err = nil
+}
+
+// NewPrefetchIterator starts reading ahead from the provided Iterator,
+// buffering up to batches batches of batchSize keys.  If batches is not
+// positive, one batch is buffered, if batchSize is not positive
+// DefaultPrefetchBatch is used.
+func NewPrefetchIterator(itr Iterator, batches, batchSize int) *PrefetchIterator {
+	if batches <= 0 {
+		batches = 1
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultPrefetchBatch
+	}
+	rv := &PrefetchIterator{
+		itr:       itr,
+		batches:   batches,
+		batchSize: batchSize,
+		// the consumer holds one batch, the producer fills one more
+		free: make(chan *prefetchBatch, batches+2),
+	}
+	rv.start()
+	return rv
+}
+
+// start starts reading ahead from the current position of the wrapped
+// Iterator, and waits for the first batch.
+func (p *PrefetchIterator) start() {
+	p.ch = make(chan *prefetchBatch, p.batches)
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.fill(p.ch, p.quit, p.done)
+	p.recycle()
+	p.batch = <-p.ch
+	p.pos = 0
+}
+
+// stop stops reading ahead, after which the wrapped Iterator can be used
+// directly.  Batches read ahead are discarded.
+func (p *PrefetchIterator) stop() {
+	if p.quit == nil {
+		return
+	}
+	close(p.quit)
+	<-p.done
+	p.quit = nil
+}
+
+func (p *PrefetchIterator) recycle() {
+	if p.batch != nil {
+		select {
+		case p.free <- p.batch:
+		default:
+		}
+		p.batch = nil
+	}
+}
+
+func (p *PrefetchIterator) fill(ch chan<- *prefetchBatch, quit <-chan struct{},
+	done chan<- struct{}) {
+	defer close(done)
+	key, val := p.itr.Current()
+	for {
+		var b *prefetchBatch
+		select {
+		case b = <-p.free:
+			b.reset()
+		default:
+			b = &prefetchBatch{
+				ends: make([]int, 0, p.batchSize),
+				vals: make([]uint64, 0, p.batchSize),
+			}
+		}
+		for len(b.vals) < p.batchSize {
+			if key == nil {
+				b.err = ErrIteratorDone
+				break
+			}
+			b.keys = append(b.keys, key...)
+			b.ends = append(b.ends, len(b.keys))
+			b.vals = append(b.vals, val)
+			err := p.itr.Next()
+			if err != nil {
+				b.err = err
+				break
+			}
+			key, val = p.itr.Current()
+		}
+		select {
+		case ch <- b:
+		case <-quit:
+			return
+		}
+		if b.err != nil {
+			return
+		}
+	}
+}
+
+// Current returns the key and value currently pointed to by the iterator.
+// The key is valid until the next call to Next/Seek/Reset/Close.
+func (p *PrefetchIterator) Current() ([]byte, uint64) {
+	if p.batch == nil || p.pos >= len(p.batch.vals) {
+		return nil, 0
+	}
+	var start int
+	if p.pos > 0 {
+		start = p.batch.ends[p.pos-1]
+	}
+	return p.batch.keys[start:p.batch.ends[p.pos]], p.batch.vals[p.pos]
+}
+
+// Next advances the iterator to the next key/value pair.  Errors returned
+// by the wrapped Iterator are returned once the keys read before them
+// have been consumed.
+func (p *PrefetchIterator) Next() error {
+	if p.batch == nil {
+		return ErrIteratorDone
+	}
+	if p.pos < len(p.batch.vals) {
+		p.pos++
+	}
+	if p.pos < len(p.batch.vals) {
+		return nil
+	}
+	if p.batch.err != nil {
+		return p.batch.err
+	}
+	p.recycle()
+	p.batch = <-p.ch
+	p.pos = 0
+	if len(p.batch.vals) == 0 {
+		return p.batch.err
+	}
+	return nil
+}
+
+// Seek advances the iterator to the specified key, see Iterator.Seek.
+func (p *PrefetchIterator) Seek(key []byte) error {
+	p.stop()
+	err := p.itr.Seek(key)
+	p.start()
+	return err
+}
+
+// Reset resets the wrapped Iterator, and starts reading ahead from its new
+// position.
+func (p *PrefetchIterator) Reset(f *FST, startKeyInclusive,
+	endKeyExclusive []byte, aut Automaton) error {
+	p.stop()
+	err := p.itr.Reset(f, startKeyInclusive, endKeyExclusive, aut)
+	p.start()
+	return err
+}
+
+// Close stops reading ahead and closes the wrapped Iterator.
+func (p *PrefetchIterator) Close() error {
+	p.stop()
+	p.recycle()
+	return p.itr.Close()
+}
+
+// iteratorRing is a ring buffer of the entries an FSTIterator decoded
+// ahead, see WithIteratorPrefetch.  The key buffers are reused as the ring
+// wraps around.
+type iteratorRing struct {
+	keys  [][]byte
+	vals  []uint64
+	first int
+	n     int
+	// err is the error which ended the decoding of the entries, returned
+	// once they are consumed
+	err error
+}
+
+func newIteratorRing(size int) *iteratorRing {
+	return &iteratorRing{
+		keys: make([][]byte, size),
+		vals: make([]uint64, size),
+	}
+}
+
+func (r *iteratorRing) reset() {
+	r.first = 0
+	r.n = 0
+	r.err = nil
+}
+
+func (r *iteratorRing) full() bool {
+	return r.n == len(r.vals)
+}
+
+func (r *iteratorRing) push(key []byte, val uint64) {
+	i := (r.first + r.n) % len(r.vals)
+	r.keys[i] = append(r.keys[i][:0], key...)
+	r.vals[i] = val
+	r.n++
+}
+
+func (r *iteratorRing) head() ([]byte, uint64) {
+	return r.keys[r.first], r.vals[r.first]
+}
+
+func (r *iteratorRing) pop() {
+	r.first = (r.first + 1) % len(r.vals)
+	r.n--
+}
+
+// fill decodes the entries from the current position of the path into the
+// empty ring, until it is full
+func (i *FSTIterator) fill() error {
+	curr := i.statesStack[len(i.statesStack)-1]
+	i.ring.push(i.keysStack, i.value(curr))
+	for !i.ring.full() {
+		err := i.next(-1)
+		if err != nil {
+			i.ring.err = err
+			break
+		}
+		curr = i.statesStack[len(i.statesStack)-1]
+		i.ring.push(i.keysStack, i.value(curr))
+	}
+	return nil
+}
+
+// nextPrefetched advances to the next entry of the ring, decoding more
+// when it is empty
+func (i *FSTIterator) nextPrefetched() error {
+	if i.ring.n > 0 {
+		i.ring.pop()
+	}
+	if i.ring.n > 0 {
+		return nil
+	}
+	if i.ring.err != nil {
+		return i.ring.err
+	}
+	err := i.next(-1)
+	if err != nil {
+		i.ring.err = err
+		return err
+	}
+	i.ring.reset()
+	return i.fill()
+}
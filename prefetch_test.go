@@ -0,0 +1,299 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func drainIterator(t testing.TB, itr Iterator, err error) ([]string, []uint64) {
+	var keys []string
+	var vals []uint64
+	for err == nil {
+		k, v := itr.Current()
+		keys = append(keys, string(k))
+		vals = append(vals, v)
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Fatalf("error iterating: %v", err)
+	}
+	return keys, vals
+}
+
+func TestPrefetchIterator(t *testing.T) {
+	fst, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	itr, err := fst.Iterator(nil, nil)
+	wantKeys, wantVals := drainIterator(t, itr, err)
+
+	itr, err = fst.Iterator(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	p := NewPrefetchIterator(itr, 2, 3)
+	gotKeys, gotVals := drainIterator(t, p, nil)
+	if !reflect.DeepEqual(wantKeys, gotKeys) || !reflect.DeepEqual(wantVals, gotVals) {
+		t.Fatalf("expected prefetched keys and values to match")
+	}
+
+	mid := len(wantKeys) / 2
+	err = p.Seek([]byte(wantKeys[mid]))
+	gotKeys, _ = drainIterator(t, p, err)
+	if !reflect.DeepEqual(wantKeys[mid:], gotKeys) {
+		t.Errorf("expected %d keys after seek, got %d", len(wantKeys[mid:]),
+			len(gotKeys))
+	}
+
+	err = p.Reset(fst, []byte(wantKeys[1]), []byte(wantKeys[5]), nil)
+	gotKeys, _ = drainIterator(t, p, err)
+	if !reflect.DeepEqual(wantKeys[1:5], gotKeys) {
+		t.Errorf("expected %v after reset, got %v", wantKeys[1:5], gotKeys)
+	}
+
+	err = p.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+}
+
+// failingIterator returns an error instead of advancing past failAt
+type failingIterator struct {
+	*testIterator
+	failAt int
+}
+
+var errTestIterator = errors.New("test iterator failed")
+
+func (f *failingIterator) Next() error {
+	if f.curr == f.failAt {
+		return errTestIterator
+	}
+	return f.testIterator.Next()
+}
+
+func TestPrefetchIteratorError(t *testing.T) {
+	ti, _ := newTestIterator(map[string]uint64{
+		"a": 1, "b": 2, "c": 3, "d": 4, "e": 5,
+	})
+	p := NewPrefetchIterator(&failingIterator{testIterator: ti, failAt: 3}, 1, 2)
+	var keys []string
+	var err error
+	for err == nil {
+		k, _ := p.Current()
+		keys = append(keys, string(k))
+		err = p.Next()
+	}
+	if err != errTestIterator {
+		t.Errorf("expected test iterator error, got %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c", "d"}) {
+		t.Errorf("expected keys before the error, got %v", keys)
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+}
+
+// buildSegments splits the words between n FSTs, with some words in
+// several of them
+func buildSegments(t testing.TB, n int) []*FST {
+	vals := randomValues(thousandTestWords)
+	rv := make([]*FST, n)
+	for i := range rv {
+		var words []string
+		var wordVals []uint64
+		for j, word := range thousandTestWords {
+			if j%n == i || j%(n+1) == i {
+				words = append(words, word)
+				wordVals = append(wordVals, vals[j])
+			}
+		}
+		var buf bytes.Buffer
+		b, err := New(&buf)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, words, wordVals)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing: %v", err)
+		}
+		rv[i], err = Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+	}
+	return rv
+}
+
+func segmentIterators(t testing.TB, segments []*FST) []Iterator {
+	rv := make([]Iterator, len(segments))
+	for i, fst := range segments {
+		itr, err := fst.Iterator(nil, nil)
+		if err != nil {
+			t.Fatalf("error creating iterator: %v", err)
+		}
+		rv[i] = itr
+	}
+	return rv
+}
+
+func TestMergePrefetch(t *testing.T) {
+	segments := buildSegments(t, 8)
+	var want bytes.Buffer
+	err := Merge(&want, nil, segmentIterators(t, segments), MergeSum)
+	if err != nil {
+		t.Fatalf("error merging: %v", err)
+	}
+	for _, batchSize := range []int{0, 1, 7} {
+		var got bytes.Buffer
+		err = Merge(&got, WithMergePrefetch(2, batchSize),
+			segmentIterators(t, segments), MergeSum)
+		if err != nil {
+			t.Fatalf("error merging: %v", err)
+		}
+		if !bytes.Equal(want.Bytes(), got.Bytes()) {
+			t.Errorf("batch size %d: expected identical FSTs with prefetching",
+				batchSize)
+		}
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	for _, n := range []int{16, 64} {
+		segments := buildSegments(b, n)
+		for _, prefetch := range []int{0, 4} {
+			b.Run(fmt.Sprintf("segments=%d/prefetch=%d", n, prefetch),
+				func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						err := Merge(ioutil.Discard, WithMergePrefetch(prefetch, 0),
+							segmentIterators(b, segments), MergeSum)
+						if err != nil {
+							b.Fatalf("error merging: %v", err)
+						}
+					}
+				})
+		}
+	}
+}
+
+func TestIteratorPrefetch(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, randomValues(thousandTestWords))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	plain, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	prefetched, err := Load(buf.Bytes(), WithIteratorPrefetch(7))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	type entry struct {
+		key   string
+		val   uint64
+		match Match
+	}
+	// iterates, seeking at the keys of seeks, and returns what is seen
+	run := func(f *FST, start, end []byte, aut Automaton,
+		seeks map[int]string) ([]entry, error) {
+		var rv []entry
+		itr, err := f.Search(aut, start, end)
+		for n := 0; err == nil; n++ {
+			key, val := itr.Current()
+			rv = append(rv, entry{string(key), val, itr.Match()})
+			if seek, ok := seeks[n]; ok {
+				err = itr.Seek([]byte(seek))
+			} else {
+				err = itr.Next()
+			}
+		}
+		return rv, err
+	}
+	tests := []struct {
+		name       string
+		start, end []byte
+		aut        Automaton
+		seeks      map[int]string
+	}{
+		{name: "all"},
+		{name: "bounded", start: []byte("b"), end: []byte("m")},
+		{name: "prefix", aut: PrefixAutomaton([]byte("co"))},
+		{name: "seeks", seeks: map[int]string{3: "d", 10: "ab", 20: "zz"}},
+	}
+	for _, test := range tests {
+		want, wantErr := run(plain, test.start, test.end, test.aut, test.seeks)
+		got, gotErr := run(prefetched, test.start, test.end, test.aut,
+			test.seeks)
+		if gotErr != wantErr {
+			t.Errorf("%s: expected %v, got %v", test.name, wantErr, gotErr)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %d entries, got %d", test.name, len(want),
+				len(got))
+		}
+	}
+
+	// bookmarks are taken at the current key, not at the entries ahead
+	itr, err := prefetched.Iterator(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	for n := 0; n < 3; n++ {
+		if err = itr.Next(); err != nil {
+			t.Fatalf("error iterating: %v", err)
+		}
+	}
+	want, _ := itr.Current()
+	bookmark, err := itr.Bookmark("")
+	if err != nil {
+		t.Fatalf("error taking bookmark: %v", err)
+	}
+	resumed, err := plain.Resume(bookmark, nil)
+	if err != nil {
+		t.Fatalf("error resuming: %v", err)
+	}
+	if err = itr.Next(); err != nil {
+		t.Fatalf("error iterating: %v", err)
+	}
+	got, _ := resumed.Current()
+	next, _ := itr.Current()
+	if !bytes.Equal(got, next) || bytes.Equal(got, want) {
+		t.Errorf("expected to resume at %q after %q, got %q", next, want, got)
+	}
+}
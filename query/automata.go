@@ -0,0 +1,49 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+// literal matches exactly the literal bytes, or if prefix is set, any
+// key starting with them.  State 0 is dead, state i+1 means i bytes of the
+// literal have been matched.
+type literal struct {
+	lit    []byte
+	prefix bool
+}
+
+func (l *literal) Start() int {
+	return 1
+}
+
+func (l *literal) IsMatch(s int) bool {
+	return s == len(l.lit)+1
+}
+
+func (l *literal) CanMatch(s int) bool {
+	return s > 0
+}
+
+func (l *literal) WillAlwaysMatch(s int) bool {
+	return l.prefix && s == len(l.lit)+1
+}
+
+func (l *literal) Accept(s int, b byte) int {
+	if s > 0 && s <= len(l.lit) && l.lit[s-1] == b {
+		return s + 1
+	}
+	if l.prefix && s == len(l.lit)+1 {
+		return s
+	}
+	return 0
+}
@@ -0,0 +1,227 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package query provides a small, composable, query expression API, which
+// compiles to a vellum.Automaton suitable for searching an FST:
+//
+//	q := query.Prefix("foo").And(query.Fuzzy("bar", 1)).Or(query.Regexp("ba+z"))
+//	aut, err := q.Compile()
+//	if err != nil {
+//		// the query is invalid
+//	}
+//	itr, err := fst.Search(aut, nil, nil)
+//
+// Building a query never fails, all validation is deferred to Compile,
+// which reports the first problem found.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/automaton"
+	"github.com/couchbase/vellum/levenshtein2"
+	"github.com/couchbase/vellum/regexp"
+)
+
+// MaxFuzzyDistance is the maximum edit distance supported by Fuzzy.
+const MaxFuzzyDistance = 2
+
+// ErrInvalidQuery is returned by Compile for a malformed query, which
+// can't be compiled.
+var ErrInvalidQuery = fmt.Errorf("invalid query")
+
+type op int
+
+const (
+	opAll op = iota
+	opExact
+	opPrefix
+	opFuzzy
+	opRegexp
+	opAnd
+	opOr
+	opNot
+)
+
+// Query is an expression matching a set of keys.  Queries are immutable,
+// combining them with And, Or and Not returns a new Query.
+type Query struct {
+	op       op
+	term     string
+	distance int
+	subs     []*Query
+}
+
+// All matches all keys.
+func All() *Query {
+	return &Query{op: opAll}
+}
+
+// Exact matches only the provided key.
+func Exact(key string) *Query {
+	return &Query{op: opExact, term: key}
+}
+
+// Prefix matches all keys starting with the provided prefix.
+func Prefix(prefix string) *Query {
+	return &Query{op: opPrefix, term: prefix}
+}
+
+// Fuzzy matches all keys within the provided Levenshtein edit distance
+// (counted in unicode characters) of the term.  The distance can be no
+// more than MaxFuzzyDistance.
+func Fuzzy(term string, distance int) *Query {
+	return &Query{op: opFuzzy, term: term, distance: distance}
+}
+
+// Regexp matches all keys matching the provided regular expression, see
+// the vellum regexp package for the supported syntax.  The expression must
+// match the whole key.
+func Regexp(expr string) *Query {
+	return &Query{op: opRegexp, term: expr}
+}
+
+// And returns a Query matching keys matched by this and all the other
+// queries.
+func (q *Query) And(others ...*Query) *Query {
+	return q.combine(opAnd, others)
+}
+
+// Or returns a Query matching keys matched by this or any of the other
+// queries.
+func (q *Query) Or(others ...*Query) *Query {
+	return q.combine(opOr, others)
+}
+
+// Not returns a Query matching keys not matched by this query.
+func (q *Query) Not() *Query {
+	return &Query{op: opNot, subs: []*Query{q}}
+}
+
+func (q *Query) combine(o op, others []*Query) *Query {
+	rv := &Query{op: o}
+	// flatten nested combinations of the same kind
+	for _, sub := range append([]*Query{q}, others...) {
+		if sub != nil && sub.op == o {
+			rv.subs = append(rv.subs, sub.subs...)
+		} else {
+			rv.subs = append(rv.subs, sub)
+		}
+	}
+	return rv
+}
+
+// String returns a readable representation of the query.
+func (q *Query) String() string {
+	if q == nil {
+		return "<nil>"
+	}
+	switch q.op {
+	case opAll:
+		return "all()"
+	case opExact:
+		return "exact(" + strconv.Quote(q.term) + ")"
+	case opPrefix:
+		return "prefix(" + strconv.Quote(q.term) + ")"
+	case opFuzzy:
+		return fmt.Sprintf("fuzzy(%s, %d)", strconv.Quote(q.term), q.distance)
+	case opRegexp:
+		return "regexp(" + strconv.Quote(q.term) + ")"
+	case opNot:
+		return "not(" + q.subs[0].String() + ")"
+	}
+	parts := make([]string, len(q.subs))
+	for i, sub := range q.subs {
+		parts[i] = sub.String()
+	}
+	sep := " AND "
+	if q.op == opOr {
+		sep = " OR "
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// Compile validates the query and compiles it to an Automaton.  The
+// returned Automaton is safe for concurrent use.
+func (q *Query) Compile() (vellum.Automaton, error) {
+	if q == nil {
+		return nil, fmt.Errorf("%w: nil query", ErrInvalidQuery)
+	}
+	switch q.op {
+	case opAll:
+		return &vellum.AlwaysMatch{}, nil
+	case opExact:
+		return &literal{lit: []byte(q.term)}, nil
+	case opPrefix:
+		return &literal{lit: []byte(q.term), prefix: true}, nil
+	case opFuzzy:
+		return compileFuzzy(q.term, q.distance)
+	case opRegexp:
+		rv, err := regexp.New(q.term)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidQuery, q, err)
+		}
+		return rv, nil
+	case opNot:
+		sub, err := q.subs[0].Compile()
+		if err != nil {
+			return nil, err
+		}
+		return automaton.Complement(sub), nil
+	}
+	auts := make([]vellum.Automaton, len(q.subs))
+	for i, sub := range q.subs {
+		var err error
+		auts[i], err = sub.Compile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if q.op == opAnd {
+		return automaton.Intersection(auts...), nil
+	}
+	return automaton.Union(auts...), nil
+}
+
+var fuzzyBuilders [MaxFuzzyDistance + 1]struct {
+	once    sync.Once
+	builder *levenshtein2.LevenshteinAutomatonBuilder
+	err     error
+}
+
+func compileFuzzy(term string, distance int) (vellum.Automaton, error) {
+	if distance < 0 || distance > MaxFuzzyDistance {
+		return nil, fmt.Errorf("%w: fuzzy distance %d not in range [0, %d]",
+			ErrInvalidQuery, distance, MaxFuzzyDistance)
+	}
+	// builders are expensive to create, but reusable
+	b := &fuzzyBuilders[distance]
+	b.once.Do(func() {
+		b.builder, b.err = levenshtein2.NewLevenshteinAutomatonBuilder(
+			uint8(distance), false)
+	})
+	if b.err != nil {
+		return nil, b.err
+	}
+	rv, err := b.builder.BuildDfa(term, uint8(distance))
+	if err != nil {
+		return nil, fmt.Errorf("%w: fuzzy(%s, %d): %v",
+			ErrInvalidQuery, strconv.Quote(term), distance, err)
+	}
+	return rv, nil
+}
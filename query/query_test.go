@@ -0,0 +1,174 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum"
+)
+
+var testKeys = []string{
+	"",
+	"ba",
+	"bar",
+	"baz",
+	"baaaz",
+	"bbaz",
+	"car",
+	"foo",
+	"foobar",
+	"foobaz",
+	"foobarbaz",
+	"fooqux",
+	"fop",
+}
+
+func buildTestFST(t *testing.T) *vellum.FST {
+	var buf bytes.Buffer
+	b, err := vellum.New(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted := append([]string(nil), testKeys...)
+	for i := 1; i < len(sorted); i++ {
+		for j := i; j > 0 && sorted[j] < sorted[j-1]; j-- {
+			sorted[j], sorted[j-1] = sorted[j-1], sorted[j]
+		}
+	}
+	for i, k := range sorted {
+		err = b.Insert([]byte(k), uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fst, err := vellum.Load(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fst
+}
+
+func search(t *testing.T, fst *vellum.FST, q *Query) []string {
+	aut, err := q.Compile()
+	if err != nil {
+		t.Fatalf("error compiling %s: %v", q, err)
+	}
+	var rv []string
+	itr, err := fst.Search(aut, nil, nil)
+	for err == nil {
+		k, _ := itr.Current()
+		rv = append(rv, string(k))
+		err = itr.Next()
+	}
+	if err != vellum.ErrIteratorDone {
+		t.Fatalf("error searching %s: %v", q, err)
+	}
+	return rv
+}
+
+func TestQuery(t *testing.T) {
+	fst := buildTestFST(t)
+	tests := []struct {
+		q    *Query
+		want []string
+	}{
+		{
+			q:    Exact("bar"),
+			want: []string{"bar"},
+		},
+		{
+			q:    Prefix("foo"),
+			want: []string{"foo", "foobar", "foobarbaz", "foobaz", "fooqux"},
+		},
+		{
+			q:    Fuzzy("bar", 1),
+			want: []string{"ba", "bar", "baz", "car"},
+		},
+		{
+			q:    Regexp("ba+z"),
+			want: []string{"baaaz", "baz"},
+		},
+		{
+			q:    Prefix("foo").And(Fuzzy("foobar", 1)),
+			want: []string{"foobar", "foobaz"},
+		},
+		{
+			q:    Prefix("foo").And(Fuzzy("bar", 1)).Or(Regexp("ba+z")),
+			want: []string{"baaaz", "baz"},
+		},
+		{
+			q:    Prefix("foo").And(Prefix("foob").Not()),
+			want: []string{"foo", "fooqux"},
+		},
+		{
+			q:    Regexp("ba+z").Or(Exact("car"), Exact("fop")),
+			want: []string{"baaaz", "baz", "car", "fop"},
+		},
+		{
+			q:    Prefix("b").And(Regexp(".*z"), Fuzzy("baz", 2)),
+			want: []string{"baaaz", "baz", "bbaz"},
+		},
+		{
+			q:    Exact("nope").Or(Prefix("x")),
+			want: nil,
+		},
+		{
+			q:    All().And(Prefix("f").Not(), Prefix("b").Not()),
+			want: []string{"", "car"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.q.String(), func(t *testing.T) {
+			got := search(t, fst, test.q)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	tests := []*Query{
+		Fuzzy("bar", 7),
+		Fuzzy("bar", -1),
+		Regexp("a("),
+		Prefix("a").And(nil),
+		Prefix("a").Or(Exact("b").And(Regexp(`\b`))),
+	}
+	for _, q := range tests {
+		_, err := q.Compile()
+		if err == nil {
+			t.Errorf("expected error compiling %s", q)
+		} else if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected ErrInvalidQuery compiling %s, got %v", q, err)
+		}
+	}
+}
+
+func TestQueryString(t *testing.T) {
+	q := Prefix("foo").And(Fuzzy("bar", 1)).Or(Regexp("ba+z"))
+	want := `((prefix("foo") AND fuzzy("bar", 1)) OR regexp("ba+z"))`
+	if q.String() != want {
+		t.Errorf("expected %s, got %s", want, q.String())
+	}
+}
@@ -0,0 +1,141 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "bytes"
+
+// PrefixAutomaton returns an Automaton matching the keys starting with the
+// prefix.  Searching an FST with it only visits the keys with the prefix,
+// as it also bounds the search, see RangeAutomaton.
+func PrefixAutomaton(prefix []byte) Automaton {
+	return RangeAutomaton(prefix, PrefixSuccessor(prefix))
+}
+
+// RangeAutomaton returns an Automaton matching the keys with
+// startKeyInclusive <= key < endKeyExclusive, a nil endKeyExclusive
+// meaning no upper bound.  When an FST is searched with it, the bounds of
+// the search are narrowed to the range, so the Iterator seeks straight to
+// its first key and stops at its end, rather than walking the keys outside
+// it.
+func RangeAutomaton(startKeyInclusive, endKeyExclusive []byte) Automaton {
+	return &rangeAutomaton{
+		start: append([]byte(nil), startKeyInclusive...),
+		end:   append([]byte(nil), endKeyExclusive...),
+		open:  endKeyExclusive == nil,
+	}
+}
+
+// rangeAutomaton states, 1 is avoided as AutomatonContains treats it as
+// the noneAddr.  Once a key is strictly within the range, every extension
+// of it is, so the automaton only tracks the bytes read while the key is
+// still a prefix of one of the bounds, in the state
+// rangeTight + 3*i + rangeTightStart/End/Both.
+const (
+	rangeDead   = 0
+	rangeInside = 2
+	rangeTight  = 3
+
+	rangeTightBoth  = 0
+	rangeTightStart = 1
+	rangeTightEnd   = 2
+)
+
+type rangeAutomaton struct {
+	start, end []byte
+	// open is true if there is no end bound
+	open bool
+}
+
+// tight returns the state after i bytes, still a prefix of the start key if
+// tightStart, and of the end key if tightEnd
+func (r *rangeAutomaton) tight(i int, tightStart, tightEnd bool) int {
+	// the key reaching the end of the start bound is within the range, but
+	// reaching the end of the end bound, it is past it
+	if tightStart && i == len(r.start) {
+		tightStart = false
+	}
+	if tightEnd && i == len(r.end) {
+		return rangeDead
+	}
+	switch {
+	case tightStart && tightEnd:
+		return rangeTight + 3*i + rangeTightBoth
+	case tightStart:
+		return rangeTight + 3*i + rangeTightStart
+	case tightEnd:
+		return rangeTight + 3*i + rangeTightEnd
+	}
+	return rangeInside
+}
+
+func (r *rangeAutomaton) Start() int {
+	return r.tight(0, true, !r.open)
+}
+
+func (r *rangeAutomaton) IsMatch(s int) bool {
+	if s < rangeTight {
+		return s == rangeInside
+	}
+	// a key which is a strict prefix of the end bound is before it
+	return (s-rangeTight)%3 == rangeTightEnd
+}
+
+func (r *rangeAutomaton) CanMatch(s int) bool {
+	return s != rangeDead
+}
+
+func (r *rangeAutomaton) WillAlwaysMatch(s int) bool {
+	return s == rangeInside
+}
+
+func (r *rangeAutomaton) Accept(s int, b byte) int {
+	if s < rangeTight {
+		return s
+	}
+	i, kind := (s-rangeTight)/3, (s-rangeTight)%3
+	tightStart := kind != rangeTightEnd
+	tightEnd := kind != rangeTightStart
+	if tightStart {
+		if b < r.start[i] {
+			return rangeDead
+		}
+		tightStart = b == r.start[i]
+	}
+	if tightEnd {
+		if b > r.end[i] {
+			return rangeDead
+		}
+		tightEnd = b == r.end[i]
+	}
+	return r.tight(i+1, tightStart, tightEnd)
+}
+
+// automatonBounds returns the bounds of a search with the automaton,
+// narrowed to the range of a RangeAutomaton
+func automatonBounds(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) ([]byte, []byte) {
+	r, ok := aut.(*rangeAutomaton)
+	if !ok {
+		return startKeyInclusive, endKeyExclusive
+	}
+	if bytes.Compare(r.start, startKeyInclusive) > 0 {
+		startKeyInclusive = r.start
+	}
+	if !r.open && (endKeyExclusive == nil ||
+		bytes.Compare(r.end, endKeyExclusive) < 0) {
+		endKeyExclusive = r.end
+	}
+	return startKeyInclusive, endKeyExclusive
+}
@@ -0,0 +1,127 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRangeAutomaton(t *testing.T) {
+	// every key of up to 3 bytes of the alphabet
+	keys := []string{""}
+	for i := 0; i < len(keys); i++ {
+		if len(keys[i]) < 3 {
+			for _, b := range []byte("ab\xff") {
+				keys = append(keys, keys[i]+string([]byte{b}))
+			}
+		}
+	}
+	sort.Strings(keys)
+	var kvs []KV
+	for _, key := range keys {
+		kvs = append(kvs, KV{key, uint64(len(key))})
+	}
+	fst := buildKVs(t, kvs...)
+
+	inRange := func(start, end []byte) func([]byte) bool {
+		return func(key []byte) bool {
+			return bytes.Compare(key, start) >= 0 &&
+				(end == nil || bytes.Compare(key, end) < 0)
+		}
+	}
+	tests := []struct {
+		desc       string
+		aut        Automaton
+		start, end []byte
+		match      func([]byte) bool
+	}{
+		{
+			desc:  "prefix",
+			aut:   PrefixAutomaton([]byte("ab")),
+			match: func(key []byte) bool { return bytes.HasPrefix(key, []byte("ab")) },
+		},
+		{
+			desc:  "empty prefix",
+			aut:   PrefixAutomaton(nil),
+			match: func([]byte) bool { return true },
+		},
+		{
+			desc: "0xff prefix",
+			aut:  PrefixAutomaton([]byte("a\xff")),
+			match: func(key []byte) bool {
+				return bytes.HasPrefix(key, []byte("a\xff"))
+			},
+		},
+		{
+			desc:  "range",
+			aut:   RangeAutomaton([]byte("ab"), []byte("b\xffa")),
+			match: inRange([]byte("ab"), []byte("b\xffa")),
+		},
+		{
+			desc:  "no end",
+			aut:   RangeAutomaton([]byte("ba"), nil),
+			match: inRange([]byte("ba"), nil),
+		},
+		{
+			desc:  "empty end",
+			aut:   RangeAutomaton(nil, []byte{}),
+			match: func([]byte) bool { return false },
+		},
+		{
+			desc:  "bounded search",
+			aut:   RangeAutomaton([]byte("a"), []byte("bb")),
+			start: []byte("ab"),
+			end:   []byte("c"),
+			match: inRange([]byte("ab"), []byte("bb")),
+		},
+		{
+			desc:  "disjoint search",
+			aut:   PrefixAutomaton([]byte("a")),
+			start: []byte("b"),
+			match: func([]byte) bool { return false },
+		},
+	}
+	for _, test := range tests {
+		var want, got []string
+		for _, key := range keys {
+			if test.start == nil && test.end == nil &&
+				AutomatonContains(test.aut, []byte(key)) != test.match([]byte(key)) {
+				t.Errorf("%s: expected contains %q to be %t", test.desc, key,
+					test.match([]byte(key)))
+			}
+		}
+		for _, kv := range kvs {
+			if test.match([]byte(kv.Key)) {
+				want = append(want, kv.Key)
+			}
+		}
+		itr, err := fst.Search(test.aut, test.start, test.end)
+		for err == nil {
+			key, _ := itr.Current()
+			got = append(got, string(key))
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("%s: error searching: %v", test.desc, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %q, got %q", test.desc, want, got)
+		}
+	}
+}
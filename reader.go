@@ -0,0 +1,93 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "sync/atomic"
+
+// Reader holds the scratch state of the queries of a single goroutine,
+// the decoded state of lookups and an Iterator, so that it doesn't
+// allocate them again for each query.  The FST is shared: any number of
+// goroutines may search it, each with its own Reader, without locking.
+//
+// A Reader must not be used by several goroutines at the same time, which
+// it detects, and panics, as the results would be corrupted.
+type Reader struct {
+	f        *FST
+	prealloc fstStateV1
+	itr      *FSTIterator
+	// busy is set during the calls, to detect concurrent use
+	busy int32
+}
+
+// Reader returns a new Reader of the FST, for use by a single goroutine.
+func (f *FST) Reader() (*Reader, error) {
+	return &Reader{f: f}, nil
+}
+
+func (r *Reader) acquire() {
+	if !atomic.CompareAndSwapInt32(&r.busy, 0, 1) {
+		panic("vellum: concurrent use of a Reader")
+	}
+}
+
+func (r *Reader) release() {
+	atomic.StoreInt32(&r.busy, 0)
+}
+
+// Get returns the value associated with the key, as FST.Get does.
+func (r *Reader) Get(input []byte) (uint64, bool, error) {
+	r.acquire()
+	defer r.release()
+	return r.f.get(input, &r.prealloc)
+}
+
+// Contains returns true if the FST contains the specified key.
+func (r *Reader) Contains(key []byte) (bool, error) {
+	_, exists, err := r.Get(key)
+	return exists, err
+}
+
+// Iterator returns an Iterator over the key/value pairs between the
+// provided startKeyInclusive and endKeyExclusive, as FST.Iterator does.
+// The Iterator is reused by the next call to Iterator or Search of the
+// Reader, which invalidates it.
+func (r *Reader) Iterator(startKeyInclusive,
+	endKeyExclusive []byte) (*FSTIterator, error) {
+	return r.Search(nil, startKeyInclusive, endKeyExclusive)
+}
+
+// Search returns an Iterator over the key/value pairs between the provided
+// startKeyInclusive and endKeyExclusive that also satisfy the provided
+// automaton, as FST.Search does.  The Iterator is reused by the next call
+// to Iterator or Search of the Reader, which invalidates it.
+func (r *Reader) Search(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) (*FSTIterator, error) {
+	r.acquire()
+	defer r.release()
+	startKeyInclusive, endKeyExclusive = automatonBounds(aut,
+		startKeyInclusive, endKeyExclusive)
+	err := emptySearch(r.f, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		return nil, err
+	}
+	if r.itr == nil {
+		r.itr = &FSTIterator{}
+	}
+	err = r.itr.Reset(r.f, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		return nil, err
+	}
+	return r.itr, nil
+}
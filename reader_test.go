@@ -0,0 +1,139 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReaderConcurrent(t *testing.T) {
+	fst, err := Load(buildWordsSample(t), WithNodeCache(4<<10),
+		WithGetCache(4<<10), WithIteratorPrefetch(8))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	want := map[string]uint64{}
+	itr, err := fst.Iterator(nil, nil)
+	for err == nil {
+		key, val := itr.Current()
+		want[string(key)] = val
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone {
+		t.Fatalf("error iterating: %v", err)
+	}
+
+	const goroutines = 8
+	errs := make(chan string, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			r, err := fst.Reader()
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			for i := g; i < len(thousandTestWords); i += goroutines {
+				word := thousandTestWords[i]
+				val, exists, err := r.Get([]byte(word))
+				if err != nil || !exists || val != want[word] {
+					errs <- "get " + word
+					return
+				}
+			}
+			itr, err := r.Iterator(nil, nil)
+			n := 0
+			for err == nil {
+				key, val := itr.Current()
+				if val != want[string(key)] {
+					errs <- "iterate " + string(key)
+					return
+				}
+				n++
+				err = itr.Next()
+			}
+			if err != ErrIteratorDone || n != len(want) {
+				errs <- "iterate"
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Errorf("unexpected result: %s", msg)
+	}
+}
+
+func TestReaderIterator(t *testing.T) {
+	fst, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	r, err := fst.Reader()
+	if err != nil {
+		t.Fatalf("error creating reader: %v", err)
+	}
+	itr, err := r.Iterator([]byte("a"), []byte("b"))
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	key, _ := itr.Current()
+	if key[0] != 'a' {
+		t.Errorf("expected a key starting with a, got %q", key)
+	}
+	// the iterator of the reader is reused
+	itr2, err := r.Iterator([]byte("c"), nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	if itr2 != itr {
+		t.Errorf("expected the iterator to be reused")
+	}
+	key, _ = itr2.Current()
+	if key[0] != 'c' {
+		t.Errorf("expected a key starting with c, got %q", key)
+	}
+	_, err = r.Iterator([]byte("b"), []byte("a"))
+	if err != ErrIteratorDone {
+		t.Errorf("expected end bound error, got %v", err)
+	}
+	exists, err := r.Contains([]byte(thousandTestWords[0]))
+	if err != nil || !exists {
+		t.Errorf("expected %q to exist, got %t %v", thousandTestWords[0],
+			exists, err)
+	}
+}
+
+func TestReaderConcurrentUsePanics(t *testing.T) {
+	fst, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	r, err := fst.Reader()
+	if err != nil {
+		t.Fatalf("error creating reader: %v", err)
+	}
+	// as if another goroutine were in the middle of a call
+	r.acquire()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+	_, _, _ = r.Get([]byte("a"))
+}
@@ -0,0 +1,301 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// readerAtBlockSize is the size of the blocks of states read through an
+// io.ReaderAt at once, and cached
+const readerAtBlockSize = 64 << 10
+
+// OpenReaderAt opens the FST of the provided size read through r, without
+// memory mapping it, for platforms without mmap or files on network
+// filesystems.  The footer and the optional sections are read when it is
+// opened, and the states in blocks as they are needed, which are cached
+// (see WithBlockCache).  r must stay open and unchanged until the FST is
+// closed, and isn't closed with it.
+//
+// Compressed FSTs aren't supported, nor are WithPartialData and
+// WithMutationCheck.  Verifying checksums reads all of the data.
+func OpenReaderAt(r io.ReaderAt, size int64, opts ...OpenOption) (*FST,
+	error) {
+	o := applyOpenOptions(opts)
+	if o.partial != nil {
+		return nil, fmt.Errorf("partial data not supported with a reader")
+	}
+	if o.mutationCheck {
+		return nil, fmt.Errorf("data read through a reader can't be checked " +
+			"for mutations")
+	}
+	if size < 0 || size > 1<<62 {
+		return nil, fmt.Errorf("invalid fst size %d", size)
+	}
+	rv := &FST{
+		drained: make(chan struct{}, 1),
+	}
+	d := &readerAtDecoder{
+		r:     r,
+		size:  int(size),
+		cache: newBlockCache(o.blockCacheBudget),
+	}
+	var err error
+	d.header, err = d.read(0, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	if isCompressed(d.header) {
+		return nil, fmt.Errorf("compressed fst not supported with a reader")
+	}
+	rv.ver, rv.typ, err = decodeHeader(d.header)
+	if err != nil {
+		return nil, err
+	}
+	if rv.ver != versionV1 && rv.ver != versionV2 {
+		return nil, fmt.Errorf("reading version %d not supported", rv.ver)
+	}
+	d.dense = rv.ver == versionV2
+	d.typ = rv.typ
+	err = d.readTail()
+	if err != nil {
+		return nil, err
+	}
+	rv.decoder = d
+	err = rv.load(o)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// readAll returns all of the data of the FST, read in full if it was opened
+// with OpenReaderAt, or a RangeUnavailableError if it was opened with
+// partial data, and some of it is missing
+func (f *FST) readAll() ([]byte, error) {
+	switch d := f.decoder.(type) {
+	case *readerAtDecoder:
+		return d.read(0, d.size)
+	case *partialDecoder:
+		err := d.available.check(0, len(d.data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f.data, nil
+}
+
+// readerAtDecoder decodes the states of an FST from the blocks read
+// through an io.ReaderAt, and the rest from the tail, read when it is
+// opened
+type readerAtDecoder struct {
+	r     io.ReaderAt
+	size  int
+	typ   int
+	dense bool
+
+	header []byte
+	// tail is the data from the end of the states, sections, section table
+	// and footer
+	tail      []byte
+	statesEnd int
+	sections  map[int][]byte
+	cache     *blockCache
+}
+
+// read returns the n bytes at offset off
+func (d *readerAtDecoder) read(off, n int) ([]byte, error) {
+	if off+n > d.size {
+		return nil, corruptf(d.size, "data too short to read %d bytes at %d",
+			n, off)
+	}
+	rv := make([]byte, n)
+	m, err := d.r.ReadAt(rv, int64(off))
+	if m == n {
+		// a full read may report io.EOF at the end of the data
+		return rv, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, fmt.Errorf("error reading fst at %d: %w", off+m, err)
+}
+
+// readTail reads the footer, and the section table and sections before it,
+// which the states end at
+func (d *readerAtDecoder) readTail() error {
+	if d.size < headerSize+footerSizeV1 {
+		return corruptf(d.size, "data too short for header and footer")
+	}
+	tailStart := d.size - footerSizeV1
+	if d.typ&typeSections != 0 {
+		if tailStart < headerSize+8 {
+			return corruptf(tailStart, "data too short for section table")
+		}
+		buf, err := d.read(tailStart-8, 8)
+		if err != nil {
+			return err
+		}
+		n := binary.LittleEndian.Uint64(buf)
+		if n > uint64(tailStart-8-headerSize)/sectionEntrySize {
+			return corruptf(tailStart-8, "invalid number of sections %d", n)
+		}
+		tableStart := tailStart - 8 - int(n)*sectionEntrySize
+		table, err := d.read(tableStart, int(n)*sectionEntrySize)
+		if err != nil {
+			return err
+		}
+		tailStart = tableStart
+		for i := 0; i < int(n); i++ {
+			s := getSectionEntry(table[i*sectionEntrySize:])
+			if s.offset >= headerSize && s.offset < uint64(tailStart) {
+				tailStart = int(s.offset)
+			}
+		}
+	}
+	var err error
+	d.tail, err = d.read(tailStart, d.size-tailStart)
+	if err != nil {
+		return err
+	}
+	d.statesEnd = tailStart
+	return nil
+}
+
+func (d *readerAtDecoder) getRoot() int {
+	if len(d.tail) < footerSizeV1 {
+		return noneAddr
+	}
+	footer := d.tail[len(d.tail)-footerSizeV1:]
+	return int(binary.LittleEndian.Uint64(footer[8:]))
+}
+
+func (d *readerAtDecoder) getLen() int {
+	if len(d.tail) < footerSizeV1 {
+		return 0
+	}
+	footer := d.tail[len(d.tail)-footerSizeV1:]
+	return int(binary.LittleEndian.Uint64(footer))
+}
+
+// validate checks the footer and the section table, which are in the tail,
+// at offsets relative to the end of the states
+func (d *readerAtDecoder) validate() error {
+	footerStart := len(d.tail) - footerSizeV1
+	n := binary.LittleEndian.Uint64(d.tail[footerStart:])
+	if n > maxLen {
+		return corruptf(d.statesEnd+footerStart, "invalid length %d", n)
+	}
+	if d.typ&typeSections != 0 {
+		err := d.parseSections(footerStart)
+		if err != nil {
+			return err
+		}
+	}
+	root := d.getRoot()
+	if root != emptyAddr && root != noneAddr &&
+		(root < headerSize || root >= d.statesEnd) {
+		return corruptf(d.statesEnd+footerStart+8, "invalid root address %d",
+			root)
+	}
+	_, err := d.stateAt(root, nil)
+	return err
+}
+
+func (d *readerAtDecoder) parseSections(end int) error {
+	n := binary.LittleEndian.Uint64(d.tail[end-8:])
+	tableStart := end - 8 - int(n)*sectionEntrySize
+	d.sections = make(map[int][]byte, n)
+	for i := 0; i < int(n); i++ {
+		entryStart := tableStart + i*sectionEntrySize
+		s := getSectionEntry(d.tail[entryStart:])
+		offset := s.offset - uint64(d.statesEnd)
+		if s.offset < uint64(d.statesEnd) || offset > uint64(tableStart) ||
+			s.length > uint64(tableStart)-offset {
+			return corruptf(d.statesEnd+entryStart,
+				"invalid section %d at %d length %d", s.id, s.offset, s.length)
+		}
+		d.sections[int(s.id)] = d.tail[offset : offset+s.length]
+	}
+	return nil
+}
+
+func (d *readerAtDecoder) sizes() Sizes {
+	rv := Sizes{
+		Total:  d.size,
+		Header: headerSize,
+		Other:  footerSizeV1,
+	}
+	if d.sections != nil {
+		rv.Other += 8 + len(d.sections)*sectionEntrySize
+	}
+	var sections int
+	rv.Sections, sections = sectionSizes(d.sections)
+	rv.States = rv.Total - rv.Header - rv.Other - sections
+	return rv
+}
+
+func (d *readerAtDecoder) section(id int) []byte {
+	return d.sections[id]
+}
+
+func (d *readerAtDecoder) stateAt(addr int, prealloc fstState) (fstState, error) {
+	state, ok := prealloc.(*fstStateV1)
+	if ok && state != nil {
+		*state = fstStateV1{allowDense: d.dense} // clear the struct
+	} else {
+		state = &fstStateV1{allowDense: d.dense}
+	}
+	if addr == emptyAddr || addr == noneAddr {
+		return state, state.at(nil, addr)
+	}
+	if addr < headerSize || addr >= d.statesEnd {
+		return nil, corruptf(addr, "invalid address %d/%d", addr, d.statesEnd)
+	}
+	block, start, err := d.block(addr / readerAtBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	err = state.atWindow(block, start, addr)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// block returns block i, from the cache if possible, and its start address.
+// States are decoded from their address down, so each block also holds
+// the largest state which can start at the top of the previous one.
+func (d *readerAtDecoder) block(i int) ([]byte, int, error) {
+	start := i*readerAtBlockSize - maxStateSize
+	if start < 0 {
+		start = 0
+	}
+	if rv := d.cache.lookup(i); rv != nil {
+		return rv, start, nil
+	}
+	end := (i + 1) * readerAtBlockSize
+	if end > d.statesEnd {
+		end = d.statesEnd
+	}
+	rv, err := d.read(start, end-start)
+	if err != nil {
+		return nil, 0, err
+	}
+	d.cache.add(i, rv)
+	return rv, start, nil
+}
@@ -0,0 +1,137 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestOpenReaderAt(t *testing.T) {
+	var keys []string
+	for i := 0; i < 30000; i++ {
+		keys = append(keys, fmt.Sprintf("%08x/%d", i*7919, i%13))
+	}
+	for _, opts := range [][]BuilderOption{
+		{WithVersion(versionV1)},
+		{WithVersion(versionV2), WithSubtreeCounts(), WithChecksums()},
+	} {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		for i, key := range keys {
+			err = b.Insert([]byte(key), uint64(i))
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		data := buf.Bytes()
+		loaded, err := Load(data)
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+
+		// a small cache, so blocks are evicted and read again
+		fst, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)),
+			WithBlockCache(2*readerAtBlockSize))
+		if err != nil {
+			t.Fatalf("error opening: %v", err)
+		}
+		if fst.Len() != len(keys) {
+			t.Errorf("expected %d keys, got %d", len(keys), fst.Len())
+		}
+		if got, want := fst.Sizes(), loaded.Sizes(); !reflect.DeepEqual(got,
+			want) {
+			t.Errorf("expected sizes %+v, got %+v", want, got)
+		}
+		for i := len(keys) - 1; i >= 0; i-- {
+			val, exists, err := fst.Get([]byte(keys[i]))
+			if err != nil || !exists || val != uint64(i) {
+				t.Fatalf("expected %q %d, got %d %t %v", keys[i], i, val,
+					exists, err)
+			}
+		}
+		n := 0
+		itr, err := fst.Iterator(nil, nil)
+		for err == nil {
+			n++
+			err = itr.Next()
+		}
+		if err != ErrIteratorDone || n != len(keys) {
+			t.Errorf("expected to iterate %d keys, got %d %v", len(keys), n, err)
+		}
+		if fst.BlockCacheStats().Evictions == 0 {
+			t.Errorf("expected blocks to be evicted, got %+v",
+				fst.BlockCacheStats())
+		}
+		hash, err := fst.ContentHash()
+		if err != nil {
+			t.Errorf("error hashing: %v", err)
+		}
+		if want, _ := loaded.ContentHash(); hash != want {
+			t.Errorf("expected the content hash of the loaded fst")
+		}
+		err = fst.VerifyChecksums()
+		if err != nil {
+			t.Errorf("error verifying checksums: %v", err)
+		}
+		err = fst.Close()
+		if err != nil {
+			t.Errorf("error closing: %v", err)
+		}
+	}
+}
+
+func TestOpenReaderAtErrors(t *testing.T) {
+	data := buildWordsWithCounts(t)
+
+	// the reader is shorter than the size provided
+	_, err := OpenReaderAt(bytes.NewReader(data[:len(data)-1]),
+		int64(len(data)))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+	_, err = OpenReaderAt(bytes.NewReader(data), headerSize)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected corruption, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	b, err := New(&buf, WithCompression("flate"))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, randomValues(thousandTestWords))
+	if err == nil {
+		err = b.Close()
+	}
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	_, err = OpenReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err == nil {
+		t.Errorf("expected compressed fst to be rejected")
+	}
+}
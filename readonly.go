@@ -0,0 +1,53 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+// ErrDataModified is returned by CheckUnmodified when the data backing an
+// FST opened with WithMutationCheck has changed since it was opened.
+var ErrDataModified = errors.New("fst data modified while in use")
+
+// WithMutationCheck enables a debug mode protecting against memory
+// corruption bugs silently altering the data backing the FST.
+//
+// Files opened with Open() are always mapped read-only.  In this mode,
+// data passed to Load() is first copied into a read-only mapping (on
+// platforms which support it), so that any attempt to write to it faults
+// immediately.  Additionally, a checksum of the data is recorded, and
+// verified by CheckUnmodified() and Close(), which panics if the data
+// was modified.
+func WithMutationCheck() OpenOption {
+	return func(o *openOpts) {
+		o.mutationCheck = true
+	}
+}
+
+func dataChecksum(data []byte) uint32 {
+	return crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+}
+
+// CheckUnmodified verifies the data backing this FST has not changed since
+// it was opened, returning ErrDataModified if it has.  It always returns
+// nil unless the FST was opened with WithMutationCheck.
+func (f *FST) CheckUnmodified() error {
+	if f.mutationCheck && dataChecksum(f.data) != f.checksum {
+		return ErrDataModified
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nommap
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package vellum
+
+import (
+	"io"
+	"syscall"
+
+	mmap "github.com/edsrzf/mmap-go"
+)
+
+// readOnlyProtectAvailable is true when readOnlyCopy protects the data
+// against writes
+const readOnlyProtectAvailable = true
+
+type protectedData struct {
+	mm mmap.MMap
+}
+
+func (p *protectedData) Close() error {
+	return p.mm.Unmap()
+}
+
+// readOnlyCopy copies data into an anonymous mapping which is then
+// protected against writes.  The returned Closer unmaps the copy.
+func readOnlyCopy(data []byte) ([]byte, io.Closer, error) {
+	if len(data) == 0 {
+		return data, nil, nil
+	}
+	mm, err := mmap.MapRegion(nil, len(data), mmap.RDWR, mmap.ANON, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	copy(mm, data)
+	err = syscall.Mprotect(mm, syscall.PROT_READ)
+	if err != nil {
+		_ = mm.Unmap()
+		return nil, nil, err
+	}
+	return mm, &protectedData{mm: mm}, nil
+}
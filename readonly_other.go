@@ -0,0 +1,31 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build nommap !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package vellum
+
+import "io"
+
+// readOnlyProtectAvailable is false when readOnlyCopy only copies the data
+const readOnlyProtectAvailable = false
+
+// readOnlyCopy can't protect the data on this platform, or with the nommap
+// build tag, a private copy is used instead, so that writes through the
+// slice passed to Load still don't affect the FST, as with a read-only
+// mapping.  Other stray writes are only caught by the checksum verification
+// of WithMutationCheck.
+func readOnlyCopy(data []byte) ([]byte, io.Closer, error) {
+	return append([]byte(nil), data...), nil, nil
+}
@@ -0,0 +1,88 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"testing"
+)
+
+func buildSmallSample(t *testing.T) []byte {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStringMap(b, smallSample)
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("err closing: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadWithMutationCheck(t *testing.T) {
+	data := buildSmallSample(t)
+
+	fst, err := Load(data, WithMutationCheck())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	for k, v := range smallSample {
+		val, ok, err := fst.Get([]byte(k))
+		if err != nil || !ok || val != v {
+			t.Errorf("expected %s to have value %d, got %d (%t) %v", k, v,
+				val, ok, err)
+		}
+	}
+
+	// mutating the original slice must not affect the loaded FST
+	for i := range data {
+		data[i] = 0
+	}
+	err = fst.CheckUnmodified()
+	if err != nil {
+		t.Errorf("expected unmodified, got %v", err)
+	}
+	err = fst.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+}
+
+func TestMutationDetected(t *testing.T) {
+	data := buildSmallSample(t)
+
+	// use the data as is, simulating a platform without read-only mappings
+	fst, err := new(data, nil, &openOpts{mutationCheck: true})
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	data[len(data)/2]++
+	if fst.CheckUnmodified() != ErrDataModified {
+		t.Errorf("expected ErrDataModified")
+	}
+
+	defer func() {
+		r := recover()
+		if r != ErrDataModified {
+			t.Errorf("expected Close to panic with ErrDataModified, got %v", r)
+		}
+	}()
+	_ = fst.Close()
+}
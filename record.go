@@ -0,0 +1,164 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrRecordOverflow is returned when a field value doesn't fit in its bits,
+// or a value has bits set beyond those of the record.
+var ErrRecordOverflow = errors.New("record value overflow")
+
+// ErrRecordSchema is returned for an invalid record schema.
+var ErrRecordSchema = errors.New("invalid record schema")
+
+// RecordField is a field of a record, an unsigned integer of Bits bits.
+type RecordField struct {
+	Name string
+	Bits int
+}
+
+// RecordCodec packs records of small unsigned integer fields into values,
+// such as an offset of 48 bits and flags of 16 bits, checking that every
+// field fits.  The first field is in the most significant of the bits
+// used, so values sort as their records do, field by field.
+type RecordCodec struct {
+	fields []RecordField
+	shifts []uint
+	bits   int
+}
+
+// NewRecordCodec returns a RecordCodec for records of the fields, at most
+// 64 bits in all.
+func NewRecordCodec(fields ...RecordField) (*RecordCodec, error) {
+	rv := &RecordCodec{
+		fields: append([]RecordField(nil), fields...),
+		shifts: make([]uint, len(fields)),
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: no fields", ErrRecordSchema)
+	}
+	names := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		if field.Bits <= 0 {
+			return nil, fmt.Errorf("%w: field %q has %d bits", ErrRecordSchema,
+				field.Name, field.Bits)
+		}
+		if _, ok := names[field.Name]; ok && field.Name != "" {
+			return nil, fmt.Errorf("%w: duplicate field %q", ErrRecordSchema,
+				field.Name)
+		}
+		names[field.Name] = struct{}{}
+		rv.bits += field.Bits
+	}
+	if rv.bits > 64 {
+		return nil, fmt.Errorf("%w: %d bits, more than 64", ErrRecordSchema,
+			rv.bits)
+	}
+	shift := uint(rv.bits)
+	for i, field := range fields {
+		shift -= uint(field.Bits)
+		rv.shifts[i] = shift
+	}
+	return rv, nil
+}
+
+// ParseRecordCodec returns a RecordCodec for a schema listing the fields
+// with their bits, such as "offset:48,flags:16".
+func ParseRecordCodec(schema string) (*RecordCodec, error) {
+	var fields []RecordField
+	for _, part := range strings.Split(schema, ",") {
+		i := strings.LastIndexByte(part, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("%w: field %q without bits", ErrRecordSchema,
+				part)
+		}
+		bits, err := strconv.Atoi(strings.TrimSpace(part[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: field %q: %v", ErrRecordSchema, part, err)
+		}
+		fields = append(fields, RecordField{
+			Name: strings.TrimSpace(part[:i]),
+			Bits: bits,
+		})
+	}
+	return NewRecordCodec(fields...)
+}
+
+// Fields returns the fields of the records.
+func (c *RecordCodec) Fields() []RecordField {
+	return append([]RecordField(nil), c.fields...)
+}
+
+// Bits returns the number of bits of the records.
+func (c *RecordCodec) Bits() int {
+	return c.bits
+}
+
+// Index returns the index of the named field, or -1 if there is none.
+func (c *RecordCodec) Index(name string) int {
+	for i, field := range c.fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *RecordCodec) mask(i int) uint64 {
+	return ^uint64(0) >> uint(64-c.fields[i].Bits)
+}
+
+// Encode packs the values of the fields, in order, into a value.
+// ErrRecordOverflow is returned if one doesn't fit in its bits.
+func (c *RecordCodec) Encode(vals ...uint64) (uint64, error) {
+	if len(vals) != len(c.fields) {
+		return 0, fmt.Errorf("record of %d fields encoded with %d values",
+			len(c.fields), len(vals))
+	}
+	var rv uint64
+	for i, val := range vals {
+		if val > c.mask(i) {
+			return 0, fmt.Errorf("%w: field %q value %d exceeds %d bits",
+				ErrRecordOverflow, c.fields[i].Name, val, c.fields[i].Bits)
+		}
+		rv |= val << c.shifts[i]
+	}
+	return rv, nil
+}
+
+// Decode appends the values of the fields packed into val to dst, and
+// returns the extended slice.  ErrRecordOverflow is returned if val has
+// bits set beyond those of the records, as it wasn't encoded by the codec.
+func (c *RecordCodec) Decode(val uint64, dst []uint64) ([]uint64, error) {
+	if c.bits < 64 && val>>uint(c.bits) != 0 {
+		return dst, fmt.Errorf("%w: %#x has more than %d bits",
+			ErrRecordOverflow, val, c.bits)
+	}
+	for i := range c.fields {
+		dst = append(dst, c.Field(val, i))
+	}
+	return dst, nil
+}
+
+// Field returns the value of the i-th field packed into val, without
+// checking val.
+func (c *RecordCodec) Field(val uint64, i int) uint64 {
+	return val >> c.shifts[i] & c.mask(i)
+}
@@ -0,0 +1,103 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRecordCodec(t *testing.T) {
+	c, err := ParseRecordCodec("offset:48, flags:16")
+	if err != nil {
+		t.Fatalf("error parsing schema: %v", err)
+	}
+	if c.Bits() != 64 || c.Index("flags") != 1 || c.Index("size") != -1 {
+		t.Errorf("unexpected codec %+v", c.Fields())
+	}
+	val, err := c.Encode(1<<48-1, 7)
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	if val != 0xffffffffffff0007 {
+		t.Errorf("expected offset in the high bits, got %#x", val)
+	}
+	vals, err := c.Decode(val, nil)
+	if err != nil || !reflect.DeepEqual(vals, []uint64{1<<48 - 1, 7}) {
+		t.Errorf("expected decoded record, got %v %v", vals, err)
+	}
+	_, err = c.Encode(1<<48, 0)
+	if !errors.Is(err, ErrRecordOverflow) {
+		t.Errorf("expected overflow of offset, got %v", err)
+	}
+	_, err = c.Encode(1)
+	if err == nil {
+		t.Errorf("expected error with too few values")
+	}
+
+	// records of fewer bits sort as their fields
+	pair, err := NewRecordCodec(RecordField{"a", 20}, RecordField{"b", 12})
+	if err != nil {
+		t.Fatalf("error creating codec: %v", err)
+	}
+	x, _ := pair.Encode(1, 4095)
+	y, _ := pair.Encode(2, 0)
+	if x >= y || pair.Field(y, 0) != 2 || pair.Field(x, 1) != 4095 {
+		t.Errorf("expected records ordered by field, got %#x %#x", x, y)
+	}
+	_, err = pair.Decode(1<<32, nil)
+	if !errors.Is(err, ErrRecordOverflow) {
+		t.Errorf("expected overflow decoding, got %v", err)
+	}
+
+	for _, schema := range []string{"", "a", "a:0", "a:x", "a:32,a:8",
+		"a:40,b:30"} {
+		_, err = ParseRecordCodec(schema)
+		if !errors.Is(err, ErrRecordSchema) {
+			t.Errorf("%q: expected schema error, got %v", schema, err)
+		}
+	}
+}
+
+func TestRecordValues(t *testing.T) {
+	c, err := ParseRecordCodec("doc:32,freq:32")
+	if err != nil {
+		t.Fatalf("error parsing schema: %v", err)
+	}
+	var kvs []KV
+	for i, word := range thousandTestWords {
+		val, err := c.Encode(uint64(i), uint64(len(word)))
+		if err != nil {
+			t.Fatalf("error encoding: %v", err)
+		}
+		kvs = append(kvs, KV{Key: word, Val: val})
+	}
+	fst := buildKVs(t, kvs...)
+	var rec []uint64
+	for i, word := range thousandTestWords {
+		val, exists, err := fst.Get([]byte(word))
+		if err != nil || !exists {
+			t.Fatalf("expected %s, got %t %v", word, exists, err)
+		}
+		rec, err = c.Decode(val, rec[:0])
+		if err != nil {
+			t.Fatalf("error decoding: %v", err)
+		}
+		if rec[0] != uint64(i) || rec[1] != uint64(len(word)) {
+			t.Errorf("%s: unexpected record %v", word, rec)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// SkippedRange is a range of keys a RecoverIterator could not read.
+type SkippedRange struct {
+	// Start is the first key of the range, inclusive
+	Start []byte
+	// End is the end of the range, exclusive, nil if the range extends to
+	// the end of the keys
+	End []byte
+	// Err is the error which caused the range to be skipped
+	Err error
+}
+
+// RecoverIterator enumerates the keys of a damaged FST, on a best-effort
+// basis, to salvage as much of it as possible.  When a state can't be
+// decoded, all the keys starting with the path leading to it are skipped,
+// and recorded as a SkippedRange, and iteration resumes with the next
+// transition which can be followed.  Transitions which would lead to a
+// cycle, or to keys out of order, are skipped the same way.
+//
+// Damage which still decodes, such as altered outputs, can't be detected,
+// so the keys and values recovered should be checked against other
+// sources where possible.  The recovered keys are in order, so they can be
+// inserted into a Builder to write a new FST.
+type RecoverIterator struct {
+	f       *FST
+	frames  []recoverFrame
+	key     []byte
+	val     uint64
+	skipped []SkippedRange
+}
+
+type recoverFrame struct {
+	state fstState
+	// next is the position of the next transition to follow
+	next int
+	// label is that of the last transition followed, -1 if none
+	label int
+	// total is the output accumulated up to the state
+	total uint64
+}
+
+// RecoverIterator returns a RecoverIterator positioned at the first key
+// which can be read, or ErrIteratorDone if there is none.  An error is
+// returned if the root state can't be decoded.
+func (f *FST) RecoverIterator() (*RecoverIterator, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	root, err := f.decoder.stateAt(f.decoder.getRoot(), nil)
+	if err != nil {
+		return nil, err
+	}
+	rv := &RecoverIterator{
+		f:      f,
+		frames: []recoverFrame{{state: root, label: -1}},
+	}
+	if root.Final() && rv.setValue(root.FinalOutput()) {
+		return rv, nil
+	}
+	return rv, rv.Next()
+}
+
+// Current returns the key and value currently pointed to by the iterator.
+// The key is only valid until the next call to Next.
+func (i *RecoverIterator) Current() ([]byte, uint64) {
+	return i.key, i.val
+}
+
+// Skipped returns the ranges of keys skipped so far.
+func (i *RecoverIterator) Skipped() []SkippedRange {
+	return i.skipped
+}
+
+// Next advances the iterator to the next key which can be read, or returns
+// ErrIteratorDone if there is none.
+func (i *RecoverIterator) Next() error {
+	if err := i.f.enter(); err != nil {
+		return err
+	}
+	defer i.f.exit()
+	for len(i.frames) > 0 {
+		top := &i.frames[len(i.frames)-1]
+		if top.next >= top.state.NumTransitions() {
+			i.frames = i.frames[:len(i.frames)-1]
+			if len(i.frames) > 0 {
+				i.key = i.key[:len(i.key)-1]
+			}
+			continue
+		}
+		label := top.state.TransitionAt(top.next)
+		top.next++
+		if int(label) <= top.label {
+			i.skip(label, corruptf(top.state.Address(),
+				"transition %d out of order", label))
+			continue
+		}
+		top.label = int(label)
+		_, addr, out := top.state.TransitionFor(label)
+		if addr == noneAddr || (addr != emptyAddr && addr >= top.state.Address()) {
+			// targets are always written before the states leading to them
+			i.skip(label, corruptf(top.state.Address(),
+				"invalid transition target %d", addr))
+			continue
+		}
+		next, err := i.f.decoder.stateAt(addr, nil)
+		if err != nil {
+			i.skip(label, err)
+			continue
+		}
+		total := top.total + out
+		i.frames = append(i.frames, recoverFrame{
+			state: next,
+			label: -1,
+			total: total,
+		})
+		i.key = append(i.key, label)
+		if next.Final() && i.setValue(total+next.FinalOutput()) {
+			return nil
+		}
+	}
+	return ErrIteratorDone
+}
+
+// setValue resolves the value of the current key, skipping it if it
+// can't be resolved
+func (i *RecoverIterator) setValue(out uint64) bool {
+	val, err := i.f.value(out)
+	if err != nil {
+		i.skipped = append(i.skipped, SkippedRange{
+			Start: append([]byte(nil), i.key...),
+			End:   KeySuccessor(i.key),
+			Err:   err,
+		})
+		return false
+	}
+	i.val = val
+	return true
+}
+
+// skip records that the keys starting with the current key followed by
+// label were skipped
+func (i *RecoverIterator) skip(label byte, err error) {
+	start := append(append([]byte(nil), i.key...), label)
+	i.skipped = append(i.skipped, SkippedRange{
+		Start: start,
+		End:   PrefixSuccessor(start),
+		Err:   err,
+	})
+}
+
+// Close will free any resources held by this iterator.
+func (i *RecoverIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"fmt"
+	"unicode"
+	unicode_utf8 "unicode/utf8"
+)
+
+// ErrInvalidSeparator returned when a separator is not an ASCII byte
+var ErrInvalidSeparator = fmt.Errorf("separators must be ASCII bytes")
+
+// the classes of the bytes either side of a position in the key, the start
+// and end of the key are classed as separators
+const (
+	classUnknown = -1
+	classText    = 0
+	classSep     = 1
+)
+
+// assertion is a zero width assertion about the classes of the bytes either
+// side of a position in the key
+type assertion int
+
+const (
+	// assertSegmentStart is (?m)^, preceded by a separator
+	assertSegmentStart assertion = iota
+	// assertSegmentEnd is (?m)$, followed by a separator
+	assertSegmentEnd
+	// assertBoundary is \b, between a separator and a non separator
+	assertBoundary
+	// assertNoBoundary is \B, between two separators or two non separators
+	assertNoBoundary
+)
+
+// check returns whether the assertion holds, and whether that is known yet,
+// as the class of the next byte may not be
+func (a assertion) check(prev, next int) (holds bool, known bool) {
+	if a == assertSegmentStart {
+		return prev == classSep, true
+	}
+	if next == classUnknown {
+		return false, false
+	}
+	switch a {
+	case assertSegmentEnd:
+		return next == classSep, true
+	case assertBoundary:
+		return prev != next, true
+	}
+	return prev == next, true
+}
+
+func (a assertion) String() string {
+	switch a {
+	case assertSegmentStart:
+		return "SEGMENT START"
+	case assertSegmentEnd:
+		return "SEGMENT END"
+	case assertBoundary:
+		return "BOUNDARY"
+	}
+	return "NO BOUNDARY"
+}
+
+// separatorSet returns the table of separators, or nil if there are none.
+func separatorSet(separators []byte) (*[256]bool, error) {
+	if len(separators) == 0 {
+		return nil, nil
+	}
+	var rv [256]bool
+	for _, b := range separators {
+		if b >= unicode_utf8.RuneSelf {
+			return nil, ErrInvalidSeparator
+		}
+		rv[b] = true
+	}
+	return &rv, nil
+}
+
+// anyCharNotSep returns the ranges of runes matched by . when the
+// separators are treated like newlines.
+func anyCharNotSep(seps *[256]bool) []rune {
+	var rv []rune
+	start := rune(0)
+	for b := 0; b < unicode_utf8.RuneSelf; b++ {
+		if b == '\n' || seps[b] {
+			if start < rune(b) {
+				rv = append(rv, start, rune(b)-1)
+			}
+			start = rune(b) + 1
+		}
+	}
+	return append(rv, start, unicode.MaxRune)
+}
@@ -0,0 +1,59 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrNotByte returned in byte mode when a character beyond \xff is used
+var ErrNotByte = fmt.Errorf("characters beyond \\xff are not allowed in byte mode")
+
+// byteRanges returns the pairs of rune ranges clipped to the byte values,
+// dropping those beyond them
+func byteRanges(runes []rune) []rune {
+	var rv []rune
+	for i := 0; i+1 < len(runes); i += 2 {
+		lo, hi := runes[i], runes[i+1]
+		if lo > 0xff {
+			break
+		}
+		if hi > 0xff {
+			hi = 0xff
+		}
+		rv = append(rv, lo, hi)
+	}
+	return rv
+}
+
+// QuoteBytes returns an expression matching exactly the bytes in byte mode
+// (see Opts.Bytes), escaping the bytes which aren't printable ASCII as
+// \xNN, and the metacharacters as regexp.QuoteMeta does.
+func QuoteBytes(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case strings.IndexByte(`\.+*?()|[]{}^$`, c) >= 0:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	return sb.String()
+}
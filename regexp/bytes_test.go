@@ -0,0 +1,103 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		query   string
+		trie    bool
+		match   []string
+		noMatch []string
+	}{
+		{
+			query:   `[\x00-\x1f]+`,
+			match:   []string{"\x00", "\x01\x1f\x10"},
+			noMatch: []string{"", " ", "\x00 "},
+		},
+		{
+			query:   `\x80[\x00-\xff]{2}`,
+			match:   []string{"\x80\x00\xff", "\x80ab"},
+			noMatch: []string{"\u0080\x00\x01", "\x80\x00"},
+		},
+		{
+			query:   `a.c`,
+			match:   []string{"a\xfec", "abc"},
+			noMatch: []string{"a\nc", "aþc"},
+		},
+		{
+			query:   `[^\x00]`,
+			trie:    true,
+			match:   []string{"\xff", "a"},
+			noMatch: []string{"\x00", "Ā"},
+		},
+		{
+			query:   `\xff\x00|\xfe|\x00[\xf0-\xf2]`,
+			trie:    true,
+			match:   []string{"\xff\x00", "\xfe", "\x00\xf1"},
+			noMatch: []string{"þ", "\xff", "\x00\xf3"},
+		},
+	}
+	for _, test := range tests {
+		r, err := NewWithOpts(test.query, &Opts{Bytes: true})
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", test.query, err)
+		}
+		if (r.trie != nil) != test.trie {
+			t.Errorf("%s: expected trie %t", test.query, test.trie)
+		}
+		for _, key := range test.match {
+			if isMatch, _ := run(r, key); !isMatch {
+				t.Errorf("%s: expected %q to match", test.query, key)
+			}
+		}
+		for _, key := range test.noMatch {
+			if isMatch, _ := run(r, key); isMatch {
+				t.Errorf("%s: expected %q not to match", test.query, key)
+			}
+		}
+	}
+
+	for _, query := range []string{`\x{100}`, `[\x{100}-\x{200}]`} {
+		_, err := NewWithOpts(query, &Opts{Bytes: true})
+		if !errors.Is(err, ErrNotByte) {
+			t.Errorf("%s: expected ErrNotByte, got %v", query, err)
+		}
+	}
+}
+
+func TestQuoteBytes(t *testing.T) {
+	keys := []string{"", "abc", "a.b*c", "\x00\x01\xff", "[\\]^$", "\x7f\x80 ~"}
+	for _, key := range keys {
+		r, err := NewWithOpts(QuoteBytes([]byte(key)), &Opts{Bytes: true})
+		if err != nil {
+			t.Fatalf("%q: error compiling %s: %v", key, QuoteBytes([]byte(key)),
+				err)
+		}
+		for _, other := range keys {
+			if isMatch, _ := run(r, other); isMatch != (other == key) {
+				t.Errorf("%s: expected match %q %t", QuoteBytes([]byte(key)),
+					other, other == key)
+			}
+		}
+	}
+	if got := QuoteBytes([]byte("a+\x00\xff")); got != `a\+\x00\xff` {
+		t.Errorf("unexpected quoting %s", got)
+	}
+}
@@ -0,0 +1,157 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// StateCache allows many compiled Regexps to share memory.
+//
+// Each DFA state holds a table of 256 transitions, which dominates the size
+// of a compiled Regexp.  Patterns with overlapping structure, such as those
+// generated from a common template, produce many states with identical
+// tables, and a StateCache keeps a single copy of each of them.
+// Additionally, patterns compiling to identical instructions share an
+// entire DFA.
+//
+// A StateCache is safe for concurrent use, and Regexps sharing it remain
+// valid regardless of what is compiled later.
+type StateCache struct {
+	m    sync.Mutex
+	rows map[uint64][][]int
+	dfas map[string]*dfa
+
+	stats StateCacheStats
+}
+
+// StateCacheStats reports on the sharing achieved by a StateCache.
+type StateCacheStats struct {
+	// DFAs is the number of distinct DFAs built.
+	DFAs int
+	// SharedDFAs is the number of Regexps which reused an existing DFA.
+	SharedDFAs int
+	// Rows is the number of distinct transition tables retained.
+	Rows int
+	// SharedRows is the number of states which reused an existing
+	// transition table.
+	SharedRows int
+}
+
+// NewStateCache returns a new, empty, StateCache.
+func NewStateCache() *StateCache {
+	return &StateCache{
+		rows: make(map[uint64][][]int),
+		dfas: make(map[string]*dfa),
+	}
+}
+
+// Stats returns the sharing achieved by the StateCache so far.
+func (c *StateCache) Stats() StateCacheStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.stats
+}
+
+// lookup returns the previously built DFA for the program, if any.
+func (c *StateCache) lookup(key string) *dfa {
+	c.m.Lock()
+	defer c.m.Unlock()
+	rv := c.dfas[key]
+	if rv != nil {
+		c.stats.SharedDFAs++
+	}
+	return rv
+}
+
+// share replaces the transition tables of the newly built DFA with shared
+// copies, and records the DFA for the program.  The DFA actually recorded
+// is returned, as another one may have been built concurrently.
+func (c *StateCache) share(key string, d *dfa) *dfa {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if existing, ok := c.dfas[key]; ok {
+		c.stats.SharedDFAs++
+		return existing
+	}
+	for i := range d.states {
+		d.states[i].next = c.row(d.states[i].next)
+		// only needed during construction
+		d.states[i].insts = nil
+	}
+	c.dfas[key] = d
+	c.stats.DFAs++
+	return d
+}
+
+func (c *StateCache) row(next []int) []int {
+	h := hashRow(next)
+	for _, candidate := range c.rows[h] {
+		if equalRows(candidate, next) {
+			c.stats.SharedRows++
+			return candidate
+		}
+	}
+	c.rows[h] = append(c.rows[h], next)
+	c.stats.Rows++
+	return next
+}
+
+const fnvOffset = 14695981039346656037
+const fnvPrime = 1099511628211
+
+func hashRow(next []int) uint64 {
+	h := uint64(fnvOffset)
+	for _, n := range next {
+		h = (h ^ uint64(n)) * fnvPrime
+	}
+	return h
+}
+
+func equalRows(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// progKey encodes the program and separators, such that identical
+// programs (and only those) have identical keys.
+func progKey(insts prog, seps *[256]bool) string {
+	buf := make([]byte, 32, 32+len(insts)*8)
+	if seps != nil {
+		for b, sep := range seps {
+			if sep {
+				buf[b/8] |= 1 << uint(b%8)
+			}
+		}
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	for _, i := range insts {
+		for _, v := range []uint64{uint64(i.op), uint64(i.to),
+			uint64(i.splitA), uint64(i.splitB),
+			uint64(i.rangeStart), uint64(i.rangeEnd), uint64(i.assert)} {
+			n := binary.PutUvarint(tmp[:], v)
+			buf = append(buf, tmp[:n]...)
+		}
+	}
+	return string(buf)
+}
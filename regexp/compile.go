@@ -15,7 +15,9 @@
 package regexp
 
 import (
+	"fmt"
 	"regexp/syntax"
+	"sort"
 	"unicode"
 
 	unicode_utf8 "unicode/utf8"
@@ -32,6 +34,12 @@ type compiler struct {
 	rangeStack utf8.RangeStack
 	startBytes []byte
 	endBytes   []byte
+
+	// separators, if set, allow the zero width assertions relative to them
+	separators *[256]bool
+
+	// bytes is set in byte mode, see Opts.Bytes
+	bytes bool
 }
 
 func newCompiler(sizeLimit uint) *compiler {
@@ -53,29 +61,54 @@ func (c *compiler) compile(ast *syntax.Regexp) (prog, error) {
 	return c.insts, nil
 }
 
+// c compiles the expression.  Lazy quantifiers are compiled as greedy
+// ones, as they only choose between matches of different lengths, and the
+// automaton only matches whole keys.
 func (c *compiler) c(ast *syntax.Regexp) (err error) {
-	if ast.Flags&syntax.NonGreedy > 1 {
-		return ErrNoLazy
-	}
-
 	switch ast.Op {
-	case syntax.OpEndLine, syntax.OpBeginLine,
-		syntax.OpBeginText, syntax.OpEndText:
-		return ErrNoEmpty
-	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
-		return ErrNoWordBoundary
+	case syntax.OpBeginLine:
+		if c.separators == nil {
+			return unsupported(ast, ErrNoEmpty)
+		}
+		c.compileAssert(assertSegmentStart)
+	case syntax.OpEndLine:
+		if c.separators == nil {
+			return unsupported(ast, ErrNoEmpty)
+		}
+		c.compileAssert(assertSegmentEnd)
+	case syntax.OpBeginText, syntax.OpEndText:
+		return unsupported(ast, ErrNoEmpty)
+	case syntax.OpWordBoundary:
+		if c.separators == nil {
+			return unsupported(ast, ErrNoWordBoundary)
+		}
+		c.compileAssert(assertBoundary)
+	case syntax.OpNoWordBoundary:
+		if c.separators == nil {
+			return unsupported(ast, ErrNoWordBoundary)
+		}
+		c.compileAssert(assertNoBoundary)
 	case syntax.OpEmptyMatch:
 		return nil
 	case syntax.OpLiteral:
 		for _, r := range ast.Rune {
-			if ast.Flags&syntax.FoldCase > 0 {
-				next := syntax.Regexp{
-					Op:    syntax.OpCharClass,
-					Flags: ast.Flags & syntax.FoldCase,
-					Rune0: [2]rune{r, r},
+			if c.bytes && r > 0xff {
+				return fmt.Errorf("%w: %q", ErrNotByte, r)
+			}
+			if ast.Flags&syntax.FoldCase > 0 && unicode.SimpleFold(r) != r {
+				// the rune matches any rune of its case folding orbit
+				err = c.compileClass(&syntax.Regexp{
+					Op:   syntax.OpCharClass,
+					Rune: foldClass(r),
+				})
+				if err != nil {
+					return err
 				}
-				next.Rune = next.Rune0[0:2]
-				return c.c(&next)
+				continue
+			}
+			if c.bytes {
+				c.compileByteRange(byte(r), byte(r))
+				continue
 			}
 			c.sequences, c.rangeStack, err = utf8.NewSequencesPrealloc(
 				r, r, c.sequences, c.rangeStack, c.startBytes, c.endBytes)
@@ -100,6 +133,10 @@ func (c *compiler) c(ast *syntax.Regexp) (err error) {
 			Flags: ast.Flags & syntax.FoldCase,
 			Rune:  []rune{0, 0x09, 0x0B, unicode.MaxRune},
 		}
+		if c.separators != nil {
+			// separators are treated like newlines
+			next.Rune = anyCharNotSep(c.separators)
+		}
 		return c.c(&next)
 	case syntax.OpCharClass:
 		return c.compileClass(ast)
@@ -229,11 +266,18 @@ func (c *compiler) compileClass(ast *syntax.Regexp) error {
 	if len(ast.Rune) == 0 {
 		return nil
 	}
-	jmps := make([]uint, 0, len(ast.Rune)-2)
+	runes := ast.Rune
+	if c.bytes {
+		runes = byteRanges(runes)
+		if len(runes) == 0 {
+			return fmt.Errorf("%w: %s", ErrNotByte, ast)
+		}
+	}
+	jmps := make([]uint, 0, len(runes)-2)
 	// does not do last pair
-	for i := 0; i < len(ast.Rune)-2; i += 2 {
-		rstart := ast.Rune[i]
-		rend := ast.Rune[i+1]
+	for i := 0; i < len(runes)-2; i += 2 {
+		rstart := runes[i]
+		rend := runes[i+1]
 
 		split := c.emptySplit()
 		j1 := c.top()
@@ -246,8 +290,8 @@ func (c *compiler) compileClass(ast *syntax.Regexp) error {
 		c.setSplit(split, j1, j2)
 	}
 	// handle last pair
-	rstart := ast.Rune[len(ast.Rune)-2]
-	rend := ast.Rune[len(ast.Rune)-1]
+	rstart := runes[len(runes)-2]
+	rend := runes[len(runes)-1]
 	err := c.compileClassRange(rstart, rend)
 	if err != nil {
 		return err
@@ -259,7 +303,30 @@ func (c *compiler) compileClass(ast *syntax.Regexp) error {
 	return nil
 }
 
+// foldClass returns the ranges of the runes equivalent to r under Unicode
+// simple case folding, r included
+func foldClass(r rune) []rune {
+	orbit := []rune{r}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		orbit = append(orbit, f)
+	}
+	sort.Slice(orbit, func(i, j int) bool { return orbit[i] < orbit[j] })
+	rv := make([]rune, 0, 2*len(orbit))
+	for _, f := range orbit {
+		if len(rv) > 0 && rv[len(rv)-1]+1 == f {
+			rv[len(rv)-1] = f
+			continue
+		}
+		rv = append(rv, f, f)
+	}
+	return rv
+}
+
 func (c *compiler) compileClassRange(startR, endR rune) (err error) {
+	if c.bytes {
+		c.compileByteRange(byte(startR), byte(endR))
+		return nil
+	}
 	c.sequences, c.rangeStack, err = utf8.NewSequencesPrealloc(
 		startR, endR, c.sequences, c.rangeStack, c.startBytes, c.endBytes)
 	if err != nil {
@@ -296,6 +363,21 @@ func (c *compiler) compileUtf8Ranges(seq utf8.Sequence) {
 	}
 }
 
+func (c *compiler) compileByteRange(start, end byte) {
+	inst := c.allocInst()
+	inst.op = OpRange
+	inst.rangeStart = start
+	inst.rangeEnd = end
+	c.insts = append(c.insts, inst)
+}
+
+func (c *compiler) compileAssert(a assertion) {
+	inst := c.allocInst()
+	inst.op = OpAssert
+	inst.assert = a
+	c.insts = append(c.insts, inst)
+}
+
 func (c *compiler) emptySplit() uint {
 	inst := c.allocInst()
 	inst.op = OpSplit
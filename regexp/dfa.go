@@ -17,29 +17,87 @@ package regexp
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
+
+	"github.com/couchbase/vellum/sparse"
 )
 
-// StateLimit is the maximum number of states allowed
+// StateLimit is the default maximum number of states allowed, see
+// Opts.StateLimit
 const StateLimit = 10000
 
 // ErrTooManyStates is returned if you attempt to build a Levenshtein
-// automaton which requires too many states.
+// automaton which requires too many states.  The error returned is a
+// *TooManyStatesError, which matches it with errors.Is.
 var ErrTooManyStates = fmt.Errorf("dfa contains more than %d states",
 	StateLimit)
 
+// TooManyStatesError is returned when the DFA exceeds the state or memory
+// limit while it is being built, reporting how far it got, to help tune
+// the limits.
+type TooManyStatesError struct {
+	// States is the number of states built when construction stopped
+	States int
+	// Memory is the approximate size of those states, in bytes
+	Memory int
+	// StateLimit and MemoryLimit are the limits in effect, MemoryLimit
+	// is zero if the memory isn't limited
+	StateLimit  int
+	MemoryLimit int
+}
+
+func (e *TooManyStatesError) Error() string {
+	if e.States > e.StateLimit {
+		return fmt.Sprintf("dfa contains more than %d states", e.StateLimit)
+	}
+	return fmt.Sprintf("dfa with %d states uses more than %d bytes", e.States,
+		e.MemoryLimit)
+}
+
+func (e *TooManyStatesError) Unwrap() error {
+	return ErrTooManyStates
+}
+
+// dfaStateSize is the approximate size of a state of a dfa being built
+var dfaStateSize = stateSize + 256*intSize
+
 type dfaBuilder struct {
 	dfa    *dfa
 	cache  map[string]int
 	keyBuf []byte
+	look   *sparse.Set
+
+	// seps are the separators, the states depend on the class of the byte
+	// preceding them only if the program has assertions
+	seps       *[256]bool
+	lookaround bool
+
+	warn       StateWarningFunc
+	thresholds []float64
+
+	stateLimit  int
+	memoryLimit int
+
+	// lazy states have their transitions kept by a lazyDFA
+	lazy bool
 }
 
-func newDfaBuilder(insts prog) *dfaBuilder {
+func newDfaBuilder(insts prog, seps *[256]bool) *dfaBuilder {
 	d := &dfaBuilder{
 		dfa: &dfa{
 			insts:  insts,
 			states: make([]state, 0, 16),
 		},
 		cache: make(map[string]int, 1024),
+		look:  sparse.New(uint(len(insts))),
+		seps:  seps,
+
+		stateLimit: StateLimit,
+	}
+	for _, inst := range insts {
+		if inst.op == OpAssert {
+			d.lookaround = true
+		}
 	}
 	// add 0 state that is invalid
 	d.dfa.states = append(d.dfa.states, state{
@@ -49,12 +107,62 @@ func newDfaBuilder(insts prog) *dfaBuilder {
 	return d
 }
 
+// setLimits sets the maximum number of states, and their approximate
+// memory, zero meaning StateLimit states and unlimited memory
+func (d *dfaBuilder) setLimits(states, memory int) {
+	d.stateLimit = stateLimit(states)
+	d.memoryLimit = memory
+}
+
+// stateLimit returns the configured state limit, or the default
+func stateLimit(limit int) int {
+	if limit <= 0 {
+		return StateLimit
+	}
+	return limit
+}
+
+// checkLimits returns a *TooManyStatesError if the dfa exceeds the limits
+func checkLimits(d *dfa, stateLimit, memoryLimit int) error {
+	numStates := len(d.states)
+	if numStates > stateLimit ||
+		(memoryLimit > 0 && numStates*dfaStateSize > memoryLimit) {
+		return &TooManyStatesError{
+			States:      numStates,
+			Memory:      numStates * dfaStateSize,
+			StateLimit:  stateLimit,
+			MemoryLimit: memoryLimit,
+		}
+	}
+	return nil
+}
+
+// setStateWarning registers a callback to be invoked as the number of
+// states crosses each of the provided fractions of the state limit.
+func (d *dfaBuilder) setStateWarning(warn StateWarningFunc,
+	thresholds []float64) {
+	d.warn = warn
+	d.thresholds = append(d.thresholds[:0], thresholds...)
+	sort.Float64s(d.thresholds)
+}
+
+// checkStateWarning invokes the registered callback once for each
+// threshold crossed since the last check.
+func (d *dfaBuilder) checkStateWarning() {
+	numStates := len(d.dfa.states)
+	for len(d.thresholds) > 0 &&
+		float64(numStates) >= d.thresholds[0]*float64(d.stateLimit) {
+		d.warn(d.thresholds[0], numStates, d.stateLimit)
+		d.thresholds = d.thresholds[1:]
+	}
+}
+
 func (d *dfaBuilder) build() (*dfa, error) {
-	cur := newSparseSet(uint(len(d.dfa.insts)))
-	next := newSparseSet(uint(len(d.dfa.insts)))
+	cur := sparse.New(uint(len(d.dfa.insts)))
+	next := sparse.New(uint(len(d.dfa.insts)))
 
-	d.dfa.add(cur, 0)
-	ns, instsReuse := d.cachedState(cur, nil)
+	d.dfa.add(cur, 0, classSep, classUnknown)
+	ns, instsReuse := d.cachedState(cur, classSep, nil)
 	states := intStack{ns}
 	seen := make(map[int]struct{})
 	var s int
@@ -69,8 +177,11 @@ func (d *dfaBuilder) build() (*dfa, error) {
 					states = states.Push(ns)
 				}
 			}
-			if len(d.dfa.states) > StateLimit {
-				return nil, ErrTooManyStates
+			if err := checkLimits(d.dfa, d.stateLimit, d.memoryLimit); err != nil {
+				return nil, err
+			}
+			if d.warn != nil {
+				d.checkStateWarning()
 			}
 		}
 		states, s = states.Pop()
@@ -78,15 +189,16 @@ func (d *dfaBuilder) build() (*dfa, error) {
 	return d.dfa, nil
 }
 
-func (d *dfaBuilder) runState(cur, next *sparseSet, state int, b byte, instsReuse []uint) (
+func (d *dfaBuilder) runState(cur, next *sparse.Set, state int, b byte, instsReuse []uint) (
 	int, []uint) {
 	cur.Clear()
 	for _, ip := range d.dfa.states[state].insts {
 		cur.Add(ip)
 	}
-	d.dfa.run(cur, next, b)
+	prev := d.dfa.states[state].prev
+	d.dfa.run(cur, d.look, next, prev, b, d.class(b))
 	var nextState int
-	nextState, instsReuse = d.cachedState(next, instsReuse)
+	nextState, instsReuse = d.cachedState(next, d.class(b), instsReuse)
 	d.dfa.states[state].next[b] = nextState
 	return nextState, instsReuse
 }
@@ -103,13 +215,21 @@ func instsKey(insts []uint, buf []byte) []byte {
 	return buf
 }
 
-func (d *dfaBuilder) cachedState(set *sparseSet,
+// class returns the class of the byte, relative to the separators.
+func (d *dfaBuilder) class(b byte) int {
+	if d.seps != nil && d.seps[b] {
+		return classSep
+	}
+	return classText
+}
+
+func (d *dfaBuilder) cachedState(set *sparse.Set, prev int,
 	instsReuse []uint) (int, []uint) {
 	insts := instsReuse[:0]
 	if cap(insts) == 0 {
 		insts = make([]uint, 0, set.Len())
 	}
-	var isMatch bool
+	var isMatch, pending bool
 	for i := uint(0); i < uint(set.Len()); i++ {
 		ip := set.Get(i)
 		switch d.dfa.insts[ip].op {
@@ -118,21 +238,46 @@ func (d *dfaBuilder) cachedState(set *sparseSet,
 		case OpMatch:
 			isMatch = true
 			insts = append(insts, ip)
+		case OpAssert:
+			// keep the assertions waiting on the class of the next byte
+			if _, known := d.dfa.insts[ip].assert.check(prev, classUnknown); !known {
+				pending = true
+				insts = append(insts, ip)
+			}
 		}
 	}
 	if len(insts) == 0 {
 		return 0, insts
 	}
+	if pending && !isMatch {
+		// the end of the key is classed as a separator
+		d.look.Clear()
+		for _, ip := range insts {
+			d.dfa.add(d.look, ip, prev, classSep)
+		}
+		for i := uint(0); i < uint(d.look.Len()); i++ {
+			if d.dfa.insts[d.look.Get(i)].op == OpMatch {
+				isMatch = true
+			}
+		}
+	}
 	d.keyBuf = instsKey(insts, d.keyBuf)
+	if d.lookaround {
+		d.keyBuf = append(d.keyBuf, byte(prev))
+	}
 	v, ok := d.cache[string(d.keyBuf)]
 	if ok {
 		return v, insts
 	}
-	d.dfa.states = append(d.dfa.states, state{
+	s := state{
 		insts: insts,
-		next:  make([]int, 256),
 		match: isMatch,
-	})
+		prev:  prev,
+	}
+	if !d.lazy {
+		s.next = make([]int, 256)
+	}
+	d.dfa.states = append(d.dfa.states, s)
 	newV := len(d.dfa.states) - 1
 	d.cache[string(d.keyBuf)] = newV
 	return newV, nil
@@ -143,32 +288,47 @@ type dfa struct {
 	states []state
 }
 
-func (d *dfa) add(set *sparseSet, ip uint) {
+// add adds the instruction, and those reachable from it without consuming
+// a byte, to the set.  The classes of the bytes before and after the
+// position are used to check assertions, those which can't be checked yet
+// are left in the set.
+func (d *dfa) add(set *sparse.Set, ip uint, prev, next int) {
 	if set.Contains(ip) {
 		return
 	}
 	set.Add(ip)
 	switch d.insts[ip].op {
 	case OpJmp:
-		d.add(set, d.insts[ip].to)
+		d.add(set, d.insts[ip].to, prev, next)
 	case OpSplit:
-		d.add(set, d.insts[ip].splitA)
-		d.add(set, d.insts[ip].splitB)
+		d.add(set, d.insts[ip].splitA, prev, next)
+		d.add(set, d.insts[ip].splitB, prev, next)
+	case OpAssert:
+		if holds, _ := d.insts[ip].assert.check(prev, next); holds {
+			d.add(set, ip+1, prev, next)
+		}
 	}
 }
 
-func (d *dfa) run(from, to *sparseSet, b byte) bool {
+// run consumes the byte b, of class next, first checking the assertions
+// left waiting on it in the look set.
+func (d *dfa) run(from, look, to *sparse.Set, prev int, b byte,
+	next int) bool {
+	look.Clear()
+	for i := uint(0); i < uint(from.Len()); i++ {
+		d.add(look, from.Get(i), prev, next)
+	}
 	to.Clear()
 	var isMatch bool
-	for i := uint(0); i < uint(from.Len()); i++ {
-		ip := from.Get(i)
+	for i := uint(0); i < uint(look.Len()); i++ {
+		ip := look.Get(i)
 		switch d.insts[ip].op {
 		case OpMatch:
 			isMatch = true
 		case OpRange:
 			if d.insts[ip].rangeStart <= b &&
 				b <= d.insts[ip].rangeEnd {
-				d.add(to, ip+1)
+				d.add(to, ip+1, next, classUnknown)
 			}
 		}
 	}
@@ -179,6 +339,8 @@ type state struct {
 	insts []uint
 	next  []int
 	match bool
+	// prev is the class of the byte preceding the state
+	prev int
 }
 
 type intStack []int
@@ -0,0 +1,170 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"fmt"
+	stdregexp "regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// ErrNoCaseFolding was returned when case insensitive matching was used.
+//
+// Deprecated: case insensitive matching is supported, it is no longer
+// returned.
+var ErrNoCaseFolding = fmt.Errorf("case insensitive matching is not allowed")
+
+// Mode selects how expressions using constructs outside the supported
+// dialect, described in docs/regexp.md, are handled.
+type Mode int
+
+const (
+	// Strict rejects constructs outside the dialect with an
+	// *UnsupportedError.
+	Strict Mode = iota
+
+	// Lenient compiles expressions using constructs outside the dialect
+	// into an automaton backed by the standard library regexp package,
+	// which matches keys exactly as regexp.MatchString would match the
+	// expression anchored at both ends.  It can't tell which keys will
+	// never match, other than those not starting with the literal prefix
+	// of the expression, so searches visit many more states, and it keeps
+	// every key prefix it visits.  Expressions within the dialect are
+	// compiled as usual.
+	Lenient
+)
+
+// UnsupportedError is returned in Strict mode for a construct outside the
+// supported dialect.
+type UnsupportedError struct {
+	// Construct is the unsupported subexpression
+	Construct string
+	// Err is ErrNoEmpty or ErrNoWordBoundary
+	Err error
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported construct `%s`: %v", e.Construct, e.Err)
+}
+
+func (e *UnsupportedError) Unwrap() error {
+	return e.Err
+}
+
+func unsupported(ast *syntax.Regexp, err error) error {
+	return &UnsupportedError{
+		Construct: ast.String(),
+		Err:       err,
+	}
+}
+
+// stdAutomaton runs a standard library regexp over the key prefixes, each
+// state being a distinct prefix visited, numbered as they are first
+// reached.  State 0 is the dead state, and 1 the empty prefix.
+type stdAutomaton struct {
+	re *stdregexp.Regexp
+	// prefix is the literal prefix of every match
+	prefix string
+
+	m      sync.Mutex
+	states map[string]int
+	keys   []string
+	match  []bool
+}
+
+func newStdAutomaton(parsed *syntax.Regexp) (*stdAutomaton, error) {
+	expr := parsed.String()
+	re, err := stdregexp.Compile(`\A(?:` + expr + `)\z`)
+	if err != nil {
+		return nil, err
+	}
+	unanchored, err := stdregexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	// the literal prefix of the unanchored expression is only known when
+	// it doesn't start with an assertion, so every match starts with it
+	prefix, _ := unanchored.LiteralPrefix()
+	rv := &stdAutomaton{
+		re:     re,
+		prefix: prefix,
+		states: make(map[string]int),
+		keys:   []string{"", ""},
+		match:  []bool{false, re.MatchString("")},
+	}
+	rv.states[""] = 1
+	return rv, nil
+}
+
+// canMatch returns whether a key starting with the prefix can match
+func (a *stdAutomaton) canMatch(key string) bool {
+	if len(key) < len(a.prefix) {
+		return strings.HasPrefix(a.prefix, key)
+	}
+	return strings.HasPrefix(key, a.prefix)
+}
+
+func (a *stdAutomaton) accept(s int, b byte) int {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if s <= 0 || s >= len(a.keys) {
+		return 0
+	}
+	key := a.keys[s] + string([]byte{b})
+	if next, ok := a.states[key]; ok {
+		return next
+	}
+	if !a.canMatch(key) {
+		return 0
+	}
+	next := len(a.keys)
+	a.states[key] = next
+	a.keys = append(a.keys, key)
+	a.match = append(a.match, a.re.MatchString(key))
+	return next
+}
+
+func (a *stdAutomaton) isMatch(s int) bool {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return s > 0 && s < len(a.match) && a.match[s]
+}
+
+func (a *stdAutomaton) numStates() int {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return len(a.keys)
+}
+
+// report returns a conservative Report, as the states which can match
+// aren't known
+func (a *stdAutomaton) report(alphabet *[256]bool) *Report {
+	rv := &Report{
+		CanMatch: true,
+	}
+	a.m.Lock()
+	rv.States = len(a.keys)
+	for _, key := range a.keys {
+		rv.Size += len(key) + stateSize
+	}
+	a.m.Unlock()
+	rv.LiveStates = rv.States - 1
+	for b := range rv.Bytes {
+		rv.Bytes[b] = alphabet == nil || alphabet[b]
+	}
+	return rv
+}
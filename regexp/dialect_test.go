@@ -0,0 +1,160 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"errors"
+	stdregexp "regexp"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		query     string
+		construct string
+		err       error
+	}{
+		{`^foo`, `\A`, ErrNoEmpty},
+		{`foo\z`, `\z`, ErrNoEmpty},
+		{`a\bb`, `\b`, ErrNoWordBoundary},
+	}
+	for _, test := range tests {
+		_, err := New(test.query)
+		var uerr *UnsupportedError
+		if !errors.As(err, &uerr) || !errors.Is(err, test.err) {
+			t.Errorf("%s: expected UnsupportedError %v, got %v", test.query,
+				test.err, err)
+			continue
+		}
+		if uerr.Construct != test.construct {
+			t.Errorf("%s: expected construct %s, got %s", test.query,
+				test.construct, uerr.Construct)
+		}
+	}
+
+	// case folding of characters without case is the identity
+	_, err := New(`(?i)[0-9]+-42`)
+	if err != nil {
+		t.Errorf("expected caseless folding to compile, got %v", err)
+	}
+}
+
+func TestCaseFolding(t *testing.T) {
+	keys := []string{"", "hello", "HeLLo", "HELLO", "hellO", "helo", "k", "K",
+		"\u212a", "s", "S", "\u017f", "ss", "\u00df", "\u1e9e", "\u03a3\u03c3\u03c2",
+		"42", "\u0664\u0662", "abc", "\u00e9t\u00e9", "\u0394\u03b4"}
+	queries := []string{`(?i)hello`, `(?i)k`, `(?i)s+`, `x|(?i:HELLO)`,
+		`(?i)\x{df}`, `(?i)σ+`, `\pL+`, `\p{Greek}+`, `\d+`, `\PL*`,
+		`(?i)[a-z]+`, `(?i)ÉT[e\x{e9}]`}
+	for _, query := range queries {
+		r, err := New(query)
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", query, err)
+		}
+		expected := stdregexp.MustCompile(`\A(?:` + query + `)\z`)
+		for _, key := range keys {
+			isMatch, _ := run(r, key)
+			want := expected.MatchString(key)
+			if isMatch != want {
+				t.Errorf("%s: expected match %q %t", query, key, want)
+			}
+		}
+	}
+
+	// in bytes mode, only the folds within a byte are matched
+	r, err := NewWithOpts(`(?i)k`, &Opts{Bytes: true})
+	if err != nil {
+		t.Fatalf("error compiling bytes: %v", err)
+	}
+	for _, key := range []string{"k", "K"} {
+		if isMatch, _ := run(r, key); !isMatch {
+			t.Errorf("expected bytes match %q", key)
+		}
+	}
+}
+
+func TestLazyQuantifiers(t *testing.T) {
+	keys := []string{"", "a", "ab", "abb", "aab", "b", "ba", "abab", "xyz"}
+	for _, query := range []string{`a*?`, `a+?b`, `(ab)+?`, `a??b`, `a{1,2}?b*`,
+		`(?U)a*b+`, `(?U)(a|b)*?b`, `.*?b`} {
+		r, err := New(query)
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", query, err)
+		}
+		if r.std != nil {
+			t.Errorf("%s: expected no standard library fallback", query)
+		}
+		expected := stdregexp.MustCompile(`\A(?:` + query + `)\z`)
+		for _, key := range keys {
+			isMatch, _ := run(r, key)
+			want := expected.MatchString(key)
+			if isMatch != want {
+				t.Errorf("%s: expected match %q %t", query, key, want)
+			}
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	keys := []string{"", "a", "ab", "abb", "abc", "foo", "foo bar", "foobar",
+		"HeLLo", "hello", "hellO world", "x", "axb", "axxb", "ba", "b"}
+	queries := []string{`^ab+$`, `\bfoo\b.*`, `a.*\Bb`, `(?i)hello.*\z`,
+		`foo\z`, `(a|b)*\z`, `hello|\Bx`}
+	for _, query := range queries {
+		r, err := NewWithOpts(query, &Opts{Mode: Lenient})
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", query, err)
+		}
+		if r.std == nil {
+			t.Errorf("%s: expected standard library fallback", query)
+		}
+		expected := stdregexp.MustCompile(`\A(?:` + query + `)\z`)
+		for _, key := range keys {
+			s := r.Start()
+			for i := 0; i < len(key) && r.CanMatch(s); i++ {
+				s = r.Accept(s, key[i])
+			}
+			want := expected.MatchString(key)
+			if r.IsMatch(s) != want {
+				t.Errorf("%s: expected match %q %t", query, key, want)
+			}
+		}
+	}
+
+	// keys without the literal prefix reach the dead state at once
+	r, err := NewWithOpts(`hello\b.*`, &Opts{Mode: Lenient})
+	if err != nil {
+		t.Fatalf("error compiling: %v", err)
+	}
+	if s := r.Accept(r.Start(), 'x'); s != 0 || r.CanMatch(s) {
+		t.Errorf("expected dead state without the prefix, got %d", s)
+	}
+	report := r.Report(nil)
+	if !report.CanMatch || report.NumBytes() != 256 {
+		t.Errorf("expected conservative report, got %+v", report)
+	}
+
+	// the dialect is compiled as usual
+	r, err = NewWithOpts(`ab+`, &Opts{Mode: Lenient})
+	if err != nil || r.std != nil || r.dfa == nil {
+		t.Errorf("expected dfa within the dialect, got %v", err)
+	}
+
+	// separators are unknown to the standard library
+	_, err = NewWithOpts(`a*/b\z`, &Opts{Mode: Lenient, Separators: []byte("/")})
+	if !errors.Is(err, ErrNoEmpty) {
+		t.Errorf("expected ErrNoEmpty with separators, got %v", err)
+	}
+}
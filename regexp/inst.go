@@ -25,6 +25,7 @@ const (
 	OpJmp
 	OpSplit
 	OpRange
+	OpAssert
 )
 
 // instSize is the approximate size of the an inst struct in bytes
@@ -37,6 +38,7 @@ type inst struct {
 	splitB     uint
 	rangeStart byte
 	rangeEnd   byte
+	assert     assertion
 }
 
 func (i *inst) String() string {
@@ -47,6 +49,8 @@ func (i *inst) String() string {
 		return fmt.Sprintf("SPLIT: %d - %d", i.splitA, i.splitB)
 	case OpRange:
 		return fmt.Sprintf("RANGE: %x - %x", i.rangeStart, i.rangeEnd)
+	case OpAssert:
+		return fmt.Sprintf("ASSERT: %v", i.assert)
 	}
 	return "MATCH"
 }
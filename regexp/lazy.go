@@ -0,0 +1,147 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/couchbase/vellum/sparse"
+)
+
+// DefaultLazyStates is the number of states whose transitions are kept by
+// a lazy Regexp, if Opts.LazyStates is zero
+const DefaultLazyStates = 1024
+
+// lazyTableSize is the approximate size of the transitions of a state of
+// a lazy dfa
+var lazyTableSize = 256 * intSize
+
+// lazyDFA determinizes the states of the program as they are reached.
+// Every state reached keeps its instructions, so that its number remains
+// valid, but only the transitions of the most recently used states are
+// kept, each computed when first taken.
+type lazyDFA struct {
+	m      sync.Mutex
+	b      *dfaBuilder
+	cur    *sparse.Set
+	next   *sparse.Set
+	reuse  []uint
+	max    int
+	tables map[int]*list.Element
+	lru    list.List // front is the most recently used
+}
+
+type lazyTable struct {
+	state int
+	// next is -1 for the transitions not yet taken
+	next [256]int
+}
+
+func newLazyDFA(insts prog, seps *[256]bool, maxStates int) *lazyDFA {
+	if maxStates <= 0 {
+		maxStates = DefaultLazyStates
+	}
+	b := newDfaBuilder(insts, seps)
+	b.lazy = true
+	rv := &lazyDFA{
+		b:      b,
+		cur:    sparse.New(uint(len(insts))),
+		next:   sparse.New(uint(len(insts))),
+		max:    maxStates,
+		tables: make(map[int]*list.Element),
+	}
+	b.dfa.add(rv.cur, 0, classSep, classUnknown)
+	b.cachedState(rv.cur, classSep, nil)
+	return rv
+}
+
+// table returns the transitions of the state, evicting those of the least
+// recently used state if there are too many
+func (l *lazyDFA) table(s int) *lazyTable {
+	if elem, ok := l.tables[s]; ok {
+		l.lru.MoveToFront(elem)
+		return elem.Value.(*lazyTable)
+	}
+	var rv *lazyTable
+	if len(l.tables) >= l.max {
+		oldest := l.lru.Back()
+		rv = l.lru.Remove(oldest).(*lazyTable)
+		delete(l.tables, rv.state)
+	} else {
+		rv = &lazyTable{}
+	}
+	rv.state = s
+	for b := range rv.next {
+		rv.next[b] = -1
+	}
+	l.tables[s] = l.lru.PushFront(rv)
+	return rv
+}
+
+func (l *lazyDFA) accept(s int, b byte) int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	states := l.b.dfa.states
+	if s <= 0 || s >= len(states) {
+		return 0
+	}
+	t := l.table(s)
+	if next := t.next[b]; next >= 0 {
+		return next
+	}
+	l.cur.Clear()
+	for _, ip := range states[s].insts {
+		l.cur.Add(ip)
+	}
+	class := l.b.class(b)
+	l.b.dfa.run(l.cur, l.b.look, l.next, states[s].prev, b, class)
+	var next int
+	next, l.reuse = l.b.cachedState(l.next, class, l.reuse)
+	t.next[b] = next
+	return next
+}
+
+func (l *lazyDFA) isMatch(s int) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return s > 0 && s < len(l.b.dfa.states) && l.b.dfa.states[s].match
+}
+
+func (l *lazyDFA) numStates() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return len(l.b.dfa.states)
+}
+
+// report returns a conservative Report, as the states not yet reached
+// aren't known
+func (l *lazyDFA) report(alphabet *[256]bool) *Report {
+	rv := &Report{
+		CanMatch: true,
+	}
+	l.m.Lock()
+	rv.States = len(l.b.dfa.states)
+	for _, s := range l.b.dfa.states {
+		rv.Size += stateSize + len(s.insts)*intSize
+	}
+	rv.Size += len(l.tables) * lazyTableSize
+	l.m.Unlock()
+	rv.LiveStates = rv.States - 1
+	for b := range rv.Bytes {
+		rv.Bytes[b] = alphabet == nil || alphabet[b]
+	}
+	return rv
+}
@@ -0,0 +1,93 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLazy(t *testing.T) {
+	keys := []string{"", "a", "ab", "abc", "marty", "marty/schoch", "b/ab",
+		"aaaa", "xyz", "abcabc", "ba"}
+	for _, query := range []string{`a.*`, `(ab)+`, `[a-m]+(/.*)?`,
+		`.*b.*`, `\bab\b`, `a{2,3}|xyz`} {
+		eager, err := NewWithOpts(query, &Opts{Separators: []byte("/")})
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", query, err)
+		}
+		// few states kept, so their transitions are recomputed
+		lazy, err := NewWithOpts(query, &Opts{
+			Separators: []byte("/"),
+			Lazy:       true,
+			LazyStates: 2,
+		})
+		if err != nil {
+			t.Fatalf("%s: error compiling lazily: %v", query, err)
+		}
+		for i := 0; i < 2; i++ {
+			for _, key := range keys {
+				wantMatch, wantCan := run(eager, key)
+				gotMatch, gotCan := run(lazy, key)
+				if gotMatch != wantMatch || gotCan != wantCan {
+					t.Errorf("%s: expected %q %t %t, got %t %t", query, key,
+						wantMatch, wantCan, gotMatch, gotCan)
+				}
+			}
+		}
+	}
+}
+
+func TestLazyTooManyStates(t *testing.T) {
+	// the DFA has a state for each suffix of 12 bytes
+	query := `(a|b)*a(a|b){12}`
+	_, err := NewWithLimits(query, 1000, 0)
+	if !errors.Is(err, ErrTooManyStates) {
+		t.Fatalf("expected ErrTooManyStates, got %v", err)
+	}
+	r, err := NewWithOpts(query, &Opts{Lazy: true, LazyStates: 16})
+	if err != nil {
+		t.Fatalf("error compiling lazily: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, key := range []string{
+				"a" + strings.Repeat("b", 12),
+				"ba" + strings.Repeat("ab", 6),
+			} {
+				if isMatch, _ := run(r, key); !isMatch {
+					t.Errorf("expected %q to match", key)
+				}
+			}
+			for _, key := range []string{strings.Repeat("b", 13), "a", "abc"} {
+				if isMatch, _ := run(r, key); isMatch {
+					t.Errorf("expected %q not to match", key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := r.Report(nil)
+	if report.States >= 1000 || !report.CanMatch {
+		t.Errorf("expected few states reached, got %+v", report)
+	}
+}
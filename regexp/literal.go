@@ -0,0 +1,233 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"regexp/syntax"
+	"sort"
+	"unicode/utf8"
+)
+
+// Patterns which are alternations of literals, such as foo|bar|baz, often
+// generated from a list of thousands of words, are compiled into a trie of
+// the literals instead of a DFA.  Building the DFA would compute the 256
+// transitions of each of its states, and the states needed for a long
+// list quickly exceed StateLimit, while the states of the trie only record
+// their few transitions, and are only limited by the size limit.
+
+// maxLiteralClass is the largest character class expanded into literals
+const maxLiteralClass = 256
+
+// approximate memory used by each state and transition of a trie
+const trieStateSize = 9
+const trieTransitionSize = 5
+
+// literalTrie is an automaton matching one of a set of literals.  State 0
+// is the dead state, and 1 is the root, as for the DFA.
+type literalTrie struct {
+	// the transitions of state s are labels and targets[start[s]:end[s]],
+	// sorted by label
+	start   []int32
+	end     []int32
+	labels  []byte
+	targets []int32
+	match   []bool
+}
+
+// alternationLiterals returns the literals matched by the parsed
+// expression, if it is an alternation of literals.  The parser factors
+// common prefixes out of alternations, and merges single characters into
+// classes, so any expression matching a finite set of several literals is
+// accepted.  ok is false if it isn't one, or if it expands into literals
+// taking more than size bytes.  In byte mode (see Opts.Bytes) characters
+// are bytes rather than UTF-8 sequences.
+func alternationLiterals(re *syntax.Regexp, size uint, bytes bool) (rv []string, ok bool) {
+	budget := int(size)
+	rv, ok = expandLiterals(re, &budget, bytes)
+	if !ok || len(rv) < 2 {
+		return nil, false
+	}
+	return rv, true
+}
+
+// expandLiterals returns the literals matched by the expression, if it
+// matches a finite set of them, decreasing the budget by their size.
+func expandLiterals(re *syntax.Regexp, budget *int, bytes bool) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		rv := string(re.Rune)
+		if bytes {
+			lit := make([]byte, len(re.Rune))
+			for i, r := range re.Rune {
+				if r > 0xff {
+					return nil, false
+				}
+				lit[i] = byte(r)
+			}
+			rv = string(lit)
+		}
+		*budget -= len(rv)
+		return []string{rv}, *budget >= 0
+	case syntax.OpCharClass:
+		runes := re.Rune
+		if bytes {
+			runes = byteRanges(runes)
+		}
+		var n int
+		for i := 0; i < len(runes); i += 2 {
+			lo, hi := runes[i], runes[i+1]
+			if !bytes && lo <= 0xdfff && hi >= 0xd800 {
+				// surrogates don't encode
+				return nil, false
+			}
+			n += int(hi-lo) + 1
+			if n > maxLiteralClass {
+				return nil, false
+			}
+		}
+		rv := make([]string, 0, n)
+		for i := 0; i < len(runes); i += 2 {
+			for r := runes[i]; r <= runes[i+1]; r++ {
+				if bytes {
+					rv = append(rv, string([]byte{byte(r)}))
+					*budget--
+					continue
+				}
+				rv = append(rv, string(r))
+				*budget -= utf8.RuneLen(r)
+			}
+		}
+		return rv, *budget >= 0
+	case syntax.OpCapture:
+		return expandLiterals(re.Sub[0], budget, bytes)
+	case syntax.OpQuest:
+		rv, ok := expandLiterals(re.Sub[0], budget, bytes)
+		return append(rv, ""), ok
+	case syntax.OpAlternate:
+		var rv []string
+		for _, sub := range re.Sub {
+			lits, ok := expandLiterals(sub, budget, bytes)
+			if !ok {
+				return nil, false
+			}
+			rv = append(rv, lits...)
+		}
+		return rv, true
+	case syntax.OpConcat:
+		rv := []string{""}
+		for _, sub := range re.Sub {
+			lits, ok := expandLiterals(sub, budget, bytes)
+			if !ok {
+				return nil, false
+			}
+			next := make([]string, 0, len(rv)*len(lits))
+			for _, prefix := range rv {
+				for _, lit := range lits {
+					*budget -= len(prefix) + len(lit)
+					if *budget < 0 {
+						return nil, false
+					}
+					next = append(next, prefix+lit)
+				}
+			}
+			rv = next
+		}
+		return rv, true
+	}
+	return nil, false
+}
+
+// newLiteralTrie builds the trie of the literals, returning
+// ErrCompiledTooBig if it would take more than size bytes.
+func newLiteralTrie(literals []string, size uint) (*literalTrie, error) {
+	sort.Strings(literals)
+	t := &literalTrie{
+		// the dead state and the root
+		start: make([]int32, 2),
+		end:   make([]int32, 2),
+		match: make([]bool, 2),
+	}
+	t.build(1, literals, 0)
+	if uint(len(t.match)*trieStateSize+len(t.labels)*trieTransitionSize) > size {
+		return nil, ErrCompiledTooBig
+	}
+	return t, nil
+}
+
+// build adds the transitions of state s, matching the sorted literals from
+// the byte at depth on
+func (t *literalTrie) build(s int32, literals []string, depth int) {
+	if len(literals[0]) == depth {
+		t.match[s] = true
+		// skip any duplicates
+		for len(literals) > 0 && len(literals[0]) == depth {
+			literals = literals[1:]
+		}
+	}
+	// the transitions of a state are contiguous, so they are all added
+	// before adding those of its targets
+	t.start[s] = int32(len(t.labels))
+	groups := make([]int, 0, 4)
+	for i := 0; i < len(literals); i++ {
+		if i == 0 || literals[i][depth] != literals[i-1][depth] {
+			groups = append(groups, i)
+			t.labels = append(t.labels, literals[i][depth])
+			t.targets = append(t.targets, int32(len(t.match)))
+			t.start = append(t.start, 0)
+			t.end = append(t.end, 0)
+			t.match = append(t.match, false)
+		}
+	}
+	t.end[s] = int32(len(t.labels))
+	groups = append(groups, len(literals))
+	for g := 0; g < len(groups)-1; g++ {
+		target := t.targets[int(t.start[s])+g]
+		t.build(target, literals[groups[g]:groups[g+1]], depth+1)
+	}
+}
+
+func (t *literalTrie) accept(s int, b byte) int {
+	if s <= 0 || s >= len(t.match) {
+		return 0
+	}
+	lo, hi := int(t.start[s]), int(t.end[s])
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if t.labels[mid] < b {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < int(t.end[s]) && t.labels[lo] == b {
+		return int(t.targets[lo])
+	}
+	return 0
+}
+
+func (t *literalTrie) isMatch(s int) bool {
+	return s > 0 && s < len(t.match) && t.match[s]
+}
+
+// canMatch is true for every state but the dead one, as every state of a
+// trie leads to a literal
+func (t *literalTrie) canMatch(s int) bool {
+	return s > 0 && s < len(t.match)
+}
@@ -0,0 +1,113 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"fmt"
+	stdregexp "regexp"
+	"strings"
+	"testing"
+)
+
+func run(r *Regexp, input string) (isMatch, canMatch bool) {
+	s := r.Start()
+	for i := 0; i < len(input); i++ {
+		s = r.Accept(s, input[i])
+	}
+	return r.IsMatch(s), r.CanMatch(s)
+}
+
+func TestLiteralAlternation(t *testing.T) {
+	tests := []struct {
+		pattern string
+		trie    bool
+	}{
+		{`foo|bar|baz`, true},
+		{`foo|foobar|fo`, true},
+		{`(cat|car|cart)`, true},
+		{`a|ab|abc|`, true},
+		{`héllo|hello|h[eé]lp`, true},
+		{`x(1|2|3)y|z?`, true},
+		{`foo|ba.`, false},
+		{`foo|ba+`, false},
+		{`[a-c]+|d`, false},
+		{`foo`, false},
+	}
+	inputs := []string{"", "f", "fo", "foo", "foob", "foobar", "bar", "baz",
+		"ba", "bax", "baa", "cat", "car", "cart", "carts", "a", "ab", "abc",
+		"abcd", "héllo", "hello", "hélp", "help", "x1y", "x4y", "z", "zz",
+		"FOO"}
+	for _, test := range tests {
+		r, err := New(test.pattern)
+		if err != nil {
+			t.Fatalf("error compiling %s: %v", test.pattern, err)
+		}
+		if (r.trie != nil) != test.trie {
+			t.Errorf("%s: expected trie %t", test.pattern, test.trie)
+		}
+		std := stdregexp.MustCompile(`^(?:` + test.pattern + `)$`)
+		for _, input := range inputs {
+			isMatch, canMatch := run(r, input)
+			if isMatch != std.MatchString(input) {
+				t.Errorf("%s: expected %q match %t", test.pattern, input, !isMatch)
+			}
+			if isMatch && !canMatch {
+				t.Errorf("%s: expected %q can match", test.pattern, input)
+			}
+		}
+	}
+}
+
+func TestLiteralAlternationLarge(t *testing.T) {
+	words := make([]string, 50000)
+	for i := range words {
+		words[i] = fmt.Sprintf("word%dx%d", i*7919%100003, i)
+	}
+	pattern := strings.Join(words, "|")
+	r, err := New(pattern)
+	if err != nil {
+		t.Fatalf("error compiling: %v", err)
+	}
+	if r.numStates() <= StateLimit {
+		t.Errorf("expected more than %d states, got %d", StateLimit,
+			r.numStates())
+	}
+	for _, word := range words {
+		isMatch, _ := run(r, word)
+		if !isMatch {
+			t.Fatalf("expected %s to match", word)
+		}
+	}
+	for input, prefix := range map[string]bool{"word": true, "word1": true,
+		words[0] + "0": false, "x": false} {
+		isMatch, canMatch := run(r, input)
+		if isMatch {
+			t.Errorf("expected %s not to match", input)
+		}
+		if canMatch != prefix {
+			t.Errorf("expected %s can match %t", input, prefix)
+		}
+	}
+	report := r.Report(nil)
+	if !report.CanMatch || report.LiveStates != r.numStates()-1 {
+		t.Errorf("unexpected report %d live of %d", report.LiveStates,
+			report.States)
+	}
+
+	_, err = NewWithOpts(pattern, &Opts{SizeLimit: 1 << 16})
+	if err != ErrCompiledTooBig {
+		t.Errorf("expected ErrCompiledTooBig, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// CompileError is the error compiling one of the patterns passed to
+// CompileAll.
+type CompileError struct {
+	Index   int
+	Pattern string
+	Err     error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("pattern %d %q: %v", e.Index, e.Pattern, e.Err)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// CompileErrors are the errors returned by CompileAll, one for each pattern
+// which failed to compile, in the order of the patterns.
+type CompileErrors []*CompileError
+
+func (e CompileErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d patterns failed to compile: %s", len(e),
+		strings.Join(msgs, "; "))
+}
+
+// CompileAll compiles the patterns, as New does, using up to parallelism
+// goroutines, or GOMAXPROCS if parallelism isn't positive.  The compiled
+// Regexps are returned in the order of the patterns.  If any pattern fails
+// to compile, its Regexp is nil, and the error returned is a CompileErrors
+// listing all the failures.
+func CompileAll(patterns []string, parallelism int) ([]*Regexp, error) {
+	return CompileAllWithOpts(patterns, parallelism, nil)
+}
+
+// CompileAllWithOpts compiles the patterns as CompileAll does, each
+// customized by the provided Opts, such as a StateCache shared by all of
+// them.  A StateWarning must be safe to call concurrently.
+func CompileAllWithOpts(patterns []string, parallelism int,
+	opts *Opts) ([]*Regexp, error) {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism > len(patterns) {
+		parallelism = len(patterns)
+	}
+	rv := make([]*Regexp, len(patterns))
+	errs := make([]error, len(patterns))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				rv[i], errs[i] = NewWithOpts(patterns[i], opts)
+			}
+		}()
+	}
+	for i := range patterns {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var compileErrs CompileErrors
+	for i, err := range errs {
+		if err != nil {
+			compileErrs = append(compileErrs, &CompileError{
+				Index:   i,
+				Pattern: patterns[i],
+				Err:     err,
+			})
+		}
+	}
+	if len(compileErrs) > 0 {
+		return rv, compileErrs
+	}
+	return rv, nil
+}
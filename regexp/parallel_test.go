@@ -0,0 +1,71 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCompileAll(t *testing.T) {
+	var patterns []string
+	for i := 0; i < 50; i++ {
+		patterns = append(patterns, fmt.Sprintf("user_%d_[0-9]+_(read|write)", i%10))
+	}
+	patterns[7] = "a(b"
+	patterns[31] = `\b`
+
+	for _, opts := range []*Opts{nil, {StateCache: NewStateCache()}} {
+		rv, err := CompileAllWithOpts(patterns, 4, opts)
+		var errs CompileErrors
+		if !errors.As(err, &errs) || len(errs) != 2 ||
+			errs[0].Index != 7 || errs[1].Index != 31 {
+			t.Fatalf("expected errors for patterns 7 and 31, got %v", err)
+		}
+		if !errors.Is(errs[1], ErrNoWordBoundary) {
+			t.Errorf("expected ErrNoWordBoundary, got %v", errs[1].Err)
+		}
+		for i, r := range rv {
+			if (r == nil) != (i == 7 || i == 31) {
+				t.Fatalf("unexpected result for pattern %d: %v", i, r)
+			}
+			if r == nil {
+				continue
+			}
+			want, err := New(patterns[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, input := range []string{fmt.Sprintf("user_%d_12_read", i%10),
+				"user_1_x_read", "user_3_4_write"} {
+				isMatch, _ := run(r, input)
+				wantMatch, _ := run(want, input)
+				if isMatch != wantMatch {
+					t.Errorf("pattern %d on %s: expected %t", i, input, wantMatch)
+				}
+			}
+		}
+	}
+
+	rv, err := CompileAll(patterns[:5], 0)
+	if err != nil || len(rv) != 5 {
+		t.Errorf("expected 5 regexps, got %d %v", len(rv), err)
+	}
+	rv, err = CompileAll(nil, 0)
+	if err != nil || len(rv) != 0 {
+		t.Errorf("expected no regexps, got %d %v", len(rv), err)
+	}
+}
@@ -15,6 +15,7 @@
 package regexp
 
 import (
+	"errors"
 	"fmt"
 	"regexp/syntax"
 )
@@ -28,7 +29,10 @@ var ErrNoWordBoundary = fmt.Errorf("word boundaries are not allowed")
 // ErrNoBytes returned when byte literals are used
 var ErrNoBytes = fmt.Errorf("byte literals are not allowed")
 
-// ErrNoLazy returned when lazy quantifiers are used
+// ErrNoLazy was returned when lazy quantifiers were used.
+//
+// Deprecated: lazy quantifiers match the same keys as greedy ones, they
+// are supported, it is no longer returned.
 var ErrNoLazy = fmt.Errorf("lazy quantifiers are not allowed")
 
 // ErrCompiledTooBig returned when regular expression parses into
@@ -37,15 +41,106 @@ var ErrCompiledTooBig = fmt.Errorf("too many instructions")
 
 var DefaultLimit = uint(10 * (1 << 20))
 
+// DefaultStateWarningThresholds are the fractions of the state limit at which
+// a StateWarningFunc is invoked, if no other thresholds are configured.
+var DefaultStateWarningThresholds = []float64{0.5, 0.9}
+
+// StateWarningFunc is invoked while the DFA is being constructed, each
+// time the number of states crosses one of the configured soft thresholds.
+// The threshold crossed, the partial number of states built so far and the
+// hard state limit are provided, allowing callers to log or alert on
+// patterns trending toward rejection with ErrTooManyStates.
+type StateWarningFunc func(threshold float64, states, limit int)
+
+// Opts is a structure to let advanced users customize how a Regexp
+// automaton is compiled.
+type Opts struct {
+	// SizeLimit is the approximate maximum size of the compiled
+	// instructions, if zero DefaultLimit is used.
+	SizeLimit uint
+
+	// StateLimit is the maximum number of DFA states, if zero the
+	// StateLimit constant is used.  Beyond it, a *TooManyStatesError is
+	// returned.
+	StateLimit int
+
+	// MemoryLimit, if positive, is the approximate maximum memory used by
+	// the DFA states, in bytes, each using about 2KB on 64-bit platforms.
+	// Beyond it, a *TooManyStatesError is returned.
+	MemoryLimit int
+
+	// StateWarning, if set, is invoked when DFA construction crosses
+	// each of the StateWarningThresholds.
+	StateWarning StateWarningFunc
+
+	// StateWarningThresholds are fractions of the state limit, if empty
+	// DefaultStateWarningThresholds is used.
+	StateWarningThresholds []float64
+
+	// StateCache, if set, is shared with the other Regexps compiled with
+	// it, reducing their combined memory.  If an identical DFA is found in
+	// the cache, it is used without construction, and StateWarning is not
+	// invoked.
+	StateCache *StateCache
+
+	// Separators are bytes which split keys into segments, such as '/'
+	// for paths.  They are treated like newlines, allowing (?m)^ and (?m)$
+	// to match at the start and end of a segment, and \b and \B to match
+	// at and away from segment boundaries, with the start and end of the
+	// key treated as separators.  Also . (without the s flag) does not
+	// match separators, so .* can't cross a segment boundary.  Separators
+	// must be ASCII bytes, otherwise ErrInvalidSeparator is returned.
+	Separators []byte
+
+	// Bytes compiles the expression in byte mode, where each character
+	// matches the byte with its value, rather than its UTF-8 encoding, so
+	// `[\x80-\xff]` matches a single byte with the high bit set, and .
+	// matches any byte but a newline.  This allows patterns over binary
+	// keys, such as packed integers or hashes, see QuoteBytes.  Characters
+	// beyond \xff are rejected with ErrNotByte, and classes ignore them.
+	// Byte mode expressions are always compiled strictly.
+	Bytes bool
+
+	// Lazy builds the DFA states as the automaton is run, rather than all
+	// of them up front, so that patterns whose DFA is too large to build
+	// can still be used when searches reach a fraction of its states.  The
+	// state and memory limits, StateWarning and StateCache don't apply,
+	// instead the transitions of LazyStates states are kept, those of the
+	// least recently used states being computed again when needed.  Each
+	// state reached keeps its instructions while the Regexp is in use.  A
+	// lazy Regexp is safe for concurrent use, but its transitions are
+	// serialized.
+	Lazy bool
+
+	// LazyStates is the number of states whose transitions are kept in
+	// Lazy mode, if zero DefaultLazyStates is used.
+	LazyStates int
+
+	// Mode selects how constructs outside the supported dialect are
+	// handled, Strict by default.  Expressions compiled with Separators
+	// are always compiled strictly, as the standard library regexp package
+	// doesn't know about them.
+	Mode Mode
+}
+
 // Regexp implements the vellum.Automaton interface for matcing a user
 // specified regular expression.
 type Regexp struct {
 	orig string
 	dfa  *dfa
+	// trie is used instead of dfa for alternations of literals
+	trie *literalTrie
+	// std is used instead of dfa in Lenient mode, for constructs outside
+	// the dialect
+	std *stdAutomaton
+	// lazy is used instead of dfa in Lazy mode
+	lazy *lazyDFA
 }
 
 // NewRegexp creates a new Regular Expression automaton with the specified
-// expression.  By default it is limited to approximately 10MB for the
+// expression.  Patterns may be compiled concurrently from many goroutines,
+// see also CompileAll, and a compiled Regexp is immutable, so it is safe
+// for concurrent use.  By default it is limited to approximately 10MB for the
 // compiled finite state automaton.  If this size is exceeded,
 // ErrCompiledTooBig will be returned.
 func New(expr string) (*Regexp, error) {
@@ -65,16 +160,113 @@ func NewWithLimit(expr string, size uint) (*Regexp, error) {
 }
 
 func NewParsedWithLimit(expr string, parsed *syntax.Regexp, size uint) (*Regexp, error) {
+	return NewParsedWithOpts(expr, parsed, &Opts{SizeLimit: size})
+}
+
+// NewWithLimits creates a new Regular Expression automaton with the
+// specified expression, whose DFA may have at most maxStates states (the
+// StateLimit constant if zero), using about maxMemory bytes (unlimited if
+// zero).  If either limit is exceeded, a *TooManyStatesError is returned,
+// reporting the number of states built.
+func NewWithLimits(expr string, maxStates, maxMemory int) (*Regexp, error) {
+	return NewWithOpts(expr, &Opts{
+		StateLimit:  maxStates,
+		MemoryLimit: maxMemory,
+	})
+}
+
+// NewWithOpts creates a new Regular Expression automaton with the
+// specified expression, compiled as customized by the provided Opts.
+func NewWithOpts(expr string, opts *Opts) (*Regexp, error) {
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return NewParsedWithOpts(expr, parsed, opts)
+}
+
+// NewParsedWithOpts creates a new Regular Expression automaton from the
+// already parsed expression, compiled as customized by the provided Opts.
+// Alternations of literals are compiled into a trie of the literals, which
+// is not subject to the state limits, nor shared through a StateCache.
+func NewParsedWithOpts(expr string, parsed *syntax.Regexp, opts *Opts) (*Regexp, error) {
+	if opts == nil {
+		opts = &Opts{}
+	}
+	size := opts.SizeLimit
+	if size == 0 {
+		size = DefaultLimit
+	}
+	seps, err := separatorSet(opts.Separators)
+	if err != nil {
+		return nil, err
+	}
+	if literals, ok := alternationLiterals(parsed, size, opts.Bytes); ok {
+		trie, err := newLiteralTrie(literals, size)
+		if err != nil {
+			return nil, err
+		}
+		return &Regexp{
+			orig: expr,
+			trie: trie,
+		}, nil
+	}
 	compiler := newCompiler(size)
+	compiler.separators = seps
+	compiler.bytes = opts.Bytes
 	insts, err := compiler.compile(parsed)
+	var uerr *UnsupportedError
+	if opts.Mode == Lenient && seps == nil && !opts.Bytes &&
+		errors.As(err, &uerr) {
+		std, err := newStdAutomaton(parsed)
+		if err != nil {
+			return nil, err
+		}
+		return &Regexp{
+			orig: expr,
+			std:  std,
+		}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	dfaBuilder := newDfaBuilder(insts)
+	if opts.Lazy {
+		return &Regexp{
+			orig: expr,
+			lazy: newLazyDFA(insts, seps, opts.LazyStates),
+		}, nil
+	}
+	var cacheKey string
+	if opts.StateCache != nil {
+		cacheKey = progKey(insts, seps)
+		if dfa := opts.StateCache.lookup(cacheKey); dfa != nil {
+			// it may have been built with higher limits
+			err = checkLimits(dfa, stateLimit(opts.StateLimit), opts.MemoryLimit)
+			if err != nil {
+				return nil, err
+			}
+			return &Regexp{
+				orig: expr,
+				dfa:  dfa,
+			}, nil
+		}
+	}
+	dfaBuilder := newDfaBuilder(insts, seps)
+	dfaBuilder.setLimits(opts.StateLimit, opts.MemoryLimit)
+	if opts.StateWarning != nil {
+		thresholds := opts.StateWarningThresholds
+		if len(thresholds) == 0 {
+			thresholds = DefaultStateWarningThresholds
+		}
+		dfaBuilder.setStateWarning(opts.StateWarning, thresholds)
+	}
 	dfa, err := dfaBuilder.build()
 	if err != nil {
 		return nil, err
 	}
+	if opts.StateCache != nil {
+		dfa = opts.StateCache.share(cacheKey, dfa)
+	}
 	return &Regexp{
 		orig: expr,
 		dfa:  dfa,
@@ -88,6 +280,15 @@ func (r *Regexp) Start() int {
 
 // IsMatch returns if the specified state is a matching state.
 func (r *Regexp) IsMatch(s int) bool {
+	if r.trie != nil {
+		return r.trie.isMatch(s)
+	}
+	if r.std != nil {
+		return r.std.isMatch(s)
+	}
+	if r.lazy != nil {
+		return r.lazy.isMatch(s)
+	}
 	if s < len(r.dfa.states) {
 		return r.dfa.states[s].match
 	}
@@ -97,6 +298,16 @@ func (r *Regexp) IsMatch(s int) bool {
 // CanMatch returns if the specified state can ever transition to a matching
 // state.
 func (r *Regexp) CanMatch(s int) bool {
+	if r.trie != nil {
+		return r.trie.canMatch(s)
+	}
+	if r.std != nil {
+		// accept only reaches states which can match
+		return s > 0
+	}
+	if r.lazy != nil {
+		return s > 0 && s < r.lazy.numStates()
+	}
 	if s < len(r.dfa.states) && s > 0 {
 		return true
 	}
@@ -112,6 +323,15 @@ func (r *Regexp) WillAlwaysMatch(int) bool {
 // Accept returns the new state, resulting from the transition byte b
 // when currently in the state s.
 func (r *Regexp) Accept(s int, b byte) int {
+	if r.trie != nil {
+		return r.trie.accept(s, b)
+	}
+	if r.std != nil {
+		return r.std.accept(s, b)
+	}
+	if r.lazy != nil {
+		return r.lazy.accept(s, b)
+	}
 	if s < len(r.dfa.states) {
 		return r.dfa.states[s].next[b]
 	}
@@ -15,7 +15,11 @@
 package regexp
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	stdregexp "regexp"
+	"strings"
 	"testing"
 )
 
@@ -190,8 +194,286 @@ func TestRegexp(t *testing.T) {
 
 }
 
+func TestSeparators(t *testing.T) {
+	// with only word characters and separators in the keys, treating the
+	// separators as newlines gives the expected matches
+	var keys [][]byte
+	keys = append(keys, []byte{})
+	for i := 0; i < len(keys) && len(keys[i]) < 5; i++ {
+		for _, b := range []byte("ab/") {
+			keys = append(keys, append(append([]byte(nil), keys[i]...), b))
+		}
+	}
+	for _, query := range []string{
+		`a.*`,
+		`(?s)a.*`,
+		`.*/b`,
+		`(?m)a$.*`,
+		`(?m)(.*/)?^b$(/.*)?`,
+		`(?s).*\bab\b.*`,
+		`(?s)\Ba.*`,
+		`(?s).*b\B`,
+		`\b(a|/)*\B`,
+		`(\b|a/)*b`,
+	} {
+		r, err := NewWithOpts(query, &Opts{Separators: []byte("/")})
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", query, err)
+		}
+		expected := stdregexp.MustCompile(`\A(?:` +
+			strings.Replace(query, "/", `\n`, -1) + `)\z`)
+		for _, key := range keys {
+			s := r.Start()
+			for _, b := range key {
+				s = r.Accept(s, b)
+			}
+			want := expected.Match(bytes.Replace(key, []byte("/"), []byte("\n"), -1))
+			if r.IsMatch(s) != want {
+				t.Errorf("%s: expected match %q %t", query, key, want)
+			}
+		}
+	}
+
+	_, err := New(`\bfoo`)
+	if !errors.Is(err, ErrNoWordBoundary) {
+		t.Errorf("expected ErrNoWordBoundary without separators, got %v", err)
+	}
+	_, err = NewWithOpts(`a`, &Opts{Separators: []byte("\xe2")})
+	if err != ErrInvalidSeparator {
+		t.Errorf("expected ErrInvalidSeparator, got %v", err)
+	}
+
+	// the separators are part of the DFA shared through the cache
+	cache := NewStateCache()
+	withSeps, err := NewWithOpts(`a.*`, &Opts{StateCache: cache,
+		Separators: []byte("/")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutSeps, err := NewWithOpts(`a.*`, &Opts{StateCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withSeps.dfa == withoutSeps.dfa {
+		t.Errorf("expected distinct DFAs with and without separators")
+	}
+}
+
 func BenchmarkNewWildcard(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		New("my.*h")
 	}
 }
+
+func TestStateWarning(t *testing.T) {
+	type warning struct {
+		threshold float64
+		states    int
+	}
+	var warnings []warning
+	opts := &Opts{
+		StateWarning: func(threshold float64, states, limit int) {
+			if limit != StateLimit {
+				t.Errorf("expected limit %d, got %d", StateLimit, limit)
+			}
+			warnings = append(warnings, warning{threshold, states})
+		},
+	}
+
+	// requires a small number of states, no warnings expected
+	_, err := NewWithOpts(`wat.r`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+
+	// requires more than StateLimit states, expect both default
+	// thresholds to be crossed before failing
+	_, err = NewWithOpts(`(a|b)*a(a|b){13}`, opts)
+	if !errors.Is(err, ErrTooManyStates) {
+		t.Fatalf("expected ErrTooManyStates, got %v", err)
+	}
+	if len(warnings) != len(DefaultStateWarningThresholds) {
+		t.Fatalf("expected %d warnings, got %v",
+			len(DefaultStateWarningThresholds), warnings)
+	}
+	for i, w := range warnings {
+		if w.threshold != DefaultStateWarningThresholds[i] {
+			t.Errorf("expected threshold %f, got %f",
+				DefaultStateWarningThresholds[i], w.threshold)
+		}
+		if float64(w.states) < w.threshold*StateLimit {
+			t.Errorf("expected at least %f states, got %d",
+				w.threshold*StateLimit, w.states)
+		}
+	}
+
+	// custom thresholds, unsorted
+	warnings = warnings[:0]
+	opts.StateWarningThresholds = []float64{0.3, 0.1}
+	_, err = NewWithOpts(`(a|b)*a(a|b){11}`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 2 || warnings[0].threshold != 0.1 ||
+		warnings[1].threshold != 0.3 {
+		t.Errorf("expected warnings at 0.1 and 0.3, got %v", warnings)
+	}
+}
+
+func TestStateLimits(t *testing.T) {
+	// more than StateLimit states, allowed by a higher limit
+	r, err := NewWithLimits(`(a|b)*a(a|b){13}`, 1<<15, 0)
+	if err != nil {
+		t.Fatalf("expected higher limit to be allowed, got %v", err)
+	}
+	if r.numStates() <= StateLimit {
+		t.Errorf("expected more than %d states, got %d", StateLimit,
+			r.numStates())
+	}
+
+	tests := []struct {
+		states, memory int
+		want           TooManyStatesError
+	}{
+		{
+			states: 10,
+			want:   TooManyStatesError{States: 11, StateLimit: 10},
+		},
+		{
+			memory: 10 * dfaStateSize,
+			want: TooManyStatesError{States: 11, StateLimit: StateLimit,
+				MemoryLimit: 10 * dfaStateSize},
+		},
+	}
+	for _, test := range tests {
+		_, err = NewWithLimits(`(a|b)*a(a|b){5}`, test.states, test.memory)
+		var serr *TooManyStatesError
+		if !errors.As(err, &serr) || !errors.Is(err, ErrTooManyStates) {
+			t.Fatalf("expected TooManyStatesError, got %v", err)
+		}
+		test.want.Memory = test.want.States * dfaStateSize
+		if *serr != test.want {
+			t.Errorf("expected %+v, got %+v", test.want, *serr)
+		}
+	}
+
+	// a cached DFA built with higher limits is checked against lower ones
+	cache := NewStateCache()
+	_, err = NewWithOpts(`(a|b)*a(a|b){5}`, &Opts{StateCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewWithOpts(`(a|b)*a(a|b){5}`, &Opts{StateCache: cache,
+		StateLimit: 10})
+	if !errors.Is(err, ErrTooManyStates) {
+		t.Errorf("expected ErrTooManyStates from cache, got %v", err)
+	}
+}
+
+func TestStateCache(t *testing.T) {
+	cache := NewStateCache()
+	patterns := []string{
+		`user_[0-9]+_(read|write)`,
+		`item_[0-9]+_(read|write)`,
+		`user_[0-9]+_(read|write)`,
+		`[a-c]+`,
+		`(a|b|c)+`,
+	}
+	var shared []*Regexp
+	for _, pattern := range patterns {
+		r, err := NewWithOpts(pattern, &Opts{StateCache: cache})
+		if err != nil {
+			t.Fatalf("error compiling %s: %v", pattern, err)
+		}
+		shared = append(shared, r)
+	}
+
+	stats := cache.Stats()
+	if stats.SharedDFAs != 2 {
+		t.Errorf("expected 2 shared dfas, got %d", stats.SharedDFAs)
+	}
+	if stats.DFAs != 3 {
+		t.Errorf("expected 3 dfas, got %d", stats.DFAs)
+	}
+	if stats.SharedRows == 0 {
+		t.Errorf("expected some shared rows, got none")
+	}
+
+	// shared automata must behave exactly as unshared ones
+	inputs := []string{"user_12_read", "item_3_write", "user__read",
+		"item_12_reads", "abcabc", "abd", ""}
+	for i, pattern := range patterns {
+		r, err := New(pattern)
+		if err != nil {
+			t.Fatalf("error compiling %s: %v", pattern, err)
+		}
+		for _, input := range inputs {
+			want := r.Start()
+			got := shared[i].Start()
+			for _, b := range []byte(input) {
+				want = r.Accept(want, b)
+				got = shared[i].Accept(got, b)
+			}
+			if r.IsMatch(want) != shared[i].IsMatch(got) ||
+				r.CanMatch(want) != shared[i].CanMatch(got) {
+				t.Errorf("pattern %s input %s: shared dfa differs", pattern, input)
+			}
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	lower := &[256]bool{}
+	for b := 'a'; b <= 'z'; b++ {
+		lower[b] = true
+	}
+	tests := []struct {
+		pattern  string
+		alphabet *[256]bool
+		bytes    string
+		canMatch bool
+	}{
+		{pattern: `ab|cd`, bytes: "abcd", canMatch: true},
+		{pattern: `ab|cd`, alphabet: lower, bytes: "abcd", canMatch: true},
+		{pattern: `Ab|cd`, alphabet: lower, bytes: "cd", canMatch: true},
+		{pattern: `[A-Z]+`, alphabet: lower, canMatch: false},
+		{pattern: `[A-Z]+`, bytes: "ABCDEFGHIJKLMNOPQRSTUVWXYZ", canMatch: true},
+	}
+	for _, test := range tests {
+		r, err := New(test.pattern)
+		if err != nil {
+			t.Fatalf("error compiling %s: %v", test.pattern, err)
+		}
+		report := r.Report(test.alphabet)
+		var got string
+		for b, used := range report.Bytes {
+			if used {
+				got += string(rune(b))
+			}
+		}
+		if got != test.bytes {
+			t.Errorf("%s: expected bytes %q, got %q", test.pattern, test.bytes, got)
+		}
+		if report.NumBytes() != len(test.bytes) {
+			t.Errorf("%s: expected %d bytes, got %d", test.pattern,
+				len(test.bytes), report.NumBytes())
+		}
+		if report.CanMatch != test.canMatch {
+			t.Errorf("%s: expected can match %t", test.pattern, test.canMatch)
+		}
+		if report.States != r.numStates() || report.Size <= 0 {
+			t.Errorf("%s: unexpected size %d states %d", test.pattern,
+				report.Size, report.States)
+		}
+		err = r.CheckAlphabet(test.alphabet)
+		if test.canMatch && err != nil {
+			t.Errorf("%s: unexpected error %v", test.pattern, err)
+		}
+		if !test.canMatch && !errors.Is(err, ErrAlphabetMismatch) {
+			t.Errorf("%s: expected ErrAlphabetMismatch, got %v", test.pattern, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regexp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// sizes used by Report, obtained without unsafe
+var stateSize = int(reflect.TypeOf(state{}).Size())
+var intSize = int(reflect.TypeOf(0).Size())
+
+// ErrAlphabetMismatch is returned by CheckAlphabet when the Regexp can't
+// match any key made only of bytes in the alphabet.
+var ErrAlphabetMismatch = fmt.Errorf("regexp can never match keys in alphabet")
+
+// Report describes the size of a compiled Regexp, and the bytes it uses,
+// when run over keys made of bytes from an alphabet.
+type Report struct {
+	// States is the number of DFA states, including the dead state.
+	States int
+	// Size is the approximate memory used by the DFA, in bytes.  States
+	// shared through a StateCache are counted in full.
+	Size int
+	// LiveStates is the number of states reachable from the start, using
+	// the alphabet, from which a match is still possible.
+	LiveStates int
+	// Bytes are the byte values used by a transition between live states.
+	// Keys containing any other byte can never match.
+	Bytes [256]bool
+	// CanMatch is true if some key, made only of bytes in the alphabet,
+	// matches.
+	CanMatch bool
+}
+
+// NumBytes returns the number of distinct values in Bytes.
+func (r *Report) NumBytes() int {
+	var rv int
+	for _, used := range r.Bytes {
+		if used {
+			rv++
+		}
+	}
+	return rv
+}
+
+// Report returns a Report describing the Regexp run over keys made of bytes
+// from the alphabet.  A nil alphabet allows all bytes.  The alphabet of an
+// FST is obtained with its Alphabet method.
+func (r *Regexp) Report(alphabet *[256]bool) *Report {
+	if r.std != nil {
+		return r.std.report(alphabet)
+	}
+	if r.lazy != nil {
+		return r.lazy.report(alphabet)
+	}
+	numStates := r.numStates()
+	rv := &Report{
+		States: numStates,
+	}
+	if r.trie != nil {
+		rv.Size = len(r.trie.match)*trieStateSize +
+			len(r.trie.labels)*trieTransitionSize
+	} else {
+		for _, s := range r.dfa.states {
+			rv.Size += stateSize + len(s.next)*intSize
+		}
+	}
+
+	allowed := func(b int) bool {
+		return alphabet == nil || alphabet[b]
+	}
+
+	// states reachable from the start, using the alphabet
+	reachable := make([]bool, numStates)
+	stack := intStack{r.Start()}
+	reachable[r.Start()] = true
+	var s int
+	for len(stack) > 0 {
+		stack, s = stack.Pop()
+		r.transitions(s, func(b, next int) bool {
+			if allowed(b) && !reachable[next] {
+				reachable[next] = true
+				stack = stack.Push(next)
+			}
+			return true
+		})
+	}
+
+	// states from which a match is reachable, using the alphabet
+	live := make([]bool, numStates)
+	for changed := true; changed; {
+		changed = false
+		for i := 1; i < numStates; i++ {
+			if live[i] {
+				continue
+			}
+			if r.IsMatch(i) {
+				live[i] = true
+				changed = true
+				continue
+			}
+			r.transitions(i, func(b, next int) bool {
+				if live[next] && allowed(b) {
+					live[i] = true
+					changed = true
+					return false
+				}
+				return true
+			})
+		}
+	}
+
+	for i := 1; i < numStates; i++ {
+		if !reachable[i] || !live[i] {
+			continue
+		}
+		rv.LiveStates++
+		r.transitions(i, func(b, next int) bool {
+			if live[next] && allowed(b) {
+				rv.Bytes[b] = true
+			}
+			return true
+		})
+	}
+	rv.CanMatch = live[r.Start()]
+	return rv
+}
+
+// numStates returns the number of states, including the dead state
+func (r *Regexp) numStates() int {
+	if r.trie != nil {
+		return len(r.trie.match)
+	}
+	if r.std != nil {
+		return r.std.numStates()
+	}
+	if r.lazy != nil {
+		return r.lazy.numStates()
+	}
+	return len(r.dfa.states)
+}
+
+// transitions calls fn with the byte and target of each transition of the
+// state to a state other than the dead one, until fn returns false
+func (r *Regexp) transitions(s int, fn func(b, next int) bool) {
+	if r.trie != nil {
+		for i := r.trie.start[s]; i < r.trie.end[s]; i++ {
+			if !fn(int(r.trie.labels[i]), int(r.trie.targets[i])) {
+				return
+			}
+		}
+		return
+	}
+	for b, next := range r.dfa.states[s].next {
+		if next != 0 && !fn(b, next) {
+			return
+		}
+	}
+}
+
+// CheckAlphabet returns ErrAlphabetMismatch if the Regexp can't match any
+// key made only of bytes in the alphabet, such as an uppercase pattern run
+// over a lowercase dictionary.  Searching with such a Regexp silently finds
+// nothing.
+func (r *Regexp) CheckAlphabet(alphabet *[256]bool) error {
+	if !r.Report(alphabet).CanMatch {
+		return fmt.Errorf("%w: %q", ErrAlphabetMismatch, r.orig)
+	}
+	return nil
+}
@@ -14,16 +14,108 @@
 
 package vellum
 
+import (
+	"encoding/binary"
+	"reflect"
+)
+
+// RegistryStats describes the lookups of compiled states in the registry
+// of a Builder, to help choose its size and hash function, see
+// BuilderOpts.RegistryHash.
+type RegistryStats struct {
+	// Lookups is the number of states looked up
+	Lookups int `json:"lookups"`
+	// Hits is the number of states found already compiled
+	Hits int `json:"hits"`
+	// Probes is the number of registered states compared to the states
+	// looked up
+	Probes int `json:"probes"`
+	// MaxProbes is the largest number of registered states compared in
+	// one lookup
+	MaxProbes int `json:"max_probes"`
+	// Collisions is the number of states not found in a bucket holding
+	// other states
+	Collisions int `json:"collisions"`
+	// Evictions is the number of registered states forgotten to make room
+	// for others
+	Evictions int `json:"evictions"`
+	// Buckets is the number of buckets of the registry, BucketsUsed the
+	// number of them holding states
+	Buckets     int `json:"buckets"`
+	BucketsUsed int `json:"buckets_used"`
+}
+
+// RegistryStats returns statistics about the lookups in the registry of
+// compiled states since the Builder was created or last Reset.
+func (b *Builder) RegistryStats() RegistryStats {
+	return b.registry.getStats()
+}
+
+// lookup records a lookup comparing probes registered states
+func (s *RegistryStats) lookup(probes int, found, occupied, evicted bool) {
+	s.Lookups++
+	s.Probes += probes
+	if probes > s.MaxProbes {
+		s.MaxProbes = probes
+	}
+	if found {
+		s.Hits++
+		return
+	}
+	if occupied {
+		s.Collisions++
+	}
+	if evicted {
+		s.Evictions++
+	}
+}
+
 type registryCell struct {
 	addr int
 	node *builderNode
 }
 
+var registryCellSize = int(reflect.TypeOf(registryCell{}).Size())
+
+// registryTableSize returns the number of buckets of the registry, shrunk
+// so that the table uses at most half of MaxMemory
+func (o *BuilderOpts) registryTableSize() int {
+	rv := o.RegistryTableSize
+	if o.MaxMemory > 0 && o.RegistryMRUSize > 0 {
+		max := o.MaxMemory / 2 / registryCellSize / o.RegistryMRUSize
+		if max < 1 {
+			max = 1
+		}
+		if rv > max {
+			rv = max
+		}
+	}
+	return rv
+}
+
 type registry struct {
 	builderNodePool *builderNodePool
 	table           []registryCell
 	tableSize       uint
 	mruSize         uint
+
+	// spilling to a memory-mapped file, see BuilderOpts.RegistrySpillThreshold
+	spillThreshold int
+	spillDir       string
+	heapBytes      int
+	spill          *registrySpill
+
+	// maxHeapBytes, if positive, bounds heapBytes when not spilling, see
+	// BuilderOpts.MaxMemory, shedding buckets from the sweep bucket on
+	maxHeapBytes int
+	sweep        int
+
+	// hashFunc, if set, hashes the nodes serialized in buf, see
+	// BuilderOpts.RegistryHash
+	hashFunc func([]byte) uint64
+	buf      []byte
+
+	stats RegistryStats
 }
 
 func newRegistry(p *builderNodePool, tableSize, mruSize int) *registry {
@@ -43,6 +135,9 @@ func (r *registry) Reset() {
 		r.builderNodePool.Put(r.table[i].node)
 		r.table[i] = empty
 	}
+	r.heapBytes = 0
+	r.sweep = 0
+	r.stats = RegistryStats{}
 }
 
 func (r *registry) entry(node *builderNode) (bool, int, *registryCell) {
@@ -50,15 +145,122 @@ func (r *registry) entry(node *builderNode) (bool, int, *registryCell) {
 		return false, 0, nil
 	}
 	bucket := r.hash(node)
+	if r.spill != nil {
+		return r.spill.entry(node, bucket, int(r.mruSize), &r.stats)
+	}
 	start := r.mruSize * uint(bucket)
 	end := start + r.mruSize
 	rc := registryCache(r.table[start:end])
-	return rc.entry(node, r.builderNodePool)
+	found, addr, cell, evicted := rc.entry(node, &r.stats)
+	if !found {
+		r.heapBytes += node.heapSize()
+		if evicted != nil {
+			r.heapBytes -= evicted.heapSize()
+			r.builderNodePool.Put(evicted)
+		}
+	}
+	return found, addr, cell
+}
+
+// set records the address of the node newly registered in the cell
+func (r *registry) set(cell *registryCell, addr int) {
+	if cell == nil {
+		return
+	}
+	cell.addr = addr
+	if r.spill != nil {
+		r.spill.set(cell)
+		r.builderNodePool.Put(cell.node)
+		cell.node = nil
+	}
+}
+
+// maybeSpill moves the registered nodes into a memory-mapped file, if
+// they use more memory than allowed
+func (r *registry) maybeSpill() error {
+	if r.spill != nil || r.spillThreshold <= 0 ||
+		r.heapBytes <= r.spillThreshold || len(r.table) == 0 {
+		return nil
+	}
+	arenaSize := 4 * r.spillThreshold
+	if arenaSize < minSpillArenaSize {
+		arenaSize = minSpillArenaSize
+	}
+	spill, err := newRegistrySpill(r.spillDir, len(r.table), arenaSize)
+	if err != nil {
+		return err
+	}
+	var empty registryCell
+	for i := range r.table {
+		if r.table[i].node != nil && r.table[i].addr != 0 {
+			spill.migrate(i, &r.table[i])
+		}
+		r.builderNodePool.Put(r.table[i].node)
+		r.table[i] = empty
+	}
+	r.heapBytes = 0
+	r.spill = spill
+	return nil
+}
+
+// maybeShed forgets the nodes registered in memory, bucket by bucket, if
+// they use more memory than allowed, until they use at most three
+// quarters of it.  The nodes are dropped rather than pooled, for their
+// memory to be released.
+func (r *registry) maybeShed() {
+	if r.spill != nil || r.maxHeapBytes <= 0 ||
+		r.heapBytes <= r.maxHeapBytes || r.tableSize == 0 {
+		return
+	}
+	target := r.maxHeapBytes / 4 * 3
+	var empty registryCell
+	for n := 0; r.heapBytes > target && n < int(r.tableSize); n++ {
+		start := r.sweep * int(r.mruSize)
+		for i := start; i < start+int(r.mruSize); i++ {
+			if r.table[i].node != nil {
+				r.heapBytes -= r.table[i].node.heapSize()
+				r.stats.Evictions++
+			}
+			r.table[i] = empty
+		}
+		r.sweep = (r.sweep + 1) % int(r.tableSize)
+	}
+}
+
+// closeSpill releases the memory-mapped file, if any, returning to
+// registering nodes in memory
+func (r *registry) closeSpill() error {
+	if r.spill == nil {
+		return nil
+	}
+	err := r.spill.close()
+	r.spill = nil
+	return err
+}
+
+// getStats returns the statistics of the lookups so far
+func (r *registry) getStats() RegistryStats {
+	rv := r.stats
+	rv.Buckets = int(r.tableSize)
+	for i := 0; i < int(r.tableSize); i++ {
+		if r.spill != nil {
+			if r.spill.occupied(i * int(r.mruSize)) {
+				rv.BucketsUsed++
+			}
+		} else if r.table[i*int(r.mruSize)].node != nil {
+			rv.BucketsUsed++
+		}
+	}
+	return rv
 }
 
 const fnvPrime = 1099511628211
 
 func (r *registry) hash(b *builderNode) int {
+	if r.hashFunc != nil {
+		r.buf = appendRegistryKey(r.buf[:0], b)
+		return int(r.hashFunc(r.buf) % uint64(r.tableSize))
+	}
 	var final uint64
 	if b.final {
 		final = 1
@@ -75,31 +277,65 @@ func (r *registry) hash(b *builderNode) int {
 	return int(h % uint64(r.tableSize))
 }
 
+// appendRegistryKey appends the bytes hashed by BuilderOpts.RegistryHash
+// for the node
+func appendRegistryKey(dst []byte, b *builderNode) []byte {
+	var buf [17]byte
+	if b.final {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	binary.LittleEndian.PutUint64(buf[:], b.finalOutput)
+	dst = append(dst, buf[:8]...)
+	for _, t := range b.trans {
+		buf[0] = t.in
+		binary.LittleEndian.PutUint64(buf[1:], t.out)
+		binary.LittleEndian.PutUint64(buf[9:], uint64(t.addr))
+		dst = append(dst, buf[:]...)
+	}
+	return dst
+}
+
 type registryCache []registryCell
 
-func (r registryCache) entry(node *builderNode, pool *builderNodePool) (bool, int, *registryCell) {
+// entry looks for a node equivalent to the node, or else registers the
+// node, returning the node evicted to make room for it, if any
+func (r registryCache) entry(node *builderNode, stats *RegistryStats) (bool, int, *registryCell, *builderNode) {
+	occupied := r[0].node != nil
 	if len(r) == 1 {
-		if r[0].node != nil && r[0].node.equiv(node) {
-			return true, r[0].addr, nil
+		if occupied && r[0].node.equiv(node) {
+			stats.lookup(1, true, true, false)
+			return true, r[0].addr, nil, nil
 		}
-		pool.Put(r[0].node)
+		evicted := r[0].node
 		r[0].node = node
-		return false, 0, &r[0]
+		probes := 0
+		if occupied {
+			probes = 1
+		}
+		stats.lookup(probes, false, occupied, occupied)
+		return false, 0, &r[0], evicted
 	}
+	probes := 0
 	for i := range r {
-		if r[i].node != nil && r[i].node.equiv(node) {
-			addr := r[i].addr
-			r.promote(i)
-			return true, addr, nil
+		if r[i].node != nil {
+			probes++
+			if r[i].node.equiv(node) {
+				addr := r[i].addr
+				r.promote(i)
+				stats.lookup(probes, true, true, false)
+				return true, addr, nil, nil
+			}
 		}
 	}
 	// no match
 	last := len(r) - 1
-	pool.Put(r[last].node)
+	evicted := r[last].node
+	stats.lookup(probes, false, occupied, evicted != nil)
 	r[last].node = node // discard LRU
 	r.promote(last)
-	return false, 0, &r[0]
-
+	return false, 0, &r[0], evicted
 }
 
 func (r registryCache) promote(i int) {
@@ -0,0 +1,169 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const minSpillArenaSize = 1 << 20
+
+// spilled cells are addr, offset and length, each 8 bytes
+const spillCellSize = 24
+
+// registrySpill holds the registry in a memory-mapped file, rather than on
+// the heap.  The file starts with the cells, laid out exactly as the
+// registry table, followed by an arena holding the serialized nodes the
+// cells refer to.  An addr of 0 marks an empty cell.
+type registrySpill struct {
+	closer io.Closer
+	cells  []byte
+	arena  []byte
+	used   int
+	buf    []byte
+
+	// pending is the cell returned by entry, until its address is set
+	pending     registryCell
+	pendingSlot int
+}
+
+func newRegistrySpill(dir string, numCells, arenaSize int) (*registrySpill, error) {
+	data, closer, err := mapSpillFile(dir, numCells*spillCellSize+arenaSize)
+	if err != nil {
+		return nil, err
+	}
+	return &registrySpill{
+		closer: closer,
+		cells:  data[:numCells*spillCellSize],
+		arena:  data[numCells*spillCellSize:],
+	}, nil
+}
+
+func (s *registrySpill) close() error {
+	s.cells, s.arena = nil, nil
+	return s.closer.Close()
+}
+
+func (s *registrySpill) cell(slot int) []byte {
+	return s.cells[slot*spillCellSize : (slot+1)*spillCellSize]
+}
+
+// serialize encodes the node in s.buf, equivalent nodes, and only those,
+// have identical encodings
+func (s *registrySpill) serialize(node *builderNode) {
+	s.buf = s.buf[:0]
+	if node.final {
+		s.buf = append(s.buf, 1)
+	} else {
+		s.buf = append(s.buf, 0)
+	}
+	s.appendUvarint(node.finalOutput)
+	s.appendUvarint(uint64(len(node.trans)))
+	for _, t := range node.trans {
+		s.buf = append(s.buf, t.in)
+		s.appendUvarint(t.out)
+		s.appendUvarint(uint64(t.addr))
+	}
+}
+
+func (s *registrySpill) appendUvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	s.buf = append(s.buf, tmp[:n]...)
+}
+
+// store copies s.buf into the arena, and records it in the cell at slot
+func (s *registrySpill) store(slot int, addr int) {
+	if s.used+len(s.buf) > len(s.arena) {
+		// full, forget everything registered so far
+		for i := range s.cells {
+			s.cells[i] = 0
+		}
+		s.used = 0
+	}
+	copy(s.arena[s.used:], s.buf)
+	c := s.cell(slot)
+	binary.LittleEndian.PutUint64(c, uint64(addr))
+	binary.LittleEndian.PutUint64(c[8:], uint64(s.used))
+	binary.LittleEndian.PutUint64(c[16:], uint64(len(s.buf)))
+	s.used += len(s.buf)
+}
+
+// migrate moves a cell of the in-memory registry into the file
+func (s *registrySpill) migrate(slot int, cell *registryCell) {
+	s.serialize(cell.node)
+	s.store(slot, cell.addr)
+}
+
+// occupied returns true if a node is recorded in the cell at slot
+func (s *registrySpill) occupied(slot int) bool {
+	return binary.LittleEndian.Uint64(s.cell(slot)) != 0
+}
+
+func (s *registrySpill) matches(slot int) (int, bool) {
+	c := s.cell(slot)
+	addr := int(binary.LittleEndian.Uint64(c))
+	if addr == 0 {
+		return 0, false
+	}
+	offset := binary.LittleEndian.Uint64(c[8:])
+	length := binary.LittleEndian.Uint64(c[16:])
+	if length != uint64(len(s.buf)) {
+		return 0, false
+	}
+	return addr, bytes.Equal(s.arena[offset:offset+length], s.buf)
+}
+
+// promote moves the cell at slot to the front of its bucket
+func (s *registrySpill) promote(start, slot int) {
+	var tmp [spillCellSize]byte
+	copy(tmp[:], s.cell(slot))
+	copy(s.cells[(start+1)*spillCellSize:], s.cells[start*spillCellSize:slot*spillCellSize])
+	copy(s.cell(start), tmp[:])
+}
+
+// entry behaves as registryCache.entry, for the bucket, the node is
+// recorded when its address is set
+func (s *registrySpill) entry(node *builderNode, bucket, mruSize int,
+	stats *RegistryStats) (bool, int, *registryCell) {
+	s.serialize(node)
+	start := bucket * mruSize
+	probes := 0
+	for i := start; i < start+mruSize; i++ {
+		if s.occupied(i) {
+			probes++
+		}
+		if addr, ok := s.matches(i); ok {
+			s.promote(start, i)
+			stats.lookup(probes, true, true, false)
+			return true, addr, nil
+		}
+	}
+	// make room at the front, discarding the least recently used
+	last := start + mruSize - 1
+	stats.lookup(probes, false, s.occupied(start), s.occupied(last))
+	s.promote(start, last)
+	s.pending = registryCell{node: node}
+	s.pendingSlot = start
+	return false, 0, &s.pending
+}
+
+// set records the pending cell, once its address is known
+func (s *registrySpill) set(cell *registryCell) {
+	s.serialize(cell.node)
+	s.store(s.pendingSlot, cell.addr)
+}
@@ -0,0 +1,63 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nommap
+
+package vellum
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	mmap "github.com/edsrzf/mmap-go"
+)
+
+type spillFile struct {
+	f  *os.File
+	mm mmap.MMap
+}
+
+func (s *spillFile) Close() error {
+	err := s.mm.Unmap()
+	err2 := s.f.Close()
+	if err == nil {
+		err = err2
+	}
+	err2 = os.Remove(s.f.Name())
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// mapSpillFile creates a temporary file of the provided size, and maps it
+// for writing.  Closing the returned Closer deletes the file.
+func mapSpillFile(dir string, size int) ([]byte, io.Closer, error) {
+	f, err := ioutil.TempFile(dir, "vellum-registry-")
+	if err != nil {
+		return nil, nil, err
+	}
+	err = f.Truncate(int64(size))
+	if err == nil {
+		var mm mmap.MMap
+		mm, err = mmap.MapRegion(f, size, mmap.RDWR, 0, 0)
+		if err == nil {
+			return mm, &spillFile{f: f, mm: mm}, nil
+		}
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return nil, nil, err
+}
@@ -0,0 +1,110 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nommap
+
+package vellum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRegistrySpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	vals := randomValues(thousandTestWords)
+	var buf bytes.Buffer
+	b, err := New(&buf, WithRegistrySize(1000, 2), WithRegistrySpill(dir, 1024))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	if b.registry.spill == nil {
+		t.Fatalf("expected registry to spill")
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected spill file to be removed, found %d files", len(files))
+	}
+
+	var plain bytes.Buffer
+	b, err = New(&plain, WithRegistrySize(1000, 2))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	// the same states are registered in memory or in the file
+	if !bytes.Equal(buf.Bytes(), plain.Bytes()) {
+		t.Errorf("expected identical FSTs with and without spilling")
+	}
+}
+
+func TestRegistrySpillFull(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithRegistrySize(1000, 2))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	// a tiny arena, which fills up repeatedly
+	b.registry.spill, err = newRegistrySpill("", len(b.registry.table), 256)
+	if err != nil {
+		t.Fatalf("error creating spill: %v", err)
+	}
+	vals := randomValues(thousandTestWords)
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	for i, word := range thousandTestWords {
+		val, exists, err := fst.Get([]byte(word))
+		if err != nil || !exists || val != vals[i] {
+			t.Fatalf("expected %s %d, got %d %t %v", word, vals[i], val,
+				exists, err)
+		}
+	}
+}
@@ -14,7 +14,12 @@
 
 package vellum
 
-import "testing"
+import (
+	"bytes"
+	"hash/fnv"
+	"reflect"
+	"testing"
+)
 
 // FIXME add tests for MRU
 
@@ -57,3 +62,113 @@ func TestRegistry(t *testing.T) {
 		t.Errorf("expected to get addr 276, got %d", nowAddr)
 	}
 }
+
+func TestRegistryHash(t *testing.T) {
+	build := func(opts ...BuilderOption) ([]byte, RegistryStats) {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, thousandTestWords,
+			make([]uint64, len(thousandTestWords)))
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		return buf.Bytes(), b.RegistryStats()
+	}
+
+	data, stats := build()
+	if stats.Lookups == 0 || stats.Hits == 0 || stats.Buckets != 10000 ||
+		stats.BucketsUsed == 0 || stats.MaxProbes > 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// the standard library FNV-1a, over the serialized states
+	hashed, hashedStats := build(WithRegistryHash(func(b []byte) uint64 {
+		h := fnv.New64a()
+		_, _ = h.Write(b)
+		return h.Sum64()
+	}))
+	if !reflect.DeepEqual(fstPairs(t, hashed), fstPairs(t, data)) {
+		t.Errorf("expected the same keys with another hash")
+	}
+	if hashedStats.Lookups != stats.Lookups || hashedStats.Hits == 0 {
+		t.Errorf("unexpected stats with another hash %+v", hashedStats)
+	}
+
+	// a constant hash puts every state in the same bucket
+	_, badStats := build(WithRegistryHash(func([]byte) uint64 {
+		return 7
+	}))
+	if badStats.BucketsUsed != 1 || badStats.Collisions == 0 ||
+		badStats.Evictions == 0 || badStats.Hits >= stats.Hits {
+		t.Errorf("unexpected stats with a constant hash %+v", badStats)
+	}
+}
+
+func TestRegistryMaxMemory(t *testing.T) {
+	const budget = 16 << 10
+	vals := randomValues(thousandTestWords)
+	var buf bytes.Buffer
+	b, err := New(&buf, WithMaxMemory(budget))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	if size := len(b.registry.table) * registryCellSize; size > budget/2 {
+		t.Errorf("expected table of at most %d bytes, got %d", budget/2, size)
+	}
+	// shedding happens before the next node is registered
+	slack := builderNodeSize + 256*transitionSize
+	for i, word := range thousandTestWords {
+		err = b.Insert([]byte(word), vals[i])
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		if b.registry.heapBytes > b.registry.maxHeapBytes+slack {
+			t.Fatalf("expected registry within %d bytes, got %d",
+				b.registry.maxHeapBytes, b.registry.heapBytes)
+		}
+	}
+	if b.RegistryStats().Evictions == 0 {
+		t.Errorf("expected registered states to be evicted")
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+
+	var plain bytes.Buffer
+	b, err = New(&plain)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	if buf.Len() < plain.Len() {
+		t.Errorf("expected bounded registry to share fewer states, got %d "+
+			"bytes, %d unbounded", buf.Len(), plain.Len())
+	}
+
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	for i, word := range thousandTestWords {
+		val, exists, err := fst.Get([]byte(word))
+		if err != nil || !exists || val != vals[i] {
+			t.Fatalf("expected %q %d, got %d %t %v", word, vals[i], val,
+				exists, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrRekeyOrder is returned by Merge when the MergeRekey function doesn't
+// preserve the order of the keys, or maps two keys to the same one.
+var ErrRekeyOrder = errors.New("rekeyed keys out of order")
+
+// RekeyFunc rewrites a key during a Merge, such as to add a shard prefix,
+// or strip an obsolete namespace, without rebuilding the FST a second
+// time.  It appends the new key to dst and returns it, or returns false to
+// drop the key.  The keys are merged in order, and the new keys must be in
+// strictly increasing order as well, otherwise Merge fails with
+// ErrRekeyOrder.  Prepending or removing a common prefix preserves the
+// order, see PrefixRekey and StripPrefixRekey.
+type RekeyFunc func(dst, key []byte) ([]byte, bool)
+
+// PrefixRekey returns a RekeyFunc prepending prefix to every key.
+func PrefixRekey(prefix []byte) RekeyFunc {
+	prefix = append([]byte(nil), prefix...)
+	return func(dst, key []byte) ([]byte, bool) {
+		return append(append(dst, prefix...), key...), true
+	}
+}
+
+// StripPrefixRekey returns a RekeyFunc removing prefix from the keys which
+// start with it, and dropping the others.
+func StripPrefixRekey(prefix []byte) RekeyFunc {
+	prefix = append([]byte(nil), prefix...)
+	return func(dst, key []byte) ([]byte, bool) {
+		if !bytes.HasPrefix(key, prefix) {
+			return dst, false
+		}
+		return append(dst, key[len(prefix):]...), true
+	}
+}
+
+// rekeyer applies a RekeyFunc, checking the order of the new keys
+type rekeyer struct {
+	curr, prev []byte
+	started    bool
+}
+
+func (r *rekeyer) rekey(f RekeyFunc, key []byte) ([]byte, bool, error) {
+	var keep bool
+	r.curr, keep = f(r.curr[:0], key)
+	if !keep {
+		return nil, false, nil
+	}
+	if r.started && bytes.Compare(r.curr, r.prev) <= 0 {
+		return nil, false, fmt.Errorf("%w: %q from %q after %q", ErrRekeyOrder,
+			r.curr, key, r.prev)
+	}
+	r.started = true
+	// swap the buffers, the key returned is prev for the next call
+	r.curr, r.prev = r.prev, r.curr
+	return r.prev, true, nil
+}
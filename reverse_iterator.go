@@ -0,0 +1,293 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "bytes"
+
+// ReverseIterator iterates over the key/value pairs of an FST in descending
+// key order.  Iterators should be constructed with the ReverseIterator
+// method on the parent FST structure.
+//
+// It implements Iterator, but in reverse: Next moves to the previous key,
+// and Seek to the key or the greatest key before it.  Iteration ends with
+// ErrIteratorDone when it goes before startKeyInclusive (see
+// WithEndBoundError).
+type ReverseIterator struct {
+	f        *FST
+	aut      Automaton
+	depthAut DepthHintAutomaton
+
+	startKeyInclusive []byte
+	endKeyExclusive   []byte
+
+	// frames is the path from the root to the current state, keys the
+	// transitions taken along it
+	frames []reverseFrame
+	keys   []byte
+}
+
+type reverseFrame struct {
+	state    fstState
+	autState int
+	// val is the sum of the outputs of the transitions to the state
+	val uint64
+	// next is the position of the next transition to take, in descending
+	// order, -1 once they have all been taken
+	next int
+	// pending is true until the state itself is visited, after the keys
+	// greater than it, reached by its transitions
+	pending bool
+}
+
+// ReverseIterator returns a ReverseIterator over the key/value pairs with
+// startKeyInclusive <= key < endKeyExclusive which satisfy the automaton,
+// positioned at the greatest of them.  The automaton may be nil, matching
+// all keys.
+func (f *FST) ReverseIterator(startKeyInclusive, endKeyExclusive []byte,
+	aut Automaton) (*ReverseIterator, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	startKeyInclusive, endKeyExclusive = automatonBounds(aut,
+		startKeyInclusive, endKeyExclusive)
+	err := emptySearch(f, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		return nil, err
+	}
+	rv := &ReverseIterator{}
+	err = rv.Reset(f, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Reset resets the ReverseIterator's internal state to allow for iterator
+// reuse (e.g. pooling), positioning it at the greatest matching key.
+func (i *ReverseIterator) Reset(f *FST,
+	startKeyInclusive, endKeyExclusive []byte, aut Automaton) error {
+	if err := f.enter(); err != nil {
+		return err
+	}
+	defer f.exit()
+	if aut == nil {
+		aut = alwaysMatchAutomaton
+	}
+	startKeyInclusive, endKeyExclusive = automatonBounds(aut,
+		startKeyInclusive, endKeyExclusive)
+
+	i.f = f
+	i.startKeyInclusive = startKeyInclusive
+	i.endKeyExclusive = endKeyExclusive
+	i.aut = aut
+	i.depthAut = nil
+	if f.depths != nil {
+		i.depthAut, _ = aut.(DepthHintAutomaton)
+	}
+
+	return i.pointTo(endKeyExclusive, false)
+}
+
+// pointTo positions the iterator at the greatest key before the bound, or
+// equal to it if inclusive.  A nil bound is after all keys.
+func (i *ReverseIterator) pointTo(bound []byte, inclusive bool) error {
+	if i.endKeyExclusive != nil && (bound == nil ||
+		bytes.Compare(bound, i.endKeyExclusive) >= 0) {
+		bound, inclusive = i.endKeyExclusive, false
+	}
+
+	i.frames = i.frames[:0]
+	i.keys = i.keys[:0]
+
+	if bound != nil && bytes.Compare(bound, i.startKeyInclusive) < 0 {
+		return i.f.errEndBound()
+	}
+	err := emptySearch(i.f, i.startKeyInclusive, i.endKeyExclusive, i.aut)
+	if err != nil {
+		return err
+	}
+
+	root, err := i.f.decoder.stateAt(i.f.decoder.getRoot(), nil)
+	if err != nil {
+		return err
+	}
+	i.frames = append(i.frames, reverseFrame{
+		state:    root,
+		autState: i.aut.Start(),
+		next:     root.NumTransitions() - 1,
+		pending:  true,
+	})
+	if bound == nil {
+		return i.next()
+	}
+
+	// follow the bound, only taking the transitions before it from the
+	// states along its path
+	for _, b := range bound {
+		top := &i.frames[len(i.frames)-1]
+		top.next = top.state.TransitionBefore(b)
+		_, nextAddr, v := top.state.TransitionFor(b)
+		if nextAddr == noneAddr {
+			return i.next()
+		}
+		autNext := i.aut.Accept(top.autState, b)
+		if !i.aut.CanMatch(autNext) {
+			return i.next()
+		}
+		next, err := i.f.decoder.stateAt(nextAddr, nil)
+		if err != nil {
+			return err
+		}
+		i.frames = append(i.frames, reverseFrame{
+			state:    next,
+			autState: autNext,
+			val:      top.val + v,
+			next:     -1,
+			pending:  true,
+		})
+		i.keys = append(i.keys, b)
+	}
+	// the keys reached from the bound itself are after it
+	last := &i.frames[len(i.frames)-1]
+	last.next = -1
+	last.pending = inclusive
+	return i.next()
+}
+
+// Current returns the key and value currently pointed to by the iterator.
+// If the iterator is not pointing at a valid value (because Iterator/Next/
+// Seek returned an error previously), it returns nil,0.
+func (i *ReverseIterator) Current() ([]byte, uint64) {
+	if i.f.enter() != nil {
+		return nil, 0
+	}
+	defer i.f.exit()
+	if len(i.frames) == 0 {
+		return nil, 0
+	}
+	top := &i.frames[len(i.frames)-1]
+	if top.pending || !top.state.Final() {
+		return nil, 0
+	}
+	total := top.val + top.state.FinalOutput()
+	if i.f.values != nil {
+		// an index out of range has no value
+		total, _ = i.f.values.get(total)
+	}
+	return i.keys, total
+}
+
+// Next moves this iterator to the previous key/value pair.  If there is
+// none, or it goes before the configured startKeyInclusive, then
+// ErrIteratorDone is returned.
+func (i *ReverseIterator) Next() error {
+	if err := i.f.enter(); err != nil {
+		return err
+	}
+	defer i.f.exit()
+	if len(i.frames) == 0 {
+		return ErrIteratorDone
+	}
+	return i.next()
+}
+
+// Seek moves this iterator to the specified key/value pair.  If this key is
+// not in the FST, Current() will return the greatest key before it.  If
+// there is no such key, or it is before the configured startKeyInclusive,
+// then ErrIteratorDone is returned.
+func (i *ReverseIterator) Seek(key []byte) error {
+	if err := i.f.enter(); err != nil {
+		return err
+	}
+	defer i.f.exit()
+	if key == nil {
+		key = []byte{}
+	}
+	return i.pointTo(key, true)
+}
+
+// Close will free any resources held by this iterator.
+func (i *ReverseIterator) Close() error {
+	return nil
+}
+
+// next visits the states in reverse order, the keys reached by the
+// transitions of a state, greatest first, then the state itself, until
+// reaching a matching key
+func (i *ReverseIterator) next() error {
+	for len(i.frames) > 0 {
+		top := &i.frames[len(i.frames)-1]
+		if top.next < 0 {
+			if top.pending {
+				top.pending = false
+				if top.state.Final() && i.aut.IsMatch(top.autState) {
+					// all the keys left are before this one
+					if bytes.Compare(i.keys, i.startKeyInclusive) < 0 {
+						i.frames = i.frames[:0]
+						return i.f.errEndBound()
+					}
+					return nil
+				}
+			}
+			i.frames = i.frames[:len(i.frames)-1]
+			if len(i.keys) > 0 {
+				i.keys = i.keys[:len(i.keys)-1]
+			}
+			continue
+		}
+
+		t := top.state.TransitionAt(top.next)
+		top.next--
+		autNext := i.aut.Accept(top.autState, t)
+		if !i.aut.CanMatch(autNext) {
+			continue
+		}
+		_, nextAddr, v := top.state.TransitionFor(t)
+		if i.depthAut != nil {
+			depth, ok := i.f.depths.get(nextAddr)
+			if ok && !i.depthAut.CanMatchWithin(autNext, depth) {
+				continue
+			}
+		}
+
+		i.keys = append(i.keys, t)
+		// the keys of the subtree are all before the start key, as are
+		// all the keys left
+		if bytes.Compare(i.keys, i.startKeyInclusive) < 0 &&
+			!bytes.HasPrefix(i.startKeyInclusive, i.keys) {
+			i.frames = i.frames[:0]
+			return i.f.errEndBound()
+		}
+
+		// the next frame might have an fstState instance that we can reuse
+		var prealloc fstState
+		if len(i.frames) < cap(i.frames) {
+			prealloc = i.frames[:cap(i.frames)][len(i.frames)].state
+		}
+		next, err := i.f.decoder.stateAt(nextAddr, prealloc)
+		if err != nil {
+			return err
+		}
+		i.frames = append(i.frames, reverseFrame{
+			state:    next,
+			autState: autNext,
+			val:      top.val + v,
+			next:     next.NumTransitions() - 1,
+			pending:  true,
+		})
+	}
+	return ErrIteratorDone
+}
@@ -0,0 +1,151 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum/regexp"
+)
+
+func TestReverseIterator(t *testing.T) {
+	vals := randomValues(thousandTestWords)
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, vals)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	r, err := regexp.New(`[a-m].*s`)
+	if err != nil {
+		t.Fatalf("error compiling regexp: %v", err)
+	}
+
+	type kv struct {
+		key string
+		val uint64
+	}
+	tests := []struct {
+		name       string
+		aut        Automaton
+		start, end []byte
+	}{
+		{name: "all"},
+		{name: "range", start: []byte("b"), end: []byte("k")},
+		{name: "existing bounds", start: []byte(thousandTestWords[10]),
+			end: []byte(thousandTestWords[900])},
+		{name: "regexp", aut: r},
+		{name: "regexp range", aut: r, start: []byte("c"), end: []byte("ha")},
+		{name: "prefix", aut: PrefixAutomaton([]byte("pro"))},
+		{name: "empty", start: []byte("zzz")},
+	}
+	for _, test := range tests {
+		var want []kv
+		itr, err := fst.Search(test.aut, test.start, test.end)
+		for err == nil {
+			key, val := itr.Current()
+			want = append([]kv{{string(key), val}}, want...)
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("%s: error iterating forward: %v", test.name, err)
+		}
+
+		var got []kv
+		ritr, err := fst.ReverseIterator(test.start, test.end, test.aut)
+		for err == nil {
+			key, val := ritr.Current()
+			got = append(got, kv{string(key), val})
+			err = ritr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("%s: error iterating in reverse: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %d keys in reverse, got %d", test.name,
+				len(want), len(got))
+		}
+	}
+}
+
+func TestReverseIteratorSeek(t *testing.T) {
+	fst := buildKVs(t, KV{"", 1}, KV{"a", 2}, KV{"ab", 3}, KV{"abc", 4},
+		KV{"b", 5}, KV{"bcd", 6})
+
+	itr, err := fst.ReverseIterator([]byte("a"), []byte("bcd"), nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	tests := []struct {
+		seek string
+		want string
+		err  error
+	}{
+		{seek: "b", want: "b"},
+		{seek: "abd", want: "abc"},
+		{seek: "ab", want: "ab"},
+		{seek: "aa", want: "a"},
+		{seek: "zzz", want: "b"},
+		{seek: "", err: ErrIteratorDone},
+	}
+	for _, test := range tests {
+		err = itr.Seek([]byte(test.seek))
+		if test.err != nil {
+			if err != test.err {
+				t.Errorf("seek %q: expected %v, got %v", test.seek, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("seek %q: %v", test.seek, err)
+		}
+		key, _ := itr.Current()
+		if string(key) != test.want {
+			t.Errorf("seek %q: expected %q, got %q", test.seek, test.want, key)
+		}
+	}
+
+	// the last N keys before a key
+	err = itr.Seek([]byte("ab"))
+	var last []string
+	for n := 0; err == nil && n < 2; n++ {
+		key, _ := itr.Current()
+		last = append(last, string(key))
+		err = itr.Next()
+	}
+	if !reflect.DeepEqual(last, []string{"ab", "a"}) {
+		t.Errorf("expected [ab a], got %v", last)
+	}
+	if err != ErrIteratorDone {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+	if key, _ := itr.Current(); key != nil {
+		t.Errorf("expected no current key past the start, got %q", key)
+	}
+}
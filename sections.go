@@ -0,0 +1,169 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Optional sections carry additional data about the FST, which isn't
+// needed to decode the states, such as annotations supporting faster
+// navigation.  When the typeSections bit is set in the header type, the
+// data of each section follows the last (root) state, then a table
+// describing them, then the footer:
+//
+//	section data...
+//	for each section: 8 bytes id, 8 bytes offset, 8 bytes length
+//	8 bytes number of sections
+//	footer
+//
+// Readers ignore sections they don't recognize, and readers unaware of
+// sections ignore all of them, as they only rely on the footer.
+
+// section identifiers
+const (
+	sectionSubtreeCounts = 1
+	sectionDepthHints    = 2
+	sectionValues        = 3
+	sectionKeyDigests    = 4
+	sectionValueMins     = 5
+	sectionValueMaxes    = 6
+	sectionPayloads      = 7
+	sectionValueLists    = 8
+	sectionChecksums     = 9
+	sectionValueSums     = 10
+)
+
+const sectionEntrySize = 24
+
+// sectionNames are the names of the sections reported by Sizes
+var sectionNames = map[int]string{
+	sectionSubtreeCounts: "subtree_counts",
+	sectionDepthHints:    "depth_hints",
+	sectionValues:        "values",
+	sectionKeyDigests:    "key_digests",
+	sectionValueMins:     "value_mins",
+	sectionValueMaxes:    "value_maxes",
+	sectionPayloads:      "payloads",
+	sectionValueLists:    "value_lists",
+	sectionChecksums:     "checksums",
+	sectionValueSums:     "value_sums",
+}
+
+// sectionSizes returns the sizes of the sections by name, with those not
+// recognized named by their identifier, and their total
+func sectionSizes(sections map[int][]byte) (map[string]int, int) {
+	if len(sections) == 0 {
+		return nil, 0
+	}
+	rv := make(map[string]int, len(sections))
+	var total int
+	for id, data := range sections {
+		name, ok := sectionNames[id]
+		if !ok {
+			name = "section_" + strconv.Itoa(id)
+		}
+		rv[name] = len(data)
+		total += len(data)
+	}
+	return rv, total
+}
+
+type sectionEntry struct {
+	id     uint64
+	offset uint64
+	length uint64
+}
+
+func (s sectionEntry) put(buf []byte) {
+	binary.LittleEndian.PutUint64(buf, s.id)
+	binary.LittleEndian.PutUint64(buf[8:], s.offset)
+	binary.LittleEndian.PutUint64(buf[16:], s.length)
+}
+
+func getSectionEntry(buf []byte) sectionEntry {
+	return sectionEntry{
+		id:     binary.LittleEndian.Uint64(buf),
+		offset: binary.LittleEndian.Uint64(buf[8:]),
+		length: binary.LittleEndian.Uint64(buf[16:]),
+	}
+}
+
+// headerType returns the type to record in the header of FSTs built with
+// these options.
+func (o *BuilderOpts) headerType() int {
+	var rv int
+	if o.SubtreeCounts || o.DepthHints || o.InternValues || o.KeyDigests > 0 ||
+		o.valueBounds() || o.valueSums() || o.payloads || o.valueLists ||
+		o.Checksums {
+		rv |= typeSections
+	}
+	if o.InternValues {
+		rv |= typeInternedValues
+	}
+	if o.payloads {
+		rv |= typePayloads
+	}
+	if o.valueLists {
+		rv |= typeValueLists
+	}
+	if o.Checksums {
+		rv |= typeChecksums
+	}
+	return rv
+}
+
+// stateAnnotator computes a value for each state as it is compiled, which
+// is then recorded in an optional section.
+type stateAnnotator interface {
+	section() int
+	reset()
+	add(addr int, node *builderNode)
+	encode() []byte
+}
+
+// annotators returns the annotators needed to build the optional sections
+// requested by these options.
+func (o *BuilderOpts) annotators() []stateAnnotator {
+	var rv []stateAnnotator
+	var counter *subtreeCounter
+	if o.SubtreeCounts || o.KeyDigests > 0 || o.valueSums() {
+		counter = &subtreeCounter{}
+		rv = append(rv, counter)
+	}
+	if o.DepthHints {
+		rv = append(rv, &depthHinter{})
+	}
+	if o.valueBounds() {
+		rv = append(rv, &valueBounder{}, &valueBounder{max: true})
+	}
+	if o.valueSums() {
+		rv = append(rv, &valueSummer{counts: counter})
+	}
+	return rv
+}
+
+// valueBounds returns true if value bounds are recorded, which they aren't
+// for interned values
+func (o *BuilderOpts) valueBounds() bool {
+	return o.ValueBounds && !o.InternValues
+}
+
+// valueSums returns true if value sums are recorded, which they aren't for
+// interned values
+func (o *BuilderOpts) valueSums() bool {
+	return o.ValueSums && !o.InternValues
+}
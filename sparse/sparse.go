@@ -0,0 +1,134 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package sparse provides a sparse set of unsigned integers, as described by
+Briggs and Torczon.  Membership tests, insertion and clearing are all
+constant time operations, and iteration visits members in insertion order.
+This makes it well suited for tracking sets of automaton states while
+implementing the vellum.Automaton interface.
+*/
+package sparse
+
+// Set is a sparse set of unsigned integers.  The zero value is an empty
+// set with no capacity, which will grow as values are added.
+type Set struct {
+	dense  []uint
+	sparse []uint
+	size   uint
+}
+
+// New returns a new Set with capacity to hold values in the range
+// [0, size) without growing.
+func New(size uint) *Set {
+	return &Set{
+		dense:  make([]uint, size),
+		sparse: make([]uint, size),
+		size:   0,
+	}
+}
+
+// Len returns the number of values in the set.
+func (s *Set) Len() int {
+	return int(s.size)
+}
+
+// Cap returns the exclusive upper bound of values which can be added
+// to the set without growing.
+func (s *Set) Cap() uint {
+	return uint(len(s.sparse))
+}
+
+// Grow ensures the set is able to hold values in the range [0, size)
+// without further allocation.  Existing members are preserved.
+func (s *Set) Grow(size uint) {
+	if size <= uint(len(s.sparse)) {
+		return
+	}
+	dense := make([]uint, size)
+	copy(dense, s.dense[:s.size])
+	sparse := make([]uint, size)
+	copy(sparse, s.sparse)
+	s.dense = dense
+	s.sparse = sparse
+}
+
+// Add inserts the value v into the set, growing the set if needed, and
+// returns the position of v in insertion order.  The caller is responsible
+// for ensuring v is not already a member, see Contains.
+func (s *Set) Add(v uint) uint {
+	if v >= uint(len(s.sparse)) {
+		s.Grow(grownSize(uint(len(s.sparse)), v))
+	}
+	i := s.size
+	s.dense[i] = v
+	s.sparse[v] = i
+	s.size++
+	return i
+}
+
+// Get returns the value at position i in insertion order.
+func (s *Set) Get(i uint) uint {
+	return s.dense[i]
+}
+
+// Contains returns true if and only if the value v is in the set.
+func (s *Set) Contains(v uint) bool {
+	if v >= uint(len(s.sparse)) {
+		return false
+	}
+	i := s.sparse[v]
+	return i < s.size && s.dense[i] == v
+}
+
+// Clear removes all values from the set, retaining its capacity.
+func (s *Set) Clear() {
+	s.size = 0
+}
+
+// Iterate invokes the callback for each value in the set in insertion
+// order.  Iteration stops early if the callback returns false.
+func (s *Set) Iterate(cb func(v uint) bool) {
+	for i := uint(0); i < s.size; i++ {
+		if !cb(s.dense[i]) {
+			return
+		}
+	}
+}
+
+// Intersect clears the set into, and then adds each value of s which
+// is also a member of other, in the insertion order of s.  It returns into,
+// allocating a new Set if into is nil.  into MUST NOT be s or other.
+func (s *Set) Intersect(other, into *Set) *Set {
+	if into == nil {
+		into = New(s.Cap())
+	} else {
+		into.Clear()
+	}
+	for i := uint(0); i < s.size; i++ {
+		v := s.dense[i]
+		if other.Contains(v) {
+			into.Add(v)
+		}
+	}
+	return into
+}
+
+func grownSize(size, v uint) uint {
+	size *= 2
+	if size <= v {
+		size = v + 1
+	}
+	return size
+}
@@ -0,0 +1,122 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSparse(t *testing.T) {
+
+	s := New(10)
+	if s.Contains(0) {
+		t.Errorf("expected not to contain 0")
+	}
+
+	s.Add(3)
+	if !s.Contains(3) {
+		t.Errorf("expected to contains 3, did not")
+	}
+
+	if s.Len() != 1 {
+		t.Errorf("expected len 1, got %d", s.Len())
+	}
+
+	if s.Get(0) != 3 {
+		t.Errorf("expected 10, got %d", s.Get(0))
+	}
+
+	s.Clear()
+
+	if s.Len() != 0 {
+		t.Errorf("expected len 0, got %d", s.Len())
+	}
+}
+
+func TestSparseGrow(t *testing.T) {
+	var s Set
+	if s.Contains(5) {
+		t.Errorf("expected not to contain 5")
+	}
+
+	s.Add(5)
+	s.Add(1)
+	s.Add(100)
+	if s.Cap() <= 100 {
+		t.Errorf("expected cap > 100, got %d", s.Cap())
+	}
+	for _, v := range []uint{5, 1, 100} {
+		if !s.Contains(v) {
+			t.Errorf("expected to contain %d, did not", v)
+		}
+	}
+	if s.Contains(1000) {
+		t.Errorf("expected not to contain 1000")
+	}
+
+	s.Grow(2000)
+	if s.Cap() != 2000 {
+		t.Errorf("expected cap 2000, got %d", s.Cap())
+	}
+	if s.Len() != 3 || !s.Contains(100) {
+		t.Errorf("expected members preserved after grow")
+	}
+}
+
+func TestSparseIterateIntersect(t *testing.T) {
+	a := New(10)
+	for _, v := range []uint{7, 2, 9, 4} {
+		a.Add(v)
+	}
+	b := New(10)
+	for _, v := range []uint{4, 9, 1} {
+		b.Add(v)
+	}
+
+	var got []uint
+	a.Iterate(func(v uint) bool {
+		got = append(got, v)
+		return true
+	})
+	if !reflect.DeepEqual(got, []uint{7, 2, 9, 4}) {
+		t.Errorf("expected insertion order, got %v", got)
+	}
+
+	got = got[:0]
+	a.Iterate(func(v uint) bool {
+		got = append(got, v)
+		return len(got) < 2
+	})
+	if !reflect.DeepEqual(got, []uint{7, 2}) {
+		t.Errorf("expected early stop, got %v", got)
+	}
+
+	into := a.Intersect(b, nil)
+	got = got[:0]
+	into.Iterate(func(v uint) bool {
+		got = append(got, v)
+		return true
+	})
+	if !reflect.DeepEqual(got, []uint{9, 4}) {
+		t.Errorf("expected intersection [9 4], got %v", got)
+	}
+
+	// reuse the destination set
+	into = b.Intersect(a, into)
+	if into.Len() != 2 || into.Get(0) != 4 || into.Get(1) != 9 {
+		t.Errorf("expected intersection [4 9], got len %d", into.Len())
+	}
+}
@@ -0,0 +1,590 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrUnknownSegment is returned by Stack.Drop for a segment not in the
+// Stack.
+var ErrUnknownSegment = errors.New("unknown segment")
+
+var errStackClosed = errors.New("stack closed")
+
+const stackManifestName = "MANIFEST"
+const stackManifestVersion = 1
+
+// isStackSegmentName reports whether the file name is that of a segment,
+// or of a segment being written
+func isStackSegmentName(name string) bool {
+	name = strings.TrimSuffix(name, ".tmp")
+	if len(name) != 12 || !strings.HasSuffix(name, ".fst") {
+		return false
+	}
+	for _, c := range name[:8] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Stack manages an ordered set of immutable FST files (segments) in a
+// directory, providing a dictionary which can grow over time.  New keys
+// are added by building a new segment, and the segments are periodically
+// compacted into one.  When a key is found in more than one segment, the
+// value from the newest segment is used.
+//
+// The segments making up the Stack are recorded in a manifest file, which
+// is replaced atomically by every change, so a crash leaves the Stack as it
+// was before or after the change.  Any segment files left over by an
+// interrupted change are removed when the Stack is next opened.
+//
+// A Stack is safe for concurrent use.  Changes are applied one at a time,
+// without blocking readers.  Segments are reference counted: a segment
+// removed by a change is only closed, and its file deleted, once the
+// Snapshots using it are released.
+type Stack struct {
+	dir  string
+	opts []OpenOption
+
+	// writeM serializes changes, m protects the fields below
+	writeM   sync.Mutex
+	m        sync.RWMutex
+	next     uint64
+	segments []*stackSegment
+	closed   bool
+}
+
+type stackSegment struct {
+	name string
+	fst  *FST
+	// refs counts the Stack, while the segment is part of it, and the
+	// Snapshots using it
+	refs int32
+	// obsolete is set once the segment is removed from the Stack, so its
+	// file is deleted when released
+	obsolete int32
+}
+
+func newStackSegment(name string, fst *FST) *stackSegment {
+	return &stackSegment{name: name, fst: fst, refs: 1}
+}
+
+func (seg *stackSegment) acquire() {
+	atomic.AddInt32(&seg.refs, 1)
+}
+
+// release drops a reference to the segment, closing it, and deleting its
+// file from dir if obsolete, when it was the last
+func (seg *stackSegment) release(dir string) error {
+	if atomic.AddInt32(&seg.refs, -1) != 0 {
+		return nil
+	}
+	err := seg.fst.Close()
+	if atomic.LoadInt32(&seg.obsolete) != 0 {
+		rerr := os.Remove(filepath.Join(dir, seg.name))
+		if rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+type stackManifest struct {
+	Version  int      `json:"version"`
+	Next     uint64   `json:"next"`
+	Segments []string `json:"segments"`
+}
+
+// OpenStack opens the Stack in the provided directory, creating it if
+// necessary.  The options are used to open every segment.
+func OpenStack(dir string, opts ...OpenOption) (*Stack, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	rv := &Stack{
+		dir:  dir,
+		opts: opts,
+		next: 1,
+	}
+	manifest, err := rv.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		rv.next = manifest.Next
+		for _, name := range manifest.Segments {
+			var fst *FST
+			fst, err = Open(filepath.Join(dir, name), opts...)
+			if err != nil {
+				_ = releaseSegments(dir, rv.segments)
+				return nil, fmt.Errorf("error opening segment %s: %w", name, err)
+			}
+			rv.segments = append(rv.segments, newStackSegment(name, fst))
+		}
+	}
+	err = rv.removeOrphans()
+	if err != nil {
+		_ = releaseSegments(dir, rv.segments)
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Segments returns the names of the segments, oldest first.
+func (s *Stack) Segments() []string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	rv := make([]string, len(s.segments))
+	for i, seg := range s.segments {
+		rv[i] = seg.name
+	}
+	return rv
+}
+
+// Add builds a new segment, the newest, with the provided build function,
+// which must insert keys in lexicographic order, as usual.  If the build
+// function returns an error the segment is discarded, and the error is
+// returned.
+func (s *Stack) Add(build func(*Builder) error, opts ...BuilderOption) error {
+	s.writeM.Lock()
+	defer s.writeM.Unlock()
+
+	name, err := s.newSegmentName()
+	if err != nil {
+		return err
+	}
+	seg, err := s.buildSegment(name, func(f *os.File) error {
+		b, err := New(f, opts...)
+		if err != nil {
+			return err
+		}
+		err = build(b)
+		if err != nil {
+			return err
+		}
+		return b.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	s.m.RLock()
+	segments := append(append([]*stackSegment(nil), s.segments...), seg)
+	s.m.RUnlock()
+	return s.commit(segments, nil)
+}
+
+// Compact merges all the segments into one, keeping the value from the
+// newest segment for keys found in more than one.  Views obtained before
+// compacting must not be used afterwards, Snapshots can.
+func (s *Stack) Compact(opts ...BuilderOption) error {
+	s.writeM.Lock()
+	defer s.writeM.Unlock()
+
+	s.m.RLock()
+	old := s.segments
+	closed := s.closed
+	s.m.RUnlock()
+	if closed {
+		return errStackClosed
+	}
+	if len(old) < 2 {
+		return nil
+	}
+
+	name, err := s.newSegmentName()
+	if err != nil {
+		return err
+	}
+	seg, err := s.buildSegment(name, func(f *os.File) error {
+		view := &StackView{segments: old}
+		itr, err := view.Iterator(nil, nil)
+		if errors.Is(err, ErrIteratorDone) {
+			return FromSlice(nil, f, opts...)
+		}
+		if err != nil {
+			return err
+		}
+		b, err := New(f, opts...)
+		if err != nil {
+			return err
+		}
+		for err == nil {
+			k, v := itr.Current()
+			err = b.Insert(k, v)
+			if err != nil {
+				return err
+			}
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			return err
+		}
+		return b.Close()
+	})
+	if err != nil {
+		return err
+	}
+	return s.commit([]*stackSegment{seg}, old)
+}
+
+// Drop removes the named segment from the Stack, deleting its file once
+// no Snapshot uses it.  Views obtained before dropping must not be used
+// afterwards, Snapshots can.
+func (s *Stack) Drop(name string) error {
+	s.writeM.Lock()
+	defer s.writeM.Unlock()
+
+	s.m.RLock()
+	if s.closed {
+		s.m.RUnlock()
+		return errStackClosed
+	}
+	var segments, dropped []*stackSegment
+	for _, seg := range s.segments {
+		if seg.name == name {
+			dropped = append(dropped, seg)
+		} else {
+			segments = append(segments, seg)
+		}
+	}
+	s.m.RUnlock()
+	if len(dropped) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownSegment, name)
+	}
+	return s.commit(segments, dropped)
+}
+
+// View returns a read view of the current segments.  The view is not
+// affected by segments added later, but must not be used after the Stack
+// is compacted, or a segment is dropped, or the Stack is closed.  Use
+// Snapshot for a view which remains valid.
+func (s *Stack) View() *StackView {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return &StackView{segments: s.segments}
+}
+
+// Snapshot returns a read view of the current segments, which remains
+// valid, whatever the changes to the Stack, until it is released.  The
+// segments it uses are kept open, and their files aren't deleted, until
+// then, so Snapshots should be released promptly.
+func (s *Stack) Snapshot() (*StackSnapshot, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.closed {
+		return nil, errStackClosed
+	}
+	for _, seg := range s.segments {
+		seg.acquire()
+	}
+	return &StackSnapshot{
+		StackView: &StackView{segments: s.segments},
+		dir:       s.dir,
+	}, nil
+}
+
+// Get returns the value associated with the key in the newest segment
+// containing it, and whether the key exists.
+func (s *Stack) Get(key []byte) (uint64, bool, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.closed {
+		return 0, false, errStackClosed
+	}
+	return (&StackView{segments: s.segments}).Get(key)
+}
+
+// Close closes all the segments, except those used by Snapshots, which are
+// closed as they are released.
+func (s *Stack) Close() error {
+	s.writeM.Lock()
+	defer s.writeM.Unlock()
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return releaseSegments(s.dir, s.segments)
+}
+
+// newSegmentName reserves the name of the next segment
+func (s *Stack) newSegmentName() (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return "", errStackClosed
+	}
+	rv := fmt.Sprintf("%08d.fst", s.next)
+	s.next++
+	return rv, nil
+}
+
+// buildSegment writes out a new segment file, durably, and opens it
+func (s *Stack) buildSegment(name string, write func(*os.File) error) (*stackSegment, error) {
+	path := filepath.Join(s.dir, name)
+	err := writeFileAtomic(path, write)
+	if err != nil {
+		return nil, err
+	}
+	fst, err := Open(path, s.opts...)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return newStackSegment(name, fst), nil
+}
+
+// commit records the new list of segments in the manifest, and then
+// releases the removed segments, which are deleted once no Snapshot uses
+// them
+func (s *Stack) commit(segments, removed []*stackSegment) error {
+	s.m.Lock()
+	manifest := &stackManifest{
+		Version:  stackManifestVersion,
+		Next:     s.next,
+		Segments: make([]string, len(segments)),
+	}
+	s.m.Unlock()
+	for i, seg := range segments {
+		manifest.Segments[i] = seg.name
+	}
+	err := s.writeManifest(manifest)
+	if err != nil {
+		// discard any segment which was being added
+		for _, seg := range segments {
+			if !s.hasSegment(seg) {
+				_ = seg.fst.Close()
+				_ = os.Remove(filepath.Join(s.dir, seg.name))
+			}
+		}
+		return err
+	}
+
+	s.m.Lock()
+	s.segments = segments
+	s.m.Unlock()
+
+	for _, seg := range removed {
+		atomic.StoreInt32(&seg.obsolete, 1)
+	}
+	return releaseSegments(s.dir, removed)
+}
+
+func (s *Stack) hasSegment(seg *stackSegment) bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for _, existing := range s.segments {
+		if existing == seg {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Stack) readManifest() (*stackManifest, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(s.dir, stackManifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rv stackManifest
+	err = json.Unmarshal(buf, &rv)
+	if err != nil {
+		return nil, fmt.Errorf("error reading stack manifest: %w", err)
+	}
+	if rv.Version != stackManifestVersion {
+		return nil, fmt.Errorf("unsupported stack manifest version %d",
+			rv.Version)
+	}
+	for _, name := range rv.Segments {
+		if !isStackSegmentName(name) || strings.HasSuffix(name, ".tmp") {
+			return nil, fmt.Errorf("invalid segment name %q in stack manifest",
+				name)
+		}
+	}
+	return &rv, nil
+}
+
+func (s *Stack) writeManifest(manifest *stackManifest) error {
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, stackManifestName),
+		func(f *os.File) error {
+			_, err := f.Write(buf)
+			return err
+		})
+}
+
+// removeOrphans deletes segment files not in the manifest, left over by
+// an interrupted change
+func (s *Stack) removeOrphans() error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool, len(s.segments))
+	for _, seg := range s.segments {
+		live[seg.name] = true
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if (isStackSegmentName(name) && !live[name]) ||
+			name == stackManifestName+".tmp" {
+			err = os.Remove(filepath.Join(s.dir, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func releaseSegments(dir string, segments []*stackSegment) error {
+	var rv error
+	for _, seg := range segments {
+		err := seg.release(dir)
+		if err != nil && rv == nil {
+			rv = err
+		}
+	}
+	return rv
+}
+
+// writeFileAtomic writes the file at path, by writing and syncing a
+// temporary file which is then renamed
+func writeFileAtomic(path string, write func(*os.File) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	cerr := d.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// StackView is a read view of the segments of a Stack at some point in
+// time, presenting them as a single dictionary.
+type StackView struct {
+	// oldest first
+	segments []*stackSegment
+}
+
+// Len returns the number of segments in the view.
+func (v *StackView) Len() int {
+	return len(v.segments)
+}
+
+// Get returns the value associated with the key in the newest segment
+// containing it, and whether the key exists.
+func (v *StackView) Get(key []byte) (uint64, bool, error) {
+	for i := len(v.segments) - 1; i >= 0; i-- {
+		val, exists, err := v.segments[i].fst.Get(key)
+		if err != nil || exists {
+			return val, exists, err
+		}
+	}
+	return 0, false, nil
+}
+
+// Iterator returns an Iterator over the keys of all the segments, with
+// startKeyInclusive <= key < endKeyExclusive, see Search.
+func (v *StackView) Iterator(startKeyInclusive, endKeyExclusive []byte) (*MergeIterator, error) {
+	return v.Search(nil, startKeyInclusive, endKeyExclusive)
+}
+
+// Search returns an Iterator over the keys of all the segments matching
+// the automaton, with startKeyInclusive <= key < endKeyExclusive.  Each key
+// is returned once, with the value from the newest segment containing it.
+// As with NewMergeIterator, ErrIteratorDone is returned if there are no
+// such keys.
+func (v *StackView) Search(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) (*MergeIterator, error) {
+	fsts := make([]*FST, len(v.segments))
+	for i, seg := range v.segments {
+		fsts[i] = seg.fst
+	}
+	return NewMergeSearch(fsts, aut, startKeyInclusive, endKeyExclusive,
+		mergeNewest)
+}
+
+// StackSnapshot is a StackView holding references to its segments, so it
+// remains valid after the Stack changes or is closed, until it is
+// released.
+type StackSnapshot struct {
+	*StackView
+	dir      string
+	released int32
+}
+
+// Release releases the segments of the snapshot, closing those no longer
+// part of the Stack, or used by another Snapshot, and deleting their files
+// if they were removed from the Stack.  The snapshot must not be used
+// afterwards.  Releasing it again has no effect.
+func (s *StackSnapshot) Release() error {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return nil
+	}
+	return releaseSegments(s.dir, s.segments)
+}
+
+// mergeNewest keeps the value from the newest segment, the last of them
+func mergeNewest(vals []uint64) uint64 {
+	return vals[len(vals)-1]
+}
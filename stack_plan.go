@@ -0,0 +1,244 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// planProbeStates is the number of states of a segment the planner visits
+// with the automaton to estimate the number of keys it matches
+const planProbeStates = 256
+
+// SearchPlan is a search of the segments of a StackView, planned by Plan.
+type SearchPlan struct {
+	aut        Automaton
+	start, end []byte
+	// segments in the order of the view, oldest first
+	segments []*plannedSegment
+	// order is the traversal order, indexes into segments
+	order []int
+}
+
+type plannedSegment struct {
+	seg     *stackSegment
+	explain SegmentExplain
+}
+
+// SearchExplain describes a SearchPlan, and the work done by the Iterators
+// it returned so far.
+type SearchExplain struct {
+	// Segments are in the order they are traversed, the most selective
+	// first
+	Segments []SegmentExplain `json:"segments"`
+}
+
+// SegmentExplain describes the search of one segment of a SearchPlan.
+type SegmentExplain struct {
+	// Name is the name of the segment file
+	Name string `json:"name"`
+	// Estimate is the estimated number of keys of the segment matching the
+	// automaton, ignoring the bounds of the search
+	Estimate int `json:"estimate"`
+	// Exact is true if the Estimate is the exact number of matching keys,
+	// the automaton having ruled out the rest of the segment
+	Exact bool `json:"exact"`
+	// Skipped is true if the segment can't have matching keys, so isn't
+	// searched
+	Skipped bool `json:"skipped"`
+	// PlanTime is the time spent estimating the keys of the segment
+	PlanTime time.Duration `json:"plan_time_ns"`
+	// Keys is the number of keys read from the segment
+	Keys int `json:"keys"`
+	// Time is the time spent positioning the segment's Iterators
+	Time time.Duration `json:"time_ns"`
+}
+
+// Plan prepares a search of the segments of the view for the keys
+// matching the automaton, with startKeyInclusive <= key < endKeyExclusive.
+// The number of keys matched by each segment is estimated by running the
+// automaton over the first states of the segment, so that the segments
+// which can't match are skipped, and the others are traversed from the
+// most selective.  The same automaton is run over every segment, so its
+// states must be valid in any of them, as for Search.
+func (v *StackView) Plan(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) (*SearchPlan, error) {
+	if aut == nil {
+		aut = alwaysMatchAutomaton
+	}
+	rv := &SearchPlan{
+		aut:   aut,
+		start: startKeyInclusive,
+		end:   endKeyExclusive,
+	}
+	for i, seg := range v.segments {
+		p := &plannedSegment{
+			seg: seg,
+		}
+		p.explain.Name = seg.name
+		began := time.Now()
+		var err error
+		p.explain.Estimate, p.explain.Exact, err = estimateMatches(seg.fst, aut,
+			startKeyInclusive, endKeyExclusive)
+		if err != nil {
+			return nil, err
+		}
+		p.explain.PlanTime = time.Since(began)
+		p.explain.Skipped = p.explain.Exact && p.explain.Estimate == 0
+		rv.segments = append(rv.segments, p)
+		rv.order = append(rv.order, i)
+	}
+	sort.SliceStable(rv.order, func(i, j int) bool {
+		return rv.segments[rv.order[i]].explain.Estimate <
+			rv.segments[rv.order[j]].explain.Estimate
+	})
+	return rv, nil
+}
+
+// estimateMatches returns the number of keys of the FST the automaton may
+// match, and whether that is exact, visiting at most planProbeStates
+// states.  When the states left unvisited have subtree counts, they are
+// used for the estimate, otherwise the length of the FST is.
+func estimateMatches(f *FST, aut Automaton, start, end []byte) (int, bool, error) {
+	err := emptySearch(f, start, end, aut)
+	if err != nil {
+		if errors.Is(err, ErrIteratorDone) {
+			return 0, true, nil
+		}
+		return 0, false, err
+	}
+	type probe struct {
+		addr, autState int
+	}
+	queue := []probe{{f.decoder.getRoot(), aut.Start()}}
+	var matches, visited int
+	var state fstState
+	for len(queue) > 0 && visited < planProbeStates {
+		p := queue[0]
+		queue = queue[1:]
+		visited++
+		state, err = f.decoder.stateAt(p.addr, state)
+		if err != nil {
+			return 0, false, err
+		}
+		if state.Final() && aut.IsMatch(p.autState) {
+			matches++
+		}
+		for i := 0; i < state.NumTransitions(); i++ {
+			t := state.TransitionAt(i)
+			next := aut.Accept(p.autState, t)
+			if !aut.CanMatch(next) {
+				continue
+			}
+			_, addr, _ := state.TransitionFor(t)
+			queue = append(queue, probe{addr, next})
+		}
+	}
+	if len(queue) == 0 {
+		return matches, true, nil
+	}
+	if f.counts == nil {
+		return f.Len(), false, nil
+	}
+	for _, p := range queue {
+		n, ok := f.counts.get(p.addr)
+		if !ok {
+			return f.Len(), false, nil
+		}
+		matches += int(n)
+	}
+	return matches, false, nil
+}
+
+// Iterator runs the plan, returning an Iterator over the matching keys of
+// all the segments, as StackView.Search does.  The segments are positioned
+// in the traversal order, then merged lazily.  The work done by the
+// Iterator is added to the plan's Explain, so the Iterator must not be used
+// concurrently with Explain.
+func (p *SearchPlan) Iterator() (*MergeIterator, error) {
+	itrs := make([]Iterator, len(p.segments))
+	for _, i := range p.order {
+		seg := p.segments[i]
+		if seg.explain.Skipped {
+			continue
+		}
+		began := time.Now()
+		itr, err := seg.seg.fst.Search(p.aut, p.start, p.end)
+		seg.explain.Time += time.Since(began)
+		if errors.Is(err, ErrIteratorDone) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		seg.explain.Keys++
+		itrs[i] = &explainedIterator{
+			Iterator: itr,
+			explain:  &seg.explain,
+		}
+	}
+	// the merge keeps the value of the newest segment, the last one
+	var rv []Iterator
+	for _, itr := range itrs {
+		if itr != nil {
+			rv = append(rv, itr)
+		}
+	}
+	return NewMergeIterator(rv, mergeNewest)
+}
+
+// Explain returns a description of the plan, and the work done by its
+// Iterators so far.
+func (p *SearchPlan) Explain() *SearchExplain {
+	rv := &SearchExplain{}
+	for _, i := range p.order {
+		rv.Segments = append(rv.Segments, p.segments[i].explain)
+	}
+	return rv
+}
+
+// explainedIterator records the keys read and the time spent by the
+// Iterator of a segment
+type explainedIterator struct {
+	Iterator
+	explain *SegmentExplain
+}
+
+func (i *explainedIterator) record(began time.Time, err error) error {
+	i.explain.Time += time.Since(began)
+	if err == nil {
+		i.explain.Keys++
+	}
+	return err
+}
+
+func (i *explainedIterator) Next() error {
+	began := time.Now()
+	return i.record(began, i.Iterator.Next())
+}
+
+func (i *explainedIterator) Seek(key []byte) error {
+	began := time.Now()
+	return i.record(began, i.Iterator.Seek(key))
+}
+
+func (i *explainedIterator) Reset(f *FST, startKeyInclusive,
+	endKeyExclusive []byte, aut Automaton) error {
+	began := time.Now()
+	return i.record(began, i.Iterator.Reset(f, startKeyInclusive,
+		endKeyExclusive, aut))
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestStackPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	s, err := OpenStack(dir)
+	if err != nil {
+		t.Fatalf("error opening stack: %v", err)
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+
+	var words []KV
+	for i, word := range thousandTestWords {
+		words = append(words, KV{word, uint64(i)})
+	}
+	addToStack(t, s, words...)
+	addToStack(t, s, KV{"mon", 1}, KV{"tues", 2})
+	addToStack(t, s, KV{"mon", 5}, KV{"month", 6})
+	addToStack(t, s, KV{"thurs", 4})
+
+	search := func(itr *MergeIterator, err error) []KV {
+		var rv []KV
+		for err == nil {
+			k, v := itr.Current()
+			rv = append(rv, KV{string(k), v})
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("error iterating: %v", err)
+		}
+		return rv
+	}
+
+	view := s.View()
+	aut := literalMatch{lit: "m", prefix: true}
+	plan, err := view.Plan(aut, nil, nil)
+	if err != nil {
+		t.Fatalf("error planning: %v", err)
+	}
+	want := search(view.Search(aut, nil, nil))
+	got := search(plan.Iterator())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	explain := plan.Explain()
+	var names []string
+	var keys int
+	for _, seg := range explain.Segments {
+		names = append(names, seg.Name)
+		keys += seg.Keys
+	}
+	wantNames := []string{"00000004.fst", "00000002.fst", "00000003.fst",
+		"00000001.fst"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("expected traversal order %v, got %v", wantNames, names)
+	}
+	last := explain.Segments[3]
+	if !explain.Segments[0].Skipped || explain.Segments[0].Keys != 0 ||
+		explain.Segments[1].Estimate != 1 || !explain.Segments[1].Exact ||
+		explain.Segments[2].Estimate != 2 || last.Skipped || last.Keys == 0 {
+		t.Errorf("unexpected explain %+v", explain)
+	}
+	// keys in more than one segment are read from each
+	if keys != len(want)+2 {
+		t.Errorf("expected %d keys read, got %d", len(want)+2, keys)
+	}
+}
@@ -0,0 +1,241 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func addToStack(t *testing.T, s *Stack, kvs ...KV) {
+	err := s.Add(func(b *Builder) error {
+		for _, kv := range kvs {
+			err := b.Insert([]byte(kv.Key), kv.Val)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error adding segment: %v", err)
+	}
+}
+
+func stackPairs(t *testing.T, s *Stack) []KV {
+	var rv []KV
+	itr, err := s.View().Iterator(nil, nil)
+	for err == nil {
+		k, v := itr.Current()
+		rv = append(rv, KV{Key: string(k), Val: v})
+		err = itr.Next()
+	}
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Fatalf("error iterating: %v", err)
+	}
+	return rv
+}
+
+func stackFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rv []string
+	for _, info := range infos {
+		rv = append(rv, info.Name())
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func TestStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	s, err := OpenStack(dir)
+	if err != nil {
+		t.Fatalf("error opening stack: %v", err)
+	}
+	if len(stackPairs(t, s)) != 0 {
+		t.Errorf("expected empty stack")
+	}
+	addToStack(t, s, KV{"mon", 1}, KV{"tues", 2})
+	addToStack(t, s, KV{"thurs", 4}, KV{"tues", 3})
+	addToStack(t, s, KV{"mon", 5})
+
+	want := []KV{{"mon", 5}, {"thurs", 4}, {"tues", 3}}
+	if got := stackPairs(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	val, exists, err := s.Get([]byte("tues"))
+	if err != nil || !exists || val != 3 {
+		t.Errorf("expected tues 3, got %d %t %v", val, exists, err)
+	}
+	wantSegments := []string{"00000001.fst", "00000002.fst", "00000003.fst"}
+	if !reflect.DeepEqual(s.Segments(), wantSegments) {
+		t.Errorf("expected segments %v, got %v", wantSegments, s.Segments())
+	}
+
+	// failed builds leave no trace
+	failed := errors.New("failed")
+	err = s.Add(func(b *Builder) error {
+		return failed
+	})
+	if err != failed {
+		t.Errorf("expected build error, got %v", err)
+	}
+	err = s.Drop("00000002.fst")
+	if err != nil {
+		t.Fatalf("error dropping: %v", err)
+	}
+	err = s.Drop("00000002.fst")
+	if !errors.Is(err, ErrUnknownSegment) {
+		t.Errorf("expected ErrUnknownSegment, got %v", err)
+	}
+	want = []KV{{"mon", 5}, {"tues", 2}}
+	if got := stackPairs(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+
+	// reopen, leaving an orphan from an interrupted change
+	err = ioutil.WriteFile(filepath.Join(dir, "00000009.fst.tmp"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenStack(dir)
+	if err != nil {
+		t.Fatalf("error reopening stack: %v", err)
+	}
+	if got := stackPairs(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after reopening, got %v", want, got)
+	}
+	wantFiles := []string{"00000001.fst", "00000003.fst", "MANIFEST"}
+	if got := stackFiles(t, dir); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("expected files %v, got %v", wantFiles, got)
+	}
+
+	err = s.Compact()
+	if err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+	if got := stackPairs(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after compacting, got %v", want, got)
+	}
+	wantFiles = []string{"00000005.fst", "MANIFEST"}
+	if got := stackFiles(t, dir); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("expected files %v, got %v", wantFiles, got)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	_, _, err = s.Get([]byte("mon"))
+	if err == nil {
+		t.Errorf("expected error using closed stack")
+	}
+}
+
+func TestStackSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	s, err := OpenStack(dir)
+	if err != nil {
+		t.Fatalf("error opening stack: %v", err)
+	}
+	addToStack(t, s, KV{"mon", 1}, KV{"tues", 2})
+	addToStack(t, s, KV{"tues", 3})
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("error taking snapshot: %v", err)
+	}
+	addToStack(t, s, KV{"wed", 4})
+	err = s.Compact()
+	if err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	// the compacted segments are kept for the snapshot
+	wantFiles := []string{"00000001.fst", "00000002.fst", "00000004.fst",
+		"MANIFEST"}
+	if got := stackFiles(t, dir); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("expected files %v, got %v", wantFiles, got)
+	}
+	val, exists, err := snap.Get([]byte("tues"))
+	if err != nil || !exists || val != 3 {
+		t.Errorf("expected tues 3, got %d %t %v", val, exists, err)
+	}
+	if _, exists, _ = snap.Get([]byte("wed")); exists {
+		t.Errorf("unexpected key added after the snapshot")
+	}
+
+	err = snap.Release()
+	if err != nil {
+		t.Fatalf("error releasing snapshot: %v", err)
+	}
+	err = snap.Release()
+	if err != nil {
+		t.Errorf("error releasing snapshot again: %v", err)
+	}
+	wantFiles = []string{"00000004.fst", "MANIFEST"}
+	if got := stackFiles(t, dir); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("expected files %v, got %v", wantFiles, got)
+	}
+
+	// snapshots outlive the stack
+	snap, err = s.Snapshot()
+	if err != nil {
+		t.Fatalf("error taking snapshot: %v", err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	val, exists, err = snap.Get([]byte("wed"))
+	if err != nil || !exists || val != 4 {
+		t.Errorf("expected wed 4, got %d %t %v", val, exists, err)
+	}
+	err = snap.Release()
+	if err != nil {
+		t.Fatalf("error releasing snapshot: %v", err)
+	}
+	if got := stackFiles(t, dir); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("expected files %v, got %v", wantFiles, got)
+	}
+	if _, err = s.Snapshot(); err == nil {
+		t.Errorf("expected error taking a snapshot of a closed stack")
+	}
+}
@@ -0,0 +1,127 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"sort"
+)
+
+// State tables associate a value with each state, such as the number of
+// keys reachable from it.  They are stored in optional sections, as pairs
+// of state address and value, sorted by address, each packed in a fixed
+// number of bytes:
+//
+//	1 byte address size, 1 byte value size
+//	for each state: address, value
+//
+// States are compiled before the states referring to them, at increasing
+// addresses, so while building, the values of the targets of a state are
+// always already known.
+
+// stateTableBuilder accumulates the values while building
+type stateTableBuilder struct {
+	addrs []int
+	vals  []uint64
+}
+
+func (t *stateTableBuilder) reset() {
+	t.addrs = t.addrs[:0]
+	t.vals = t.vals[:0]
+}
+
+func (t *stateTableBuilder) lookup(addr int) uint64 {
+	i := sort.SearchInts(t.addrs, addr)
+	if i < len(t.addrs) && t.addrs[i] == addr {
+		return t.vals[i]
+	}
+	return 0
+}
+
+// add records the value for a newly encoded state
+func (t *stateTableBuilder) add(addr int, val uint64) {
+	t.addrs = append(t.addrs, addr)
+	t.vals = append(t.vals, val)
+}
+
+func (t *stateTableBuilder) encode() []byte {
+	var maxAddr, maxVal uint64
+	for i := range t.addrs {
+		if uint64(t.addrs[i]) > maxAddr {
+			maxAddr = uint64(t.addrs[i])
+		}
+		if t.vals[i] > maxVal {
+			maxVal = t.vals[i]
+		}
+	}
+	addrSize, valSize := packedSize(maxAddr), packedSize(maxVal)
+	entrySize := addrSize + valSize
+	rv := make([]byte, 2+len(t.addrs)*entrySize)
+	rv[0], rv[1] = byte(addrSize), byte(valSize)
+	for i := range t.addrs {
+		entry := rv[2+i*entrySize:]
+		putPackedUint(entry[:addrSize], uint64(t.addrs[i]))
+		putPackedUint(entry[addrSize:entrySize], t.vals[i])
+	}
+	return rv
+}
+
+func putPackedUint(buf []byte, v uint64) {
+	for i := range buf {
+		buf[i] = byte(v >> uint(i*8))
+	}
+}
+
+// stateTable reads the values from the section data
+type stateTable struct {
+	data     []byte
+	addrSize int
+	valSize  int
+	n        int
+}
+
+func loadStateTable(data []byte, name string) (*stateTable, error) {
+	if len(data) < 2 || data[0] < 1 || data[0] > 8 || data[1] < 1 || data[1] > 8 {
+		return nil, corruptf(0, "invalid %s section", name)
+	}
+	rv := &stateTable{
+		data:     data[2:],
+		addrSize: int(data[0]),
+		valSize:  int(data[1]),
+	}
+	entrySize := rv.addrSize + rv.valSize
+	if len(rv.data)%entrySize != 0 {
+		return nil, corruptf(0, "invalid %s section length %d", name,
+			len(data))
+	}
+	rv.n = len(rv.data) / entrySize
+	return rv, nil
+}
+
+func (t *stateTable) addr(i int) int {
+	start := i * (t.addrSize + t.valSize)
+	return int(readPackedUint(t.data[start : start+t.addrSize]))
+}
+
+// get returns the value of the state at addr, if there is one
+func (t *stateTable) get(addr int) (uint64, bool) {
+	i := sort.Search(t.n, func(i int) bool {
+		return t.addr(i) >= addr
+	})
+	if i < t.n && t.addr(i) == addr {
+		start := i*(t.addrSize+t.valSize) + t.addrSize
+		return readPackedUint(t.data[start : start+t.valSize]), true
+	}
+	return 0, false
+}
@@ -0,0 +1,293 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/willf/bitset"
+)
+
+// StatsSchemaVersion is the version of the JSON structures produced by
+// Stats, MergeStats, BuildStats and DebugDumpJSON.  It is incremented whenever a field
+// is removed or the meaning of a field changes.  New fields may be added
+// without incrementing it, so consumers should ignore unknown fields.
+const StatsSchemaVersion = 1
+
+// Stats describes the contents of an FST.  The JSON encoding is stable,
+// see StatsSchemaVersion.
+type Stats struct {
+	// SchemaVersion is the StatsSchemaVersion which produced this value
+	SchemaVersion int `json:"schema_version"`
+	// Version is the encoding version of the FST
+	Version int `json:"version"`
+	// Type is the type of the FST
+	Type int `json:"type"`
+	// Keys is the number of keys in the FST
+	Keys int `json:"keys"`
+	// States is the number of distinct states reachable from the root
+	States int `json:"states"`
+	// FinalStates is the number of distinct final states
+	FinalStates int `json:"final_states"`
+	// Transitions is the number of transitions between distinct states
+	Transitions int `json:"transitions"`
+	// Bytes is the size of the encoded FST
+	Bytes int `json:"bytes"`
+	// Sizes breaks Bytes down by the parts of the FST
+	Sizes Sizes `json:"sizes"`
+}
+
+// Sizes breaks the size of an encoded FST down by its parts, in bytes.
+// The JSON encoding is stable, see StatsSchemaVersion.
+type Sizes struct {
+	// Total is the size of the encoded FST
+	Total int `json:"total"`
+	// Header is the size of the header, including the magic of a
+	// compressed FST
+	Header int `json:"header"`
+	// States is the size of the encoded states, compressed if the FST is
+	// compressed
+	States int `json:"states"`
+	// Sections is the size of each optional section, by name
+	Sections map[string]int `json:"sections,omitempty"`
+	// Other is the size of the rest, the footer, the table of sections
+	// and the index of the blocks of a compressed FST
+	Other int `json:"other"`
+}
+
+// Sizes returns the breakdown of the size of the FST.  Unlike Stats, it
+// doesn't visit the states.
+func (f *FST) Sizes() Sizes {
+	if f.enter() != nil {
+		return Sizes{}
+	}
+	defer f.exit()
+	return f.decoder.sizes()
+}
+
+// Stats visits every state of the FST and reports the resulting Stats.
+func (f *FST) Stats() (*Stats, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	rv := &Stats{
+		SchemaVersion: StatsSchemaVersion,
+		Version:       f.ver,
+		Type:          f.typ,
+		Keys:          f.len,
+		Bytes:         f.decoder.sizes().Total,
+		Sizes:         f.decoder.sizes(),
+	}
+	err := f.visitStates(func(state fstState) error {
+		rv.States++
+		if state.Final() {
+			rv.FinalStates++
+		}
+		rv.Transitions += state.NumTransitions()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Alphabet visits every state of the FST and reports the byte values used
+// by its transitions, that is the bytes appearing in its keys.
+func (f *FST) Alphabet() (*[256]bool, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	rv := &[256]bool{}
+	err := f.visitStates(func(state fstState) error {
+		for i := 0; i < state.NumTransitions(); i++ {
+			rv[state.TransitionAt(i)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// visitStates invokes the callback once for each distinct state reachable
+// from the root, in depth first order.
+func (f *FST) visitStates(cb func(fstState) error) error {
+	root := f.decoder.getRoot()
+	size := f.addrSpace()
+	set := bitset.New(uint(size))
+	stack := addrStack{root}
+	var addr int
+	for len(stack) > 0 {
+		stack, addr = stack.Pop()
+		if addr >= 0 && addr < size {
+			if set.Test(uint(addr)) {
+				continue
+			}
+			set.Set(uint(addr))
+		}
+		state, err := f.decoder.stateAt(addr, nil)
+		if err != nil {
+			return err
+		}
+		err = cb(state)
+		if err != nil {
+			return err
+		}
+		for i := state.NumTransitions() - 1; i >= 0; i-- {
+			_, dest, _ := state.TransitionFor(state.TransitionAt(i))
+			stack = append(stack, dest)
+		}
+	}
+	return nil
+}
+
+// addrSpace returns the bound of the addresses of the states, that is the
+// size of the data, or of the states once uncompressed.
+func (f *FST) addrSpace() int {
+	switch d := f.decoder.(type) {
+	case *compressedDecoder:
+		return d.statesEnd
+	case *readerAtDecoder:
+		return d.statesEnd
+	}
+	return len(f.data)
+}
+
+// MergeStats describes the outcome of a Merge.  The JSON encoding is
+// stable, see StatsSchemaVersion.
+type MergeStats struct {
+	// SchemaVersion is the StatsSchemaVersion which produced this value
+	SchemaVersion int `json:"schema_version"`
+	// Inputs is the number of Iterators merged
+	Inputs int `json:"inputs"`
+	// Keys is the number of keys in the resulting FST
+	Keys int `json:"keys"`
+	// MergedKeys is the number of keys found in more than one Iterator,
+	// the value of which was chosen with the MergeFunc
+	MergedKeys int `json:"merged_keys"`
+	// DroppedKeys is the number of keys dropped by the MergeRekey function
+	DroppedKeys int `json:"dropped_keys"`
+}
+
+// DebugDumpJSON writes a JSON document describing every distinct state
+// reachable from the root of the FST.  The document is written
+// incrementally and has the structure:
+//
+//	{
+//	  "schema_version": 1,
+//	  "version": 1,
+//	  "type": 0,
+//	  "keys": 4,
+//	  "root": 42,
+//	  "states": [
+//	    {
+//	      "addr": 42,
+//	      "final": false,
+//	      "final_output": 0,
+//	      "transitions": [
+//	        {"label": 109, "dest": 30, "output": 2}
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// See StatsSchemaVersion for the compatibility guarantees.
+func (f *FST) DebugDumpJSON(w io.Writer) error {
+	if err := f.enter(); err != nil {
+		return err
+	}
+	defer f.exit()
+	header, err := json.Marshal(debugDumpHeader{
+		SchemaVersion: StatsSchemaVersion,
+		Version:       f.ver,
+		Type:          f.typ,
+		Keys:          f.len,
+		Root:          f.decoder.getRoot(),
+	})
+	if err != nil {
+		return err
+	}
+	// reopen the header object to append the states
+	_, err = w.Write(header[:len(header)-1])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, `,"states":[`)
+	if err != nil {
+		return err
+	}
+	first := true
+	err = f.visitStates(func(state fstState) error {
+		ds := debugDumpState{
+			Addr:        state.Address(),
+			Final:       state.Final(),
+			FinalOutput: state.FinalOutput(),
+			Transitions: make([]debugDumpTransition, 0, state.NumTransitions()),
+		}
+		for i := 0; i < state.NumTransitions(); i++ {
+			label := state.TransitionAt(i)
+			_, dest, out := state.TransitionFor(label)
+			ds.Transitions = append(ds.Transitions, debugDumpTransition{
+				Label:  label,
+				Dest:   dest,
+				Output: out,
+			})
+		}
+		buf, err := json.Marshal(ds)
+		if err != nil {
+			return err
+		}
+		if !first {
+			_, err = io.WriteString(w, ",")
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+type debugDumpHeader struct {
+	SchemaVersion int `json:"schema_version"`
+	Version       int `json:"version"`
+	Type          int `json:"type"`
+	Keys          int `json:"keys"`
+	Root          int `json:"root"`
+}
+
+type debugDumpState struct {
+	Addr        int                   `json:"addr"`
+	Final       bool                  `json:"final"`
+	FinalOutput uint64                `json:"final_output"`
+	Transitions []debugDumpTransition `json:"transitions"`
+}
+
+type debugDumpTransition struct {
+	Label  byte   `json:"label"`
+	Dest   int    `json:"dest"`
+	Output uint64 `json:"output"`
+}
@@ -0,0 +1,374 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	data := buildSmallSample(t)
+	fst, err := Load(data)
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	stats, err := fst.Stats()
+	if err != nil {
+		t.Fatalf("error getting stats: %v", err)
+	}
+	// states: root, m, mo, t, th, thu, thur, tu, ty, and the shared
+	// final state, with thurs and tues also sharing the s suffix
+	want := &Stats{
+		SchemaVersion: StatsSchemaVersion,
+		Version:       1,
+		Keys:          4,
+		States:        10,
+		FinalStates:   1,
+		Transitions:   12,
+		Bytes:         len(data),
+		Sizes: Sizes{
+			Total:  len(data),
+			Header: headerSize,
+			States: len(data) - headerSize - footerSizeV1,
+			Other:  footerSizeV1,
+		},
+	}
+	if !reflect.DeepEqual(want, stats) {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+
+	buf, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(buf, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"schema_version", "version", "type", "keys",
+		"states", "final_states", "transitions", "bytes", "sizes"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("expected json field %s in %s", name, buf)
+		}
+	}
+}
+
+func TestSizes(t *testing.T) {
+	build := func(opts ...BuilderOption) *FST {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		for i, key := range thousandTestWords {
+			err = b.Insert([]byte(key), uint64(i))
+			if err != nil {
+				t.Fatalf("error inserting: %v", err)
+			}
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing: %v", err)
+		}
+		fst, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		return fst
+	}
+	tests := []struct {
+		opts     []BuilderOption
+		sections []string
+	}{
+		{nil, nil},
+		{[]BuilderOption{WithSubtreeCounts(), WithChecksums()},
+			[]string{"checksums", "subtree_counts"}},
+		{[]BuilderOption{WithCompression("flate"), WithDepthHints()},
+			[]string{"depth_hints"}},
+	}
+	for _, test := range tests {
+		fst := build(test.opts...)
+		sizes := fst.Sizes()
+		sum := sizes.Header + sizes.States + sizes.Other
+		var names []string
+		for name, size := range sizes.Sections {
+			if size <= 0 {
+				t.Errorf("expected section %s to have a size, got %d", name, size)
+			}
+			sum += size
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.sections) {
+			t.Errorf("expected sections %v, got %v", test.sections, names)
+		}
+		if sizes.Total != len(fst.data) || sum != sizes.Total {
+			t.Errorf("expected sizes to add up to %d, got %+v", len(fst.data),
+				sizes)
+		}
+		if sizes.States <= 0 || sizes.Other <= 0 {
+			t.Errorf("expected states and other sizes, got %+v", sizes)
+		}
+		if fst.IsCompressed() != (sizes.Header > headerSize) {
+			t.Errorf("expected magic in the header of compressed fsts, got %+v",
+				sizes)
+		}
+	}
+}
+
+func TestAlphabet(t *testing.T) {
+	fst, err := Load(buildSmallSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	alphabet, err := fst.Alphabet()
+	if err != nil {
+		t.Fatalf("error getting alphabet: %v", err)
+	}
+	var got string
+	for b, used := range alphabet {
+		if used {
+			got += string(rune(b))
+		}
+	}
+	if got != "ehmnorstuy" {
+		t.Errorf("expected alphabet ehmnorstuy, got %s", got)
+	}
+}
+
+func TestDebugDumpJSON(t *testing.T) {
+	fst, err := Load(buildSmallSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = fst.DebugDumpJSON(&buf)
+	if err != nil {
+		t.Fatalf("error dumping: %v", err)
+	}
+
+	var dump struct {
+		SchemaVersion int `json:"schema_version"`
+		Keys          int `json:"keys"`
+		Root          int `json:"root"`
+		States        []struct {
+			Addr        int    `json:"addr"`
+			Final       bool   `json:"final"`
+			FinalOutput uint64 `json:"final_output"`
+			Transitions []struct {
+				Label  byte   `json:"label"`
+				Dest   int    `json:"dest"`
+				Output uint64 `json:"output"`
+			} `json:"transitions"`
+		} `json:"states"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &dump)
+	if err != nil {
+		t.Fatalf("error parsing dump %s: %v", buf.String(), err)
+	}
+	if dump.SchemaVersion != StatsSchemaVersion || dump.Keys != 4 ||
+		len(dump.States) != 10 || dump.States[0].Addr != dump.Root {
+		t.Errorf("unexpected dump %s", buf.String())
+	}
+
+	// reconstruct the key/value pairs from the dump
+	states := map[int]int{}
+	for i, s := range dump.States {
+		states[s.Addr] = i
+	}
+	got := map[string]uint64{}
+	var walk func(addr int, key string, total uint64)
+	walk = func(addr int, key string, total uint64) {
+		s := dump.States[states[addr]]
+		if s.Final {
+			got[key] = total + s.FinalOutput
+		}
+		for _, t := range s.Transitions {
+			walk(t.Dest, key+string(t.Label), total+t.Output)
+		}
+	}
+	walk(dump.Root, "", 0)
+	if !reflect.DeepEqual(smallSample, got) {
+		t.Errorf("expected %v, got: %v", smallSample, got)
+	}
+}
+
+func TestMergeWithStats(t *testing.T) {
+	itr0, err := newTestIterator(map[string]uint64{"a": 1, "b": 2, "c": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr1, err := newTestIterator(map[string]uint64{"b": 5, "d": 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	stats, err := MergeWithStats(&buf, nil, []Iterator{itr0, itr1}, MergeSum)
+	if err != nil {
+		t.Fatalf("error merging: %v", err)
+	}
+	want := &MergeStats{
+		SchemaVersion: StatsSchemaVersion,
+		Inputs:        2,
+		Keys:          4,
+		MergedKeys:    1,
+	}
+	if !reflect.DeepEqual(want, stats) {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestKeyHistogram(t *testing.T) {
+	for _, data := range [][]byte{buildWordsSample(t), buildWordsWithCounts(t)} {
+		fst, err := Load(data)
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		for _, depth := range []int{0, 1, 2, 3} {
+			hist, err := fst.KeyHistogram(depth)
+			if err != nil {
+				t.Fatalf("error getting histogram: %v", err)
+			}
+			var firstByte [256]int
+			var prefixes []PrefixCount
+			for _, word := range thousandTestWords {
+				firstByte[word[0]]++
+				prefix := word
+				if len(prefix) > depth {
+					prefix = prefix[:depth]
+				}
+				last := len(prefixes) - 1
+				if last >= 0 && string(prefixes[last].Prefix) == prefix {
+					prefixes[last].Count++
+				} else {
+					prefixes = append(prefixes, PrefixCount{
+						Prefix: []byte(prefix),
+						Count:  1,
+					})
+				}
+			}
+			if hist.Keys != len(thousandTestWords) {
+				t.Errorf("depth %d: expected %d keys, got %d", depth,
+					len(thousandTestWords), hist.Keys)
+			}
+			if hist.FirstByte != firstByte {
+				t.Errorf("depth %d: unexpected first byte counts", depth)
+			}
+			if !reflect.DeepEqual(prefixes, hist.Prefixes) {
+				t.Errorf("depth %d: expected %v, got %v", depth, prefixes,
+					hist.Prefixes)
+			}
+		}
+	}
+
+	fst, err := Load(buildWordsSample(t))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	hist, err := fst.KeyHistogram(3)
+	if err != nil {
+		t.Fatalf("error getting histogram: %v", err)
+	}
+	partitions := hist.Partitions(4)
+	if len(partitions) != 3 {
+		t.Fatalf("expected 3 partition points, got %q", partitions)
+	}
+	start := []byte(nil)
+	for i := 0; i <= len(partitions); i++ {
+		var end []byte
+		if i < len(partitions) {
+			end = partitions[i]
+		}
+		itr, err := fst.Iterator(start, end)
+		n := len(iterateKeys(t, itr, err))
+		// a prefix of depth 3 holds at most a few percent of the words
+		if n < len(thousandTestWords)/4-50 || n > len(thousandTestWords)/4+50 {
+			t.Errorf("partition %d: expected about a quarter of the keys, got %d",
+				i, n)
+		}
+		start = end
+	}
+}
+
+func TestValueStats(t *testing.T) {
+	var kvs []KV
+	for i, word := range thousandTestWords {
+		kvs = append(kvs, KV{Key: word, Val: uint64(i)})
+	}
+	fst := buildKVs(t, kvs...)
+	stats, err := fst.ValueStats(1000)
+	if err != nil {
+		t.Fatalf("error getting value stats: %v", err)
+	}
+	if stats.Keys != 1000 || stats.Min != 0 || stats.Max != 999 ||
+		!stats.Monotone || stats.Mean != 499.5 {
+		t.Errorf("unexpected value stats %+v", stats)
+	}
+	// a bucket per value, the percentiles are exact
+	if len(stats.Buckets) != 1000 || stats.P50 != 499 || stats.P90 != 899 ||
+		stats.P99 != 989 || stats.Percentile(100) != 999 ||
+		stats.Percentile(0) != 0 {
+		t.Errorf("unexpected percentiles %d %d %d", stats.P50, stats.P90,
+			stats.P99)
+	}
+
+	stats, err = fst.ValueStats(7)
+	if err != nil {
+		t.Fatalf("error getting value stats: %v", err)
+	}
+	var n int
+	for i, b := range stats.Buckets {
+		n += b.Count
+		if i > 0 && b.Min != stats.Buckets[i-1].Max+1 {
+			t.Errorf("bucket %d: expected to follow the previous one, got %+v",
+				i, b)
+		}
+	}
+	if len(stats.Buckets) != 7 || n != 1000 ||
+		stats.Buckets[6].Max != 999 {
+		t.Errorf("unexpected buckets %+v", stats.Buckets)
+	}
+	if stats.P50 < 499 || stats.P50 > 499+1000/7+1 {
+		t.Errorf("expected an upper bound of the median, got %d", stats.P50)
+	}
+
+	fst = buildKVs(t, KV{"a", 5}, KV{"b", 1 << 63}, KV{"c", 3},
+		KV{"d", ^uint64(0)})
+	stats, err = fst.ValueStats(4)
+	if err != nil {
+		t.Fatalf("error getting value stats: %v", err)
+	}
+	if stats.Monotone || stats.Min != 3 || stats.Max != ^uint64(0) ||
+		len(stats.Buckets) != 4 || stats.Buckets[0].Count != 2 ||
+		stats.Buckets[3].Max != ^uint64(0) || stats.P99 != ^uint64(0) {
+		t.Errorf("unexpected value stats %+v", stats)
+	}
+
+	stats, err = buildKVs(t).ValueStats(10)
+	if err != nil {
+		t.Fatalf("error getting value stats: %v", err)
+	}
+	if stats.Keys != 0 || stats.Buckets != nil || stats.Percentile(50) != 0 {
+		t.Errorf("unexpected value stats of no keys %+v", stats)
+	}
+}
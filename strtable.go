@@ -0,0 +1,300 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A string table is a sidecar file holding the original forms of the keys
+// of an FST built from normalized keys, such as lowercased or case folded
+// ones.  The strings are stored back to back in an arena, followed by the
+// end offset of each string, the number of strings and a magic number:
+//
+//	[arena][end offset (8 bytes) x count][count (8 bytes)][magic (8 bytes)]
+//
+// All integers are little endian.
+
+const stringTableMagic = 0x31626174727473 // "strtab1"
+
+const stringTableFooterSize = 16
+
+// ErrStringTableClosed is returned by StringTableWriter.Add after Close.
+var ErrStringTableClosed = errors.New("string table writer is closed")
+
+// StringTableWriter writes a string table.
+type StringTableWriter struct {
+	w      *bufio.Writer
+	ends   []uint64
+	size   uint64
+	closed bool
+}
+
+// NewStringTableWriter returns a StringTableWriter writing to w.
+func NewStringTableWriter(w io.Writer) *StringTableWriter {
+	return &StringTableWriter{
+		w: bufio.NewWriter(w),
+	}
+}
+
+// Add appends the string to the table, returning its id, the number of
+// strings added before it.  Strings addressed by value are added in any
+// order, and the returned id inserted as the value of the normalized key.
+// Strings addressed by ordinal are added in the order of their normalized
+// keys.
+func (s *StringTableWriter) Add(str []byte) (uint64, error) {
+	if s.closed {
+		return 0, ErrStringTableClosed
+	}
+	_, err := s.w.Write(str)
+	if err != nil {
+		return 0, err
+	}
+	s.size += uint64(len(str))
+	s.ends = append(s.ends, s.size)
+	return uint64(len(s.ends) - 1), nil
+}
+
+// Len returns the number of strings added.
+func (s *StringTableWriter) Len() int {
+	return len(s.ends)
+}
+
+// Close writes the end of the table and flushes it, it does not close the
+// underlying io.Writer.
+func (s *StringTableWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var buf [8]byte
+	for _, end := range s.ends {
+		binary.LittleEndian.PutUint64(buf[:], end)
+		_, err := s.w.Write(buf[:])
+		if err != nil {
+			return err
+		}
+	}
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(s.ends)))
+	_, err := s.w.Write(buf[:])
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(buf[:], stringTableMagic)
+	_, err = s.w.Write(buf[:])
+	if err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// StringTable reads a string table.  The strings are slices of its data,
+// so looking them up doesn't allocate.
+type StringTable struct {
+	arena []byte
+	ends  []byte
+	n     int
+}
+
+// LoadStringTable reads a string table from the data, which must remain
+// unchanged while the StringTable is in use.
+func LoadStringTable(data []byte) (*StringTable, error) {
+	if len(data) < stringTableFooterSize {
+		return nil, corruptf(0, "string table of %d bytes is too short",
+			len(data))
+	}
+	footer := len(data) - stringTableFooterSize
+	if binary.LittleEndian.Uint64(data[footer+8:]) != stringTableMagic {
+		return nil, corruptf(footer+8, "not a string table")
+	}
+	n := binary.LittleEndian.Uint64(data[footer:])
+	if n > uint64(footer/8) {
+		return nil, corruptf(footer, "invalid string count %d", n)
+	}
+	endsStart := footer - int(n)*8
+	rv := &StringTable{
+		arena: data[:endsStart],
+		ends:  data[endsStart:footer],
+		n:     int(n),
+	}
+	if n > 0 && rv.end(rv.n-1) != uint64(endsStart) {
+		return nil, corruptf(footer-8, "arena of %d bytes doesn't end at %d",
+			endsStart, rv.end(rv.n-1))
+	}
+	return rv, nil
+}
+
+func (t *StringTable) end(i int) uint64 {
+	return binary.LittleEndian.Uint64(t.ends[i*8:])
+}
+
+// Len returns the number of strings of the table.
+func (t *StringTable) Len() int {
+	return t.n
+}
+
+// Get returns the string with the id.
+func (t *StringTable) Get(id uint64) ([]byte, error) {
+	if id >= uint64(t.n) {
+		return nil, fmt.Errorf("string id %d out of range [0, %d)", id, t.n)
+	}
+	var start uint64
+	if id > 0 {
+		start = t.end(int(id) - 1)
+	}
+	end := t.end(int(id))
+	if start > end || end > uint64(len(t.arena)) {
+		return nil, corruptf(len(t.arena)+int(id)*8,
+			"invalid string bounds [%d, %d)", start, end)
+	}
+	return t.arena[start:end], nil
+}
+
+// OriginalKeyAddressing is how the original form of a normalized key is
+// found in a string table.
+type OriginalKeyAddressing int
+
+const (
+	// OriginalByValue uses the value of the normalized key as the id of
+	// its original form
+	OriginalByValue OriginalKeyAddressing = iota
+	// OriginalByOrdinal uses the ordinal of the normalized key, its
+	// position in key order, as the id of its original form, leaving the
+	// values of the FST free for other uses
+	OriginalByOrdinal
+)
+
+// OriginalKeys reads an FST of normalized keys together with the string
+// table of their original forms.
+type OriginalKeys struct {
+	fst   *FST
+	table *StringTable
+	addr  OriginalKeyAddressing
+}
+
+// NewOriginalKeys returns an OriginalKeys looking up the original forms of
+// the keys of the FST in the table.
+func NewOriginalKeys(f *FST, table *StringTable,
+	addr OriginalKeyAddressing) (*OriginalKeys, error) {
+	switch addr {
+	case OriginalByValue:
+	case OriginalByOrdinal:
+		if table.Len() != f.Len() {
+			return nil, fmt.Errorf("string table of %d strings for %d keys",
+				table.Len(), f.Len())
+		}
+	default:
+		return nil, fmt.Errorf("unknown original key addressing %d", addr)
+	}
+	return &OriginalKeys{
+		fst:   f,
+		table: table,
+		addr:  addr,
+	}, nil
+}
+
+// Get returns the original form and the value of the normalized key, and
+// whether it exists.  When addressed by value, the value is the id of the
+// original form.
+func (o *OriginalKeys) Get(key []byte) ([]byte, uint64, bool, error) {
+	val, exists, err := o.fst.Get(key)
+	if err != nil || !exists {
+		return nil, 0, false, err
+	}
+	id := val
+	if o.addr == OriginalByOrdinal {
+		id, err = o.fst.keysBefore(key)
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+	original, err := o.table.Get(id)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return original, val, true, nil
+}
+
+// Iterator returns an OriginalKeysIterator over the normalized keys with
+// startKeyInclusive <= key < endKeyExclusive.
+func (o *OriginalKeys) Iterator(startKeyInclusive,
+	endKeyExclusive []byte) (*OriginalKeysIterator, error) {
+	itr, err := o.fst.Iterator(startKeyInclusive, endKeyExclusive)
+	if err != nil {
+		return nil, err
+	}
+	rv := &OriginalKeysIterator{
+		o:   o,
+		itr: itr,
+	}
+	if o.addr == OriginalByOrdinal {
+		key, _ := itr.Current()
+		rv.ordinal, err = o.fst.keysBefore(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rv.lookup()
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// OriginalKeysIterator iterates over normalized keys along with their
+// original forms.
+type OriginalKeysIterator struct {
+	o        *OriginalKeys
+	itr      *FSTIterator
+	ordinal  uint64
+	original []byte
+}
+
+func (i *OriginalKeysIterator) lookup() error {
+	id := i.ordinal
+	if i.o.addr == OriginalByValue {
+		_, id = i.itr.Current()
+	}
+	var err error
+	i.original, err = i.o.table.Get(id)
+	return err
+}
+
+// Current returns the normalized key, its original form and its value.
+// The slices are only valid until the next call to Next.
+func (i *OriginalKeysIterator) Current() ([]byte, []byte, uint64) {
+	key, val := i.itr.Current()
+	return key, i.original, val
+}
+
+// Next advances to the next key, see FSTIterator.Next.
+func (i *OriginalKeysIterator) Next() error {
+	err := i.itr.Next()
+	if err != nil {
+		return err
+	}
+	// the keys of a range iteration are consecutive
+	i.ordinal++
+	return i.lookup()
+}
+
+// Close will free any resources held by this iterator.
+func (i *OriginalKeysIterator) Close() error {
+	return i.itr.Close()
+}
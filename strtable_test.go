@@ -0,0 +1,130 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOriginalKeys(t *testing.T) {
+	originals := []string{"Apple", "BANANA", "Cherry", "cherryPie", "Date"}
+
+	for _, addr := range []OriginalKeyAddressing{OriginalByValue,
+		OriginalByOrdinal} {
+		var tableBuf bytes.Buffer
+		w := NewStringTableWriter(&tableBuf)
+		var kvs []KV
+		// by value, the strings are added in reverse order, to be sure the
+		// ids are what is looked up
+		for i := range originals {
+			original := originals[i]
+			if addr == OriginalByValue {
+				original = originals[len(originals)-1-i]
+			}
+			id, err := w.Add([]byte(original))
+			if err != nil {
+				t.Fatalf("error adding: %v", err)
+			}
+			val := uint64(100 + i)
+			if addr == OriginalByValue {
+				val = id
+			}
+			kvs = append(kvs, KV{strings.ToLower(original), val})
+		}
+		err := w.Close()
+		if err != nil {
+			t.Fatalf("error closing table: %v", err)
+		}
+		if _, err = w.Add(nil); err != ErrStringTableClosed {
+			t.Errorf("expected ErrStringTableClosed, got %v", err)
+		}
+		if addr == OriginalByValue {
+			sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+		}
+		fst := buildKVs(t, kvs...)
+		table, err := LoadStringTable(tableBuf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading table: %v", err)
+		}
+		o, err := NewOriginalKeys(fst, table, addr)
+		if err != nil {
+			t.Fatalf("error creating original keys: %v", err)
+		}
+
+		for _, want := range originals {
+			got, _, exists, err := o.Get([]byte(strings.ToLower(want)))
+			if err != nil || !exists || string(got) != want {
+				t.Errorf("%d: expected %q, got %q %t %v", addr, want, got,
+					exists, err)
+			}
+		}
+		_, _, exists, err := o.Get([]byte("fig"))
+		if err != nil || exists {
+			t.Errorf("%d: expected fig not to exist, got %t %v", addr, exists,
+				err)
+		}
+
+		itr, err := o.Iterator([]byte("c"), nil)
+		var got []string
+		for err == nil {
+			key, original, _ := itr.Current()
+			if !bytes.Equal(key, bytes.ToLower(original)) {
+				t.Errorf("%d: key %q has original %q", addr, key, original)
+			}
+			got = append(got, string(original))
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("%d: error iterating: %v", addr, err)
+		}
+		if strings.Join(got, ",") != "Cherry,cherryPie,Date" {
+			t.Errorf("%d: expected Cherry,cherryPie,Date, got %v", addr, got)
+		}
+	}
+}
+
+func TestStringTableCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewStringTableWriter(&buf)
+	for _, s := range []string{"a", "", "bcd"} {
+		if _, err := w.Add([]byte(s)); err != nil {
+			t.Fatalf("error adding: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	table, err := LoadStringTable(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if s, err := table.Get(1); err != nil || len(s) != 0 {
+		t.Errorf("expected empty string, got %q %v", s, err)
+	}
+	if _, err := table.Get(3); err == nil {
+		t.Errorf("expected error for id out of range")
+	}
+
+	data := buf.Bytes()
+	for _, bad := range [][]byte{data[:10], data[1:], data[:len(data)-1]} {
+		if _, err := LoadStringTable(bad); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected ErrCorrupt, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"math"
+)
+
+// SubFST is a view of the keys of an FST starting with a prefix, with the
+// prefix removed.  It allows several dictionaries, such as one per field or
+// per tenant, to be stored in a single FST, with each prefix identifying a
+// dictionary.  A SubFST is cheap to create, and safe for concurrent use.
+type SubFST struct {
+	f      *FST
+	prefix []byte
+}
+
+// Sub returns a view of the keys starting with the prefix, relative to the
+// prefix.
+func (f *FST) Sub(prefix []byte) *SubFST {
+	return &SubFST{
+		f:      f,
+		prefix: append([]byte(nil), prefix...),
+	}
+}
+
+// Sub returns a view of the keys starting with the prefix, relative to
+// this view.
+func (s *SubFST) Sub(prefix []byte) *SubFST {
+	return s.f.Sub(prefixed(s.prefix, prefix))
+}
+
+// Prefix returns the prefix of the keys in the view.
+func (s *SubFST) Prefix() []byte {
+	return s.prefix
+}
+
+// Contains returns true if the view contains the key.
+func (s *SubFST) Contains(key []byte) (bool, error) {
+	return s.f.Contains(prefixed(s.prefix, key))
+}
+
+// Get returns the value associated with the key, see FST.Get.
+func (s *SubFST) Get(key []byte) (uint64, bool, error) {
+	return s.f.Get(prefixed(s.prefix, key))
+}
+
+// Iterator returns an Iterator over the keys of the view, between the
+// provided startKeyInclusive and endKeyExclusive, which are relative to the
+// prefix, as are the keys returned.
+func (s *SubFST) Iterator(startKeyInclusive, endKeyExclusive []byte) (*SubIterator, error) {
+	return s.Search(nil, startKeyInclusive, endKeyExclusive)
+}
+
+// Search returns an Iterator over the keys of the view matching the
+// automaton, between the provided startKeyInclusive and endKeyExclusive.
+// The automaton, bounds and keys returned are all relative to the prefix.
+func (s *SubFST) Search(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte) (*SubIterator, error) {
+	rv := &SubIterator{}
+	err := rv.reset(s.f, s.prefix, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// SubIterator iterates over the keys of a SubFST.
+type SubIterator struct {
+	prefix []byte
+	itr    FSTIterator
+}
+
+// Current returns the key, without the prefix, and value currently pointed
+// to by the iterator.
+func (i *SubIterator) Current() ([]byte, uint64) {
+	key, val := i.itr.Current()
+	if key == nil {
+		return nil, val
+	}
+	return key[len(i.prefix):], val
+}
+
+// Next advances the iterator to the next key/value pair, see
+// FSTIterator.Next.
+func (i *SubIterator) Next() error {
+	return i.itr.Next()
+}
+
+// Seek advances the iterator to the specified key, relative to the prefix,
+// see FSTIterator.Seek.
+func (i *SubIterator) Seek(key []byte) error {
+	return i.itr.Seek(prefixed(i.prefix, key))
+}
+
+// Reset resets the iterator to iterate over a view of the provided FST,
+// with the same prefix, see FSTIterator.Reset.
+func (i *SubIterator) Reset(f *FST, startKeyInclusive, endKeyExclusive []byte,
+	aut Automaton) error {
+	return i.reset(f, i.prefix, startKeyInclusive, endKeyExclusive, aut)
+}
+
+// Close will free any resources held by this iterator.
+func (i *SubIterator) Close() error {
+	return i.itr.Close()
+}
+
+func (i *SubIterator) reset(f *FST, prefix, startKeyInclusive,
+	endKeyExclusive []byte, aut Automaton) error {
+	i.prefix = prefix
+	start := prefixed(prefix, startKeyInclusive)
+	var end []byte
+	if endKeyExclusive != nil {
+		end = prefixed(prefix, endKeyExclusive)
+	} else {
+		end = PrefixSuccessor(prefix)
+	}
+	if aut != nil {
+		aut = &prefixAutomaton{prefix: prefix, aut: aut, start: aut.Start()}
+	}
+	return i.itr.Reset(f, start, end, aut)
+}
+
+// SearchFrom returns an Iterator over the keys starting with the prefix,
+// with startKeyInclusive <= key < endKeyExclusive, whose remainder after
+// the prefix the automaton matches, starting in the state autState rather
+// than in its start state.  The keys returned include the prefix.
+//
+// This resumes a search from a saved (prefix, state) pair, such as the
+// state reached by the automaton after the prefix (see AutomatonAdvance),
+// so that one logical search can be split by prefix, and each part run at
+// another time, or on another machine.  The automaton must then be built
+// identically, from the same source, for the state to be meaningful.
+func (f *FST) SearchFrom(aut Automaton, prefix []byte, autState int,
+	startKeyInclusive, endKeyExclusive []byte) (*FSTIterator, error) {
+	if bytes.Compare(startKeyInclusive, prefix) < 0 {
+		startKeyInclusive = prefix
+	}
+	if end := PrefixSuccessor(prefix); end != nil &&
+		(endKeyExclusive == nil || bytes.Compare(end, endKeyExclusive) < 0) {
+		endKeyExclusive = end
+	}
+	if aut == nil {
+		aut = alwaysMatchAutomaton
+	}
+	prefix = append([]byte(nil), prefix...)
+	return f.Search(&prefixAutomaton{prefix: prefix, aut: aut,
+		start: autState}, startKeyInclusive, endKeyExclusive)
+}
+
+func prefixed(prefix, key []byte) []byte {
+	rv := make([]byte, len(prefix)+len(key))
+	copy(rv, prefix)
+	copy(rv[len(prefix):], key)
+	return rv
+}
+
+// prefixAutomaton matches the prefix, followed by keys matching the
+// wrapped automaton from the start state.  While matching the prefix, after
+// i bytes, it is in state -(i+1), once matched it shares the states of the
+// wrapped automaton, which must not be negative.
+type prefixAutomaton struct {
+	prefix []byte
+	aut    Automaton
+	start  int
+}
+
+const prefixAutomatonDead = math.MinInt32
+
+func (p *prefixAutomaton) Start() int {
+	if len(p.prefix) == 0 {
+		return p.start
+	}
+	return -1
+}
+
+func (p *prefixAutomaton) IsMatch(s int) bool {
+	return s >= 0 && p.aut.IsMatch(s)
+}
+
+func (p *prefixAutomaton) CanMatch(s int) bool {
+	if s < 0 {
+		return s != prefixAutomatonDead
+	}
+	return p.aut.CanMatch(s)
+}
+
+func (p *prefixAutomaton) WillAlwaysMatch(s int) bool {
+	return s >= 0 && p.aut.WillAlwaysMatch(s)
+}
+
+func (p *prefixAutomaton) Accept(s int, b byte) int {
+	if s >= 0 {
+		return p.aut.Accept(s, b)
+	}
+	if s == prefixAutomatonDead {
+		return s
+	}
+	i := -s - 1
+	if p.prefix[i] != b {
+		return prefixAutomatonDead
+	}
+	if i+1 == len(p.prefix) {
+		return p.start
+	}
+	return s - 1
+}
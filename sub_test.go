@@ -0,0 +1,181 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum/regexp"
+)
+
+func subKeys(t *testing.T, itr *SubIterator, err error) []string {
+	var rv []string
+	for err == nil {
+		key, _ := itr.Current()
+		rv = append(rv, string(key))
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone {
+		t.Fatalf("error iterating: %v", err)
+	}
+	return rv
+}
+
+func TestSub(t *testing.T) {
+	var buf bytes.Buffer
+	err := FromMap(map[string]uint64{
+		"body:bar":   1,
+		"body:baz":   2,
+		"body:foo":   3,
+		"title:bar":  4,
+		"title:qux":  5,
+		"title:\xff": 6,
+		"title;":     7,
+		"title":      8,
+	}, &buf)
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	title := fst.Sub([]byte("title:"))
+	val, exists, err := title.Get([]byte("bar"))
+	if err != nil || !exists || val != 4 {
+		t.Errorf("expected bar 4, got %d %t %v", val, exists, err)
+	}
+	exists, err = title.Contains([]byte("foo"))
+	if err != nil || exists {
+		t.Errorf("expected no foo, got %t %v", exists, err)
+	}
+
+	itr, err := title.Iterator(nil, nil)
+	got := subKeys(t, itr, err)
+	want := []string{"bar", "qux", "\xff"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	itr, err = fst.Sub([]byte("body:")).Iterator([]byte("baz"), []byte("c"))
+	got = subKeys(t, itr, err)
+	want = []string{"baz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	r, err := regexp.New(`ba.`)
+	if err != nil {
+		t.Fatalf("error compiling regexp: %v", err)
+	}
+	itr, err = fst.Sub([]byte("body:")).Search(r, nil, nil)
+	got = subKeys(t, itr, err)
+	want = []string{"bar", "baz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	err = itr.Seek([]byte("baz"))
+	if err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	key, val := itr.Current()
+	if string(key) != "baz" || val != 2 {
+		t.Errorf("expected baz 2 after seek, got %q %d", key, val)
+	}
+
+	nested := fst.Sub([]byte("ti")).Sub([]byte("tle:"))
+	if string(nested.Prefix()) != "title:" {
+		t.Errorf("expected nested prefix title:, got %q", nested.Prefix())
+	}
+	itr, err = nested.Search(r, nil, nil)
+	got = subKeys(t, itr, err)
+	want = []string{"bar"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	_, err = fst.Sub([]byte("none:")).Iterator(nil, nil)
+	if !errors.Is(err, ErrIteratorDone) {
+		t.Errorf("expected ErrIteratorDone, got %v", err)
+	}
+}
+
+func TestSearchFrom(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords,
+		make([]uint64, len(thousandTestWords)))
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	r, err := regexp.New(`[a-m].*s`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := func(itr *FSTIterator, err error) []string {
+		var rv []string
+		for err == nil {
+			key, _ := itr.Current()
+			rv = append(rv, string(key))
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("error iterating: %v", err)
+		}
+		return rv
+	}
+	want := keys(fst.Search(r, nil, nil))
+
+	// the same search, split by first byte
+	var got []string
+	for c := 0; c < 256; c++ {
+		prefix := []byte{byte(c)}
+		s, ok := AutomatonAdvance(r, r.Start(), prefix)
+		if ok {
+			got = append(got, keys(fst.SearchFrom(r, prefix, s, nil, nil))...)
+		}
+	}
+	if len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %d keys, got %d", len(want), len(got))
+	}
+
+	// bounds are intersected with the prefix
+	s, _ := AutomatonAdvance(r, r.Start(), []byte("b"))
+	got = keys(fst.SearchFrom(r, []byte("b"), s, []byte("a"), []byte("c")))
+	want = keys(fst.Search(r, []byte("b"), []byte("c")))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	got = keys(fst.SearchFrom(nil, []byte("zo"), 0, nil, nil))
+	want = keys(fst.Iterator([]byte("zo"), []byte("zp")))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
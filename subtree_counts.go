@@ -0,0 +1,77 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// Subtree counts record, for each state, the number of keys reachable from
+// it (including the state itself if it is final), in a state table.
+
+type subtreeCounter struct {
+	table stateTableBuilder
+}
+
+func (c *subtreeCounter) section() int {
+	return sectionSubtreeCounts
+}
+
+func (c *subtreeCounter) reset() {
+	c.table.reset()
+}
+
+func (c *subtreeCounter) lookup(addr int) uint64 {
+	if addr == emptyAddr {
+		return 1
+	}
+	return c.table.lookup(addr)
+}
+
+// add records the count for a newly encoded state
+func (c *subtreeCounter) add(addr int, node *builderNode) {
+	var n uint64
+	if node.final {
+		n = 1
+	}
+	for _, t := range node.trans {
+		n += c.lookup(t.addr)
+	}
+	c.table.add(addr, n)
+}
+
+func (c *subtreeCounter) encode() []byte {
+	return c.table.encode()
+}
+
+// subtreeCounts reads the counts from the section data
+type subtreeCounts struct {
+	table *stateTable
+}
+
+func loadSubtreeCounts(data []byte) (*subtreeCounts, error) {
+	table, err := loadStateTable(data, "subtree counts")
+	if err != nil {
+		return nil, err
+	}
+	return &subtreeCounts{table: table}, nil
+}
+
+// get returns the number of keys reachable from the state at addr
+func (c *subtreeCounts) get(addr int) (uint64, bool) {
+	switch addr {
+	case emptyAddr:
+		return 1, true
+	case noneAddr:
+		return 0, true
+	}
+	return c.table.get(addr)
+}
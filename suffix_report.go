@@ -0,0 +1,135 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"sort"
+)
+
+// SuffixReport describes the states shared by the keys of an FST built
+// with BuilderOpts.SuffixReport, and the space saved by sharing them.  A
+// suffix shared by an unexpectedly large number of keys may reveal a
+// problem with the data, such as a constant appended by mistake.  The JSON
+// encoding is stable, see StatsSchemaVersion.
+type SuffixReport struct {
+	// SchemaVersion is the StatsSchemaVersion which produced this value
+	SchemaVersion int `json:"schema_version"`
+	// States is the number of states written
+	States int `json:"states"`
+	// SharedStates is the number of states written which were shared
+	SharedStates int `json:"shared_states"`
+	// Shares is the number of times states were shared rather than
+	// written again
+	Shares int `json:"shares"`
+	// BytesSaved is the approximate number of bytes saved by sharing states
+	BytesSaved int64 `json:"bytes_saved"`
+	// Suffixes are the most frequently shared suffixes, most shared first
+	Suffixes []SharedSuffix `json:"suffixes"`
+}
+
+// SharedSuffix describes a state shared by the keys of an FST.
+type SharedSuffix struct {
+	// Suffix is one of the key suffixes reachable from the state, that of
+	// the first key found to share it
+	Suffix []byte `json:"suffix"`
+	// Shares is the number of times the state was shared
+	Shares int `json:"shares"`
+	// Size is the size of the encoded state
+	Size int `json:"size"`
+	// BytesSaved is the number of bytes saved by sharing the state
+	BytesSaved int64 `json:"bytes_saved"`
+}
+
+// suffixReporter tracks the states shared while building, for the
+// SuffixReport
+type suffixReporter struct {
+	n int
+	// sizes are the sizes of the states written, by address
+	sizes  map[int]int
+	shared map[int]*SharedSuffix
+	rv     SuffixReport
+}
+
+func newSuffixReporter(n int) *suffixReporter {
+	rv := &suffixReporter{n: n}
+	rv.reset()
+	return rv
+}
+
+func (r *suffixReporter) reset() {
+	r.sizes = make(map[int]int)
+	r.shared = make(map[int]*SharedSuffix)
+	r.rv = SuffixReport{SchemaVersion: StatsSchemaVersion}
+}
+
+// written records the state written at addr
+func (r *suffixReporter) written(addr, size int) {
+	r.sizes[addr] = size
+	r.rv.States++
+}
+
+// reused records the state at addr shared by a key ending with suffix,
+// which is nil if not known
+func (r *suffixReporter) reused(addr int, suffix []byte) {
+	s := r.shared[addr]
+	if s == nil {
+		s = &SharedSuffix{Size: r.sizes[addr]}
+		r.shared[addr] = s
+		r.rv.SharedStates++
+	}
+	if s.Suffix == nil && suffix != nil {
+		s.Suffix = append([]byte{}, suffix...)
+	}
+	s.Shares++
+	s.BytesSaved += int64(s.Size)
+	r.rv.Shares++
+	r.rv.BytesSaved += int64(s.Size)
+}
+
+// report returns the report, with the n most shared suffixes, the longest
+// first when shared as often
+func (r *suffixReporter) report() *SuffixReport {
+	rv := r.rv
+	rv.Suffixes = make([]SharedSuffix, 0, len(r.shared))
+	for _, s := range r.shared {
+		rv.Suffixes = append(rv.Suffixes, *s)
+	}
+	sort.Slice(rv.Suffixes, func(i, j int) bool {
+		a, b := rv.Suffixes[i], rv.Suffixes[j]
+		if a.Shares != b.Shares {
+			return a.Shares > b.Shares
+		}
+		if len(a.Suffix) != len(b.Suffix) {
+			return len(a.Suffix) > len(b.Suffix)
+		}
+		return bytes.Compare(a.Suffix, b.Suffix) < 0
+	})
+	if len(rv.Suffixes) > r.n {
+		rv.Suffixes = rv.Suffixes[:r.n]
+	}
+	return &rv
+}
+
+// SuffixReport returns the report of the states shared by the keys
+// inserted since the Builder was created or last Reset, or nil if
+// BuilderOpts.SuffixReport isn't set.  It is complete once the Builder is
+// closed.
+func (b *Builder) SuffixReport() *SuffixReport {
+	if b.suffixes == nil {
+		return nil
+	}
+	return b.suffixes.report()
+}
@@ -0,0 +1,74 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSuffixReport(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithSuffixReport(2))
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		err = b.Insert([]byte(fmt.Sprintf("key%03d.tmp", i)), 0)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+
+	report := b.SuffixReport()
+	if len(report.Suffixes) != 2 {
+		t.Fatalf("expected 2 suffixes, got %+v", report)
+	}
+	top := report.Suffixes[0]
+	if string(top.Suffix) != ".tmp" || top.Shares != 99 || top.Size <= 0 ||
+		top.BytesSaved != int64(99*top.Size) {
+		t.Errorf("expected .tmp shared 99 times, got %+v", top)
+	}
+	if string(report.Suffixes[1].Suffix) != "tmp" {
+		t.Errorf("expected tmp next, got %+v", report.Suffixes[1])
+	}
+	if report.SharedStates < 4 || report.Shares < 4*99 ||
+		report.BytesSaved < top.BytesSaved ||
+		report.States+report.Shares < 100 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	err = b.Reset(&buf)
+	if err != nil {
+		t.Fatalf("error resetting builder: %v", err)
+	}
+	if report = b.SuffixReport(); report.States != 0 ||
+		len(report.Suffixes) != 0 {
+		t.Errorf("expected an empty report after reset, got %+v", report)
+	}
+
+	b, err = New(&buf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	if b.SuffixReport() != nil {
+		t.Errorf("expected no report by default")
+	}
+}
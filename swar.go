@@ -0,0 +1,58 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// The functions in this file search arrays of transition labels 8 bytes
+// at a time, treating each uint64 as 8 independent byte lanes (SWAR).
+// Searching for an exact label is already handled by bytes.IndexByte,
+// which the Go runtime implements in assembly on the major platforms.
+
+const swarLo7 = 0x7f7f7f7f7f7f7f7f
+const swarHi = 0x8080808080808080
+const swarOnes = 0x0101010101010101
+
+// swarLess returns a mask with the high bit of each byte lane set if and
+// only if that lane of x is less than the same lane of y (unsigned).
+func swarLess(x, y uint64) uint64 {
+	// with the high bit of x forced on and the high bit of y forced off
+	// the subtraction never borrows across lanes, the high bit of each lane
+	// of t is set iff the low 7 bits of x are >= the low 7 bits of y
+	t := (x | swarHi) - (y & swarLo7)
+	return ((^x & y) | (^(x ^ y) & ^t)) & swarHi
+}
+
+// indexLessThan returns the index of the first byte in data which is
+// less than b, or -1 if there is none.
+func indexLessThan(data []byte, b byte) int {
+	bs := swarOnes * uint64(b)
+	i := 0
+	for ; i+8 <= len(data); i += 8 {
+		m := swarLess(binary.LittleEndian.Uint64(data[i:]), bs)
+		if m != 0 {
+			return i + bits.TrailingZeros64(m)/8
+		}
+	}
+	for ; i < len(data); i++ {
+		if data[i] < b {
+			return i
+		}
+	}
+	return -1
+}
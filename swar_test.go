@@ -0,0 +1,129 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func naiveIndexLessThan(data []byte, b byte) int {
+	for i := range data {
+		if data[i] < b {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestIndexLessThan(t *testing.T) {
+	// every pair of byte values in every lane position
+	data := make([]byte, 9)
+	for x := 0; x < 256; x++ {
+		for y := 0; y < 256; y++ {
+			for pos := 0; pos < len(data); pos++ {
+				for i := range data {
+					data[i] = 255
+				}
+				data[pos] = byte(x)
+				want := naiveIndexLessThan(data, byte(y))
+				got := indexLessThan(data, byte(y))
+				if got != want {
+					t.Fatalf("data %v, b %d, expected %d got %d",
+						data, y, want, got)
+				}
+			}
+		}
+	}
+
+	// random arrays of varied lengths
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		data := make([]byte, r.Intn(40))
+		r.Read(data)
+		b := byte(r.Intn(256))
+		want := naiveIndexLessThan(data, b)
+		got := indexLessThan(data, b)
+		if got != want {
+			t.Fatalf("data %v, b %d, expected %d got %d", data, b, want, got)
+		}
+	}
+}
+
+func TestTransitionBefore(t *testing.T) {
+	node := &builderNode{}
+	for _, c := range []byte("acegikmoqsuwy") {
+		node.trans = append(node.trans, transition{in: c, addr: 0})
+	}
+	for _, c := range []byte("bdfhjlnprtvxz") {
+		node.trans = append(node.trans, transition{in: c + 100, addr: 0})
+	}
+
+	var buf bytes.Buffer
+	e := newEncoderV1(&buf)
+	err := e.start(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := e.encodeState(node, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.bw.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newDecoderV1(buf.Bytes()).stateAt(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for b := 0; b < 256; b++ {
+		want := -1
+		for q := s.NumTransitions() - 1; q >= 0; q-- {
+			if s.TransitionAt(q) < byte(b) {
+				want = q
+				break
+			}
+		}
+		got := s.TransitionBefore(byte(b))
+		if got != want {
+			t.Errorf("for %d expected %d, got %d", b, want, got)
+		}
+	}
+}
+
+var indexResult int
+
+func BenchmarkIndexLessThan(b *testing.B) {
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(255 - i)
+	}
+	for i := 0; i < b.N; i++ {
+		indexResult = indexLessThan(data, 56)
+	}
+}
+
+func BenchmarkIndexLessThanNaive(b *testing.B) {
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(255 - i)
+	}
+	for i := 0; i < b.N; i++ {
+		indexResult = naiveIndexLessThan(data, 56)
+	}
+}
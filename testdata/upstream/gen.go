@@ -0,0 +1,97 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build ignore
+// +build ignore
+
+// gen builds the golden FSTs of the compatibility tests, NAME.fst from each
+// NAME.txt dataset of the directory, with the default options.  It must be
+// run with github.com/couchbase/vellum resolving to the upstream library,
+// not this one, so that the FSTs are those written by upstream:
+//
+//	go run gen.go -dir .
+//
+// Each line of a dataset is a key, quoted as by strconv.Quote, and its
+// value, in key order.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/vellum"
+)
+
+var dir = flag.String("dir", ".", "directory of the datasets")
+
+func main() {
+	flag.Parse()
+	paths, err := filepath.Glob(filepath.Join(*dir, "*.txt"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range paths {
+		err = gen(path, strings.TrimSuffix(path, ".txt")+".fst")
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+}
+
+func gen(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	b, err := vellum.New(out, nil)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		sp := strings.LastIndexByte(line, ' ')
+		key, err := strconv.Unquote(line[:sp])
+		if err != nil {
+			return err
+		}
+		val, err := strconv.ParseUint(line[sp+1:], 10, 64)
+		if err != nil {
+			return err
+		}
+		err = b.Insert([]byte(key), val)
+		if err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	err = b.Close()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
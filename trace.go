@@ -0,0 +1,128 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GetTrace describes the path followed by a Get through the encoded FST.
+// The JSON encoding is stable, see StatsSchemaVersion.
+type GetTrace struct {
+	// Key is the key looked up
+	Key []byte `json:"key"`
+	// Root is the address of the root state
+	Root int `json:"root"`
+	// Steps are the transitions followed, one for each byte of the key
+	// consumed.  If the key isn't in the FST, there may be fewer steps than
+	// bytes in the key, the last byte of the key without a matching
+	// transition is Key[len(Steps)].
+	Steps []GetTraceStep `json:"steps"`
+	// Final is true if the state reached after consuming the whole key is
+	// final, with FinalOutput its final output
+	Final       bool   `json:"final"`
+	FinalOutput uint64 `json:"final_output"`
+	// Exists and Value are the result of the Get, if the values are
+	// interned (see BuilderOpts.InternValues) the outputs are indexes,
+	// only Value is resolved
+	Exists bool   `json:"exists"`
+	Value  uint64 `json:"value"`
+}
+
+// GetTraceStep describes one transition followed by a Get.
+type GetTraceStep struct {
+	// Addr is the address of the state the transition leaves
+	Addr int `json:"addr"`
+	// NumTransitions is the number of transitions leaving that state
+	NumTransitions int `json:"num_transitions"`
+	// Label is the byte consumed
+	Label byte `json:"label"`
+	// Dest is the address of the state the transition enters
+	Dest int `json:"dest"`
+	// Output is the output of the transition, and Total the sum of the
+	// outputs of the transitions followed so far
+	Output uint64 `json:"output"`
+	Total  uint64 `json:"total"`
+}
+
+// TraceGet looks up the key, as Get does, but records every state visited
+// and every transition followed.  It is intended to help debug unexpected
+// values, and to illustrate how the encoding works.  The node cache (see
+// WithNodeCache) is not used, so the trace always reflects the encoded FST.
+func (f *FST) TraceGet(key []byte) (*GetTrace, error) {
+	if err := f.enter(); err != nil {
+		return nil, err
+	}
+	defer f.exit()
+	rv := &GetTrace{
+		Key:  append([]byte(nil), key...),
+		Root: f.decoder.getRoot(),
+	}
+	state, err := f.decoder.stateAt(rv.Root, nil)
+	if err != nil {
+		return nil, err
+	}
+	var total uint64
+	for _, c := range key {
+		_, dest, output := state.TransitionFor(c)
+		if dest == noneAddr {
+			return rv, nil
+		}
+		total += output
+		rv.Steps = append(rv.Steps, GetTraceStep{
+			Addr:           state.Address(),
+			NumTransitions: state.NumTransitions(),
+			Label:          c,
+			Dest:           dest,
+			Output:         output,
+			Total:          total,
+		})
+		state, err = f.decoder.stateAt(dest, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if state.Final() {
+		rv.Final = true
+		rv.FinalOutput = state.FinalOutput()
+		rv.Exists = true
+		rv.Value, err = f.value(total + rv.FinalOutput)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// String returns a readable, multi-line, rendering of the trace.
+func (t *GetTrace) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "get %q from root %d\n", t.Key, t.Root)
+	for _, step := range t.Steps {
+		fmt.Fprintf(&sb, "  state %d (%d transitions) --%q/%d--> state %d, total %d\n",
+			step.Addr, step.NumTransitions, step.Label, step.Output, step.Dest,
+			step.Total)
+	}
+	if len(t.Steps) < len(t.Key) {
+		fmt.Fprintf(&sb, "  no transition for %q, not found\n",
+			t.Key[len(t.Steps)])
+	} else if !t.Final {
+		sb.WriteString("  state not final, not found\n")
+	} else {
+		fmt.Fprintf(&sb, "  final output %d, value %d\n", t.FinalOutput, t.Value)
+	}
+	return sb.String()
+}
@@ -0,0 +1,70 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTraceGet(t *testing.T) {
+	fst, err := Load(buildSmallSample(t), WithNodeCache(1<<20))
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	for _, key := range []string{"mon", "tues", "thurs", "tye", "mo", "tuesday",
+		"x", "", "thz"} {
+		val, exists, err := fst.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("error getting %s: %v", key, err)
+		}
+		trace, err := fst.TraceGet([]byte(key))
+		if err != nil {
+			t.Fatalf("error tracing %s: %v", key, err)
+		}
+		if trace.Exists != exists || trace.Value != val {
+			t.Errorf("%s: expected %d %t, traced %d %t", key, val, exists,
+				trace.Value, trace.Exists)
+		}
+		if trace.Root != fst.decoder.getRoot() {
+			t.Errorf("%s: expected root %d, got %d", key, fst.decoder.getRoot(),
+				trace.Root)
+		}
+		addr := trace.Root
+		var total uint64
+		for i, step := range trace.Steps {
+			if step.Addr != addr || step.Label != key[i] {
+				t.Errorf("%s: step %d leaves %d with %q, expected %d with %q",
+					key, i, step.Addr, step.Label, addr, key[i])
+			}
+			total += step.Output
+			if step.Total != total {
+				t.Errorf("%s: step %d expected total %d, got %d", key, i,
+					total, step.Total)
+			}
+			addr = step.Dest
+		}
+		if exists && trace.Value != total+trace.FinalOutput {
+			t.Errorf("%s: expected value %d to be total %d plus final output %d",
+				key, trace.Value, total, trace.FinalOutput)
+		}
+		if !exists && len(trace.Steps) == len(key) && trace.Final {
+			t.Errorf("%s: expected non final state", key)
+		}
+		if !strings.HasPrefix(trace.String(), "get ") {
+			t.Errorf("%s: unexpected rendering %s", key, trace)
+		}
+	}
+}
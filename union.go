@@ -0,0 +1,123 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// MergeOp is an arithmetic combining the values of a key found in more than
+// one of the FSTs of a Union.
+type MergeOp int
+
+const (
+	// MergeOpSum sums the values, such as term frequencies, saturating at
+	// the largest uint64 rather than overflowing
+	MergeOpSum MergeOp = iota
+	// MergeOpMin chooses the minimum value
+	MergeOpMin
+	// MergeOpMax chooses the maximum value
+	MergeOpMax
+)
+
+func (op MergeOp) String() string {
+	switch op {
+	case MergeOpSum:
+		return "sum"
+	case MergeOpMin:
+		return "min"
+	case MergeOpMax:
+		return "max"
+	}
+	return fmt.Sprintf("MergeOp(%d)", int(op))
+}
+
+// combiner returns the function combining two values with the op, or nil
+// if the op is unknown
+func (op MergeOp) combiner() func(a, b uint64) uint64 {
+	switch op {
+	case MergeOpSum:
+		return func(a, b uint64) uint64 {
+			if a > math.MaxUint64-b {
+				return math.MaxUint64
+			}
+			return a + b
+		}
+	case MergeOpMin:
+		return func(a, b uint64) uint64 {
+			if b < a {
+				return b
+			}
+			return a
+		}
+	case MergeOpMax:
+		return func(a, b uint64) uint64 {
+			if b > a {
+				return b
+			}
+			return a
+		}
+	}
+	return nil
+}
+
+// MergeFunc returns a MergeFunc applying the op, for use with Merge and
+// NewMergeIterator, or nil if the op is unknown.
+func (op MergeOp) MergeFunc() MergeFunc {
+	combine := op.combiner()
+	if combine == nil {
+		return nil
+	}
+	return func(vals []uint64) uint64 {
+		rv := vals[0]
+		for _, v := range vals[1:] {
+			rv = combine(rv, v)
+		}
+		return rv
+	}
+}
+
+// Union builds an FST of the keys of all the FSTs to the Writer, the values
+// of the keys found in more than one of them combined with the op, and
+// reports MergeStats describing the outcome.  It is equivalent to a Merge of
+// their Iterators with op.MergeFunc(), but the values are combined without
+// a callback, and runs of keys found in only one of the FSTs are copied
+// with their shared suffixes, see Builder.CopyFrom.
+func Union(w io.Writer, fsts []*FST, op MergeOp, opts ...BuilderOption) (*MergeStats, error) {
+	combine := op.combiner()
+	if combine == nil {
+		return nil, fmt.Errorf("unknown merge op %v", op)
+	}
+	var itrs []Iterator
+	for _, fst := range fsts {
+		itr, err := fst.Iterator(nil, nil)
+		if errors.Is(err, ErrIteratorDone) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		itrs = append(itrs, itr)
+	}
+	stats, err := merge(w, applyBuilderOptions(opts), itrs, op.MergeFunc(),
+		combine, nil)
+	if stats != nil {
+		stats.Inputs = len(fsts)
+	}
+	return stats, err
+}
@@ -0,0 +1,71 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestUnion(t *testing.T) {
+	a := buildKVs(t, KV{"a", 1}, KV{"b", 5}, KV{"c", math.MaxUint64 - 1})
+	b := buildKVs(t, KV{"b", 2}, KV{"c", 3}, KV{"d", 4})
+	c := buildKVs(t, KV{"b", 7})
+	empty := buildKVs(t)
+
+	tests := []struct {
+		op   MergeOp
+		want []KV
+	}{
+		{MergeOpSum, []KV{{"a", 1}, {"b", 14}, {"c", math.MaxUint64}, {"d", 4}}},
+		{MergeOpMin, []KV{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 4}}},
+		{MergeOpMax, []KV{{"a", 1}, {"b", 7}, {"c", math.MaxUint64 - 1}, {"d", 4}}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		stats, err := Union(&buf, []*FST{a, empty, b, c}, test.op, WithVersion(2))
+		if err != nil {
+			t.Fatalf("%v: error building union: %v", test.op, err)
+		}
+		if stats.Inputs != 4 || stats.Keys != 4 || stats.MergedKeys != 2 {
+			t.Errorf("%v: unexpected stats %+v", test.op, stats)
+		}
+		fst, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%v: error loading: %v", test.op, err)
+		}
+		var got []KV
+		itr, err := fst.Iterator(nil, nil)
+		for err == nil {
+			k, v := itr.Current()
+			got = append(got, KV{string(k), v})
+			err = itr.Next()
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v, got %v", test.op, test.want, got)
+		}
+		if v := test.op.MergeFunc()([]uint64{5, 2, 7}); v != test.want[1].Val {
+			t.Errorf("%v: expected MergeFunc to give %d, got %d", test.op,
+				test.want[1].Val, v)
+		}
+	}
+
+	_, err := Union(&bytes.Buffer{}, []*FST{a}, MergeOp(7))
+	if err == nil {
+		t.Errorf("expected unknown op to be rejected")
+	}
+}
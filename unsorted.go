@@ -0,0 +1,359 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// DefaultUnsortedMemoryBudget is the memory an UnsortedBuilder buffers
+// inserts in, if UnsortedOpts.MemoryBudget is zero
+const DefaultUnsortedMemoryBudget = 64 << 20
+
+// unsortedEntrySize is the approximate memory used by a buffered insert,
+// besides its key
+const unsortedEntrySize = 32
+
+var errUnsortedBuilderClosed = errors.New("unsorted builder closed")
+
+// UnsortedOpts customizes an UnsortedBuilder.
+type UnsortedOpts struct {
+	// MemoryBudget is the approximate memory (in bytes) used to buffer
+	// inserts, beyond which they are sorted and spilled to a temporary
+	// file, if zero DefaultUnsortedMemoryBudget
+	MemoryBudget int
+
+	// TempDir is the directory of the temporary files, if empty the
+	// default directory for temporary files is used
+	TempDir string
+
+	// Merge chooses the value of a key inserted more than once, if nil the
+	// value inserted last is kept.  It is called with the values in the
+	// order they were inserted, but possibly on a few of them at a time,
+	// its results then merged again with the others, so it must be
+	// associative, as sums, minimums and maximums are.
+	Merge MergeFunc
+}
+
+// UnsortedBuilder builds an FST from keys inserted in any order.  Inserts
+// are buffered in memory, sorted and spilled to temporary files as sorted
+// runs when they exceed the memory budget, and the runs are merged into
+// the FST on Close.
+type UnsortedBuilder struct {
+	w     io.Writer
+	opts  *BuilderOpts
+	uopts UnsortedOpts
+
+	arena   []byte
+	entries []unsortedEntry
+	runs    []*os.File
+
+	closed bool
+}
+
+// unsortedEntry is a buffered insert, its key at arena[start:end]
+type unsortedEntry struct {
+	start, end int
+	val        uint64
+}
+
+// NewUnsortedBuilder returns an UnsortedBuilder which writes the FST to w on
+// Close, built with the provided BuilderOptions.
+func NewUnsortedBuilder(w io.Writer, uopts *UnsortedOpts,
+	opts ...BuilderOption) (*UnsortedBuilder, error) {
+	rv := &UnsortedBuilder{
+		w:    w,
+		opts: applyBuilderOptions(opts),
+		// the keys are never nil, nil marks the end for MergeIterator
+		arena: make([]byte, 0, 4096),
+	}
+	if uopts != nil {
+		rv.uopts = *uopts
+	}
+	if rv.uopts.MemoryBudget <= 0 {
+		rv.uopts.MemoryBudget = DefaultUnsortedMemoryBudget
+	}
+	if rv.uopts.Merge == nil {
+		rv.uopts.Merge = mergeNewest
+	}
+	return rv, nil
+}
+
+// Insert buffers the key and value, in any order.
+func (b *UnsortedBuilder) Insert(key []byte, val uint64) error {
+	if b.closed {
+		return errUnsortedBuilderClosed
+	}
+	start := len(b.arena)
+	b.arena = append(b.arena, key...)
+	b.entries = append(b.entries, unsortedEntry{
+		start: start,
+		end:   len(b.arena),
+		val:   val,
+	})
+	if len(b.arena)+len(b.entries)*unsortedEntrySize > b.uopts.MemoryBudget {
+		return b.spill()
+	}
+	return nil
+}
+
+// Runs returns the number of sorted runs spilled to temporary files so far.
+func (b *UnsortedBuilder) Runs() int {
+	return len(b.runs)
+}
+
+// Close merges the inserts into the FST, writes it, and removes the
+// temporary files.
+func (b *UnsortedBuilder) Close() error {
+	if b.closed {
+		return errUnsortedBuilderClosed
+	}
+	b.closed = true
+	defer b.removeRuns()
+
+	var itrs []Iterator
+	if len(b.runs) > 0 {
+		err := b.spill()
+		if err != nil {
+			return err
+		}
+		for _, run := range b.runs {
+			itr, err := newRunIterator(run)
+			if err != nil && err != ErrIteratorDone {
+				return err
+			}
+			if err == nil {
+				itrs = append(itrs, itr)
+			}
+		}
+	} else if len(b.entries) > 0 {
+		// everything fit in memory, nothing was spilled
+		b.sort()
+		itrs = append(itrs, &memRunIterator{b: b})
+	}
+	if len(itrs) == 0 {
+		builder, err := newBuilder(b.w, b.opts)
+		if err != nil {
+			return err
+		}
+		return builder.Close()
+	}
+	_, err := merge(b.w, b.opts, itrs, b.uopts.Merge, nil, nil)
+	return err
+}
+
+// sort sorts the buffered entries by key, and merges the values of the
+// duplicate keys
+func (b *UnsortedBuilder) sort() {
+	sort.SliceStable(b.entries, func(i, j int) bool {
+		return bytes.Compare(b.key(i), b.key(j)) < 0
+	})
+	var vals []uint64
+	n := 0
+	for i := 0; i < len(b.entries); {
+		j := i + 1
+		for j < len(b.entries) && bytes.Equal(b.key(i), b.key(j)) {
+			j++
+		}
+		if j > i+1 {
+			vals = vals[:0]
+			for _, e := range b.entries[i:j] {
+				vals = append(vals, e.val)
+			}
+			b.entries[i].val = b.uopts.Merge(vals)
+		}
+		b.entries[n] = b.entries[i]
+		n++
+		i = j
+	}
+	b.entries = b.entries[:n]
+}
+
+func (b *UnsortedBuilder) key(i int) []byte {
+	return b.arena[b.entries[i].start:b.entries[i].end]
+}
+
+// spill sorts the buffered entries, and writes them to a new run
+func (b *UnsortedBuilder) spill() error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+	b.sort()
+	f, err := ioutil.TempFile(b.uopts.TempDir, "vellum-unsorted-")
+	if err != nil {
+		return err
+	}
+	b.runs = append(b.runs, f)
+	w := bufio.NewWriter(f)
+	var buf [2 * binary.MaxVarintLen64]byte
+	for i, e := range b.entries {
+		n := binary.PutUvarint(buf[:], uint64(e.end-e.start))
+		_, err = w.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b.key(i))
+		if err != nil {
+			return err
+		}
+		n = binary.PutUvarint(buf[:], e.val)
+		_, err = w.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	b.arena = b.arena[:0]
+	b.entries = b.entries[:0]
+	return nil
+}
+
+func (b *UnsortedBuilder) removeRuns() {
+	for _, run := range b.runs {
+		_ = run.Close()
+		_ = os.Remove(run.Name())
+	}
+	b.runs = nil
+}
+
+// memRunIterator iterates over the sorted entries of an UnsortedBuilder
+// which were never spilled.  It only supports what merge needs.
+type memRunIterator struct {
+	b *UnsortedBuilder
+	i int
+}
+
+func (m *memRunIterator) Current() ([]byte, uint64) {
+	if m.i >= len(m.b.entries) {
+		return nil, 0
+	}
+	return m.b.key(m.i), m.b.entries[m.i].val
+}
+
+func (m *memRunIterator) Next() error {
+	if m.i < len(m.b.entries) {
+		m.i++
+	}
+	if m.i >= len(m.b.entries) {
+		return ErrIteratorDone
+	}
+	return nil
+}
+
+func (m *memRunIterator) Seek(key []byte) error {
+	m.i = sort.Search(len(m.b.entries), func(i int) bool {
+		return bytes.Compare(m.b.key(i), key) >= 0
+	})
+	if m.i >= len(m.b.entries) {
+		return ErrIteratorDone
+	}
+	return nil
+}
+
+func (m *memRunIterator) Reset(*FST, []byte, []byte, Automaton) error {
+	return fmt.Errorf("sorted run iterator can't be reset")
+}
+
+func (m *memRunIterator) Close() error {
+	return nil
+}
+
+// runIterator reads a run spilled to a temporary file, each entry encoded
+// as the uvarint key length, the key, and the uvarint value.  It only
+// supports what merge needs.
+type runIterator struct {
+	r   *bufio.Reader
+	key []byte
+	val uint64
+	err error
+}
+
+func newRunIterator(f *os.File) (*runIterator, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	rv := &runIterator{
+		r: bufio.NewReader(f),
+	}
+	err = rv.Next()
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+func (r *runIterator) Current() ([]byte, uint64) {
+	if r.err != nil {
+		return nil, 0
+	}
+	return r.key, r.val
+}
+
+func (r *runIterator) Next() error {
+	if r.err != nil {
+		return r.err
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		r.err = ErrIteratorDone
+		return r.err
+	}
+	if err == nil {
+		// the key is never nil, nil marks the end for MergeIterator
+		if r.key == nil || cap(r.key) < int(n) {
+			r.key = make([]byte, n)
+		}
+		r.key = r.key[:n]
+		_, err = io.ReadFull(r.r, r.key)
+	}
+	if err == nil {
+		r.val, err = binary.ReadUvarint(r.r)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = fmt.Errorf("error reading sorted run: %v", err)
+	}
+	return r.err
+}
+
+func (r *runIterator) Seek(key []byte) error {
+	var err error
+	for err == nil && bytes.Compare(r.key, key) < 0 {
+		err = r.Next()
+	}
+	return err
+}
+
+func (r *runIterator) Reset(*FST, []byte, []byte, Automaton) error {
+	return fmt.Errorf("sorted run iterator can't be reset")
+}
+
+func (r *runIterator) Close() error {
+	return nil
+}
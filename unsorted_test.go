@@ -0,0 +1,151 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestUnsortedBuilder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// every word is inserted twice, with its index and then 1, in shuffled
+	// order, plus the empty key
+	type kv struct {
+		key string
+		val uint64
+	}
+	var inserts []kv
+	for i, word := range thousandTestWords {
+		inserts = append(inserts, kv{word, uint64(i)})
+	}
+	rng := rand.New(rand.NewSource(1))
+	rng.Shuffle(len(inserts), func(i, j int) {
+		inserts[i], inserts[j] = inserts[j], inserts[i]
+	})
+	for _, word := range thousandTestWords {
+		inserts = append(inserts, kv{word, 1})
+	}
+	inserts = append(inserts, kv{"", 7})
+
+	tests := []struct {
+		name   string
+		budget int
+		merge  MergeFunc
+		runs   bool
+		want   func(i int) uint64
+	}{
+		{
+			name: "in memory",
+			want: func(int) uint64 { return 1 },
+		},
+		{
+			name:   "spilled",
+			budget: 4096,
+			runs:   true,
+			want:   func(int) uint64 { return 1 },
+		},
+		{
+			name:   "spilled sum",
+			budget: 4096,
+			merge:  MergeOpSum.MergeFunc(),
+			runs:   true,
+			want:   func(i int) uint64 { return uint64(i) + 1 },
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		b, err := NewUnsortedBuilder(&buf, &UnsortedOpts{
+			MemoryBudget: test.budget,
+			TempDir:      dir,
+			Merge:        test.merge,
+		})
+		if err != nil {
+			t.Fatalf("%s: error creating builder: %v", test.name, err)
+		}
+		for _, ins := range inserts {
+			err = b.Insert([]byte(ins.key), ins.val)
+			if err != nil {
+				t.Fatalf("%s: error inserting: %v", test.name, err)
+			}
+		}
+		if (b.Runs() > 1) != test.runs {
+			t.Errorf("%s: unexpected %d runs", test.name, b.Runs())
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("%s: error closing: %v", test.name, err)
+		}
+		if err = b.Insert([]byte("late"), 0); err == nil {
+			t.Errorf("%s: expected an error inserting after close", test.name)
+		}
+
+		fst, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: error loading: %v", test.name, err)
+		}
+		if fst.Len() != len(thousandTestWords)+1 {
+			t.Errorf("%s: expected %d keys, got %d", test.name,
+				len(thousandTestWords)+1, fst.Len())
+		}
+		for i, word := range thousandTestWords {
+			val, exists, err := fst.Get([]byte(word))
+			if err != nil || !exists || val != test.want(i) {
+				t.Errorf("%s: expected %q %d, got %d %t %v", test.name, word,
+					test.want(i), val, exists, err)
+			}
+		}
+		if val, exists, _ := fst.Get(nil); !exists || val != 7 {
+			t.Errorf("%s: expected the empty key, got %d %t", test.name, val,
+				exists)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected the runs to be removed, found %d files", len(files))
+	}
+
+	var buf bytes.Buffer
+	b, err := NewUnsortedBuilder(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing empty builder: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading empty fst: %v", err)
+	}
+	if _, err = fst.Iterator(nil, nil); !errors.Is(err, ErrIteratorDone) {
+		t.Errorf("expected an empty fst, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "math"
+
+// Value bounds record, for each state, the minimum and the maximum of the
+// outputs accumulated from it to the keys reachable from it, in two state
+// tables.  Adding them to the outputs accumulated up to the state bounds
+// the values of all the keys of its subtree, allowing searches for a range
+// of values to skip the subtrees outside it.
+
+type valueBounder struct {
+	table stateTableBuilder
+	max   bool
+}
+
+func (v *valueBounder) section() int {
+	if v.max {
+		return sectionValueMaxes
+	}
+	return sectionValueMins
+}
+
+func (v *valueBounder) reset() {
+	v.table.reset()
+}
+
+func (v *valueBounder) lookup(addr int) uint64 {
+	if addr == emptyAddr {
+		return 0
+	}
+	return v.table.lookup(addr)
+}
+
+// add records the bound for a newly encoded state
+func (v *valueBounder) add(addr int, node *builderNode) {
+	var rv uint64
+	if !v.max {
+		rv = math.MaxUint64
+	}
+	bound := func(out uint64) {
+		if (v.max && out > rv) || (!v.max && out < rv) {
+			rv = out
+		}
+	}
+	if node.final {
+		bound(node.finalOutput)
+	}
+	for _, t := range node.trans {
+		bound(t.out + v.lookup(t.addr))
+	}
+	v.table.add(addr, rv)
+}
+
+func (v *valueBounder) encode() []byte {
+	return v.table.encode()
+}
+
+// valueBounds reads the bounds from the sections data
+type valueBounds struct {
+	mins  *stateTable
+	maxes *stateTable
+}
+
+func loadValueBounds(mins, maxes []byte) (*valueBounds, error) {
+	if mins == nil || maxes == nil {
+		return nil, corruptf(0, "value bounds missing their mins or maxes")
+	}
+	var rv valueBounds
+	var err error
+	rv.mins, err = loadStateTable(mins, "value mins")
+	if err != nil {
+		return nil, err
+	}
+	rv.maxes, err = loadStateTable(maxes, "value maxes")
+	if err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// get returns the minimum and the maximum of the outputs accumulated from
+// the state at addr to the keys reachable from it
+func (v *valueBounds) get(addr int) (uint64, uint64, bool) {
+	if addr == emptyAddr {
+		return 0, 0, true
+	}
+	min, ok := v.mins.get(addr)
+	if !ok {
+		return 0, 0, false
+	}
+	max, ok := v.maxes.get(addr)
+	return min, max, ok
+}
+
+// valueRange restricts the values of the keys returned by an FSTIterator
+type valueRange struct {
+	min, max uint64
+}
+
+// contains returns true if the value is in the range
+func (r *valueRange) contains(val uint64) bool {
+	return val >= r.min && val <= r.max
+}
+
+// SearchValues returns a new Iterator over the key/value pairs between the
+// provided startKeyInclusive and endKeyExclusive that satisfy the provided
+// automaton, and have minValue <= value <= maxValue.  If the FST was built
+// with value bounds (see BuilderOpts.ValueBounds), the subtrees whose
+// values are all outside the range are skipped, otherwise every key
+// matching the automaton is visited.
+func (f *FST) SearchValues(aut Automaton, startKeyInclusive,
+	endKeyExclusive []byte, minValue, maxValue uint64) (*FSTIterator, error) {
+	if minValue > maxValue {
+		return nil, ErrIteratorDone
+	}
+	startKeyInclusive, endKeyExclusive = automatonBounds(aut,
+		startKeyInclusive, endKeyExclusive)
+	err := emptySearch(f, startKeyInclusive, endKeyExclusive, aut)
+	if err != nil {
+		return nil, err
+	}
+	rv := &FSTIterator{}
+	err = rv.reset(f, startKeyInclusive, endKeyExclusive, aut,
+		&valueRange{min: minValue, max: maxValue})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
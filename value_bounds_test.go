@@ -0,0 +1,125 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/vellum/regexp"
+)
+
+func TestSearchValues(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vals := make([]uint64, len(thousandTestWords))
+	for i := range vals {
+		vals[i] = uint64(rng.Intn(10000))
+	}
+	build := func(opts ...BuilderOption) *FST {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, thousandTestWords, vals)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		fst, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		return fst
+	}
+	plain := build()
+	bounded := build(WithValueBounds())
+	interned := build(WithValueBounds(),
+		builderOptionFunc(func(o *BuilderOpts) { o.InternValues = true }))
+	if plain.bounds != nil || bounded.bounds == nil || interned.bounds != nil {
+		t.Fatalf("expected value bounds only without interned values")
+	}
+
+	min, max, ok := bounded.bounds.get(bounded.decoder.getRoot())
+	wantMin, wantMax := vals[0], vals[0]
+	for _, v := range vals {
+		if v < wantMin {
+			wantMin = v
+		}
+		if v > wantMax {
+			wantMax = v
+		}
+	}
+	if !ok || min != wantMin || max != wantMax {
+		t.Errorf("expected root bounds [%d, %d], got [%d, %d] %t", wantMin,
+			wantMax, min, max, ok)
+	}
+
+	r, err := regexp.New(`[a-m].*`)
+	if err != nil {
+		t.Fatalf("error compiling regexp: %v", err)
+	}
+	tests := []struct {
+		name       string
+		aut        Automaton
+		start, end []byte
+		min, max   uint64
+	}{
+		{name: "all", max: 1 << 63},
+		{name: "range", min: 1000, max: 1999},
+		{name: "single", min: vals[10], max: vals[10]},
+		{name: "none", min: 20000, max: 30000},
+		{name: "keys and values", aut: r, start: []byte("c"),
+			end: []byte("t"), min: 9000, max: 9999},
+	}
+	for _, test := range tests {
+		var want []string
+		itr, err := plain.Search(test.aut, test.start, test.end)
+		for err == nil {
+			key, val := itr.Current()
+			if val >= test.min && val <= test.max {
+				want = append(want, string(key))
+			}
+			err = itr.Next()
+		}
+		if !errors.Is(err, ErrIteratorDone) {
+			t.Fatalf("%s: error searching: %v", test.name, err)
+		}
+
+		for _, fst := range []*FST{plain, bounded, interned} {
+			var got []string
+			itr, err := fst.SearchValues(test.aut, test.start, test.end,
+				test.min, test.max)
+			for err == nil {
+				key, _ := itr.Current()
+				got = append(got, string(key))
+				err = itr.Next()
+			}
+			if !errors.Is(err, ErrIteratorDone) {
+				t.Fatalf("%s: error searching values: %v", test.name, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: expected %d keys, got %d", test.name, len(want),
+					len(got))
+			}
+		}
+	}
+}
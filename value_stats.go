@@ -0,0 +1,144 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+// ValueStats describes the distribution of the values of an FST, to help
+// verify them, for instance that offsets into a file increase with the
+// keys and stay under its size.  The JSON encoding is stable, see
+// StatsSchemaVersion.
+type ValueStats struct {
+	// SchemaVersion is the StatsSchemaVersion which produced this value
+	SchemaVersion int `json:"schema_version"`
+	// Keys is the number of keys in the FST
+	Keys int `json:"keys"`
+	// Min and Max are the smallest and largest values, zero if there are
+	// no keys
+	Min uint64 `json:"min"`
+	Max uint64 `json:"max"`
+	// Mean is the mean of the values
+	Mean float64 `json:"mean"`
+	// Monotone is true if the values never decrease in key order
+	Monotone bool `json:"monotone"`
+	// P50, P90 and P99 are the percentiles returned by Percentile
+	P50 uint64 `json:"p50"`
+	P90 uint64 `json:"p90"`
+	P99 uint64 `json:"p99"`
+	// Buckets count the values in ranges of the same width, from Min to
+	// Max
+	Buckets []ValueBucket `json:"buckets"`
+}
+
+// ValueBucket is the number of values from Min to Max, inclusive.
+type ValueBucket struct {
+	Min   uint64 `json:"min"`
+	Max   uint64 `json:"max"`
+	Count int    `json:"count"`
+}
+
+// ValueStats computes the distribution of the values of the FST, counting
+// them in up to buckets ranges of the same width.  The keys are iterated
+// twice, once for the range of the values and once to count them, so the
+// memory used only depends on the number of buckets.
+func (f *FST) ValueStats(buckets int) (*ValueStats, error) {
+	if buckets < 1 {
+		buckets = 1
+	}
+	rv := &ValueStats{
+		SchemaVersion: StatsSchemaVersion,
+		Monotone:      true,
+	}
+	var sum float64
+	err := f.eachValue(func(val uint64) {
+		if rv.Keys == 0 || val < rv.Min {
+			rv.Min = val
+		}
+		if rv.Keys > 0 && val < rv.Max {
+			rv.Monotone = false
+		}
+		if val > rv.Max {
+			rv.Max = val
+		}
+		sum += float64(val)
+		rv.Keys++
+	})
+	if err != nil || rv.Keys == 0 {
+		return rv, err
+	}
+	rv.Mean = sum / float64(rv.Keys)
+
+	span := rv.Max - rv.Min
+	if span < uint64(buckets-1) {
+		buckets = int(span) + 1
+	}
+	// (Max-Min)/width < buckets, without overflowing
+	width := span/uint64(buckets) + 1
+	buckets = int(span/width) + 1
+	rv.Buckets = make([]ValueBucket, buckets)
+	for i := range rv.Buckets {
+		rv.Buckets[i].Min = rv.Min + uint64(i)*width
+		rv.Buckets[i].Max = rv.Buckets[i].Min + (width - 1)
+		if rv.Buckets[i].Max > rv.Max || rv.Buckets[i].Max < rv.Buckets[i].Min {
+			rv.Buckets[i].Max = rv.Max
+		}
+	}
+	err = f.eachValue(func(val uint64) {
+		rv.Buckets[(val-rv.Min)/width].Count++
+	})
+	if err != nil {
+		return nil, err
+	}
+	rv.P50 = rv.Percentile(50)
+	rv.P90 = rv.Percentile(90)
+	rv.P99 = rv.Percentile(99)
+	return rv, nil
+}
+
+// Percentile returns an upper bound of the value below or equal to which
+// are p percent of the values, the Max of the bucket holding it.  It is
+// exact if the buckets each hold a single value.
+func (s *ValueStats) Percentile(p float64) uint64 {
+	if s.Keys == 0 {
+		return 0
+	}
+	rank := int(p / 100 * float64(s.Keys))
+	if float64(rank) < p/100*float64(s.Keys) {
+		rank++
+	}
+	if rank < 1 {
+		rank = 1
+	}
+	var n int
+	for _, b := range s.Buckets {
+		n += b.Count
+		if n >= rank {
+			return b.Max
+		}
+	}
+	return s.Max
+}
+
+// eachValue calls cb with the value of every key, in key order
+func (f *FST) eachValue(cb func(uint64)) error {
+	itr, err := f.Iterator(nil, nil)
+	for err == nil {
+		_, val := itr.Current()
+		cb(val)
+		err = itr.Next()
+	}
+	if err != ErrIteratorDone {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// Value sums record, for each state, the sum of the outputs accumulated
+// from it to the keys reachable from it, in a state table.  With the
+// subtree counts, the sum of the values of the keys of a subtree is the
+// outputs accumulated up to its state times its count, plus its sum.
+// Sums beyond the range of a uint64 are saturated.
+
+// ErrNoValueSums is returned by WeightedSample if the FST wasn't built
+// with value sums.
+var ErrNoValueSums = errors.New("fst built without value sums")
+
+// ErrValueSumsOverflow is returned by WeightedSample if the sum of the
+// values of the FST doesn't fit in a uint64.
+var ErrValueSumsOverflow = errors.New("sum of the values overflows")
+
+type valueSummer struct {
+	table  stateTableBuilder
+	counts *subtreeCounter
+}
+
+func (v *valueSummer) section() int {
+	return sectionValueSums
+}
+
+func (v *valueSummer) reset() {
+	v.table.reset()
+}
+
+func (v *valueSummer) lookup(addr int) uint64 {
+	if addr == emptyAddr {
+		return 0
+	}
+	return v.table.lookup(addr)
+}
+
+// add records the sum for a newly encoded state, after the subtree counter
+// has recorded its count
+func (v *valueSummer) add(addr int, node *builderNode) {
+	var rv uint64
+	if node.final {
+		rv = node.finalOutput
+	}
+	for _, t := range node.trans {
+		hi, lo := bits.Mul64(t.out, v.counts.lookup(t.addr))
+		rv = saturatingAdd(rv, lo, hi)
+		rv = saturatingAdd(rv, v.lookup(t.addr), 0)
+	}
+	v.table.add(addr, rv)
+}
+
+// saturatingAdd returns a+b, or math.MaxUint64 if it overflows or carry is
+// set, from a multiplication which overflowed
+func saturatingAdd(a, b, carry uint64) uint64 {
+	sum, c := bits.Add64(a, b, 0)
+	if c != 0 || carry != 0 {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+func (v *valueSummer) encode() []byte {
+	return v.table.encode()
+}
+
+// WeightedSample returns a key of the FST chosen at random, with a
+// probability proportional to its value, and its value.  It returns false
+// if the FST is empty, or all of its values are zero.  Choosing a key
+// visits only the states along it, but requires the FST to have been
+// built with value sums (see BuilderOpts.ValueSums), otherwise
+// ErrNoValueSums is returned.
+func (f *FST) WeightedSample(rng *rand.Rand) ([]byte, uint64, bool, error) {
+	if err := f.enter(); err != nil {
+		return nil, 0, false, err
+	}
+	defer f.exit()
+	if f.sums == nil {
+		return nil, 0, false, ErrNoValueSums
+	}
+	root := f.decoder.getRoot()
+	weight, err := f.subtreeWeight(root, 0)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if weight == 0 {
+		return nil, 0, false, nil
+	}
+	if weight == math.MaxUint64 {
+		return nil, 0, false, ErrValueSumsOverflow
+	}
+	// every weight within the root's is a part of it, so doesn't overflow
+	r := uint64n(rng, weight)
+
+	var key []byte
+	var total uint64
+	curr, err := f.decoder.stateAt(root, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	for {
+		if curr.Final() {
+			val := total + curr.FinalOutput()
+			if r < val {
+				return key, val, true, nil
+			}
+			r -= val
+		}
+		next := noneAddr
+		for j := 0; j < curr.NumTransitions(); j++ {
+			t := curr.TransitionAt(j)
+			_, addr, out := curr.TransitionFor(t)
+			w, err := f.subtreeWeight(addr, total+out)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			if r < w {
+				key = append(key, t)
+				total += out
+				next = addr
+				break
+			}
+			r -= w
+		}
+		if next == noneAddr {
+			return nil, 0, false, corruptf(curr.Address(),
+				"value sums exceed the values of the subtree")
+		}
+		curr, err = f.decoder.stateAt(next, curr)
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+}
+
+// subtreeWeight returns the sum of the values of the keys reachable from
+// the state at addr, having accumulated the output total up to it
+func (f *FST) subtreeWeight(addr int, total uint64) (uint64, error) {
+	count, ok := f.counts.get(addr)
+	if !ok {
+		return 0, corruptf(addr, "missing subtree count")
+	}
+	var sum uint64
+	if addr != emptyAddr && addr != noneAddr {
+		sum, ok = f.sums.get(addr)
+		if !ok {
+			return 0, corruptf(addr, "missing value sum")
+		}
+	}
+	hi, lo := bits.Mul64(total, count)
+	return saturatingAdd(sum, lo, hi), nil
+}
+
+// uint64n returns a uniformly distributed number in [0, n), rejecting the
+// numbers beyond the largest multiple of n which would bias the result
+func uint64n(rng *rand.Rand, n uint64) uint64 {
+	// 2^64 % n
+	threshold := -n % n
+	for {
+		v := rng.Uint64()
+		if v >= threshold {
+			return v % n
+		}
+	}
+}
@@ -0,0 +1,121 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func buildKVsWithSums(t *testing.T, kvs ...KV) *FST {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithValueSums())
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertKVs(kvs...)(b)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	return fst
+}
+
+func TestValueSums(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var kvs []KV
+	var sum uint64
+	for _, word := range thousandTestWords {
+		v := uint64(rng.Intn(1 << 20))
+		kvs = append(kvs, KV{word, v})
+		sum += v
+	}
+	fst := buildKVsWithSums(t, kvs...)
+	if fst.counts == nil || fst.sums == nil {
+		t.Fatalf("expected subtree counts and value sums")
+	}
+	got, err := fst.subtreeWeight(fst.decoder.getRoot(), 0)
+	if err != nil || got != sum {
+		t.Errorf("expected sum %d, got %d %v", sum, got, err)
+	}
+	key, val, ok, err := fst.WeightedSample(rng)
+	if err != nil || !ok {
+		t.Fatalf("error sampling: %t %v", ok, err)
+	}
+	if v, exists, _ := fst.Get(key); !exists || v != val {
+		t.Errorf("expected sampled %q to have value %d, got %d %t", key, val,
+			v, exists)
+	}
+}
+
+func TestWeightedSample(t *testing.T) {
+	fst := buildKVsWithSums(t, KV{"a", 1}, KV{"b", 0}, KV{"c", 3},
+		KV{"cd", 6})
+	rng := rand.New(rand.NewSource(42))
+	const n = 100000
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		key, val, ok, err := fst.WeightedSample(rng)
+		if err != nil || !ok {
+			t.Fatalf("error sampling: %t %v", ok, err)
+		}
+		if v, _, _ := fst.Get(key); v != val {
+			t.Fatalf("expected sampled %q to have value %d, got %d", key, v,
+				val)
+		}
+		counts[string(key)]++
+	}
+	for key, weight := range map[string]int{"a": 1, "b": 0, "c": 3, "cd": 6} {
+		want := n * weight / 10
+		if counts[key] < want-n/100 || counts[key] > want+n/100 {
+			t.Errorf("expected %q sampled about %d times, got %d", key, want,
+				counts[key])
+		}
+	}
+}
+
+func TestWeightedSampleNone(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, fst := range []*FST{
+		buildKVsWithSums(t),
+		buildKVsWithSums(t, KV{"a", 0}, KV{"b", 0}),
+	} {
+		_, _, ok, err := fst.WeightedSample(rng)
+		if ok || err != nil {
+			t.Errorf("expected no sample, got %t %v", ok, err)
+		}
+	}
+
+	_, _, _, err := buildKVs(t, KV{"a", 1}).WeightedSample(rng)
+	if err != ErrNoValueSums {
+		t.Errorf("expected ErrNoValueSums, got %v", err)
+	}
+
+	fst := buildKVsWithSums(t, KV{"a", math.MaxUint64 / 2},
+		KV{"b", math.MaxUint64 / 2}, KV{"c", math.MaxUint64 / 2})
+	_, _, _, err = fst.WeightedSample(rng)
+	if err != ErrValueSumsOverflow {
+		t.Errorf("expected ErrValueSumsOverflow, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "errors"
+
+// ErrInternedValues is returned by operations which rely on the outputs of
+// the FST being ordered as the values are, which isn't the case when the
+// values are interned, see BuilderOpts.InternValues.
+var ErrInternedValues = errors.New("operation not supported with interned values")
+
+// Interned values are stored once each in a value table, in the order they
+// were first inserted, and the outputs of the FST are indexes into it.
+// When many keys share a few large values, the small indexes take fewer
+// bytes to encode in the states than the values would.  The value table
+// is an optional section, with every value packed in the same size:
+//
+//	1 byte value size
+//	for each value: value
+//
+// The typeInternedValues bit is set in the header type, as the outputs
+// can't be used as values without it.
+
+// valueInterner assigns indexes to the values while building
+type valueInterner struct {
+	indexes map[uint64]uint64
+	values  []uint64
+}
+
+func newValueInterner() *valueInterner {
+	return &valueInterner{
+		indexes: make(map[uint64]uint64),
+	}
+}
+
+func (v *valueInterner) reset() {
+	v.indexes = make(map[uint64]uint64)
+	v.values = v.values[:0]
+}
+
+// intern returns the index of the value
+func (v *valueInterner) intern(val uint64) uint64 {
+	if i, ok := v.indexes[val]; ok {
+		return i
+	}
+	i := uint64(len(v.values))
+	v.indexes[val] = i
+	v.values = append(v.values, val)
+	return i
+}
+
+func (v *valueInterner) encode() []byte {
+	var maxVal uint64
+	for _, val := range v.values {
+		if val > maxVal {
+			maxVal = val
+		}
+	}
+	valSize := packedSize(maxVal)
+	rv := make([]byte, 1+len(v.values)*valSize)
+	rv[0] = byte(valSize)
+	for i, val := range v.values {
+		putPackedUint(rv[1+i*valSize:1+(i+1)*valSize], val)
+	}
+	return rv
+}
+
+// valueTable reads the values from the section data
+type valueTable struct {
+	data    []byte
+	valSize int
+	n       int
+}
+
+func loadValueTable(data []byte) (*valueTable, error) {
+	if len(data) < 1 || data[0] < 1 || data[0] > 8 {
+		return nil, corruptf(0, "invalid value table section")
+	}
+	rv := &valueTable{
+		data:    data[1:],
+		valSize: int(data[0]),
+	}
+	if len(rv.data)%rv.valSize != 0 {
+		return nil, corruptf(0, "invalid value table section length %d",
+			len(data))
+	}
+	rv.n = len(rv.data) / rv.valSize
+	return rv, nil
+}
+
+// get returns the value at index i, if there is one
+func (t *valueTable) get(i uint64) (uint64, bool) {
+	if i >= uint64(t.n) {
+		return 0, false
+	}
+	start := int(i) * t.valSize
+	return readPackedUint(t.data[start : start+t.valSize]), true
+}
+
+// value returns the value of a key with the output accumulated along its
+// path, resolving interned values.
+func (f *FST) value(out uint64) (uint64, error) {
+	if f.values == nil {
+		return out, nil
+	}
+	rv, ok := f.values.get(out)
+	if !ok {
+		return 0, corruptf(0, "interned value index %d out of range", out)
+	}
+	return rv, nil
+}
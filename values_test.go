@@ -0,0 +1,135 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestInternValues(t *testing.T) {
+	// a few large values, shared by many keys
+	vals := make([]uint64, len(thousandTestWords))
+	for i := range vals {
+		vals[i] = 1<<50 + uint64(i%7)*1<<40
+	}
+	build := func(opts ...BuilderOption) []byte {
+		var buf bytes.Buffer
+		b, err := New(&buf, opts...)
+		if err != nil {
+			t.Fatalf("error creating builder: %v", err)
+		}
+		err = insertStrings(b, thousandTestWords, vals)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+		err = b.Close()
+		if err != nil {
+			t.Fatalf("error closing builder: %v", err)
+		}
+		return buf.Bytes()
+	}
+	plain := build()
+	interned := build(WithInternedValues())
+	if len(interned) >= len(plain) {
+		t.Errorf("expected interned values to be smaller, got %d >= %d",
+			len(interned), len(plain))
+	}
+
+	for _, opts := range [][]OpenOption{nil, {WithNodeCache(1 << 20)}} {
+		fst, err := Load(interned, opts...)
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		for i, word := range thousandTestWords {
+			val, exists, err := fst.Get([]byte(word))
+			if err != nil || !exists || val != vals[i] {
+				t.Fatalf("expected %s %d, got %d %t %v", word, vals[i], val,
+					exists, err)
+			}
+		}
+		itr, err := fst.Iterator(nil, nil)
+		var n int
+		for err == nil {
+			key, val := itr.Current()
+			if string(key) != thousandTestWords[n] || val != vals[n] {
+				t.Fatalf("expected %s %d, got %s %d", thousandTestWords[n],
+					vals[n], key, val)
+			}
+			n++
+			err = itr.Next()
+		}
+		if err != ErrIteratorDone || n != len(thousandTestWords) {
+			t.Errorf("expected %d keys, got %d %v", len(thousandTestWords), n, err)
+		}
+		trace, err := fst.TraceGet([]byte(thousandTestWords[3]))
+		if err != nil || trace.Value != vals[3] {
+			t.Errorf("expected traced value %d, got %d %v", vals[3], trace.Value,
+				err)
+		}
+		_, err = fst.BestFirst(nil, nil, nil)
+		if err != ErrInternedValues {
+			t.Errorf("expected ErrInternedValues, got %v", err)
+		}
+	}
+
+	// the value table is required to read the values
+	corrupted := append([]byte(nil), plain...)
+	binary.LittleEndian.PutUint64(corrupted[8:], typeInternedValues)
+	_, err := Load(corrupted)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt without value table, got %v", err)
+	}
+}
+
+func TestInternValuesReset(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, WithInternedValues())
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	for _, kv := range []KV{{"a", 100}, {"b", 200}} {
+		err = b.Insert([]byte(kv.Key), kv.Val)
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	buf.Reset()
+	err = b.Reset(&buf)
+	if err != nil {
+		t.Fatalf("error resetting: %v", err)
+	}
+	err = b.Insert([]byte("c"), 300)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	if fst.values.n != 1 {
+		t.Errorf("expected 1 interned value after reset, got %d", fst.values.n)
+	}
+	val, exists, err := fst.Get([]byte("c"))
+	if err != nil || !exists || val != 300 {
+		t.Errorf("expected c 300, got %d %t %v", val, exists, err)
+	}
+}
@@ -37,7 +37,9 @@ enumerate key/value pairs within a specified range.
 package vellum
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -49,63 +51,567 @@ var ErrOutOfOrder = errors.New("values not inserted in lexicographic order")
 // Current() value pointed to by the iterator is greater than the last
 // key in this FST, or outside the configured startKeyInclusive/endKeyExclusive
 // range of the Iterator.
+//
+// Any other error is a genuine failure, in particular errors matching
+// ErrCorrupt report malformed FST data encountered during the scan.
 var ErrIteratorDone = errors.New("iterator-done")
 
+// ErrIteratorEndBound is returned instead of ErrIteratorDone by the
+// Iterator/Next/Seek methods of FSTs opened WithEndBoundError, when the
+// iterator moves past the configured endKeyExclusive.  It matches
+// ErrIteratorDone using errors.Is.
+var ErrIteratorEndBound = fmt.Errorf("%w: past end bound", ErrIteratorDone)
+
 // BuilderOpts is a structure to let advanced users customize the behavior
 // of the builder and some aspects of the generated FST.
 type BuilderOpts struct {
-	Encoder           int
+	Encoder int
+
+	// RegistryTableSize is the number of buckets of the registry of
+	// compiled states, which is looked up to share equivalent states, and
+	// RegistryMRUSize the number of most recently used states kept in each
+	// bucket.  Larger registries share more states, resulting in smaller
+	// FSTs, using more memory, see MaxMemory.
 	RegistryTableSize int
 	RegistryMRUSize   int
+
+	// CheckOutputs enables an invariant-checking build mode, intended for
+	// testing new encoders and output algebra.  If greater than zero,
+	// every CheckOutputs'th key inserted has its output reconstructed from
+	// the outputs distributed along its prefix, both when it is inserted
+	// and again after the following key is inserted, and Insert returns
+	// ErrOutputInvariant if it doesn't match the value inserted.
+	CheckOutputs int
+
+	// SubtreeCounts records the number of keys reachable from each state
+	// in an optional section of the FST, allowing iterators to skip over
+	// whole subtrees, see FSTIterator.SeekOrdinal, and FST.GetByOrdinal
+	// and FST.Ordinal to find keys by their rank.  FSTs built with this
+	// option remain readable by earlier versions of this package.
+	SubtreeCounts bool
+
+	// DepthHints records the length of the longest key suffix reachable
+	// from each state in an optional section of the FST, allowing automata
+	// implementing DepthHintAutomaton to prune states which can't reach a
+	// match within that many bytes.
+	DepthHints bool
+
+	// ValueBounds records the minimum and maximum values of the keys
+	// reachable from each state in optional sections of the FST, allowing
+	// FST.SearchValues to skip the subtrees with no value in the range
+	// searched.  It is ignored with InternValues, as the indexes of
+	// interned values aren't ordered as the values are.
+	ValueBounds bool
+
+	// ValueSums records the sum of the values of the keys reachable from
+	// each state in an optional section of the FST, along with subtree
+	// counts, allowing FST.WeightedSample to choose keys with a probability
+	// proportional to their values.  It is ignored with InternValues, as
+	// the indexes of interned values aren't the values.
+	ValueSums bool
+
+	// RegistrySpillThreshold, if positive, is the approximate memory (in
+	// bytes) the registry of compiled states may use.  Beyond it, the
+	// registered states are moved to a memory-mapped temporary file, which
+	// the operating system can page out, allowing large FSTs to be built
+	// on machines with little memory.  The file can hold about four times
+	// this many bytes of states, when it is full it is cleared, which
+	// results in a larger FST, but never an incorrect one.  It is deleted
+	// when the Builder is closed or reset.
+	RegistrySpillThreshold int
+
+	// RegistrySpillDir is the directory for the temporary file, if empty
+	// the default directory for temporary files is used.
+	RegistrySpillDir string
+
+	// MaxMemory, if positive, is the approximate memory (in bytes) the
+	// registry of compiled states may use, including its table, bounding
+	// the memory used by the Builder when it isn't spilling.  The table is
+	// shrunk to use at most half of it, if RegistryTableSize and
+	// RegistryMRUSize would use more, and beyond it, whole buckets of
+	// registered states are forgotten in turn, which results in a larger
+	// FST, but never an incorrect one.  The memory proportional to the
+	// number of states of optional sections such as SubtreeCounts, and of
+	// InternValues and SuffixReport, isn't bounded.
+	MaxMemory int
+
+	// RegistryHash, if set, replaces the FNV-1a hash choosing the bucket
+	// of the registry compiled states are looked up in, such as xxhash or
+	// wyhash, if Builder.RegistryStats shows long probes or many
+	// collisions.  It is passed 1 byte final flag and 8 bytes final output
+	// for each state, then for each transition 1 byte label, 8 bytes
+	// output and 8 bytes address, all uint64 little-endian.
+	RegistryHash func([]byte) uint64
+
+	// MergePrefetch, if positive, has Merge read ahead from each of the
+	// Iterators being merged on its own goroutine, buffering up to this
+	// many batches of MergePrefetchBatch keys (DefaultPrefetchBatch if not
+	// positive), see PrefetchIterator.  It is ignored by New.
+	MergePrefetch      int
+	MergePrefetchBatch int
+
+	// MergeRekey, if set, has Merge rewrite each merged key before
+	// inserting it, or drop it, see RekeyFunc.  It is ignored by New.
+	MergeRekey RekeyFunc
+
+	// InternValues stores each distinct value once, in a table in an
+	// optional section, and uses indexes into it as the outputs.  This
+	// makes FSTs with many keys sharing a few large values smaller, values
+	// are resolved transparently when read.  Readers unaware of this option
+	// would read the indexes as values, and BestFirst returns
+	// ErrInternedValues, as the indexes aren't ordered as the values are.
+	InternValues bool
+
+	// KeyDigests, if positive, records a digest of this many bytes (up to
+	// 8) of each key and its value in an optional section of the FST,
+	// which GetVerified checks to detect a lookup landing on the wrong key
+	// or value.  Subtree counts are always recorded with the digests, see
+	// SubtreeCounts.
+	KeyDigests int
+
+	// Checksums records CRC-32C checksums of the blocks of the FST data,
+	// and of its section table and footer, in an optional section, which
+	// Load and Open verify, detecting truncated or corrupted files.  They
+	// apply to either version of the encoding, selected by Encoder, rather
+	// than being a version of their own.  It requires one of the built-in
+	// encoders, and can't be combined with Compression.
+	Checksums bool
+
+	// Compression, if set, is the name of the Compressor (see
+	// RegisterCompressor) compressing the states of the FST, in blocks of
+	// CompressionBlockSize bytes (DefaultCompressionBlockSize if zero),
+	// which are decompressed as they are read, and cached, see
+	// WithBlockCache.  The sections aren't compressed.  It requires one of
+	// the built-in encoders, and can't be combined with Checksums.
+	Compression          string
+	CompressionBlockSize int
+
+	// SuffixReport, if positive, has the Builder report the states shared
+	// by the keys, with this many of the most frequently shared suffixes,
+	// see Builder.SuffixReport.  The size of every state is remembered
+	// while building, which takes memory proportional to the size of the
+	// FST.
+	SuffixReport int
+
+	// payloads is set by NewBytesBuilder, the outputs are then indexes
+	// into a table of payloads
+	payloads bool
+
+	// valueLists is set by NewMultiBuilder, the outputs are then indexes
+	// into a table of lists of values
+	valueLists bool
+}
+
+// BuilderOption is used to customize the behavior of the builder.
+//
+// Options are applied in order, starting from the defaults.  A *BuilderOpts
+// is itself a BuilderOption which replaces all settings with its own (a nil
+// *BuilderOpts restores the defaults), the functional options such as
+// WithRegistrySize only change the settings they refer to.  This means
+// existing callers passing a *BuilderOpts continue to work unchanged, and
+// new capabilities are added as new functional options.
+type BuilderOption interface {
+	applyBuilderOption(*BuilderOpts)
+}
+
+func (o *BuilderOpts) applyBuilderOption(rv *BuilderOpts) {
+	if o == nil {
+		o = defaultBuilderOpts
+	}
+	*rv = *o
+}
+
+type builderOptionFunc func(*BuilderOpts)
+
+func (f builderOptionFunc) applyBuilderOption(rv *BuilderOpts) {
+	f(rv)
+}
+
+func applyBuilderOptions(opts []BuilderOption) *BuilderOpts {
+	rv := *defaultBuilderOpts
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyBuilderOption(&rv)
+		}
+	}
+	return &rv
+}
+
+// WithVersion selects the version of the encoding used to write the FST.
+// Version 1 is the default, version 2 encodes states with many
+// transitions with a bitmap of their labels (see docs/format.md).
+func WithVersion(version int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.Encoder = version
+	})
+}
+
+// WithChecksums records checksums of the FST data, see
+// BuilderOpts.Checksums.
+func WithChecksums() BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.Checksums = true
+	})
+}
+
+// WithCompression compresses the states of the FST with the named
+// Compressor, see BuilderOpts.Compression.
+func WithCompression(name string) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.Compression = name
+	})
+}
+
+// WithSuffixReport reports the n most frequently shared suffixes, see
+// BuilderOpts.SuffixReport.
+func WithSuffixReport(n int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.SuffixReport = n
+	})
+}
+
+// WithRegistrySize sets the size of the registry used to find previously
+// compiled equivalent states, as a number of hash table buckets, each of
+// which remembers the mruSize most recently used states.  A tableSize of
+// zero disables the registry, building a larger FST with less memory.
+func WithRegistrySize(tableSize, mruSize int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.RegistryTableSize = tableSize
+		o.RegistryMRUSize = mruSize
+	})
+}
+
+// WithRegistryHash sets the hash of the registry, see
+// BuilderOpts.RegistryHash.
+func WithRegistryHash(hash func([]byte) uint64) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.RegistryHash = hash
+	})
+}
+
+// WithOutputCheck enables the invariant-checking build mode, verifying
+// every n'th key inserted, see BuilderOpts.CheckOutputs.
+func WithOutputCheck(n int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.CheckOutputs = n
+	})
+}
+
+// WithSubtreeCounts records subtree counts, see BuilderOpts.SubtreeCounts.
+func WithSubtreeCounts() BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.SubtreeCounts = true
+	})
+}
+
+// WithDepthHints records depth hints, see BuilderOpts.DepthHints.
+func WithDepthHints() BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.DepthHints = true
+	})
+}
+
+// WithValueBounds records value bounds, see BuilderOpts.ValueBounds.
+func WithValueBounds() BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.ValueBounds = true
+	})
+}
+
+// WithValueSums records value sums, see BuilderOpts.ValueSums.
+func WithValueSums() BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.ValueSums = true
+	})
+}
+
+// WithMaxMemory bounds the memory used by the registry, see
+// BuilderOpts.MaxMemory.
+func WithMaxMemory(budget int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.MaxMemory = budget
+	})
+}
+
+// WithRegistrySpill moves the registry to a memory-mapped temporary file in
+// dir once it uses more than threshold bytes, see
+// BuilderOpts.RegistrySpillThreshold.
+func WithRegistrySpill(dir string, threshold int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.RegistrySpillDir = dir
+		o.RegistrySpillThreshold = threshold
+	})
+}
+
+// WithInternedValues interns the values, see BuilderOpts.InternValues.
+func WithInternedValues() BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.InternValues = true
+	})
+}
+
+// WithKeyDigests records key digests of size bytes, see
+// BuilderOpts.KeyDigests.
+func WithKeyDigests(size int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.KeyDigests = size
+	})
+}
+
+// WithMergePrefetch has Merge read ahead from each of the Iterators being
+// merged, see BuilderOpts.MergePrefetch.
+func WithMergePrefetch(batches, batchSize int) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.MergePrefetch = batches
+		o.MergePrefetchBatch = batchSize
+	})
+}
+
+// WithMergeRekey has Merge rewrite the keys, see BuilderOpts.MergeRekey.
+func WithMergeRekey(f RekeyFunc) BuilderOption {
+	return builderOptionFunc(func(o *BuilderOpts) {
+		o.MergeRekey = f
+	})
 }
 
 // New returns a new Builder which will stream out the
 // underlying representation to the provided Writer as the set is built.
-func New(w io.Writer, opts *BuilderOpts) (*Builder, error) {
-	return newBuilder(w, opts)
+func New(w io.Writer, opts ...BuilderOption) (*Builder, error) {
+	return newBuilder(w, applyBuilderOptions(opts))
+}
+
+// OpenOption is used to customize how an FST is opened or loaded.
+type OpenOption func(*openOpts)
+
+type openOpts struct {
+	nodeCacheBudget int
+	getCacheBudget  int
+	warmupCtx       context.Context
+	warmupProgress  WarmupProgressFunc
+	mutationCheck   bool
+	workers         int
+	prefetch        int
+	skipChecksums   bool
+	endBoundError   bool
+	partial         *RangeSet
+
+	blockCacheBudget int
+}
+
+func applyOpenOptions(opts []OpenOption) *openOpts {
+	rv := &openOpts{
+		blockCacheBudget: DefaultBlockCacheBudget,
+	}
+	for _, opt := range opts {
+		opt(rv)
+	}
+	return rv
+}
+
+// WithNodeCache decodes and pins the states closest to the root of the
+// FST, level by level, for as long as they fit in the provided budget
+// (in bytes).  Lookups then follow the cached states with simple array
+// indexing, instead of decoding each of them from the underlying data.
+func WithNodeCache(budget int) OpenOption {
+	return func(o *openOpts) {
+		o.nodeCacheBudget = budget
+	}
+}
+
+// WithGetCache caches the results of recent lookups with Get, including
+// those of keys which don't exist, for as long as they fit in the provided
+// budget (in bytes), evicting the least recently used ones first.  With
+// skewed key distributions, a small cache avoids most traversals of the
+// FST.  See FST.GetCacheStats.
+func WithGetCache(budget int) OpenOption {
+	return func(o *openOpts) {
+		o.getCacheBudget = budget
+	}
+}
+
+// WithIteratorPrefetch has the iterators of the FST decode up to n entries
+// at a time, ahead of the current one, into a ring buffer.  Consumers
+// processing the entries in tight loops then run over the buffered entries,
+// and the decoding of the states they share stays warm in the caches,
+// rather than alternating with the consumer.  Unlike PrefetchIterator, no
+// goroutine is involved.
+func WithIteratorPrefetch(n int) OpenOption {
+	return func(o *openOpts) {
+		o.prefetch = n
+	}
+}
+
+// WithoutChecksumVerification skips the verification of the checksums of
+// FSTs built with BuilderOpts.Checksums when they are opened, which reads
+// all of their data.  FST.VerifyChecksums can verify them later.
+func WithoutChecksumVerification() OpenOption {
+	return func(o *openOpts) {
+		o.skipChecksums = true
+	}
+}
+
+// WithEndBoundError has the iterators of the FST return ErrIteratorEndBound
+// rather than ErrIteratorDone when they move past their end bound (or
+// before their start bound, for ReverseIterators), so that callers can
+// tell a range scan ending apart from the end of the FST.  Callers must
+// then use errors.Is(err, ErrIteratorDone) to match both.
+func WithEndBoundError() OpenOption {
+	return func(o *openOpts) {
+		o.endBoundError = true
+	}
 }
 
 // Open loads the FST stored in the provided path
-func Open(path string) (*FST, error) {
-	return open(path)
+func Open(path string, opts ...OpenOption) (*FST, error) {
+	return open(path, applyOpenOptions(opts))
 }
 
 // Load will return the FST represented by the provided byte slice.
-func Load(data []byte) (*FST, error) {
-	return new(data, nil)
+func Load(data []byte, opts ...OpenOption) (*FST, error) {
+	o := applyOpenOptions(opts)
+	if o.mutationCheck {
+		protected, closer, err := readOnlyCopy(data)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := new(protected, closer, o)
+		if err != nil && closer != nil {
+			_ = closer.Close()
+		}
+		return rv, err
+	}
+	return new(data, nil, o)
 }
 
 // Merge will iterate through the provided Iterators, merge duplicate keys
 // with the provided MergeFunc, and build a new FST to the provided Writer.
-func Merge(w io.Writer, opts *BuilderOpts, itrs []Iterator, f MergeFunc) error {
-	builder, err := New(w, opts)
+func Merge(w io.Writer, opts BuilderOption, itrs []Iterator, f MergeFunc) error {
+	_, err := MergeWithStats(w, opts, itrs, f)
+	return err
+}
+
+// MergeWithStats performs a Merge, and reports MergeStats describing the
+// outcome.
+func MergeWithStats(w io.Writer, opts BuilderOption, itrs []Iterator,
+	f MergeFunc) (*MergeStats, error) {
+	return merge(w, applyBuilderOptions([]BuilderOption{opts}), itrs, f, nil,
+		nil)
+}
+
+// MergeFSTs builds a new FST to the provided Writer with the keys of all
+// the FSTs, streaming them in order without holding them in memory, as
+// when compacting segments.  The values of a key found in more than one
+// FST are passed to resolve, in the order of the FSTs, to choose its value.
+// Runs of keys found in only one of the FSTs are copied with their shared
+// suffixes, see Builder.CopyFrom.
+func MergeFSTs(w io.Writer, fsts []*FST, resolve ResolveFunc,
+	opts ...BuilderOption) error {
+	var itrs []Iterator
+	for _, fst := range fsts {
+		itr, err := fst.Iterator(nil, nil)
+		if errors.Is(err, ErrIteratorDone) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		itrs = append(itrs, itr)
+	}
+	_, err := merge(w, applyBuilderOptions(opts), itrs, nil, nil, resolve)
+	return err
+}
+
+// merge performs a Merge, the values of duplicate keys being combined
+// pairwise with fold, or with resolve, if set, instead of with f
+func merge(w io.Writer, o *BuilderOpts, itrs []Iterator, f MergeFunc,
+	fold func(a, b uint64) uint64, resolve ResolveFunc) (*MergeStats, error) {
+	builder, err := newBuilder(w, o)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if o.MergePrefetch > 0 {
+		prefetchers := make([]*PrefetchIterator, len(itrs))
+		prefetched := make([]Iterator, len(itrs))
+		for i, itr := range itrs {
+			prefetchers[i] = NewPrefetchIterator(itr, o.MergePrefetch,
+				o.MergePrefetchBatch)
+			prefetched[i] = prefetchers[i]
+		}
+		// stop reading ahead if the merge fails
+		defer func() {
+			for _, p := range prefetchers {
+				p.stop()
+			}
+		}()
+		itrs = prefetched
+	}
+
+	stats := &MergeStats{
+		SchemaVersion: StatsSchemaVersion,
+		Inputs:        len(itrs),
+	}
+
+	// when every input is an FST, a run of keys from one of them is copied
+	// with its shared suffixes, see Builder.CopyFrom
+	var srcs []*FSTIterator
+	if o.MergeRekey == nil {
+		srcs = mergeCopySources(itrs)
 	}
+	run := -1
 
-	itr, err := NewMergeIterator(itrs, f)
+	var rekeyed rekeyer
+	itr, err := newMergeIterator(itrs, f, fold, resolve)
 	for err == nil {
+		if srcs != nil && len(itr.lowIdxs) == 1 {
+			if itr.lowIdxs[0] == run {
+				var n int
+				n, err = itr.copyRun(builder, srcs)
+				stats.Keys += n
+				run = -1
+				continue
+			}
+			run = itr.lowIdxs[0]
+		} else {
+			run = -1
+		}
 		k, v := itr.Current()
+		if o.MergeRekey != nil {
+			var keep bool
+			k, keep, err = rekeyed.rekey(o.MergeRekey, k)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				stats.DroppedKeys++
+				err = itr.Next()
+				continue
+			}
+		}
 		err = builder.Insert(k, v)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		stats.Keys++
+		if len(itr.lowIdxs) > 1 {
+			stats.MergedKeys++
 		}
 		err = itr.Next()
 	}
 
-	if err != nil && err != ErrIteratorDone {
-		return err
+	if err != nil && !errors.Is(err, ErrIteratorDone) {
+		return nil, err
 	}
 
 	err = itr.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = builder.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return stats, nil
 }
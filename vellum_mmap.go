@@ -17,17 +17,26 @@
 package vellum
 
 import (
+	"context"
 	"os"
 
 	mmap "github.com/edsrzf/mmap-go"
 )
 
+// mmapAvailable is true when Open memory maps files
+const mmapAvailable = true
+
 type mmapWrapper struct {
 	f  *os.File
 	mm mmap.MMap
+
+	cancelWarmup context.CancelFunc
 }
 
 func (m *mmapWrapper) Close() (err error) {
+	if m.cancelWarmup != nil {
+		m.cancelWarmup()
+	}
 	if m.mm != nil {
 		err = m.mm.Unmap()
 	}
@@ -42,7 +51,7 @@ func (m *mmapWrapper) Close() (err error) {
 	return
 }
 
-func open(path string) (*FST, error) {
+func open(path string, opts *openOpts) (*FST, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -53,8 +62,21 @@ func open(path string) (*FST, error) {
 		_ = f.Close()
 		return nil, err
 	}
-	return new(mm, &mmapWrapper{
+	wrapper := &mmapWrapper{
 		f:  f,
 		mm: mm,
-	})
+	}
+	rv, err := new(mm, wrapper, opts)
+	if err != nil {
+		_ = wrapper.Close()
+		return nil, err
+	}
+	if opts.warmupCtx != nil {
+		// read through the file, not the mapping, so that closing the
+		// FST during the warmup only results in a read error
+		var ctx context.Context
+		ctx, wrapper.cancelWarmup = context.WithCancel(opts.warmupCtx)
+		go warmup(ctx, f, int64(len(mm)), opts.warmupProgress)
+	}
+	return rv, nil
 }
@@ -18,10 +18,19 @@ package vellum
 
 import "io/ioutil"
 
-func open(path string) (*FST, error) {
-	data, err := ioutil.ReadFile(string)
+// mmapAvailable is false with the nommap build tag, Open reads files into
+// memory
+const mmapAvailable = false
+
+func open(path string, opts *openOpts) (*FST, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return new(data, nil)
+	if opts.warmupCtx != nil && opts.warmupProgress != nil {
+		// the whole file has already been read into memory
+		total := int64(len(data))
+		opts.warmupProgress(total, total, nil)
+	}
+	return new(data, nil, opts)
 }
@@ -16,9 +16,12 @@ package vellum
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -150,6 +153,38 @@ func TestRoundTripSimple(t *testing.T) {
 	}
 }
 
+func TestMinMaxKey(t *testing.T) {
+	tests := []struct {
+		kvs      []KV
+		min, max string
+	}{
+		{[]KV{{"a", 1}}, "a", "a"},
+		{[]KV{{"", 0}}, "", ""},
+		{[]KV{{"", 0}, {"b", 1}}, "", "b"},
+		{[]KV{{"a", 1}, {"ab", 2}, {"abc", 3}}, "a", "abc"},
+		{[]KV{{"ab", 1}, {"abc", 2}, {"b", 3}, {"ba", 4}}, "ab", "ba"},
+	}
+	for _, test := range tests {
+		fst := buildKVs(t, test.kvs...)
+		min, ok, err := fst.MinKey()
+		if err != nil || !ok || string(min) != test.min {
+			t.Errorf("expected min %q, got %q %t %v", test.min, min, ok, err)
+		}
+		max, ok, err := fst.MaxKey()
+		if err != nil || !ok || string(max) != test.max {
+			t.Errorf("expected max %q, got %q %t %v", test.max, max, ok, err)
+		}
+	}
+
+	empty := buildKVs(t)
+	if _, ok, err := empty.MinKey(); ok || err != nil {
+		t.Errorf("expected no min key for empty fst, got %t %v", ok, err)
+	}
+	if _, ok, err := empty.MaxKey(); ok || err != nil {
+		t.Errorf("expected no max key for empty fst, got %t %v", ok, err)
+	}
+}
+
 func TestRoundTripThousand(t *testing.T) {
 	dataset := thousandTestWords
 	randomThousandVals := randomValues(dataset)
@@ -638,3 +673,491 @@ func benchmarkBigKey(b *testing.B, n int) {
 		}
 	}
 }
+
+func TestArcs(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+
+	err = insertStringMap(b, smallSample)
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("err closing: %v", err)
+	}
+
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading set: %v", err)
+	}
+
+	// reconstruct all the key/value pairs by walking the arcs
+	got := map[string]uint64{}
+	var walk func(addr int, key []byte, total uint64)
+	walk = func(addr int, key []byte, total uint64) {
+		if final, out := fst.IsMatchWithVal(addr); final {
+			got[string(key)] = total + out
+		}
+		arcs, err := fst.Arcs(addr, nil)
+		if err != nil {
+			t.Fatalf("error getting arcs: %v", err)
+		}
+		for i, arc := range arcs {
+			if i > 0 && arcs[i-1].Label >= arc.Label {
+				t.Errorf("expected arcs in ascending label order, got %v", arcs)
+			}
+			walk(arc.Dest, append(key, arc.Label), total+arc.Output)
+		}
+	}
+	walk(fst.Start(), nil, 0)
+
+	if !reflect.DeepEqual(smallSample, got) {
+		t.Errorf("expected %v, got: %v", smallSample, got)
+	}
+
+	// arcs are appended to the provided slice
+	prealloc := []Arc{{Label: 'z'}}
+	arcs, err := fst.Arcs(fst.Start(), prealloc)
+	if err != nil {
+		t.Fatalf("error getting arcs: %v", err)
+	}
+	if len(arcs) != 3 || arcs[0].Label != 'z' || arcs[1].Label != 'm' ||
+		arcs[2].Label != 't' {
+		t.Errorf("expected arcs z, m, t, got %v", arcs)
+	}
+
+	_, err = fst.Arcs(3, nil)
+	if err == nil {
+		t.Errorf("expected error for invalid address")
+	}
+}
+
+func TestMergeRekey(t *testing.T) {
+	merge := func(f RekeyFunc, in ...map[string]uint64) (map[string]uint64,
+		*MergeStats, error) {
+		var itrs []Iterator
+		for _, m := range in {
+			itr, err := newTestIterator(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			itrs = append(itrs, itr)
+		}
+		var buf bytes.Buffer
+		stats, err := MergeWithStats(&buf, WithMergeRekey(f), itrs, MergeMin)
+		if err != nil {
+			return nil, nil, err
+		}
+		fst, err := Load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error loading: %v", err)
+		}
+		rv := make(map[string]uint64)
+		itr, err := fst.Iterator(nil, nil)
+		for err == nil {
+			k, v := itr.Current()
+			rv[string(k)] = v
+			err = itr.Next()
+		}
+		return rv, stats, nil
+	}
+
+	a := map[string]uint64{"old/x": 1, "old/y": 2, "z": 3}
+	b := map[string]uint64{"old/x": 0, "old/w": 4}
+	got, stats, err := merge(PrefixRekey([]byte("s1:")), a, b)
+	want := map[string]uint64{"s1:old/w": 4, "s1:old/x": 0, "s1:old/y": 2,
+		"s1:z": 3}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v %v", want, got, err)
+	}
+
+	got, stats, err = merge(StripPrefixRekey([]byte("old/")), a, b)
+	want = map[string]uint64{"w": 4, "x": 0, "y": 2}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v %v", want, got, err)
+	}
+	if stats.Keys != 3 || stats.DroppedKeys != 1 || stats.MergedKeys != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// inverting the bytes reverses the order, truncating merges keys
+	invert := func(dst, key []byte) ([]byte, bool) {
+		for _, c := range key {
+			dst = append(dst, 0xff-c)
+		}
+		return dst, true
+	}
+	truncate := func(dst, key []byte) ([]byte, bool) {
+		return append(dst, key[:1]...), true
+	}
+	for _, f := range []RekeyFunc{invert, truncate} {
+		_, _, err = merge(f, a, b)
+		if !errors.Is(err, ErrRekeyOrder) {
+			t.Errorf("expected ErrRekeyOrder, got %v", err)
+		}
+	}
+}
+
+func TestMergeFSTs(t *testing.T) {
+	a := buildKVs(t, KV{"a", 1}, KV{"b", 5}, KV{"c", 6})
+	b := buildKVs(t, KV{"b", 2}, KV{"c", 3}, KV{"d", 4})
+	empty := buildKVs(t)
+
+	var keys []string
+	resolve := func(key []byte, vals []uint64) uint64 {
+		keys = append(keys, string(key))
+		if string(key) == "b" {
+			return vals[0]
+		}
+		return vals[len(vals)-1]
+	}
+	var buf bytes.Buffer
+	err := MergeFSTs(&buf, []*FST{a, empty, b}, resolve)
+	if err != nil {
+		t.Fatalf("error merging: %v", err)
+	}
+	want := []KV{{"a", 1}, {"b", 5}, {"c", 3}, {"d", 4}}
+	if got := fstPairs(t, buf.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Errorf("expected b and c resolved, got %v", keys)
+	}
+
+	buf.Reset()
+	err = MergeFSTs(&buf, []*FST{empty}, resolve)
+	if err != nil {
+		t.Fatalf("error merging: %v", err)
+	}
+	if got := fstPairs(t, buf.Bytes()); len(got) != 0 {
+		t.Errorf("expected no keys, got %v", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "words.fst")
+	err = ioutil.WriteFile(path, buildWordsSample(t), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fst, err := Open(path)
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	itr, err := fst.Iterator(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+
+	// lookups and iterations in progress complete, or end with ErrClosed
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				word := thousandTestWords[i%len(thousandTestWords)]
+				_, exists, err := fst.Get([]byte(word))
+				if err == ErrClosed {
+					return
+				}
+				if err != nil || !exists {
+					t.Errorf("expected %s, got %t %v", word, exists, err)
+					return
+				}
+			}
+		}()
+	}
+	err = fst.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	wg.Wait()
+
+	// closing again does nothing
+	err = fst.Close()
+	if err != nil {
+		t.Fatalf("error closing again: %v", err)
+	}
+	var nilFST *FST
+	err = nilFST.Close()
+	if err != nil {
+		t.Fatalf("error closing nil fst: %v", err)
+	}
+
+	_, _, err = fst.Get([]byte("test"))
+	if err != ErrClosed {
+		t.Errorf("expected ErrClosed from get, got %v", err)
+	}
+	if err = itr.Next(); err != ErrClosed {
+		t.Errorf("expected ErrClosed from next, got %v", err)
+	}
+	if err = itr.Seek([]byte("m")); err != ErrClosed {
+		t.Errorf("expected ErrClosed from seek, got %v", err)
+	}
+	if _, err = fst.Iterator(nil, nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed from iterator, got %v", err)
+	}
+	if _, err = fst.GetMinKey(); err != ErrClosed {
+		t.Errorf("expected ErrClosed from min key, got %v", err)
+	}
+	if _, err = fst.Arcs(0, nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed from arcs, got %v", err)
+	}
+}
+
+// TestClosedMethods checks that the methods reading the data of an FST,
+// and of its iterators created before it is closed, return ErrClosed or
+// zero values once it is closed, rather than reading unmapped data
+func TestClosedMethods(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "words.fst")
+	err = ioutil.WriteFile(path, buildWordsWithCounts(t), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fst, err := Open(path)
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	itr, err := fst.Iterator(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating iterator: %v", err)
+	}
+	bookmark, err := itr.Bookmark("")
+	if err != nil {
+		t.Fatalf("error creating bookmark: %v", err)
+	}
+	ritr, err := fst.ReverseIterator(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("error creating reverse iterator: %v", err)
+	}
+	bitr, err := fst.BestFirst(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("error creating best first iterator: %v", err)
+	}
+	recitr, err := fst.RecoverIterator()
+	if err != nil {
+		t.Fatalf("error creating recover iterator: %v", err)
+	}
+	reader, err := fst.Reader()
+	if err != nil {
+		t.Fatalf("error creating reader: %v", err)
+	}
+	err = fst.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+
+	key := []byte(thousandTestWords[0])
+	errs := map[string]func() error{
+		"Get": func() error {
+			_, _, err := fst.Get(key)
+			return err
+		},
+		"Contains": func() error {
+			_, err := fst.Contains(key)
+			return err
+		},
+		"Lookup": func() error {
+			return fst.Lookup(key).Err()
+		},
+		"LookupBatch": func() error {
+			return fst.LookupBatch([][]byte{key})[0].Err()
+		},
+		"Reader.Get": func() error {
+			_, _, err := reader.Get(key)
+			return err
+		},
+		"GetVerified": func() error {
+			_, _, err := fst.GetVerified(key)
+			return err
+		},
+		"GetMulti": func() error {
+			_, _, err := fst.GetMulti(key)
+			return err
+		},
+		"Values": func() error {
+			_, err := fst.Values(0)
+			return err
+		},
+		"GetBytes": func() error {
+			_, _, err := fst.GetBytes(key)
+			return err
+		},
+		"Payload": func() error {
+			_, err := fst.Payload(0)
+			return err
+		},
+		"Iterator": func() error {
+			_, err := fst.Iterator(nil, nil)
+			return err
+		},
+		"Search": func() error {
+			_, err := fst.Search(nil, nil, nil)
+			return err
+		},
+		"SearchValues": func() error {
+			_, err := fst.SearchValues(nil, nil, nil, 0, 1<<20)
+			return err
+		},
+		"MultiIterator": func() error {
+			_, err := fst.MultiIterator(nil, nil)
+			return err
+		},
+		"ReverseIterator": func() error {
+			_, err := fst.ReverseIterator(nil, nil, nil)
+			return err
+		},
+		"BestFirst": func() error {
+			_, err := fst.BestFirst(nil, nil, nil)
+			return err
+		},
+		"RecoverIterator": func() error {
+			_, err := fst.RecoverIterator()
+			return err
+		},
+		"Resume": func() error {
+			_, err := fst.Resume(bookmark, nil)
+			return err
+		},
+		"SearchParallel": func() error {
+			return fst.SearchParallel(nil, nil, nil,
+				func([]byte, uint64) error { return nil })
+		},
+		"Sub.Get": func() error {
+			_, _, err := fst.Sub([]byte("a")).Get(key)
+			return err
+		},
+		"MinKey": func() error {
+			_, _, err := fst.MinKey()
+			return err
+		},
+		"MaxKey": func() error {
+			_, _, err := fst.MaxKey()
+			return err
+		},
+		"Arcs": func() error {
+			_, err := fst.Arcs(0, nil)
+			return err
+		},
+		"Debug": func() error {
+			return fst.Debug(func(int, interface{}) error { return nil })
+		},
+		"DebugDumpJSON": func() error {
+			return fst.DebugDumpJSON(ioutil.Discard)
+		},
+		"Stats": func() error {
+			_, err := fst.Stats()
+			return err
+		},
+		"ContentHash": func() error {
+			_, err := fst.ContentHash()
+			return err
+		},
+		"Alphabet": func() error {
+			_, err := fst.Alphabet()
+			return err
+		},
+		"KeyHistogram": func() error {
+			_, err := fst.KeyHistogram(1)
+			return err
+		},
+		"ValueStats": func() error {
+			_, err := fst.ValueStats(1)
+			return err
+		},
+		"TraceGet": func() error {
+			_, err := fst.TraceGet(key)
+			return err
+		},
+		"GetByOrdinal": func() error {
+			_, _, _, err := fst.GetByOrdinal(0)
+			return err
+		},
+		"Ordinal": func() error {
+			_, _, err := fst.Ordinal(key)
+			return err
+		},
+		"WeightedSample": func() error {
+			_, _, _, err := fst.WeightedSample(nil)
+			return err
+		},
+		"VerifyChecksums": func() error {
+			return fst.VerifyChecksums()
+		},
+		"Zip": func() error {
+			return Zip(fst, fst, func([]byte, *uint64, *uint64) bool {
+				return true
+			})
+		},
+		"CopyFrom": func() error {
+			b, err := New(ioutil.Discard, nil)
+			if err != nil {
+				return err
+			}
+			return b.CopyFrom(fst, nil, nil)
+		},
+		"FSTIterator.Next": itr.Next,
+		"FSTIterator.Seek": func() error {
+			return itr.Seek(key)
+		},
+		"FSTIterator.SeekOrdinal": func() error {
+			return itr.SeekOrdinal(1)
+		},
+		"FSTIterator.Bookmark": func() error {
+			_, err := itr.Bookmark("")
+			return err
+		},
+		"ReverseIterator.Next": ritr.Next,
+		"ReverseIterator.Seek": func() error {
+			return ritr.Seek(key)
+		},
+		"BestFirstIterator.Next": bitr.Next,
+		"RecoverIterator.Next":   recitr.Next,
+	}
+	for name, fn := range errs {
+		if err := fn(); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: expected ErrClosed, got %v", name, err)
+		}
+	}
+
+	if key, val := itr.Current(); key != nil || val != 0 {
+		t.Errorf("expected no current key, got %q %d", key, val)
+	}
+	if key, _ := itr.CurrentInto(nil); len(key) != 0 {
+		t.Errorf("expected no current key, got %q", key)
+	}
+	if m := itr.Match(); m != (Match{}) {
+		t.Errorf("expected no match, got %+v", m)
+	}
+	if key, val := ritr.Current(); key != nil || val != 0 {
+		t.Errorf("expected no current key, got %q %d", key, val)
+	}
+	if fst.Sizes().Total != 0 {
+		t.Errorf("expected zero sizes")
+	}
+	start := fst.Start()
+	if start != noneAddr || fst.IsMatch(start) || fst.Accept(start, 'a') !=
+		noneAddr {
+		t.Errorf("expected the closed fst to match nothing")
+	}
+}
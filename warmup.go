@@ -0,0 +1,84 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"context"
+	"io"
+)
+
+const warmupChunkSize = 1 << 20
+
+// WarmupProgressFunc is invoked as the file backing an FST is read by a
+// warmup, with the number of bytes read so far and the total size.  When
+// the warmup completes read is equal to total.  If the warmup stops early,
+// because the context was canceled, the FST was closed, or a read failed,
+// the final invocation includes the reason as err.
+type WarmupProgressFunc func(read, total int64, err error)
+
+// WithWarmup sequentially reads the entire file in the background after
+// it is opened, populating the operating system page cache, so that the
+// first queries do not pay the latency of cold reads.  The warmup stops
+// early if the provided context is canceled, or the FST is closed.  It has
+// no effect on an FST created with Load().
+func WithWarmup(ctx context.Context) OpenOption {
+	return func(o *openOpts) {
+		o.warmupCtx = ctx
+	}
+}
+
+// WithWarmupProgress registers a callback reporting the progress of a
+// warmup requested with WithWarmup.
+func WithWarmupProgress(progress WarmupProgressFunc) OpenOption {
+	return func(o *openOpts) {
+		o.warmupProgress = progress
+	}
+}
+
+// warmup reads r from start to finish in chunks, reporting progress,
+// until it is done, ctx is canceled or a read fails.
+func warmup(ctx context.Context, r io.ReaderAt, total int64,
+	progress WarmupProgressFunc) {
+	buf := make([]byte, warmupChunkSize)
+	var read int64
+	for read < total {
+		select {
+		case <-ctx.Done():
+			if progress != nil {
+				progress(read, total, ctx.Err())
+			}
+			return
+		default:
+		}
+		chunk := buf
+		if total-read < int64(len(chunk)) {
+			chunk = chunk[:total-read]
+		}
+		n, err := r.ReadAt(chunk, read)
+		read += int64(n)
+		if err != nil && !(err == io.EOF && read == total) {
+			if progress != nil {
+				progress(read, total, err)
+			}
+			return
+		}
+		if progress != nil && read < total {
+			progress(read, total, nil)
+		}
+	}
+	if progress != nil {
+		progress(read, total, nil)
+	}
+}
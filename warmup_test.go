@@ -0,0 +1,139 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpenWithWarmup(t *testing.T) {
+	f, err := ioutil.TempFile("", "vellum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.Remove(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	b, err := New(f, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertStrings(b, thousandTestWords, randomValues(thousandTestWords))
+	if err != nil {
+		t.Fatalf("error building: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("err closing: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type progress struct {
+		read, total int64
+		err         error
+	}
+	done := make(chan progress, 1)
+	fst, err := Open(f.Name(), WithWarmup(context.Background()),
+		WithWarmupProgress(func(read, total int64, err error) {
+			if read == total || err != nil {
+				done <- progress{read, total, err}
+			}
+		}))
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	p := <-done
+	if p.err != nil {
+		t.Errorf("unexpected warmup error: %v", p.err)
+	}
+	if p.read != fi.Size() || p.total != fi.Size() {
+		t.Errorf("expected to read %d bytes, got %d/%d", fi.Size(), p.read, p.total)
+	}
+	ok, err := fst.Contains([]byte(thousandTestWords[0]))
+	if err != nil || !ok {
+		t.Errorf("expected to contain %s", thousandTestWords[0])
+	}
+	err = fst.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var errStubReaderAt = errors.New("stub read error")
+
+type stubReaderAt struct {
+	data   []byte
+	failAt int64
+}
+
+func (s *stubReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.failAt {
+		return 0, errStubReaderAt
+	}
+	return bytes.NewReader(s.data).ReadAt(p, off)
+}
+
+func TestWarmupStopsEarly(t *testing.T) {
+	data := make([]byte, 3*warmupChunkSize+10)
+	var last struct {
+		read, total int64
+		err         error
+	}
+	record := func(read, total int64, err error) {
+		last.read, last.total, last.err = read, total, err
+	}
+
+	// complete
+	warmup(context.Background(), &stubReaderAt{data: data, failAt: 1 << 62},
+		int64(len(data)), record)
+	if last.err != nil || last.read != int64(len(data)) {
+		t.Errorf("expected complete warmup, got %d/%d %v", last.read,
+			last.total, last.err)
+	}
+
+	// read failure
+	warmup(context.Background(), &stubReaderAt{data: data, failAt: warmupChunkSize},
+		int64(len(data)), record)
+	if last.err != errStubReaderAt || last.read != warmupChunkSize {
+		t.Errorf("expected read failure after one chunk, got %d/%d %v",
+			last.read, last.total, last.err)
+	}
+
+	// canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	warmup(ctx, &stubReaderAt{data: data, failAt: 1 << 62},
+		int64(len(data)), record)
+	if last.err != context.Canceled || last.read != 0 {
+		t.Errorf("expected canceled warmup, got %d/%d %v", last.read,
+			last.total, last.err)
+	}
+}
@@ -0,0 +1,267 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wildcard
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/couchbase/vellum/sparse"
+	"github.com/couchbase/vellum/utf8"
+)
+
+type stateKind int
+
+const (
+	// stateRange moves to out on a byte from lo to hi
+	stateRange stateKind = iota
+	// stateSplit moves to both out and out1 without a byte
+	stateSplit
+	stateMatch
+	// stateFail never matches, it is used for empty classes
+	stateFail
+)
+
+type nfaState struct {
+	kind      stateKind
+	lo, hi    byte
+	out, out1 int
+}
+
+// compiler builds the NFA of a pattern, from its end, each node being
+// compiled knowing the state following it
+type compiler struct {
+	states []nfaState
+}
+
+func (c *compiler) add(s nfaState) int {
+	c.states = append(c.states, s)
+	return len(c.states) - 1
+}
+
+// compile adds the states matching the node followed by next, and returns
+// the first of them
+func (c *compiler) compile(n *node, next int) int {
+	switch n.op {
+	case opLiteral:
+		for i := len(n.lit) - 1; i >= 0; i-- {
+			next = c.add(nfaState{
+				kind: stateRange,
+				lo:   n.lit[i],
+				hi:   n.lit[i],
+				out:  next,
+			})
+		}
+		return next
+	case opStar:
+		split := c.add(nfaState{kind: stateSplit, out1: next})
+		c.states[split].out = c.add(nfaState{
+			kind: stateRange,
+			lo:   0,
+			hi:   0xff,
+			out:  split,
+		})
+		return split
+	case opClass:
+		var starts []int
+		for i := 0; i < len(n.ranges); i += 2 {
+			// the ranges are valid, surrogates are skipped
+			seqs, _ := utf8.NewSequences(n.ranges[i], n.ranges[i+1])
+			for _, seq := range seqs {
+				s := next
+				for j := len(seq) - 1; j >= 0; j-- {
+					s = c.add(nfaState{
+						kind: stateRange,
+						lo:   seq[j].Start,
+						hi:   seq[j].End,
+						out:  s,
+					})
+				}
+				starts = append(starts, s)
+			}
+		}
+		return c.alternate(starts)
+	case opAlternate:
+		starts := make([]int, len(n.subs))
+		for i, sub := range n.subs {
+			starts[i] = c.compile(sub, next)
+		}
+		return c.alternate(starts)
+	default:
+		for i := len(n.subs) - 1; i >= 0; i-- {
+			next = c.compile(n.subs[i], next)
+		}
+		return next
+	}
+}
+
+// alternate returns a state moving to any of the states
+func (c *compiler) alternate(starts []int) int {
+	if len(starts) == 0 {
+		return c.add(nfaState{kind: stateFail})
+	}
+	rv := starts[len(starts)-1]
+	for i := len(starts) - 2; i >= 0; i-- {
+		rv = c.add(nfaState{kind: stateSplit, out: starts[i], out1: rv})
+	}
+	return rv
+}
+
+// dfaBuilder builds the DFA of an NFA by the subset construction, its
+// states being the sets of range and match states reachable after a key
+// prefix.  The empty set is the dead state 0.
+type dfaBuilder struct {
+	states []nfaState
+	w      *Wildcard
+	// reps are the first bytes of the classes
+	reps []byte
+
+	sets  [][]int
+	ids   map[string]int
+	seen  *sparse.Set
+	stack []int
+	buf   []byte
+}
+
+// build builds the DFA of the NFA states, starting from start
+func (w *Wildcard) build(states []nfaState, start, maxStates int) error {
+	b := &dfaBuilder{
+		states: states,
+		w:      w,
+		ids:    make(map[string]int),
+		seen:   sparse.New(uint(len(states))),
+	}
+	b.classify()
+	w.match = append(w.match, false)
+	w.next = append(w.next, make([]uint32, w.numClasses)...)
+	b.sets = append(b.sets, nil)
+	b.ids[""] = 0
+	first := b.closure(nil, start)
+	if len(first) == 0 {
+		// nothing matches, state 1 is out of range, so dead too
+		return nil
+	}
+	b.state(first)
+	for s := 1; s < len(b.sets); s++ {
+		for k, rep := range b.reps {
+			var next []int
+			for _, i := range b.sets[s] {
+				st := b.states[i]
+				if st.kind == stateRange && st.lo <= rep && rep <= st.hi {
+					next = b.closure(next, st.out)
+				}
+			}
+			w.next[s*w.numClasses+k] = uint32(b.state(next))
+		}
+		if len(b.sets) > maxStates {
+			return ErrTooManyStates
+		}
+	}
+	b.always()
+	return nil
+}
+
+// classify groups the bytes into classes which no range tells apart
+func (b *dfaBuilder) classify() {
+	var bounds [257]bool
+	for _, st := range b.states {
+		if st.kind == stateRange {
+			bounds[st.lo] = true
+			bounds[int(st.hi)+1] = true
+		}
+	}
+	b.reps = append(b.reps, 0)
+	for i := 1; i < 256; i++ {
+		if bounds[i] {
+			b.reps = append(b.reps, byte(i))
+		}
+		b.w.classes[i] = byte(len(b.reps) - 1)
+	}
+	b.w.numClasses = len(b.reps)
+}
+
+// closure adds the range and match states reachable from the state
+// without a byte to the set
+func (b *dfaBuilder) closure(set []int, start int) []int {
+	b.seen.Clear()
+	for _, i := range set {
+		b.seen.Add(uint(i))
+	}
+	b.stack = append(b.stack[:0], start)
+	for len(b.stack) > 0 {
+		i := b.stack[len(b.stack)-1]
+		b.stack = b.stack[:len(b.stack)-1]
+		if b.seen.Contains(uint(i)) {
+			continue
+		}
+		b.seen.Add(uint(i))
+		switch b.states[i].kind {
+		case stateSplit:
+			b.stack = append(b.stack, b.states[i].out1, b.states[i].out)
+		case stateRange, stateMatch:
+			set = append(set, i)
+		}
+	}
+	return set
+}
+
+// state returns the DFA state of the set, adding it if it is new
+func (b *dfaBuilder) state(set []int) int {
+	sort.Ints(set)
+	b.buf = b.buf[:0]
+	var tmp [binary.MaxVarintLen64]byte
+	for _, i := range set {
+		n := binary.PutUvarint(tmp[:], uint64(i))
+		b.buf = append(b.buf, tmp[:n]...)
+	}
+	if rv, ok := b.ids[string(b.buf)]; ok {
+		return rv
+	}
+	rv := len(b.sets)
+	b.ids[string(b.buf)] = rv
+	b.sets = append(b.sets, set)
+	match := false
+	for _, i := range set {
+		if b.states[i].kind == stateMatch {
+			match = true
+		}
+	}
+	b.w.match = append(b.w.match, match)
+	b.w.next = append(b.w.next, make([]uint32, b.w.numClasses)...)
+	return rv
+}
+
+// always finds the matching states whose transitions all lead to such
+// states, by removing the others until none is left
+func (b *dfaBuilder) always() {
+	w := b.w
+	w.always = append([]bool{}, w.match...)
+	w.always[0] = false
+	for changed := true; changed; {
+		changed = false
+		for s := 1; s < len(w.always); s++ {
+			if !w.always[s] {
+				continue
+			}
+			for _, next := range w.next[s*w.numClasses : (s+1)*w.numClasses] {
+				if !w.always[next] {
+					w.always[s] = false
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
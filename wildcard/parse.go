@@ -0,0 +1,246 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wildcard
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+type op int
+
+const (
+	opLiteral op = iota
+	opStar
+	opClass
+	opConcat
+	opAlternate
+)
+
+// node is a parsed pattern
+type node struct {
+	op op
+	// lit are the bytes of an opLiteral
+	lit []byte
+	// ranges are the sorted, disjoint, inclusive rune ranges of an opClass,
+	// as pairs
+	ranges []rune
+	subs   []*node
+}
+
+var anyRune = []rune{0, unicode.MaxRune}
+
+type parser struct {
+	pattern string
+	pos     int
+}
+
+func (p *parser) parse() (*node, error) {
+	rv, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	// a , or } outside an alternation is a literal
+	for p.pos < len(p.pattern) {
+		lit := &node{op: opLiteral, lit: []byte{p.pattern[p.pos]}}
+		p.pos++
+		more, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		rv.subs = append(rv.subs, lit, more)
+	}
+	return rv, nil
+}
+
+// sequence parses a concatenation, up to the end of the pattern or the
+// next , or } outside of a class or an alternation
+func (p *parser) sequence() (*node, error) {
+	rv := &node{op: opConcat}
+	var lit []byte
+	flush := func() {
+		if len(lit) > 0 {
+			rv.subs = append(rv.subs, &node{op: opLiteral, lit: lit})
+			lit = nil
+		}
+	}
+	for p.pos < len(p.pattern) {
+		c := p.pattern[p.pos]
+		switch c {
+		case '*':
+			p.pos++
+			flush()
+			last := len(rv.subs) - 1
+			if last < 0 || rv.subs[last].op != opStar {
+				rv.subs = append(rv.subs, &node{op: opStar})
+			}
+		case '?':
+			p.pos++
+			flush()
+			rv.subs = append(rv.subs, &node{op: opClass, ranges: anyRune})
+		case '[':
+			flush()
+			class, err := p.class()
+			if err != nil {
+				return nil, err
+			}
+			rv.subs = append(rv.subs, class)
+		case '{':
+			flush()
+			alt, err := p.alternate()
+			if err != nil {
+				return nil, err
+			}
+			rv.subs = append(rv.subs, alt)
+		case ',', '}':
+			// ends an alternative, parse makes it a literal otherwise
+			flush()
+			return rv, nil
+		case '\\':
+			p.pos++
+			if p.pos >= len(p.pattern) {
+				return nil, ErrTrailingEscape
+			}
+			lit = append(lit, p.pattern[p.pos])
+			p.pos++
+		default:
+			lit = append(lit, c)
+			p.pos++
+		}
+	}
+	flush()
+	return rv, nil
+}
+
+// alternate parses an alternation, starting with its {
+func (p *parser) alternate() (*node, error) {
+	start := p.pos
+	p.pos++
+	rv := &node{op: opAlternate}
+	for {
+		sub, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		rv.subs = append(rv.subs, sub)
+		if p.pos >= len(p.pattern) {
+			return nil, fmt.Errorf("%w at offset %d", ErrUnterminatedAlternation,
+				start)
+		}
+		p.pos++
+		if p.pattern[p.pos-1] == '}' {
+			return rv, nil
+		}
+	}
+}
+
+// class parses a character class, starting with its [
+func (p *parser) class() (*node, error) {
+	start := p.pos
+	p.pos++
+	negate := false
+	if p.pos < len(p.pattern) &&
+		(p.pattern[p.pos] == '!' || p.pattern[p.pos] == '^') {
+		negate = true
+		p.pos++
+	}
+	var ranges []rune
+	first := true
+	for {
+		if p.pos >= len(p.pattern) {
+			return nil, fmt.Errorf("%w at offset %d", ErrUnterminatedClass, start)
+		}
+		if p.pattern[p.pos] == ']' && !first {
+			p.pos++
+			break
+		}
+		first = false
+		lo, err := p.classRune()
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if p.pos+1 < len(p.pattern) && p.pattern[p.pos] == '-' &&
+			p.pattern[p.pos+1] != ']' {
+			p.pos++
+			hi, err = p.classRune()
+			if err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("%w: %q-%q", ErrInvalidRange, lo, hi)
+			}
+		}
+		ranges = append(ranges, lo, hi)
+	}
+	ranges = normalizeRanges(ranges)
+	if negate {
+		ranges = negateRanges(ranges)
+	}
+	return &node{op: opClass, ranges: ranges}, nil
+}
+
+// classRune returns the next rune of a class, possibly escaped
+func (p *parser) classRune() (rune, error) {
+	if p.pattern[p.pos] == '\\' {
+		p.pos++
+		if p.pos >= len(p.pattern) {
+			return 0, ErrTrailingEscape
+		}
+	}
+	r, size := utf8.DecodeRuneInString(p.pattern[p.pos:])
+	p.pos += size
+	return r, nil
+}
+
+// normalizeRanges sorts the ranges, and merges those which overlap or are
+// adjacent
+func normalizeRanges(ranges []rune) []rune {
+	pairs := make([][2]rune, 0, len(ranges)/2)
+	for i := 0; i < len(ranges); i += 2 {
+		pairs = append(pairs, [2]rune{ranges[i], ranges[i+1]})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	rv := ranges[:0]
+	for _, pair := range pairs {
+		last := len(rv) - 1
+		if last > 0 && pair[0] <= rv[last]+1 {
+			if pair[1] > rv[last] {
+				rv[last] = pair[1]
+			}
+			continue
+		}
+		rv = append(rv, pair[0], pair[1])
+	}
+	return rv
+}
+
+// negateRanges returns the runes outside of the normalized ranges
+func negateRanges(ranges []rune) []rune {
+	var rv []rune
+	next := rune(0)
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > next {
+			rv = append(rv, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		rv = append(rv, next, unicode.MaxRune)
+	}
+	return rv
+}
@@ -0,0 +1,137 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wildcard compiles shell-style patterns directly into a
+// vellum.Automaton, without going through the regexp compiler:
+//
+//	a*       a, followed by any sequence of bytes, including none
+//	a?       a, followed by any single UTF-8 encoded rune
+//	[a-z]    any rune of the class, [!a-z] or [^a-z] any rune outside it
+//	{a,b*}   any of the comma separated patterns, which may be nested
+//	\*       the character *
+//
+// A key matches when the whole key matches the pattern.  Other bytes match
+// themselves.  Within a class, a ] first is part of the class, as is a -
+// first or last.
+package wildcard
+
+import (
+	"fmt"
+)
+
+// StateLimit is the default maximum number of states allowed, see
+// NewWithLimit
+const StateLimit = 10000
+
+// ErrTooManyStates is returned if the pattern requires a DFA with more
+// states than the limit.
+var ErrTooManyStates = fmt.Errorf("dfa contains too many states")
+
+// ErrUnterminatedClass is returned for a [ without its ]
+var ErrUnterminatedClass = fmt.Errorf("unterminated character class")
+
+// ErrUnterminatedAlternation is returned for a { without its }
+var ErrUnterminatedAlternation = fmt.Errorf("unterminated alternation")
+
+// ErrTrailingEscape is returned for a pattern ending with a \
+var ErrTrailingEscape = fmt.Errorf("trailing backslash")
+
+// ErrInvalidRange is returned for a class range ending before it starts
+var ErrInvalidRange = fmt.Errorf("invalid character class range")
+
+// Wildcard implements the vellum.Automaton interface for matching a
+// shell-style pattern.  It is immutable once compiled, so it is safe for
+// concurrent use.
+type Wildcard struct {
+	pattern string
+	// classes maps the bytes to the classes of bytes with the same
+	// transitions from every state
+	classes    [256]byte
+	numClasses int
+	// next holds the transitions of the states by class, numClasses per
+	// state, state 0 is dead
+	next   []uint32
+	match  []bool
+	always []bool
+}
+
+// New compiles the pattern into an automaton of at most StateLimit
+// states.
+func New(pattern string) (*Wildcard, error) {
+	return NewWithLimit(pattern, 0)
+}
+
+// NewWithLimit compiles the pattern into an automaton of at most maxStates
+// states (StateLimit if zero).  If it requires more, ErrTooManyStates is
+// returned.
+func NewWithLimit(pattern string, maxStates int) (*Wildcard, error) {
+	if maxStates <= 0 {
+		maxStates = StateLimit
+	}
+	p := &parser{pattern: pattern}
+	ast, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{}
+	start := c.compile(ast, c.add(nfaState{kind: stateMatch}))
+	rv := &Wildcard{pattern: pattern}
+	err = rv.build(c.states, start, maxStates)
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// String returns the pattern the automaton was compiled from.
+func (w *Wildcard) String() string {
+	return w.pattern
+}
+
+// NumStates returns the number of states of the automaton, the dead state
+// included.
+func (w *Wildcard) NumStates() int {
+	return len(w.match)
+}
+
+// Start returns the start state of this automaton.
+func (w *Wildcard) Start() int {
+	return 1
+}
+
+// IsMatch returns if the specified state is a matching state.
+func (w *Wildcard) IsMatch(s int) bool {
+	return s > 0 && s < len(w.match) && w.match[s]
+}
+
+// CanMatch returns if the specified state can ever transition to a matching
+// state.
+func (w *Wildcard) CanMatch(s int) bool {
+	return s > 0 && s < len(w.match)
+}
+
+// WillAlwaysMatch returns if the specified state will always end in a
+// matching state, such as after the prefix of a pattern ending with *.
+func (w *Wildcard) WillAlwaysMatch(s int) bool {
+	return s > 0 && s < len(w.always) && w.always[s]
+}
+
+// Accept returns the new state, resulting from the transition byte b
+// when currently in the state s.
+func (w *Wildcard) Accept(s int, b byte) int {
+	if s <= 0 || s >= len(w.match) {
+		return 0
+	}
+	return int(w.next[s*w.numClasses+int(w.classes[b])])
+}
@@ -0,0 +1,246 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wildcard
+
+import (
+	"bytes"
+	"errors"
+	stdregexp "regexp"
+	"testing"
+
+	"github.com/couchbase/vellum"
+	"github.com/couchbase/vellum/automatontest"
+	"github.com/couchbase/vellum/regexp"
+)
+
+func run(w *Wildcard, key string) bool {
+	s := w.Start()
+	for i := 0; i < len(key) && w.CanMatch(s); i++ {
+		s = w.Accept(s, key[i])
+	}
+	return w.IsMatch(s)
+}
+
+var keys = []string{"", "a", "b", "ab", "abc", "abd", "acd", "foo", "bar",
+	"foobar", "foo.txt", "bar.txt", "foo.go", "a*b", "a?", "x,y", "{}",
+	"é", "éé", "日本", "-", "]", "a]", "z", "fooo", "abcabc"}
+
+func TestWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		// equivalent is the equivalent regular expression
+		equivalent string
+	}{
+		{``, ``},
+		{`abc`, `abc`},
+		{`*`, `(?s).*`},
+		{`a*`, `(?s)a.*`},
+		{`*.txt`, `(?s).*\.txt`},
+		{`a*b*c`, `(?s)a.*b.*c`},
+		{`***`, `(?s).*`},
+		{`?`, `.`},
+		{`??`, `..`},
+		{`a?d`, `a.d`},
+		{`[ab]`, `[ab]`},
+		{`[a-c]?`, `[a-c].`},
+		{`[!a-c]*`, `(?s)[^a-c].*`},
+		{`[^a]`, `[^a]`},
+		{`[]a]`, `[\]a]`},
+		{`[a-]`, `[a\-]`},
+		{`[\]]`, `\]`},
+		{`[é-ü]`, `[é-ü]`},
+		{`{foo,bar}`, `foo|bar`},
+		{`{foo,bar}.{txt,go}`, `(foo|bar)\.(txt|go)`},
+		{`{a,b{c,d}}*`, `(?s)(a|b(c|d)).*`},
+		{`{,a}`, `|a`},
+		{`{}`, ``},
+		{`x,y`, `x,y`},
+		{`a}`, `a\}`},
+		{`a\*b`, `a\*b`},
+		{`a\?`, `a\?`},
+		{`\{\}`, `\{\}`},
+		{`日?`, `日.`},
+		{`*o`, `(?s).*o`},
+	}
+	for _, test := range tests {
+		w, err := New(test.pattern)
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", test.pattern, err)
+		}
+		expected := stdregexp.MustCompile(`\A(?:` + test.equivalent + `)\z`)
+		for _, key := range keys {
+			want := expected.MatchString(key)
+			if run(w, key) != want {
+				t.Errorf("%s: expected match %q %t", test.pattern, key, want)
+			}
+		}
+		var seeds [][]byte
+		for _, key := range keys {
+			seeds = append(seeds, []byte(key))
+		}
+		err = automatontest.Check(w, &automatontest.Opts{
+			Alphabet: []byte("abcdefo.txg*?"),
+			Seeds:    seeds,
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+		}
+	}
+}
+
+func TestWildcardWillAlwaysMatch(t *testing.T) {
+	w, err := New(`foo*`)
+	if err != nil {
+		t.Fatalf("error compiling: %v", err)
+	}
+	s := w.Start()
+	for _, b := range []byte("fo") {
+		if w.WillAlwaysMatch(s) {
+			t.Errorf("expected no always match before the prefix")
+		}
+		s = w.Accept(s, b)
+	}
+	s = w.Accept(s, 'o')
+	if !w.WillAlwaysMatch(s) {
+		t.Errorf("expected always match after the prefix")
+	}
+
+	// nothing matches a class of every rune but for its complement
+	w, err = New("[!\x00-\U0010ffff]")
+	if err != nil {
+		t.Fatalf("error compiling: %v", err)
+	}
+	if w.CanMatch(w.Start()) {
+		t.Errorf("expected nothing to match the empty class")
+	}
+}
+
+func TestWildcardInvalidUTF8(t *testing.T) {
+	// * matches any bytes, ? and classes only UTF-8 encoded runes
+	for _, test := range []struct {
+		pattern string
+		want    bool
+	}{
+		{`*`, true},
+		{`a*`, true},
+		{`a?`, false},
+		{`a[!b]`, false},
+		{`a[!b]*`, false},
+	} {
+		w, err := New(test.pattern)
+		if err != nil {
+			t.Fatalf("%s: error compiling: %v", test.pattern, err)
+		}
+		if run(w, "a\xff") != test.want {
+			t.Errorf("%s: expected match %t", test.pattern, test.want)
+		}
+	}
+}
+
+func TestWildcardErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     error
+	}{
+		{`[ab`, ErrUnterminatedClass},
+		{`[]`, ErrUnterminatedClass},
+		{`[!`, ErrUnterminatedClass},
+		{`{a,b`, ErrUnterminatedAlternation},
+		{`{a,{b}`, ErrUnterminatedAlternation},
+		{`ab\`, ErrTrailingEscape},
+		{`[a\`, ErrTrailingEscape},
+		{`[z-a]`, ErrInvalidRange},
+	}
+	for _, test := range tests {
+		_, err := New(test.pattern)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.pattern, test.err, err)
+		}
+	}
+
+	_, err := NewWithLimit(`*a???????`, 16)
+	if err != ErrTooManyStates {
+		t.Errorf("expected too many states, got %v", err)
+	}
+}
+
+func TestWildcardSearch(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := vellum.New(&buf, nil)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	words := []string{"bar.go", "bar.txt", "baz.txt", "foo.go", "foo.txt",
+		"foobar.txt", "readme"}
+	for i, word := range words {
+		err = b.Insert([]byte(word), uint64(i))
+		if err != nil {
+			t.Fatalf("error inserting: %v", err)
+		}
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing builder: %v", err)
+	}
+	fst, err := vellum.Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	w, err := New(`{foo,ba?}.txt`)
+	if err != nil {
+		t.Fatalf("error compiling: %v", err)
+	}
+	var got []string
+	itr, err := fst.Search(w, nil, nil)
+	for err == nil {
+		key, _ := itr.Current()
+		got = append(got, string(key))
+		err = itr.Next()
+	}
+	if err != vellum.ErrIteratorDone {
+		t.Fatalf("error iterating: %v", err)
+	}
+	want := []string{"bar.txt", "baz.txt", "foo.txt"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestWildcardSmallerThanRegexp(t *testing.T) {
+	w, err := New(`*foo*bar?`)
+	if err != nil {
+		t.Fatalf("error compiling: %v", err)
+	}
+	r, err := regexp.New(`.*foo.*bar.`)
+	if err != nil {
+		t.Fatalf("error compiling regexp: %v", err)
+	}
+	report := r.Report(nil)
+	if w.NumStates() > report.States {
+		t.Errorf("expected at most the %d states of the regexp, got %d",
+			report.States, w.NumStates())
+	}
+}
+
+func BenchmarkNewWildcard(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, _ = New(`*foo*{bar,baz}?.[a-z]*`)
+	}
+}
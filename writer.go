@@ -24,6 +24,8 @@ import (
 type writer struct {
 	w       *bufio.Writer
 	counter int
+	// sum, if set, checksums the data written, see checksums.go
+	sum *blockChecksummer
 }
 
 func newWriter(w io.Writer) *writer {
@@ -43,12 +45,18 @@ func (w *writer) WriteByte(c byte) error {
 		return err
 	}
 	w.counter++
+	if w.sum != nil {
+		w.sum.writeByte(c)
+	}
 	return nil
 }
 
 func (w *writer) Write(p []byte) (int, error) {
 	n, err := w.w.Write(p)
 	w.counter += n
+	if w.sum != nil {
+		w.sum.write(p[:n])
+	}
 	return n, err
 }
 
@@ -56,6 +64,12 @@ func (w *writer) Flush() error {
 	return w.w.Flush()
 }
 
+// Buffered returns the number of bytes not yet written to the underlying
+// Writer
+func (w *writer) Buffered() int {
+	return w.w.Buffered()
+}
+
 func (w *writer) WritePackedUintIn(v uint64, n int) error {
 	for shift := uint(0); shift < uint(n*8); shift += 8 {
 		err := w.WriteByte(byte(v >> shift))
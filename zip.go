@@ -0,0 +1,105 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import "bytes"
+
+// ZipFunc is invoked by Zip for each key in either FST, with its value in
+// each, nil if the FST doesn't contain the key.  The key and values are
+// only valid for the duration of the call.  Returning false stops Zip.
+type ZipFunc func(key []byte, va, vb *uint64) bool
+
+// Zip walks the keys of both FSTs in lockstep, in lexicographic order,
+// invoking emit once for every key in either of them, with the values it
+// has in each.  This allows two versions of a dictionary to be reconciled,
+// or the values migrated from one to the other to be audited.
+func Zip(a, b *FST, emit ZipFunc) error {
+	za, err := newZipSide(a)
+	if err != nil {
+		return err
+	}
+	zb, err := newZipSide(b)
+	if err != nil {
+		return err
+	}
+	for za.ok || zb.ok {
+		cmp := 0
+		if !zb.ok {
+			cmp = -1
+		} else if !za.ok {
+			cmp = 1
+		} else {
+			cmp = bytes.Compare(za.key, zb.key)
+		}
+		var key []byte
+		var va, vb *uint64
+		if cmp <= 0 {
+			key, va = za.key, &za.val
+		}
+		if cmp >= 0 {
+			key, vb = zb.key, &zb.val
+		}
+		if !emit(key, va, vb) {
+			return nil
+		}
+		if cmp <= 0 {
+			err = za.next()
+			if err != nil {
+				return err
+			}
+		}
+		if cmp >= 0 {
+			err = zb.next()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// zipSide is the iterator over one of the FSTs of a Zip
+type zipSide struct {
+	itr *FSTIterator
+	key []byte
+	val uint64
+	ok  bool
+}
+
+func newZipSide(f *FST) (*zipSide, error) {
+	itr, err := f.Iterator(nil, nil)
+	if err == ErrIteratorDone {
+		return &zipSide{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rv := &zipSide{itr: itr, ok: true}
+	rv.key, rv.val = itr.Current()
+	return rv, nil
+}
+
+func (z *zipSide) next() error {
+	err := z.itr.Next()
+	if err == ErrIteratorDone {
+		z.ok = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	z.key, z.val = z.itr.Current()
+	return nil
+}
@@ -0,0 +1,118 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vellum
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func buildKVs(t *testing.T, kvs ...KV) *FST {
+	var buf bytes.Buffer
+	b, err := New(&buf)
+	if err != nil {
+		t.Fatalf("error creating builder: %v", err)
+	}
+	err = insertKVs(kvs...)(b)
+	if err != nil {
+		t.Fatalf("error inserting: %v", err)
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	fst, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+	return fst
+}
+
+func TestZip(t *testing.T) {
+	a := buildKVs(t, KV{"", 7}, KV{"bar", 1}, KV{"baz", 2}, KV{"foo", 3})
+	b := buildKVs(t, KV{"ba", 5}, KV{"baz", 6}, KV{"foo", 3}, KV{"fox", 4})
+	empty := buildKVs(t)
+
+	format := func(v *uint64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprint(*v)
+	}
+	zip := func(a, b *FST, limit int) []string {
+		var rv []string
+		err := Zip(a, b, func(key []byte, va, vb *uint64) bool {
+			rv = append(rv, fmt.Sprintf("%s:%s:%s", key, format(va), format(vb)))
+			return len(rv) < limit
+		})
+		if err != nil {
+			t.Fatalf("error zipping: %v", err)
+		}
+		return rv
+	}
+
+	tests := []struct {
+		name  string
+		a, b  *FST
+		limit int
+		want  []string
+	}{
+		{
+			name:  "both",
+			a:     a,
+			b:     b,
+			limit: 100,
+			want: []string{":7:-", "ba:-:5", "bar:1:-", "baz:2:6", "foo:3:3",
+				"fox:-:4"},
+		},
+		{
+			name:  "stopped",
+			a:     a,
+			b:     b,
+			limit: 3,
+			want:  []string{":7:-", "ba:-:5", "bar:1:-"},
+		},
+		{
+			name:  "empty a",
+			a:     empty,
+			b:     b,
+			limit: 100,
+			want:  []string{"ba:-:5", "baz:-:6", "foo:-:3", "fox:-:4"},
+		},
+		{
+			name:  "empty b",
+			a:     a,
+			b:     empty,
+			limit: 100,
+			want:  []string{":7:-", "bar:1:-", "baz:2:-", "foo:3:-"},
+		},
+		{
+			name:  "empty",
+			a:     empty,
+			b:     empty,
+			limit: 100,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := zip(test.a, test.b, test.limit)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}